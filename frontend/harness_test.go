@@ -0,0 +1,226 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/kevincobain2000/gol/pkg"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// The integration tests start gol from a command line, set up the way main does, and assert the
+// JSON of its API over HTTP. A new endpoint gets a case here next to the unit tests of its handler.
+
+// startGol sets gol up from args and serves it on an ephemeral port, it returns the base URL.
+// gol keeps its state in globals, so a test binary starts it once.
+func startGol(t *testing.T, args ...string) string {
+	t.Helper()
+	server, err := setup(parseFlags(args), false)
+	if err != nil {
+		t.Fatalf("setting gol up: %v", err)
+	}
+	server.Echo.HideBanner = true
+	server.Echo.HidePort = true
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	go server.Serve(listener) //nolint: errcheck
+	t.Cleanup(func() {
+		server.Shutdown(context.Background()) //nolint: errcheck
+		pkg.Cleanup()
+	})
+	return "http://" + listener.Addr().String() + f.baseURL
+}
+
+// requestJSON sends the request, decodes the JSON answer into v and returns the status code
+func requestJSON(t *testing.T, method string, url string, token string, v interface{}) int {
+	t.Helper()
+	req, err := http.NewRequest(method, url, nil)
+	if err != nil {
+		t.Fatalf("new request: %v", err)
+	}
+	if token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	}
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", method, url, err)
+	}
+	defer res.Body.Close()
+	body, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("reading %s: %v", url, err)
+	}
+	if err := json.Unmarshal(body, v); err != nil {
+		t.Fatalf("decoding %s: %v: %s", url, err, body)
+	}
+	return res.StatusCode
+}
+
+func getJSON(t *testing.T, url string, v interface{}) int {
+	t.Helper()
+	return requestJSON(t, http.MethodGet, url, "", v)
+}
+
+// newSSHKey writes a new private key to dir, it returns the path of the key and its public key
+func newSSHKey(t *testing.T, dir string) (string, ssh.PublicKey) {
+	t.Helper()
+	public, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating key: %v", err)
+	}
+	publicKey, err := ssh.NewPublicKey(public)
+	if err != nil {
+		t.Fatalf("public key: %v", err)
+	}
+	block, err := ssh.MarshalPrivateKey(private, "")
+	if err != nil {
+		t.Fatalf("marshaling key: %v", err)
+	}
+	keyPath := filepath.Join(dir, "id_ed25519")
+	if err := os.WriteFile(keyPath, pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatalf("writing key: %v", err)
+	}
+	return keyPath, publicKey
+}
+
+// startSSHServer serves SSH on an ephemeral port and returns its address and host key. It accepts the
+// authorized key only and answers the commands gol runs on the local file system.
+func startSSHServer(t *testing.T, authorized ssh.PublicKey) (string, ssh.PublicKey) {
+	t.Helper()
+	_, hostKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatalf("generating host key: %v", err)
+	}
+	hostSigner, err := ssh.NewSignerFromKey(hostKey)
+	if err != nil {
+		t.Fatalf("host key: %v", err)
+	}
+	config := &ssh.ServerConfig{
+		PublicKeyCallback: func(_ ssh.ConnMetadata, key ssh.PublicKey) (*ssh.Permissions, error) {
+			if bytes.Equal(key.Marshal(), authorized.Marshal()) {
+				return nil, nil
+			}
+			return nil, errors.New("unauthorized key")
+		},
+	}
+	config.AddHostKey(hostSigner)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("listening: %v", err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go serveSSH(conn, config)
+		}
+	}()
+	return listener.Addr().String(), hostSigner.PublicKey()
+}
+
+// writeKnownHosts writes a known_hosts file to dir with the host keys by address, it returns its path
+func writeKnownHosts(t *testing.T, dir string, hostKeys map[string]ssh.PublicKey) string {
+	t.Helper()
+	lines := ""
+	for address, key := range hostKeys {
+		lines += knownhosts.Line([]string{knownhosts.Normalize(address)}, key) + "\n"
+	}
+	knownHostsPath := filepath.Join(dir, "known_hosts")
+	if err := os.WriteFile(knownHostsPath, []byte(lines), 0600); err != nil {
+		t.Fatalf("writing known hosts: %v", err)
+	}
+	return knownHostsPath
+}
+
+func serveSSH(conn net.Conn, config *ssh.ServerConfig) {
+	_, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		conn.Close()
+		return
+	}
+	// answers the keepalives of the reused clients
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.UnknownChannelType, "sessions only") //nolint: errcheck
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go serveSession(channel, requests)
+	}
+}
+
+// serveSession runs the exec request of a session, other requests are declined
+func serveSession(channel ssh.Channel, requests <-chan *ssh.Request) {
+	for req := range requests {
+		var exec struct{ Command string }
+		if req.Type != "exec" || ssh.Unmarshal(req.Payload, &exec) != nil {
+			req.Reply(false, nil) //nolint: errcheck
+			continue
+		}
+		req.Reply(true, nil) //nolint: errcheck
+		output, err := runCommand(exec.Command)
+		status := struct{ Status uint32 }{}
+		if err != nil {
+			fmt.Fprintln(channel.Stderr(), err)
+			status.Status = 1
+		}
+		channel.Write(output)                                          //nolint: errcheck
+		channel.SendRequest("exit-status", false, ssh.Marshal(status)) //nolint: errcheck
+		channel.Close()
+	}
+}
+
+// runCommand runs ls <pattern> and cat <file>, the commands gol lists and reads SSH paths with,
+// and true && stat -c %s <file>, the deep health check
+func runCommand(command string) ([]byte, error) {
+	name, arg, _ := strings.Cut(command, " ")
+	switch name {
+	case "true":
+		if next, ok := strings.CutPrefix(arg, "&& "); ok {
+			return runCommand(next)
+		}
+		return nil, nil
+	case "stat":
+		info, err := os.Stat(strings.TrimPrefix(arg, "-c %s "))
+		if err != nil {
+			return nil, err
+		}
+		return []byte(fmt.Sprintln(info.Size())), nil
+	case "ls":
+		matches, err := filepath.Glob(arg)
+		if err == nil && len(matches) == 0 {
+			err = fmt.Errorf("ls: cannot access '%s': No such file or directory", arg)
+		}
+		if err != nil {
+			return nil, err
+		}
+		return []byte(strings.Join(matches, "\n") + "\n"), nil
+	case "cat":
+		return os.ReadFile(arg)
+	}
+	return nil, fmt.Errorf("%s: command not found", name)
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kevincobain2000/gol/pkg"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestIntegration(t *testing.T) {
+	dir := t.TempDir()
+	testdata, err := filepath.Abs("testdata")
+	assert.NoError(t, err)
+	appLog := filepath.Join(testdata, "app.log")
+	appLogGz := filepath.Join(testdata, "app.log.1.gz")
+	webLog := filepath.Join(testdata, "remote", "web.log")
+	growingLog := filepath.Join(dir, "growing.log")
+	assert.NoError(t, os.WriteFile(growingLog, []byte("2024-06-01 12:00:00 INFO worker started\n"), 0600))
+
+	keyPath, publicKey := newSSHKey(t, dir)
+	sshAddr, hostKey := startSSHServer(t, publicKey)
+	_, otherKey := newSSHKey(t, t.TempDir())
+	// the SSH configs are looked up by host, the server denying the key is addressed as localhost
+	deniedAddr, deniedHostKey := startSSHServer(t, otherKey)
+	_, deniedPort, err := net.SplitHostPort(deniedAddr)
+	assert.NoError(t, err)
+	knownHosts := writeKnownHosts(t, dir, map[string]ssh.PublicKey{
+		sshAddr: hostKey,
+		net.JoinHostPort("localhost", deniedPort): deniedHostKey,
+	})
+
+	baseURL := startGol(t,
+		"-open=false",
+		"-ui=false",
+		"-data-dir", filepath.Join(dir, "data"),
+		"-min-free-disk", "0",
+		"-admin-token", "s3cret",
+		"-f", filepath.Join(testdata, "app.log*"),
+		"-f", growingLog,
+		"-s", fmt.Sprintf("gol@%s %s private_key=%s known_hosts=%s", sshAddr, filepath.Join(testdata, "remote", "*.log"), keyPath, knownHosts),
+		"-s", fmt.Sprintf("gol@localhost:%s %s private_key=%s known_hosts=%s", deniedPort, filepath.Join(testdata, "remote", "*.log"), keyPath, knownHosts),
+	)
+
+	read := func(t *testing.T, params url.Values) (pkg.APIResponse, int) {
+		t.Helper()
+		var res pkg.APIResponse
+		status := getJSON(t, baseURL+"api?"+params.Encode(), &res)
+		return res, status
+	}
+
+	t.Run("lists the files of every source", func(t *testing.T) {
+		var res pkg.FileListResponse
+		assert.Equal(t, http.StatusOK, getJSON(t, baseURL+"api/files", &res))
+		files := map[string]pkg.FileInfo{}
+		for _, fileInfo := range res.FilePaths {
+			files[fileInfo.Type+" "+fileInfo.Host+" "+fileInfo.FilePath] = fileInfo
+		}
+		assert.Len(t, files, 4)
+		assert.Equal(t, 5, files[pkg.TypeFile+"  "+appLog].LinesCount)
+		assert.Equal(t, 3, files[pkg.TypeFile+"  "+appLogGz].LinesCount)
+		assert.Equal(t, 4, files[pkg.TypeSSH+" 127.0.0.1 "+webLog].LinesCount)
+		assert.NotEmpty(t, files[pkg.TypeFile+"  "+growingLog].ID)
+	})
+
+	t.Run("reports the SSH source denying the key", func(t *testing.T) {
+		var res pkg.SourcesResponse
+		assert.Equal(t, http.StatusOK, getJSON(t, baseURL+"api/sources", &res))
+		files := map[string]int{}
+		for _, status := range res.Sources {
+			files[status.Type+" "+status.Host] += status.Files
+		}
+		assert.Equal(t, 1, files[pkg.TypeSSH+" 127.0.0.1"])
+		assert.Equal(t, 0, files[pkg.TypeSSH+" localhost"])
+	})
+
+	t.Run("reads a file", func(t *testing.T) {
+		res, status := read(t, url.Values{"file_path": {appLog}, "type": {pkg.TypeFile}})
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, 5, res.Result.Total)
+		assert.Len(t, res.Result.Lines, 5)
+		assert.Len(t, res.FilePaths, 4)
+	})
+
+	t.Run("searches a gzip file", func(t *testing.T) {
+		res, status := read(t, url.Values{"file_path": {appLogGz}, "type": {pkg.TypeFile}, "query": {"ERROR"}})
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, 1, res.Result.Total)
+		if assert.Len(t, res.Result.Lines, 1) {
+			assert.Contains(t, res.Result.Lines[0].Content, "gateway timeout")
+		}
+	})
+
+	t.Run("searches a file over SSH", func(t *testing.T) {
+		res, status := read(t, url.Values{"file_path": {webLog}, "type": {pkg.TypeSSH}, "host": {"127.0.0.1"}, "query": {"ERROR"}})
+		assert.Equal(t, http.StatusOK, status)
+		assert.Equal(t, 1, res.Result.Total)
+		if assert.Len(t, res.Result.Lines, 1) {
+			assert.Contains(t, res.Result.Lines[0].Content, "upstream timed out")
+		}
+	})
+
+	t.Run("reads a file growing in the background", func(t *testing.T) {
+		ctx, cancel := context.WithCancel(context.Background())
+		defer cancel()
+		go func() {
+			file, err := os.OpenFile(growingLog, os.O_APPEND|os.O_WRONLY, 0600)
+			if err != nil {
+				return
+			}
+			defer file.Close()
+			for i := 1; ctx.Err() == nil; i++ {
+				fmt.Fprintf(file, "2024-06-01 12:00:%02d INFO job %d done\n", i%60, i)
+				time.Sleep(10 * time.Millisecond)
+			}
+		}()
+
+		params := url.Values{"file_path": {growingLog}, "type": {pkg.TypeFile}}
+		total := 0
+		for deadline := time.Now().Add(5 * time.Second); total < 5 && time.Now().Before(deadline); {
+			res, status := read(t, params)
+			assert.Equal(t, http.StatusOK, status)
+			assert.GreaterOrEqual(t, res.Result.Total, total)
+			total = res.Result.Total
+			time.Sleep(20 * time.Millisecond)
+		}
+		assert.GreaterOrEqual(t, total, 5)
+	})
+
+	t.Run("resumes a download", func(t *testing.T) {
+		content, err := os.ReadFile(appLog)
+		assert.NoError(t, err)
+		req, err := http.NewRequest(http.MethodGet, baseURL+"api/download?"+url.Values{"file_path": {appLog}, "type": {pkg.TypeFile}}.Encode(), nil)
+		assert.NoError(t, err)
+		req.Header.Set("Range", "bytes=100-")
+		res, err := http.DefaultClient.Do(req)
+		if !assert.NoError(t, err) {
+			return
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		assert.NoError(t, err)
+		assert.Equal(t, http.StatusPartialContent, res.StatusCode)
+		assert.Equal(t, string(content[100:]), string(body))
+	})
+
+	t.Run("rejects files not listed", func(t *testing.T) {
+		var res struct {
+			Error pkg.FileAccessError `json:"error"`
+		}
+		status := getJSON(t, baseURL+"api?"+url.Values{"file_path": {"/etc/passwd"}, "type": {pkg.TypeFile}}.Encode(), &res)
+		assert.Equal(t, http.StatusForbidden, status)
+		assert.Equal(t, pkg.ErrorCodeFileNotAllowed, res.Error.Code)
+	})
+
+	t.Run("checks every source type", func(t *testing.T) {
+		var res pkg.DeepHealthResponse
+		assert.Equal(t, http.StatusMultiStatus, requestJSON(t, http.MethodGet, baseURL+"api/healthz/deep", "s3cret", &res))
+		assert.Equal(t, pkg.HealthPartial, res.Status)
+		checks := map[string]pkg.HealthCheck{}
+		for _, check := range res.Checks {
+			checks[check.Type+" "+check.Host] = check
+		}
+		assert.Len(t, checks, 3)
+		assert.Equal(t, pkg.HealthPass, checks[pkg.TypeFile+" "].Status)
+		assert.Equal(t, pkg.HealthPass, checks[pkg.TypeSSH+" 127.0.0.1"].Status)
+		assert.Equal(t, webLog, checks[pkg.TypeSSH+" 127.0.0.1"].Target)
+		assert.Equal(t, pkg.HealthFail, checks[pkg.TypeSSH+" localhost"].Status)
+	})
+
+	t.Run("rejects admin requests without the token", func(t *testing.T) {
+		for _, token := range []string{"", "wrong"} {
+			var res pkg.HTTPErrorResponse
+			assert.Equal(t, http.StatusUnauthorized, requestJSON(t, http.MethodPost, baseURL+"api/admin/reload", token, &res))
+			assert.Equal(t, pkg.ErrorCodeUnauthorized, res.Error)
+		}
+		// without -config, the sources of the flags are rescanned
+		var res pkg.ConfigReload
+		assert.Equal(t, http.StatusOK, requestJSON(t, http.MethodPost, baseURL+"api/admin/reload", "s3cret", &res))
+		assert.Empty(t, res.FilesRemoved)
+	})
+}
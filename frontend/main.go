@@ -1,76 +1,519 @@
 package main
 
 import (
-	"embed"
+	"context"
 	"flag"
 	"fmt"
 	"log/slog"
+	"net"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
 	"strings"
+	"syscall"
+	"time"
 
 	"github.com/kevincobain2000/gol/pkg"
+	"gopkg.in/yaml.v3"
 )
 
-//go:embed all:dist/*
-var publicDir embed.FS
-
 type Flags struct {
-	host        string
-	port        int64
-	cors        int64
-	every       int64
-	limit       int
-	baseURL     string
-	filePaths   pkg.SliceFlags
-	sshPaths    pkg.SliceFlags
-	dockerPaths pkg.SliceFlags
-	access      bool
-	open        bool
-	version     bool
+	host             string
+	port             int64
+	cors             int64
+	every            pkg.EveryFlag
+	fsnotify         bool
+	limit            int
+	stdinBuffer      int
+	stdinName        string
+	baseURL          string
+	dataDir          string
+	indexDir         string
+	noPersistIndex   bool
+	config           string
+	adminToken       string
+	token            string
+	compression      string
+	gzipLevel        int
+	brLevel          int
+	maxLineLength    int
+	maxPerPage       int
+	maxMergeFiles    int
+	maxScans         int
+	maxReads         int
+	maxReadsPerHost  int
+	maxTails         int
+	maxReadWait      time.Duration
+	rateLimit        float64
+	rateBurst        int
+	sshWorkers       int
+	sshDeadline      time.Duration
+	sshIdleTimeout   time.Duration
+	sshMaxSessions   int
+	filePaths        pkg.SliceFlags
+	sshPaths         pkg.SliceFlags
+	dockerPaths      pkg.SliceFlags
+	excludes         pkg.SliceFlags
+	remotePaths      pkg.SliceFlags
+	httpHeaders      pkg.SliceFlags
+	httpMaxSize      pkg.ByteSizeFlag
+	httpMaxRedirects int
+	k8sPaths         pkg.SliceFlags
+	s3Paths          pkg.SliceFlags
+	s3Endpoint       string
+	journalUnits     pkg.SliceFlags
+	rotationSuffixes pkg.SliceFlags
+	rotationGroups   bool
+	access           bool
+	readOnly         bool
+	open             bool
+	version          bool
+	check            bool
+	minFreeDisk      pkg.ByteSizeFlag
+	maxBufferMemory  pkg.ByteSizeFlag
+	maxResponseBytes pkg.ByteSizeFlag
+	charset          string
+	ui               bool
+	agent            bool
+	metrics          bool
+	requireAll       bool
+	internalLogs     int
+	patternLimits    pkg.PatternLimits
+	reportTo         string
+	reportSecret     string
+	reportEvery      time.Duration
+	exportRetention  time.Duration
+	shareTTL         time.Duration
+	shutdownTimeout  time.Duration
+	cert             string
+	key              string
+	tlsAuto          bool
+	redirectHTTP     int64
+	authUsers        pkg.SliceFlags
+	authTokens       pkg.SliceFlags
+	authFile         string
+	exportMaxLines   int
+	alerts           pkg.SliceFlags
+	alertURL         string
+	alertContext     int
+	alertSnippets    int
 }
 
 var f Flags
 
+var config *pkg.Config
+var configReloader *pkg.ConfigReloader
+
 var version = "dev"
 
 func main() {
-	pkg.SetupLoggingStdout(slog.LevelInfo)
-	flags()
-
-	if pkg.IsInputFromPipe() {
-		pkg.HandleStdinPipe()
+	flagSet := parseFlags(os.Args[1:])
+	wantsVersion()
+	pkg.SetupLoggingStdout(slog.LevelInfo, f.internalLogs)
+	if f.check {
+		os.Exit(check())
+	}
+	if f.config == "check" && flagSet.NArg() > 0 {
+		os.Exit(checkConfig(flagSet))
+	}
+	server, err := setup(flagSet, pkg.IsInputFromPipe())
+	if err != nil {
+		slog.Error("starting gol", "error", err)
+		return
 	}
-	setFilePaths()
 
-	go pkg.WatchFilePaths(f.every, f.filePaths, f.sshPaths, f.dockerPaths, f.limit)
+	if f.fsnotify {
+		if pkg.GlobalPathWatcher, err = pkg.NewPathWatcher(); err != nil {
+			slog.Warn("watching file paths, polling every -every instead", "error", err)
+		}
+	}
+	// the first SIGINT or SIGTERM shuts down gracefully, a second one kills gol
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	context.AfterFunc(ctx, stop)
+	go pkg.WatchFilePathsContext(ctx, time.Duration(f.every), f.filePaths, f.sshPaths, f.dockerPaths, f.limit)
+	go pkg.WatchDiskUsage(time.Duration(f.every))
+	go pkg.GlobalShares.Run(pkg.SharePruneEvery)
+	if pkg.GlobalSelfReporter != nil {
+		go pkg.GlobalSelfReporter.Run(f.reportEvery)
+	}
+	if pkg.GlobalAlerts != nil {
+		go pkg.GlobalAlerts.Run()
+	}
 	slog.Info("Flags", "host", f.host, "port", f.port, "baseURL", f.baseURL, "open", f.open, "cors", f.cors, "access", f.access)
 
-	if f.open {
-		pkg.OpenBrowser(fmt.Sprintf("http://%s:%d%s", f.host, f.port, f.baseURL))
-	}
 	defer pkg.Cleanup()
-	pkg.HandleCltrC(pkg.Cleanup)
+	pkg.HandleSIGHUP(func() {
+		if _, err := configReloader.Reload(); err != nil {
+			slog.Error("reloading config", "config", err)
+		}
+		if err := server.ReloadCertificate(); err != nil {
+			slog.Error("reloading certificate, keeping the previous one", "error", err)
+		}
+		if err := server.ReloadAuth(); err != nil {
+			slog.Error("reloading auth file, keeping the previous credentials", "error", err)
+		}
+	})
+
+	if err := server.Run(ctx); err != nil {
+		slog.Error("serving gol", "error", err)
+	}
+}
+
+// setup applies the parsed flags, scans the file list once and returns the server to start.
+// Watching the file list and handling signals is left to main, stdin is copied when it is a pipe.
+func setup(flagSet *flag.FlagSet, stdin bool) (*pkg.Server, error) {
+	loadConfig(flagSet)
+	validateFlags()
+	slog.Info("Config", "effective", effectiveConfig().Redacted().OneLine())
+
+	pkg.GlobalDataDir = f.dataDir
+	indexDir := f.indexDir
+	if indexDir == "" {
+		indexDir = f.dataDir
+	}
+	if indexDir != "" {
+		pkg.GlobalLineIndexes = pkg.NewLineIndexes(indexDir, !f.noPersistIndex)
+	}
+	pkg.GlobalMinFreeDisk = int64(f.minFreeDisk)
+	pkg.GlobalMemory.SetCeiling(int64(f.maxBufferMemory))
+	pkg.GlobalMaxLineLength = f.maxLineLength
+	pkg.GlobalMaxPerPage = f.maxPerPage
+	pkg.GlobalMaxResponseBytes = int64(f.maxResponseBytes)
+	pkg.GlobalCharset = f.charset
+	pkg.GlobalMaxMergeFiles = f.maxMergeFiles
+	pkg.GlobalExportMaxLines = f.exportMaxLines
+	pkg.GlobalPatternLimits = f.patternLimits
+	pkg.GlobalExcludes = f.excludes
+	pkg.GlobalReadLimiter = pkg.NewLimiter(f.maxScans, f.maxReads, f.maxReadsPerHost, f.maxTails, f.maxReadWait)
+	pkg.GlobalSSHDiscovery = pkg.NewSSHDiscovery(f.sshWorkers, f.sshDeadline)
+	pkg.GlobalSSHPool = pkg.NewSSHPool(f.sshIdleTimeout, f.sshMaxSessions)
+	pkg.GlobalExports = pkg.NewExports(f.exportRetention)
+	pkg.GlobalShares = pkg.NewShares(pkg.GlobalStore, f.shareTTL)
+	if f.rotationGroups {
+		patterns := []string(f.rotationSuffixes)
+		if len(patterns) == 0 {
+			patterns = pkg.DefaultRotationSuffixes
+		}
+		suffixes, err := pkg.NewRotationSuffixes(patterns)
+		if err != nil {
+			return nil, fmt.Errorf("parsing rotation suffixes: %w", err)
+		}
+		pkg.GlobalRotationSuffixes = suffixes
+	}
+	setRemoteClients()
+	setHTTPSources()
+	setK8sSource()
+	setS3Source()
+	if len(f.journalUnits) > 0 {
+		pkg.GlobalJournalSource = pkg.NewJournalSource(f.journalUnits)
+	}
+	setSelfReporter()
+	setAlerts()
+	store, err := pkg.OpenFileStore(pkg.StoreFilePath(f.dataDir))
+	if err != nil {
+		return nil, fmt.Errorf("opening store: %w", err)
+	}
+	pkg.SetGlobalStore(store)
+	pkg.GlobalFileStatsCache.Load(pkg.StatsCacheFilePath())
+	start := "cold"
+	if pkg.GlobalFileStatsCache.Len() > 0 {
+		start = "warm"
+	}
 
-	err := pkg.NewEcho(func(o *pkg.EchoOptions) error {
+	if stdin {
+		pkg.HandleStdinPipe(f.stdinBuffer, f.stdinName)
+	}
+	pkg.GlobalFIFOs = pkg.NewFIFOs(f.stdinBuffer)
+	startedAt := time.Now()
+	setFilePaths(flagSet)
+	slog.Info("Files scanned", "start", start, "files", len(pkg.GlobalFileRegistry.Snapshot()), "took", time.Since(startedAt))
+	pkg.SaveGlobalFileStatsCache()
+
+	// the sources of the flags are rescanned on reload, those of the config file read again
+	flagFilePaths, flagSSHPaths, flagDockerPaths := []string(f.filePaths), []string(f.sshPaths), []string(f.dockerPaths)
+	if config != nil {
+		flagFilePaths = pkg.StringsMissingFrom(f.filePaths, config.FilePatterns())
+		flagSSHPaths = pkg.StringsMissingFrom(f.sshPaths, config.SSHPathStrings())
+		flagDockerPaths = pkg.StringsMissingFrom(f.dockerPaths, config.DockerPaths)
+	}
+	configReloader = pkg.NewConfigReloader(f.config, config, flagFilePaths, flagSSHPaths, flagDockerPaths)
+	return pkg.NewServer(func(o *pkg.EchoOptions) error {
 		o.Host = f.host
 		o.Port = f.port
 		o.Cors = f.cors
 		o.Access = f.access
 		o.BaseURL = f.baseURL
-		o.PublicDir = &publicDir
+		if f.ui {
+			o.PublicDir = publicDir
+		}
+		o.Compression = f.compression
+		o.GzipLevel = f.gzipLevel
+		o.BrotliLevel = f.brLevel
+		o.ReadOnly = f.readOnly
+		o.Metrics = f.metrics
+		o.RequireAllSources = f.requireAll
+		o.ShutdownTimeout = f.shutdownTimeout
+		o.ListenCallback = listening
+		o.CertFile = f.cert
+		o.KeyFile = f.key
+		o.TLSAuto = f.tlsAuto
+		o.RedirectHTTP = f.redirectHTTP
+		o.RateLimit = f.rateLimit
+		o.RateBurst = f.rateBurst
+		o.Version = version
+		o.AdminToken = f.adminToken
+		o.Token = f.token
+		o.AuthTokens = f.authTokens
+		o.AuthUsers = f.authUsers
+		o.AuthFile = f.authFile
+		o.ConfigReloader = configReloader
 		return nil
 	})
+}
+
+// listening logs the URL served on, with the port picked for -port 0, and opens it with -open
+func listening(addr net.Addr) {
+	port := f.port
+	if tcpAddr, ok := addr.(*net.TCPAddr); ok {
+		port = int64(tcpAddr.Port)
+	}
+	scheme := "http"
+	if f.cert != "" || f.tlsAuto {
+		scheme = "https"
+	}
+	url := fmt.Sprintf("%s://%s%s", scheme, net.JoinHostPort(f.host, strconv.FormatInt(port, 10)), f.baseURL)
+	slog.Info("Listening", "url", url, "port", port)
+	if f.open {
+		pkg.OpenBrowser(url)
+	}
+}
+
+func loadConfig(flagSet *flag.FlagSet) {
+	if f.config == "" {
+		return
+	}
+	var err error
+	config, err = pkg.LoadConfig(f.config)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	// flags given on the command line win over the config file
+	set := map[string]bool{}
+	flagSet.Visit(func(fl *flag.Flag) { set[fl.Name] = true })
+	if config.Host != "" && !set["host"] {
+		f.host = config.Host
+	}
+	if config.Port != 0 && !set["port"] {
+		f.port = config.Port
+	}
+	if config.BaseURL != "" && !set["base-url"] {
+		f.baseURL = config.BaseURL
+	}
+	if config.Every != "" && !set["every"] {
+		f.every.Set(config.Every) //nolint: errcheck // validated by LoadConfig
+	}
+	if config.Limit != 0 && !set["limit"] {
+		f.limit = config.Limit
+	}
+	if config.AlertURL != "" && !set["alert-url"] {
+		f.alertURL = config.AlertURL
+	}
+	// the lists add up, the token defaults to GOL_TOKEN which wins over the file as well
+	if auth := config.Auth; auth != nil {
+		if auth.Token != "" && f.token == "" {
+			f.token = auth.Token
+		}
+		if auth.AdminToken != "" && f.adminToken == "" {
+			f.adminToken = auth.AdminToken
+		}
+		if auth.File != "" && !set["auth-file"] {
+			f.authFile = auth.File
+		}
+		f.authTokens = append(f.authTokens, auth.Tokens...)
+		f.authUsers = append(f.authUsers, auth.Users...)
+	}
+	pkg.GlobalPathDefaults.Set(config.Paths)
+	f.filePaths = append(f.filePaths, config.FilePatterns()...)
+	f.sshPaths = append(f.sshPaths, config.SSHPathStrings()...)
+	f.dockerPaths = append(f.dockerPaths, config.DockerPaths...)
+	f.excludes = append(f.excludes, config.Excludes...)
+}
+
+// effectiveConfig is the config gol runs with, that of the file merged with the flags
+func effectiveConfig() *pkg.Config {
+	effective := &pkg.Config{
+		Host:        f.host,
+		Port:        f.port,
+		BaseURL:     f.baseURL,
+		Every:       time.Duration(f.every).String(),
+		Limit:       f.limit,
+		AlertURL:    f.alertURL,
+		DockerPaths: f.dockerPaths,
+		Excludes:    f.excludes,
+	}
+	patterns := f.filePaths
+	if config != nil {
+		effective.Paths = append(effective.Paths, config.Paths...)
+		patterns = pkg.StringsMissingFrom(f.filePaths, config.FilePatterns())
+	}
+	for _, labeled := range patterns {
+		label, pattern := pkg.ParseFilePattern(labeled)
+		effective.Paths = append(effective.Paths, pkg.PathConfig{Pattern: pattern, Label: label})
+	}
+	for _, sshPath := range f.sshPaths {
+		if sshPathConfig, err := pkg.StringToSSHPathConfig(sshPath); err == nil {
+			effective.SSHPaths = append(effective.SSHPaths, pkg.NewConfigSSHPath(*sshPathConfig))
+		}
+	}
+	for _, alert := range f.alerts {
+		if rule, err := pkg.ParseAlertRule(alert); err == nil {
+			effective.Alerts = append(effective.Alerts, rule)
+		}
+	}
+	if config != nil {
+		effective.Alerts = append(effective.Alerts, config.Alerts...)
+	}
+	if f.token != "" || f.adminToken != "" || f.authFile != "" || len(f.authTokens) > 0 || len(f.authUsers) > 0 {
+		effective.Auth = &pkg.AuthConfig{
+			Token:      f.token,
+			Tokens:     f.authTokens,
+			Users:      f.authUsers,
+			File:       f.authFile,
+			AdminToken: f.adminToken,
+		}
+	}
+	return effective
+}
+
+// checkConfig is "-config check gol.yaml": it prints the effective config of the file and the flags,
+// secrets redacted, and returns the exit code without starting the server
+func checkConfig(flagSet *flag.FlagSet) int {
+	f.config = flagSet.Arg(0)
+	loadConfig(flagSet)
+	settings := flagSettings()
+	if err := settings.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	f.baseURL = settings.BaseURL
+	encoder := yaml.NewEncoder(os.Stdout)
+	encoder.SetIndent(2)
+	if err := encoder.Encode(effectiveConfig().Redacted()); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		return 2
+	}
+	return 0
+}
+
+func setRemoteClients() {
+	for _, remotePath := range f.remotePaths {
+		remoteConfig, err := pkg.StringToRemotePathConfig(remotePath)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "remote:", err)
+			os.Exit(2)
+		}
+		pkg.GlobalRemoteClients = append(pkg.GlobalRemoteClients, pkg.NewRemoteClient(*remoteConfig, nil))
+	}
+}
+
+func setHTTPSources() {
+	headers := []pkg.HTTPHeader{}
+	for _, s := range f.httpHeaders {
+		header, err := pkg.ParseHTTPHeader(s)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "http-header:", err)
+			os.Exit(2)
+		}
+		headers = append(headers, header)
+	}
+	pkg.GlobalHTTPSources = pkg.NewHTTPSources(headers, int64(f.httpMaxSize), f.httpMaxRedirects)
+}
+
+func setK8sSource() {
+	if len(f.k8sPaths) == 0 {
+		return
+	}
+	client, err := pkg.NewK8sClient()
 	if err != nil {
-		slog.Error("starting echo", "echo", err)
+		fmt.Fprintln(os.Stderr, "k8s:", err)
+		os.Exit(2)
+	}
+	pkg.GlobalK8sSource = pkg.NewK8sSource(client, f.k8sPaths)
+}
+
+func setS3Source() {
+	if len(f.s3Paths) == 0 {
 		return
 	}
+	for _, s3Path := range f.s3Paths {
+		if _, err := pkg.StringToS3PathConfig(s3Path); err != nil {
+			fmt.Fprintln(os.Stderr, "s3:", err)
+			os.Exit(2)
+		}
+	}
+	client, err := pkg.NewS3Client(context.Background(), f.s3Endpoint)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "s3:", err)
+		os.Exit(2)
+	}
+	pkg.GlobalS3Source = pkg.NewS3Source(client, f.s3Paths)
 }
 
-func setFilePaths() {
-	// convenient method support for gol *logs
-	if len(os.Args) > 1 {
+func setSelfReporter() {
+	if f.reportTo == "" {
+		return
+	}
+	if err := pkg.ValidateEvery(f.reportEvery); err != nil {
+		fmt.Fprintln(os.Stderr, "report-every:", err)
+		os.Exit(2)
+	}
+	reporter, err := pkg.NewSelfReporter(f.reportTo, f.reportSecret, version, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, "report-to:", err)
+		os.Exit(2)
+	}
+	pkg.GlobalSelfReporter = reporter
+}
+
+// setAlerts matches the watched files against the rules of -alert and the config file, when there are any
+func setAlerts() {
+	rules := []pkg.AlertRule{}
+	for _, alert := range f.alerts {
+		rule, err := pkg.ParseAlertRule(alert)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, "alert:", err)
+			os.Exit(2)
+		}
+		rules = append(rules, rule)
+	}
+	if config != nil {
+		rules = append(rules, config.Alerts...)
+	}
+	if len(rules) == 0 {
+		return
+	}
+	alerts, err := pkg.NewAlerts(rules, nil)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	alerts.SetContextOptions(pkg.AlertContextOptions{
+		Before:      f.alertContext,
+		After:       f.alertContext,
+		MaxSnippets: f.alertSnippets,
+		BaseURL:     f.alertURL,
+	})
+	pkg.GlobalAlerts = alerts
+}
+
+func setFilePaths(flagSet *flag.FlagSet) {
+	// convenient method support for gol *logs, paths given without any flag
+	if flagSet.NFlag() == 0 && flagSet.NArg() > 0 {
 		filePaths := pkg.SliceFlags{}
-		for _, arg := range os.Args[1:] {
+		for _, arg := range flagSet.Args() {
 			// ignore background process flag
 			if arg == "&" {
 				continue
@@ -92,54 +535,176 @@ func setFilePaths() {
 
 	// Append GlobalPipeTmpFilePath to f.filePaths if it's not empty
 	// should be set if user has piped input
-	if pkg.GlobalPipeTmpFilePath != "" {
-		f.filePaths = append(f.filePaths, pkg.GlobalPipeTmpFilePath)
+	if pkg.PipeTmpFilePath() != "" {
+		f.filePaths = append(f.filePaths, pkg.PipeTmpFilePath())
 	}
 
-	// If f.sshPaths is not nil, process each SSH path
-	if f.sshPaths != nil {
-		for _, sshPath := range f.sshPaths {
-			// Convert SSH path string to SSHPathConfig
-			sshFilePathConfig, err := pkg.StringToSSHPathConfig(sshPath)
-			if err != nil {
-				slog.Error("parsing SSH path", sshPath, err)
-				continue
-			}
-			if sshFilePathConfig != nil {
-				sshConfig := pkg.SSHConfig{
-					Host:           sshFilePathConfig.Host,
-					Port:           sshFilePathConfig.Port,
-					User:           sshFilePathConfig.User,
-					Password:       sshFilePathConfig.Password,
-					PrivateKeyPath: sshFilePathConfig.PrivateKeyPath,
-				}
-				// Get file information from the SSH path and append to GlobalFilePaths
-				fileInfos := pkg.GetFileInfos(sshFilePathConfig.FilePath, f.limit, true, &sshConfig)
-				pkg.GlobalFilePaths = append(pkg.GlobalFilePaths, fileInfos...)
-			}
-		}
+	// Update global file paths with the current filePaths, stdin to tmp, sshPaths, and dockerPaths,
+	// which a reload rescans from then on
+	pkg.GlobalWatchedPatterns.Set(f.filePaths, f.sshPaths, f.dockerPaths, f.limit)
+	pkg.UpdateGlobalFilePaths(pkg.GlobalWatchedPatterns.Get())
+}
+
+// parseFlags parses args, the command line without the program name, into f
+func parseFlags(args []string) *flag.FlagSet {
+	f = Flags{}
+	flagSet := flag.NewFlagSet(os.Args[0], flag.ExitOnError)
+	flagSet.Var(&f.filePaths, "f", "full path pattern to the log file, label:pattern groups its files under label, or an http(s) URL")
+	flagSet.Var(&f.httpHeaders, "http-header", "header sent to the -f URLs, \"[url-prefix] Name: value\" sends it to the URLs starting with url-prefix only, repeatable")
+	f.httpMaxSize = pkg.ByteSizeFlag(pkg.DefaultHTTPMaxSize)
+	flagSet.Var(&f.httpMaxSize, "http-max-size", "how much of a -f URL served without Range support is downloaded, larger ones are listed with an error")
+	flagSet.IntVar(&f.httpMaxRedirects, "http-max-redirects", pkg.DefaultHTTPMaxRedirects, "redirects followed by requests to the -f URLs")
+	flagSet.Var(&f.sshPaths, "s", "full ssh path pattern to the log file")
+	flagSet.Var(&f.dockerPaths, "d", "docker paths to the log file")
+	flagSet.Var(&f.excludes, "exclude", "glob of the files not to list, matched against the path, or the file name when it has no /, repeatable")
+	flagSet.Var(&f.k8sPaths, "k8s", "kubernetes pods to follow the logs of, \"namespace/pod[/container]\" or \"namespace/app=myapp\"")
+	flagSet.Var(&f.s3Paths, "s3", "S3 keys to list and read with ranged requests, \"s3://bucket/prefix/*.log\", with the credentials of the default chain of the AWS SDK")
+	flagSet.StringVar(&f.s3Endpoint, "s3-endpoint", "", "endpoint of an S3 compatible store like MinIO, \"http://localhost:9000\", AWS when empty")
+	flagSet.Var(&f.journalUnits, "journal", "systemd unit to read the journal of, \"nginx.service [priority=err]\"")
+	flagSet.Var(&f.remotePaths, "remote", "peer gol to list and read files from, \"https://host:port [token=XYZ] [label=dc2]\"")
+	flagSet.Var(&f.remotePaths, "r", "shorthand for -remote")
+	flagSet.Var(&f.rotationSuffixes, "rotation-suffix", "regex of a rotation suffix, repeatable (default numeric and dated suffixes)")
+	flagSet.BoolVar(&f.rotationGroups, "rotation-groups", false, "group rotated siblings (app.log.1, app.log.2.gz) into one logical log")
+	flagSet.BoolVar(&f.version, "version", false, "")
+	flagSet.BoolVar(&f.check, "check", false, "check the config, sources and data dir, print every problem found and exit")
+	flagSet.BoolVar(&f.access, "access", false, "print access logs")
+	flagSet.BoolVar(&f.readOnly, "read-only", false, "reject all API requests that change server state")
+	flagSet.BoolVar(&f.ui, "ui", true, "serve the web UI, -ui=false serves the API and a status page only")
+	flagSet.BoolVar(&f.agent, "agent", false, "serve the files to a central gol only, that lists them with -r: the read-only API without the UI or a browser")
+	flagSet.BoolVar(&f.metrics, "metrics", true, "serve Prometheus metrics on /metrics, behind -token when set")
+	flagSet.BoolVar(&f.requireAll, "require-all-sources", false, "report /readyz ready only when every source is reachable, not just one")
+	flagSet.StringVar(&f.host, "host", "localhost", "host to serve")
+	flagSet.Int64Var(&f.port, "port", 3003, "port to serve, 0 picks a free one, logged once listening")
+	f.every = pkg.EveryFlag(10 * time.Second)
+	flagSet.Var(&f.every, "every", "check for file paths every duration, e.g. 30s, with -fsnotify for SSH, docker and other remote paths and written local files only")
+	flagSet.BoolVar(&f.fsnotify, "fsnotify", true, "list local files as they are created, removed or renamed, watching the directories of -f patterns")
+	flagSet.IntVar(&f.limit, "limit", 1000, "limit the number of files to read from the file path pattern")
+	flagSet.IntVar(&f.stdinBuffer, "stdin-buffer", pkg.DefaultStdinBuffer, "lines piped to gol kept viewable, older lines are dropped, of named pipes -f points at as well")
+	flagSet.StringVar(&f.stdinName, "stdin-name", "", "name of the piped stream listed, instead of its temp file")
+	flagSet.Int64Var(&f.cors, "cors", 0, "cors port to allow the api (for development)")
+	flagSet.BoolVar(&f.open, "open", true, "open browser on start")
+	flagSet.StringVar(&f.baseURL, "base-url", "/", "base url with slash")
+	flagSet.StringVar(&f.compression, "compression", pkg.CompressionGzip, "response compression: gzip, br or off")
+	flagSet.IntVar(&f.gzipLevel, "gzip-level", -1, "gzip compression level (-1 default, 1 fastest, 9 best)")
+	flagSet.IntVar(&f.brLevel, "br-level", 6, "brotli compression level (0 fastest, 11 best)")
+	flagSet.IntVar(&f.maxLineLength, "max-line-length", pkg.DefaultMaxLineLength, "lines longer than n bytes are truncated for display (0 to disable)")
+	flagSet.IntVar(&f.maxPerPage, "max-lines-per-request", pkg.DefaultMaxPerPage, "max lines a response holds, longer pages and tails are truncated with a cursor to the lines after them")
+	flagSet.IntVar(&f.maxPerPage, "max-per-page", pkg.DefaultMaxPerPage, "same as -max-lines-per-request")
+	flagSet.StringVar(&f.charset, "charset", pkg.CharsetAuto, "encoding of the files without a BOM: auto detects UTF-16, Shift-JIS and Latin-1 per file, or one of utf-8, latin-1, shift-jis")
+	f.maxResponseBytes = pkg.ByteSizeFlag(pkg.DefaultMaxResponseBytes)
+	flagSet.Var(&f.maxResponseBytes, "max-response-bytes", "max size of the lines of a response, e.g. 16MiB, longer pages are truncated like past -max-lines-per-request (0 to disable)")
+	flagSet.IntVar(&f.maxMergeFiles, "max-merge-files", pkg.DefaultMaxMergeFiles, "max files merged into one view or stream")
+	flagSet.IntVar(&f.maxScans, "max-concurrent-scans", pkg.DefaultMaxScans, "max concurrent searches, exports and counts over all sources, 0 for no limit")
+	flagSet.IntVar(&f.maxReads, "max-reads", pkg.DefaultMaxLocalReads, "max concurrent reads and searches of local files")
+	flagSet.IntVar(&f.maxReadsPerHost, "max-reads-per-host", pkg.DefaultMaxReadsPerHost, "max concurrent reads and searches per remote host")
+	flagSet.IntVar(&f.maxTails, "max-tails", pkg.DefaultMaxTails, "max concurrent streaming tails")
+	flagSet.IntVar(&f.maxTails, "max-streams", pkg.DefaultMaxTails, "same as -max-tails, tails and streams share the budget")
+	flagSet.DurationVar(&f.maxReadWait, "max-read-wait", pkg.DefaultMaxReadWait, "how long a read waits for a free slot before 503, or 429 for a scan")
+	flagSet.Float64Var(&f.rateLimit, "rate-limit", pkg.DefaultRateLimit, "API requests per second of each client address, 0 for no limit")
+	flagSet.IntVar(&f.rateBurst, "rate-burst", pkg.DefaultRateBurst, "API requests a client address may send at once over -rate-limit")
+	flagSet.IntVar(&f.sshWorkers, "ssh-workers", pkg.DefaultSSHWorkers, "SSH paths listed at once")
+	flagSet.DurationVar(&f.sshDeadline, "ssh-deadline", pkg.DefaultSSHDeadline, "how long a scan waits for the SSH paths, slower ones are listed once they resolve")
+	flagSet.DurationVar(&f.sshIdleTimeout, "ssh-idle-timeout", pkg.DefaultSSHIdleTimeout, "how long an SSH connection without sessions stays open, 0 keeps it")
+	flagSet.IntVar(&f.sshMaxSessions, "ssh-max-sessions", pkg.DefaultSSHMaxSessions, "max sessions at once on the connection to an SSH host")
+	flagSet.StringVar(&f.token, "token", os.Getenv("GOL_TOKEN"), "token every API request must carry as a bearer token or ?token=, no auth when empty (env GOL_TOKEN)")
+	flagSet.Var(&f.authTokens, "auth-token", "another token API requests may carry like -token, repeatable")
+	flagSet.Var(&f.authUsers, "auth-user", "basic auth user of the API, \"user:bcrypt-hash\" as made by htpasswd -nB, repeatable")
+	flagSet.StringVar(&f.authFile, "auth-file", "", "file of \"token <token>\" and \"user <user>:<bcrypt-hash>\" lines, read again on SIGHUP")
+	flagSet.StringVar(&f.adminToken, "admin-token", os.Getenv("GOL_ADMIN_TOKEN"), "bearer token of the admin API, disabled when empty (env GOL_ADMIN_TOKEN)")
+	flagSet.StringVar(&f.config, "config", "", "path to the yaml config file, reloaded on SIGHUP, \"-config check gol.yaml\" prints the effective config and exits")
+	flagSet.StringVar(&f.dataDir, "data-dir", filepath.Join(pkg.GetHomedir(), ".cache", "gol"), "directory for persisted caches")
+	flagSet.StringVar(&f.indexDir, "index-dir", "", "directory of the line index files of large files, -data-dir when empty")
+	flagSet.BoolVar(&f.noPersistIndex, "no-persist-index", false, "remove the line index files on exit instead of keeping them for the next start")
+	f.minFreeDisk = pkg.ByteSizeFlag(pkg.DefaultMinFreeDisk)
+	flagSet.Var(&f.minFreeDisk, "min-free-disk", "free space temp copies and caches must leave on their file system, e.g. 1GiB (0 to disable)")
+	f.maxBufferMemory = pkg.ByteSizeFlag(pkg.DefaultMaxBufferMemory)
+	flagSet.Var(&f.maxBufferMemory, "max-buffer-memory", "memory the in-memory line buffers may hold, their oldest lines are spilled to the data dir beyond it, e.g. 256MiB (0 to disable)")
+	flagSet.IntVar(&f.patternLimits.MaxCost, "max-pattern-cost", pkg.DefaultMaxPatternCost, "estimated cost a search regex may have, literal searches cost nothing (0 to disable)")
+	flagSet.StringVar(&f.patternLimits.Mode, "pattern-limit", pkg.PatternLimitSample, "regexes over -max-pattern-cost are rejected with a 400 (reject) or tested against a sample of the lines (sample)")
+	flagSet.Float64Var(&f.patternLimits.SampleRate, "pattern-sample", pkg.DefaultPatternSampleRate, "fraction of the lines tested against regexes over -max-pattern-cost")
+	flagSet.StringVar(&f.reportTo, "report-to", "", "fleet inventory URL the self report (versions and counts, never paths) is POSTed to, disabled when empty")
+	flagSet.StringVar(&f.reportSecret, "report-secret", os.Getenv("GOL_REPORT_SECRET"), "shared secret sent with the self report as "+pkg.SelfReportSecretHeader+" (env GOL_REPORT_SECRET)")
+	flagSet.DurationVar(&f.reportEvery, "report-every", pkg.DefaultSelfReportEvery, "how often the self report is sent")
+	flagSet.DurationVar(&f.exportRetention, "export-retention", pkg.DefaultExportRetention, "how long exports spooled to the data dir are kept for resumed downloads, less while it is low on disk")
+	flagSet.DurationVar(&f.shareTTL, "share-ttl", pkg.DefaultShareTTL, "how long share links of a line are kept, 0 keeps them")
+	flagSet.DurationVar(&f.shutdownTimeout, "shutdown-timeout", pkg.DefaultShutdownTimeout, "how long a shutdown on SIGINT or SIGTERM waits for the requests in flight, streams are ended at once")
+	flagSet.StringVar(&f.cert, "cert", "", "PEM certificate file to serve HTTPS with, along with -key, reloaded on SIGHUP")
+	flagSet.StringVar(&f.key, "key", "", "PEM key file of -cert")
+	flagSet.BoolVar(&f.tlsAuto, "tls-auto", false, "serve HTTPS with a self-signed certificate generated on startup, its fingerprint is logged")
+	flagSet.Int64Var(&f.redirectHTTP, "redirect-http", 0, "port of a listener redirecting HTTP to HTTPS, with -cert or -tls-auto, none when 0")
+	flagSet.IntVar(&f.exportMaxLines, "export-max-lines", pkg.DefaultExportMaxLines, "max lines of a download of search results, the rest is cut with a trailer telling so")
+	flagSet.Var(&f.alerts, "alert", "webhook POSTed the lines appended to the watched files that match a regex, \"pattern=/FATAL|panic/ url=https://hooks.example.com/... cooldown=300\", repeatable")
+	flagSet.StringVar(&f.alertURL, "alert-url", "", "external URL of gol the share links of the alerts are built on, e.g. https://gol.example.com/, none when empty")
+	flagSet.IntVar(&f.alertContext, "alert-context", pkg.DefaultAlertContextBefore, "lines before and after a matched line sent with an alert")
+	flagSet.IntVar(&f.alertSnippets, "alert-snippets", pkg.DefaultAlertContextMaxSnippets, "max matched lines of an alert sent with their context, the others are counted")
+	flagSet.IntVar(&f.internalLogs, "internal-logs", pkg.DefaultInternalLogLines, "last n lines of gol's own log listed as the \"gol (internal)\" source (0 to disable)")
+
+	flagSet.Parse(args) //nolint: errcheck // exits on error
+	if f.agent {
+		f.ui, f.open, f.readOnly = false, false, true
 	}
+	return flagSet
+}
 
-	// Update global file paths with the current filePaths, stdin to tmp, sshPaths, and dockerPaths
-	pkg.UpdateGlobalFilePaths(f.filePaths, f.sshPaths, f.dockerPaths, f.limit)
+func flagSettings() pkg.Settings {
+	return pkg.Settings{
+		Every:         time.Duration(f.every),
+		Limit:         f.limit,
+		Port:          f.port,
+		BaseURL:       f.baseURL,
+		PatternLimits: &f.patternLimits,
+		ReadLimits:    &pkg.ReadLimits{MaxReads: f.maxReads, MaxReadsPerHost: f.maxReadsPerHost, MaxTails: f.maxTails},
+	}
 }
 
-func flags() {
-	flag.Var(&f.filePaths, "f", "full path pattern to the log file")
-	flag.Var(&f.sshPaths, "s", "full ssh path pattern to the log file")
-	flag.Var(&f.dockerPaths, "d", "docker paths to the log file")
-	flag.BoolVar(&f.version, "version", false, "")
-	flag.BoolVar(&f.access, "access", false, "print access logs")
-	flag.StringVar(&f.host, "host", "localhost", "host to serve")
-	flag.Int64Var(&f.port, "port", 3003, "port to serve")
-	flag.Int64Var(&f.every, "every", 10, "check for file paths every n seconds")
-	flag.IntVar(&f.limit, "limit", 1000, "limit the number of files to read from the file path pattern")
-	flag.Int64Var(&f.cors, "cors", 0, "cors port to allow the api (for development)")
-	flag.BoolVar(&f.open, "open", true, "open browser on start")
-	flag.StringVar(&f.baseURL, "base-url", "/", "base url with slash")
+func validateFlags() {
+	settings := flagSettings()
+	if err := settings.Validate(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	f.baseURL = settings.BaseURL
+	charset, err := pkg.ParseCharset(f.charset)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(2)
+	}
+	f.charset = charset
+	for _, exclude := range f.excludes {
+		if _, err := filepath.Match(exclude, ""); err != nil {
+			fmt.Fprintf(os.Stderr, "exclude %q: %s\n", exclude, err)
+			os.Exit(2)
+		}
+	}
+}
 
-	flag.Parse()
-	wantsVersion()
+// check runs the self-check of -check and returns the exit code, 1 when any error is found
+func check() int {
+	setHTTPSources()
+	findings := pkg.RunChecks(context.Background(), pkg.CheckOptions{
+		ConfigPath:       f.config,
+		Settings:         flagSettings(),
+		FilePaths:        f.filePaths,
+		SSHPaths:         f.sshPaths,
+		DockerPaths:      f.dockerPaths,
+		RemotePaths:      f.remotePaths,
+		K8sPaths:         f.k8sPaths,
+		JournalUnits:     f.journalUnits,
+		RotationSuffixes: f.rotationSuffixes,
+		DataDir:          f.dataDir,
+	})
+	errors := 0
+	for _, finding := range findings {
+		fmt.Println(finding)
+		if finding.Severity == pkg.CheckSeverityError {
+			errors++
+		}
+	}
+	fmt.Printf("%d errors, %d warnings\n", errors, len(findings)-errors)
+	if errors > 0 {
+		return 1
+	}
+	return 0
 }
 
 func wantsVersion() {
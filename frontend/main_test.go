@@ -0,0 +1,147 @@
+package main
+
+import (
+	"bytes"
+	"log/slog"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/kevincobain2000/gol/pkg"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFlags_Token(t *testing.T) {
+	t.Setenv("GOL_TOKEN", "")
+	parseFlags([]string{})
+	assert.Empty(t, f.token)
+
+	t.Setenv("GOL_TOKEN", "from-env")
+	parseFlags([]string{})
+	assert.Equal(t, "from-env", f.token)
+
+	// the flag wins over the environment
+	parseFlags([]string{"-token", "from-flag"})
+	assert.Equal(t, "from-flag", f.token)
+}
+
+func TestParseFlags_Agent(t *testing.T) {
+	parseFlags([]string{"-r", "http://agent1:3003 token=XYZ", "-remote", "http://agent2:3003"})
+	assert.Equal(t, pkg.SliceFlags{"http://agent1:3003 token=XYZ", "http://agent2:3003"}, f.remotePaths)
+	assert.True(t, f.ui)
+	assert.False(t, f.readOnly)
+
+	parseFlags([]string{"-agent", "-ui"})
+	assert.False(t, f.ui)
+	assert.False(t, f.open)
+	assert.True(t, f.readOnly)
+}
+
+func TestLoadConfig_FlagsWin(t *testing.T) {
+	t.Setenv("GOL_TOKEN", "")
+	t.Setenv("GOL_ADMIN_TOKEN", "")
+	path := filepath.Join(t.TempDir(), "gol.yaml")
+	content := `
+host: 0.0.0.0
+port: 4000
+every: 30s
+limit: 50
+paths:
+  - pattern: /var/log/app/*.log
+ssh_paths:
+  - host: web1
+    user: deploy
+    password: hunter2
+    file: /var/log/app.log
+excludes: ["*.gz"]
+auth:
+  token: s3cret
+`
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	defer pkg.GlobalPathDefaults.Set(nil)
+	defer func() { config = nil }()
+
+	flagSet := parseFlags([]string{"-config", path, "-port", "5000", "-f", "/tmp/other.log", "-exclude", "*.1"})
+	loadConfig(flagSet)
+	assert.Equal(t, "0.0.0.0", f.host)
+	assert.Equal(t, int64(5000), f.port)
+	assert.Equal(t, 30*time.Second, time.Duration(f.every))
+	assert.Equal(t, 50, f.limit)
+	assert.Equal(t, "s3cret", f.token)
+	assert.Equal(t, pkg.SliceFlags{"/tmp/other.log", "/var/log/app/*.log"}, f.filePaths)
+	assert.Equal(t, pkg.SliceFlags{"deploy@web1 password=hunter2 /var/log/app.log"}, f.sshPaths)
+	assert.Equal(t, pkg.SliceFlags{"*.1", "*.gz"}, f.excludes)
+
+	effective := effectiveConfig()
+	assert.Equal(t, []pkg.PathConfig{{Pattern: "/var/log/app/*.log"}, {Pattern: "/tmp/other.log"}}, effective.Paths)
+	line := effective.Redacted().OneLine()
+	assert.Contains(t, line, "port: 5000")
+	assert.NotContains(t, line, "hunter2")
+	assert.NotContains(t, line, "s3cret")
+}
+
+func TestSetFilePaths_UnreachableSSHHost(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte("INFO a\n"), 0o600))
+
+	// the host never answers its listing
+	unreachable := make(chan struct{})
+	defer close(unreachable)
+	defer func(runner pkg.RemoteRunner, discovery *pkg.SSHDiscovery, fileInfos []pkg.FileInfo, sshConfigs []pkg.SSHPathConfig) {
+		pkg.GlobalRemoteRunner, pkg.GlobalSSHDiscovery = runner, discovery
+		pkg.SetSSHPathConfigs(sshConfigs)
+		pkg.GlobalFileRegistry.Replace(fileInfos)
+	}(pkg.GlobalRemoteRunner, pkg.GlobalSSHDiscovery, pkg.GlobalFileRegistry.Snapshot(), pkg.GlobalPathSSHConfig)
+	defer pkg.GlobalWatchedPatterns.Set(pkg.GlobalWatchedPatterns.Get())
+	pkg.GlobalRemoteRunner = &pkg.ScriptedRemoteRunner{
+		Gates: map[string]chan struct{}{"web1 ls /var/log/*.log": unreachable},
+	}
+	pkg.GlobalSSHDiscovery = pkg.NewSSHDiscovery(1, 50*time.Millisecond)
+
+	flagSet := parseFlags([]string{"-f", logFile, "-s", "user@web1 /var/log/*.log"})
+	done := make(chan struct{})
+	go func() {
+		setFilePaths(flagSet)
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("startup blocked on the unreachable host")
+	}
+	// the local files are listed without waiting for it
+	filePaths := []string{}
+	for _, fileInfo := range pkg.GlobalFileRegistry.Snapshot() {
+		filePaths = append(filePaths, fileInfo.FilePath)
+	}
+	assert.Contains(t, filePaths, logFile)
+}
+
+func TestParseFlags_PortZero(t *testing.T) {
+	parseFlags([]string{"-port", "0", "-open=false"})
+	settings := flagSettings()
+	assert.NoError(t, settings.Validate())
+
+	// the port picked is logged
+	var logs bytes.Buffer
+	defer slog.SetDefault(slog.Default())
+	slog.SetDefault(slog.New(slog.NewTextHandler(&logs, nil)))
+	listening(&net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 43017})
+	assert.Contains(t, logs.String(), "port=43017")
+	assert.Contains(t, logs.String(), "url=http://localhost:43017/")
+}
+
+func TestParseFlags_ReadLimits(t *testing.T) {
+	parseFlags([]string{"-max-reads", "-1", "-max-reads-per-host", "0", "-max-streams", "0"})
+	settings := flagSettings()
+	err := settings.Validate()
+	assert.ErrorContains(t, err, "max-reads must be at least 1, got -1")
+	assert.ErrorContains(t, err, "max-reads-per-host must be at least 1, got 0")
+	assert.ErrorContains(t, err, "max-tails must be at least 1, got 0")
+
+	parseFlags([]string{})
+	settings = flagSettings()
+	assert.NoError(t, settings.Validate())
+}
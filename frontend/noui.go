@@ -0,0 +1,8 @@
+//go:build noui
+
+package main
+
+import "io/fs"
+
+// publicDir is nil in API only builds, a status page is served at the base url
+var publicDir fs.FS
@@ -0,0 +1,13 @@
+//go:build !noui
+
+package main
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed all:dist/*
+var embeddedUI embed.FS
+
+var publicDir fs.FS = embeddedUI
@@ -1,26 +1,69 @@
 package gol
 
 import (
-	"embed"
+	"context"
+	"io/fs"
 	"log/slog"
+	"net"
 	"net/http"
+	"sync"
+	"time"
 
 	"github.com/kevincobain2000/gol/pkg"
 	"github.com/labstack/echo/v4"
 )
 
-//go:embed all:frontend/dist/*
-var publicDir embed.FS
-
 type GolOptions struct { // nolint: revive
+	// Every is the interval in seconds at which file paths are rescanned
 	Every     int64
 	FilePaths []string
 	LogLevel  slog.Leveler
+	// StorePath is the file persisting saved state (pins, hidden files), kept in memory when empty
+	StorePath string
+	// FileOpener, RemoteRunner and Clock replace the file system, SSH hosts and time, for tests
+	FileOpener   pkg.FileOpener
+	RemoteRunner pkg.RemoteRunner
+	Clock        pkg.Clock
+	// Processors are registered for requests and path defaults to select by name
+	Processors []pkg.LineProcessor
+	// Token must be carried by every request of the handlers wrapped by Adapter, no auth when empty
+	Token string
+	// AuthValidator accepts requests by credentials of the app, along with Token
+	AuthValidator pkg.AuthValidator
+	// Host and Port are where Start serves gol
+	Host string
+	Port int64
+	// ShutdownTimeout is how long Stop waits for the requests in flight
+	ShutdownTimeout time.Duration
+	// ListenCallback is called with the address Start serves on, the port picked when Port is 0
+	ListenCallback func(addr net.Addr)
 }
 type GolOption func(*GolOptions) error // nolint: revive
 
+// WithToken requires token, as a bearer token or the token query parameter, on every request
+func WithToken(token string) GolOption {
+	return func(o *GolOptions) error {
+		o.Token = token
+		return nil
+	}
+}
+
+// WithAuth accepts the requests validator accepts, with credentials of the app instead of or along with
+// a token
+func WithAuth(validator pkg.AuthValidator) GolOption {
+	return func(o *GolOptions) error {
+		o.AuthValidator = validator
+		return nil
+	}
+}
+
 type Gol struct {
 	Options *GolOptions
+
+	mutex sync.Mutex
+	// stop ends the serving of Start, which closes stopped once it returned
+	stop    context.CancelFunc
+	stopped chan struct{}
 }
 
 func NewGol(opts ...GolOption) *Gol {
@@ -28,6 +71,10 @@ func NewGol(opts ...GolOption) *Gol {
 		Every:     1000,
 		LogLevel:  slog.LevelInfo,
 		FilePaths: []string{},
+
+		Host:            "localhost",
+		Port:            3000,
+		ShutdownTimeout: pkg.DefaultShutdownTimeout,
 	}
 	for _, opt := range opts {
 		err := opt(options)
@@ -35,24 +82,119 @@ func NewGol(opts ...GolOption) *Gol {
 			return nil
 		}
 	}
+	if err := pkg.ValidateEvery(options.every()); err != nil {
+		slog.Error("validating gol options", "every", err)
+		return nil
+	}
+	if options.FileOpener != nil {
+		pkg.GlobalFileOpener = options.FileOpener
+	}
+	if options.RemoteRunner != nil {
+		pkg.GlobalRemoteRunner = options.RemoteRunner
+	}
+	if options.Clock != nil {
+		pkg.GlobalClock = options.Clock
+	}
+	for _, processor := range options.Processors {
+		if err := pkg.RegisterProcessor(processor); err != nil {
+			slog.Error("registering processor", "processor", err)
+			return nil
+		}
+	}
+	if options.StorePath != "" {
+		store, err := pkg.OpenFileStore(options.StorePath)
+		if err != nil {
+			slog.Error("opening store", "path", err)
+			return nil
+		}
+		pkg.SetGlobalStore(store)
+	}
 	return &Gol{
 		Options: options,
 	}
 }
 
+func (o *GolOptions) every() time.Duration {
+	return time.Duration(o.Every) * time.Second
+}
+
 func (g *Gol) NewAPIHandler() *pkg.APIHandler {
 	pkg.UpdateGlobalFilePaths(g.Options.FilePaths, nil, nil, 1000)
-	go pkg.WatchFilePaths(g.Options.Every, g.Options.FilePaths, nil, nil, 1000)
+	go pkg.WatchFilePaths(g.Options.every(), g.Options.FilePaths, nil, nil, 1000)
 	return pkg.NewAPIHandler()
 }
+
+// Start serves gol, its UI and API, on the host and port of the options and watches the file paths,
+// until ctx is done or Stop is called. It then shuts down gracefully and returns once the requests in
+// flight finished, or ShutdownTimeout passed.
+func (g *Gol) Start(ctx context.Context) error {
+	server, err := pkg.NewServer(func(o *pkg.EchoOptions) error {
+		o.Host = g.Options.Host
+		o.Port = g.Options.Port
+		o.Token = g.Options.Token
+		o.AuthValidator = g.Options.AuthValidator
+		o.ShutdownTimeout = g.Options.ShutdownTimeout
+		o.ListenCallback = g.Options.ListenCallback
+		if publicDir != nil {
+			uiDir, err := fs.Sub(publicDir, "frontend")
+			if err != nil {
+				return err
+			}
+			o.PublicDir = uiDir
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	stopped := make(chan struct{})
+	defer close(stopped)
+	g.mutex.Lock()
+	g.stop, g.stopped = cancel, stopped
+	g.mutex.Unlock()
+
+	pkg.UpdateGlobalFilePaths(g.Options.FilePaths, nil, nil, 1000)
+	go pkg.WatchFilePathsContext(ctx, g.Options.every(), g.Options.FilePaths, nil, nil, 1000)
+	return server.Run(ctx)
+}
+
+// Stop shuts down the server of Start and waits until it has, it does nothing when not started
+func (g *Gol) Stop() {
+	g.mutex.Lock()
+	stop, stopped := g.stop, g.stopped
+	g.mutex.Unlock()
+	if stop == nil {
+		return
+	}
+	stop()
+	<-stopped
+}
+
+// NewMetricsHandler serves the Prometheus metrics of gol, from a registry of its own rather than the
+// default one of the app
+func (*Gol) NewMetricsHandler() http.Handler {
+	return pkg.GlobalMetrics.Handler()
+}
+
 func (*Gol) NewAssetsHandler() *pkg.AssetsHandler {
-	return pkg.NewAssetsHandler(&publicDir, "frontend/dist", "index.html")
+	return pkg.NewAssetsHandler(publicDir, "frontend/dist", "index.html")
+}
+
+// validRequest tells whether r carries the token or passes the validator of the options
+func (g *Gol) validRequest(r *http.Request) bool {
+	return pkg.ValidToken(r, g.Options.Token) || (g.Options.AuthValidator != nil && g.Options.AuthValidator(r))
 }
 
-func (*Gol) Adapter(echoHandler echo.HandlerFunc) http.HandlerFunc {
+func (g *Gol) Adapter(echoHandler echo.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
 		e := echo.New()
 		c := e.NewContext(r, w)
+		if (g.Options.Token != "" || g.Options.AuthValidator != nil) && !g.validRequest(r) {
+			e.HTTPErrorHandler(echo.NewHTTPError(http.StatusUnauthorized, pkg.ErrorCodeUnauthorized), c)
+			return
+		}
 		if err := echoHandler(c); err != nil {
 			e.HTTPErrorHandler(err, c)
 		}
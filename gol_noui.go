@@ -0,0 +1,8 @@
+//go:build noui
+
+package gol
+
+import "io/fs"
+
+// publicDir is nil in API only builds, NewAssetsHandler serves a status page instead
+var publicDir fs.FS
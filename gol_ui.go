@@ -0,0 +1,13 @@
+//go:build !noui
+
+package gol
+
+import (
+	"embed"
+	"io/fs"
+)
+
+//go:embed all:frontend/dist/*
+var embeddedUI embed.FS
+
+var publicDir fs.FS = embeddedUI
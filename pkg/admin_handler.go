@@ -0,0 +1,57 @@
+package pkg
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+type AdminHandler struct {
+	token    string
+	reloader *ConfigReloader
+}
+
+func NewAdminHandler(options *EchoOptions) *AdminHandler {
+	return &AdminHandler{
+		token:    options.AdminToken,
+		reloader: options.ConfigReloader,
+	}
+}
+
+// authorize requires the admin token as a bearer token, admin routes are disabled without one
+func (h *AdminHandler) authorize(c echo.Context) error {
+	if h.token == "" {
+		return echo.NewHTTPError(http.StatusForbidden, ErrorCodeAdminDisabled)
+	}
+	token := strings.TrimPrefix(c.Request().Header.Get(echo.HeaderAuthorization), "Bearer ")
+	if subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) != 1 {
+		return echo.NewHTTPError(http.StatusUnauthorized, ErrorCodeUnauthorized)
+	}
+	return nil
+}
+
+// authorizeShared requires the admin token on a shared server, one started with -admin-token.
+// A single user server, without one, lets everyone through.
+func (h *AdminHandler) authorizeShared(c echo.Context) error {
+	if h.token == "" {
+		return nil
+	}
+	return h.authorize(c)
+}
+
+// PostReload reloads the config file, same as SIGHUP
+func (h *AdminHandler) PostReload(c echo.Context) error {
+	if err := h.authorize(c); err != nil {
+		return err
+	}
+	if h.reloader == nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "reloading is not enabled")
+	}
+	reload, err := h.reloader.Reload()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	return c.JSON(http.StatusOK, reload)
+}
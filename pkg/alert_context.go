@@ -0,0 +1,100 @@
+package pkg
+
+import (
+	"strings"
+)
+
+const (
+	DefaultAlertContextBefore      = 3
+	DefaultAlertContextAfter       = 3
+	DefaultAlertContextMaxSnippets = 5
+)
+
+// AlertContext is the pre-resolved neighborhood of one matched line of an alert
+type AlertContext struct {
+	LineNumber int          `json:"line_number"`
+	Anchor     string       `json:"anchor"`
+	Lines      []LineResult `json:"lines"`
+	// ShareID is the share link of the matched line, Link its URL on the BaseURL of the options
+	ShareID string `json:"share_id,omitempty"`
+	Link    string `json:"link,omitempty"`
+}
+
+// AlertContextOptions bound the context read for a notification. At most MaxSnippets matched lines
+// get a snippet, so a high-frequency alert costs one bounded read per notification.
+type AlertContextOptions struct {
+	Before      int
+	After       int
+	MaxSnippets int
+	// BaseURL is the external URL of gol the links are built on, no links without it
+	BaseURL string
+}
+
+func (o AlertContextOptions) withDefaults() AlertContextOptions {
+	if o.Before <= 0 {
+		o.Before = DefaultAlertContextBefore
+	}
+	if o.After <= 0 {
+		o.After = DefaultAlertContextAfter
+	}
+	if o.MaxSnippets <= 0 {
+		o.MaxSnippets = DefaultAlertContextMaxSnippets
+	}
+	return o
+}
+
+// ResolveContexts fills the context snippets of the first matched lines of the payload, read from
+// the payload's file with watcher, each with a share link of its line, and counts the matched lines
+// left without one
+func (p *AlertPayload) ResolveContexts(watcher *Watcher, options AlertContextOptions) error {
+	options = options.withDefaults()
+	lines := p.Lines
+	if len(lines) > options.MaxSnippets {
+		lines = lines[:options.MaxSnippets]
+	}
+	lineNumbers := make([]int, 0, len(lines))
+	for _, line := range lines {
+		lineNumbers = append(lineNumbers, line.LineNumber)
+	}
+
+	contexts, err := watcher.ReadContext(p.FilePath, lineNumbers, options.Before, options.After)
+	if err != nil {
+		return err
+	}
+	fileInfo := p.fileInfo()
+	p.Contexts = make([]AlertContext, 0, len(lines))
+	for _, line := range lines {
+		snippet, ok := contexts[line.LineNumber]
+		if !ok {
+			continue
+		}
+		share, err := GlobalShares.Create(fileInfo, line.LineNumber, nil)
+		if err != nil {
+			return err
+		}
+		alertContext := AlertContext{
+			LineNumber: line.LineNumber,
+			Anchor:     line.Anchor,
+			Lines:      snippet,
+			ShareID:    share.ID,
+		}
+		if options.BaseURL != "" {
+			alertContext.Link = strings.TrimSuffix(options.BaseURL, "/") + "/api/shares/" + share.ID
+		}
+		p.Contexts = append(p.Contexts, alertContext)
+	}
+	p.OmittedContexts = len(p.Lines) - len(p.Contexts)
+	return nil
+}
+
+// fileInfo is the file of the payload as watched, its size and modification time telling a share
+// link that it was rewritten since
+func (p *AlertPayload) fileInfo() FileInfo {
+	ref := FileRef{FilePath: p.FilePath, Host: p.Host, Type: p.Type}
+	for _, fileInfo := range GlobalFileRegistry.Snapshot() {
+		if fileRefOf(fileInfo) == ref {
+			return fileInfo
+		}
+	}
+	return FileInfo{FilePath: p.FilePath, Host: p.Host, Type: p.Type}
+}
@@ -0,0 +1,65 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAlertPayload_ResolveContexts(t *testing.T) {
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	var content strings.Builder
+	for i := 1; i <= 20; i++ {
+		level := "INFO"
+		if i%4 == 0 {
+			level = "ERROR"
+		}
+		fmt.Fprintf(&content, "%s line %d\n", level, i)
+	}
+	assert.NoError(t, os.WriteFile(logFile, []byte(content.String()), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: logFile, Type: TypeFile}})
+
+	watcher, err := NewWatcher(logFile, "ERROR", "", false, "", "", "", "", "")
+	assert.NoError(t, err)
+	result, err := watcher.Scan(1, 10, false)
+	assert.NoError(t, err)
+	assert.Len(t, result.Lines, 5)
+
+	payload := AlertPayload{Name: "errors", FilePath: logFile, Type: TypeFile, Pattern: "ERROR", Lines: result.Lines}
+	err = payload.ResolveContexts(watcher, AlertContextOptions{Before: 2, After: 1, MaxSnippets: 2, BaseURL: "https://gol.example.com/"})
+	assert.NoError(t, err)
+	assert.Len(t, payload.Contexts, 2)
+	assert.Equal(t, 3, payload.OmittedContexts)
+
+	first := payload.Contexts[0]
+	assert.Equal(t, 4, first.LineNumber)
+	assert.Equal(t, result.Lines[0].Anchor, first.Anchor)
+	assert.Equal(t, []string{"INFO line 2", "INFO line 3", "ERROR line 4", "INFO line 5"}, alertContextContents(first.Lines))
+	assert.Equal(t, 2, first.Lines[0].LineNumber)
+	assert.Equal(t, "https://gol.example.com/api/shares/"+first.ShareID, first.Link)
+	share, _, err := GlobalShares.Resolve(first.ShareID)
+	assert.NoError(t, err)
+	assert.Equal(t, Share{ID: first.ShareID, FilePath: logFile, Type: TypeFile, LineNumber: 4, CreatedAt: share.CreatedAt}, share)
+
+	// the last line of the file has no lines after it, matches past the end are omitted
+	payload = AlertPayload{FilePath: logFile, Type: TypeFile, Lines: []LineResult{{LineNumber: 20}, {LineNumber: 99}}}
+	assert.NoError(t, payload.ResolveContexts(watcher, AlertContextOptions{Before: 1, After: 3}))
+	assert.Len(t, payload.Contexts, 1)
+	assert.Equal(t, []string{"INFO line 19", "ERROR line 20"}, alertContextContents(payload.Contexts[0].Lines))
+	assert.NotEmpty(t, payload.Contexts[0].ShareID)
+	assert.Empty(t, payload.Contexts[0].Link)
+	assert.Equal(t, 1, payload.OmittedContexts)
+}
+
+func alertContextContents(lines []LineResult) []string {
+	contents := make([]string, 0, len(lines))
+	for _, line := range lines {
+		contents = append(contents, line.Content)
+	}
+	return contents
+}
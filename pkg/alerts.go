@@ -0,0 +1,436 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// DefaultAlertCooldown is how long a rule stays quiet after it triggered, when it does not say
+const DefaultAlertCooldown = 300 * time.Second
+
+// AlertRule is an -alert, or an entry of the alerts of the config file: the lines of the watched files
+// matching Pattern are POSTed to URL, at most once per Cooldown seconds. A rule of the config file may
+// send an email or run a command instead, or as well.
+type AlertRule struct {
+	Name     string                 `yaml:"name,omitempty" json:"name"`
+	Pattern  string                 `yaml:"pattern" json:"pattern"`
+	URL      string                 `yaml:"url,omitempty" json:"url,omitempty"`
+	Cooldown int                    `yaml:"cooldown,omitempty" json:"cooldown"`
+	Email    *EmailNotifierConfig   `yaml:"email,omitempty" json:"email,omitempty"`
+	Command  *CommandNotifierConfig `yaml:"command,omitempty" json:"command,omitempty"`
+}
+
+// ParseAlertRule parses an -alert, "pattern=/FATAL|panic/ url=https://hooks.example.com/... cooldown=300".
+// A pattern between slashes may contain spaces, name names the rule, its pattern when left out.
+func ParseAlertRule(s string) (AlertRule, error) {
+	rule := AlertRule{}
+	rest := strings.TrimSpace(s)
+	for rest != "" {
+		key, value, ok := strings.Cut(rest, "=")
+		if !ok || strings.ContainsFunc(key, unicode.IsSpace) {
+			return rule, fmt.Errorf("%q is not key=value", strings.Fields(rest)[0])
+		}
+		end := strings.IndexFunc(value, unicode.IsSpace)
+		if strings.HasPrefix(value, "/") {
+			// the closing slash is the one ending the value
+			end = -1
+			for i := 1; i < len(value); i++ {
+				if value[i] == '/' && (i == len(value)-1 || unicode.IsSpace(rune(value[i+1]))) {
+					end = i + 1
+					break
+				}
+			}
+			if end < 0 {
+				return rule, fmt.Errorf("%s: missing the closing / of %s", key, value)
+			}
+		}
+		if end < 0 {
+			end = len(value)
+		}
+		rest = strings.TrimSpace(value[end:])
+		value = value[:end]
+		switch key {
+		case "pattern":
+			if len(value) >= 2 && strings.HasPrefix(value, "/") && strings.HasSuffix(value, "/") {
+				value = value[1 : len(value)-1]
+			}
+			rule.Pattern = value
+		case "url":
+			rule.URL = value
+		case "name":
+			rule.Name = value
+		case "cooldown":
+			cooldown, err := strconv.Atoi(value)
+			if err != nil {
+				return rule, fmt.Errorf("cooldown %q must be a number of seconds", value)
+			}
+			rule.Cooldown = cooldown
+		default:
+			return rule, fmt.Errorf("unknown key %q, one of pattern, url, cooldown, name", key)
+		}
+	}
+	// an -alert has no email or command to deliver to
+	if rule.URL == "" {
+		return rule, errors.New("url is required")
+	}
+	return rule, rule.Validate()
+}
+
+// Validate requires a pattern that compiles and at least one of an http(s) URL, an email and a
+// command, all of them valid
+func (r AlertRule) Validate() error {
+	if r.Pattern == "" {
+		return errors.New("pattern is required")
+	}
+	if r.URL == "" && r.Email == nil && r.Command == nil {
+		return errors.New("one of url, email and command is required")
+	}
+	if _, err := regexp.Compile(r.Pattern); err != nil {
+		return fmt.Errorf("pattern: %w", err)
+	}
+	if _, err := r.notifiers(nil); err != nil {
+		return err
+	}
+	if r.Cooldown < 0 {
+		return errors.New("cooldown must not be negative")
+	}
+	return nil
+}
+
+// notifiers are the webhook, email and command notifiers of the rule, named after it unless they
+// have a name of their own
+func (r AlertRule) notifiers(client *http.Client) ([]Notifier, error) {
+	notifiers := []Notifier{}
+	if r.URL != "" {
+		webhook, err := NewWebhookNotifier(r.Name, r.URL, client)
+		if err != nil {
+			return nil, err
+		}
+		notifiers = append(notifiers, webhook)
+	}
+	if r.Email != nil {
+		config := *r.Email
+		if config.Name == "" && r.Name != "" {
+			config.Name = r.Name + ":" + NotifierTypeEmail
+		}
+		email, err := NewEmailNotifier(config)
+		if err != nil {
+			return nil, fmt.Errorf("email: %w", err)
+		}
+		notifiers = append(notifiers, email)
+	}
+	if r.Command != nil {
+		config := *r.Command
+		if config.Name == "" && r.Name != "" {
+			config.Name = r.Name + ":" + NotifierTypeCommand
+		}
+		command, err := NewCommandNotifier(config)
+		if err != nil {
+			return nil, fmt.Errorf("command: %w", err)
+		}
+		notifiers = append(notifiers, command)
+	}
+	return notifiers, nil
+}
+
+// AlertRuleStatus is the state of a rule, served by the alert status API
+type AlertRuleStatus struct {
+	Name    string `json:"name"`
+	Pattern string `json:"pattern"`
+	// URL is the webhook without its path, which holds the secret of most hooks
+	URL string `json:"url,omitempty"`
+	// Notifiers are the names of the notifiers of the rule, their deliveries are under notifiers
+	Notifiers []string `json:"notifiers"`
+	Cooldown  int      `json:"cooldown"`
+	Triggers  int      `json:"triggers"`
+	// Suppressed are the matches within the cooldown of a trigger, not delivered
+	Suppressed        int        `json:"suppressed"`
+	LastTriggerAt     *time.Time `json:"last_trigger_at,omitempty"`
+	LastFilePath      string     `json:"last_file_path,omitempty"`
+	LastLineNumber    int        `json:"last_line_number,omitempty"`
+	LastDeliveredAt   *time.Time `json:"last_delivered_at,omitempty"`
+	LastDeliveryError string     `json:"last_delivery_error,omitempty"`
+}
+
+type alertRule struct {
+	rule      AlertRule
+	regexp    *regexp.Regexp
+	cooldown  time.Duration
+	notifiers []Notifier
+
+	mutex  sync.Mutex
+	status AlertRuleStatus
+}
+
+// trigger tells whether a match at now fires the rule, or falls within the cooldown of the last trigger
+func (r *alertRule) trigger(now time.Time, filePath string, lineNumber int) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if last := r.status.LastTriggerAt; last != nil && now.Sub(*last) < r.cooldown {
+		r.status.Suppressed++
+		return false
+	}
+	r.status.Triggers++
+	r.status.LastTriggerAt = &now
+	r.status.LastFilePath = filePath
+	r.status.LastLineNumber = lineNumber
+	return true
+}
+
+func (r *alertRule) delivered(err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err != nil {
+		r.status.LastDeliveryError = err.Error()
+		return
+	}
+	now := GlobalClock.Now()
+	r.status.LastDeliveredAt = &now
+	r.status.LastDeliveryError = ""
+}
+
+// Alerts checks the lines appended to the watched local files against the rules, following each file
+// with GlobalTailHub from its end: lines written before a file is followed are never matched. A match
+// is delivered to the notifiers of its rule in the background, retried with backoff, unless the rule
+// triggered less than its cooldown ago.
+type Alerts struct {
+	rules    []*alertRule
+	attempts int
+	backoff  time.Duration
+	// contexts bound the context snippets resolved for each notification
+	contexts AlertContextOptions
+
+	mutex   sync.Mutex
+	follows map[registryKey]context.CancelFunc
+	ctx     context.Context
+	cancel  context.CancelFunc
+	// deliveries are the notifications in flight, waited for by Close
+	deliveries sync.WaitGroup
+}
+
+// NewAlerts compiles the rules, posting with client, or a client of their own when nil
+func NewAlerts(rules []AlertRule, client *http.Client) (*Alerts, error) {
+	ctx, cancel := context.WithCancel(context.Background())
+	a := &Alerts{
+		attempts: defaultNotifyAttempts,
+		backoff:  defaultNotifyBackoff,
+		follows:  map[registryKey]context.CancelFunc{},
+		ctx:      ctx,
+		cancel:   cancel,
+	}
+	for i, rule := range rules {
+		if err := rule.Validate(); err != nil {
+			cancel()
+			return nil, fmt.Errorf("alert %d: %w", i+1, err)
+		}
+		if rule.Name == "" {
+			rule.Name = rule.Pattern
+		}
+		cooldown := DefaultAlertCooldown
+		if rule.Cooldown > 0 {
+			cooldown = time.Duration(rule.Cooldown) * time.Second
+		}
+		notifiers, err := rule.notifiers(client)
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("alert %d: %w", i+1, err)
+		}
+		names := make([]string, 0, len(notifiers))
+		for _, notifier := range notifiers {
+			names = append(names, notifier.Name())
+		}
+		a.rules = append(a.rules, &alertRule{
+			rule:      rule,
+			regexp:    regexp.MustCompile(rule.Pattern),
+			cooldown:  cooldown,
+			notifiers: notifiers,
+			status: AlertRuleStatus{
+				Name:      rule.Name,
+				Pattern:   rule.Pattern,
+				URL:       redactURL(rule.URL),
+				Notifiers: names,
+				Cooldown:  int(cooldown / time.Second),
+			},
+		})
+	}
+	return a, nil
+}
+
+// SetContextOptions sets the bounds of the context snippets and the URL of their links, before Run
+func (a *Alerts) SetContextOptions(options AlertContextOptions) {
+	a.contexts = options
+}
+
+// Run follows the local files of the file list, and those listed later, until Close
+func (a *Alerts) Run() {
+	defer a.unfollowAll()
+	for {
+		events := GlobalFileRegistry.Subscribe()
+		a.sync(GlobalFileRegistry.Snapshot())
+		for open := true; open; {
+			select {
+			case <-a.ctx.Done():
+				GlobalFileRegistry.Unsubscribe(events)
+				return
+			case _, open = <-events:
+				if open {
+					a.sync(GlobalFileRegistry.Snapshot())
+				}
+			}
+		}
+		// dropped for lagging behind, the snapshot tells the changes missed
+	}
+}
+
+// sync follows the local files of fileInfos not followed yet and stops following the others
+func (a *Alerts) sync(fileInfos []FileInfo) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.ctx.Err() != nil {
+		return
+	}
+	listed := map[registryKey]bool{}
+	for _, fileInfo := range fileInfos {
+		if !isLocalFile(fileInfo.Type, fileInfo.FilePath) {
+			continue
+		}
+		key := newRegistryKey(fileInfo)
+		listed[key] = true
+		if _, ok := a.follows[key]; ok {
+			continue
+		}
+		events, unsubscribe, err := GlobalTailHub.Subscribe(fileInfo.FilePath)
+		if err != nil {
+			slog.Warn("following file for alerts", "path", fileInfo.FilePath, "error", err)
+			continue
+		}
+		ctx, cancel := context.WithCancel(a.ctx)
+		a.follows[key] = cancel
+		go a.follow(ctx, fileInfo, events, unsubscribe)
+	}
+	for key, cancel := range a.follows {
+		if !listed[key] {
+			cancel()
+			delete(a.follows, key)
+		}
+	}
+}
+
+// follow matches the lines of a followed file until ctx is done. A follower dropped for lagging
+// behind follows the file again from its end.
+func (a *Alerts) follow(ctx context.Context, fileInfo FileInfo, events <-chan TailEvent, unsubscribe func()) {
+	defer func() { unsubscribe() }()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				slog.Warn("alerts fell behind the lines of a file, following it again from its end", "path", fileInfo.FilePath)
+				unsubscribe()
+				var err error
+				if events, unsubscribe, err = GlobalTailHub.Subscribe(fileInfo.FilePath); err != nil {
+					slog.Warn("following file for alerts", "path", fileInfo.FilePath, "error", err)
+					unsubscribe = func() {}
+					<-ctx.Done()
+					return
+				}
+				continue
+			}
+			if event.Type == TailEventLine {
+				a.match(fileInfo, event)
+			}
+		}
+	}
+}
+
+// match fires the rules the line matches
+func (a *Alerts) match(fileInfo FileInfo, event TailEvent) {
+	for _, rule := range a.rules {
+		if !rule.regexp.MatchString(event.Content) {
+			continue
+		}
+		now := GlobalClock.Now()
+		if !rule.trigger(now, fileInfo.FilePath, event.LineNumber) {
+			continue
+		}
+		payload := AlertPayload{
+			Name:        rule.rule.Name,
+			FilePath:    fileInfo.FilePath,
+			Host:        fileInfo.Host,
+			Type:        fileInfo.Type,
+			Pattern:     rule.rule.Pattern,
+			Lines:       []LineResult{{LineNumber: event.LineNumber, Content: event.Content}},
+			TriggeredAt: now,
+		}
+		a.mutex.Lock()
+		if a.ctx.Err() != nil {
+			a.mutex.Unlock()
+			return
+		}
+		a.deliveries.Add(1)
+		a.mutex.Unlock()
+		go func(rule *alertRule) {
+			defer a.deliveries.Done()
+			a.resolveContexts(&payload)
+			rule.delivered(NotifyAll(a.ctx, rule.notifiers, payload, a.attempts, a.backoff, GlobalNotifierStatuses))
+		}(rule)
+	}
+}
+
+// resolveContexts adds the context snippets to payload, which is sent without them when they cannot be read
+func (a *Alerts) resolveContexts(payload *AlertPayload) {
+	watcher, err := NewWatcher(payload.FilePath, payload.Pattern, "", false, "", "", "", "", "")
+	if err == nil {
+		err = payload.ResolveContexts(watcher, a.contexts)
+	}
+	if err != nil {
+		slog.Warn("resolving alert contexts", "name", payload.Name, "path", payload.FilePath, "error", err)
+	}
+}
+
+func (a *Alerts) unfollowAll() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.unfollowAllLocked()
+}
+
+func (a *Alerts) unfollowAllLocked() {
+	for key, cancel := range a.follows {
+		cancel()
+		delete(a.follows, key)
+	}
+}
+
+// Statuses are the states of the rules, sorted by name
+func (a *Alerts) Statuses() []AlertRuleStatus {
+	statuses := make([]AlertRuleStatus, 0, len(a.rules))
+	for _, rule := range a.rules {
+		rule.mutex.Lock()
+		statuses = append(statuses, rule.status)
+		rule.mutex.Unlock()
+	}
+	sort.SliceStable(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// Close stops following the files and waits for the deliveries in flight, which are cancelled
+func (a *Alerts) Close() {
+	a.mutex.Lock()
+	a.cancel()
+	a.unfollowAllLocked()
+	a.mutex.Unlock()
+	a.deliveries.Wait()
+}
@@ -0,0 +1,216 @@
+package pkg
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAlertRule(t *testing.T) {
+	rule, err := ParseAlertRule("pattern=/FATAL|panic: .*/ url=https://hooks.example.com/T0/B0/x cooldown=60")
+	assert.NoError(t, err)
+	assert.Equal(t, AlertRule{Pattern: "FATAL|panic: .*", URL: "https://hooks.example.com/T0/B0/x", Cooldown: 60}, rule)
+
+	rule, err = ParseAlertRule("  name=oom pattern=OutOfMemory url=http://localhost:9000/hook ")
+	assert.NoError(t, err)
+	assert.Equal(t, AlertRule{Name: "oom", Pattern: "OutOfMemory", URL: "http://localhost:9000/hook"}, rule)
+
+	for _, invalid := range []string{
+		"pattern=/FATAL",
+		"pattern=FATAL",
+		"url=https://hooks.example.com/x",
+		"pattern=/[/ url=https://hooks.example.com/x",
+		"pattern=FATAL url=ftp://hooks.example.com/x",
+		"pattern=FATAL url=https://hooks.example.com/x cooldown=5m",
+		"pattern=FATAL url=https://hooks.example.com/x every=5",
+		"FATAL https://hooks.example.com/x",
+	} {
+		_, err := ParseAlertRule(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// alertHook is a webhook recording the payloads posted to it, answering status
+type alertHook struct {
+	mutex    sync.Mutex
+	status   int
+	payloads []AlertPayload
+}
+
+func (h *alertHook) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	payload := AlertPayload{}
+	json.Unmarshal(body, &payload) //nolint: errcheck
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	h.payloads = append(h.payloads, payload)
+	w.WriteHeader(h.status)
+}
+
+func (h *alertHook) received() []AlertPayload {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return append([]AlertPayload{}, h.payloads...)
+}
+
+func TestAlerts(t *testing.T) {
+	defer func(clock Clock) { GlobalClock = clock }(GlobalClock)
+	clock := NewManualClock(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))
+	GlobalClock = clock
+	defer func(hub *TailHub) { GlobalTailHub = hub }(GlobalTailHub)
+	GlobalTailHub = NewTailHub(10 * time.Millisecond)
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+
+	hook := &alertHook{status: http.StatusOK}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+	filePath := filepath.Join(t.TempDir(), "app.log")
+	// history is never matched
+	assert.NoError(t, os.WriteFile(filePath, []byte("FATAL before gol\n"), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: filePath, Type: TypeFile}, {FilePath: "app.log", Host: "web1", Type: TypeSSH}})
+
+	alerts, err := NewAlerts([]AlertRule{
+		{Name: "fatal", Pattern: "FATAL|panic", URL: server.URL + "/hooks/secret", Cooldown: 60},
+		{Pattern: "disk full", URL: server.URL + "/hooks/disk"},
+	}, server.Client())
+	assert.NoError(t, err)
+	go alerts.Run()
+	defer alerts.Close()
+	assert.Eventually(t, func() bool { return GlobalTailHub.Len() == 1 }, 2*time.Second, 10*time.Millisecond)
+
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0600)
+	assert.NoError(t, err)
+	defer file.Close()
+	_, err = file.WriteString("INFO started\nFATAL out of memory\npanic: again\n")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return len(hook.received()) == 1 }, 2*time.Second, 10*time.Millisecond)
+	payload := hook.received()[0]
+	assert.Equal(t, "fatal", payload.Name)
+	assert.Equal(t, filePath, payload.FilePath)
+	assert.Equal(t, TypeFile, payload.Type)
+	assert.Equal(t, 3, payload.Lines[0].LineNumber)
+	assert.Equal(t, "FATAL out of memory", payload.Lines[0].Content)
+	assert.Equal(t, clock.Now(), payload.TriggeredAt)
+
+	// within the cooldown matches are counted, not delivered
+	assert.Eventually(t, func() bool {
+		statuses := alerts.Statuses()
+		return statuses[1].Suppressed == 1 && statuses[1].LastDeliveredAt != nil
+	}, 2*time.Second, 10*time.Millisecond)
+	status := alerts.Statuses()[1]
+	assert.Equal(t, "fatal", status.Name)
+	assert.Equal(t, 1, status.Triggers)
+	assert.Equal(t, 60, status.Cooldown)
+	assert.Equal(t, server.URL+"/REDACTED", status.URL)
+	assert.Equal(t, []string{"fatal"}, status.Notifiers)
+	assert.Equal(t, 3, status.LastLineNumber)
+	assert.Equal(t, DefaultAlertCooldown/time.Second, time.Duration(alerts.Statuses()[0].Cooldown))
+
+	// after the cooldown the rule fires again, a failed delivery is retried and reported
+	clock.Advance(time.Minute)
+	hook.mutex.Lock()
+	hook.status = http.StatusInternalServerError
+	hook.mutex.Unlock()
+	alerts.backoff = time.Millisecond
+	_, err = file.WriteString("FATAL disk full\n")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool {
+		statuses := alerts.Statuses()
+		return statuses[0].LastDeliveryError != "" && statuses[1].LastDeliveryError != ""
+	}, 5*time.Second, 10*time.Millisecond)
+	assert.Len(t, hook.received(), 1+2*defaultNotifyAttempts)
+
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	defer func(alerts *Alerts) { GlobalAlerts = alerts }(GlobalAlerts)
+	GlobalAlerts = alerts
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/alerts/status", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	response := AlertsStatusResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Len(t, response.Rules, 2)
+	assert.Equal(t, "webhook answered 500 Internal Server Error", response.Rules[1].LastDeliveryError)
+	assert.Equal(t, 2, response.Rules[1].Triggers)
+	assert.NotContains(t, rec.Body.String(), "secret")
+
+	// files no longer listed are no longer followed
+	GlobalFileRegistry.Replace(nil)
+	assert.Eventually(t, func() bool { return GlobalTailHub.Len() == 0 }, 2*time.Second, 10*time.Millisecond)
+}
+
+func TestAlerts_Notifiers(t *testing.T) {
+	defer func(hub *TailHub) { GlobalTailHub = hub }(GlobalTailHub)
+	GlobalTailHub = NewTailHub(10 * time.Millisecond)
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	defer func(statuses *NotifierStatuses) { GlobalNotifierStatuses = statuses }(GlobalNotifierStatuses)
+	GlobalNotifierStatuses = NewNotifierStatuses()
+
+	hook := &alertHook{status: http.StatusOK}
+	server := httptest.NewServer(hook)
+	defer server.Close()
+	dir := t.TempDir()
+	filePath := filepath.Join(dir, "app.log")
+	assert.NoError(t, os.WriteFile(filePath, nil, 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: filePath, Type: TypeFile}})
+
+	// the command of a rule gets the payload on stdin, besides its webhook
+	out := filepath.Join(dir, "payload.json")
+	alerts, err := NewAlerts([]AlertRule{{
+		Name:    "fatal",
+		Pattern: "FATAL",
+		URL:     server.URL + "/hooks/secret",
+		Command: &CommandNotifierConfig{Path: "/bin/sh", Args: []string{"-c", `cat > "$0"`, out}},
+	}}, server.Client())
+	assert.NoError(t, err)
+	alerts.SetContextOptions(AlertContextOptions{BaseURL: "https://gol.example.com"})
+	go alerts.Run()
+	defer alerts.Close()
+	assert.Eventually(t, func() bool { return GlobalTailHub.Len() == 1 }, 2*time.Second, 10*time.Millisecond)
+
+	file, err := os.OpenFile(filePath, os.O_APPEND|os.O_WRONLY, 0600)
+	assert.NoError(t, err)
+	defer file.Close()
+	_, err = file.WriteString("FATAL out of memory\n")
+	assert.NoError(t, err)
+	assert.Eventually(t, func() bool { return alerts.Statuses()[0].LastDeliveredAt != nil }, 5*time.Second, 10*time.Millisecond)
+	assert.Len(t, hook.received(), 1)
+	b, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	var payload AlertPayload
+	assert.NoError(t, json.Unmarshal(b, &payload))
+	assert.Equal(t, "FATAL out of memory", payload.Lines[0].Content)
+	// the payload carries the matched line in its context, with a share link of it
+	if assert.Len(t, payload.Contexts, 1) {
+		assert.Equal(t, []string{"FATAL out of memory"}, alertContextContents(payload.Contexts[0].Lines))
+		assert.Equal(t, "https://gol.example.com/api/shares/"+payload.Contexts[0].ShareID, payload.Contexts[0].Link)
+	}
+	assert.Zero(t, payload.OmittedContexts)
+
+	statuses := GlobalNotifierStatuses.List()
+	if assert.Len(t, statuses, 2) {
+		assert.Equal(t, NotifierStatus{Name: "fatal", Type: NotifierTypeWebhook, Attempts: 1}, NotifierStatus{Name: statuses[0].Name, Type: statuses[0].Type, Attempts: statuses[0].Attempts})
+		assert.Equal(t, NotifierStatus{Name: "fatal:command", Type: NotifierTypeCommand, Attempts: 1}, NotifierStatus{Name: statuses[1].Name, Type: statuses[1].Type, Attempts: statuses[1].Attempts})
+	}
+
+	// a rule of the config file may have a command without a webhook
+	rule := AlertRule{Name: "oom", Pattern: "OutOfMemory", Command: &CommandNotifierConfig{Path: "/bin/true"}}
+	commandOnly, err := NewAlerts([]AlertRule{rule}, nil)
+	assert.NoError(t, err)
+	defer commandOnly.Close()
+	assert.Empty(t, commandOnly.Statuses()[0].URL)
+	assert.Equal(t, []string{"oom:command"}, commandOnly.Statuses()[0].Notifiers)
+	_, err = NewAlerts([]AlertRule{{Pattern: "OutOfMemory"}}, nil)
+	assert.ErrorContains(t, err, "alert 1: one of url, email and command is required")
+
+	// a notifier of the rule that cannot be built is an error of the rule
+	_, err = NewAlerts([]AlertRule{{Pattern: "FATAL", URL: server.URL, Email: &EmailNotifierConfig{Host: "smtp.example.com"}}}, nil)
+	assert.ErrorContains(t, err, "alert 1: email: email notifier requires host, from and to")
+}
@@ -0,0 +1,194 @@
+package pkg
+
+import (
+	"bufio"
+	"fmt"
+	"hash/fnv"
+	"io"
+	"os"
+
+	"github.com/acarl005/stripansi"
+)
+
+const (
+	// lines searched around the remembered line number before falling back to a bounded scan
+	anchorNearWindow = 5000
+	// upper bound of lines scanned when the line moved far away
+	anchorMaxScanLines = 5000000
+)
+
+// Anchor is a rotation stable identity of a line: a short hash of the line and its neighbors,
+// the rotation generation of the file and the line number it was last seen at (a search hint)
+type Anchor struct {
+	Generation int
+	Hash       uint32
+	LineNumber int
+}
+
+func (a Anchor) String() string {
+	return fmt.Sprintf("g%d-%08x-%d", a.Generation, a.Hash, a.LineNumber)
+}
+
+// ParseAnchor parses the output of Anchor.String
+func ParseAnchor(s string) (Anchor, error) {
+	var a Anchor
+	n, err := fmt.Sscanf(s, "g%d-%08x-%d", &a.Generation, &a.Hash, &a.LineNumber)
+	if err != nil || n != 3 || a.LineNumber < 1 {
+		return a, fmt.Errorf("invalid anchor %q", s)
+	}
+	return a, nil
+}
+
+const (
+	fnvOffset32 = 2166136261
+	fnvPrime32  = 16777619
+)
+
+// lineHash is the per line hash that anchors are built from, the FNV-1a of hash/fnv
+// computed inline as it runs for every line scanned
+func lineHash[T string | []byte](line T) uint32 {
+	h := uint32(fnvOffset32)
+	for i := 0; i < len(line); i++ {
+		h ^= uint32(line[i])
+		h *= fnvPrime32
+	}
+	return h
+}
+
+// anchorHash combines the hashes of the previous, current and next lines
+func anchorHash(prev, current, next uint32) uint32 {
+	h := fnv.New32a()
+	for _, v := range []uint32{prev, current, next} {
+		h.Write([]byte{byte(v >> 24), byte(v >> 16), byte(v >> 8), byte(v)}) //nolint: errcheck
+	}
+	return h.Sum32()
+}
+
+// AnchorResult is where an anchor was found
+type AnchorResult struct {
+	FilePath   string `json:"file_path"`
+	Host       string `json:"host"`
+	Type       string `json:"type"`
+	LineNumber int    `json:"line_number"`
+	Anchor     string `json:"anchor"`
+	Rotated    bool   `json:"rotated"`
+}
+
+// LocateAnchor finds the line identified by the anchor in the watched file, or in its rotated sibling.
+// ok is false when the line genuinely no longer exists.
+func (w *Watcher) LocateAnchor(anchor Anchor) (*AnchorResult, bool, error) {
+	lineNumber, err := w.locateAnchorInFile(w.filePath, anchor)
+	if err != nil {
+		return nil, false, err
+	}
+	if lineNumber > 0 {
+		return &AnchorResult{
+			FilePath:   w.filePath,
+			LineNumber: lineNumber,
+			Anchor:     Anchor{Generation: FileGeneration(w.filePath), Hash: anchor.Hash, LineNumber: lineNumber}.String(),
+		}, true, nil
+	}
+
+	// the line may have been rotated out, e.g. app.log -> app.log.1
+	if w.isRemote {
+		return nil, false, nil
+	}
+	rotated := w.filePath + ".1"
+	if _, err := os.Stat(rotated); err != nil {
+		return nil, false, nil
+	}
+	lineNumber, err = w.locateAnchorInFile(rotated, anchor)
+	if err != nil || lineNumber == 0 {
+		return nil, false, err
+	}
+	return &AnchorResult{
+		FilePath:   rotated,
+		LineNumber: lineNumber,
+		Anchor:     Anchor{Generation: FileGeneration(rotated), Hash: anchor.Hash, LineNumber: lineNumber}.String(),
+		Rotated:    true,
+	}, true, nil
+}
+
+func (w *Watcher) locateAnchorInFile(filePath string, anchor Anchor) (int, error) {
+	// near the remembered line first, seeking with the stats cache checkpoints when possible
+	if !w.isRemote {
+		from := anchor.LineNumber - anchorNearWindow
+		if file, startLine, ok := seekToLine(filePath, from); ok {
+			lineNumber, err := scanForAnchor(file, startLine, anchor.LineNumber+anchorNearWindow, anchor.Hash)
+			file.Close()
+			if err != nil || lineNumber > 0 {
+				return lineNumber, err
+			}
+		}
+	}
+
+	file, scanner, err := w.openScanner(filePath)
+	if err != nil {
+		return 0, err
+	}
+	if file != nil {
+		defer file.Close()
+	}
+	return scanLinesForAnchor(scanner, 1, anchorMaxScanLines, anchor.Hash)
+}
+
+// seekToLine opens a plain local file positioned at the closest cached checkpoint before line.
+// It returns the line number the file is positioned at.
+func seekToLine(filePath string, line int) (File, int, bool) {
+	if _, ok := GlobalFileStatsCache.Peek(filePath); !ok {
+		return nil, 0, false
+	}
+	file, err := openFile(OSFileOpener{}, filePath)
+	if err != nil {
+		return nil, 0, false
+	}
+	// checkpoints are offsets in the decoded stream, only plain UTF-8 files can seek to them
+	buffer := make([]byte, 512)
+	n, _ := file.ReadAt(buffer, 0)
+	if IsCompressed(buffer[:n]) || DetectEncoding(buffer[:n]) != EncodingUTF8 {
+		file.Close()
+		return nil, 0, false
+	}
+
+	startLine, offset := checkpointBefore(filePath, line)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, 0, false
+	}
+	return file, startLine, true
+}
+
+func scanForAnchor(file File, startLine int, endLine int, hash uint32) (int, error) {
+	scanner := bufio.NewScanner(file)
+	buf := make([]byte, 1024*1024)
+	scanner.Buffer(buf, len(buf))
+	return scanLinesForAnchor(scanner, startLine, endLine-startLine+1, hash)
+}
+
+// scanLinesForAnchor reads at most maxLines lines and returns the line number whose anchor hash matches
+func scanLinesForAnchor(scanner *bufio.Scanner, startLine int, maxLines int, hash uint32) (int, error) {
+	var prev, current uint32
+	lineNumber := startLine - 1
+	read := 0
+	for scanner.Scan() {
+		next := lineHash(stripansi.Strip(scanner.Text()))
+		lineNumber++
+		read++
+		// the anchor of the line before this one is known now that its next neighbor was read
+		if read > 1 && anchorHash(prev, current, next) == hash {
+			return lineNumber - 1, nil
+		}
+		prev, current = current, next
+		if read >= maxLines {
+			return 0, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	// last line has no next neighbor
+	if read > 0 && anchorHash(prev, current, 0) == hash {
+		return lineNumber, nil
+	}
+	return 0, nil
+}
@@ -0,0 +1,58 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseAnchor(t *testing.T) {
+	anchor := Anchor{Generation: 2, Hash: 0xdeadbeef, LineNumber: 40532}
+	parsed, err := ParseAnchor(anchor.String())
+	assert.NoError(t, err)
+	assert.Equal(t, anchor, parsed)
+
+	_, err = ParseAnchor("40532")
+	assert.Error(t, err)
+}
+
+func TestWatcher_LocateAnchor(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+	content := "INFO one\nINFO two\nERROR three\nINFO four\n"
+	assert.NoError(t, os.WriteFile(logFile, []byte(content), 0600))
+
+	watcher, err := NewWatcher(logFile, "ERROR", "", false, "", "", "", "", "")
+	assert.NoError(t, err)
+	result, err := watcher.Scan(1, 10, false)
+	assert.NoError(t, err)
+	assert.Len(t, result.Lines, 1)
+	anchor, err := ParseAnchor(result.Lines[0].Anchor)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, anchor.LineNumber)
+
+	// the log grew at the top, the line moved
+	assert.NoError(t, os.WriteFile(logFile, []byte("INFO zero\nINFO zero again\n"+content), 0600))
+	found, ok, err := watcher.LocateAnchor(anchor)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 5, found.LineNumber)
+	assert.False(t, found.Rotated)
+
+	// rotated out to the sibling
+	assert.NoError(t, os.Rename(logFile, logFile+".1"))
+	assert.NoError(t, os.WriteFile(logFile, []byte("INFO fresh\n"), 0600))
+	found, ok, err = watcher.LocateAnchor(anchor)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.True(t, found.Rotated)
+	assert.Equal(t, logFile+".1", found.FilePath)
+
+	// genuinely gone
+	assert.NoError(t, os.Remove(logFile+".1"))
+	_, ok, err = watcher.LocateAnchor(anchor)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+}
@@ -8,10 +8,32 @@ func NewAPI() *API {
 }
 
 func (a *API) FindSSHConfig(host string) *SSHPathConfig {
-	for _, sshConfig := range GlobalPathSSHConfig {
+	for _, sshConfig := range SSHPathConfigs() {
 		if sshConfig.Host == host {
 			return &sshConfig
 		}
 	}
 	return nil
 }
+
+func (a *API) FindRemoteClient(host string) *RemoteClient {
+	for _, client := range GlobalRemoteClients {
+		if client.Label() == host {
+			return client
+		}
+	}
+	return nil
+}
+
+// ToSSHConfig returns the connection part of the path config
+func (c *SSHPathConfig) ToSSHConfig() *SSHConfig {
+	return &SSHConfig{
+		Host:           c.Host,
+		Port:           c.Port,
+		User:           c.User,
+		Password:       c.Password,
+		PrivateKeyPath: c.PrivateKeyPath,
+		StrictHostKey:  !c.Insecure,
+		KnownHostsPath: c.KnownHostsPath,
+	}
+}
@@ -1,8 +1,19 @@
 package pkg
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
 	"net/http"
+	"path/filepath"
+	"regexp"
 	"strings"
+	"time"
 
 	"github.com/labstack/echo/v4"
 	"github.com/mcuadros/go-defaults"
@@ -12,12 +23,53 @@ type APIHandler struct {
 	API *API
 }
 type FileInfo struct {
+	// ID is stable for the same path, host and type, requests can address the file by it
+	ID         string `json:"id,omitempty"`
 	FilePath   string `json:"file_path"`
 	LinesCount int    `json:"lines_count"`
 	FileSize   int64  `json:"file_size"`
 	Name       string `json:"name"`
 	Type       string `json:"type"`
 	Host       string `json:"host"`
+	Generation int    `json:"generation"`
+	// ModTime is when the file was last modified, unset when it could not be read, such as from a
+	// host without stat
+	ModTime *time.Time `json:"mod_time,omitempty"`
+	// Group is the section of the file list showing the file, the label of its pattern or else its
+	// source. It is shown only, files differing by group alone are the same file.
+	Group string `json:"group,omitempty"`
+	// Corrupt is why a damaged file, like a truncated gzip segment, could not be counted. It stays
+	// listed so that its rotation group shows it, the reads of the group skip it with a warning.
+	Corrupt string `json:"corrupt,omitempty"`
+	// Compression of a compressed file, gzip, zstd, bzip2 or xz, it is read decompressed
+	Compression string `json:"compression,omitempty"`
+	// Target is the file a symlink currently points to, the file is still listed and read by its link
+	Target string `json:"target,omitempty"`
+	// CanonicalPath is FilePath with every symlink resolved, set when they differ, FilePath is still shown
+	CanonicalPath string `json:"canonical_path,omitempty"`
+	// Aliases are the other paths of the same local file, listed once under FilePath
+	Aliases []string `json:"aliases,omitempty"`
+	// Warning is why a listed file cannot be read, such as a broken symlink
+	Warning string `json:"warning,omitempty"`
+	// Stale is a file of a remote peer that could not be listed, as it was last listed, Warning tells why
+	Stale bool `json:"stale,omitempty"`
+	// PeerType and PeerHost are the type and host of a remote file on its peer, they tell apart the
+	// files of a peer with the same path
+	PeerType string `json:"peer_type,omitempty"`
+	PeerHost string `json:"peer_host,omitempty"`
+	// Dropped is the number of lines piped to gol no longer kept, beyond -stdin-buffer, of stdin only
+	Dropped int64 `json:"dropped,omitempty"`
+	// Closed is stdin reaching its end, the lines piped stay viewable
+	Closed bool `json:"closed,omitempty"`
+	// Segments are the physical files of a rotation group, oldest first, set on the base file only
+	Segments []FileInfo `json:"segments,omitempty"`
+	// Defaults are the presentation defaults from the config file, request parameters override them
+	Defaults *ViewDefaults `json:"defaults,omitempty"`
+	// Hidden and Pinned are the curation of the file list, set by the file list API only
+	Hidden bool `json:"hidden,omitempty"`
+	Pinned bool `json:"pinned,omitempty"`
+	// Preview is the end of the file, set by the file list API when asked for
+	Preview *FilePreview `json:"preview,omitempty"`
 }
 
 func NewAPIHandler() *APIHandler {
@@ -29,12 +81,52 @@ func NewAPIHandler() *APIHandler {
 type APIRequest struct {
 	Query    string `json:"query" query:"query"`
 	Ignore   string `json:"ignore" query:"ignore"`
+	ID       string `json:"id" query:"id"`
 	FilePath string `json:"file_path" query:"file_path"`
 	Host     string `json:"host" query:"host"`
 	Type     string `json:"type" query:"type"`
 	Page     int    `json:"page" query:"page" default:"1" validate:"required,gte=1" message:"page >=1 is required"`
 	PerPage  int    `json:"per_page" query:"per_page" default:"15" validate:"required" message:"per_page is required"`
 	Reverse  bool   `json:"reverse" query:"reverse" default:"false"`
+	Logical  bool   `json:"logical" query:"logical" default:"false"`
+	// Sample keeps a fraction of the lines, SampleEvery every nth matching line
+	Sample      float64 `json:"sample" query:"sample"`
+	SampleEvery int     `json:"sample_every" query:"sample_every"`
+	// From and To (RFC 3339, or without an offset in Timezone) keep the lines of that time and route the
+	// query to the segments of the logical log covering it
+	From string `json:"from" query:"from"`
+	To   string `json:"to" query:"to"`
+	// Timezone (IANA) is of the times without an offset, the path default or the server's when missing
+	Timezone string `json:"tz" query:"tz"`
+	// Processor reads the lines with a registered line processor, the path default when missing
+	Processor string `json:"processor" query:"processor"`
+	// Fields (key=value) keep the lines whose processed fields match all of them
+	Fields []string `json:"field" query:"field"`
+	// Filters (key=value) keep the lines that are JSON objects with fields matching all of them,
+	// nested fields are addressed with dots, like http.status=500
+	Filters []string `json:"filter" query:"filter"`
+	// Levels (comma separated, like error,warn) keep the lines of these levels
+	Levels string `json:"levels" query:"levels"`
+	// Multiline groups lines into entries starting at lines matching MultilineStart, a timestamp at
+	// the start of the line when empty, the path default when neither is set
+	Multiline      bool   `json:"multiline" query:"multiline"`
+	MultilineStart string `json:"multiline_start" query:"multiline_start"`
+	// Tail returns the last lines of the file instead of a page, read from its end
+	Tail int `json:"tail" query:"tail" validate:"gte=0" message:"tail >=0 is required"`
+	// FollowRotation continues a tail longer than the file into the file it was last rotated to
+	FollowRotation bool `json:"follow_rotation" query:"follow_rotation"`
+	// Cursor is the next_cursor of a previous page, the page after it is returned instead of page
+	Cursor string `json:"cursor" query:"cursor"`
+	// Download streams every matching line instead of a page, as an attachment in Format, txt when missing
+	Download bool   `json:"download" query:"download"`
+	Format   string `json:"format" query:"format" validate:"omitempty,oneof=txt json csv" message:"format must be one of txt json csv"`
+	// Async spools the download to the data dir under an export job instead, answering its ID at once
+	Async bool `json:"async" query:"async"`
+}
+
+// ExportJobResult is the answer of an async download, GET api/exports/:id serves it once its job is done
+type ExportJobResult struct {
+	JobID string `json:"job_id"`
 }
 
 type APIResponse struct {
@@ -42,23 +134,112 @@ type APIResponse struct {
 	FilePaths []FileInfo `json:"file_paths"`
 }
 
+// writeAPIResponse answers response as c.JSON does, encoding the lines one at a time into the response
+// rather than the whole of it in memory first. Pretty responses are encoded by c.JSON.
+func writeAPIResponse(c echo.Context, response APIResponse) error {
+	if _, pretty := c.QueryParams()["pretty"]; pretty || c.Echo().Debug {
+		return c.JSON(http.StatusOK, response)
+	}
+	lines := response.Result.Lines
+	response.Result.Lines = nil
+	encoded, err := json.Marshal(response)
+	if err != nil {
+		return err
+	}
+	// strings are escaped, the first "lines":null is the field of the result
+	before, after, _ := bytes.Cut(encoded, []byte(`"lines":null`))
+
+	c.Response().Header().Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	c.Response().WriteHeader(http.StatusOK)
+	writer := bufio.NewWriter(c.Response())
+	writer.Write(before) //nolint: errcheck
+	if lines == nil {
+		writer.WriteString(`"lines":null`) //nolint: errcheck
+	} else {
+		writer.WriteString(`"lines":[`) //nolint: errcheck
+		for i := range lines {
+			if i > 0 {
+				writer.WriteByte(',') //nolint: errcheck
+			}
+			line, err := json.Marshal(&lines[i])
+			if err != nil {
+				return err
+			}
+			writer.Write(line) //nolint: errcheck
+		}
+		writer.WriteByte(']') //nolint: errcheck
+	}
+	writer.Write(after)    //nolint: errcheck
+	writer.WriteByte('\n') //nolint: errcheck
+	return writer.Flush()
+}
+
 func (h *APIHandler) Get(c echo.Context) error {
 	req := new(APIRequest)
 	if err := BindRequest(c, req); err != nil {
 		return echo.NewHTTPError(http.StatusUnprocessableEntity, err)
 	}
+	if err := resolveFileID(req.ID, &req.FilePath, &req.Host, &req.Type); err != nil {
+		return err
+	}
 	defaults.SetDefaults(req)
 	msgs, err := ValidateRequest(req)
 	if err != nil {
 		return echo.NewHTTPError(http.StatusUnprocessableEntity, msgs)
 	}
 
-	if len(GlobalFilePaths) == 0 {
+	var sampler *Sampler
+	if req.Sample != 0 || req.SampleEvery != 0 {
+		if sampler, err = NewSampler(req.Sample, req.SampleEvery); err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
+	}
+
+	if req.Tail > 0 && (req.Query != "" || req.Ignore != "" || sampler != nil || req.Processor != "" || len(req.Fields) > 0 || len(req.Filters) > 0 || req.Levels != "" || req.Logical || req.From != "" || req.To != "") {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "tail does not combine with query, ignore, sampling, processors, filters, levels or time ranges")
+	}
+	if req.FollowRotation && (req.Tail == 0 || req.Type != TypeFile) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "follow_rotation only applies to the tail of a local file")
+	}
+	if req.Format != "" && !req.Download {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "format only applies to downloads")
+	}
+	if req.Download && (req.Tail > 0 || req.Cursor != "") {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "download does not combine with tail or cursor")
+	}
+	if req.Async && (!req.Download || req.Type == TypeRemoteGol) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "async only applies to downloads of files of this gol")
+	}
+	var cursor *Cursor
+	if req.Cursor != "" {
+		if req.Tail > 0 || req.Reverse || sampler != nil || req.Logical || req.From != "" || req.To != "" {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "cursor does not combine with tail, reverse, sampling, logical logs or time ranges")
+		}
+		parsed, err := ParseCursor(req.Cursor)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
+		cursor = &parsed
+	}
+
+	// patterns over the max cost are rejected, or only tested against a sample of the lines
+	patternCost, patternErr := GlobalPatternLimits.Check(req.Query, req.Ignore)
+	if patternErr != nil {
+		if GlobalPatternLimits.Mode == PatternLimitReject {
+			return echo.NewHTTPError(http.StatusBadRequest, patternErr.Error())
+		}
+		if cursor != nil {
+			return echo.NewHTTPError(http.StatusBadRequest, patternErr.Error())
+		}
+		sampler = GlobalPatternLimits.Sampler(sampler)
+	}
+
+	if len(GlobalFileRegistry.Snapshot()) == 0 {
 		return echo.NewHTTPError(http.StatusNotFound, "filepath not found")
 	}
 
 	if req.FilePath == "" {
-		first := GlobalFilePaths[0]
+		first := GlobalFileRegistry.Snapshot()[0]
 		req.FilePath = first.FilePath
 		req.Host = first.Host
 		req.Type = first.Type
@@ -67,56 +248,747 @@ func (h *APIHandler) Get(c echo.Context) error {
 		return echo.NewHTTPError(http.StatusUnprocessableEntity, "type and host are required")
 	}
 
-	if !FilePathInGlobalFilePaths(req.FilePath) {
-		return echo.NewHTTPError(http.StatusNotFound, "file not found")
+	if err := AuthorizeFilePath(req.FilePath, req.Type, req.Host); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err)
+	}
+	// the configured order applies only when the request does not say
+	pathDefaults := GlobalPathDefaults.For(req.FilePath)
+	if pathDefaults != nil && pathDefaults.Order != "" && !c.QueryParams().Has("reverse") {
+		req.Reverse = pathDefaults.Order == OrderDesc
+	}
+	if req.Processor == "" && pathDefaults != nil {
+		req.Processor = pathDefaults.Processor
+	}
+	loc, err := timeLocation(req.Timezone, pathDefaults)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	from, to, err := parseTimeRangeIn(req.From, req.To, loc)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	// remote gol instances apply their own processors
+	var processor LineProcessor
+	if req.Processor != "" && req.Type != TypeRemoteGol {
+		var ok bool
+		if processor, ok = GlobalProcessors.Get(req.Processor); !ok {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("processor %q is not registered", req.Processor))
+		}
+	}
+	fieldFilters, err := ParseFieldFilters(req.Fields)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	if len(fieldFilters) > 0 && processor == nil && req.Type != TypeRemoteGol {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "field filters need a processor")
+	}
+	jsonFilters, err := ParseJSONFilters(req.Filters)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	levels, err := ParseLevels(req.Levels)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	// the configured grouping applies only when the request does not say
+	if req.MultilineStart == "" && pathDefaults != nil && pathDefaults.Multiline != "" && !c.QueryParams().Has("multiline") {
+		req.Multiline, req.MultilineStart = true, pathDefaults.Multiline
+	}
+	var multiline *regexp.Regexp
+	if req.Multiline || req.MultilineStart != "" {
+		if multiline, err = ParseMultilineStart(req.MultilineStart); err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
+		if cursor != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "cursor does not combine with multiline")
+		}
+		if req.FollowRotation {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "follow_rotation does not combine with multiline")
+		}
+	}
+
+	release, err := acquireRead(c, req.Type, req.Host, req.FilePath)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if req.Type == TypeRemoteGol {
+		if req.Download {
+			return h.proxyRemote(c, "api", req.Host, req.FilePath)
+		}
+		return h.getRemote(c, req.Host, req.FilePath)
 	}
 
 	var watcher *Watcher
 	if req.Type == TypeDocker {
 		if !strings.HasPrefix(req.FilePath, TmpContainerPath) {
-			result, err := ContainerLogsFromFile(req.Host, req.Query, req.Ignore, req.FilePath, req.Page, req.PerPage, req.Reverse)
+			if patternErr != nil {
+				return echo.NewHTTPError(http.StatusBadRequest, patternErr.Error())
+			}
+			if sampler != nil {
+				return echo.NewHTTPError(http.StatusUnprocessableEntity, "sampling is not supported for files inside containers")
+			}
+			if processor != nil {
+				return echo.NewHTTPError(http.StatusUnprocessableEntity, "processors are not supported for files inside containers")
+			}
+			if len(jsonFilters) > 0 || levels != nil {
+				return echo.NewHTTPError(http.StatusUnprocessableEntity, "filters and levels are not supported for files inside containers")
+			}
+			if multiline != nil {
+				return echo.NewHTTPError(http.StatusUnprocessableEntity, "multiline is not supported for files inside containers")
+			}
+			if !from.IsZero() || !to.IsZero() {
+				return echo.NewHTTPError(http.StatusUnprocessableEntity, "time ranges are not supported for files inside containers")
+			}
+			if req.Tail > 0 {
+				return echo.NewHTTPError(http.StatusUnprocessableEntity, "tail is not supported for files inside containers")
+			}
+			if cursor != nil {
+				return echo.NewHTTPError(http.StatusUnprocessableEntity, ErrCursorUnsupported.Error())
+			}
+			if req.Download {
+				return echo.NewHTTPError(http.StatusUnprocessableEntity, "download is not supported for files inside containers")
+			}
+			result, err := ContainerLogsFromFileContext(c.Request().Context(), req.Host, req.Query, req.Ignore, req.FilePath, req.Page, req.PerPage, req.Reverse)
+			if errors.Is(err, ErrContainerStopped) {
+				return echo.NewHTTPError(http.StatusConflict, err.Error())
+			}
 			if err != nil {
 				return echo.NewHTTPError(http.StatusInternalServerError, err)
 			}
 			result.Type = req.Type
-			return c.JSON(http.StatusOK, APIResponse{
+			result.SetSourceType(req.Type, req.Host)
+			return writeAPIResponse(c, APIResponse{
 				Result:    *result,
-				FilePaths: GlobalFilePaths,
+				FilePaths: GlobalFileRegistry.Snapshot(),
 			})
 		}
+	}
+	if watcher, err = h.newWatcher(req.Type, req.Host, req.FilePath, req.Query, req.Ignore); err != nil {
+		return err
+	}
+
+	if sampler != nil {
+		watcher.SetSampler(sampler)
+	}
+	if processor != nil && req.Tail == 0 {
+		watcher.SetProcessor(processor, fieldFilters)
+	}
+	if len(jsonFilters) > 0 {
+		watcher.SetJSONFilters(jsonFilters)
+	}
+	if levels != nil {
+		watcher.SetLevels(levels)
+	}
+	if multiline != nil {
+		watcher.SetMultiline(multiline)
+	}
+	if !from.IsZero() || !to.IsZero() {
+		watcher.SetTimeRange(TimeRange{From: from, To: to, Location: loc})
+	}
+	if req.FollowRotation {
+		watcher.SetFollowRotation(true)
+	}
 
-		watcher, err = NewWatcher(req.FilePath, req.Query, req.Ignore, false, "", "", "", "", "")
+	if req.Download {
+		return downloadLines(c, req, watcher, from, to)
+	}
+
+	var result *ScanResult
+	switch {
+	case cursor != nil:
+		result, err = watcher.ScanCursor(c.Request().Context(), cursor, req.PerPage)
+		if errors.Is(err, ErrCursorExpired) {
+			return echo.NewHTTPError(http.StatusConflict, ErrorCodeCursorExpired)
+		}
+		if errors.Is(err, ErrCursorUnsupported) {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
+	case req.Tail > 0:
+		result, err = watcher.Tail(c.Request().Context(), req.Tail)
+	case (!from.IsZero() || !to.IsZero()) && req.Type == TypeFile:
+		var resolution *TimeRangeResolution
+		if resolution, err = GlobalSegmentTimeRanges.Resolve(LogicalSegments(req.FilePath), from, to); err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		result, err = watcher.ScanSegments(resolution.FilePaths(), req.Page, req.PerPage, req.Reverse)
+		if err == nil {
+			result.TimeRange = resolution
+			result.Warnings = append(resolution.Warnings, result.Warnings...)
+		}
+	case req.Logical && req.Type == TypeFile:
+		result, err = watcher.ScanSegments(LogicalSegments(req.FilePath), req.Page, req.PerPage, req.Reverse)
+	default:
+		result, err = watcher.ScanContext(c.Request().Context(), req.Page, req.PerPage, req.Reverse)
+		if err == nil && !req.Reverse && sampler == nil && multiline == nil && from.IsZero() && to.IsZero() && len(result.Lines) > 0 {
+			// pages after this one are read from the cursor, not shifted by lines appended meanwhile
+			last := result.Lines[len(result.Lines)-1].LineNumber
+			if result.NextCursor, err = watcher.CursorAfter(c.Request().Context(), last); errors.Is(err, ErrCursorUnsupported) {
+				err = nil
+			}
+		}
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	result.Type = req.Type
+	result.Host = req.Host
+	result.SetSourceType(req.Type, req.Host)
+	if patternErr != nil {
+		result.PatternLimited = true
+		result.PatternCost = &patternCost
+	}
+
+	return writeAPIResponse(c, APIResponse{
+		Result:    *result,
+		FilePaths: GlobalFileRegistry.Snapshot(),
+	})
+}
+
+// downloadLines streams every line of the read asked for as an attachment, over the segments of its time
+// range or logical log like a page of it. It is cut after GlobalExportMaxLines lines, so that a pattern
+// matching everything cannot stream without end. An error before the first bytes are sent is answered
+// as such, the download is cut short after. An async download is spooled by an export job instead.
+func downloadLines(c echo.Context, req *APIRequest, watcher *Watcher, from, to time.Time) error {
+	filePaths := []string{req.FilePath}
+	switch {
+	case (!from.IsZero() || !to.IsZero()) && req.Type == TypeFile:
+		resolution, err := GlobalSegmentTimeRanges.Resolve(LogicalSegments(req.FilePath), from, to)
 		if err != nil {
 			return echo.NewHTTPError(http.StatusInternalServerError, err)
 		}
+		filePaths = resolution.FilePaths()
+	case req.Logical && req.Type == TypeFile:
+		filePaths = LogicalSegments(req.FilePath)
+	}
+	if req.Format == "" {
+		req.Format = ExportFormatTxt
+	}
+
+	fileName := filepath.Base(req.FilePath)
+	fileName = fmt.Sprintf("%s.export.%s", strings.TrimSuffix(fileName, filepath.Ext(fileName)), req.Format)
+	if req.Async {
+		return spoolLines(c, req, watcher, filePaths, fileName)
+	}
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, exportContentTypes[req.Format])
+	header.Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", fileName))
+	writer := NewExportWriter(c.Response(), req.Format)
+	truncated, warnings, err := watcher.Export(c.Request().Context(), filePaths, GlobalExportMaxLines, writer.Write)
+	if err == nil {
+		err = writer.Close(truncated, GlobalExportMaxLines)
+	}
+	if err != nil && !c.Response().Committed {
+		header.Del(echo.HeaderContentDisposition)
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if err != nil {
+		slog.Error("downloading lines", "filePath", req.FilePath, "error", err)
+	}
+	for _, warning := range warnings {
+		slog.Warn("download skipped a segment", "filePath", warning.FilePath, "error", warning.Error)
+	}
+	return nil
+}
+
+// spoolLines starts an export job writing the download to the data dir and answers its ID. The job
+// takes a read slot of the file of its own, the one of the request is released when it is answered.
+func spoolLines(c echo.Context, req *APIRequest, watcher *Watcher, filePaths []string, fileName string) error {
+	exportKey := "api?" + c.QueryParams().Encode()
+	id, err := GlobalExports.SpoolAsync(exportKey, req.FilePath, fileName, exportContentTypes[req.Format], func(ctx context.Context, w io.Writer) error {
+		release, err := GlobalReadLimiter.AcquireRead(ctx, req.Type, req.Host, req.FilePath)
+		if err != nil {
+			return err
+		}
+		defer release()
+		writer := NewExportWriter(w, req.Format)
+		truncated, warnings, err := watcher.Export(ctx, filePaths, GlobalExportMaxLines, writer.Write)
+		for _, warning := range warnings {
+			slog.Warn("download skipped a segment", "filePath", warning.FilePath, "error", warning.Error)
+		}
+		if err != nil {
+			return err
+		}
+		return writer.Close(truncated, GlobalExportMaxLines)
+	})
+	if errors.Is(err, ErrNoDataDir) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusAccepted, ExportJobResult{JobID: id})
+}
+
+type BytesRequest struct {
+	Query    string `json:"query" query:"query"`
+	ID       string `json:"id" query:"id"`
+	FilePath string `json:"file_path" query:"file_path" validate:"required" message:"file_path is required"`
+	Host     string `json:"host" query:"host"`
+	Type     string `json:"type" query:"type" validate:"required" message:"type is required"`
+	Offset   int64  `json:"offset" query:"offset" default:"0" validate:"gte=0" message:"offset >=0 is required"`
+	Length   int64  `json:"length" query:"length" default:"65536" validate:"required,gte=1" message:"length >=1 is required"`
+}
+
+// GetBytes serves a byte window of a file, for files without a sane line structure
+func (h *APIHandler) GetBytes(c echo.Context) error {
+	req := new(BytesRequest)
+	if err := BindRequest(c, req); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err)
+	}
+	if err := resolveFileID(req.ID, &req.FilePath, &req.Host, &req.Type); err != nil {
+		return err
+	}
+	defaults.SetDefaults(req)
+	msgs, err := ValidateRequest(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, msgs)
+	}
+
+	if err := AuthorizeFilePath(req.FilePath, req.Type, req.Host); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err)
 	}
 
-	if req.Type == TypeSSH {
+	release, err := acquireRead(c, req.Type, req.Host, req.FilePath)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var result *ByteWindowResult
+	switch req.Type {
+	case TypeRemoteGol:
+		return h.proxyRemote(c, "api/bytes", req.Host, req.FilePath)
+	case TypeSSH:
 		sshConfig := h.API.FindSSHConfig(req.Host)
 		if sshConfig == nil {
 			return echo.NewHTTPError(http.StatusNotFound, "ssh config not found")
 		}
-		watcher, err = NewWatcher(req.FilePath, req.Query, req.Ignore, true, sshConfig.Host, sshConfig.Port, sshConfig.User, sshConfig.Password, sshConfig.PrivateKeyPath)
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		result, err = ReadByteWindow(req.FilePath, req.Offset, req.Length, req.Query, true, sshConfig.ToSSHConfig())
+	case TypeFile, TypeStdin, TypeK8s, TypeJournal, TypeHTTP, TypeS3:
+		result, err = ReadByteWindow(req.FilePath, req.Offset, req.Length, req.Query, false, nil)
+	case TypeDocker:
+		if !strings.HasPrefix(req.FilePath, TmpContainerPath) {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "byte window is not supported for files inside containers")
 		}
+		result, err = ReadByteWindow(req.FilePath, req.Offset, req.Length, req.Query, false, nil)
+	default:
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "unknown type")
 	}
-	if req.Type == TypeFile || req.Type == TypeStdin {
-		watcher, err = NewWatcher(req.FilePath, req.Query, req.Ignore, false, "", "", "", "", "")
-		if err != nil {
-			return echo.NewHTTPError(http.StatusInternalServerError, err)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	result.Type = req.Type
+	result.Host = req.Host
+
+	return c.JSON(http.StatusOK, result)
+}
+
+// GetFiles lists the watched files, filtered and optionally grouped server side
+func (h *APIHandler) GetFiles(c echo.Context) error {
+	req := new(FileListRequest)
+	if err := BindRequest(c, req); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err)
+	}
+	msgs, err := ValidateRequest(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, msgs)
+	}
+
+	filtered := SortFileList(FilterFileInfos(GlobalFileRegistry.Snapshot(), req), req.Sort, req.Order)
+	curated := GlobalFileCuration.Apply(filtered, req.IncludeHidden)
+	// only the files of the page are previewed
+	filePaths := PageFileInfos(curated, req.Limit, req.Offset)
+	if req.Preview != "" && req.Preview != "false" {
+		GlobalPreviews.Add(c.Request().Context(), filePaths, req.Preview == PreviewAll, h.API.FindSSHConfig)
+	}
+	return c.JSON(http.StatusOK, FileListResponse{
+		FilePaths: filePaths,
+		Groups:    GroupFileInfos(filePaths, req.GroupBy),
+		Total:     len(curated),
+	})
+}
+
+type AnchorRequest struct {
+	Anchor   string `json:"anchor" query:"anchor" validate:"required" message:"anchor is required"`
+	ID       string `json:"id" query:"id"`
+	FilePath string `json:"file_path" query:"file_path" validate:"required" message:"file_path is required"`
+	Host     string `json:"host" query:"host"`
+	Type     string `json:"type" query:"type" validate:"required" message:"type is required"`
+}
+
+// GetAnchor re-locates a line by its anchor in the current file or its rotated sibling
+func (h *APIHandler) GetAnchor(c echo.Context) error {
+	req := new(AnchorRequest)
+	if err := BindRequest(c, req); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err)
+	}
+	if err := resolveFileID(req.ID, &req.FilePath, &req.Host, &req.Type); err != nil {
+		return err
+	}
+	msgs, err := ValidateRequest(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, msgs)
+	}
+	anchor, err := ParseAnchor(req.Anchor)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	if err := AuthorizeFilePath(req.FilePath, req.Type, req.Host); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err)
+	}
+
+	release, err := acquireRead(c, req.Type, req.Host, req.FilePath)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var watcher *Watcher
+	switch req.Type {
+	case TypeRemoteGol:
+		return h.proxyRemote(c, "api/anchor", req.Host, req.FilePath)
+	case TypeSSH:
+		sshConfig := h.API.FindSSHConfig(req.Host)
+		if sshConfig == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "ssh config not found")
 		}
+		watcher, err = NewWatcher(req.FilePath, "", "", true, sshConfig.Host, sshConfig.Port, sshConfig.User, sshConfig.Password, sshConfig.PrivateKeyPath)
+	case TypeDocker:
+		if !strings.HasPrefix(req.FilePath, TmpContainerPath) {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "anchors are not supported for files inside containers")
+		}
+		watcher, err = NewWatcher(req.FilePath, "", "", false, "", "", "", "", "")
+	case TypeFile, TypeStdin, TypeInternal, TypeK8s, TypeJournal, TypeHTTP, TypeS3:
+		watcher, err = NewWatcher(req.FilePath, "", "", false, "", "", "", "", "")
+	default:
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "unknown type")
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
-	result, err := watcher.Scan(req.Page, req.PerPage, req.Reverse)
+	result, ok, err := watcher.LocateAnchor(anchor)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, ErrorCodeAnchorNotFound)
+	}
 	result.Type = req.Type
 	result.Host = req.Host
+	return c.JSON(http.StatusOK, result)
+}
+
+type LineRequest struct {
+	Query      string `json:"query" query:"query"`
+	ID         string `json:"id" query:"id"`
+	FilePath   string `json:"file_path" query:"file_path" validate:"required" message:"file_path is required"`
+	Host       string `json:"host" query:"host"`
+	Type       string `json:"type" query:"type" validate:"required" message:"type is required"`
+	LineNumber int    `json:"line_number" query:"line_number" validate:"gte=0" message:"line_number >=0 is required"`
+	Anchor     string `json:"anchor" query:"anchor"`
+}
+
+// GetLine serves one complete, untruncated line by its line number or anchor
+func (h *APIHandler) GetLine(c echo.Context) error {
+	req := new(LineRequest)
+	if err := BindRequest(c, req); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err)
+	}
+	if err := resolveFileID(req.ID, &req.FilePath, &req.Host, &req.Type); err != nil {
+		return err
+	}
+	msgs, err := ValidateRequest(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, msgs)
+	}
+	if req.LineNumber == 0 && req.Anchor == "" {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "line_number or anchor is required")
+	}
+	if err := AuthorizeFilePath(req.FilePath, req.Type, req.Host); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err)
+	}
+
+	release, err := acquireRead(c, req.Type, req.Host, req.FilePath)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	var watcher *Watcher
+	switch req.Type {
+	case TypeRemoteGol:
+		return h.proxyRemote(c, "api/line", req.Host, req.FilePath)
+	case TypeSSH:
+		sshConfig := h.API.FindSSHConfig(req.Host)
+		if sshConfig == nil {
+			return echo.NewHTTPError(http.StatusNotFound, "ssh config not found")
+		}
+		watcher, err = NewWatcher(req.FilePath, req.Query, "", true, sshConfig.Host, sshConfig.Port, sshConfig.User, sshConfig.Password, sshConfig.PrivateKeyPath)
+	case TypeDocker:
+		if !strings.HasPrefix(req.FilePath, TmpContainerPath) {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "full lines are not supported for files inside containers")
+		}
+		watcher, err = NewWatcher(req.FilePath, req.Query, "", false, "", "", "", "", "")
+	case TypeFile, TypeStdin, TypeInternal, TypeK8s, TypeJournal, TypeHTTP, TypeS3:
+		watcher, err = NewWatcher(req.FilePath, req.Query, "", false, "", "", "", "", "")
+	default:
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "unknown type")
+	}
 	if err != nil {
 		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
 
-	return c.JSON(http.StatusOK, APIResponse{
-		Result:    *result,
-		FilePaths: GlobalFilePaths,
+	filePath := req.FilePath
+	lineNumber := req.LineNumber
+	if req.Anchor != "" {
+		anchor, err := ParseAnchor(req.Anchor)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
+		located, ok, err := watcher.LocateAnchor(anchor)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		if !ok {
+			return echo.NewHTTPError(http.StatusNotFound, ErrorCodeAnchorNotFound)
+		}
+		filePath = located.FilePath
+		lineNumber = located.LineNumber
+	}
+
+	line, err := watcher.ReadLine(filePath, lineNumber)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if line == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "line not found")
+	}
+	return c.JSON(http.StatusOK, line)
+}
+
+type ContextRequest struct {
+	Query    string `json:"query" query:"query"`
+	ID       string `json:"id" query:"id"`
+	FilePath string `json:"file_path" query:"file_path" validate:"required" message:"file_path is required"`
+	Host     string `json:"host" query:"host"`
+	Type     string `json:"type" query:"type" validate:"required" message:"type is required"`
+	Line     int    `json:"line" query:"line" validate:"required,gte=1" message:"line >=1 is required"`
+	// Context is the number of lines returned before and after the line
+	Context int `json:"context" query:"context" default:"50" validate:"gte=0" message:"context >=0 is required"`
+}
+
+// GetContext serves the lines around a line number, such as a search result, without paging to it
+func (h *APIHandler) GetContext(c echo.Context) error {
+	req := new(ContextRequest)
+	if err := BindRequest(c, req); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err)
+	}
+	if err := resolveFileID(req.ID, &req.FilePath, &req.Host, &req.Type); err != nil {
+		return err
+	}
+	defaults.SetDefaults(req)
+	msgs, err := ValidateRequest(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, msgs)
+	}
+	if 2*req.Context+1 > GlobalMaxPerPage {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("context must be at most %d", (GlobalMaxPerPage-1)/2))
+	}
+	if err := AuthorizeFilePath(req.FilePath, req.Type, req.Host); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err)
+	}
+
+	release, err := acquireRead(c, req.Type, req.Host, req.FilePath)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	if req.Type == TypeRemoteGol {
+		return h.proxyRemote(c, "api/context", req.Host, req.FilePath)
+	}
+	if req.Type == TypeDocker && !strings.HasPrefix(req.FilePath, TmpContainerPath) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "context is not supported for files inside containers")
+	}
+	watcher, err := h.newWatcher(req.Type, req.Host, req.FilePath, req.Query, "")
+	if err != nil {
+		return err
+	}
+	result, err := watcher.ReadAround(c.Request().Context(), req.Line, req.Context)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	if len(result.Lines) == 0 {
+		return echo.NewHTTPError(http.StatusNotFound, "line not found")
+	}
+	result.Type = req.Type
+	result.SetSourceType(req.Type, req.Host)
+	return c.JSON(http.StatusOK, result)
+}
+
+type AlertsStatusResponse struct {
+	Notifiers []NotifierStatus `json:"notifiers"`
+	// Rules are the alert rules with their last trigger and delivery error
+	Rules []AlertRuleStatus `json:"rules"`
+}
+
+// GetAlertsStatus reports the delivery state of the alert notifiers and rules
+func (h *APIHandler) GetAlertsStatus(c echo.Context) error {
+	rules := []AlertRuleStatus{}
+	if GlobalAlerts != nil {
+		rules = GlobalAlerts.Statuses()
+	}
+	return c.JSON(http.StatusOK, AlertsStatusResponse{
+		Notifiers: GlobalNotifierStatuses.List(),
+		Rules:     rules,
+	})
+}
+
+// resolveFileID fills the path, host and type of a request addressing the file by its ID.
+// Requests without an ID keep addressing the file by path.
+// newWatcher is the watcher of a local file, an SSH file, an HTTP source, an S3 object or a file copied out of a container
+func (h *APIHandler) newWatcher(sourceType string, host string, filePath string, query string, ignore string) (*Watcher, error) {
+	var watcher *Watcher
+	var err error
+	switch sourceType {
+	case TypeSSH:
+		sshConfig := h.API.FindSSHConfig(host)
+		if sshConfig == nil {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "ssh config not found")
+		}
+		watcher, err = NewWatcher(filePath, query, ignore, true, sshConfig.Host, sshConfig.Port, sshConfig.User, sshConfig.Password, sshConfig.PrivateKeyPath)
+	case TypeFile, TypeStdin, TypeInternal, TypeDocker, TypeK8s, TypeJournal, TypeHTTP, TypeS3:
+		watcher, err = NewWatcher(filePath, query, ignore, false, "", "", "", "", "")
+	default:
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("type %q is not supported", sourceType))
+	}
+	if err != nil {
+		return nil, echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return watcher, nil
+}
+
+func resolveFileID(id string, filePath *string, host *string, sourceType *string) error {
+	if id == "" {
+		return nil
+	}
+	fileInfo, ok := FileInfoByID(id)
+	if !ok {
+		return echo.NewHTTPError(http.StatusNotFound, "file not found")
+	}
+	*filePath = fileInfo.FilePath
+	*host = fileInfo.Host
+	*sourceType = fileInfo.Type
+	return nil
+}
+
+// acquireRead takes a scan slot and a read slot of the source, responding 429 with Retry-After when no
+// scan slot frees up in time, 503 when no read slot does
+func acquireRead(c echo.Context, sourceType string, host string, filePath string) (func(), error) {
+	release, err := GlobalReadLimiter.AcquireRead(c.Request().Context(), sourceType, host, filePath)
+	return readAcquired(c, release, err)
+}
+
+// acquireSourceRead takes a read slot of another source of a scan holding its scan slot already
+func acquireSourceRead(c echo.Context, sourceType string, host string, filePath string) (func(), error) {
+	release, err := GlobalReadLimiter.AcquireSourceRead(c.Request().Context(), sourceType, host, filePath)
+	return readAcquired(c, release, err)
+}
+
+func readAcquired(c echo.Context, release func(), err error) (func(), error) {
+	if err != nil {
+		c.Response().Header().Set("Retry-After", GlobalReadLimiter.RetryAfter())
+		if errors.Is(err, ErrTooManyScans) {
+			GlobalMetrics.Rejected(RejectedScans)
+			return nil, echo.NewHTTPError(http.StatusTooManyRequests, ErrorCodeTooManyScans)
+		}
+		GlobalMetrics.Rejected(RejectedReads)
+		return nil, echo.NewHTTPError(http.StatusServiceUnavailable, ErrorCodeTooBusy)
+	}
+	return release, nil
+}
+
+// acquireTail waits for a streaming slot, answering 503 with a Retry-After when none frees up in time
+func acquireTail(c echo.Context) (func(), error) {
+	release, err := GlobalReadLimiter.AcquireTail(c.Request().Context())
+	if err != nil {
+		c.Response().Header().Set("Retry-After", GlobalReadLimiter.RetryAfter())
+		GlobalMetrics.Rejected(RejectedStreams)
+		return nil, echo.NewHTTPError(http.StatusServiceUnavailable, ErrorCodeTooBusy)
+	}
+	return release, nil
+}
+
+type MetricsResponse struct {
+	InFlight LimiterInFlight `json:"in_flight"`
+	Disk     []DiskUsage     `json:"disk"`
+	Memory   MemoryUsage     `json:"memory"`
+}
+
+// GetMetrics reports runtime counters of the server
+func (h *APIHandler) GetMetrics(c echo.Context) error {
+	return c.JSON(http.StatusOK, MetricsResponse{
+		InFlight: GlobalReadLimiter.InFlight(),
+		Disk:     DiskUsages(),
+		Memory:   GlobalMemory.Usage(),
 	})
 }
+
+// parseTimeRange parses the optional RFC 3339 bounds of a time range query
+func parseTimeRange(from, to string) (time.Time, time.Time, error) {
+	return parseTimeRangeIn(from, to, time.Local)
+}
+
+// timeRangeLayouts are the layouts of the bounds of a time range without an offset
+var timeRangeLayouts = []string{"2006-01-02T15:04:05", "2006-01-02 15:04:05", "2006-01-02T15:04", "2006-01-02 15:04"}
+
+// parseTimeRangeIn parses the optional bounds of a time range query, RFC 3339 or without an offset in loc
+func parseTimeRangeIn(from, to string, loc *time.Location) (time.Time, time.Time, error) {
+	var fromTime, toTime time.Time
+	var err error
+	if from != "" {
+		if fromTime, err = parseTimeIn(from, loc); err != nil {
+			return fromTime, toTime, fmt.Errorf("from must be an RFC 3339 time or one like 2006-01-02T15:04:05: %w", err)
+		}
+	}
+	if to != "" {
+		if toTime, err = parseTimeIn(to, loc); err != nil {
+			return fromTime, toTime, fmt.Errorf("to must be an RFC 3339 time or one like 2006-01-02T15:04:05: %w", err)
+		}
+	}
+	if !fromTime.IsZero() && !toTime.IsZero() && toTime.Before(fromTime) {
+		return fromTime, toTime, fmt.Errorf("to must not be before from")
+	}
+	return fromTime, toTime, nil
+}
+
+func parseTimeIn(s string, loc *time.Location) (time.Time, error) {
+	t, err := time.Parse(time.RFC3339, s)
+	if err == nil {
+		return t, nil
+	}
+	for _, layout := range timeRangeLayouts {
+		if t, layoutErr := time.ParseInLocation(layout, s, loc); layoutErr == nil {
+			return t, nil
+		}
+	}
+	return t, err
+}
+
+// timeLocation is the location of times without an offset: tz, the path default or the server's
+func timeLocation(tz string, pathDefaults *ViewDefaults) (*time.Location, error) {
+	if tz == "" && pathDefaults != nil {
+		tz = pathDefaults.Timezone
+	}
+	if tz == "" {
+		return time.Local, nil
+	}
+	loc, err := time.LoadLocation(tz)
+	if err != nil {
+		return nil, fmt.Errorf("tz: %w", err)
+	}
+	return loc, nil
+}
@@ -1,10 +1,12 @@
 package pkg
 
 import (
+	"encoding/json"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/labstack/echo/v4"
@@ -20,14 +22,14 @@ func TestAPIHandler_Get(t *testing.T) {
 	e := echo.New()
 
 	// Set up global variables for testing
-	GlobalFilePaths = []FileInfo{
+	GlobalFileRegistry.Replace([]FileInfo{
 		{
 			FilePath:   "test.log",
 			LinesCount: 4,
 			FileSize:   0,
 			Type:       TypeFile,
 		},
-	}
+	})
 	GlobalPipeTmpFilePath = "temp.log"
 
 	// Create a temporary log file for testing
@@ -36,9 +38,9 @@ func TestAPIHandler_Get(t *testing.T) {
 ERROR An error occurred
 INFO Service running
 ERROR Another error occurred`
-	err := os.WriteFile(GlobalFilePaths[0].FilePath, []byte(content), 0600)
+	err := os.WriteFile(GlobalFileRegistry.Snapshot()[0].FilePath, []byte(content), 0600)
 	assert.NoError(t, err)
-	defer os.Remove(GlobalFilePaths[0].FilePath)
+	defer os.Remove(GlobalFileRegistry.Snapshot()[0].FilePath)
 
 	// Create a test request
 	req := httptest.NewRequest(http.MethodGet, "/api?query=ERROR&page=1&per_page=10", nil)
@@ -61,20 +63,36 @@ ERROR Another error occurred`
 					"line_number": 2,
 					"content": "ERROR An error occurred",
 					"level": "error",
+					"class": "error",
 					"date": "",
+					"anchor": "g0-540dd4bd-2",
 					"agent": {
 						"device": "server"
-					}
+					},
+					"highlights": [{"start": 0, "end": 5}],
+					"source": 0
 				},
 				{
 					"line_number": 4,
 					"content": "ERROR Another error occurred",
 					"level": "error",
+					"class": "error",
 					"date": "",
+					"anchor": "g0-467aa6a5-4",
 					"agent": {
 						"device": "server"
-					}
+					},
+					"highlights": [{"start": 0, "end": 5}],
+					"source": 0
 				}
+				],
+				"sources": [
+					{
+						"file_path": "test.log",
+						"host": "",
+						"type": "file",
+						"label": ""
+					}
 				]
 			},
 			"file_paths": [
@@ -84,26 +102,35 @@ ERROR Another error occurred`
 					"file_size": 0,
 					"type": "file",
 					"host": "",
-					"name": ""
+					"name": "",
+					"generation": 0
 				}
 			]
 		}`
 		fmt.Println(rec.Body.String())
-		assert.JSONEq(t, expected, rec.Body.String())
+		// the cursor holds the inode of the file
+		actual := map[string]interface{}{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &actual))
+		result := actual["result"].(map[string]interface{})
+		assert.NotEmpty(t, result["next_cursor"])
+		delete(result, "next_cursor")
+		body, err := json.Marshal(actual)
+		assert.NoError(t, err)
+		assert.JSONEq(t, expected, string(body))
 	}
 }
 func TestAPIHandler_Get404(t *testing.T) {
 	e := echo.New()
 
 	// Set up global variables for testing
-	GlobalFilePaths = []FileInfo{
+	GlobalFileRegistry.Replace([]FileInfo{
 		{
 			FilePath:   "test.log",
 			LinesCount: 4,
 			FileSize:   0,
 			Type:       TypeFile,
 		},
-	}
+	})
 	GlobalPipeTmpFilePath = "temp.log"
 
 	// nolint:goconst
@@ -111,9 +138,9 @@ func TestAPIHandler_Get404(t *testing.T) {
 	ERROR An error occurred
 	INFO Service running
 	ERROR Another error occurred`
-	err := os.WriteFile(GlobalFilePaths[0].FilePath, []byte(content), 0600)
+	err := os.WriteFile(GlobalFileRegistry.Snapshot()[0].FilePath, []byte(content), 0600)
 	assert.NoError(t, err)
-	defer os.Remove(GlobalFilePaths[0].FilePath)
+	defer os.Remove(GlobalFileRegistry.Snapshot()[0].FilePath)
 
 	handler := NewAPIHandler()
 
@@ -138,8 +165,75 @@ func TestAPIHandler_Get404(t *testing.T) {
 	assert.Error(t, resp)
 	// nolint: errorlint
 	if he, ok := resp.(*echo.HTTPError); ok {
-		assert.Equal(t, http.StatusNotFound, he.Code)
+		assert.Equal(t, http.StatusForbidden, he.Code)
 	} else {
 		assert.Fail(t, "response is not an HTTP error")
 	}
 }
+
+func TestAPIHandler_GetByID(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte("INFO Starting service\nERROR An error occurred\n"), 0600))
+	GlobalFileRegistry.Replace(SetFileIDs([]FileInfo{{FilePath: logFile, LinesCount: 2, Type: TypeFile}}))
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+
+	get := func(url string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("/api/line?line_number=2&id=" + GlobalFileRegistry.Snapshot()[0].ID)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), "ERROR An error occurred")
+
+	// the path still works
+	rec = get("/api/line?line_number=2&type=file&file_path=" + logFile)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = get("/api/line?line_number=2&id=unknown")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
+
+func TestAPIHandler_GetTail(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.log")
+	content := ""
+	for i := 1; i <= 1000; i++ {
+		content += fmt.Sprintf("INFO request %d\n", i)
+	}
+	assert.NoError(t, os.WriteFile(logFile, []byte(content), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: logFile, LinesCount: 1000, Type: TypeFile}})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+
+	get := func(query string) (*httptest.ResponseRecorder, APIResponse) {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api?type=file&file_path="+logFile+query, nil))
+		res := APIResponse{}
+		if rec.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		}
+		return rec, res
+	}
+
+	rec, tail := get("&tail=3")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 1000, tail.Result.Total)
+	// the same lines, numbers and anchors as the last page of a scan
+	_, page := get("&page=1&per_page=3&reverse=true")
+	assert.Equal(t, page.Result.Lines, tail.Result.Lines)
+	assert.Equal(t, 998, tail.Result.Lines[0].LineNumber)
+	assert.Equal(t, "INFO request 1000", tail.Result.Lines[2].Content)
+
+	rec, _ = get("&tail=3&query=ERROR")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	// a tail over the max is truncated to its last lines
+	defer func(maxPerPage int) { GlobalMaxPerPage = maxPerPage }(GlobalMaxPerPage)
+	GlobalMaxPerPage = 2
+	rec, tail = get("&tail=3")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, tail.Result.Truncated)
+	assert.Equal(t, page.Result.Lines[1:], tail.Result.Lines)
+	rec, _ = get("&tail=-1")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
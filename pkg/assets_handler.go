@@ -1,10 +1,13 @@
 package pkg
 
 import (
-	"embed"
 	"fmt"
+	"html/template"
+	"io/fs"
 	"net/http"
 	"os"
+	"sort"
+	"strings"
 
 	"github.com/labstack/echo/v4"
 )
@@ -12,10 +15,11 @@ import (
 type AssetsHandler struct {
 	filename  string
 	distDir   string
-	publicDir *embed.FS
+	publicDir fs.FS
 }
 
-func NewAssetsHandler(publicDir *embed.FS, distDir string, filename string) *AssetsHandler {
+// NewAssetsHandler serves filename of distDir in publicDir, which is nil in builds without the UI
+func NewAssetsHandler(publicDir fs.FS, distDir string, filename string) *AssetsHandler {
 	return &AssetsHandler{
 		publicDir: publicDir,
 		distDir:   distDir,
@@ -23,29 +27,102 @@ func NewAssetsHandler(publicDir *embed.FS, distDir string, filename string) *Ass
 	}
 }
 
-func (h *AssetsHandler) GetPlain(c echo.Context) error {
+// HasUI tells whether publicDir holds a built frontend in distDir
+func HasUI(publicDir fs.FS, distDir string) bool {
+	if publicDir == nil {
+		return false
+	}
+	_, err := fs.Stat(publicDir, distDir+"/index.html")
+	return err == nil
+}
+
+func (h *AssetsHandler) read() ([]byte, string, error) {
 	filename := fmt.Sprintf("%s/%s", h.distDir, h.filename)
-	content, err := h.publicDir.ReadFile(filename)
+	if h.publicDir == nil {
+		return nil, filename, fs.ErrNotExist
+	}
+	content, err := fs.ReadFile(h.publicDir, filename)
+	return content, filename, err
+}
+
+func (h *AssetsHandler) GetPlain(c echo.Context) error {
+	content, _, err := h.read()
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "Not Found")
 	}
 	return ResponsePlain(c, content, "0")
 }
 func (h *AssetsHandler) GetICO(c echo.Context) error {
-	filename := fmt.Sprintf("%s/%s", h.distDir, h.filename)
-	content, err := h.publicDir.ReadFile(filename)
+	content, filename, err := h.read()
 	if err != nil {
 		return echo.NewHTTPError(http.StatusNotFound, "Not Found")
 	}
 	SetHeadersResponsePNG(c.Response().Header())
-	return c.Blob(http.StatusOK, "image/x-icon", content)
+	return blobPrecompressed(c, "image/x-icon", filename, content)
 }
 
+// Get serves the page, or the status page when the frontend was not built into the binary
 func (h *AssetsHandler) Get(c echo.Context) error {
-	filename := fmt.Sprintf("%s/%s", h.distDir, h.filename)
-	content, err := h.publicDir.ReadFile(filename)
+	content, filename, err := h.read()
 	if err != nil {
-		return c.String(http.StatusOK, os.Getenv("VERSION"))
+		return renderStatusPage(c, os.Getenv("VERSION"))
+	}
+	SetHeadersResponseHTML(c.Response().Header(), "0")
+	return blobPrecompressed(c, "text/html", filename, content)
+}
+
+// StatusHandler serves the built-in page of API only servers
+type StatusHandler struct {
+	version string
+}
+
+func NewStatusHandler(options *EchoOptions) *StatusHandler {
+	return &StatusHandler{version: options.Version}
+}
+
+func (h *StatusHandler) Get(c echo.Context) error {
+	return renderStatusPage(c, h.version)
+}
+
+var statusPage = template.Must(template.New("status").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>gol</title></head>
+<body>
+<h1>gol {{.Version}}</h1>
+<p>Watching {{.Files}} files. This server has no web UI, the API is available at:</p>
+<ul>
+{{- range .Routes}}
+<li><code>{{.Method}} {{.Path}}</code></li>
+{{- end}}
+</ul>
+</body>
+</html>
+`))
+
+type statusPageData struct {
+	Version string
+	Files   int
+	Routes  []*echo.Route
+}
+
+// renderStatusPage lists the API routes registered on the server and the number of watched files
+func renderStatusPage(c echo.Context, version string) error {
+	data := statusPageData{Version: version, Files: len(GlobalFileRegistry.Snapshot())}
+	for _, route := range c.Echo().Routes() {
+		if strings.Contains(route.Path, "/api") {
+			data.Routes = append(data.Routes, route)
+		}
+	}
+	sort.Slice(data.Routes, func(i, j int) bool {
+		if data.Routes[i].Path != data.Routes[j].Path {
+			return data.Routes[i].Path < data.Routes[j].Path
+		}
+		return data.Routes[i].Method < data.Routes[j].Method
+	})
+	var page strings.Builder
+	if err := statusPage.Execute(&page, data); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
 	}
-	return ResponseHTML(c, content, "0")
+	SetHeadersResponseHTML(c.Response().Header(), "0")
+	return c.HTML(http.StatusOK, page.String())
 }
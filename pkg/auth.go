@@ -0,0 +1,339 @@
+package pkg
+
+import (
+	"bufio"
+	"crypto/subtle"
+	"fmt"
+	"log/slog"
+	"math"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// TokenQueryParam carries the API token for clients that cannot set headers.
+// Prefer the Authorization header, query strings end up in access logs.
+const TokenQueryParam = "token"
+
+const (
+	// authFailuresAllowed are the failed attempts of an address before it has to wait, twice as long
+	// after each further failure
+	authFailuresAllowed = 5
+	authBackoffBase     = time.Second
+	authBackoffMax      = 5 * time.Minute
+	// authFailuresKept is how many addresses failures are kept for, idle ones are dropped past it
+	authFailuresKept = 10000
+)
+
+// RequestToken returns the bearer token of r, or else its token query parameter
+func RequestToken(r *http.Request) string {
+	if bearer, ok := strings.CutPrefix(r.Header.Get(echo.HeaderAuthorization), "Bearer "); ok {
+		return bearer
+	}
+	return r.URL.Query().Get(TokenQueryParam)
+}
+
+// ValidToken tells whether r carries one of tokens, empty tokens never match
+func ValidToken(r *http.Request, tokens ...string) bool {
+	got := []byte(RequestToken(r))
+	for _, token := range tokens {
+		if token != "" && subtle.ConstantTimeCompare(got, []byte(token)) == 1 {
+			return true
+		}
+	}
+	return false
+}
+
+// AuthValidator accepts requests by credentials of its own, for apps embedding gol
+type AuthValidator func(r *http.Request) bool
+
+// Authenticator accepts a request carrying one of the tokens as a bearer token or ?token=, the basic
+// auth of one of the users, or passing the validator. The tokens and users of the auth file are read
+// again by Reload, those of the options are kept.
+type Authenticator struct {
+	tokens    []string
+	users     map[string][]byte
+	file      string
+	validator AuthValidator
+	// adminToken is accepted as well, it does not enable the auth on its own
+	adminToken string
+	failures   *AuthFailures
+
+	mutex      sync.RWMutex
+	fileTokens []string
+	fileUsers  map[string][]byte
+}
+
+// NewAuthenticator takes the token, the users, the auth file and the validator of the options. A
+// user is "name:bcrypt-hash".
+func NewAuthenticator(options *EchoOptions) (*Authenticator, error) {
+	a := &Authenticator{
+		users:      map[string][]byte{},
+		file:       options.AuthFile,
+		validator:  options.AuthValidator,
+		adminToken: options.AdminToken,
+		failures:   NewAuthFailures(),
+	}
+	for _, token := range append([]string{options.Token}, options.AuthTokens...) {
+		if token != "" {
+			a.tokens = append(a.tokens, token)
+		}
+	}
+	for _, user := range options.AuthUsers {
+		name, hash, err := ParseAuthUser(user)
+		if err != nil {
+			return nil, err
+		}
+		a.users[name] = hash
+	}
+	if err := a.Reload(); err != nil {
+		return nil, err
+	}
+	return a, nil
+}
+
+// ParseAuthUser parses "name:bcrypt-hash", as made by htpasswd -nB
+func ParseAuthUser(user string) (string, []byte, error) {
+	name, hash, ok := strings.Cut(user, ":")
+	if !ok || name == "" {
+		return "", nil, fmt.Errorf("auth user %q is not name:bcrypt-hash", name)
+	}
+	if _, err := bcrypt.Cost([]byte(hash)); err != nil {
+		return "", nil, fmt.Errorf("auth user %s: %w", name, err)
+	}
+	return name, []byte(hash), nil
+}
+
+// Reload reads the auth file again, keeping its previous tokens and users when it cannot be read
+func (a *Authenticator) Reload() error {
+	if a.file == "" {
+		return nil
+	}
+	tokens, users, err := readAuthFile(a.file)
+	if err != nil {
+		return err
+	}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.fileTokens, a.fileUsers = tokens, users
+	slog.Info("loaded auth file", "file", a.file, "tokens", len(tokens), "users", len(users))
+	return nil
+}
+
+// readAuthFile reads lines of "token <token>" and "user <name>:<bcrypt-hash>", # starts a comment
+func readAuthFile(path string) ([]string, map[string][]byte, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading auth file %s: %w", path, err)
+	}
+	defer file.Close()
+	tokens := []string{}
+	users := map[string][]byte{}
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		kind, value, _ := strings.Cut(line, " ")
+		value = strings.TrimSpace(value)
+		switch {
+		case kind == "token" && value != "":
+			tokens = append(tokens, value)
+		case kind == "user":
+			name, hash, err := ParseAuthUser(value)
+			if err != nil {
+				return nil, nil, fmt.Errorf("auth file %s line %d: %w", path, lineNumber, err)
+			}
+			users[name] = hash
+		default:
+			return nil, nil, fmt.Errorf("auth file %s line %d: want \"token <token>\" or \"user <name>:<bcrypt-hash>\"", path, lineNumber)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, nil, fmt.Errorf("reading auth file %s: %w", path, err)
+	}
+	return tokens, users, nil
+}
+
+// Enabled tells whether requests need credentials, they do once any token, user, auth file or
+// validator is set
+func (a *Authenticator) Enabled() bool {
+	return len(a.tokens) > 0 || len(a.users) > 0 || a.file != "" || a.validator != nil
+}
+
+// Basic tells whether users log in with basic auth, unauthorized answers then ask browsers for it
+func (a *Authenticator) Basic() bool {
+	a.mutex.RLock()
+	defer a.mutex.RUnlock()
+	return len(a.users) > 0 || len(a.fileUsers) > 0
+}
+
+// Mode is how clients authenticate, for the capabilities
+func (a *Authenticator) Mode() string {
+	a.mutex.RLock()
+	tokens := len(a.tokens) > 0 || len(a.fileTokens) > 0
+	a.mutex.RUnlock()
+	switch basic := a.Basic(); {
+	case !a.Enabled():
+		return AuthModeNone
+	case tokens && basic:
+		return AuthModeTokenOrBasic
+	case basic:
+		return AuthModeBasic
+	case tokens:
+		return AuthModeToken
+	}
+	return AuthModeCustom
+}
+
+// Valid tells whether r carries valid credentials
+func (a *Authenticator) Valid(r *http.Request) bool {
+	name, password, basic := r.BasicAuth()
+	a.mutex.RLock()
+	tokens := append(append([]string{a.adminToken}, a.tokens...), a.fileTokens...)
+	hash, ok := a.users[name]
+	if !ok {
+		hash, ok = a.fileUsers[name]
+	}
+	a.mutex.RUnlock()
+	if basic && ok && bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil {
+		return true
+	}
+	if ValidToken(r, tokens...) {
+		return true
+	}
+	return a.validator != nil && a.validator(r)
+}
+
+// AuthFailures counts the failed attempts of each address, which wait twice as long after each
+// failure past authFailuresAllowed, up to authBackoffMax
+type AuthFailures struct {
+	mutex  sync.Mutex
+	byAddr map[string]*authFailure
+}
+
+type authFailure struct {
+	count int
+	last  time.Time
+	until time.Time
+}
+
+func NewAuthFailures() *AuthFailures {
+	return &AuthFailures{byAddr: map[string]*authFailure{}}
+}
+
+// Wait is how long addr has to wait before its next attempt
+func (f *AuthFailures) Wait(addr string) time.Duration {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	failure, ok := f.byAddr[addr]
+	if !ok {
+		return 0
+	}
+	return max(failure.until.Sub(GlobalClock.Now()), 0)
+}
+
+// Failed counts a failed attempt of addr and returns its failures so far
+func (f *AuthFailures) Failed(addr string) int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	now := GlobalClock.Now()
+	if len(f.byAddr) >= authFailuresKept {
+		for other, failure := range f.byAddr {
+			if now.Sub(failure.last) > authBackoffMax {
+				delete(f.byAddr, other)
+			}
+		}
+	}
+	failure, ok := f.byAddr[addr]
+	if !ok {
+		failure = &authFailure{}
+		f.byAddr[addr] = failure
+	}
+	failure.count++
+	failure.last = now
+	if over := failure.count - authFailuresAllowed; over > 0 {
+		backoff := authBackoffMax
+		if over <= 20 {
+			backoff = min(authBackoffBase<<(over-1), authBackoffMax)
+		}
+		failure.until = now.Add(backoff)
+	}
+	return failure.count
+}
+
+// Succeeded forgets the failures of addr
+func (f *AuthFailures) Succeeded(addr string) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	delete(f.byAddr, addr)
+}
+
+// hasCredentials tells whether r tries to authenticate, requests without credentials are refused
+// without counting as failures, as a browser sends before asking for basic auth
+func hasCredentials(r *http.Request) bool {
+	return r.Header.Get(echo.HeaderAuthorization) != "" || r.URL.Query().Has(TokenQueryParam)
+}
+
+// connectionIP is the address of the client connection, headers set by clients are not trusted for
+// counting their failures
+func connectionIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// Auth is a middleware requiring credentials on every API request and on the metrics, the admin token
+// is accepted too. It is disabled without any token, user, auth file or validator. An address failing
+// more than authFailuresAllowed times gets 429 until its backoff passed.
+func Auth(options *EchoOptions) echo.MiddlewareFunc {
+	auth := options.Authenticator
+	var authErr error
+	if auth == nil {
+		auth, authErr = NewAuthenticator(options)
+	}
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			path := c.Request().URL.Path
+			if !strings.HasPrefix(path, options.BaseURL+"api") && path != options.BaseURL+"metrics" {
+				return next(c)
+			}
+			if authErr != nil {
+				// the server refuses to start with bad credentials, without them nothing is let through
+				slog.Error("checking credentials", "error", authErr)
+				return echo.NewHTTPError(http.StatusUnauthorized, ErrorCodeUnauthorized)
+			}
+			if !auth.Enabled() {
+				return next(c)
+			}
+			addr := connectionIP(c.Request())
+			if wait := auth.failures.Wait(addr); wait > 0 {
+				c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				return echo.NewHTTPError(http.StatusTooManyRequests, ErrorCodeTooManyAttempts)
+			}
+			if !auth.Valid(c.Request()) {
+				if hasCredentials(c.Request()) {
+					slog.Warn("authentication failed", "remote", addr, "path", path, "failures", auth.failures.Failed(addr))
+				}
+				if auth.Basic() {
+					c.Response().Header().Set(echo.HeaderWWWAuthenticate, `Basic realm="gol", charset="UTF-8"`)
+				}
+				return echo.NewHTTPError(http.StatusUnauthorized, ErrorCodeUnauthorized)
+			}
+			auth.failures.Succeeded(addr)
+			return next(c)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package pkg
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/bcrypt"
+)
+
+func TestTokenAuth(t *testing.T) {
+	get := func(options *EchoOptions, url string, bearer string) *httptest.ResponseRecorder {
+		e := newTestEcho(options)
+		req := httptest.NewRequest(http.MethodGet, url, nil)
+		if bearer != "" {
+			req.Header.Set("Authorization", "Bearer "+bearer)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// disabled without a token
+	options := &EchoOptions{BaseURL: "/", Version: "v1.2.3", Compression: CompressionOff}
+	assert.Equal(t, http.StatusOK, get(options, "/api/version", "").Code)
+
+	options.Token = "secret"
+	options.AdminToken = "admin"
+	rec := get(options, "/api/version", "")
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrorCodeUnauthorized)
+	assert.Equal(t, http.StatusUnauthorized, get(options, "/api/version", "wrong").Code)
+	assert.Equal(t, http.StatusUnauthorized, get(options, "/api/version?token=wrong", "").Code)
+
+	assert.Equal(t, http.StatusOK, get(options, "/api/version", "secret").Code)
+	assert.Equal(t, http.StatusOK, get(options, "/api/version?token=secret", "").Code)
+	assert.Equal(t, http.StatusOK, get(options, "/api/version", "admin").Code)
+	// the status page is not part of the API
+	assert.Equal(t, http.StatusOK, get(options, "/", "").Code)
+
+	rec = get(options, "/api/capabilities", "secret")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"auth_mode":"`+AuthModeToken+`"`)
+}
+
+func TestValidToken(t *testing.T) {
+	req := httptest.NewRequest(http.MethodGet, "/api?token=query", nil)
+	assert.Equal(t, "query", RequestToken(req))
+	assert.True(t, ValidToken(req, "other", "query"))
+	assert.False(t, ValidToken(req, ""))
+
+	// the header wins over the query string
+	req.Header.Set("Authorization", "Bearer header")
+	assert.Equal(t, "header", RequestToken(req))
+	assert.False(t, ValidToken(req, "query"))
+
+	assert.False(t, ValidToken(httptest.NewRequest(http.MethodGet, "/api", nil), ""))
+}
+
+func TestAuth_UsersAndAuthFile(t *testing.T) {
+	hash := func(password string) string {
+		b, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+		assert.NoError(t, err)
+		return string(b)
+	}
+	authFile := filepath.Join(t.TempDir(), "auth")
+	assert.NoError(t, os.WriteFile(authFile, []byte("# rotated weekly\ntoken file-token\nuser bob:"+hash("bob-pw")+"\n"), 0600))
+	options := &EchoOptions{
+		BaseURL:     "/",
+		Version:     "v1.2.3",
+		Compression: CompressionOff,
+		AuthTokens:  []string{"token-1", "token-2"},
+		AuthUsers:   []string{"alice:" + hash("alice-pw")},
+		AuthFile:    authFile,
+	}
+	auth, err := NewAuthenticator(options)
+	assert.NoError(t, err)
+	options.Authenticator = auth
+	e := newTestEcho(options)
+	get := func(setup func(req *http.Request)) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/capabilities", nil)
+		setup(req)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	basic := func(user, password string) func(*http.Request) {
+		return func(req *http.Request) { req.SetBasicAuth(user, password) }
+	}
+	bearer := func(token string) func(*http.Request) {
+		return func(req *http.Request) { req.Header.Set(echo.HeaderAuthorization, "Bearer "+token) }
+	}
+
+	// browsers are asked for basic auth
+	rec := get(func(*http.Request) {})
+	assert.Equal(t, http.StatusUnauthorized, rec.Code)
+	assert.Contains(t, rec.Header().Get(echo.HeaderWWWAuthenticate), `Basic realm="gol"`)
+
+	rec = get(basic("alice", "alice-pw"))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"auth_mode":"`+AuthModeTokenOrBasic+`"`)
+	assert.Equal(t, http.StatusOK, get(basic("bob", "bob-pw")).Code)
+	assert.Equal(t, http.StatusUnauthorized, get(basic("alice", "bob-pw")).Code)
+	assert.Equal(t, http.StatusOK, get(bearer("token-2")).Code)
+	assert.Equal(t, http.StatusOK, get(bearer("file-token")).Code)
+
+	// a reload rotates the credentials of the file, a broken file keeps the previous ones
+	assert.NoError(t, os.WriteFile(authFile, []byte("token rotated\n"), 0600))
+	assert.NoError(t, auth.Reload())
+	assert.Equal(t, http.StatusUnauthorized, get(bearer("file-token")).Code)
+	assert.Equal(t, http.StatusUnauthorized, get(basic("bob", "bob-pw")).Code)
+	assert.Equal(t, http.StatusOK, get(bearer("rotated")).Code)
+	assert.NoError(t, os.WriteFile(authFile, []byte("password hunter2\n"), 0600))
+	assert.ErrorContains(t, auth.Reload(), authFile+" line 1")
+	assert.Equal(t, http.StatusOK, get(bearer("rotated")).Code)
+
+	_, err = NewAuthenticator(&EchoOptions{AuthUsers: []string{"carol:plain"}})
+	assert.Error(t, err)
+	_, err = NewAuthenticator(&EchoOptions{AuthFile: filepath.Join(t.TempDir(), "missing")})
+	assert.ErrorContains(t, err, "missing")
+}
+
+func TestAuth_Backoff(t *testing.T) {
+	defer func(clock Clock) { GlobalClock = clock }(GlobalClock)
+	clock := NewManualClock(time.Unix(0, 0))
+	GlobalClock = clock
+	options := &EchoOptions{BaseURL: "/", Compression: CompressionOff, Token: "secret"}
+	e := newTestEcho(options)
+	get := func(remoteAddr string, token string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/version", nil)
+		req.RemoteAddr = remoteAddr
+		if token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	// requests without credentials are not attempts
+	for range 10 {
+		assert.Equal(t, http.StatusUnauthorized, get("192.0.2.1:1000", "").Code)
+	}
+	for range authFailuresAllowed {
+		assert.Equal(t, http.StatusUnauthorized, get("192.0.2.1:1000", "wrong").Code)
+	}
+	assert.Equal(t, http.StatusUnauthorized, get("192.0.2.1:1000", "wrong").Code)
+	// after 6 failures the address waits a second, then two after the next one
+	rec := get("192.0.2.1:1001", "secret")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	// other addresses are not held back
+	assert.Equal(t, http.StatusOK, get("192.0.2.2:1000", "secret").Code)
+
+	clock.Advance(time.Second)
+	assert.Equal(t, http.StatusUnauthorized, get("192.0.2.1:1000", "wrong").Code)
+	assert.Equal(t, "2", get("192.0.2.1:1000", "secret").Header().Get("Retry-After"))
+	clock.Advance(2 * time.Second)
+	assert.Equal(t, http.StatusOK, get("192.0.2.1:1000", "secret").Code)
+	// a success forgets the failures
+	assert.Equal(t, http.StatusUnauthorized, get("192.0.2.1:1000", "wrong").Code)
+	assert.Equal(t, http.StatusOK, get("192.0.2.1:1000", "secret").Code)
+}
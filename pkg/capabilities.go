@@ -0,0 +1,194 @@
+package pkg
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+// CapabilitiesSchemaVersion is bumped when capabilities are added, the schema is additive only:
+// fields and feature names are never renamed or removed
+const CapabilitiesSchemaVersion = 9
+
+const (
+	FeatureRegexSearch    = "regex_search"
+	FeatureByteWindow     = "byte_window"
+	FeatureAnchors        = "anchors"
+	FeatureFullLine       = "full_line"
+	FeatureStreamingTail  = "streaming_tail"
+	FeatureFileList       = "file_list"
+	FeatureRotationGroups = "rotation_groups"
+	FeatureAlertsStatus   = "alerts_status"
+	FeatureMetrics        = "metrics"
+	FeatureCompressionBr  = "compression_br"
+	FeatureRemoteSources  = "remote_sources"
+	FeatureSampling       = "sampling"
+	FeatureFileCuration   = "file_curation"
+	FeatureFileIDs        = "file_ids"
+	FeatureJobs           = "jobs"
+	FeatureLineClasses    = "line_classes"
+	FeatureReplay         = "replay"
+	FeatureInternalLogs   = "internal_logs"
+	FeatureDiff           = "diff"
+	FeatureProcessors     = "processors"
+	FeatureSelfReport     = "self_report"
+	FeatureDownload       = "download"
+	FeatureFilePreview    = "file_preview"
+	FeatureDeepHealth     = "deep_health"
+	FeatureMerge          = "merge"
+	FeatureShares         = "shares"
+	FeatureHistogram      = "histogram"
+
+	AuthModeNone         = "none"
+	AuthModeToken        = "token"
+	AuthModeBasic        = "basic"
+	AuthModeTokenOrBasic = "token_or_basic"
+	// AuthModeCustom is a validator of an app embedding gol
+	AuthModeCustom = "custom"
+
+	DefaultMaxPerPage = 10000
+)
+
+type CapabilitiesLimits struct {
+	MaxPageSize     int `json:"max_page_size"`
+	MaxLineLength   int `json:"max_line_length"`
+	MaxReads        int `json:"max_reads"`
+	MaxReadsPerHost int `json:"max_reads_per_host"`
+	MaxTails        int `json:"max_tails"`
+	// MaxPatternCost is the estimated cost search patterns may have, PatternLimit what happens to the others
+	MaxPatternCost int    `json:"max_pattern_cost"`
+	PatternLimit   string `json:"pattern_limit"`
+	// MaxExportLines, the lines a download of search results is cut at, was added in schema version 4
+	MaxExportLines int `json:"max_export_lines"`
+	// MaxMergeFiles, the files a merged view or stream reads at most, was added in schema version 5
+	MaxMergeFiles int `json:"max_merge_files"`
+	// MaxScans, the searches, exports and counts running at once over all sources (0 when not capped),
+	// was added in schema version 8
+	MaxScans int `json:"max_scans"`
+	// MaxResponseBytes, the size of the lines past which a response is truncated (0 when not capped), was
+	// added in schema version 9
+	MaxResponseBytes int64 `json:"max_response_bytes"`
+}
+
+// CapabilitiesClassification are the rules the class of a line is computed with.
+// Keywords of the default rules are overridden per path by the classes of the file's defaults.
+type CapabilitiesClassification struct {
+	Classes             []string    `json:"classes"`
+	Rules               []ClassRule `json:"rules"`
+	MaxWords            int         `json:"max_words"`
+	StackStarts         []string    `json:"stack_starts"`
+	StackIndentedStarts []string    `json:"stack_indented_starts"`
+	AccessParsers       []string    `json:"access_parsers"`
+}
+
+// Capabilities lets the frontend and API consumers feature-detect the server
+type Capabilities struct {
+	SchemaVersion int                `json:"schema_version"`
+	Version       string             `json:"version"`
+	Features      []string           `json:"features"`
+	Limits        CapabilitiesLimits `json:"limits"`
+	ExportFormats []string           `json:"export_formats"`
+	Streaming     bool               `json:"streaming"`
+	AuthMode      string             `json:"auth_mode"`
+	ReadOnly      bool               `json:"read_only"`
+	// Classification was added in schema version 2
+	Classification CapabilitiesClassification `json:"classification"`
+	// Processors, the names of the registered line processors, were added in schema version 3
+	Processors []string `json:"processors"`
+}
+
+// NewCapabilities assembles the capabilities from the options and flags the server was started with
+func NewCapabilities(options *EchoOptions) Capabilities {
+	features := []string{
+		FeatureRegexSearch,
+		FeatureByteWindow,
+		FeatureAnchors,
+		FeatureFullLine,
+		FeatureStreamingTail,
+		FeatureFileList,
+		FeatureAlertsStatus,
+		FeatureMetrics,
+		FeatureSampling,
+		FeatureFileIDs,
+		FeatureJobs,
+		FeatureLineClasses,
+		FeatureReplay,
+		FeatureDiff,
+		FeatureProcessors,
+		FeatureDownload,
+		FeatureFilePreview,
+		FeatureMerge,
+		FeatureHistogram,
+	}
+	if !options.ReadOnly {
+		features = append(features, FeatureFileCuration, FeatureShares)
+	}
+	if options.AdminToken != "" {
+		features = append(features, FeatureDeepHealth)
+	}
+	if len(GlobalRotationSuffixes) > 0 {
+		features = append(features, FeatureRotationGroups)
+	}
+	if len(GlobalRemoteClients) > 0 {
+		features = append(features, FeatureRemoteSources)
+	}
+	if GlobalLogBuffer != nil {
+		features = append(features, FeatureInternalLogs)
+	}
+	if GlobalSelfReporter != nil {
+		features = append(features, FeatureSelfReport)
+	}
+	if options.Compression == CompressionBr {
+		features = append(features, FeatureCompressionBr)
+	}
+	maxScans, maxReads, maxReadsPerHost, maxTails := GlobalReadLimiter.Budgets()
+	authMode := AuthModeNone
+	if options.Authenticator != nil {
+		authMode = options.Authenticator.Mode()
+	} else if auth, err := NewAuthenticator(options); err == nil {
+		authMode = auth.Mode()
+	}
+	return Capabilities{
+		SchemaVersion: CapabilitiesSchemaVersion,
+		Version:       options.Version,
+		Features:      features,
+		Limits: CapabilitiesLimits{
+			MaxPageSize:      GlobalMaxPerPage,
+			MaxLineLength:    GlobalMaxLineLength,
+			MaxReads:         maxReads,
+			MaxReadsPerHost:  maxReadsPerHost,
+			MaxTails:         maxTails,
+			MaxPatternCost:   GlobalPatternLimits.MaxCost,
+			PatternLimit:     GlobalPatternLimits.Mode,
+			MaxExportLines:   GlobalExportMaxLines,
+			MaxMergeFiles:    GlobalMaxMergeFiles,
+			MaxScans:         maxScans,
+			MaxResponseBytes: GlobalMaxResponseBytes,
+		},
+		ExportFormats: []string{DiffFormatNDJSON, ExportFormatTxt, ExportFormatJSON, ExportFormatCSV},
+		Streaming:     true,
+		AuthMode:      authMode,
+		ReadOnly:      options.ReadOnly,
+		Classification: CapabilitiesClassification{
+			Classes:             Classes,
+			Rules:               DefaultClassRules,
+			MaxWords:            classMaxWords,
+			StackStarts:         StackStarts,
+			StackIndentedStarts: StackIndentedStarts,
+			AccessParsers:       AccessParsers,
+		},
+		Processors: GlobalProcessors.Names(),
+	}
+}
+
+type CapabilitiesHandler struct {
+	capabilities Capabilities
+}
+
+func NewCapabilitiesHandler(options *EchoOptions) *CapabilitiesHandler {
+	return &CapabilitiesHandler{capabilities: NewCapabilities(options)}
+}
+
+func (h *CapabilitiesHandler) Get(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.capabilities)
+}
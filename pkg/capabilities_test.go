@@ -0,0 +1,43 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// TestCapabilities_Schema guards the additive only schema: renaming or removing
+// a field or a feature name breaks clients feature-detecting with it
+func TestCapabilities_Schema(t *testing.T) {
+	options := &EchoOptions{BaseURL: "/", Version: "v1.0.0", Compression: CompressionBr}
+	e := newTestEcho(options)
+
+	req := httptest.NewRequest(http.MethodGet, "/api/capabilities", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var body map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &body))
+	for _, key := range []string{"schema_version", "version", "features", "limits", "export_formats", "streaming", "auth_mode", "read_only", "classification", "processors"} {
+		assert.Contains(t, body, key)
+	}
+	limits, ok := body["limits"].(map[string]interface{})
+	assert.True(t, ok)
+	for _, key := range []string{"max_page_size", "max_line_length", "max_reads", "max_reads_per_host", "max_tails", "max_export_lines", "max_merge_files", "max_scans", "max_response_bytes"} {
+		assert.Contains(t, limits, key)
+	}
+
+	features := []string{}
+	for _, feature := range body["features"].([]interface{}) {
+		features = append(features, feature.(string))
+	}
+	for _, feature := range []string{"regex_search", "byte_window", "anchors", "full_line", "streaming_tail", "file_list", "alerts_status", "metrics", "compression_br", "line_classes", "merge", "shares", "histogram"} {
+		assert.Contains(t, features, feature)
+	}
+	assert.Equal(t, float64(9), body["schema_version"])
+	assert.Equal(t, "none", body["auth_mode"])
+}
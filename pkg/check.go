@@ -0,0 +1,255 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+const (
+	CheckSeverityError   = "error"
+	CheckSeverityWarning = "warning"
+
+	DefaultCheckTimeout = 10 * time.Second
+)
+
+// CheckFinding is a problem found by RunChecks in one source or setting
+type CheckFinding struct {
+	Severity string
+	// Source is the flag value, pattern or path the finding is about
+	Source  string
+	Message string
+}
+
+func (f CheckFinding) String() string {
+	return fmt.Sprintf("%-7s %s: %s", f.Severity, f.Source, f.Message)
+}
+
+// CheckOptions are the sources and settings checked by RunChecks, as given by flags
+type CheckOptions struct {
+	ConfigPath       string
+	Settings         Settings
+	FilePaths        []string
+	SSHPaths         []string
+	DockerPaths      []string
+	RemotePaths      []string
+	K8sPaths         []string
+	JournalUnits     []string
+	RotationSuffixes []string
+	DataDir          string
+	// Timeout bounds the check of each remote source
+	Timeout time.Duration
+}
+
+// RunChecks validates the settings and config the way startup does and tries every source,
+// without starting the server. Errors would fail or break the server, warnings are likely mistakes.
+func RunChecks(ctx context.Context, options CheckOptions) []CheckFinding {
+	findings := []CheckFinding{}
+	add := func(severity string, source string, err error) {
+		findings = append(findings, CheckFinding{Severity: severity, Source: source, Message: err.Error()})
+	}
+	if options.Timeout == 0 {
+		options.Timeout = DefaultCheckTimeout
+	}
+
+	filePaths := options.FilePaths
+	if options.ConfigPath != "" {
+		config, err := LoadConfig(options.ConfigPath)
+		if err != nil {
+			add(CheckSeverityError, options.ConfigPath, err)
+		} else {
+			filePaths = append(append([]string{}, filePaths...), config.FilePatterns()...)
+		}
+	}
+	if err := options.Settings.Validate(); err != nil {
+		for _, err := range unjoin(err) {
+			add(CheckSeverityError, "flags", err)
+		}
+	}
+	if _, err := NewRotationSuffixes(options.RotationSuffixes); err != nil {
+		add(CheckSeverityError, "rotation-suffix", err)
+	}
+	if options.DataDir != "" {
+		if err := checkWritableDir(options.DataDir); err != nil {
+			add(CheckSeverityError, options.DataDir, err)
+		}
+	}
+
+	for _, labeled := range filePaths {
+		_, pattern := ParseFilePattern(labeled)
+		if IsHTTPURL(pattern) {
+			if err := checkHTTPSource(ctx, pattern, options.Timeout); err != nil {
+				add(CheckSeverityError, labeled, err)
+			}
+			continue
+		}
+		findings = append(findings, checkFilePattern(ctx, labeled, pattern, false, nil)...)
+	}
+	for _, sshPath := range options.SSHPaths {
+		findings = append(findings, checkSSHPath(ctx, sshPath, options.Timeout)...)
+	}
+	if len(options.DockerPaths) > 0 {
+		findings = append(findings, checkDockerPaths(options.DockerPaths)...)
+	}
+	if len(options.K8sPaths) > 0 {
+		findings = append(findings, checkK8sPaths(ctx, options.K8sPaths, options.Timeout)...)
+	}
+	for _, unit := range options.JournalUnits {
+		if _, err := StringToJournalPathConfig(unit); err != nil {
+			add(CheckSeverityError, unit, err)
+		}
+	}
+	if len(options.JournalUnits) > 0 {
+		if _, err := exec.LookPath("journalctl"); err != nil {
+			add(CheckSeverityWarning, strings.Join(options.JournalUnits, ", "), ErrJournalctlNotFound)
+		}
+	}
+	for _, remotePath := range options.RemotePaths {
+		if err := checkRemotePath(ctx, remotePath, options.Timeout); err != nil {
+			add(CheckSeverityError, remotePath, err)
+		}
+	}
+	return findings
+}
+
+// unjoin lists the errors joined by errors.Join
+func unjoin(err error) []error {
+	var joined interface{ Unwrap() []error }
+	if errors.As(err, &joined) {
+		return joined.Unwrap()
+	}
+	return []error{err}
+}
+
+func checkWritableDir(dir string) error {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(dir, ".gol-check-*")
+	if err != nil {
+		return fmt.Errorf("data dir is not writable: %w", err)
+	}
+	tmp.Close()
+	return os.Remove(tmp.Name())
+}
+
+func checkFilePattern(ctx context.Context, source string, pattern string, isRemote bool, sshConfig *SSHConfig) []CheckFinding {
+	filePaths, err := FilesByPatternContext(ctx, pattern, isRemote, sshConfig)
+	if err != nil {
+		return []CheckFinding{{Severity: CheckSeverityError, Source: source, Message: err.Error()}}
+	}
+	if len(filePaths) == 0 {
+		return []CheckFinding{{Severity: CheckSeverityWarning, Source: source, Message: "pattern matches no files"}}
+	}
+	findings := []CheckFinding{}
+	for _, filePath := range filePaths {
+		if _, err := IsReadableFileContext(ctx, filePath, isRemote, sshConfig, false); err != nil {
+			findings = append(findings, CheckFinding{Severity: CheckSeverityError, Source: source, Message: fmt.Sprintf("%s: %s", filePath, err)})
+		}
+	}
+	return findings
+}
+
+func checkSSHPath(ctx context.Context, sshPath string, timeout time.Duration) []CheckFinding {
+	sshPathConfig, err := StringToSSHPathConfig(sshPath)
+	if err != nil {
+		return []CheckFinding{{Severity: CheckSeverityError, Source: sshPath, Message: err.Error()}}
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	// a fresh connection, a cached client would hide connection and auth failures
+	client, err := sshConnect(ctx, sshPathConfig.ToSSHConfig())
+	if err != nil {
+		return []CheckFinding{{Severity: CheckSeverityError, Source: sshPath, Message: err.Error()}}
+	}
+	client.Close()
+	return checkFilePattern(ctx, sshPath, sshPathConfig.FilePath, true, sshPathConfig.ToSSHConfig())
+}
+
+// checkDockerPaths checks the containers named or given by ID in the docker paths exist
+func checkDockerPaths(dockerPaths []string) []CheckFinding {
+	containers, err := ListDockerContainers()
+	if err != nil {
+		return []CheckFinding{{Severity: CheckSeverityError, Source: strings.Join(dockerPaths, ", "), Message: err.Error()}}
+	}
+	findings := []CheckFinding{}
+	for _, pattern := range dockerPaths {
+		fields := strings.Fields(pattern)
+		switch len(fields) {
+		case 0:
+			if len(containers) == 0 {
+				findings = append(findings, CheckFinding{Severity: CheckSeverityWarning, Source: pattern, Message: "no running containers"})
+			}
+		case 1:
+			found := false
+			for _, container := range containers {
+				found = found || MatchContainer(container, pattern)
+			}
+			if !found {
+				findings = append(findings, CheckFinding{Severity: CheckSeverityError, Source: pattern, Message: "no running container name contains " + pattern})
+			}
+		default:
+			dockerPathConfig, err := StringToDockerPathConfig(pattern)
+			if err != nil {
+				findings = append(findings, CheckFinding{Severity: CheckSeverityError, Source: pattern, Message: err.Error()})
+				continue
+			}
+			found := false
+			for _, container := range containers {
+				found = found || MatchContainer(container, dockerPathConfig.ContainerID)
+			}
+			if !found {
+				findings = append(findings, CheckFinding{Severity: CheckSeverityError, Source: pattern, Message: "container not found: " + dockerPathConfig.ContainerID})
+			}
+		}
+	}
+	return findings
+}
+
+// checkK8sPaths checks the pods of the k8s paths exist and are not pending
+func checkK8sPaths(ctx context.Context, k8sPaths []string, timeout time.Duration) []CheckFinding {
+	client, err := NewK8sClient()
+	if err != nil {
+		return []CheckFinding{{Severity: CheckSeverityError, Source: strings.Join(k8sPaths, ", "), Message: err.Error()}}
+	}
+	source := NewK8sSource(client, k8sPaths)
+	findings := []CheckFinding{}
+	for _, pattern := range k8sPaths {
+		config, err := StringToK8sPathConfig(pattern)
+		if err != nil {
+			findings = append(findings, CheckFinding{Severity: CheckSeverityError, Source: pattern, Message: err.Error()})
+			continue
+		}
+		ctx, cancel := context.WithTimeout(ctx, timeout)
+		containers, err := source.Containers(ctx, config)
+		cancel()
+		if err != nil {
+			findings = append(findings, CheckFinding{Severity: CheckSeverityError, Source: pattern, Message: err.Error()})
+		} else if len(containers) == 0 {
+			findings = append(findings, CheckFinding{Severity: CheckSeverityWarning, Source: pattern, Message: "no running pods"})
+		}
+	}
+	return findings
+}
+
+func checkHTTPSource(ctx context.Context, rawURL string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	_, _, err := GlobalHTTPSources.Stat(ctx, rawURL)
+	return err
+}
+
+func checkRemotePath(ctx context.Context, remotePath string, timeout time.Duration) error {
+	remoteConfig, err := StringToRemotePathConfig(remotePath)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	_, err = NewRemoteClient(*remoteConfig, nil).ListFiles(ctx)
+	return err
+}
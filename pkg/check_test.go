@@ -0,0 +1,69 @@
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunChecks(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte("INFO started\n"), 0600))
+	config := filepath.Join(dir, "gol.yaml")
+	assert.NoError(t, os.WriteFile(config, []byte("paths:\n  - pattern: "+filepath.Join(dir, "*.txt")+"\n"), 0600))
+	notADir := filepath.Join(dir, "file")
+	assert.NoError(t, os.WriteFile(notADir, nil, 0600))
+
+	settings := Settings{Every: 10 * time.Second, Limit: 1000, Port: 3003, BaseURL: "/"}
+
+	t.Run("ok", func(t *testing.T) {
+		findings := RunChecks(context.Background(), CheckOptions{
+			Settings:  settings,
+			FilePaths: []string{logFile},
+			DataDir:   filepath.Join(dir, "data"),
+		})
+		assert.Empty(t, findings)
+	})
+
+	t.Run("problems", func(t *testing.T) {
+		badSettings := settings
+		badSettings.Port = 70000
+		badSettings.Limit = 0
+		findings := RunChecks(context.Background(), CheckOptions{
+			ConfigPath:       config,
+			Settings:         badSettings,
+			FilePaths:        []string{logFile, "["},
+			SSHPaths:         []string{"no-host-part"},
+			RemotePaths:      []string{"ftp://peer"},
+			RotationSuffixes: []string{"(unclosed"},
+			DataDir:          notADir,
+		})
+		bySource := map[string][]string{}
+		for _, finding := range findings {
+			bySource[finding.Source] = append(bySource[finding.Source], finding.Severity)
+		}
+		assert.Equal(t, map[string][]string{
+			"flags":                     {CheckSeverityError, CheckSeverityError},
+			"rotation-suffix":           {CheckSeverityError},
+			notADir:                     {CheckSeverityError},
+			"[":                         {CheckSeverityError},
+			filepath.Join(dir, "*.txt"): {CheckSeverityWarning},
+			"no-host-part":              {CheckSeverityError},
+			"ftp://peer":                {CheckSeverityError},
+		}, bySource)
+	})
+
+	t.Run("invalid config", func(t *testing.T) {
+		invalid := filepath.Join(dir, "invalid.yaml")
+		assert.NoError(t, os.WriteFile(invalid, []byte("paths:\n  - defaults:\n      view: grid\n"), 0600))
+		findings := RunChecks(context.Background(), CheckOptions{ConfigPath: invalid, Settings: settings})
+		assert.Len(t, findings, 1)
+		assert.Equal(t, CheckSeverityError, findings[0].Severity)
+		assert.Contains(t, findings[0].Message, "pattern is required")
+	})
+}
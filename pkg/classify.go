@@ -0,0 +1,177 @@
+package pkg
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"unicode"
+
+	"github.com/acarl005/stripansi"
+)
+
+// Line classes, the severity of a line as the API reports it.
+// Clients style lines by class instead of guessing severity from the text.
+const (
+	ClassError   = "error"
+	ClassWarn    = "warn"
+	ClassInfo    = "info"
+	ClassDebug   = "debug"
+	ClassTrace   = "trace"
+	ClassUnknown = "unknown"
+	// ClassStack is a continuation line of a stack trace or multi line message
+	ClassStack = "stack"
+	// ClassAccess is a line in the common or combined access log format
+	ClassAccess = "access"
+
+	// classMaxWords is how many leading words of a line are looked up for a keyword,
+	// a keyword further into the message is part of the message, not the severity
+	classMaxWords = 8
+)
+
+// Classes are every class a line can have
+var Classes = []string{ClassError, ClassWarn, ClassInfo, ClassDebug, ClassTrace, ClassUnknown, ClassStack, ClassAccess}
+
+// ClassRule gives the class of lines with one of the keywords among their leading words.
+// Keywords are matched as whole words ignoring case.
+type ClassRule struct {
+	Class    string   `json:"class"`
+	Keywords []string `json:"keywords"`
+}
+
+// DefaultClassRules classify the lines of paths without overrides
+var DefaultClassRules = []ClassRule{
+	{Class: ClassError, Keywords: []string{"error", "err", "fail", "failed", "fatal", "severe", "critical", "crit", "danger", "panic", "emerg"}},
+	{Class: ClassWarn, Keywords: []string{"warn", "warning", "wrn", "alert"}},
+	{Class: ClassInfo, Keywords: []string{"info", "inf", "notice", "success", "succ"}},
+	{Class: ClassDebug, Keywords: []string{"debug", "dbg"}},
+	{Class: ClassTrace, Keywords: []string{"trace", "trc"}},
+}
+
+// StackStarts start a stack trace, StackIndentedStarts continue one after indentation
+var (
+	StackStarts         = []string{"Caused by:", "Traceback (most recent call last)", "goroutine "}
+	StackIndentedStarts = []string{"at ", "... ", "File \"", "Caused by:"}
+)
+
+// AccessParsers are the parsers of path defaults whose files are access logs
+var AccessParsers = []string{"common", "combined"}
+
+// accessLine is an access log line: host ident user [time] "request" status size
+var accessLine = regexp.MustCompile(`^\S+ \S+ \S+ \[[^\]]+\] "[^"]*" \d{3} (\d+|-)`)
+
+// levelClasses maps the levels of DetectLevel and JudgeLogLevel to classes
+var levelClasses = map[string]string{
+	"success":  ClassInfo,
+	LevelInfo:  ClassInfo,
+	LevelError: ClassError,
+	"danger":   ClassError,
+	LevelWarn:  ClassWarn,
+	LevelDebug: ClassDebug,
+	LevelTrace: ClassTrace,
+}
+
+// Classifier gives lines their class
+type Classifier struct {
+	keywords map[string]string
+	access   bool
+}
+
+// NewClassifier classifies with the default rules, overridden by the keywords of overrides,
+// a map of class to keywords. An access log parser makes access the class of lines it parses.
+func NewClassifier(overrides map[string][]string, parser string) *Classifier {
+	c := &Classifier{keywords: map[string]string{}}
+	for _, rule := range DefaultClassRules {
+		for _, keyword := range rule.Keywords {
+			c.keywords[strings.ToLower(keyword)] = rule.Class
+		}
+	}
+	for _, class := range Classes {
+		for _, keyword := range overrides[class] {
+			c.keywords[strings.ToLower(keyword)] = class
+		}
+	}
+	for _, accessParser := range AccessParsers {
+		c.access = c.access || parser == accessParser
+	}
+	return c
+}
+
+var defaultClassifier = NewClassifier(nil, "")
+
+// ClassifierFor is the classifier of the path defaults of filePath
+func ClassifierFor(filePath string) *Classifier {
+	defaults := GlobalPathDefaults.For(filePath)
+	if defaults == nil || (len(defaults.Classes) == 0 && defaults.Parser == "") {
+		return defaultClassifier
+	}
+	return NewClassifier(defaults.Classes, defaults.Parser)
+}
+
+// Classify returns the class of a line, level is the one judged for it, if any.
+// Stack continuations come first, then access log lines, keywords among the leading words
+// and finally the judged level.
+func (c *Classifier) Classify(content string, level string) string {
+	content = stripansi.Strip(content)
+	if isStackLine(content) {
+		return ClassStack
+	}
+	if c.access && accessLine.MatchString(content) {
+		return ClassAccess
+	}
+	words := strings.FieldsFunc(content, func(r rune) bool {
+		return !unicode.IsLetter(r)
+	})
+	for i, word := range words {
+		if i == classMaxWords {
+			break
+		}
+		if class, ok := c.keywords[strings.ToLower(word)]; ok {
+			return class
+		}
+	}
+	if class, ok := levelClasses[level]; ok {
+		return class
+	}
+	return ClassUnknown
+}
+
+// ClassifyLines sets the class of lines whose level was already judged
+func (c *Classifier) ClassifyLines(lines []LineResult) {
+	for i, line := range lines {
+		lines[i].Class = c.Classify(line.Content, line.Level)
+	}
+}
+
+// isStackLine tells whether a line starts a stack trace or an indented line continues one
+func isStackLine(line string) bool {
+	for _, start := range StackStarts {
+		if strings.HasPrefix(line, start) {
+			return true
+		}
+	}
+	trimmed := strings.TrimLeft(line, " \t")
+	if trimmed == "" || len(trimmed) == len(line) {
+		return false
+	}
+	for _, start := range StackIndentedStarts {
+		if strings.HasPrefix(trimmed, start) {
+			return true
+		}
+	}
+	// Go stack frames are a tab indented file:line
+	return line[0] == '\t' && strings.Contains(trimmed, ".go:")
+}
+
+// ValidateClasses rejects overrides naming a class that does not exist
+func ValidateClasses(overrides map[string][]string) error {
+	for class := range overrides {
+		known := false
+		for _, c := range Classes {
+			known = known || c == class
+		}
+		if !known {
+			return fmt.Errorf("classes: unknown class %q, must be one of %s", class, strings.Join(Classes, " "))
+		}
+	}
+	return nil
+}
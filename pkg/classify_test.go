@@ -0,0 +1,75 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClassifier_Classify(t *testing.T) {
+	access := `127.0.0.1 - frank [10/Oct/2000:13:55:36 -0700] "GET /error.gif HTTP/1.0" 500 2326`
+	tests := []struct {
+		name       string
+		classifier *Classifier
+		content    string
+		level      string
+		want       string
+	}{
+		{"keyword", defaultClassifier, "2024-06-01 12:00:00 ERROR disk full", "", ClassError},
+		{"first keyword wins", defaultClassifier, "INFO request failed", "", ClassInfo},
+		{"case and brackets", defaultClassifier, "[Warning] low memory", "", ClassWarn},
+		{"ansi", defaultClassifier, "\x1b[36mDEBUG\x1b[0m cache miss", "", ClassDebug},
+		{"trace", defaultClassifier, "TRACE entering handler", "", ClassTrace},
+		{"whole words only", defaultClassifier, "information about errors", "", ClassUnknown},
+		{"keyword past the leading words", defaultClassifier, "a b c d e f g h error", "", ClassUnknown},
+		{"judged level", defaultClassifier, "x", "danger", ClassError},
+		{"no keyword", defaultClassifier, "listening on :8080", "", ClassUnknown},
+		{"java frame", defaultClassifier, "\tat com.example.Main.run(Main.java:12)", "", ClassStack},
+		{"caused by", defaultClassifier, "Caused by: java.io.IOException: error", "", ClassStack},
+		{"python frame", defaultClassifier, `  File "app.py", line 3, in <module>`, "", ClassStack},
+		{"go frame", defaultClassifier, "\t/src/app/main.go:42 +0x1d", "", ClassStack},
+		{"indented text", defaultClassifier, "  ERROR nested", "", ClassError},
+		{"access without parser", defaultClassifier, access, "", ClassError},
+		{"access", NewClassifier(nil, "combined"), access, "", ClassAccess},
+		{"override", NewClassifier(map[string][]string{ClassError: {"SEVERE"}, ClassWarn: {"FATAL"}}, ""), "FATAL but not really", "", ClassWarn},
+		{"override keeps defaults", NewClassifier(map[string][]string{ClassError: {"SEVERE"}}, ""), "DEBUG x", "", ClassDebug},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, tt.classifier.Classify(tt.content, tt.level))
+		})
+	}
+}
+
+func TestValidateClasses(t *testing.T) {
+	assert.NoError(t, ValidateClasses(map[string][]string{ClassError: {"SEVERE"}, ClassTrace: {"FINEST"}}))
+	assert.Error(t, ValidateClasses(map[string][]string{"fatal": {"FATAL"}}))
+}
+
+func TestAPIHandler_GetClasses(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte("SEVERE db down\n\tat Db.connect(Db.java:1)\nINFO retrying\n"), 0600))
+
+	defer GlobalPathDefaults.Set(nil)
+	GlobalPathDefaults.Set([]PathConfig{{Pattern: filepath.Join(dir, "*.log"), Defaults: &ViewDefaults{Classes: map[string][]string{ClassError: {"SEVERE"}}}}})
+	GlobalFileRegistry.Replace(ApplyPathDefaults([]FileInfo{{FilePath: logFile, Type: TypeFile}}))
+
+	e := echo.New()
+	req := httptest.NewRequest(http.MethodGet, "/api?type=file&file_path="+logFile, nil)
+	rec := httptest.NewRecorder()
+	assert.NoError(t, NewAPIHandler().Get(e.NewContext(req, rec)))
+	res := APIResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	classes := []string{}
+	for _, line := range res.Result.Lines {
+		classes = append(classes, line.Class)
+	}
+	assert.Equal(t, []string{ClassError, ClassStack, ClassInfo}, classes)
+}
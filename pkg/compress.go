@@ -0,0 +1,225 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/andybalholm/brotli"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	CompressionGzip = "gzip"
+	CompressionBr   = "br"
+	CompressionOff  = "off"
+
+	// context key holding the negotiated encoding, so handlers can serve precompressed bodies
+	compressionEncodingKey = "compression_encoding"
+)
+
+// compressibleContentTypes is the allowlist of content types that get compressed.
+// Already compressed downloads and SSE streams are not in it.
+var compressibleContentTypes = []string{
+	"text/html",
+	"text/plain",
+	"text/css",
+	"text/javascript",
+	"application/javascript",
+	"application/json",
+	"image/svg+xml",
+	"image/x-icon",
+}
+
+// Compress is a middleware compressing responses with brotli or gzip as negotiated by Accept-Encoding
+func Compress(options *EchoOptions) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if options.Compression == CompressionOff || c.Request().Header.Get(echo.HeaderAccept) == "text/event-stream" || c.IsWebSocket() {
+				return next(c)
+			}
+			encoding := NegotiateEncoding(c.Request().Header.Get(echo.HeaderAcceptEncoding), options.Compression)
+			if encoding == "" {
+				return next(c)
+			}
+			c.Set(compressionEncodingKey, encoding)
+
+			res := c.Response()
+			res.Header().Add(echo.HeaderVary, echo.HeaderAcceptEncoding)
+			cw := &compressResponseWriter{
+				ResponseWriter: res.Writer,
+				encoding:       encoding,
+				level:          options.compressionLevel(encoding),
+			}
+			res.Writer = cw
+			defer func() {
+				if cw.writer != nil {
+					cw.writer.Close()
+				}
+				res.Writer = cw.ResponseWriter
+			}()
+			return next(c)
+		}
+	}
+}
+
+// NegotiateEncoding picks the encoding to use given the Accept-Encoding header and the configured mode.
+// br is preferred over gzip when both are accepted and br is enabled.
+func NegotiateEncoding(acceptEncoding string, mode string) string {
+	accepted := map[string]bool{}
+	for _, part := range strings.Split(acceptEncoding, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		name := strings.ToLower(strings.TrimSpace(fields[0]))
+		q := 1.0
+		for _, param := range fields[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					q = v
+				}
+			}
+		}
+		accepted[name] = q > 0
+	}
+	if mode == CompressionBr && accepted[CompressionBr] {
+		return CompressionBr
+	}
+	if (mode == CompressionBr || mode == CompressionGzip) && accepted[CompressionGzip] {
+		return CompressionGzip
+	}
+	return ""
+}
+
+func (o *EchoOptions) compressionLevel(encoding string) int {
+	if encoding == CompressionBr {
+		return o.BrotliLevel
+	}
+	return o.GzipLevel
+}
+
+func newCompressWriter(w io.Writer, encoding string, level int) io.WriteCloser {
+	if encoding == CompressionBr {
+		return brotli.NewWriterLevel(w, level)
+	}
+	gz, err := gzip.NewWriterLevel(w, level)
+	if err != nil {
+		gz = gzip.NewWriter(w)
+	}
+	return gz
+}
+
+func isCompressibleContentType(contentType string) bool {
+	contentType = strings.ToLower(strings.TrimSpace(strings.Split(contentType, ";")[0]))
+	return StringInSlice(contentType, compressibleContentTypes)
+}
+
+type compressResponseWriter struct {
+	http.ResponseWriter
+	encoding string
+	level    int
+	writer   io.WriteCloser
+	decided  bool
+}
+
+// decide starts compressing once the content type is known
+func (w *compressResponseWriter) decide() {
+	if w.decided {
+		return
+	}
+	w.decided = true
+	header := w.Header()
+	if header.Get(echo.HeaderContentEncoding) != "" || !isCompressibleContentType(header.Get(echo.HeaderContentType)) {
+		return
+	}
+	// the ranges of a file served in ranges are of its bytes as is, a compressed body would not match them
+	if header.Get("Accept-Ranges") == "bytes" {
+		return
+	}
+	header.Del(echo.HeaderContentLength)
+	header.Set(echo.HeaderContentEncoding, w.encoding)
+	w.writer = newCompressWriter(w.ResponseWriter, w.encoding, w.level)
+}
+
+func (w *compressResponseWriter) WriteHeader(code int) {
+	if code != http.StatusNoContent && code != http.StatusNotModified {
+		w.decide()
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *compressResponseWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		if w.Header().Get(echo.HeaderContentType) == "" {
+			w.Header().Set(echo.HeaderContentType, http.DetectContentType(b))
+		}
+		w.decide()
+	}
+	if w.writer != nil {
+		return w.writer.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *compressResponseWriter) Flush() {
+	if f, ok := w.writer.(interface{ Flush() error }); ok {
+		f.Flush() //nolint: errcheck
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (w *compressResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if h, ok := w.ResponseWriter.(http.Hijacker); ok {
+		return h.Hijack()
+	}
+	return nil, nil, errors.New("hijack not supported")
+}
+
+func (w *compressResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// precompressed memoizes compressed bodies of static assets, they are compressed once per encoding
+type precompressed struct {
+	bodies sync.Map
+}
+
+func (p *precompressed) get(key string, encoding string, body []byte) []byte {
+	cacheKey := encoding + ":" + key
+	if b, ok := p.bodies.Load(cacheKey); ok {
+		return b.([]byte)
+	}
+	var buf bytes.Buffer
+	w := newCompressWriter(&buf, encoding, compressionBestLevel(encoding))
+	w.Write(body) //nolint: errcheck
+	w.Close()
+	p.bodies.Store(cacheKey, buf.Bytes())
+	return buf.Bytes()
+}
+
+func compressionBestLevel(encoding string) int {
+	if encoding == CompressionBr {
+		return brotli.BestCompression
+	}
+	return gzip.BestCompression
+}
+
+var assetsPrecompressed = &precompressed{}
+
+// blobPrecompressed responds with a precompressed body when an encoding was negotiated for the request
+func blobPrecompressed(c echo.Context, contentType string, key string, body []byte) error {
+	encoding, _ := c.Get(compressionEncodingKey).(string)
+	if encoding == "" || !isCompressibleContentType(contentType) {
+		return c.Blob(http.StatusOK, contentType, body)
+	}
+	c.Response().Header().Set(echo.HeaderContentEncoding, encoding)
+	return c.Blob(http.StatusOK, contentType, assetsPrecompressed.get(key, encoding, body))
+}
@@ -0,0 +1,62 @@
+package pkg
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/andybalholm/brotli"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		acceptEncoding string
+		mode           string
+		want           string
+	}{
+		{"gzip, deflate, br", CompressionBr, CompressionBr},
+		{"gzip, deflate, br", CompressionGzip, CompressionGzip},
+		{"gzip, br;q=0", CompressionBr, CompressionGzip},
+		{"br", CompressionGzip, ""},
+		{"gzip, br", CompressionOff, ""},
+		{"", CompressionBr, ""},
+	}
+	for _, tt := range tests {
+		t.Run(tt.acceptEncoding+"/"+tt.mode, func(t *testing.T) {
+			assert.Equal(t, tt.want, NegotiateEncoding(tt.acceptEncoding, tt.mode))
+		})
+	}
+}
+
+func TestCompress(t *testing.T) {
+	e := echo.New()
+	e.Use(Compress(&EchoOptions{Compression: CompressionBr, BrotliLevel: brotli.DefaultCompression}))
+	body := strings.Repeat(`{"content":"ERROR An error occurred"}`, 100)
+	e.GET("/json", func(c echo.Context) error {
+		return c.Blob(http.StatusOK, echo.MIMEApplicationJSON, []byte(body))
+	})
+	e.GET("/download", func(c echo.Context) error {
+		return c.Blob(http.StatusOK, "application/gzip", []byte(body))
+	})
+
+	req := httptest.NewRequest(http.MethodGet, "/json", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, "gzip, br")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, CompressionBr, rec.Header().Get(echo.HeaderContentEncoding))
+	decoded, err := io.ReadAll(brotli.NewReader(rec.Body))
+	assert.NoError(t, err)
+	assert.Equal(t, body, string(decoded))
+
+	// already compressed content types are skipped
+	req = httptest.NewRequest(http.MethodGet, "/download", nil)
+	req.Header.Set(echo.HeaderAcceptEncoding, "gzip, br")
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Empty(t, rec.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, body, rec.Body.String())
+}
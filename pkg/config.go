@@ -0,0 +1,495 @@
+package pkg
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	ViewTable = "table"
+	ViewLines = "lines"
+
+	OrderAsc  = "asc"
+	OrderDesc = "desc"
+)
+
+// redacted replaces the secrets of a config when it is printed or logged
+const redacted = "REDACTED"
+
+// unknownConfigKey is how yaml tells of a key no field is decoded from
+var unknownConfigKey = regexp.MustCompile(`field (\S+) not found in type \S+`)
+
+// Config is the config file given with -config, flags given on the command line win over it.
+// Only the paths and their defaults are applied on reload, the other settings need a restart.
+type Config struct {
+	Host    string `yaml:"host,omitempty"`
+	Port    int64  `yaml:"port,omitempty"`
+	BaseURL string `yaml:"base_url,omitempty"`
+	// Every is a duration like 10s, as -every
+	Every       string          `yaml:"every,omitempty"`
+	Limit       int             `yaml:"limit,omitempty"`
+	Paths       []PathConfig    `yaml:"paths,omitempty"`
+	SSHPaths    []ConfigSSHPath `yaml:"ssh_paths,omitempty"`
+	DockerPaths []string        `yaml:"docker_paths,omitempty"`
+	// Excludes are globs of the files not to list, as -exclude
+	Excludes []string    `yaml:"excludes,omitempty"`
+	Auth     *AuthConfig `yaml:"auth,omitempty"`
+	// Alerts are webhook rules, as -alert
+	Alerts []AlertRule `yaml:"alerts,omitempty"`
+	// AlertURL is the external URL of gol the share links of the alerts are built on, as -alert-url
+	AlertURL string `yaml:"alert_url,omitempty"`
+}
+
+// PathConfig is a watched file path pattern with its presentation defaults
+type PathConfig struct {
+	Pattern string `yaml:"pattern"`
+	// Label groups the files of the pattern in the file list, as "label:pattern" does for -f
+	Label    string        `yaml:"label,omitempty"`
+	Defaults *ViewDefaults `yaml:"defaults,omitempty"`
+}
+
+// ConfigSSHPath is an SSH path of the config file, the parts of a -s path as fields of their own
+type ConfigSSHPath struct {
+	Host       string `yaml:"host"`
+	Port       int    `yaml:"port,omitempty"`
+	User       string `yaml:"user"`
+	Key        string `yaml:"key,omitempty"`
+	Password   string `yaml:"password,omitempty"`
+	KnownHosts string `yaml:"known_hosts,omitempty"`
+	Insecure   bool   `yaml:"insecure,omitempty"`
+	File       string `yaml:"file"`
+}
+
+// AuthConfig are the credentials of the API, as -token, -auth-token, -auth-user, -auth-file and -admin-token
+type AuthConfig struct {
+	Token      string   `yaml:"token,omitempty"`
+	Tokens     []string `yaml:"tokens,omitempty"`
+	Users      []string `yaml:"users,omitempty"`
+	File       string   `yaml:"file,omitempty"`
+	AdminToken string   `yaml:"admin_token,omitempty"`
+}
+
+// ViewDefaults are how files matching a pattern are presented unless the request says otherwise
+type ViewDefaults struct {
+	Parser    string `yaml:"parser,omitempty" json:"parser,omitempty"`
+	View      string `yaml:"view,omitempty" json:"view,omitempty"`
+	Order     string `yaml:"order,omitempty" json:"order,omitempty"`
+	Multiline string `yaml:"multiline,omitempty" json:"multiline,omitempty"`
+	Timezone  string `yaml:"timezone,omitempty" json:"timezone,omitempty"`
+	// Processor is the registered line processor the files are read with
+	Processor string `yaml:"processor,omitempty" json:"processor,omitempty"`
+	// Classes are keywords per line class overriding the default rules, e.g. error: [SEVERE]
+	Classes map[string][]string `yaml:"classes,omitempty" json:"classes,omitempty"`
+}
+
+// LoadConfig reads and validates the config file at path, a key it does not know is an error naming it
+func LoadConfig(path string) (*Config, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	config := &Config{}
+	decoder := yaml.NewDecoder(bytes.NewReader(b))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(config); err != nil && !errors.Is(err, io.EOF) {
+		var typeErr *yaml.TypeError
+		if errors.As(err, &typeErr) {
+			for i, message := range typeErr.Errors {
+				typeErr.Errors[i] = unknownConfigKey.ReplaceAllString(message, "unknown key $1")
+			}
+		}
+		return nil, fmt.Errorf("parsing config %s: %w", path, err)
+	}
+	if err := config.Validate(); err != nil {
+		return nil, fmt.Errorf("validating config %s: %w", path, err)
+	}
+	return config, nil
+}
+
+func (c *Config) Validate() error {
+	if c.Port != 0 {
+		if err := ValidatePort(c.Port); err != nil {
+			return err
+		}
+	}
+	if c.Every != "" {
+		every, err := ParseEvery(c.Every)
+		if err != nil {
+			return err
+		}
+		if err := ValidateEvery(every); err != nil {
+			return err
+		}
+	}
+	if c.Limit != 0 {
+		if err := ValidateLimit(c.Limit); err != nil {
+			return err
+		}
+	}
+	for i, p := range c.SSHPaths {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("ssh_paths[%d]: %w", i, err)
+		}
+	}
+	for i, d := range c.DockerPaths {
+		if _, err := StringToDockerPathConfig(d); err != nil {
+			return fmt.Errorf("docker_paths[%d] %q: %w", i, d, err)
+		}
+	}
+	for i, exclude := range c.Excludes {
+		if _, err := filepath.Match(exclude, ""); err != nil {
+			return fmt.Errorf("excludes[%d] %q: %w", i, exclude, err)
+		}
+	}
+	if c.Auth != nil {
+		for i, user := range c.Auth.Users {
+			if _, _, err := ParseAuthUser(user); err != nil {
+				return fmt.Errorf("auth.users[%d]: %w", i, err)
+			}
+		}
+	}
+	for i, rule := range c.Alerts {
+		if err := rule.Validate(); err != nil {
+			return fmt.Errorf("alerts[%d]: %w", i, err)
+		}
+	}
+	for i, p := range c.Paths {
+		if p.Pattern == "" {
+			return fmt.Errorf("paths[%d]: pattern is required", i)
+		}
+		if label, _ := ParseFilePattern(p.Label + ":" + p.Pattern); p.Label != "" && label != p.Label {
+			return fmt.Errorf("paths[%d] %s: label %q must be 2 or more letters, digits, _, . or -", i, p.Pattern, p.Label)
+		}
+		if p.Defaults == nil {
+			continue
+		}
+		if err := p.Defaults.Validate(); err != nil {
+			return fmt.Errorf("paths[%d] %s: %w", i, p.Pattern, err)
+		}
+	}
+	return nil
+}
+
+func (d *ViewDefaults) Validate() error {
+	if d.View != "" && d.View != ViewTable && d.View != ViewLines {
+		return fmt.Errorf("view must be one of %s %s", ViewTable, ViewLines)
+	}
+	if d.Order != "" && d.Order != OrderAsc && d.Order != OrderDesc {
+		return fmt.Errorf("order must be one of %s %s", OrderAsc, OrderDesc)
+	}
+	if d.Multiline != "" {
+		if _, err := regexp.Compile(d.Multiline); err != nil {
+			return fmt.Errorf("multiline: %w", err)
+		}
+	}
+	if d.Timezone != "" {
+		if _, err := time.LoadLocation(d.Timezone); err != nil {
+			return fmt.Errorf("timezone: %w", err)
+		}
+	}
+	if d.Processor != "" {
+		if _, ok := GlobalProcessors.Get(d.Processor); !ok {
+			return fmt.Errorf("processor %q is not registered", d.Processor)
+		}
+	}
+	return ValidateClasses(d.Classes)
+}
+
+// Validate requires the host, the user and the file. A field must not contain spaces, the path is
+// handed on as a -s path.
+func (p *ConfigSSHPath) Validate() error {
+	if p.Host == "" || p.User == "" || p.File == "" {
+		return errors.New("host, user and file are required")
+	}
+	for name, value := range map[string]string{"host": p.Host, "user": p.User, "key": p.Key, "password": p.Password, "known_hosts": p.KnownHosts, "file": p.File} {
+		if strings.ContainsAny(value, " \t\n") {
+			return fmt.Errorf("%s must not contain spaces", name)
+		}
+	}
+	return nil
+}
+
+// String is the path as given with -s
+func (p ConfigSSHPath) String() string {
+	parts := []string{p.User + "@" + p.Host}
+	if p.Port != 0 {
+		parts[0] += ":" + strconv.Itoa(p.Port)
+	}
+	if p.Password != "" {
+		parts = append(parts, "password="+p.Password)
+	}
+	if p.Key != "" {
+		parts = append(parts, "private_key="+p.Key)
+	}
+	if p.KnownHosts != "" {
+		parts = append(parts, "known_hosts="+p.KnownHosts)
+	}
+	if p.Insecure {
+		parts = append(parts, "insecure=true")
+	}
+	return strings.Join(append(parts, p.File), " ")
+}
+
+// NewConfigSSHPath is the config of a parsed -s path
+func NewConfigSSHPath(c SSHPathConfig) ConfigSSHPath {
+	port, _ := strconv.Atoi(c.Port)
+	return ConfigSSHPath{
+		Host:       c.Host,
+		Port:       port,
+		User:       c.User,
+		Key:        c.PrivateKeyPath,
+		Password:   c.Password,
+		KnownHosts: c.KnownHostsPath,
+		Insecure:   c.Insecure,
+		File:       c.FilePath,
+	}
+}
+
+// SSHPathStrings are the SSH paths as given with -s
+func (c *Config) SSHPathStrings() []string {
+	paths := make([]string, 0, len(c.SSHPaths))
+	for _, p := range c.SSHPaths {
+		paths = append(paths, p.String())
+	}
+	return paths
+}
+
+// Redacted is a copy of c with its tokens, passwords and webhook paths replaced, users keep their names
+func (c *Config) Redacted() *Config {
+	r := *c
+	r.SSHPaths = make([]ConfigSSHPath, len(c.SSHPaths))
+	for i, p := range c.SSHPaths {
+		if p.Password != "" {
+			p.Password = redacted
+		}
+		r.SSHPaths[i] = p
+	}
+	if c.Alerts != nil {
+		r.Alerts = make([]AlertRule, len(c.Alerts))
+		for i, rule := range c.Alerts {
+			rule.URL = redactURL(rule.URL)
+			if rule.Email != nil && rule.Email.Password != "" {
+				email := *rule.Email
+				email.Password = redacted
+				rule.Email = &email
+			}
+			r.Alerts[i] = rule
+		}
+	}
+	if c.Auth == nil {
+		return &r
+	}
+	auth := AuthConfig{File: c.Auth.File}
+	if c.Auth.Token != "" {
+		auth.Token = redacted
+	}
+	if c.Auth.AdminToken != "" {
+		auth.AdminToken = redacted
+	}
+	for range c.Auth.Tokens {
+		auth.Tokens = append(auth.Tokens, redacted)
+	}
+	for _, user := range c.Auth.Users {
+		name, _, _ := strings.Cut(user, ":")
+		auth.Users = append(auth.Users, name+":"+redacted)
+	}
+	r.Auth = &auth
+	return &r
+}
+
+// OneLine is the config as a single line of YAML flow style, for the log
+func (c *Config) OneLine() string {
+	node := &yaml.Node{}
+	if err := node.Encode(c); err != nil {
+		return err.Error()
+	}
+	node.Style = yaml.FlowStyle
+	b, err := yaml.Marshal(node)
+	if err != nil {
+		return err.Error()
+	}
+	return strings.TrimSpace(string(b))
+}
+
+// FilePatterns are the watched patterns of the config
+func (c *Config) FilePatterns() []string {
+	patterns := make([]string, 0, len(c.Paths))
+	for _, p := range c.Paths {
+		if p.Label != "" {
+			patterns = append(patterns, p.Label+":"+p.Pattern)
+			continue
+		}
+		patterns = append(patterns, p.Pattern)
+	}
+	return patterns
+}
+
+// PathDefaults resolves the view defaults of file paths, swapped as a whole on config reload
+type PathDefaults struct {
+	mutex sync.RWMutex
+	paths []PathConfig
+}
+
+func (d *PathDefaults) Set(paths []PathConfig) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	d.paths = paths
+}
+
+// For returns the defaults of the first pattern matching filePath, nil when none does
+func (d *PathDefaults) For(filePath string) *ViewDefaults {
+	d.mutex.RLock()
+	defer d.mutex.RUnlock()
+	for _, p := range d.paths {
+		if p.Defaults == nil {
+			continue
+		}
+		if matched, err := filepath.Match(p.Pattern, filePath); err == nil && matched {
+			return p.Defaults
+		}
+	}
+	return nil
+}
+
+// ApplyPathDefaults sets the Defaults of every local FileInfo
+func ApplyPathDefaults(fileInfos []FileInfo) []FileInfo {
+	for i, fileInfo := range fileInfos {
+		if fileInfo.Type != TypeFile {
+			continue
+		}
+		fileInfos[i].Defaults = GlobalPathDefaults.For(fileInfo.FilePath)
+	}
+	return fileInfos
+}
+
+// ConfigReload summarizes what a reload changed: the patterns of the config file and the files listed
+type ConfigReload struct {
+	Added           []string     `json:"added"`
+	Removed         []string     `json:"removed"`
+	FilesAdded      []LineSource `json:"files_added"`
+	FilesRemoved    []LineSource `json:"files_removed"`
+	RestartRequired []string     `json:"restart_required"`
+}
+
+// ConfigReloader re-reads the config file on SIGHUP or the admin API and applies it in place. Without a
+// config file, a reload rescans the sources of the flags.
+type ConfigReloader struct {
+	mutex           sync.Mutex
+	path            string
+	current         *Config
+	flagFilePaths   []string
+	flagSSHPaths    []string
+	flagDockerPaths []string
+}
+
+// NewConfigReloader reloads path, which may be empty, current is the config the server started with and
+// the flag paths are the sources given by flags, which are kept across reloads
+func NewConfigReloader(path string, current *Config, flagFilePaths []string, flagSSHPaths []string, flagDockerPaths []string) *ConfigReloader {
+	if current == nil {
+		current = &Config{}
+	}
+	return &ConfigReloader{
+		path:            path,
+		current:         current,
+		flagFilePaths:   flagFilePaths,
+		flagSSHPaths:    flagSSHPaths,
+		flagDockerPaths: flagDockerPaths,
+	}
+}
+
+// Reload applies the config file: path defaults are swapped, the sources of the file and the flags
+// rescanned, new files are listed and removed ones dropped, unchanged ones keep their cached stats. The
+// streams of removed files are ended by GlobalSourceReloads. The current config is kept when the file is
+// invalid.
+func (r *ConfigReloader) Reload() (*ConfigReload, error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	config := r.current
+	if r.path != "" {
+		var err error
+		if config, err = LoadConfig(r.path); err != nil {
+			return nil, err
+		}
+	}
+	reload := &ConfigReload{
+		Added:           StringsMissingFrom(config.FilePatterns(), r.current.FilePatterns()),
+		Removed:         StringsMissingFrom(r.current.FilePatterns(), config.FilePatterns()),
+		RestartRequired: []string{},
+	}
+	if config.Host != r.current.Host {
+		reload.RestartRequired = append(reload.RestartRequired, "host")
+	}
+	if config.Port != r.current.Port {
+		reload.RestartRequired = append(reload.RestartRequired, "port")
+	}
+	if config.BaseURL != r.current.BaseURL {
+		reload.RestartRequired = append(reload.RestartRequired, "base_url")
+	}
+	for _, setting := range []struct {
+		name    string
+		changed bool
+	}{
+		{"every", config.Every != r.current.Every},
+		{"limit", config.Limit != r.current.Limit},
+		{"excludes", !reflect.DeepEqual(config.Excludes, r.current.Excludes)},
+		{"auth", !reflect.DeepEqual(config.Auth, r.current.Auth)},
+		{"alerts", !reflect.DeepEqual(config.Alerts, r.current.Alerts)},
+		{"alert_url", config.AlertURL != r.current.AlertURL},
+	} {
+		if setting.changed {
+			reload.RestartRequired = append(reload.RestartRequired, setting.name)
+		}
+	}
+	for _, setting := range reload.RestartRequired {
+		slog.Warn("config setting changed, it takes effect after a restart", "setting", setting)
+	}
+
+	GlobalPathDefaults.Set(config.Paths)
+	filePaths := append(append([]string{}, r.flagFilePaths...), config.FilePatterns()...)
+	sshPaths := append(append([]string{}, r.flagSSHPaths...), config.SSHPathStrings()...)
+	dockerPaths := append(append([]string{}, r.flagDockerPaths...), config.DockerPaths...)
+	_, _, _, limit := GlobalWatchedPatterns.Get()
+	GlobalWatchedPatterns.Set(filePaths, sshPaths, dockerPaths, limit)
+	if GlobalPathWatcher != nil {
+		GlobalPathWatcher.Watch(filePaths)
+	}
+	before := GlobalFileRegistry.Snapshot()
+	UpdateGlobalFilePaths(filePaths, sshPaths, dockerPaths, limit)
+	after := GlobalFileRegistry.Snapshot()
+	reload.FilesAdded = fileInfosMissingFrom(after, before)
+	reload.FilesRemoved = fileInfosMissingFrom(before, after)
+	r.current = config
+	GlobalSourceReloads.Notify()
+	slog.Info("Config reloaded", "path", r.path, "added", reload.Added, "removed", reload.Removed,
+		"files_added", len(reload.FilesAdded), "files_removed", len(reload.FilesRemoved))
+	return reload, nil
+}
+
+// fileInfosMissingFrom are the files of fileInfos that are not in other, by type, host and path
+func fileInfosMissingFrom(fileInfos []FileInfo, other []FileInfo) []LineSource {
+	listed := map[[3]string]bool{}
+	for _, fileInfo := range other {
+		listed[[3]string{fileInfo.Type, fileInfo.Host, fileInfo.FilePath}] = true
+	}
+	missing := []LineSource{}
+	for _, fileInfo := range fileInfos {
+		if !listed[[3]string{fileInfo.Type, fileInfo.Host, fileInfo.FilePath}] {
+			missing = append(missing, LineSource{FilePath: fileInfo.FilePath, Host: fileInfo.Host, Type: fileInfo.Type, Label: fileInfo.Name})
+		}
+	}
+	return missing
+}
@@ -0,0 +1,371 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLoadConfig(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gol.yaml")
+	content := `
+paths:
+  - pattern: /var/log/nginx/*.log
+    defaults:
+      parser: combined
+      view: table
+  - pattern: /var/log/app/*.log
+    defaults:
+      order: desc
+      multiline: '^\S'
+      timezone: Europe/Berlin
+  - pattern: /var/log/other/*.log
+    label: other
+`
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+
+	config, err := LoadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/var/log/nginx/*.log", "/var/log/app/*.log", "other:/var/log/other/*.log"}, config.FilePatterns())
+
+	defaults := &PathDefaults{}
+	defaults.Set(config.Paths)
+	assert.Equal(t, &ViewDefaults{Parser: "combined", View: ViewTable}, defaults.For("/var/log/nginx/access.log"))
+	assert.Equal(t, OrderDesc, defaults.For("/var/log/app/app.log").Order)
+	assert.Nil(t, defaults.For("/var/log/other/x.log"))
+
+	invalid := map[string]string{
+		"view":      "paths:\n  - pattern: a\n    defaults:\n      view: grid\n",
+		"order":     "paths:\n  - pattern: a\n    defaults:\n      order: sideways\n",
+		"multiline": "paths:\n  - pattern: a\n    defaults:\n      multiline: '('\n",
+		"timezone":  "paths:\n  - pattern: a\n    defaults:\n      timezone: Mars/Olympus\n",
+		"classes":   "paths:\n  - pattern: a\n    defaults:\n      classes:\n        fatal: [FATAL]\n",
+		"processor": "paths:\n  - pattern: a\n    defaults:\n      processor: protobuf\n",
+		"pattern":   "paths:\n  - defaults:\n      view: table\n",
+		"label":     "paths:\n  - pattern: a\n    label: web/1\n",
+		"yaml":      "paths: [",
+	}
+	for name, content := range invalid {
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		_, err := LoadConfig(path)
+		assert.Error(t, err, name)
+	}
+}
+
+func TestLoadConfig_Settings(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gol.yaml")
+	content := `
+host: 0.0.0.0
+port: 4000
+every: 30s
+limit: 50
+ssh_paths:
+  - host: web1
+    user: deploy
+    port: 2222
+    key: /keys/id_ed25519
+    password: hunter2
+    file: /var/log/app/*.log
+docker_paths:
+  - abc123 /var/log/app.log
+excludes: ["*.gz", /var/log/nginx/old-*]
+auth:
+  token: s3cret
+  tokens: [other]
+  users: ['alice:$2a$10$N9qo8uLOickgx2ZMRZoMyeIjZAgcfl7p92ldGxad68LJZdL17lhWy']
+  admin_token: admin
+alert_url: https://gol.example.com/
+alerts:
+  - name: fatal
+    pattern: FATAL|panic
+    url: https://hooks.example.com/services/T0/B0/hooksecret
+    cooldown: 60
+    email:
+      host: smtp.example.com
+      username: gol
+      password: mailsecret
+      from: gol@example.com
+      to: [ops@example.com]
+    command:
+      path: /usr/local/bin/page
+      args: [--team, ops]
+      timeout: 10s
+`
+	assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	config, err := LoadConfig(path)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"deploy@web1:2222 password=hunter2 private_key=/keys/id_ed25519 /var/log/app/*.log"}, config.SSHPathStrings())
+	sshPathConfig, err := StringToSSHPathConfig(config.SSHPathStrings()[0])
+	assert.NoError(t, err)
+	// parsed back, the path has the default known hosts
+	parsed := NewConfigSSHPath(*sshPathConfig)
+	assert.Equal(t, DefaultKnownHostsPath(), parsed.KnownHosts)
+	parsed.KnownHosts = ""
+	assert.Equal(t, config.SSHPaths[0], parsed)
+
+	// the secrets are redacted, the config itself is kept
+	redacted := config.Redacted()
+	assert.Equal(t, "REDACTED", redacted.SSHPaths[0].Password)
+	assert.Equal(t, &AuthConfig{Token: "REDACTED", Tokens: []string{"REDACTED"}, Users: []string{"alice:REDACTED"}, AdminToken: "REDACTED"}, redacted.Auth)
+	assert.Equal(t, "hunter2", config.SSHPaths[0].Password)
+	assert.Equal(t, "s3cret", config.Auth.Token)
+	email := EmailNotifierConfig{Host: "smtp.example.com", Username: "gol", Password: "mailsecret", From: "gol@example.com", To: []string{"ops@example.com"}}
+	command := &CommandNotifierConfig{Path: "/usr/local/bin/page", Args: []string{"--team", "ops"}, Timeout: 10 * time.Second}
+	redactedEmail := email
+	redactedEmail.Password = "REDACTED"
+	assert.Equal(t, []AlertRule{{Name: "fatal", Pattern: "FATAL|panic", URL: "https://hooks.example.com/REDACTED", Cooldown: 60, Email: &redactedEmail, Command: command}}, redacted.Alerts)
+	assert.Equal(t, "https://hooks.example.com/services/T0/B0/hooksecret", config.Alerts[0].URL)
+	assert.Equal(t, "https://gol.example.com/", config.AlertURL)
+	assert.Equal(t, &email, config.Alerts[0].Email)
+	line := redacted.OneLine()
+	assert.NotContains(t, line, "\n")
+	assert.NotContains(t, line, "hunter2")
+	assert.NotContains(t, line, "s3cret")
+	assert.NotContains(t, line, "hooksecret")
+	assert.NotContains(t, line, "mailsecret")
+	assert.Contains(t, line, "host: 0.0.0.0")
+
+	// an empty file is an empty config
+	assert.NoError(t, os.WriteFile(path, nil, 0600))
+	_, err = LoadConfig(path)
+	assert.NoError(t, err)
+
+	invalid := map[string]string{
+		"every":          "every: 1ms\n",
+		"limit":          "limit: -1\n",
+		"port":           "port: 70000\n",
+		"ssh host":       "ssh_paths:\n  - user: deploy\n    file: /var/log/a.log\n",
+		"ssh spaces":     "ssh_paths:\n  - host: web1\n    user: deploy\n    file: /var/log/my app.log\n",
+		"docker path":    "docker_paths: ['a b c']\n",
+		"exclude":        "excludes: ['[']\n",
+		"auth user":      "auth:\n  users: [alice]\n",
+		"alert":          "alerts:\n  - pattern: '['\n    url: https://hooks.example.com/x\n",
+		"alert notifier": "alerts:\n  - pattern: FATAL\n",
+		"alert email":    "alerts:\n  - pattern: FATAL\n    url: https://hooks.example.com/x\n    email:\n      host: smtp.example.com\n",
+	}
+	for name, content := range invalid {
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		_, err := LoadConfig(path)
+		assert.Error(t, err, name)
+	}
+
+	// an unknown key is an error naming it, at any depth
+	for key, content := range map[string]string{
+		"prot":     "host: a\nprot: 3003\n",
+		"tokne":    "auth:\n  tokne: s3cret\n",
+		"hostname": "ssh_paths:\n  - hostname: web1\n",
+	} {
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+		_, err := LoadConfig(path)
+		assert.ErrorContains(t, err, "unknown key "+key)
+	}
+}
+
+func TestAPIHandler_GetPathDefaults(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte("line 1\nline 2\nline 3\n"), 0600))
+
+	GlobalFileRegistry.Replace(ApplyPathDefaults([]FileInfo{{FilePath: logFile, Type: TypeFile}}))
+	defer GlobalPathDefaults.Set(nil)
+	GlobalPathDefaults.Set([]PathConfig{{Pattern: filepath.Join(dir, "*.log"), Defaults: &ViewDefaults{Order: OrderDesc}}})
+	GlobalFileRegistry.Replace(ApplyPathDefaults(GlobalFileRegistry.Snapshot()))
+	assert.Equal(t, OrderDesc, GlobalFileRegistry.Snapshot()[0].Defaults.Order)
+
+	get := func(query string) *ScanResult {
+		e := echo.New()
+		req := httptest.NewRequest(http.MethodGet, "/api?per_page=1&type=file&file_path="+logFile+query, nil)
+		rec := httptest.NewRecorder()
+		c := e.NewContext(req, rec)
+		assert.NoError(t, NewAPIHandler().Get(c))
+		res := APIResponse{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		return &res.Result
+	}
+
+	// the configured order reads from the end
+	assert.Equal(t, "line 3", get("").Lines[0].Content)
+	// request parameters override it
+	assert.Equal(t, "line 1", get("&reverse=false").Lines[0].Content)
+}
+
+func TestConfigReloader_Reload(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.log", "b.log"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("line\n"), 0600))
+	}
+	path := filepath.Join(dir, "gol.yaml")
+	write := func(content string) {
+		assert.NoError(t, os.WriteFile(path, []byte(content), 0600))
+	}
+
+	write("port: 3003\npaths:\n  - pattern: " + filepath.Join(dir, "a.log") + "\n")
+	config, err := LoadConfig(path)
+	assert.NoError(t, err)
+	GlobalWatchedPatterns.Set(config.FilePatterns(), nil, nil, 1000)
+	UpdateGlobalFilePaths(GlobalWatchedPatterns.Get())
+	defer GlobalPathDefaults.Set(nil)
+
+	reloader := NewConfigReloader(path, config, nil, nil, nil)
+
+	write("port: 4000\npaths:\n  - pattern: " + filepath.Join(dir, "b.log") + "\n    defaults:\n      view: table\n")
+	reload, err := reloader.Reload()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{filepath.Join(dir, "b.log")}, reload.Added)
+	assert.Equal(t, []string{filepath.Join(dir, "a.log")}, reload.Removed)
+	assert.Equal(t, []string{"port"}, reload.RestartRequired)
+	assert.Len(t, GlobalFileRegistry.Snapshot(), 1)
+	assert.Equal(t, filepath.Join(dir, "b.log"), GlobalFileRegistry.Snapshot()[0].FilePath)
+	assert.Equal(t, ViewTable, GlobalFileRegistry.Snapshot()[0].Defaults.View)
+	_, cached := GlobalFileStatsCache.Peek(filepath.Join(dir, "a.log"))
+	assert.False(t, cached)
+
+	// an invalid file keeps the current config
+	write("paths: [")
+	_, err = reloader.Reload()
+	assert.Error(t, err)
+	assert.Equal(t, filepath.Join(dir, "b.log"), GlobalFileRegistry.Snapshot()[0].FilePath)
+}
+
+func TestConfigReloader_SourceRemoved(t *testing.T) {
+	useTailHub(t)
+	dir := t.TempDir()
+	aLog, bLog := filepath.Join(dir, "a.log"), filepath.Join(dir, "b.log")
+	for _, name := range []string{aLog, bLog} {
+		assert.NoError(t, os.WriteFile(name, []byte("line\n"), 0600))
+	}
+	path := filepath.Join(dir, "gol.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("paths:\n  - pattern: "+aLog+"\n  - pattern: "+bLog+"\n"), 0600))
+	config, err := LoadConfig(path)
+	assert.NoError(t, err)
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	defer GlobalWatchedPatterns.Set(nil, nil, nil, 0)
+	GlobalWatchedPatterns.Set(config.FilePatterns(), nil, nil, 1000)
+	UpdateGlobalFilePaths(GlobalWatchedPatterns.Get())
+	reloader := NewConfigReloader(path, config, nil, nil, nil)
+
+	server := httptest.NewServer(newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff}))
+	defer server.Close()
+	kept := dialStream(t, server, "type=file&file_path="+aLog)
+	defer kept.Close()
+	removed := dialStream(t, server, "type=file&file_path="+bLog)
+	defer removed.Close()
+	assert.Equal(t, StreamMessageSnapshot, readStreamMessage(t, kept).Type)
+	assert.Equal(t, StreamMessageSnapshot, readStreamMessage(t, removed).Type)
+
+	assert.NoError(t, os.WriteFile(path, []byte("paths:\n  - pattern: "+aLog+"\n"), 0600))
+	reload, err := reloader.Reload()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{bLog}, reload.Removed)
+	assert.Equal(t, []LineSource{{FilePath: bLog, Type: TypeFile}}, reload.FilesRemoved)
+	assert.Empty(t, reload.FilesAdded)
+
+	// the stream of the removed file ends, the other one goes on
+	assert.Equal(t, StreamMessageSourceRemoved, readStreamMessage(t, removed).Type)
+	_, _, err = removed.ReadMessage()
+	assert.True(t, websocket.IsCloseError(err, websocket.CloseNormalClosure), err)
+	f, err := os.OpenFile(aLog, os.O_APPEND|os.O_WRONLY, 0600)
+	assert.NoError(t, err)
+	_, err = f.WriteString("appended\n")
+	assert.NoError(t, err)
+	f.Close()
+	message := readStreamMessage(t, kept)
+	if assert.Equal(t, StreamMessageLine, message.Type) {
+		assert.Equal(t, "appended", message.Line.Content)
+	}
+}
+
+func TestConfigReloader_Concurrent(t *testing.T) {
+	dir := t.TempDir()
+	pattern := filepath.Join(dir, "*.log")
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "a.log"), []byte("line\n"), 0600))
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	defer GlobalWatchedPatterns.Set(nil, nil, nil, 0)
+	GlobalWatchedPatterns.Set([]string{pattern}, nil, nil, 1000)
+	UpdateGlobalFilePaths(GlobalWatchedPatterns.Get())
+	// without a config file, the patterns of the flags are rescanned
+	reloader := NewConfigReloader("", nil, []string{pattern}, nil, nil)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "b.log"), []byte("line\n"), 0600))
+
+	done := make(chan struct{})
+	var readers sync.WaitGroup
+	readers.Add(1)
+	go func() {
+		// the API and the watch loop keep reading and rescanning meanwhile
+		defer readers.Done()
+		for {
+			select {
+			case <-done:
+				return
+			default:
+			}
+			for _, fileInfo := range GlobalFileRegistry.Snapshot() {
+				_ = AuthorizeFilePath(fileInfo.FilePath, fileInfo.Type, fileInfo.Host)
+			}
+			_ = SSHPathConfigs()
+			UpdateGlobalFilePaths(GlobalWatchedPatterns.Get())
+		}
+	}()
+	reloads := make(chan *ConfigReload, 2)
+	var reloaders sync.WaitGroup
+	for range 2 {
+		reloaders.Add(1)
+		go func() {
+			defer reloaders.Done()
+			reload, err := reloader.Reload()
+			assert.NoError(t, err)
+			reloads <- reload
+		}()
+	}
+	reloaders.Wait()
+	close(done)
+	readers.Wait()
+	close(reloads)
+
+	assert.Len(t, GlobalFileRegistry.Snapshot(), 2)
+	assert.NoError(t, AuthorizeFilePath(filepath.Join(dir, "b.log"), TypeFile, ""))
+	for reload := range reloads {
+		assert.Empty(t, reload.FilesRemoved)
+	}
+}
+
+func TestAdminHandler_PostReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gol.yaml")
+	assert.NoError(t, os.WriteFile(path, []byte("paths: []\n"), 0600))
+	config, err := LoadConfig(path)
+	assert.NoError(t, err)
+
+	post := func(options *EchoOptions, token string) int {
+		e := newTestEcho(options)
+		req := httptest.NewRequest(http.MethodPost, "/api/admin/reload", nil)
+		if token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	options := &EchoOptions{BaseURL: "/", Compression: CompressionOff, ConfigReloader: NewConfigReloader(path, config, nil, nil, nil)}
+	assert.Equal(t, http.StatusForbidden, post(options, "secret"))
+
+	options.AdminToken = "secret"
+	assert.Equal(t, http.StatusUnauthorized, post(options, ""))
+	assert.Equal(t, http.StatusUnauthorized, post(options, "wrong"))
+	assert.Equal(t, http.StatusOK, post(options, "secret"))
+
+	options.ReadOnly = true
+	assert.Equal(t, http.StatusMethodNotAllowed, post(options, "secret"))
+}
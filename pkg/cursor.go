@@ -0,0 +1,265 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	"github.com/acarl005/stripansi"
+)
+
+var (
+	// ErrCursorExpired is returned for a cursor of a file truncated or replaced since it was issued
+	ErrCursorExpired = errors.New("cursor expired")
+	// ErrCursorUnsupported is returned for files that cursors cannot point into
+	ErrCursorUnsupported = errors.New("cursors are only supported for local uncompressed UTF-8 files")
+)
+
+// Cursor is a position between two lines of a file, handed out as an opaque token. It holds on to the
+// identity and first bytes of the file, so that a cursor into a file since truncated or rotated is told apart.
+type Cursor struct {
+	// Offset is the byte offset of the line after the position
+	Offset int64 `json:"o"`
+	// LineNumber and Hash are of the line before the position, 0 at the start of the file
+	LineNumber int    `json:"l"`
+	Hash       uint32 `json:"h"`
+	Identity   string `json:"i"`
+	// Fingerprint hashes the bytes of the file up to the offset, at most fingerprintSize of them
+	Fingerprint string `json:"f"`
+}
+
+func (c Cursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseCursor parses the output of Cursor.String
+func ParseCursor(s string) (Cursor, error) {
+	var c Cursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &c) != nil || c.Offset < 0 || c.LineNumber < 0 {
+		return c, fmt.Errorf("invalid cursor %q", s)
+	}
+	return c, nil
+}
+
+// completeLines splits like bufio.ScanLines but leaves out a last line without newline, which may
+// still be being written. The bytes of every line split are added to offset.
+func completeLines(offset *int64) bufio.SplitFunc {
+	return func(data []byte, _ bool) (int, []byte, error) {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			return 0, nil, nil
+		}
+		*offset += int64(i + 1)
+		return i + 1, bytes.TrimSuffix(data[:i], []byte{'\r'}), nil
+	}
+}
+
+// openCursor opens the watched file for reading from cursor, the start of the file when nil
+func (w *Watcher) openCursor(cursor *Cursor) (File, error) {
+	if w.isRemote || w.filePath == InternalLogPath {
+		return nil, ErrCursorUnsupported
+	}
+	file, err := openFile(OSFileOpener{}, w.filePath)
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, 512)
+	n, _ := file.ReadAt(head, 0)
+	if IsCompressed(head[:n]) || DetectEncoding(head[:n]) != EncodingUTF8 {
+		file.Close()
+		return nil, ErrCursorUnsupported
+	}
+	if cursor == nil {
+		return file, nil
+	}
+
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	fingerprint, err := fingerprintPrefix(file, min(cursor.Offset, fingerprintSize))
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if cursor.Identity != fileIdentity(w.filePath) || info.Size() < cursor.Offset || cursor.Fingerprint != fingerprint {
+		file.Close()
+		return nil, ErrCursorExpired
+	}
+	if _, err := file.Seek(cursor.Offset, 0); err != nil {
+		file.Close()
+		return nil, err
+	}
+	return file, nil
+}
+
+// newCursor is the cursor at offset of the watched file, after line lineNumber hashed to hash
+func (w *Watcher) newCursor(file File, offset int64, lineNumber int, hash uint32) (Cursor, error) {
+	fingerprint, err := fingerprintPrefix(file, min(offset, fingerprintSize))
+	if err != nil {
+		return Cursor{}, err
+	}
+	return Cursor{
+		Offset:      offset,
+		LineNumber:  lineNumber,
+		Hash:        hash,
+		Identity:    fileIdentity(w.filePath),
+		Fingerprint: fingerprint,
+	}, nil
+}
+
+// CursorAfter is the cursor after line lineNumber of the watched file. A last line without newline is
+// not passed, the page after the cursor returns it again once complete.
+func (w *Watcher) CursorAfter(ctx context.Context, lineNumber int) (string, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	file, err := w.openCursor(nil)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	var offset int64
+	scanner := newLineScanner(file)
+	scanner.Split(completeLines(&offset))
+	current := 0
+	var hash uint32
+	for current < lineNumber && scanner.Scan() {
+		if current%collectCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return "", err
+			}
+		}
+		current++
+		hash = lineHash(stripansi.Strip(scanner.Text()))
+	}
+	if err := scanner.Err(); err != nil {
+		return "", err
+	}
+	cursor, err := w.newCursor(file, offset, current, hash)
+	if err != nil {
+		return "", err
+	}
+	return cursor.String(), nil
+}
+
+// ScanCursor returns the first pageSize lines after cursor, nil for the start of the file, matching the
+// patterns of the watcher, with the cursor after them. Lines are numbered on from the cursor, and the
+// total is the number of lines returned. The file growing in between does not shift the next page.
+func (w *Watcher) ScanCursor(ctx context.Context, cursor *Cursor, pageSize int) (*ScanResult, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	match, err := newLineMatcher(w.matchPattern)
+	if err != nil {
+		return nil, err
+	}
+	var ignore lineMatcher
+	if w.ignorePattern != "" {
+		if ignore, err = newLineMatcher(w.ignorePattern); err != nil {
+			return nil, err
+		}
+	}
+
+	file, err := w.openCursor(cursor)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var offset int64
+	lineNumber := 0
+	var prevHash uint32
+	if cursor != nil {
+		offset, lineNumber, prevHash = cursor.Offset, cursor.LineNumber, cursor.Hash
+	}
+	scanner := newLineScanner(file)
+	scanner.Split(completeLines(&offset))
+
+	window := w.pageWindow(1, pageSize, false)
+	next := Cursor{Offset: offset, LineNumber: lineNumber, Hash: prevHash}
+	for scanned := 0; scanner.Scan(); scanned++ {
+		if scanned%collectCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		line := scanner.Bytes()
+		content := ""
+		if hasANSI(line) {
+			content = stripansi.Strip(string(line))
+			line = []byte(content)
+		}
+		lineNumber++
+		hash := lineHash(line)
+		if last := window.last(); last != nil && last.LineNumber == lineNumber-1 {
+			last.nextHash = hash
+		}
+		if window.full() {
+			// read only for the anchor of the last line, the next page starts at it
+			break
+		}
+		before := next
+		next = Cursor{Offset: offset, LineNumber: lineNumber, Hash: hash}
+
+		var fields map[string]string
+		if w.processor != nil {
+			if processed, ok := processLine(w.processor, line); ok {
+				content, fields = processed.Text, processed.Fields
+				line = []byte(content)
+			}
+			if len(w.fieldFilters) > 0 && !matchFields(w.fieldFilters, fields) {
+				prevHash = hash
+				continue
+			}
+		}
+		if len(w.jsonFilters) > 0 && !matchJSONFilters(w.jsonFilters, line) {
+			prevHash = hash
+			continue
+		}
+		if w.levels != nil && !w.levels[DetectLevel(line)] {
+			prevHash = hash
+			continue
+		}
+		if (ignore == nil || !ignore.Match(line)) && match.Match(line) {
+			if content == "" {
+				content = string(line)
+			}
+			if !window.add(LineResult{
+				LineNumber: lineNumber,
+				Content:    content,
+				Fields:     fields,
+				prevHash:   prevHash,
+				hash:       hash,
+			}) {
+				// past the budget, the next page starts at this line
+				next = before
+				break
+			}
+		}
+		prevHash = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	lines := window.page()
+	sources := []LineSource{{FilePath: w.filePath, Host: w.sshHost}}
+	w.finalizeLines(lines, sources)
+	nextCursor, err := w.newCursor(file, next.Offset, next.LineNumber, next.Hash)
+	if err != nil {
+		return nil, err
+	}
+	result := w.scanResult(lines, len(lines), len(lines), sources)
+	result.NextCursor = nextCursor.String()
+	result.Truncated = window.truncated
+	return result, nil
+}
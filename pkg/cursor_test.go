@@ -0,0 +1,136 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCursor_String(t *testing.T) {
+	cursor := Cursor{Offset: 1234, LineNumber: 56, Hash: 0xdeadbeef, Identity: "inode:1:2", Fingerprint: "abc"}
+	parsed, err := ParseCursor(cursor.String())
+	assert.NoError(t, err)
+	assert.Equal(t, cursor, parsed)
+
+	for _, s := range []string{"", "not a cursor", Cursor{Offset: -1}.String()} {
+		_, err := ParseCursor(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestAPIHandler_GetCursor(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte("line 1\nline 2\nline 3\n"), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: logFile, Type: TypeFile}})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+
+	get := func(query string) (*httptest.ResponseRecorder, ScanResult) {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api?type=file&file_path="+logFile+query, nil))
+		res := APIResponse{}
+		if rec.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		}
+		return rec, res.Result
+	}
+	appendTo := func(content string) {
+		f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0600)
+		assert.NoError(t, err)
+		_, err = f.WriteString(content)
+		assert.NoError(t, err)
+		f.Close()
+	}
+
+	// writes between every page fetch, every line is read once and in order
+	var seen []string
+	rec, result := get("&per_page=2")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	written := 3
+	for i := 0; i < 10; i++ {
+		for _, line := range result.Lines {
+			assert.Equal(t, fmt.Sprintf("line %d", line.LineNumber), line.Content)
+			seen = append(seen, line.Content)
+		}
+		assert.NotEmpty(t, result.NextCursor)
+		written++
+		appendTo(fmt.Sprintf("line %d\n", written))
+		rec, result = get("&per_page=2&cursor=" + url.QueryEscape(result.NextCursor))
+		assert.Equal(t, http.StatusOK, rec.Code)
+	}
+	for len(result.Lines) > 0 {
+		for _, line := range result.Lines {
+			seen = append(seen, line.Content)
+		}
+		_, result = get("&per_page=2&cursor=" + url.QueryEscape(result.NextCursor))
+	}
+	var want []string
+	for i := 1; i <= written; i++ {
+		want = append(want, fmt.Sprintf("line %d", i))
+	}
+	assert.Equal(t, want, seen)
+
+	// a line still being written is returned once complete
+	cursor := result.NextCursor
+	appendTo("line 14")
+	_, result = get("&cursor=" + url.QueryEscape(cursor))
+	assert.Empty(t, result.Lines)
+	assert.Equal(t, cursor, result.NextCursor)
+	appendTo(" done\nline 15\n")
+	_, result = get("&cursor=" + url.QueryEscape(cursor))
+	if assert.Len(t, result.Lines, 2) {
+		assert.Equal(t, 14, result.Lines[0].LineNumber)
+		assert.Equal(t, "line 14 done", result.Lines[0].Content)
+	}
+
+	// the lines matching the query, anchored as a scan anchors them
+	_, page := get("&query=" + url.QueryEscape("line 1[0-9]"))
+	_, result = get("&query=" + url.QueryEscape("line 1[0-9]") + "&cursor=" + url.QueryEscape(cursor))
+	assert.Equal(t, page.Lines[len(page.Lines)-2:], result.Lines)
+
+	// a truncated or rotated file expires the cursor
+	cursor = result.NextCursor
+	assert.NoError(t, os.WriteFile(logFile, []byte("new 1\n"), 0600))
+	rec, _ = get("&cursor=" + url.QueryEscape(cursor))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrorCodeCursorExpired)
+
+	_, result = get("&per_page=1")
+	cursor = result.NextCursor
+	assert.NoError(t, os.Rename(logFile, logFile+".1"))
+	assert.NoError(t, os.WriteFile(logFile, []byte("new 1\nnew 2\n"), 0600))
+	rec, _ = get("&cursor=" + url.QueryEscape(cursor))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrorCodeCursorExpired)
+
+	// cursors do not point into gzip files or combine with the other modes
+	gzFile := filepath.Join(dir, "app.log.2.gz")
+	assert.NoError(t, os.WriteFile(gzFile, gzipped(t, "old 1\n"), 0600))
+	GlobalFileRegistry.Replace(append(GlobalFileRegistry.Snapshot(), FileInfo{FilePath: gzFile, Type: TypeFile}))
+	_, result = get("&per_page=1")
+	cursor = url.QueryEscape(result.NextCursor)
+	for query, status := range map[string]int{
+		"&cursor=invalid":                   http.StatusUnprocessableEntity,
+		"&cursor=" + cursor + "&reverse=1":  http.StatusUnprocessableEntity,
+		"&cursor=" + cursor + "&tail=1":     http.StatusUnprocessableEntity,
+		"&cursor=" + cursor + "&sample=0.5": http.StatusUnprocessableEntity,
+	} {
+		rec, _ := get(query)
+		assert.Equal(t, status, rec.Code, query)
+	}
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api?type=file&file_path="+gzFile+"&cursor="+cursor, nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api?type=file&file_path="+gzFile, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.False(t, strings.Contains(rec.Body.String(), "next_cursor"))
+}
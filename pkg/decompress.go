@@ -0,0 +1,89 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"errors"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/ulikunitz/xz"
+)
+
+// The compressions of log files read transparently, as set in FileInfo.Compression
+const (
+	FileCompressionGzip  = "gzip"
+	FileCompressionZstd  = "zstd"
+	FileCompressionBzip2 = "bzip2"
+	FileCompressionXz    = "xz"
+
+	// compressionSniffBytes is the longest magic number, that of xz
+	compressionSniffBytes = 6
+)
+
+// DetectCompression tells the compression of a file from its magic number, empty when it is not compressed
+func DetectCompression(head []byte) string {
+	switch {
+	case IsGzip(head):
+		return FileCompressionGzip
+	case bytes.HasPrefix(head, []byte{0x28, 0xb5, 0x2f, 0xfd}):
+		return FileCompressionZstd
+	case len(head) >= 4 && bytes.HasPrefix(head, []byte("BZh")) && head[3] >= '1' && head[3] <= '9':
+		return FileCompressionBzip2
+	case bytes.HasPrefix(head, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return FileCompressionXz
+	}
+	return ""
+}
+
+// IsCompressed tells whether a file starting with head is compressed, such a file cannot be read from
+// an offset and is read through instead
+func IsCompressed(head []byte) bool {
+	return DetectCompression(head) != ""
+}
+
+// NewDecompressingReader sniffs the magic number of r and returns it decompressed, along with its
+// compression. r is returned buffered as is when it is not compressed.
+func NewDecompressingReader(r io.Reader) (io.Reader, string, error) {
+	reader := bufio.NewReader(r)
+	head, err := reader.Peek(compressionSniffBytes)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, "", err
+	}
+	compression := DetectCompression(head)
+	var decompressed io.Reader
+	switch compression {
+	case FileCompressionGzip:
+		decompressed, err = gzip.NewReader(reader)
+	case FileCompressionZstd:
+		// a decoder of one goroutine decodes synchronously, it leaves nothing running to be closed
+		var decoder *zstd.Decoder
+		if decoder, err = zstd.NewReader(reader, zstd.WithDecoderConcurrency(1)); err == nil {
+			decompressed = decoder.IOReadCloser()
+		}
+	case FileCompressionBzip2:
+		decompressed = bzip2.NewReader(reader)
+	case FileCompressionXz:
+		decompressed, err = xz.NewReader(reader)
+	default:
+		return reader, "", nil
+	}
+	if err != nil {
+		return nil, compression, err
+	}
+	return decompressed, compression, nil
+}
+
+// FileCompression is the compression of a local file, empty when it is not compressed or cannot be read
+func FileCompression(filePath string) string {
+	file, err := openFile(GlobalFileOpener, filePath)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	head := make([]byte, compressionSniffBytes)
+	n, _ := file.ReadAt(head, 0)
+	return DetectCompression(head[:n])
+}
@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/ulikunitz/xz"
+)
+
+const compressedLines = "INFO line 1\nINFO line 2\nWARN line 3\nINFO line 4\nERROR line 5\n"
+
+// compressedFiles are compressedLines in every compression read, by file name
+func compressedFiles(t *testing.T) map[string][]byte {
+	var gz, zst, xzb bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gz)
+	_, err := gzipWriter.Write([]byte(compressedLines))
+	assert.NoError(t, err)
+	assert.NoError(t, gzipWriter.Close())
+	zstdWriter, err := zstd.NewWriter(&zst)
+	assert.NoError(t, err)
+	_, err = zstdWriter.Write([]byte(compressedLines))
+	assert.NoError(t, err)
+	assert.NoError(t, zstdWriter.Close())
+	xzWriter, err := xz.NewWriter(&xzb)
+	assert.NoError(t, err)
+	_, err = xzWriter.Write([]byte(compressedLines))
+	assert.NoError(t, err)
+	assert.NoError(t, xzWriter.Close())
+	// compress/bzip2 only reads, the file was written with bzip2 -9
+	bz2, err := os.ReadFile(filepath.Join("testdata", "lines.log.bz2"))
+	assert.NoError(t, err)
+	return map[string][]byte{"app.log.gz": gz.Bytes(), "app.log.zst": zst.Bytes(), "app.log.xz": xzb.Bytes(), "app.log.bz2": bz2}
+}
+
+func TestNewDecompressingReader(t *testing.T) {
+	want := map[string]string{
+		"app.log.gz":  FileCompressionGzip,
+		"app.log.zst": FileCompressionZstd,
+		"app.log.xz":  FileCompressionXz,
+		"app.log.bz2": FileCompressionBzip2,
+	}
+	for name, data := range compressedFiles(t) {
+		reader, compression, err := NewDecompressingReader(bytes.NewReader(data))
+		assert.NoError(t, err, name)
+		assert.Equal(t, want[name], compression, name)
+		content, err := io.ReadAll(reader)
+		assert.NoError(t, err, name)
+		assert.Equal(t, compressedLines, string(content), name)
+	}
+
+	for _, plain := range []string{"", "I", compressedLines, "BZh is not bzip2 without a block size"} {
+		reader, compression, err := NewDecompressingReader(bytes.NewReader([]byte(plain)))
+		assert.NoError(t, err)
+		assert.Empty(t, compression)
+		content, err := io.ReadAll(reader)
+		assert.NoError(t, err)
+		assert.Equal(t, plain, string(content))
+	}
+}
+
+func TestCompressedFiles(t *testing.T) {
+	dir := t.TempDir()
+	for name, data := range compressedFiles(t) {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), data, 0600))
+	}
+	fileInfos, err := GetFileInfosContext(context.Background(), filepath.Join(dir, "app.log.*"), 10, false, nil)
+	assert.NoError(t, err)
+	assert.Len(t, fileInfos, 4)
+	for _, fileInfo := range fileInfos {
+		assert.Equal(t, 5, fileInfo.LinesCount, fileInfo.FilePath)
+		assert.NotEmpty(t, fileInfo.Compression, fileInfo.FilePath)
+
+		// tails cannot seek and scan the file instead
+		lines, err := ReadTailLinesContext(context.Background(), fileInfo.FilePath, 2, false, nil)
+		assert.NoError(t, err)
+		assert.Equal(t, []string{"INFO line 4", "ERROR line 5"}, lines, fileInfo.FilePath)
+
+		watcher, err := NewWatcher(fileInfo.FilePath, "WARN", "", false, "", "", "", "", "")
+		assert.NoError(t, err)
+		result, err := watcher.ScanContext(context.Background(), 1, 10, false)
+		assert.NoError(t, err)
+		if assert.Len(t, result.Lines, 1, fileInfo.FilePath) {
+			assert.Equal(t, 3, result.Lines[0].LineNumber)
+		}
+	}
+
+	// downloads are as is unless decompressed
+	GlobalFileRegistry.Replace(fileInfos)
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	zst := filepath.Join(dir, "app.log.zst")
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/download?type=file&file_path="+zst+"&decompress=true", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, compressedLines, rec.Body.String())
+	assert.Contains(t, rec.Header().Get("Content-Disposition"), `filename="app.log"`)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/download?type=file&file_path="+zst, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, IsCompressed(rec.Body.Bytes()))
+}
@@ -0,0 +1,227 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/acarl005/stripansi"
+	"github.com/kevincobain2000/gol/pkg/search"
+)
+
+const (
+	// DiffAdded lines are in A but not in B
+	DiffAdded = "added"
+	// DiffRemoved lines are in B but not in A
+	DiffRemoved = "removed"
+	// DiffCommon lines are in both
+	DiffCommon = "common"
+)
+
+// LineTemplate is line with its timestamps, ids and numbers replaced by <ts>, <id> and <n>.
+//
+// Deprecated: use search.LineTemplate.
+func LineTemplate(line string) string {
+	return search.LineTemplate(line)
+}
+
+type DiffResult struct {
+	A       DiffSource `json:"a"`
+	B       DiffSource `json:"b"`
+	Added   DiffSet    `json:"added"`
+	Removed DiffSet    `json:"removed"`
+	Common  DiffSet    `json:"common"`
+}
+
+type DiffSource struct {
+	FilePath string `json:"file_path"`
+	Host     string `json:"host"`
+	Type     string `json:"type"`
+	// Lines is the number of lines kept by the filters and the time window
+	Lines     int                  `json:"lines"`
+	TimeRange *TimeRangeResolution `json:"time_range,omitempty"`
+	// Warnings are the segments skipped because they could not be read
+	Warnings []SegmentWarning `json:"warnings,omitempty"`
+}
+
+// DiffSet is one side of the difference, counted in distinct templates and in the lines of A
+// they stand for, of B for removed lines
+type DiffSet struct {
+	Distinct int           `json:"distinct"`
+	Lines    int           `json:"lines"`
+	Examples []DiffExample `json:"examples"`
+}
+
+// DiffExample is the first line of a template with the number of its lines in A and B
+type DiffExample struct {
+	Kind     string `json:"kind"`
+	Template string `json:"template"`
+	Content  string `json:"content"`
+	CountA   int    `json:"count_a"`
+	CountB   int    `json:"count_b"`
+}
+
+// diffSource reads the lines of one side of a diff: the segments of a source, cut to a time window when set
+type diffSource struct {
+	watcher  *Watcher
+	segments []string
+	from     time.Time
+	to       time.Time
+	inWindow bool
+	// warnings are the segments skipped by the first read, the next reads skip them silently
+	warnings []SegmentWarning
+	skipped  map[string]bool
+}
+
+// each calls fn with the content and template of every line kept by the filters and the time window,
+// until fn returns false. Segments that cannot be read are skipped, unless there is only one.
+func (s *diffSource) each(ctx context.Context, match, ignore lineMatcher, fn func(content string, template string) bool) error {
+	s.inWindow = s.from.IsZero()
+	for _, segment := range s.segments {
+		if s.skipped[segment] {
+			continue
+		}
+		done, err := s.segment(ctx, segment, match, ignore, fn)
+		var readErr *SegmentReadError
+		if len(s.segments) > 1 && errors.As(err, &readErr) {
+			if s.skipped == nil {
+				s.skipped = map[string]bool{}
+			}
+			s.skipped[segment] = true
+			s.warnings = append(s.warnings, readErr.Warning())
+			continue
+		}
+		if err != nil || done {
+			return err
+		}
+	}
+	return nil
+}
+
+// segment reads one file, done is set once fn returns false or a line after the window is read
+func (s *diffSource) segment(ctx context.Context, filePath string, match, ignore lineMatcher, fn func(string, string) bool) (bool, error) {
+	file, scanner, err := s.watcher.openScanner(filePath)
+	if err != nil {
+		return false, &SegmentReadError{FilePath: filePath, Err: err}
+	}
+	if file != nil {
+		defer file.Close()
+	}
+	windowed := !s.from.IsZero() || !s.to.IsZero()
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		line := scanner.Bytes()
+		if hasANSI(line) {
+			line = []byte(stripansi.Strip(string(line)))
+		}
+		if windowed {
+			if ts, dated := extractTime(string(line)); dated {
+				if !s.to.IsZero() && ts.After(s.to) {
+					return true, nil
+				}
+				s.inWindow = s.from.IsZero() || !ts.Before(s.from)
+			}
+			if !s.inWindow {
+				continue
+			}
+		}
+		if (ignore != nil && ignore.Match(line)) || !match.Match(line) {
+			continue
+		}
+		content := string(line)
+		if !fn(content, search.LineTemplate(content)) {
+			return true, nil
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return false, &SegmentReadError{FilePath: filePath, Err: err}
+	}
+	return false, nil
+}
+
+// diffLines compares the line templates of a and b. Every template of both is counted, then emit is
+// called with the first line of each template of a, added or common, and of each template only in b,
+// removed. emit returning false skips the rest of that side. Memory is a hash and a count per
+// distinct template, lines are not kept.
+func diffLines(ctx context.Context, a, b *diffSource, matchPattern, ignorePattern string, emit func(DiffExample) bool) (*DiffResult, error) {
+	match, err := newLineMatcher(matchPattern)
+	if err != nil {
+		return nil, err
+	}
+	var ignore lineMatcher
+	if ignorePattern != "" {
+		if ignore, err = newLineMatcher(ignorePattern); err != nil {
+			return nil, err
+		}
+	}
+
+	result := &DiffResult{}
+	count := func(source *diffSource, lines *int) (map[uint64]int, error) {
+		counts := map[uint64]int{}
+		err := source.each(ctx, match, ignore, func(_ string, template string) bool {
+			counts[search.TemplateHash(template)]++
+			*lines++
+			return true
+		})
+		return counts, err
+	}
+	countsA, err := count(a, &result.A.Lines)
+	if err != nil {
+		return nil, err
+	}
+	countsB, err := count(b, &result.B.Lines)
+	if err != nil {
+		return nil, err
+	}
+
+	for hash, count := range countsA {
+		set := &result.Added
+		if countsB[hash] > 0 {
+			set = &result.Common
+		}
+		set.Distinct++
+		set.Lines += count
+	}
+	for hash, count := range countsB {
+		if countsA[hash] == 0 {
+			result.Removed.Distinct++
+			result.Removed.Lines += count
+		}
+	}
+
+	emitted := map[uint64]struct{}{}
+	examples := func(source *diffSource, removedOnly bool) error {
+		return source.each(ctx, match, ignore, func(content string, template string) bool {
+			hash := search.TemplateHash(template)
+			if _, ok := emitted[hash]; ok {
+				return true
+			}
+			emitted[hash] = struct{}{}
+			example := DiffExample{Template: template, Content: content, CountA: countsA[hash], CountB: countsB[hash]}
+			switch {
+			case example.CountA == 0:
+				example.Kind = DiffRemoved
+			case removedOnly:
+				return true
+			case example.CountB == 0:
+				example.Kind = DiffAdded
+			default:
+				example.Kind = DiffCommon
+			}
+			truncated := LineResult{Content: example.Content}
+			TruncateLine(&truncated, GlobalMaxLineLength, nil)
+			example.Content = truncated.Content
+			return emit(example)
+		})
+	}
+	if err := examples(a, false); err != nil {
+		return nil, err
+	}
+	// b only adds the templates missing from a
+	if err := examples(b, true); err != nil {
+		return nil, err
+	}
+	return result, nil
+}
@@ -0,0 +1,220 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mcuadros/go-defaults"
+)
+
+const (
+	DiffFormatJSON   = "json"
+	DiffFormatNDJSON = "ndjson"
+)
+
+// DiffRequest compares A, the file, to B, the other file or the same file over the other time window
+type DiffRequest struct {
+	ID       string `json:"id" query:"id"`
+	FilePath string `json:"file_path" query:"file_path" validate:"required" message:"file_path is required"`
+	Host     string `json:"host" query:"host"`
+	Type     string `json:"type" query:"type" validate:"required" message:"type is required"`
+	// From and To (RFC 3339) bound the window of A, a missing bound is open
+	From string `json:"from" query:"from"`
+	To   string `json:"to" query:"to"`
+	// OtherID, OtherFilePath, OtherHost and OtherType are B, A when missing
+	OtherID       string `json:"other_id" query:"other_id"`
+	OtherFilePath string `json:"other_file_path" query:"other_file_path"`
+	OtherHost     string `json:"other_host" query:"other_host"`
+	OtherType     string `json:"other_type" query:"other_type"`
+	OtherFrom     string `json:"other_from" query:"other_from"`
+	OtherTo       string `json:"other_to" query:"other_to"`
+	Query         string `json:"query" query:"query"`
+	Ignore        string `json:"ignore" query:"ignore"`
+	// Page and PerPage page the examples of each set
+	Page    int `json:"page" query:"page" default:"1" validate:"required,gte=1" message:"page >=1 is required"`
+	PerPage int `json:"per_page" query:"per_page" default:"15" validate:"required" message:"per_page is required"`
+	// Format ndjson streams every example of the diff, one JSON object per line, instead of a page
+	Format string `json:"format" query:"format" default:"json" validate:"oneof=json ndjson" message:"format must be json or ndjson"`
+}
+
+// GetDiff compares the lines of two files, or of one file over two time windows. Lines are compared
+// by their template, with timestamps, ids and numbers stripped, and counted as added (in A only),
+// removed (in B only) or common, with the first line of each template as example.
+func (h *APIHandler) GetDiff(c echo.Context) error {
+	req := new(DiffRequest)
+	if err := BindRequest(c, req); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err)
+	}
+	if err := resolveFileID(req.ID, &req.FilePath, &req.Host, &req.Type); err != nil {
+		return err
+	}
+	if err := resolveFileID(req.OtherID, &req.OtherFilePath, &req.OtherHost, &req.OtherType); err != nil {
+		return err
+	}
+	defaults.SetDefaults(req)
+	msgs, err := ValidateRequest(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, msgs)
+	}
+	if req.PerPage > GlobalMaxPerPage {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("per_page must be at most %d", GlobalMaxPerPage))
+	}
+
+	from, to, err := parseTimeRange(req.From, req.To)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	otherFrom, otherTo, err := parseTimeRange(req.OtherFrom, req.OtherTo)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "other_"+err.Error())
+	}
+	if req.OtherFilePath == "" {
+		if req.OtherFrom == "" && req.OtherTo == "" {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, "other_file_path, or other_from or other_to, is required")
+		}
+		req.OtherFilePath, req.OtherHost, req.OtherType = req.FilePath, req.Host, req.Type
+	}
+	if req.OtherType == "" {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "other_type is required")
+	}
+
+	// a diff of a sample of the lines would report most lines as missing
+	if _, err := GlobalPatternLimits.Check(req.Query, req.Ignore); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	// an interrupted export resumes from the export spooled for the same request
+	exportKey := "api/diff?" + c.QueryParams().Encode()
+	if req.Format == DiffFormatNDJSON && c.Request().Header.Get("Range") != "" {
+		if export, ok := GlobalExports.Latest(exportKey); ok {
+			return serveExport(c, export)
+		}
+	}
+
+	a, result, err := h.diffSource(c, req.FilePath, req.Host, req.Type, from, to)
+	if err != nil {
+		return err
+	}
+	b, otherResult, err := h.diffSource(c, req.OtherFilePath, req.OtherHost, req.OtherType, otherFrom, otherTo)
+	if err != nil {
+		return err
+	}
+
+	release, err := acquireRead(c, req.Type, req.Host, req.FilePath)
+	if err != nil {
+		return err
+	}
+	defer release()
+	if req.OtherFilePath != req.FilePath || req.OtherHost != req.Host || req.OtherType != req.Type {
+		releaseOther, err := acquireSourceRead(c, req.OtherType, req.OtherHost, req.OtherFilePath)
+		if err != nil {
+			return err
+		}
+		defer releaseOther()
+	}
+
+	if req.Format == DiffFormatNDJSON {
+		return diffNDJSON(c, a, b, req, exportKey)
+	}
+
+	limit := req.Page * req.PerPage
+	examples := map[string][]DiffExample{}
+	diff, err := diffLines(c.Request().Context(), a, b, req.Query, req.Ignore, func(example DiffExample) bool {
+		if len(examples[example.Kind]) < limit {
+			examples[example.Kind] = append(examples[example.Kind], example)
+		}
+		if example.Kind == DiffRemoved {
+			return len(examples[DiffRemoved]) < limit
+		}
+		return len(examples[DiffAdded]) < limit || len(examples[DiffCommon]) < limit
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	result.Lines, otherResult.Lines = diff.A.Lines, diff.B.Lines
+	result.Warnings = append(result.Warnings, a.warnings...)
+	otherResult.Warnings = append(otherResult.Warnings, b.warnings...)
+	diff.A, diff.B = result, otherResult
+	diff.Added.Examples = diffPage(examples[DiffAdded], req.Page, req.PerPage)
+	diff.Removed.Examples = diffPage(examples[DiffRemoved], req.Page, req.PerPage)
+	diff.Common.Examples = diffPage(examples[DiffCommon], req.Page, req.PerPage)
+	return c.JSON(http.StatusOK, diff)
+}
+
+// diffNDJSON exports every example of the diff. The export is spooled to the data dir first and
+// then served with Range support, a Range request of the same export resumes it.
+func diffNDJSON(c echo.Context, a, b *diffSource, req *DiffRequest, exportKey string) error {
+	export, err := GlobalExports.Spool(c.Request().Context(), exportKey, req.FilePath, "diff.ndjson", "application/x-ndjson", func(ctx context.Context, w io.Writer) error {
+		encoder := json.NewEncoder(w)
+		var encodeErr error
+		_, err := diffLines(ctx, a, b, req.Query, req.Ignore, func(example DiffExample) bool {
+			encodeErr = encoder.Encode(example)
+			return encodeErr == nil
+		})
+		if err != nil {
+			return err
+		}
+		return encodeErr
+	})
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return serveExport(c, export)
+}
+
+// diffSource opens one side of a diff. The time window of a local file is read from the segments
+// of its logical log covering it.
+func (h *APIHandler) diffSource(c echo.Context, filePath, host, sourceType string, from, to time.Time) (*diffSource, DiffSource, error) {
+	result := DiffSource{FilePath: filePath, Host: host, Type: sourceType}
+	if err := AuthorizeFilePath(filePath, sourceType, host); err != nil {
+		return nil, result, echo.NewHTTPError(http.StatusForbidden, err)
+	}
+	var watcher *Watcher
+	var err error
+	switch sourceType {
+	case TypeSSH:
+		sshConfig := h.API.FindSSHConfig(host)
+		if sshConfig == nil {
+			return nil, result, echo.NewHTTPError(http.StatusNotFound, "ssh config not found")
+		}
+		watcher, err = NewWatcher(filePath, "", "", true, sshConfig.Host, sshConfig.Port, sshConfig.User, sshConfig.Password, sshConfig.PrivateKeyPath)
+	case TypeFile, TypeStdin, TypeInternal, TypeK8s, TypeJournal, TypeHTTP, TypeS3:
+		watcher, err = NewWatcher(filePath, "", "", false, "", "", "", "", "")
+	case TypeDocker:
+		if !strings.HasPrefix(filePath, TmpContainerPath) {
+			return nil, result, echo.NewHTTPError(http.StatusUnprocessableEntity, "diff is not supported for files inside containers")
+		}
+		watcher, err = NewWatcher(filePath, "", "", false, "", "", "", "", "")
+	default:
+		return nil, result, echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("diff is not supported for type %s", sourceType))
+	}
+	if err != nil {
+		return nil, result, echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	source := &diffSource{watcher: watcher, segments: []string{filePath}, from: from, to: to}
+	if (!from.IsZero() || !to.IsZero()) && sourceType == TypeFile {
+		resolution, err := GlobalSegmentTimeRanges.Resolve(LogicalSegments(filePath), from, to)
+		if err != nil {
+			return nil, result, echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		source.segments = resolution.FilePaths()
+		result.TimeRange = resolution
+		result.Warnings = resolution.Warnings
+	}
+	return source, result, nil
+}
+
+func diffPage(examples []DiffExample, page, perPage int) []DiffExample {
+	start := (page - 1) * perPage
+	if start >= len(examples) {
+		return []DiffExample{}
+	}
+	return examples[start:]
+}
@@ -0,0 +1,85 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIHandler_GetDiff(t *testing.T) {
+	useExports(t)
+	dir := t.TempDir()
+	canary := filepath.Join(dir, "canary.log")
+	stable := filepath.Join(dir, "stable.log")
+	assert.NoError(t, os.WriteFile(canary, []byte(strings.Join([]string{
+		"2024-06-01T12:00:00Z INFO request 1 took 10ms",
+		"2024-06-01T12:00:01Z ERROR cache miss for key 42",
+		"2024-06-01T12:00:02Z INFO request 2 took 12ms",
+		"2024-06-01T12:00:03Z ERROR cache miss for key 43",
+	}, "\n")+"\n"), 0600))
+	assert.NoError(t, os.WriteFile(stable, []byte(strings.Join([]string{
+		"2024-06-01T12:00:00Z INFO request 7 took 9ms",
+		"2024-06-01T12:00:05Z WARN slow disk",
+	}, "\n")+"\n"), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: canary, Type: TypeFile}, {FilePath: stable, Type: TypeFile}})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/diff?type=file&file_path="+canary+query, nil))
+		return rec
+	}
+
+	rec := get("&other_type=file&other_file_path=" + stable)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	diff := DiffResult{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &diff))
+	assert.Equal(t, 4, diff.A.Lines)
+	assert.Equal(t, 2, diff.B.Lines)
+	assert.Equal(t, DiffSet{Distinct: 1, Lines: 2, Examples: []DiffExample{{
+		Kind:     DiffAdded,
+		Template: "<ts> ERROR cache miss for key <n>",
+		Content:  "2024-06-01T12:00:01Z ERROR cache miss for key 42",
+		CountA:   2,
+	}}}, diff.Added)
+	assert.Equal(t, 1, diff.Common.Distinct)
+	assert.Equal(t, 2, diff.Common.Lines)
+	assert.Equal(t, 1, diff.Common.Examples[0].CountB)
+	assert.Equal(t, "2024-06-01T12:00:05Z WARN slow disk", diff.Removed.Examples[0].Content)
+
+	// examples are paged
+	rec = get("&other_type=file&other_file_path=" + stable + "&page=2&per_page=1")
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &diff))
+	assert.Equal(t, 1, diff.Added.Distinct)
+	assert.Empty(t, diff.Added.Examples)
+
+	// the same file over two time windows
+	rec = get("&to=2024-06-01T12:00:01Z&other_from=2024-06-01T12:00:02Z")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	diff = DiffResult{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &diff))
+	assert.Equal(t, 2, diff.A.Lines)
+	assert.Equal(t, 2, diff.B.Lines)
+	assert.Equal(t, 2, diff.Common.Distinct)
+	assert.Equal(t, 0, diff.Added.Distinct+diff.Removed.Distinct)
+
+	// the export has every example
+	rec = get("&other_type=file&other_file_path=" + stable + "&format=ndjson&per_page=1")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/x-ndjson", rec.Header().Get("Content-Type"))
+	lines := strings.Split(strings.TrimSpace(rec.Body.String()), "\n")
+	assert.Len(t, lines, 3)
+	example := DiffExample{}
+	assert.NoError(t, json.Unmarshal([]byte(lines[2]), &example))
+	assert.Equal(t, DiffRemoved, example.Kind)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, get("").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, get("&other_from=yesterday").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, get("&other_type=file&other_file_path="+stable+"&format=csv").Code)
+	assert.Equal(t, http.StatusForbidden, get("&other_type=file&other_file_path=/nope.log").Code)
+}
@@ -0,0 +1,182 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// DefaultMinFreeDisk is the free space gol leaves on the file systems it writes temp copies and caches to
+const DefaultMinFreeDisk int64 = 1 << 30
+
+// ErrDiskFull is returned instead of writing a temp copy or cache that would leave less than the floor free
+var ErrDiskFull = errors.New("not enough free disk space")
+
+// DiskUsage is the space of the file system holding one of gol's directories
+type DiskUsage struct {
+	Path  string `json:"path"`
+	Total int64  `json:"total"`
+	Free  int64  `json:"free"`
+	// Used is the size of gol's own files in Path
+	Used    int64 `json:"used"`
+	MinFree int64 `json:"min_free"`
+	// Pressure is set once free space is below twice the floor, temp copies are evicted from then on
+	Pressure bool   `json:"pressure"`
+	Error    string `json:"error,omitempty"`
+}
+
+// TmpDir is where temp copies of stdin, remote files and container logs are written
+func TmpDir() string {
+	return filepath.Dir(TmpStdinPath)
+}
+
+// EnsureFreeDisk checks that writing size bytes in dir leaves at least GlobalMinFreeDisk free.
+// File systems whose free space cannot be read are not guarded.
+func EnsureFreeDisk(dir string, size int64) error {
+	if GlobalMinFreeDisk <= 0 {
+		return nil
+	}
+	_, free, err := diskSpace(dir)
+	if err != nil {
+		slog.Debug("reading free disk space", "dir", dir, "error", err)
+		return nil
+	}
+	if free-size < GlobalMinFreeDisk {
+		return fmt.Errorf("%w in %s: %s free, writing %s would go below -min-free-disk %s",
+			ErrDiskFull, dir, FormatByteSize(free), FormatByteSize(size), FormatByteSize(GlobalMinFreeDisk))
+	}
+	return nil
+}
+
+// DiskUsages reports the data dir and the temp dir
+func DiskUsages() []DiskUsage {
+	usages := []DiskUsage{diskUsage(TmpDir(), tmpFilesSize())}
+	if GlobalDataDir != "" {
+		usages = append(usages, diskUsage(GlobalDataDir, dirSize(GlobalDataDir)))
+	}
+	return usages
+}
+
+func diskUsage(dir string, used int64) DiskUsage {
+	usage := DiskUsage{Path: dir, Used: used, MinFree: GlobalMinFreeDisk}
+	total, free, err := diskSpace(dir)
+	if err != nil {
+		usage.Error = err.Error()
+		return usage
+	}
+	usage.Total = total
+	usage.Free = free
+	// free-min < min rather than free < 2*min, which overflows for very large minimums
+	usage.Pressure = GlobalMinFreeDisk > 0 && free-GlobalMinFreeDisk < GlobalMinFreeDisk
+	return usage
+}
+
+// tmpFiles are gol's temp copies, oldest first
+func tmpFiles() []string {
+	filePaths := []string{}
+	for _, prefix := range []string{TmpStdinPath, TmpContainerPath, TmpK8sPath, TmpJournalPath} {
+		matches, _ := filepath.Glob(prefix + "*")
+		filePaths = append(filePaths, matches...)
+	}
+	modTimes := map[string]time.Time{}
+	for _, filePath := range filePaths {
+		if fileInfo, err := os.Stat(filePath); err == nil {
+			modTimes[filePath] = fileInfo.ModTime()
+		}
+	}
+	sort.SliceStable(filePaths, func(i, j int) bool { return modTimes[filePaths[i]].Before(modTimes[filePaths[j]]) })
+	return filePaths
+}
+
+func tmpFilesSize() int64 {
+	var size int64
+	for _, filePath := range tmpFiles() {
+		if fileInfo, err := os.Stat(filePath); err == nil {
+			size += fileInfo.Size()
+		}
+	}
+	return size
+}
+
+func dirSize(dir string) int64 {
+	var size int64
+	filepath.WalkDir(dir, func(_ string, entry os.DirEntry, err error) error { //nolint: errcheck
+		if err != nil || entry.IsDir() {
+			return nil
+		}
+		if fileInfo, err := entry.Info(); err == nil {
+			size += fileInfo.Size()
+		}
+		return nil
+	})
+	return size
+}
+
+// EvictTmpFiles removes the oldest temp copies no listed file is backed by while the temp dir is
+// under pressure, e.g. copies left behind by a crash or by containers that are gone
+func EvictTmpFiles() []string {
+	usage := diskUsage(TmpDir(), 0)
+	if !usage.Pressure {
+		return nil
+	}
+	inUse := map[string]bool{PipeTmpFilePath(): true}
+	for _, fileInfo := range GlobalFileRegistry.Snapshot() {
+		inUse[fileInfo.FilePath] = true
+	}
+	evicted := []string{}
+	for _, filePath := range tmpFiles() {
+		if inUse[filePath] {
+			continue
+		}
+		if err := os.Remove(filePath); err != nil {
+			slog.Warn("evicting temp file", "path", filePath, "error", err)
+			continue
+		}
+		evicted = append(evicted, filePath)
+		if _, free, err := diskSpace(TmpDir()); err != nil || free >= 2*GlobalMinFreeDisk {
+			break
+		}
+	}
+	return evicted
+}
+
+// WatchDiskUsage re-checks the free space every interval, warns while a directory is under pressure,
+// evicts temp copies and expires spooled exports
+func WatchDiskUsage(interval time.Duration) {
+	ticks, stop := GlobalClock.Tick(interval)
+	defer stop()
+
+	for range ticks {
+		for _, usage := range DiskUsages() {
+			if usage.Pressure {
+				slog.Warn("low disk space", "path", usage.Path, "free", FormatByteSize(usage.Free), "min-free-disk", FormatByteSize(usage.MinFree))
+			}
+		}
+		if evicted := EvictTmpFiles(); len(evicted) > 0 {
+			slog.Warn("evicted temp files", "files", strings.Join(evicted, " "))
+		}
+		if expired := GlobalExports.Expire(); len(expired) > 0 {
+			slog.Info("expired spooled exports", "files", strings.Join(expired, " "))
+		}
+	}
+}
+
+// FormatByteSize formats a size with a binary unit, e.g. 1.5GiB
+func FormatByteSize(size int64) string {
+	units := []string{"B", "KiB", "MiB", "GiB", "TiB"}
+	value := float64(size)
+	unit := 0
+	for value >= 1024 && unit < len(units)-1 {
+		value /= 1024
+		unit++
+	}
+	if unit == 0 {
+		return fmt.Sprintf("%dB", size)
+	}
+	return strings.TrimSuffix(fmt.Sprintf("%.1f", value), ".0") + units[unit]
+}
@@ -0,0 +1,89 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func useMinFreeDisk(t *testing.T, minFree int64) {
+	previous := GlobalMinFreeDisk
+	t.Cleanup(func() { GlobalMinFreeDisk = previous })
+	GlobalMinFreeDisk = minFree
+}
+
+func TestEnsureFreeDisk(t *testing.T) {
+	dir := t.TempDir()
+	useMinFreeDisk(t, 0)
+	assert.NoError(t, EnsureFreeDisk(dir, 1<<62))
+
+	useMinFreeDisk(t, 1)
+	assert.NoError(t, EnsureFreeDisk(dir, 0))
+
+	useMinFreeDisk(t, 1<<62)
+	err := EnsureFreeDisk(dir, 0)
+	assert.ErrorIs(t, err, ErrDiskFull)
+	assert.Contains(t, err.Error(), dir)
+
+	usage := diskUsage(dir, 42)
+	assert.Equal(t, int64(42), usage.Used)
+	assert.Equal(t, int64(1<<62), usage.MinFree)
+	assert.True(t, usage.Pressure)
+	assert.Positive(t, usage.Total)
+}
+
+func TestSSHCopy_DiskGuard(t *testing.T) {
+	runner := &ScriptedRemoteRunner{Outputs: map[string]string{
+		"web1 ls /var/log/*.log":    "/var/log/app.log\n",
+		"web1 cat /var/log/app.log": "INFO a\n",
+	}}
+	useFakes(t, fstest.MapFS{}, runner, nil)
+	sshConfig := &SSHConfig{Host: "web1", Port: "22"}
+
+	// the temp copy is removed once read
+	file, err := sshOpenFile(context.Background(), "/var/log/app.log", sshConfig)
+	assert.NoError(t, err)
+	name := file.(*tmpCopy).Name()
+	assert.NoError(t, file.Close())
+	_, err = os.Stat(name)
+	assert.True(t, os.IsNotExist(err))
+
+	useMinFreeDisk(t, 1<<62)
+	_, err = sshOpenFile(context.Background(), "/var/log/app.log", sshConfig)
+	assert.ErrorIs(t, err, ErrDiskFull)
+
+	// listing streams the files, it does not need the disk
+	fileInfos, err := GetFileInfosContext(context.Background(), "/var/log/*.log", 10, true, sshConfig)
+	assert.NoError(t, err)
+	assert.Len(t, fileInfos, 1)
+
+	GlobalSourceStatuses.Set(nil)
+	defer GlobalSourceStatuses.Set(nil)
+	UpdateGlobalFilePaths(nil, SliceFlags{"user@web1 /var/log/*.log"}, nil, 10)
+	statuses := GlobalSourceStatuses.List()
+	assert.Len(t, statuses, 1)
+	assert.Equal(t, "web1", statuses[0].Host)
+	assert.Empty(t, statuses[0].Error)
+
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/sources", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	res := SourcesResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Len(t, res.Sources, 1)
+	assert.Equal(t, TmpDir(), res.Disk[0].Path)
+	assert.True(t, res.Disk[0].Pressure)
+}
+
+func TestFormatByteSize(t *testing.T) {
+	assert.Equal(t, "512B", FormatByteSize(512))
+	assert.Equal(t, "1.5KiB", FormatByteSize(1536))
+	assert.Equal(t, "1GiB", FormatByteSize(1<<30))
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package pkg
+
+import "errors"
+
+// diskSpace is not implemented on windows, where free space is not guarded
+func diskSpace(string) (int64, int64, error) {
+	return 0, 0, errors.ErrUnsupported
+}
@@ -2,69 +2,224 @@ package pkg
 
 import (
 	"bufio"
+	"bytes"
 	"context"
+	"errors"
 	"fmt"
+	"io"
 	"log/slog"
 	"os"
 	"regexp"
-	"strconv"
 	"strings"
 
 	"github.com/acarl005/stripansi"
 	"github.com/docker/docker/api/types"
 	"github.com/docker/docker/api/types/container"
 	"github.com/docker/docker/client"
+	"github.com/docker/docker/errdefs"
+	"github.com/docker/docker/pkg/stdcopy"
 )
 
-func ListDockerContainers() ([]types.Container, error) {
+// ErrContainerStopped is returned when a container stopped or went away before it was read from
+var ErrContainerStopped = errors.New("container is not running")
+
+// containerStdoutMaxLines is the number of lines of the stdout of a container kept in its temp file
+const containerStdoutMaxLines = 10000
+
+// newDockerClient connects to the daemon of DOCKER_HOST, the local one by default
+func newDockerClient() (*client.Client, error) {
 	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
 	if err != nil {
 		return nil, fmt.Errorf("failed to create Docker client: %w", err)
 	}
+	return cli, nil
+}
+
+func ListDockerContainers() ([]types.Container, error) {
+	cli, err := newDockerClient()
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
 
 	// Get the list of containers
 	return cli.ContainerList(context.Background(), container.ListOptions{})
 }
 
+// PingDocker checks that the Docker daemon answers
+func PingDocker(ctx context.Context) error {
+	cli, err := newDockerClient()
+	if err != nil {
+		return err
+	}
+	defer cli.Close()
+	_, err = cli.Ping(ctx)
+	return err
+}
+
+// ContainerName is the name of a listed container without its leading slash, its short ID when it has none
+func ContainerName(c types.Container) string {
+	if len(c.Names) > 0 && strings.TrimPrefix(c.Names[0], "/") != "" {
+		return strings.TrimPrefix(c.Names[0], "/")
+	}
+	return shortContainerID(c.ID)
+}
+
+// MatchContainer tells if the name of a container contains pattern or its ID starts with it
+func MatchContainer(c types.Container, pattern string) bool {
+	return strings.Contains(ContainerName(c), pattern) || strings.HasPrefix(c.ID, pattern)
+}
+
+func shortContainerID(id string) string {
+	if len(id) > 12 {
+		return id[:12]
+	}
+	return id
+}
+
+// containerGone wraps err in ErrContainerStopped when the container is not running anymore,
+// telling a container stopped mid-read apart from a failing read
+func containerGone(ctx context.Context, cli *client.Client, containerID string, err error) error {
+	info, inspectErr := cli.ContainerInspect(ctx, containerID)
+	if errdefs.IsNotFound(inspectErr) || (inspectErr == nil && (info.State == nil || !info.State.Running)) {
+		return fmt.Errorf("%w: %s", ErrContainerStopped, containerID)
+	}
+	return err
+}
+
+// dockerExec runs cmd in a container, handing its stdout to read as it streams in.
+// A command exiting with an error fails with its stderr.
+func dockerExec(ctx context.Context, cli *client.Client, containerID string, cmd []string, read func(stdout io.Reader) error) error {
+	created, err := cli.ContainerExecCreate(ctx, containerID, container.ExecOptions{
+		Cmd:          cmd,
+		AttachStdout: true,
+		AttachStderr: true,
+	})
+	if err != nil {
+		return containerGone(ctx, cli, containerID, fmt.Errorf("failed to create exec instance: %w", err))
+	}
+	attached, err := cli.ContainerExecAttach(ctx, created.ID, container.ExecAttachOptions{})
+	if err != nil {
+		return containerGone(ctx, cli, containerID, fmt.Errorf("failed to attach to exec instance: %w", err))
+	}
+	defer attached.Close()
+
+	stdout, writer := io.Pipe()
+	var stderr bytes.Buffer
+	copied := make(chan struct{})
+	go func() {
+		defer close(copied)
+		_, err := stdcopy.StdCopy(writer, &stderr, attached.Reader)
+		writer.CloseWithError(err)
+	}()
+	readErr := read(stdout)
+	// a reader done early stops the copy
+	stdout.Close()
+	<-copied
+	if readErr != nil {
+		return containerGone(ctx, cli, containerID, readErr)
+	}
+
+	inspected, err := cli.ContainerExecInspect(ctx, created.ID)
+	if err != nil {
+		return containerGone(ctx, cli, containerID, err)
+	}
+	if inspected.ExitCode != 0 {
+		err := fmt.Errorf("%s exited with %d: %s", cmd[0], inspected.ExitCode, strings.TrimSpace(stderr.String()))
+		return containerGone(ctx, cli, containerID, err)
+	}
+	return nil
+}
+
+// containerOpenFile copies a file inside a container to a temp file, for the reads that need random
+// access, like sshOpenFile does for the files of SSH hosts
+func containerOpenFile(ctx context.Context, cli *client.Client, containerID string, filePath string) (File, error) {
+	if err := EnsureFreeDisk(TmpDir(), 0); err != nil {
+		return nil, fmt.Errorf("copying %s of %s: %w", filePath, containerID, err)
+	}
+	tmpFile, err := os.Create(GetTmpFileNameForContainer())
+	if err != nil {
+		return nil, err
+	}
+	copied := &tmpCopy{File: tmpFile}
+	err = dockerExec(ctx, cli, containerID, []string{"cat", "--", filePath}, func(stdout io.Reader) error {
+		_, err := io.Copy(tmpFile, stdout)
+		return err
+	})
+	if err != nil {
+		copied.Close()
+		return nil, err
+	}
+	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		copied.Close()
+		return nil, err
+	}
+	return copied, nil
+}
+
+// Deprecated: use ContainerStdoutToTmpContext.
 func ContainerStdoutToTmp(containerID string) *os.File {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	tmpFile, err := ContainerStdoutToTmpContext(context.Background(), containerID)
 	if err != nil {
-		slog.Error("creating Docker client", "docker", err)
+		slog.Error("copying container logs", "containerID", containerID, "error", err)
 		return nil
 	}
+	return tmpFile
+}
+
+// ContainerStdoutToTmpContext copies the last lines of the stdout and stderr of a container to its temp file,
+// from where it is served as a local file. The temp file of the container is reused when it has one.
+func ContainerStdoutToTmpContext(ctx context.Context, containerID string) (*os.File, error) {
+	cli, err := newDockerClient()
+	if err != nil {
+		return nil, err
+	}
+	defer cli.Close()
 
-	// Get container logs
-	options := container.LogsOptions{ShowStdout: true, ShowStderr: true}
-	out, err := cli.ContainerLogs(context.Background(), containerID, options)
+	info, err := cli.ContainerInspect(ctx, containerID)
 	if err != nil {
-		slog.Error("getting container logs", containerID, err)
-		return nil
+		return nil, containerGone(ctx, cli, containerID, err)
+	}
+	name := strings.TrimPrefix(info.Name, "/")
+	out, err := cli.ContainerLogs(ctx, containerID, container.LogsOptions{ShowStdout: true, ShowStderr: true})
+	if err != nil {
+		return nil, containerGone(ctx, cli, containerID, fmt.Errorf("getting container logs: %w", err))
+	}
+	defer out.Close()
+
+	// without a TTY stdout and stderr come multiplexed
+	logs := io.Reader(out)
+	if info.Config == nil || !info.Config.Tty {
+		reader, writer := io.Pipe()
+		go func() {
+			_, err := stdcopy.StdCopy(writer, writer, out)
+			writer.CloseWithError(err)
+		}()
+		defer reader.Close()
+		logs = reader
 	}
 
-	// Check if tmpFile already exists in GlobalFilePaths for container ID previously by watcher
+	// Check if tmpFile already exists in the file list for container previously by watcher
 	var tmpFile *os.File
-	for _, fileInfo := range GlobalFilePaths {
-		if fileInfo.Host == containerID[:12] && fileInfo.Type == TypeDocker && strings.HasPrefix(fileInfo.FilePath, TmpContainerPath) {
+	for _, fileInfo := range GlobalFileRegistry.Snapshot() {
+		if fileInfo.Host == name && fileInfo.Type == TypeDocker && strings.HasPrefix(fileInfo.FilePath, TmpContainerPath) {
 			tmpFile, err = os.OpenFile(fileInfo.FilePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
 			if err != nil {
-				slog.Error("opening temp file", fileInfo.FilePath, err)
-				return nil
+				return nil, fmt.Errorf("opening temp file: %w", err)
 			}
 		}
 	}
 	if tmpFile == nil {
 		tmpFile, err = os.Create(GetTmpFileNameForContainer())
 		if err != nil {
-			slog.Error("creating temp file", "tmp", err)
-			return nil
+			return nil, fmt.Errorf("creating temp file: %w", err)
 		}
 	}
-	scanner := bufio.NewScanner(out)
+	scanner := newLineScanner(logs)
 	lineCount := 0
 	for scanner.Scan() {
-		line := scanner.Text()
-		line = stripansi.Strip(line)
-		if lineCount >= 10000 {
+		line := stripansi.Strip(scanner.Text())
+		if lineCount >= containerStdoutMaxLines {
 			if err := tmpFile.Truncate(0); err != nil {
 				slog.Error("truncating file", "scan", err)
 			}
@@ -78,168 +233,93 @@ func ContainerStdoutToTmp(containerID string) *os.File {
 		}
 		lineCount++
 	}
-
 	if err := scanner.Err(); err != nil {
-		slog.Error("reading container logs", containerID, err)
+		tmpFile.Close()
+		return nil, containerGone(ctx, cli, containerID, fmt.Errorf("reading container logs: %w", err))
 	}
-	return tmpFile
+	return tmpFile, nil
 }
 
+// Deprecated: use ContainerLogsFromFileContext.
 func ContainerLogsFromFile(containerID string, query string, ignorePattern string, filePath string, page, pageSize int, reverse bool) (*ScanResult, error) {
-	lines := []LineResult{}
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
-	if err != nil {
-		return nil, fmt.Errorf("failed to create Docker client: %w", err)
-	}
+	return ContainerLogsFromFileContext(context.Background(), containerID, query, ignorePattern, filePath, page, pageSize, reverse)
+}
 
-	re, err := regexp.Compile(query)
+// ContainerLogsFromFileContext returns a page of the lines of a file inside a container matching query,
+// the file streaming in from cat run in the container. A container stopping before the whole file is
+// read fails with ErrContainerStopped.
+func ContainerLogsFromFileContext(ctx context.Context, containerID string, query string, ignorePattern string, filePath string, page, pageSize int, reverse bool) (*ScanResult, error) {
+	_, err := regexp.Compile(query)
 	if err != nil {
 		return nil, fmt.Errorf("invalid regex pattern: %w", err)
 	}
-
-	var reIgnore *regexp.Regexp
 	if ignorePattern != "" {
-		reIgnore, err = regexp.Compile(ignorePattern)
-		if err != nil {
+		if _, err := regexp.Compile(ignorePattern); err != nil {
 			return nil, fmt.Errorf("invalid ignore regex pattern: %w", err)
 		}
 	}
-
-	countCmd := []string{"sh", "-c", fmt.Sprintf("wc -l < %s", filePath)}
-	countExecConfig := container.ExecOptions{
-		Cmd:          countCmd,
-		AttachStdout: true,
-		AttachStderr: true,
-	}
-
-	countExecIDResp, err := cli.ContainerExecCreate(context.Background(), containerID, countExecConfig)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create exec instance for counting lines: %w", err)
-	}
-
-	countResp, err := cli.ContainerExecAttach(context.Background(), countExecIDResp.ID, container.ExecStartOptions{})
-	if err != nil {
-		return nil, fmt.Errorf("failed to attach to exec instance for counting lines: %w", err)
-	}
-	defer countResp.Close()
-
-	countScanner := bufio.NewScanner(countResp.Reader)
-	countScanner.Scan()
-	totalLines, err := strconv.Atoi(strings.TrimSpace(CleanString(countScanner.Text())))
-	if err != nil {
-		return nil, fmt.Errorf("failed to parse line count: %w", err)
-	}
-
-	startLine := (page - 1) * pageSize
-
-	var cmd []string
-	if reverse {
-		cmd = []string{"sh", "-c", fmt.Sprintf("tac %s | tail -n +%d | head -n %d", filePath, startLine+1, pageSize)}
-	} else {
-		cmd = []string{"sh", "-c", fmt.Sprintf("tail -n +%d %s | head -n %d", startLine+1, filePath, pageSize)}
-	}
-
-	execConfig := container.ExecOptions{
-		Cmd:          cmd,
-		AttachStdout: true,
-		AttachStderr: true,
-	}
-
-	execIDResp, err := cli.ContainerExecCreate(context.Background(), containerID, execConfig)
+	cli, err := newDockerClient()
 	if err != nil {
-		return nil, fmt.Errorf("failed to create exec instance: %w", err)
+		return nil, err
 	}
+	defer cli.Close()
 
-	resp, err := cli.ContainerExecAttach(context.Background(), execIDResp.ID, container.ExecStartOptions{})
+	watcher := &Watcher{filePath: filePath, matchPattern: query, ignorePattern: ignorePattern, sshHost: containerID}
+	window := watcher.pageWindow(page, pageSize, reverse)
+	var counts int
+	err = dockerExec(ctx, cli, containerID, []string{"cat", "--", filePath}, func(stdout io.Reader) error {
+		counts, err = watcher.collectLinesInto(ctx, newLineScanner(utf8BufferedReader(stdout)), scanStart{}, window)
+		return err
+	})
 	if err != nil {
-		return nil, fmt.Errorf("failed to attach to exec instance: %w", err)
-	}
-	defer resp.Close()
-
-	scanner := bufio.NewScanner(resp.Reader)
-	lineNumber := startLine + 1
-	for scanner.Scan() {
-		lineContent := stripansi.Strip(scanner.Text())
-		lineContent = CleanString(lineContent)
-		if reIgnore != nil && reIgnore.MatchString(lineContent) {
-			continue
-		}
-		if re.MatchString(lineContent) {
-			lineResult := LineResult{
-				LineNumber: lineNumber,
-				Content:    lineContent,
-				Level:      "",
-			}
-			lines = append(lines, lineResult)
-		}
-		lineNumber++
-	}
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("reading logs: %w", err)
-	}
-
-	if reverse {
-		for i := range lines {
-			lines[i].LineNumber = totalLines - (startLine + len(lines)) + i + 1
-		}
-		shifted := make([]LineResult, len(lines))
-		for i := range lines {
-			newIndex := len(lines) - 1 - i
-			shifted[newIndex] = LineResult{
-				LineNumber: lines[len(lines)-1-i].LineNumber,
-				Content:    lines[i].Content,
-				Level:      lines[len(lines)-1-i].Level,
-			}
-		}
-		lines = shifted
+		return nil, err
 	}
 
-	AppendGeneralInfo(&lines)
-	scanResult := &ScanResult{
-		FilePath:     filePath,
-		Host:         containerID,
-		MatchPattern: query,
-		Total:        totalLines,
-		Lines:        lines,
-	}
-
-	return scanResult, nil
+	lines := window.page()
+	sources := []LineSource{{FilePath: filePath, Host: containerID}}
+	watcher.finalizeLines(lines, sources)
+	result := watcher.scanResult(lines, window.matches, counts, sources)
+	result.Truncated = window.truncated
+	return result, nil
 }
 
+// Deprecated: use ContainerFileInfosContext.
 func GetContainerFileInfos(pattern string, limit int, containerID string) []FileInfo {
-	cli, err := client.NewClientWithOpts(client.FromEnv, client.WithAPIVersionNegotiation())
+	fileInfos, err := ContainerFileInfosContext(context.Background(), pattern, limit, containerID)
 	if err != nil {
-		slog.Error("Failed to create Docker client", "docker", err)
+		slog.Error("listing container files", "containerID", containerID, "error", err)
 		return nil
 	}
+	return fileInfos
+}
 
-	execConfig := container.ExecOptions{
-		Cmd:          []string{"sh", "-c", fmt.Sprintf("ls -1 %s", pattern)},
-		AttachStdout: true,
-		AttachStderr: true,
-	}
-
-	execIDResp, err := cli.ContainerExecCreate(context.Background(), containerID, execConfig)
+// ContainerFileInfosContext lists the files inside a container matching pattern, globbed by its shell,
+// with the name of the container as their host
+func ContainerFileInfosContext(ctx context.Context, pattern string, limit int, containerID string) ([]FileInfo, error) {
+	cli, err := newDockerClient()
 	if err != nil {
-		slog.Error("Failed to create exec instance", "container", err)
-		return nil
+		return nil, err
 	}
+	defer cli.Close()
 
-	resp, err := cli.ContainerExecAttach(context.Background(), execIDResp.ID, container.ExecStartOptions{})
+	info, err := cli.ContainerInspect(ctx, containerID)
 	if err != nil {
-		slog.Error("Failed to attach to exec instance", "container", err)
-		return nil
+		return nil, containerGone(ctx, cli, containerID, err)
 	}
-	defer resp.Close()
+	name := strings.TrimPrefix(info.Name, "/")
 
-	scanner := bufio.NewScanner(resp.Reader)
 	filePaths := []string{}
-	for scanner.Scan() {
-		filePaths = append(filePaths, CleanString(scanner.Text()))
-	}
-	if err := scanner.Err(); err != nil {
-		slog.Error("reading exec output", "scanner", err)
-		return nil
+	err = dockerExec(ctx, cli, containerID, []string{"sh", "-c", "ls -1 " + pattern}, func(stdout io.Reader) error {
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			if filePath := strings.TrimSpace(scanner.Text()); filePath != "" {
+				filePaths = append(filePaths, filePath)
+			}
+		}
+		return scanner.Err()
+	})
+	if err != nil {
+		return nil, err
 	}
 
 	fileInfos := make([]FileInfo, 0)
@@ -248,7 +328,10 @@ func GetContainerFileInfos(pattern string, limit int, containerID string) []File
 		filePaths = filePaths[:limit]
 	}
 	for _, filePath := range filePaths {
-		linesCount, fileSize, err := getFileStatsFromContainer(cli, containerID, filePath)
+		linesCount, fileSize, err := getFileStatsFromContainer(ctx, cli, containerID, filePath)
+		if errors.Is(err, ErrContainerStopped) {
+			return nil, err
+		}
 		if err != nil {
 			slog.Error("Failed to get file stats", filePath, err)
 			continue
@@ -259,64 +342,30 @@ func GetContainerFileInfos(pattern string, limit int, containerID string) []File
 			LinesCount: linesCount,
 			FileSize:   fileSize,
 			Type:       TypeDocker,
-			Host:       containerID[:12],
+			Host:       name,
 		})
 	}
 
-	return fileInfos
+	return fileInfos, nil
 }
 
-func getFileStatsFromContainer(cli *client.Client, containerID string, filePath string) (int, int64, error) {
-	execConfig := container.ExecOptions{
-		Cmd:          []string{"wc", "-l", filePath},
-		AttachStdout: true,
-		AttachStderr: true,
-	}
-
-	execIDResp, err := cli.ContainerExecCreate(context.Background(), containerID, execConfig)
-	if err != nil {
-		return 0, 0, fmt.Errorf("failed to create exec instance for wc: %w", err)
-	}
-
-	resp, err := cli.ContainerExecAttach(context.Background(), execIDResp.ID, container.ExecStartOptions{})
-	if err != nil {
-		return 0, 0, fmt.Errorf("failed to attach to exec instance for wc: %w", err)
-	}
-	defer resp.Close()
-
-	scanner := bufio.NewScanner(resp.Reader)
+func getFileStatsFromContainer(ctx context.Context, cli *client.Client, containerID string, filePath string) (int, int64, error) {
 	var linesCount int
-	if scanner.Scan() {
-		fmt.Sscanf(CleanString(scanner.Text()), "%d", &linesCount) //nolint: errcheck
-	}
-	if err := scanner.Err(); err != nil {
-		return 0, 0, fmt.Errorf("reading wc output: %w", err)
-	}
-
-	execConfig = container.ExecOptions{
-		Cmd:          []string{"stat", "-c", "%s", filePath},
-		AttachStdout: true,
-		AttachStderr: true,
-	}
-
-	execIDResp, err = cli.ContainerExecCreate(context.Background(), containerID, execConfig)
-	if err != nil {
-		return 0, 0, fmt.Errorf("failed to create exec instance for stat: %w", err)
-	}
-
-	resp, err = cli.ContainerExecAttach(context.Background(), execIDResp.ID, container.ExecStartOptions{})
+	err := dockerExec(ctx, cli, containerID, []string{"wc", "-l", filePath}, func(stdout io.Reader) error {
+		_, err := fmt.Fscanf(stdout, "%d", &linesCount)
+		return err
+	})
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to attach to exec instance for stat: %w", err)
+		return 0, 0, fmt.Errorf("counting lines: %w", err)
 	}
-	defer resp.Close()
 
-	scanner = bufio.NewScanner(resp.Reader)
 	var fileSize int64
-	if scanner.Scan() {
-		fmt.Sscanf(CleanString(scanner.Text()), "%d", &fileSize) //nolint: errcheck
-	}
-	if err := scanner.Err(); err != nil {
-		return 0, 0, fmt.Errorf("reading stat output: %w", err)
+	err = dockerExec(ctx, cli, containerID, []string{"stat", "-c", "%s", filePath}, func(stdout io.Reader) error {
+		_, err := fmt.Fscanf(stdout, "%d", &fileSize)
+		return err
+	})
+	if err != nil {
+		return 0, 0, fmt.Errorf("getting file size: %w", err)
 	}
 
 	return linesCount, fileSize, nil
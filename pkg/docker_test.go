@@ -0,0 +1,329 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/docker/docker/api/types/container"
+	"github.com/docker/docker/pkg/stdcopy"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeContainer struct {
+	ID      string
+	Name    string
+	Running bool
+	Tty     bool
+	Stdout  string
+	Stderr  string
+	Files   map[string]string
+	// StopAfter stops the container after cat wrote that many bytes
+	StopAfter int
+}
+
+type fakeExec struct {
+	container *fakeContainer
+	cmd       []string
+	exitCode  int
+}
+
+// fakeDocker answers the endpoints of the Docker API the Docker source calls
+type fakeDocker struct {
+	mutex      sync.Mutex
+	containers []*fakeContainer
+	execs      map[string]*fakeExec
+}
+
+var fakeDockerVersion = regexp.MustCompile(`^/v[0-9.]+`)
+
+// useFakeDocker points DOCKER_HOST at a fake Docker API serving containers
+func useFakeDocker(t *testing.T, containers ...*fakeContainer) *fakeDocker {
+	fake := &fakeDocker{containers: containers, execs: map[string]*fakeExec{}}
+	server := httptest.NewServer(fake)
+	t.Cleanup(server.Close)
+	t.Setenv("DOCKER_HOST", "tcp://"+server.Listener.Addr().String())
+	return fake
+}
+
+func (f *fakeDocker) find(id string) *fakeContainer {
+	for _, c := range f.containers {
+		if c.Name == id || strings.HasPrefix(c.ID, id) {
+			return c
+		}
+	}
+	return nil
+}
+
+func (f *fakeDocker) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	route := fakeDockerVersion.ReplaceAllString(r.URL.Path, "")
+	parts := strings.Split(strings.Trim(route, "/"), "/")
+	reply := func(status int, body interface{}) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		json.NewEncoder(w).Encode(body) //nolint: errcheck
+	}
+
+	switch {
+	case route == "/_ping":
+		w.Header().Set("API-Version", "1.45")
+		fmt.Fprint(w, "OK")
+	case route == "/containers/json":
+		list := []map[string]interface{}{}
+		for _, c := range f.containers {
+			if c.Running {
+				list = append(list, map[string]interface{}{"Id": c.ID, "Names": []string{"/" + c.Name}})
+			}
+		}
+		reply(http.StatusOK, list)
+	case len(parts) == 3 && parts[0] == "containers":
+		c := f.find(parts[1])
+		if c == nil {
+			reply(http.StatusNotFound, map[string]string{"message": "No such container: " + parts[1]})
+			return
+		}
+		switch parts[2] {
+		case "json":
+			reply(http.StatusOK, map[string]interface{}{
+				"Id":     c.ID,
+				"Name":   "/" + c.Name,
+				"State":  map[string]interface{}{"Running": c.Running},
+				"Config": map[string]interface{}{"Tty": c.Tty},
+			})
+		case "logs":
+			if c.Tty {
+				fmt.Fprint(w, c.Stdout)
+				return
+			}
+			stdcopy.NewStdWriter(w, stdcopy.Stdout).Write([]byte(c.Stdout)) //nolint: errcheck
+			stdcopy.NewStdWriter(w, stdcopy.Stderr).Write([]byte(c.Stderr)) //nolint: errcheck
+		case "exec":
+			if !c.Running {
+				reply(http.StatusConflict, map[string]string{"message": "container " + c.ID + " is not running"})
+				return
+			}
+			options := container.ExecOptions{}
+			json.NewDecoder(r.Body).Decode(&options) //nolint: errcheck
+			id := fmt.Sprintf("exec%d", len(f.execs)+1)
+			f.execs[id] = &fakeExec{container: c, cmd: options.Cmd}
+			reply(http.StatusCreated, map[string]string{"Id": id})
+		}
+	case len(parts) == 3 && parts[0] == "exec" && parts[2] == "json":
+		exec, ok := f.execs[parts[1]]
+		if !ok || !exec.container.Running && exec.exitCode == 0 {
+			reply(http.StatusNotFound, map[string]string{"message": "No such exec instance"})
+			return
+		}
+		reply(http.StatusOK, map[string]interface{}{"ID": parts[1], "Running": false, "ExitCode": exec.exitCode})
+	case len(parts) == 3 && parts[0] == "exec" && parts[2] == "start":
+		io.Copy(io.Discard, r.Body) //nolint: errcheck
+		exec := f.execs[parts[1]]
+		conn, buffered, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprint(buffered, "HTTP/1.1 101 UPGRADED\r\nContent-Type: application/vnd.docker.multiplexed-stream\r\nConnection: Upgrade\r\nUpgrade: tcp\r\n\r\n")
+		stdout, stderr := exec.run()
+		stdcopy.NewStdWriter(buffered, stdcopy.Stdout).Write([]byte(stdout)) //nolint: errcheck
+		if stderr != "" {
+			stdcopy.NewStdWriter(buffered, stdcopy.Stderr).Write([]byte(stderr)) //nolint: errcheck
+		}
+		buffered.Flush() //nolint: errcheck
+	default:
+		reply(http.StatusNotFound, map[string]string{"message": "page not found"})
+	}
+}
+
+// run runs the few commands the Docker source execs
+func (e *fakeExec) run() (string, string) {
+	c := e.container
+	switch e.cmd[0] {
+	case "cat":
+		filePath := e.cmd[len(e.cmd)-1]
+		content, ok := c.Files[filePath]
+		if !ok {
+			e.exitCode = 1
+			return "", "cat: can't open '" + filePath + "': No such file or directory"
+		}
+		if c.StopAfter > 0 && c.StopAfter < len(content) {
+			c.Running = false
+			e.exitCode = 137
+			return content[:c.StopAfter], ""
+		}
+		return content, ""
+	case "sh":
+		pattern := strings.TrimPrefix(e.cmd[2], "ls -1 ")
+		filePaths := []string{}
+		for filePath := range c.Files {
+			if ok, _ := path.Match(pattern, filePath); ok {
+				filePaths = append(filePaths, filePath)
+			}
+		}
+		sort.Strings(filePaths)
+		return strings.Join(filePaths, "\n") + "\n", ""
+	case "wc":
+		return fmt.Sprintf("%d %s\n", strings.Count(c.Files[e.cmd[2]], "\n"), e.cmd[2]), ""
+	case "stat":
+		return fmt.Sprintf("%d\n", len(c.Files[e.cmd[3]])), ""
+	}
+	e.exitCode = 127
+	return "", e.cmd[0] + ": not found"
+}
+
+func TestStringToDockerPathConfig(t *testing.T) {
+	config, err := StringToDockerPathConfig("web /var/log/app.log")
+	assert.NoError(t, err)
+	assert.Equal(t, &DockerPathConfig{ContainerID: "web", FilePath: "/var/log/app.log"}, config)
+
+	config, err = StringToDockerPathConfig("0123456789ab")
+	assert.NoError(t, err)
+	assert.Equal(t, &DockerPathConfig{ContainerID: "0123456789ab"}, config)
+
+	for _, s := range []string{"", "  ", "web /var/log/app.log extra"} {
+		_, err := StringToDockerPathConfig(s)
+		assert.Error(t, err, s)
+	}
+}
+
+func TestContainerFileInfosContext(t *testing.T) {
+	useFakeDocker(t, &fakeContainer{ID: "0123456789abcdef", Name: "web", Running: true, Files: map[string]string{
+		"/var/log/app.log":    "line 1\nline 2\n",
+		"/var/log/error.log":  "oops\n",
+		"/var/log/readme.txt": "not a log\n",
+	}})
+
+	fileInfos, err := ContainerFileInfosContext(context.Background(), "/var/log/*.log", 10, "0123456789ab")
+	assert.NoError(t, err)
+	assert.Equal(t, []FileInfo{
+		{FilePath: "/var/log/app.log", LinesCount: 2, FileSize: 14, Type: TypeDocker, Host: "web"},
+		{FilePath: "/var/log/error.log", LinesCount: 1, FileSize: 5, Type: TypeDocker, Host: "web"},
+	}, fileInfos)
+
+	fileInfos, err = ContainerFileInfosContext(context.Background(), "/var/log/*.log", 1, "web")
+	assert.NoError(t, err)
+	assert.Len(t, fileInfos, 1)
+
+	_, err = ContainerFileInfosContext(context.Background(), "/var/log/*.log", 10, "missing")
+	assert.ErrorIs(t, err, ErrContainerStopped)
+}
+
+func TestContainerLogsFromFileContext(t *testing.T) {
+	content := ""
+	for i := 1; i <= 20; i++ {
+		content += fmt.Sprintf("INFO request %d\n", i)
+	}
+	web := &fakeContainer{ID: "0123456789abcdef", Name: "web", Running: true, Files: map[string]string{"/var/log/app.log": content}}
+	useFakeDocker(t, web, &fakeContainer{ID: "fedcba9876543210", Name: "db", Files: map[string]string{"/var/log/app.log": content}})
+
+	result, err := ContainerLogsFromFileContext(context.Background(), "web", "request 1[0-9]", "", "/var/log/app.log", 1, 3, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 10, result.Total)
+	assert.Len(t, result.Lines, 3)
+	assert.Equal(t, 10, result.Lines[0].LineNumber)
+	assert.Equal(t, "INFO request 10", result.Lines[0].Content)
+	assert.NotEmpty(t, result.Lines[0].Anchor)
+
+	result, err = ContainerLogsFromFileContext(context.Background(), "web", "", "request 20", "/var/log/app.log", 1, 2, true)
+	assert.NoError(t, err)
+	assert.Equal(t, 19, result.Total)
+	assert.Equal(t, []int{18, 19}, []int{result.Lines[0].LineNumber, result.Lines[1].LineNumber})
+
+	// a missing file fails with what cat said, a stopped container with ErrContainerStopped
+	_, err = ContainerLogsFromFileContext(context.Background(), "web", "", "", "/var/log/missing.log", 1, 10, false)
+	assert.ErrorContains(t, err, "No such file or directory")
+	assert.NotErrorIs(t, err, ErrContainerStopped)
+	_, err = ContainerLogsFromFileContext(context.Background(), "db", "", "", "/var/log/app.log", 1, 10, false)
+	assert.ErrorIs(t, err, ErrContainerStopped)
+
+	// the container stopping halfway through the file
+	web.StopAfter = len(content) / 2
+	_, err = ContainerLogsFromFileContext(context.Background(), "web", "", "", "/var/log/app.log", 1, 10, false)
+	assert.ErrorIs(t, err, ErrContainerStopped)
+	assert.ErrorContains(t, err, "web")
+
+	// which the API answers with a 409
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: "/var/log/app.log", Type: TypeDocker, Host: "web"}})
+	rec := httptest.NewRecorder()
+	newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff}).ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api?type=docker&host=web&file_path=/var/log/app.log", nil))
+	assert.Equal(t, http.StatusConflict, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrContainerStopped.Error())
+}
+
+func TestContainerStdoutToTmpContext(t *testing.T) {
+	useFakeDocker(t,
+		&fakeContainer{ID: "0123456789abcdef", Name: "web", Running: true, Stdout: "GET / 200\nGET /a 200\n", Stderr: "\x1b[31mwarning\x1b[0m\n"},
+		&fakeContainer{ID: "fedcba9876543210", Name: "tty", Running: true, Tty: true, Stdout: "prompt> ls\n"},
+	)
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	GlobalFileRegistry.Replace(nil)
+
+	// stdout and stderr demultiplexed, no stream headers in the lines
+	tmpFile, err := ContainerStdoutToTmpContext(context.Background(), "web")
+	assert.NoError(t, err)
+	tmpFile.Close()
+	defer os.Remove(tmpFile.Name())
+	content, err := os.ReadFile(tmpFile.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, "GET / 200\nGET /a 200\nwarning\n", string(content))
+
+	// the temp file of the container is reused
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: tmpFile.Name(), Type: TypeDocker, Host: "web"}})
+	again, err := ContainerStdoutToTmpContext(context.Background(), "0123456789ab")
+	assert.NoError(t, err)
+	again.Close()
+	assert.Equal(t, tmpFile.Name(), again.Name())
+
+	tty, err := ContainerStdoutToTmpContext(context.Background(), "tty")
+	assert.NoError(t, err)
+	tty.Close()
+	defer os.Remove(tty.Name())
+	content, err = os.ReadFile(tty.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, "prompt> ls\n", string(content))
+
+	_, err = ContainerStdoutToTmpContext(context.Background(), "missing")
+	assert.ErrorIs(t, err, ErrContainerStopped)
+}
+
+func TestUpdateGlobalFilePaths_Docker(t *testing.T) {
+	useFakeDocker(t, &fakeContainer{ID: "0123456789abcdef", Name: "web", Running: true, Stdout: "started\n", Files: map[string]string{
+		"/var/log/app.log": "line 1\n",
+	}})
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	GlobalSourceStatuses.Set(nil)
+	defer GlobalSourceStatuses.Set(nil)
+
+	UpdateGlobalFilePaths(nil, nil, SliceFlags{"web", "web /var/log/*.log"}, 10)
+	var docker []FileInfo
+	for _, fileInfo := range GlobalFileRegistry.Snapshot() {
+		if fileInfo.Type == TypeDocker {
+			docker = append(docker, fileInfo)
+			if strings.HasPrefix(fileInfo.FilePath, TmpContainerPath) {
+				defer os.Remove(fileInfo.FilePath)
+			}
+		}
+	}
+	if assert.Len(t, docker, 2) {
+		assert.Equal(t, "/var/log/app.log", docker[0].FilePath)
+		assert.True(t, strings.HasPrefix(docker[1].FilePath, TmpContainerPath))
+		for _, fileInfo := range docker {
+			assert.Equal(t, "web", fileInfo.Host)
+		}
+	}
+	for _, status := range GlobalSourceStatuses.List() {
+		assert.Empty(t, status.Error, status.Source)
+	}
+}
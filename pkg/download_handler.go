@@ -0,0 +1,276 @@
+package pkg
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/labstack/echo/v4"
+)
+
+type DownloadRequest struct {
+	ID       string `json:"id" query:"id"`
+	FilePath string `json:"file_path" query:"file_path" validate:"required" message:"file_path is required"`
+	Host     string `json:"host" query:"host"`
+	Type     string `json:"type" query:"type" validate:"required" message:"type is required"`
+	// Decompress serves a compressed file decompressed, as text without Range support
+	Decompress bool `json:"decompress" query:"decompress"`
+	// Lines (from-to, 1 based and inclusive) serves only these lines, decompressed, as text
+	Lines string `json:"lines" query:"lines"`
+}
+
+// downloadContentTypes are the content types of compressed downloads, by compression
+var downloadContentTypes = map[string]string{
+	FileCompressionGzip:  "application/gzip",
+	FileCompressionZstd:  "application/zstd",
+	FileCompressionBzip2: "application/x-bzip2",
+	FileCompressionXz:    "application/x-xz",
+}
+
+// GetDownload serves a file as is. Local files answer Range requests, so an interrupted download
+// resumes where it stopped. The files of SSH hosts and inside containers stream in through the remote
+// session, a Range request of one is served from a temp copy. It takes no read slot, serving bytes is
+// cheap and downloads are long.
+func (h *APIHandler) GetDownload(c echo.Context) error {
+	req := new(DownloadRequest)
+	if err := BindRequest(c, req); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err)
+	}
+	if err := resolveFileID(req.ID, &req.FilePath, &req.Host, &req.Type); err != nil {
+		return err
+	}
+	msgs, err := ValidateRequest(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, msgs)
+	}
+	from, to, err := parseLineRange(req.Lines)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	if err := AuthorizeFilePath(req.FilePath, req.Type, req.Host); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err)
+	}
+
+	switch req.Type {
+	case TypeFile, TypeStdin, TypeK8s, TypeJournal, TypeHTTP, TypeS3:
+	case TypeDocker:
+		if !strings.HasPrefix(req.FilePath, TmpContainerPath) {
+			return h.downloadContainerFile(c, req, from, to)
+		}
+	case TypeSSH:
+		return h.downloadSSHFile(c, req, from, to)
+	default:
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("download is not supported for type %s", req.Type))
+	}
+
+	file, err := openFile(GlobalFileOpener, req.FilePath)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	defer file.Close()
+	head := make([]byte, 512)
+	n, _ := file.ReadAt(head, 0)
+	head = head[:n]
+	if req.Lines != "" && !IsCompressed(head) && DetectEncoding(head) == EncodingUTF8 {
+		// a plain file is read from the checkpoint of its line index before the lines
+		first, err := seekLineIndex(c.Request().Context(), file, req.FilePath, from)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		return streamLineRange(c, filepath.Base(req.FilePath), file, first, from, to)
+	}
+	if req.Lines != "" || (req.Decompress && IsCompressed(head)) {
+		return streamDownload(c, req, file, from, to)
+	}
+	return serveFile(c, file, filepath.Base(req.FilePath), downloadContentType(head), "")
+}
+
+// downloadSSHFile streams a file of an SSH host as cat writes it
+func (h *APIHandler) downloadSSHFile(c echo.Context, req *DownloadRequest, from, to int) error {
+	sshPathConfig := h.API.FindSSHConfig(req.Host)
+	if sshPathConfig == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "host not found")
+	}
+	ctx := c.Request().Context()
+	if rangedDownload(c, req) {
+		file, err := sshOpenFile(ctx, req.FilePath, sshPathConfig.ToSSHConfig())
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		defer file.Close()
+		return serveRemoteCopy(c, req, file)
+	}
+	stream, err := sshStreamFile(ctx, req.FilePath, sshPathConfig.ToSSHConfig())
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer stream.Close()
+	return streamDownload(c, req, stream, from, to)
+}
+
+// downloadContainerFile streams a file inside a container as cat run in the container writes it
+func (h *APIHandler) downloadContainerFile(c echo.Context, req *DownloadRequest, from, to int) error {
+	cli, err := newDockerClient()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+	}
+	defer cli.Close()
+	ctx := c.Request().Context()
+	if rangedDownload(c, req) {
+		file, err := containerOpenFile(ctx, cli, req.Host, req.FilePath)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+		}
+		defer file.Close()
+		return serveRemoteCopy(c, req, file)
+	}
+	err = dockerExec(ctx, cli, req.Host, []string{"cat", "--", req.FilePath}, func(stdout io.Reader) error {
+		return streamDownload(c, req, stdout, from, to)
+	})
+	if err == nil {
+		return nil
+	}
+	// once the download started its status is sent, the client sees it cut short
+	if c.Response().Committed {
+		slog.Error("downloading container file", "containerID", req.Host, "filePath", req.FilePath, "error", err)
+		return nil
+	}
+	if errors.Is(err, ErrContainerStopped) {
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	return echo.NewHTTPError(http.StatusInternalServerError, err.Error())
+}
+
+// rangedDownload tells whether a remote download is asked for a part of the file as is
+func rangedDownload(c echo.Context, req *DownloadRequest) bool {
+	return c.Request().Header.Get("Range") != "" && req.Lines == "" && !req.Decompress
+}
+
+// serveRemoteCopy serves the temp copy of a remote file as the file itself
+func serveRemoteCopy(c echo.Context, req *DownloadRequest, file File) error {
+	head := make([]byte, 512)
+	n, _ := file.ReadAt(head, 0)
+	return serveFile(c, file, filepath.Base(req.FilePath), downloadContentType(head[:n]), "")
+}
+
+// streamDownload copies r to the response as it is read, decompressed when asked to, or only the lines
+// from to to as text when they are given
+func streamDownload(c echo.Context, req *DownloadRequest, r io.Reader, from, to int) error {
+	fileName := filepath.Base(req.FilePath)
+	reader := bufio.NewReader(r)
+	head, _ := reader.Peek(512)
+	contentType := downloadContentType(head)
+	var body io.Reader = reader
+	if req.Lines != "" || req.Decompress {
+		decompressed, compression, err := NewDecompressingReader(reader)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
+		if compression != "" {
+			fileName = strings.TrimSuffix(fileName, filepath.Ext(fileName))
+			contentType = echo.MIMETextPlainCharsetUTF8
+		}
+		body = decompressed
+	}
+	if req.Lines != "" {
+		return streamLineRange(c, fileName, body, 1, from, to)
+	}
+	c.Response().Header().Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", fileName))
+	return c.Stream(http.StatusOK, contentType, body)
+}
+
+// streamLineRange writes the lines from to to of r as text, r starting at line first, named after fileName
+func streamLineRange(c echo.Context, fileName string, r io.Reader, first, from, to int) error {
+	ext := filepath.Ext(fileName)
+	fileName = fmt.Sprintf("%s.lines-%d-%d%s", strings.TrimSuffix(fileName, ext), from, to, ext)
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", fileName))
+	header.Set(echo.HeaderContentType, echo.MIMETextPlainCharsetUTF8)
+	c.Response().WriteHeader(http.StatusOK)
+	return writeLineRange(c.Response(), utf8BufferedReader(r), first, from, to)
+}
+
+// writeLineRange writes the lines from to to of r, whose first line is line first, it stops reading after to
+func writeLineRange(w io.Writer, r io.Reader, first, from, to int) error {
+	scanner := newLineScanner(r)
+	for lineNumber := first; lineNumber <= to && scanner.Scan(); lineNumber++ {
+		if lineNumber < from {
+			continue
+		}
+		if _, err := fmt.Fprintln(w, scanner.Text()); err != nil {
+			return err
+		}
+	}
+	return scanner.Err()
+}
+
+// parseLineRange parses lines given as from-to, both 1 based and inclusive, none when empty
+func parseLineRange(lines string) (int, int, error) {
+	if lines == "" {
+		return 0, 0, nil
+	}
+	fromText, toText, ok := strings.Cut(lines, "-")
+	from, fromErr := strconv.Atoi(strings.TrimSpace(fromText))
+	to, toErr := strconv.Atoi(strings.TrimSpace(toText))
+	if !ok || fromErr != nil || toErr != nil || from < 1 || to < from {
+		return 0, 0, fmt.Errorf("lines must be from-to, like 10-20, with from at least 1 and not after to: %s", lines)
+	}
+	return from, to, nil
+}
+
+// downloadContentType is the content type of a download starting with head: that of its compression,
+// or else sniffed from head as text or binary
+func downloadContentType(head []byte) string {
+	if contentType, ok := downloadContentTypes[DetectCompression(head)]; ok {
+		return contentType
+	}
+	return http.DetectContentType(head)
+}
+
+// GetExport serves a spooled export by its job ID, with Range support. An async export still being
+// written answers 202 with its job.
+func (h *APIHandler) GetExport(c echo.Context) error {
+	export, ok := GlobalExports.Get(c.Param("id"))
+	if !ok {
+		if job, ok := GlobalJobs.Get(c.Param("id")); ok && job.Kind == JobKindExport && job.State == JobStateRunning {
+			return c.JSON(http.StatusAccepted, job)
+		}
+		return echo.NewHTTPError(http.StatusNotFound, "export not found")
+	}
+	return serveExport(c, export)
+}
+
+// serveExport serves a spooled export, its ID is the ETag so that If-Range resumes only the same export
+func serveExport(c echo.Context, export Export) error {
+	file, err := os.Open(export.FilePath)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusNotFound, "export not found")
+	}
+	defer file.Close()
+	c.Response().Header().Set(HeaderExportID, export.ID)
+	return serveFile(c, file, export.FileName, export.ContentType, `"`+export.ID+`"`)
+}
+
+// serveFile serves file as an attachment. Accept-Ranges is advertised and a Range request gets the
+// 206 of the part asked for, checked against etag or the modification time when it has an If-Range.
+func serveFile(c echo.Context, file File, fileName string, contentType string, etag string) error {
+	info, err := file.Stat()
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	header := c.Response().Header()
+	header.Set(echo.HeaderContentType, contentType)
+	header.Set(echo.HeaderContentDisposition, fmt.Sprintf("attachment; filename=%q", fileName))
+	if etag != "" {
+		header.Set("ETag", etag)
+	}
+	http.ServeContent(c.Response(), c.Request(), fileName, info.ModTime(), file)
+	return nil
+}
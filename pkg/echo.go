@@ -1,59 +1,369 @@
 package pkg
 
 import (
-	"embed"
+	"compress/gzip"
+	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
+	"io/fs"
 	"log/slog"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
+	"github.com/andybalholm/brotli"
 	"github.com/labstack/echo/v4"
 	"github.com/labstack/echo/v4/middleware"
 )
 
 type EchoOptions struct {
-	Host      string
-	Port      int64
-	Cors      int64
-	BaseURL   string
-	Access    bool
-	PublicDir *embed.FS
+	Host        string
+	Port        int64
+	Cors        int64
+	BaseURL     string
+	Access      bool
+	PublicDir   fs.FS  // the built frontend under dist, a status page is served without one
+	Compression string // gzip, br or off
+	GzipLevel   int
+	BrotliLevel int
+	ReadOnly    bool // all non GET API routes are rejected
+	Metrics     bool // the Prometheus metrics are served on /metrics and requests are timed
+	Version     string
+	AdminToken  string // bearer token of the admin routes, they are disabled when empty
+	Token       string // token every API request must carry, no auth when empty
+	// AuthTokens are more tokens accepted like Token, AuthUsers are "name:bcrypt-hash" of basic auth users
+	AuthTokens []string
+	AuthUsers  []string
+	// AuthFile holds more tokens and users, read again on SIGHUP
+	AuthFile string
+	// AuthValidator accepts requests by credentials of an app embedding gol
+	AuthValidator AuthValidator
+	// Authenticator checks the credentials of all of the above, made by NewServer
+	Authenticator *Authenticator
+	// RequireAllSources keeps /readyz at 503 while any source is unreachable, instead of until one is reachable
+	RequireAllSources bool
+	// ShutdownTimeout is how long a graceful shutdown waits for the requests in flight to finish
+	ShutdownTimeout time.Duration
+	// ConfigReloader reloads the config file, nil when started without one
+	ConfigReloader *ConfigReloader
+	// ListenCallback is called with the address served on once listening, the port picked with port 0
+	ListenCallback func(addr net.Addr)
+	// CertFile and KeyFile serve HTTPS with a PEM certificate and key, TLSAuto with a self-signed one
+	CertFile string
+	KeyFile  string
+	TLSAuto  bool
+	// RedirectHTTP is the port of a listener redirecting to HTTPS, none when 0
+	RedirectHTTP int64
+	// RateLimit is the API requests per second of each address, in bursts of RateBurst, none when 0
+	RateLimit float64
+	RateBurst int
 }
 
 type EchoOption func(*EchoOptions) error
 
-func NewEcho(opts ...EchoOption) error {
+// WithListenCallback calls callback with the address the server listens on, once it does
+func WithListenCallback(callback func(addr net.Addr)) EchoOption {
+	return func(o *EchoOptions) error {
+		o.ListenCallback = callback
+		return nil
+	}
+}
+
+// DefaultShutdownTimeout is how long a graceful shutdown waits for the requests in flight by default
+const DefaultShutdownTimeout = 10 * time.Second
+
+// streamingRoutes never end on their own, they are ended when the server shuts down
+var streamingRoutes = []string{"api/tail", "api/stream", "api/events", "api/replay"}
+
+// Server is a configured gol server, started on its host and port with Start, or with Serve on a
+// listener of the caller. Port 0 picks a free port, told by Addr and the ListenCallback of the options.
+// Run and RunListener serve until their context is done and shut the server down gracefully. Routes
+// added to Echo before serving are served along with those of gol.
+type Server struct {
+	Echo    *echo.Echo
+	Options *EchoOptions
+
+	mutex sync.Mutex
+	addr  net.Addr
+	// certificate is served over TLS, nil for plain HTTP
+	certificate *Certificate
+	redirect    *http.Server
+
+	// closing is closed when the server shuts down, ending the streams
+	closing   chan struct{}
+	closeOnce sync.Once
+	// streams are the streaming requests in flight, WebSockets included, which the HTTP server forgets
+	// once upgraded
+	streams sync.WaitGroup
+}
+
+func NewServer(opts ...EchoOption) (*Server, error) {
 	options := &EchoOptions{
-		Cors:      0,
-		BaseURL:   "/",
-		Host:      "localhost", // default host
-		Port:      3000,        // default port
-		Access:    false,
-		PublicDir: nil,
+		Cors:        0,
+		BaseURL:     "/",
+		Host:        "localhost", // default host
+		Port:        3000,        // default port
+		Access:      false,
+		PublicDir:   nil,
+		Compression: CompressionGzip,
+		GzipLevel:   gzip.DefaultCompression,
+		BrotliLevel: brotli.DefaultCompression,
+		ReadOnly:    false,
+		Version:     "dev",
+
+		ShutdownTimeout: DefaultShutdownTimeout,
 	}
 	for _, opt := range opts {
 		err := opt(options)
 		if err != nil {
-			return err
+			return nil, err
 		}
 	}
+	certificate, err := options.loadCertificate()
+	if err != nil {
+		return nil, err
+	}
+	if options.Authenticator == nil {
+		if options.Authenticator, err = NewAuthenticator(options); err != nil {
+			return nil, err
+		}
+	}
+	if options.RedirectHTTP != 0 && certificate == nil {
+		return nil, errors.New("redirecting HTTP needs TLS, a certificate and key or TLS auto")
+	}
 	e := echo.New()
+	server := &Server{Echo: e, Options: options, closing: make(chan struct{}), certificate: certificate}
 
-	SetupMiddlewares(e)
+	SetupMiddlewares(e, options)
 	if options.Access {
 		e.Use(middleware.Logger())
 	}
+	e.Use(server.endStreams())
 	SetupRoutes(e, options)
 	SetupCors(e, options)
 
-	e.Logger.Fatal(e.Start(fmt.Sprintf("%s:%d", options.Host, options.Port)))
+	return server, nil
+}
+
+// loadCertificate loads or generates the certificate of the options, nil without TLS
+func (o *EchoOptions) loadCertificate() (*Certificate, error) {
+	switch {
+	case o.TLSAuto && (o.CertFile != "" || o.KeyFile != ""):
+		return nil, errors.New("TLS auto generates its certificate, it cannot be given a certificate or key")
+	case o.TLSAuto:
+		return SelfSignedCertificate(o.Host)
+	case o.CertFile != "" || o.KeyFile != "":
+		return LoadCertificate(o.CertFile, o.KeyFile)
+	}
+	return nil, nil
+}
+
+// Listen listens on the host and port of the options, port 0 picks a free one
+func (s *Server) Listen() (net.Listener, error) {
+	return net.Listen("tcp", net.JoinHostPort(s.Options.Host, strconv.FormatInt(s.Options.Port, 10)))
+}
+
+// Start serves on the host and port of the options until the server is shut down
+func (s *Server) Start() error {
+	listener, err := s.Listen()
+	if err != nil {
+		return err
+	}
+	return s.Serve(listener)
+}
+
+// Serve serves on listener until the server is shut down, the host and port of the options are ignored.
+// With TLS, the redirecting listener of the options is started along.
+func (s *Server) Serve(listener net.Listener) error {
+	if s.certificate != nil {
+		if err := s.startRedirect(listener.Addr()); err != nil {
+			listener.Close()
+			return err
+		}
+		listener = tls.NewListener(listener, s.certificate.TLSConfig())
+	}
+	s.mutex.Lock()
+	s.addr = listener.Addr()
+	s.mutex.Unlock()
+	if s.Options.ListenCallback != nil {
+		s.Options.ListenCallback(listener.Addr())
+	}
+	s.Echo.Listener = listener
+	return s.Echo.Start("")
+}
+
+// TLS tells whether the server serves HTTPS
+func (s *Server) TLS() bool {
+	return s.certificate != nil
+}
+
+// ReloadCertificate reads the certificate and key files again, new connections get the new certificate
+func (s *Server) ReloadCertificate() error {
+	if s.certificate == nil {
+		return nil
+	}
+	return s.certificate.Reload()
+}
+
+// ReloadAuth reads the auth file of the options again, new requests are checked against it
+func (s *Server) ReloadAuth() error {
+	return s.Options.Authenticator.Reload()
+}
+
+// startRedirect listens on the RedirectHTTP port of the options, redirecting to the port of addr
+func (s *Server) startRedirect(addr net.Addr) error {
+	if s.Options.RedirectHTTP == 0 {
+		return nil
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return fmt.Errorf("redirecting HTTP to %s, not a TCP address", addr)
+	}
+	listener, err := net.Listen("tcp", net.JoinHostPort(s.Options.Host, strconv.FormatInt(s.Options.RedirectHTTP, 10)))
+	if err != nil {
+		return fmt.Errorf("listening to redirect HTTP: %w", err)
+	}
+	redirect := &http.Server{Handler: RedirectToTLS(tcpAddr.Port), ReadHeaderTimeout: 10 * time.Second}
+	s.mutex.Lock()
+	s.redirect = redirect
+	s.mutex.Unlock()
+	slog.Info("redirecting HTTP to HTTPS", "addr", listener.Addr(), "port", tcpAddr.Port)
+	go func() {
+		if err := redirect.Serve(listener); !errors.Is(err, http.ErrServerClosed) {
+			slog.Error("redirecting HTTP", "error", err)
+		}
+	}()
 	return nil
 }
 
-func SetupMiddlewares(e *echo.Echo) {
+// Addr is the address served on, nil until serving
+func (s *Server) Addr() net.Addr {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.addr
+}
+
+// Run serves on the host and port of the options until ctx is done, then shuts down gracefully
+func (s *Server) Run(ctx context.Context) error {
+	return s.run(ctx, s.Start)
+}
+
+// RunListener serves on listener until ctx is done, then shuts down gracefully
+func (s *Server) RunListener(ctx context.Context, listener net.Listener) error {
+	return s.run(ctx, func() error { return s.Serve(listener) })
+}
+
+func (s *Server) run(ctx context.Context, serve func() error) error {
+	served := make(chan error, 1)
+	go func() {
+		served <- serve()
+	}()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+
+	slog.Info("shutting down, waiting for the requests in flight", "timeout", s.Options.ShutdownTimeout)
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), s.Options.ShutdownTimeout)
+	defer cancel()
+	if err := s.Shutdown(shutdownCtx); err != nil {
+		return err
+	}
+	if err := <-served; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
+	return nil
+}
+
+// Shutdown ends the streams, stops accepting connections and waits for the requests in flight until
+// ctx is done
+func (s *Server) Shutdown(ctx context.Context) error {
+	s.closeOnce.Do(func() { close(s.closing) })
+	s.mutex.Lock()
+	redirect := s.redirect
+	s.mutex.Unlock()
+	if redirect != nil {
+		if err := redirect.Shutdown(ctx); err != nil {
+			return err
+		}
+	}
+	if err := s.Echo.Shutdown(ctx); err != nil {
+		return err
+	}
+	streamsDone := make(chan struct{})
+	go func() {
+		s.streams.Wait()
+		close(streamsDone)
+	}()
+	select {
+	case <-streamsDone:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// endStreams cancels the context of the streaming requests when the server shuts down, they end as
+// their client went away
+func (s *Server) endStreams() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !slices.Contains(streamingRoutes, strings.TrimPrefix(c.Path(), s.Options.BaseURL)) {
+				return next(c)
+			}
+			select {
+			case <-s.closing:
+				return echo.NewHTTPError(http.StatusServiceUnavailable, ErrorCodeShuttingDown)
+			default:
+			}
+			s.streams.Add(1)
+			defer s.streams.Done()
+			ctx, cancel := context.WithCancel(c.Request().Context())
+			defer cancel()
+			go func() {
+				select {
+				case <-s.closing:
+					cancel()
+				case <-ctx.Done():
+				}
+			}()
+			c.SetRequest(c.Request().WithContext(ctx))
+			return next(c)
+		}
+	}
+}
+
+// NewEcho builds the server and serves until ctx is done or the process gets SIGINT or SIGTERM, then
+// shuts down gracefully
+func NewEcho(ctx context.Context, opts ...EchoOption) error {
+	server, err := NewServer(opts...)
+	if err != nil {
+		return err
+	}
+	ctx, stop := signal.NotifyContext(ctx, os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	return server.Run(ctx)
+}
+
+func SetupMiddlewares(e *echo.Echo, options *EchoOptions) {
 	e.HTTPErrorHandler = HTTPErrorHandler
 	e.Use(middleware.Recover())
-	e.Use(middleware.Gzip())
+	if options.Metrics {
+		e.Use(GlobalMetrics.Middleware())
+	}
+	e.Use(RateLimit(options))
+	e.Use(Compress(options))
+	e.Use(Auth(options))
+	e.Use(ReadOnly(options))
 	e.Pre(middleware.RemoveTrailingSlash())
 	e.Use(middleware.LoggerWithConfig(middleware.LoggerConfig{
 		Format: ltsv(),
@@ -61,9 +371,51 @@ func SetupMiddlewares(e *echo.Echo) {
 }
 
 func SetupRoutes(e *echo.Echo, options *EchoOptions) {
-	e.GET(options.BaseURL+"", NewAssetsHandler(options.PublicDir, "dist", "index.html").Get)
-	e.GET(options.BaseURL+"favicon.ico", NewAssetsHandler(options.PublicDir, "dist", "favicon.ico").GetICO)
+	if HasUI(options.PublicDir, "dist") {
+		e.GET(options.BaseURL+"", NewAssetsHandler(options.PublicDir, "dist", "index.html").Get)
+		e.GET(options.BaseURL+"favicon.ico", NewAssetsHandler(options.PublicDir, "dist", "favicon.ico").GetICO)
+	} else {
+		e.GET(options.BaseURL+"", NewStatusHandler(options).Get)
+	}
 	e.GET(options.BaseURL+"api", NewAPIHandler().Get)
+	e.GET(options.BaseURL+"api/search", NewAPIHandler().GetSearch)
+	e.GET(options.BaseURL+"api/bytes", NewAPIHandler().GetBytes)
+	e.GET(options.BaseURL+"api/files", NewAPIHandler().GetFiles)
+	e.GET(options.BaseURL+"api/anchor", NewAPIHandler().GetAnchor)
+	e.GET(options.BaseURL+"api/tail", NewAPIHandler().GetTail)
+	e.GET(options.BaseURL+"api/stream", NewAPIHandler().GetStream)
+	e.GET(options.BaseURL+"api/merge", NewAPIHandler().GetMerge)
+	e.GET(options.BaseURL+"api/line", NewAPIHandler().GetLine)
+	e.GET(options.BaseURL+"api/context", NewAPIHandler().GetContext)
+	e.GET(options.BaseURL+"api/alerts/status", NewAPIHandler().GetAlertsStatus)
+	e.GET(options.BaseURL+"api/metrics", NewAPIHandler().GetMetrics)
+	e.GET(options.BaseURL+"api/sources", NewAPIHandler().GetSources)
+	e.GET(options.BaseURL+"api/jobs", NewAPIHandler().GetJobs)
+	e.GET(options.BaseURL+"api/events", NewAPIHandler().GetEvents)
+	e.GET(options.BaseURL+"api/replay", NewAPIHandler().GetReplay)
+	e.GET(options.BaseURL+"api/diff", NewAPIHandler().GetDiff)
+	e.GET(options.BaseURL+"api/histogram", NewAPIHandler().GetHistogram)
+	e.GET(options.BaseURL+"api/download", NewAPIHandler().GetDownload)
+	e.GET(options.BaseURL+"api/exports/:id", NewAPIHandler().GetExport)
+	e.GET(options.BaseURL+"api/shares/:id", NewAPIHandler().GetShare)
+	e.GET(options.BaseURL+"api/self-report", NewAPIHandler().GetSelfReport)
+	e.GET(options.BaseURL+"api/version", NewVersionHandler(options).Get)
+	e.GET(options.BaseURL+"api/capabilities", NewCapabilitiesHandler(options).Get)
+	e.GET(options.BaseURL+"api/openapi.json", NewOpenAPIHandler(options).Get)
+	e.GET(options.BaseURL+"api/healthz/deep", NewAdminHandler(options).GetDeepHealth)
+	// outside of api, the probes of orchestrators never carry the token
+	e.GET(options.BaseURL+"healthz", NewHealthHandler(options).GetHealthz)
+	e.GET(options.BaseURL+"readyz", NewHealthHandler(options).GetReadyz)
+	e.POST(options.BaseURL+"api/admin/reload", NewAdminHandler(options).PostReload)
+	e.POST(options.BaseURL+"api/files/hide", NewAdminHandler(options).PostHideFile)
+	e.POST(options.BaseURL+"api/files/pin", NewAdminHandler(options).PostPinFile)
+	e.POST(options.BaseURL+"api/shares", NewAPIHandler().PostShare)
+	e.DELETE(options.BaseURL+"api/jobs/:id", NewAdminHandler(options).DeleteJob)
+	e.POST(options.BaseURL+"api/replay/pause", NewAdminHandler(options).PostReplayPause)
+	e.POST(options.BaseURL+"api/replay/resume", NewAdminHandler(options).PostReplayResume)
+	if options.Metrics {
+		e.GET(options.BaseURL+"metrics", echo.WrapHandler(GlobalMetrics.Handler()))
+	}
 }
 
 func SetupCors(e *echo.Echo, options *EchoOptions) {
@@ -76,6 +428,22 @@ func SetupCors(e *echo.Echo, options *EchoOptions) {
 	}))
 }
 
+// ReadOnly is a middleware rejecting every API request that could change server state
+func ReadOnly(options *EchoOptions) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !options.ReadOnly || !strings.HasPrefix(c.Request().URL.Path, options.BaseURL+"api") {
+				return next(c)
+			}
+			switch c.Request().Method {
+			case http.MethodGet, http.MethodHead, http.MethodOptions:
+				return next(c)
+			}
+			return echo.NewHTTPError(http.StatusMethodNotAllowed, ErrorCodeReadOnly)
+		}
+	}
+}
+
 // HTTPErrorResponse is the response for HTTP errors
 type HTTPErrorResponse struct {
 	Error interface{} `json:"error"`
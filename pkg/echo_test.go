@@ -0,0 +1,214 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"io/fs"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func newTestEcho(options *EchoOptions) *echo.Echo {
+	e := echo.New()
+	SetupMiddlewares(e, options)
+	SetupRoutes(e, options)
+	return e
+}
+
+func TestReadOnly(t *testing.T) {
+	options := &EchoOptions{BaseURL: "/", ReadOnly: true, Version: "v1.2.3", Compression: CompressionOff}
+	e := newTestEcho(options)
+	// stands in for every mutating route, whichever get registered later
+	e.POST(options.BaseURL+"api/example", func(c echo.Context) error {
+		return c.NoContent(http.StatusCreated)
+	})
+
+	mutating := 0
+	for _, route := range e.Routes() {
+		if route.Method == http.MethodGet || !strings.HasPrefix(route.Path, options.BaseURL+"api") {
+			continue
+		}
+		mutating++
+		req := httptest.NewRequest(route.Method, route.Path, nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		assert.Equal(t, http.StatusMethodNotAllowed, rec.Code, route.Method+" "+route.Path)
+		assert.Contains(t, rec.Body.String(), ErrorCodeReadOnly)
+	}
+	assert.Positive(t, mutating)
+
+	// reads still work
+	req := httptest.NewRequest(http.MethodGet, "/api/version", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var version VersionResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &version))
+	assert.Equal(t, "v1.2.3", version.Version)
+	assert.Equal(t, APIVersion, version.APIVersion)
+	assert.Equal(t, VersionCapabilities{ReadOnly: true, Mutations: false}, version.Capabilities)
+	assert.Equal(t, GlobalMemory.Usage().Ceiling, version.Memory.Ceiling)
+
+	// not read only
+	options.ReadOnly = false
+	req = httptest.NewRequest(http.MethodPost, "/api/example", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusCreated, rec.Code)
+}
+
+func TestSetupRoutes_UI(t *testing.T) {
+	get := func(options *EchoOptions, url string) *httptest.ResponseRecorder {
+		e := newTestEcho(options)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, url, nil))
+		return rec
+	}
+
+	ui := fstest.MapFS{
+		"dist/index.html":  {Data: []byte("<html>frontend</html>")},
+		"dist/favicon.ico": {Data: []byte("icon")},
+	}
+	options := &EchoOptions{BaseURL: "/", Version: "v1.2.3", Compression: CompressionOff, PublicDir: ui}
+	assert.Equal(t, "<html>frontend</html>", get(options, "/").Body.String())
+	assert.Equal(t, http.StatusOK, get(options, "/favicon.ico").Code)
+
+	// API only: without a frontend, or an empty one, the status page lists the API
+	for _, publicDir := range []fs.FS{nil, fstest.MapFS{}} {
+		options.PublicDir = publicDir
+		rec := get(options, "/")
+		assert.Equal(t, http.StatusOK, rec.Code)
+		assert.Contains(t, rec.Body.String(), "gol v1.2.3")
+		assert.Contains(t, rec.Body.String(), "<code>GET /api/files</code>")
+		assert.Equal(t, http.StatusNotFound, get(options, "/favicon.ico").Code)
+	}
+}
+
+func TestServer_RunListener(t *testing.T) {
+	server, err := NewServer(func(o *EchoOptions) error {
+		o.Compression = CompressionOff
+		o.ShutdownTimeout = 5 * time.Second
+		return nil
+	})
+	assert.NoError(t, err)
+	server.Echo.HideBanner = true
+	server.Echo.HidePort = true
+	started := make(chan struct{})
+	server.Echo.GET("/slow", func(c echo.Context) error {
+		close(started)
+		time.Sleep(200 * time.Millisecond)
+		return c.String(http.StatusOK, "done")
+	})
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	url := "http://" + listener.Addr().String()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	ran := make(chan error, 1)
+	go func() {
+		ran <- server.RunListener(ctx, listener)
+	}()
+
+	// streams never end on their own
+	events, err := http.Get(url + "/api/events")
+	assert.NoError(t, err)
+	defer events.Body.Close()
+	assert.Equal(t, http.StatusOK, events.StatusCode)
+	type slowResponse struct {
+		code int
+		body string
+		err  error
+	}
+	slow := make(chan slowResponse, 1)
+	go func() {
+		res, err := http.Get(url + "/slow")
+		if err != nil {
+			slow <- slowResponse{err: err}
+			return
+		}
+		defer res.Body.Close()
+		body, err := io.ReadAll(res.Body)
+		slow <- slowResponse{code: res.StatusCode, body: string(body), err: err}
+	}()
+	<-started
+	cancel()
+
+	// the request in flight completes, the stream is ended and Run returns once both are done
+	res := <-slow
+	assert.NoError(t, res.err)
+	assert.Equal(t, http.StatusOK, res.code)
+	assert.Equal(t, "done", res.body)
+	_, err = io.ReadAll(events.Body)
+	assert.NoError(t, err)
+	select {
+	case err := <-ran:
+		assert.NoError(t, err)
+	case <-time.After(5 * time.Second):
+		t.Fatal("the server did not shut down")
+	}
+	_, err = http.Get(url + "/slow")
+	assert.Error(t, err)
+}
+
+func TestServer_PortZero(t *testing.T) {
+	type started struct {
+		server *Server
+		addr   net.Addr
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make(chan error, 2)
+	listening := make(chan started, 2)
+	for range 2 {
+		go func() {
+			addrs := make(chan net.Addr, 1)
+			server, err := NewServer(func(o *EchoOptions) error {
+				o.Host = "127.0.0.1"
+				o.Port = 0
+				o.Compression = CompressionOff
+				return nil
+			}, WithListenCallback(func(addr net.Addr) { addrs <- addr }))
+			if err != nil {
+				ran <- err
+				return
+			}
+			server.Echo.HideBanner = true
+			server.Echo.HidePort = true
+			// routes of the caller are served along with those of gol
+			server.Echo.GET("/extra", func(c echo.Context) error {
+				return c.String(http.StatusOK, "extra")
+			})
+			go func() {
+				listening <- started{server: server, addr: <-addrs}
+			}()
+			ran <- server.Run(ctx)
+		}()
+	}
+
+	ports := map[int]bool{}
+	for range 2 {
+		s := <-listening
+		assert.Equal(t, s.addr, s.server.Addr())
+		port := s.addr.(*net.TCPAddr).Port
+		assert.NotZero(t, port)
+		ports[port] = true
+		res, err := http.Get("http://" + s.addr.String() + "/extra")
+		if assert.NoError(t, err) {
+			body, _ := io.ReadAll(res.Body)
+			res.Body.Close()
+			assert.Equal(t, "extra", string(body))
+		}
+	}
+	assert.Len(t, ports, 2)
+	cancel()
+	assert.NoError(t, <-ran)
+	assert.NoError(t, <-ran)
+}
@@ -0,0 +1,195 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+	"golang.org/x/text/transform"
+)
+
+const (
+	EncodingUTF8     = "utf-8"
+	EncodingUTF16LE  = "utf-16le"
+	EncodingUTF16BE  = "utf-16be"
+	EncodingLatin1   = "latin-1"
+	EncodingShiftJIS = "shift-jis"
+
+	// CharsetAuto detects the encoding of each file from its first bytes
+	CharsetAuto = "auto"
+)
+
+// ParseCharset parses a -charset, auto or one of the encodings, under its usual names
+func ParseCharset(name string) (string, error) {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "", CharsetAuto:
+		return CharsetAuto, nil
+	case EncodingUTF8, "utf8":
+		return EncodingUTF8, nil
+	case EncodingLatin1, "latin1", "iso-8859-1", "iso8859-1", "windows-1252", "cp1252":
+		return EncodingLatin1, nil
+	case EncodingShiftJIS, "shift_jis", "sjis", "cp932":
+		return EncodingShiftJIS, nil
+	}
+	return "", fmt.Errorf("charset %q is not one of auto, utf-8, latin-1, shift-jis", name)
+}
+
+// DetectEncoding is the encoding of a file starting with buffer: that of its BOM, else GlobalCharset
+// unless it is auto, else UTF-16 as DetectUTF16 sniffs it, else Shift-JIS or Latin-1 when the bytes are not
+// UTF-8 but read as either. Other bytes are UTF-8, invalid sequences are replaced as the lines are returned.
+func DetectEncoding(buffer []byte) string {
+	switch {
+	case bytes.HasPrefix(buffer, []byte{0xef, 0xbb, 0xbf}):
+		return EncodingUTF8
+	case bytes.HasPrefix(buffer, []byte{0xff, 0xfe}), bytes.HasPrefix(buffer, []byte{0xfe, 0xff}):
+		return DetectUTF16(buffer)
+	case GlobalCharset != CharsetAuto && GlobalCharset != "":
+		return GlobalCharset
+	}
+	if encoding := DetectUTF16(buffer); encoding != EncodingUTF8 {
+		return encoding
+	}
+	return detectLegacyCharset(buffer)
+}
+
+// detectLegacyCharset tells Shift-JIS and Latin-1 text apart from UTF-8, which any valid multibyte
+// sequence is taken for: Shift-JIS when the bytes are all pairs and katakana of it, with a pair led by
+// 0x81-0x9f which Latin-1 has no letters at, else Latin-1 when they hold no control characters and
+// mostly ASCII, as text in a Latin alphabet does
+func detectLegacyCharset(buffer []byte) string {
+	buffer = trimPartialRune(buffer)
+	if utf8.Valid(buffer) {
+		return EncodingUTF8
+	}
+	multibyte, controls, high := 0, 0, 0
+	shiftJIS, shiftJISPairs := true, 0
+	for i := 0; i < len(buffer); i++ {
+		b := buffer[i]
+		if b >= utf8.RuneSelf {
+			high++
+		}
+		if r, size := utf8.DecodeRune(buffer[i:]); size > 1 && r != utf8.RuneError {
+			multibyte++
+		}
+		if isControlByte(b) && b != '\r' && b != '\f' && b != 0x1b {
+			controls++
+		}
+		switch {
+		case !shiftJIS || b < 0x80 || (b >= 0xa1 && b <= 0xdf):
+		case (b >= 0x81 && b <= 0x9f) || (b >= 0xe0 && b <= 0xfc):
+			if i+1 == len(buffer) {
+				break
+			}
+			if trail := buffer[i+1]; trail < 0x40 || trail == 0x7f || trail > 0xfc {
+				shiftJIS = false
+				break
+			}
+			if b <= 0x9f {
+				shiftJISPairs++
+			}
+			i++
+		default:
+			shiftJIS = false
+		}
+	}
+	switch {
+	case multibyte > 0 || controls > 0:
+		return EncodingUTF8
+	case shiftJIS && shiftJISPairs > 0:
+		return EncodingShiftJIS
+	case isBinaryShare(high, len(buffer)):
+		return EncodingUTF8
+	}
+	return EncodingLatin1
+}
+
+// trimPartialRune drops the start of a character b ends with, cut in the middle of it
+func trimPartialRune(b []byte) []byte {
+	for i := len(b) - 1; i >= 0 && i >= len(b)-utf8.UTFMax; i-- {
+		if utf8.RuneStart(b[i]) {
+			if !utf8.FullRune(b[i:]) {
+				return b[:i]
+			}
+			break
+		}
+	}
+	return b
+}
+
+// DetectUTF16 sniffs the first bytes of a file for UTF-16, by BOM or else by the position of NUL bytes
+// (ASCII text encoded as UTF-16 has every other byte NUL). Returns EncodingUTF8 when it is not UTF-16.
+func DetectUTF16(buffer []byte) string {
+	switch {
+	case bytes.HasPrefix(buffer, []byte{0xff, 0xfe}):
+		return EncodingUTF16LE
+	case bytes.HasPrefix(buffer, []byte{0xfe, 0xff}):
+		return EncodingUTF16BE
+	}
+
+	pairs := len(buffer) / 2
+	if pairs < 2 {
+		return EncodingUTF8
+	}
+	evenNUL, oddNUL := 0, 0
+	for i := 0; i+1 < len(buffer); i += 2 {
+		if buffer[i] == 0 {
+			evenNUL++
+		}
+		if buffer[i+1] == 0 {
+			oddNUL++
+		}
+	}
+	// more than 40% of code units look like ASCII in UTF-16 and the other half is not NUL
+	switch {
+	case oddNUL*10 > pairs*4 && evenNUL*10 < pairs:
+		return EncodingUTF16LE
+	case evenNUL*10 > pairs*4 && oddNUL*10 < pairs:
+		return EncodingUTF16BE
+	}
+	return EncodingUTF8
+}
+
+// NewUTF8Reader transcodes r to UTF-8 when encoding is UTF-16, Latin-1 or Shift-JIS, otherwise returns r
+// as is. Latin-1 is read as Windows-1252 like browsers do, which only has letters where it has controls.
+func NewUTF8Reader(r io.Reader, encoding string) io.Reader {
+	switch encoding {
+	case EncodingLatin1:
+		return transform.NewReader(r, charmap.Windows1252.NewDecoder())
+	case EncodingShiftJIS:
+		return transform.NewReader(r, japanese.ShiftJIS.NewDecoder())
+	case EncodingUTF16LE:
+		return transform.NewReader(r, unicode.UTF16(unicode.LittleEndian, unicode.UseBOM).NewDecoder())
+	case EncodingUTF16BE:
+		return transform.NewReader(r, unicode.UTF16(unicode.BigEndian, unicode.UseBOM).NewDecoder())
+	}
+	return r
+}
+
+// transcodeBytes is NewUTF8Reader for an in memory buffer
+func transcodeBytes(b []byte, encoding string) []byte {
+	if encoding == EncodingUTF8 {
+		return b
+	}
+	out, err := io.ReadAll(NewUTF8Reader(bytes.NewReader(b), encoding))
+	if err != nil {
+		return b
+	}
+	return out
+}
+
+// utf8BufferedReader sniffs r and transparently transcodes content of another encoding to UTF-8
+func utf8BufferedReader(r io.Reader) *bufio.Reader {
+	br := bufio.NewReader(r)
+	peek, _ := br.Peek(512)
+	encoding := DetectEncoding(peek)
+	if encoding == EncodingUTF8 {
+		return br
+	}
+	return bufio.NewReader(NewUTF8Reader(br, encoding))
+}
@@ -0,0 +1,156 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+func TestDetectUTF16(t *testing.T) {
+	tests := []struct {
+		name   string
+		buffer []byte
+		want   string
+	}{
+		{"utf-8", []byte("hello, world"), EncodingUTF8},
+		{"le bom", []byte{0xff, 0xfe, 'h', 0}, EncodingUTF16LE},
+		{"be bom", []byte{0xfe, 0xff, 0, 'h'}, EncodingUTF16BE},
+		{"le without bom", []byte{'h', 0, 'e', 0, 'l', 0, 'l', 0, 'o', 0}, EncodingUTF16LE},
+		{"be without bom", []byte{0, 'h', 0, 'e', 0, 'l', 0, 'l', 0, 'o'}, EncodingUTF16BE},
+		{"binary zeros", make([]byte, 64), EncodingUTF8},
+		{"empty", []byte{}, EncodingUTF8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, DetectUTF16(tt.buffer))
+		})
+	}
+}
+
+func TestUTF16Files(t *testing.T) {
+	dir := t.TempDir()
+	content := "2024-06-01 10:00:00 INFO w3svc started\r\n2024-06-01 10:00:01 ERROR request failed\r\n2024-06-01 10:00:02 INFO done\r\n"
+
+	fixtures := map[string]unicode.Endianness{
+		"iis-utf16le.log": unicode.LittleEndian,
+		"iis-utf16be.log": unicode.BigEndian,
+	}
+	for name, endianness := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := unicode.UTF16(endianness, unicode.UseBOM).NewEncoder().String(content)
+			assert.NoError(t, err)
+			logFile := filepath.Join(dir, name)
+			assert.NoError(t, os.WriteFile(logFile, []byte(encoded), 0600))
+
+			readable, err := IsReadableFile(logFile, false, nil, true)
+			assert.NoError(t, err)
+			assert.True(t, readable)
+
+			linesCount, _, err := FileStats(logFile, false, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, 3, linesCount)
+
+			watcher, err := NewWatcher(logFile, "ERROR", "", false, "", "", "", "", "")
+			assert.NoError(t, err)
+			result, err := watcher.Scan(1, 10, false)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, result.Total)
+			assert.Equal(t, "2024-06-01 10:00:01 ERROR request failed", result.Lines[0].Content)
+			assert.Equal(t, 2, result.Lines[0].LineNumber)
+		})
+	}
+}
+
+func TestDetectEncoding(t *testing.T) {
+	shiftJIS, err := japanese.ShiftJIS.NewEncoder().String("2024-06-01 ERROR 接続に失敗しました")
+	assert.NoError(t, err)
+	latin1, err := charmap.Windows1252.NewEncoder().String("2024-06-01 ERROR Müller: échec de la connexion")
+	assert.NoError(t, err)
+	tests := []struct {
+		name   string
+		buffer []byte
+		want   string
+	}{
+		{"utf-8", []byte("2024-06-01 ERROR Müller"), EncodingUTF8},
+		{"utf-8 cut in a character", []byte("ERROR Müller")[:8], EncodingUTF8},
+		{"utf-8 bom", append([]byte{0xef, 0xbb, 0xbf}, latin1...), EncodingUTF8},
+		{"utf-16 bom", []byte{0xff, 0xfe, 'h', 0}, EncodingUTF16LE},
+		{"shift-jis", []byte(shiftJIS), EncodingShiftJIS},
+		{"latin-1", []byte(latin1), EncodingLatin1},
+		{"utf-8 with a raw byte", []byte("ERROR Müller \xff"), EncodingUTF8},
+		{"nul runs", []byte("INSERT INTO t VALUES ('\x00\x00\xff\xfe')"), EncodingUTF8},
+		{"high bytes", []byte{0x80, 0x81, 0x82, 0x83}, EncodingUTF8},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Equal(t, tt.want, DetectEncoding(tt.buffer))
+		})
+	}
+
+	// -charset applies to the files without a BOM
+	defer func(charset string) { GlobalCharset = charset }(GlobalCharset)
+	GlobalCharset = EncodingLatin1
+	assert.Equal(t, EncodingLatin1, DetectEncoding([]byte(shiftJIS)))
+	assert.Equal(t, EncodingUTF16BE, DetectEncoding([]byte{0xfe, 0xff, 0, 'h'}))
+
+	for name, want := range map[string]string{"": CharsetAuto, "UTF8": EncodingUTF8, "iso-8859-1": EncodingLatin1, "sjis": EncodingShiftJIS} {
+		charset, err := ParseCharset(name)
+		assert.NoError(t, err)
+		assert.Equal(t, want, charset)
+	}
+	_, err = ParseCharset("ebcdic")
+	assert.Error(t, err)
+}
+
+func TestLegacyCharsetFiles(t *testing.T) {
+	dir := t.TempDir()
+	lines := []string{"2024-06-01 10:00:00 INFO démarrage", "2024-06-01 10:00:01 ERROR échec: ünïcödé", "2024-06-01 10:00:02 INFO terminé"}
+	japaneseLines := []string{"2024-06-01 10:00:00 INFO 起動しました", "2024-06-01 10:00:01 ERROR 接続に失敗しました", "2024-06-01 10:00:02 INFO 完了"}
+	fixtures := map[string]struct {
+		encoder *encoding.Encoder
+		lines   []string
+	}{
+		"latin1.log":   {charmap.Windows1252.NewEncoder(), lines},
+		"shiftjis.log": {japanese.ShiftJIS.NewEncoder(), japaneseLines},
+	}
+	for name, fixture := range fixtures {
+		t.Run(name, func(t *testing.T) {
+			encoded, err := fixture.encoder.String(strings.Join(fixture.lines, "\n") + "\n")
+			assert.NoError(t, err)
+			logFile := filepath.Join(dir, name)
+			assert.NoError(t, os.WriteFile(logFile, []byte(encoded), 0600))
+
+			readable, err := IsReadableFile(logFile, false, nil, true)
+			assert.NoError(t, err)
+			assert.True(t, readable)
+
+			watcher, err := NewWatcher(logFile, "ERROR", "", false, "", "", "", "", "")
+			assert.NoError(t, err)
+			result, err := watcher.Scan(1, 10, false)
+			assert.NoError(t, err)
+			assert.Equal(t, 1, result.Total)
+			assert.Equal(t, fixture.lines[1], result.Lines[0].Content)
+			assert.False(t, result.Lines[0].Binary)
+
+			tail, err := ReadTailLines(logFile, 1, false, nil)
+			assert.NoError(t, err)
+			assert.Equal(t, fixture.lines[2:], tail)
+
+			// appended lines are streamed transcoded too
+			tailer, err := NewTailer(logFile, true)
+			assert.NoError(t, err)
+			defer tailer.Close()
+			events, err := tailer.Poll()
+			assert.NoError(t, err)
+			assert.Len(t, events, 3)
+			assert.Equal(t, fixture.lines[0], events[0].Content)
+		})
+	}
+}
@@ -0,0 +1,246 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	// JobKindExport is an export spooled to the data dir before it is served
+	JobKindExport = "export"
+
+	// HeaderExportID carries the ID of a spooled export, GET api/exports/:id serves it again
+	HeaderExportID = "X-Gol-Export-ID"
+
+	// DefaultExportRetention is how long spooled exports are kept for resumed downloads
+	DefaultExportRetention = 24 * time.Hour
+
+	exportsDirName        = "exports"
+	exportProgressEvery   = 1 << 20
+	exportWriteBufferSize = 64 << 10
+)
+
+var ErrNoDataDir = errors.New("exports are spooled to the data dir, none is set")
+
+// Export is an artifact spooled to the data dir, served with Range support under its job ID
+type Export struct {
+	ID          string
+	FilePath    string
+	FileName    string
+	ContentType string
+}
+
+// Exports keeps the spooled exports, so that an interrupted download resumes with a Range request
+// instead of generating the export again. The exports are expired after the retention, and oldest
+// first while the data dir is under disk pressure.
+type Exports struct {
+	mutex     sync.Mutex
+	retention time.Duration
+	exports   map[string]Export
+	// latest is the ID of the last export of each request, inFlight the exports being spooled
+	latest   map[string]string
+	inFlight map[string]*exportFlight
+}
+
+type exportFlight struct {
+	done   chan struct{}
+	export Export
+	err    error
+}
+
+func NewExports(retention time.Duration) *Exports {
+	return &Exports{
+		retention: retention,
+		exports:   map[string]Export{},
+		latest:    map[string]string{},
+		inFlight:  map[string]*exportFlight{},
+	}
+}
+
+// ExportsDir is where the exports are spooled
+func ExportsDir() string {
+	return filepath.Join(GlobalDataDir, exportsDirName)
+}
+
+// Spool writes the export of the request key with write, under the ID of an export job, and returns
+// it once written. A request of the same key while it is spooled waits for it and gets the same export.
+func (e *Exports) Spool(ctx context.Context, key string, target string, fileName string, contentType string, write func(ctx context.Context, w io.Writer) error) (Export, error) {
+	flight, err := e.begin(ctx, key, target, fileName, contentType, write)
+	if err != nil {
+		return Export{}, err
+	}
+	select {
+	case <-flight.done:
+		return flight.export, flight.err
+	case <-ctx.Done():
+		return Export{}, ctx.Err()
+	}
+}
+
+// SpoolAsync starts the export of the request key as Spool does, but returns its ID at once. It is
+// written in the background, until its job is done or canceled, and GET api/exports/:id serves it after.
+func (e *Exports) SpoolAsync(key string, target string, fileName string, contentType string, write func(ctx context.Context, w io.Writer) error) (string, error) {
+	flight, err := e.begin(context.Background(), key, target, fileName, contentType, write)
+	if err != nil {
+		return "", err
+	}
+	return flight.export.ID, nil
+}
+
+// begin starts writing the export of the request key under a new export job, or joins the export of
+// the key being written
+func (e *Exports) begin(ctx context.Context, key string, target string, fileName string, contentType string, write func(ctx context.Context, w io.Writer) error) (*exportFlight, error) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	if flight, spooling := e.inFlight[key]; spooling {
+		return flight, nil
+	}
+	if GlobalDataDir == "" {
+		return nil, ErrNoDataDir
+	}
+	dir := ExportsDir()
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	if err := EnsureFreeDisk(dir, 0); err != nil {
+		return nil, err
+	}
+	ctx, job := GlobalJobs.Start(ctx, JobKindExport, target, 0, true)
+	// the file being spooled is never expired
+	flight := &exportFlight{
+		done: make(chan struct{}),
+		export: Export{
+			ID:          job.ID(),
+			FilePath:    filepath.Join(dir, job.ID()+filepath.Ext(fileName)),
+			FileName:    fileName,
+			ContentType: contentType,
+		},
+	}
+	e.inFlight[key] = flight
+
+	go func() {
+		err := writeExport(ctx, flight.export.FilePath, job, write)
+		e.mutex.Lock()
+		flight.err = err
+		delete(e.inFlight, key)
+		if err == nil {
+			e.exports[flight.export.ID] = flight.export
+			e.latest[key] = flight.export.ID
+		}
+		e.mutex.Unlock()
+		// served once its job is done, a client polling the job gets it at once
+		job.Finish(err)
+		close(flight.done)
+	}()
+	return flight, nil
+}
+
+func writeExport(ctx context.Context, filePath string, job *JobTracker, write func(ctx context.Context, w io.Writer) error) error {
+	file, err := os.Create(filePath)
+	if err != nil {
+		return err
+	}
+	buffered := bufio.NewWriterSize(&progressWriter{writer: file, job: job}, exportWriteBufferSize)
+	err = write(ctx, buffered)
+	if err == nil {
+		err = buffered.Flush()
+	}
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(filePath) //nolint: errcheck
+	}
+	return err
+}
+
+// progressWriter reports the bytes written to the job every exportProgressEvery bytes
+type progressWriter struct {
+	writer   io.Writer
+	job      *JobTracker
+	written  int64
+	reported int64
+}
+
+func (w *progressWriter) Write(p []byte) (int, error) {
+	n, err := w.writer.Write(p)
+	w.written += int64(n)
+	if w.written-w.reported >= exportProgressEvery {
+		w.reported = w.written
+		w.job.Progress(w.written)
+	}
+	return n, err
+}
+
+func (e *Exports) Get(id string) (Export, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	export, ok := e.exports[id]
+	return export, ok
+}
+
+// Latest returns the last export of the request key while it is kept
+func (e *Exports) Latest(key string) (Export, bool) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	export, ok := e.exports[e.latest[key]]
+	return export, ok
+}
+
+// Expire removes the exports older than the retention, and the oldest ones while the data dir is
+// under disk pressure. Files left in the exports dir by an earlier run are expired the same way.
+func (e *Exports) Expire() []string {
+	entries, err := os.ReadDir(ExportsDir())
+	if err != nil || GlobalDataDir == "" {
+		return nil
+	}
+	modTimes := map[string]time.Time{}
+	filePaths := []string{}
+	for _, entry := range entries {
+		info, err := entry.Info()
+		if err != nil || entry.IsDir() {
+			continue
+		}
+		filePath := filepath.Join(ExportsDir(), entry.Name())
+		modTimes[filePath] = info.ModTime()
+		filePaths = append(filePaths, filePath)
+	}
+	sort.SliceStable(filePaths, func(i, j int) bool { return modTimes[filePaths[i]].Before(modTimes[filePaths[j]]) })
+
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	spooling := map[string]bool{}
+	for _, flight := range e.inFlight {
+		spooling[flight.export.FilePath] = true
+	}
+	pressure := diskUsage(GlobalDataDir, 0).Pressure
+	now := GlobalClock.Now()
+	expired := []string{}
+	for _, filePath := range filePaths {
+		if spooling[filePath] || (!pressure && now.Sub(modTimes[filePath]) < e.retention) {
+			continue
+		}
+		if err := os.Remove(filePath); err != nil {
+			continue
+		}
+		expired = append(expired, filePath)
+		for id, export := range e.exports {
+			if export.FilePath == filePath {
+				delete(e.exports, id)
+			}
+		}
+		if pressure {
+			if _, free, err := diskSpace(GlobalDataDir); err != nil || free >= 2*GlobalMinFreeDisk {
+				pressure = false
+			}
+		}
+	}
+	return expired
+}
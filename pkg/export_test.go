@@ -0,0 +1,212 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func useExports(t *testing.T) {
+	dataDir, exports := GlobalDataDir, GlobalExports
+	t.Cleanup(func() {
+		GlobalDataDir, GlobalExports = dataDir, exports
+	})
+	GlobalDataDir = t.TempDir()
+	GlobalExports = NewExports(DefaultExportRetention)
+}
+
+func TestAPIHandler_GetDownload(t *testing.T) {
+	content := ""
+	for i := 1; i <= 100; i++ {
+		content += fmt.Sprintf("2024-06-01T12:00:%02dZ INFO request %d\n", i%60, i)
+	}
+	filePath := filepath.Join(t.TempDir(), "app.log")
+	assert.NoError(t, os.WriteFile(filePath, []byte(content), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: filePath, Type: TypeFile}, {FilePath: "/var/log/web.log", Type: TypeSSH, Host: "web1"}})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionGzip})
+	get := func(query string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/download?"+query, nil)
+		req.Header.Set(echo.HeaderAcceptEncoding, "gzip")
+		for name := range header {
+			req.Header.Set(name, header.Get(name))
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("type=file&file_path="+filePath, nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "bytes", rec.Header().Get("Accept-Ranges"))
+	assert.Equal(t, `attachment; filename="app.log"`, rec.Header().Get(echo.HeaderContentDisposition))
+	// ranges are of the file as is, never of a compressed body
+	assert.Empty(t, rec.Header().Get(echo.HeaderContentEncoding))
+	assert.Equal(t, content, rec.Body.String())
+
+	// the download is interrupted after 1000 bytes, curl -C - asks for the rest
+	rec = get("type=file&file_path="+filePath, http.Header{"Range": {"bytes=1000-"}})
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, fmt.Sprintf("bytes 1000-%d/%d", len(content)-1, len(content)), rec.Header().Get("Content-Range"))
+	assert.Equal(t, content, content[:1000]+rec.Body.String())
+
+	// a line range is text, compressed as such since it has no ranges
+	rec = get("type=file&file_path="+filePath+"&lines=2-3", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, echo.MIMETextPlainCharsetUTF8, rec.Header().Get(echo.HeaderContentType))
+	assert.Equal(t, `attachment; filename="app.lines-2-3.log"`, rec.Header().Get(echo.HeaderContentDisposition))
+	assert.Equal(t, "gzip", rec.Header().Get(echo.HeaderContentEncoding))
+	reader, _, err := NewDecompressingReader(rec.Body)
+	assert.NoError(t, err)
+	lines, err := io.ReadAll(reader)
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-06-01T12:00:02Z INFO request 2\n2024-06-01T12:00:03Z INFO request 3\n", string(lines))
+	for _, lines := range []string{"3", "0-2", "5-4", "a-b"} {
+		assert.Equal(t, http.StatusUnprocessableEntity, get("type=file&file_path="+filePath+"&lines="+lines, nil).Code, lines)
+	}
+
+	assert.Equal(t, http.StatusForbidden, get("type=file&file_path=/etc/passwd", nil).Code)
+	assert.Equal(t, http.StatusForbidden, get("type=file&file_path="+filepath.Dir(filePath)+"/../../etc/passwd", nil).Code)
+	// the file of a host is not that of another, nor a local file
+	assert.Equal(t, http.StatusForbidden, get("type=ssh&host=web2&file_path=/var/log/web.log", nil).Code)
+	assert.Equal(t, http.StatusForbidden, get("type=file&file_path=/var/log/web.log", nil).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, get("file_path="+filePath, nil).Code)
+}
+
+func TestAPIHandler_GetDownload_SSH(t *testing.T) {
+	content := "INFO line 1\nINFO line 2\nWARN line 3\n"
+	runner := &ScriptedRemoteRunner{Outputs: map[string]string{"web1 cat /var/log/web.log": content}}
+	defer func(runner RemoteRunner, fileInfos []FileInfo, sshConfigs []SSHPathConfig) {
+		GlobalRemoteRunner, GlobalPathSSHConfig = runner, sshConfigs
+		GlobalFileRegistry.Replace(fileInfos)
+	}(GlobalRemoteRunner, GlobalFileRegistry.Snapshot(), GlobalPathSSHConfig)
+	GlobalRemoteRunner = runner
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: "/var/log/web.log", Type: TypeSSH, Host: "web1"}})
+	GlobalPathSSHConfig = []SSHPathConfig{{Host: "web1", FilePath: "/var/log/*.log"}}
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	get := func(query string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, "/api/download?"+query, nil)
+		for name := range header {
+			req.Header.Set(name, header.Get(name))
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get("type=ssh&host=web1&file_path=/var/log/web.log", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `attachment; filename="web.log"`, rec.Header().Get(echo.HeaderContentDisposition))
+	assert.Equal(t, "text/plain; charset=utf-8", rec.Header().Get(echo.HeaderContentType))
+	assert.Equal(t, content, rec.Body.String())
+
+	// a Range request is served from a temp copy
+	rec = get("type=ssh&host=web1&file_path=/var/log/web.log", http.Header{"Range": {"bytes=12-"}})
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, fmt.Sprintf("bytes 12-%d/%d", len(content)-1, len(content)), rec.Header().Get("Content-Range"))
+	assert.Equal(t, content[12:], rec.Body.String())
+
+	rec = get("type=ssh&host=web1&file_path=/var/log/web.log&lines=3-9", nil)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "WARN line 3\n", rec.Body.String())
+}
+
+func TestAPIHandler_GetDiff_ResumedExport(t *testing.T) {
+	useExports(t)
+	dir := t.TempDir()
+	canary := filepath.Join(dir, "canary.log")
+	stable := filepath.Join(dir, "stable.log")
+	lines := []string{}
+	for i := 1; i <= 50; i++ {
+		lines = append(lines, fmt.Sprintf("2024-06-01T12:00:%02dZ ERROR failure %d of kind %c", i%60, i, 'a'+i%26))
+	}
+	assert.NoError(t, os.WriteFile(canary, []byte(strings.Join(lines, "\n")+"\n"), 0600))
+	assert.NoError(t, os.WriteFile(stable, []byte("2024-06-01T12:00:00Z INFO ok\n"), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: canary, Type: TypeFile}, {FilePath: stable, Type: TypeFile}})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	target := "/api/diff?type=file&file_path=" + canary + "&other_type=file&other_file_path=" + stable + "&format=ndjson"
+	get := func(target string, header http.Header) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, target, nil)
+		req.Header = header
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := get(target, http.Header{})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "bytes", rec.Header().Get("Accept-Ranges"))
+	export := rec.Body.String()
+	id := rec.Header().Get(HeaderExportID)
+	assert.NotEmpty(t, id)
+	assert.Equal(t, `"`+id+`"`, rec.Header().Get("ETag"))
+	job, ok := GlobalJobs.Get(id)
+	assert.True(t, ok)
+	assert.Equal(t, JobKindExport, job.Kind)
+	assert.Equal(t, JobStateDone, job.State)
+
+	// the file changes, a resumed download still gets the spooled export
+	assert.NoError(t, os.WriteFile(canary, []byte("2024-06-01T12:00:00Z WARN something else\n"), 0600))
+
+	// the middle of the export
+	rec = get(target, http.Header{"Range": {"bytes=100-299"}, "If-Range": {`"` + id + `"`}})
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, fmt.Sprintf("bytes 100-299/%d", len(export)), rec.Header().Get("Content-Range"))
+	assert.Equal(t, export[100:300], rec.Body.String())
+
+	// and by its ID
+	rec = get("/api/exports/"+id, http.Header{"Range": {"bytes=300-"}})
+	assert.Equal(t, http.StatusPartialContent, rec.Code)
+	assert.Equal(t, export[300:], rec.Body.String())
+	assert.Equal(t, http.StatusNotFound, get("/api/exports/nope", http.Header{}).Code)
+
+	// a new request exports the file as it is now
+	rec = get(target, http.Header{})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NotEqual(t, id, rec.Header().Get(HeaderExportID))
+	assert.NotEqual(t, export, rec.Body.String())
+}
+
+func TestExports_Expire(t *testing.T) {
+	useExports(t)
+	clock := NewManualClock(time.Now())
+	useFakes(t, fstest.MapFS{}, nil, clock)
+	write := func(_ context.Context, w io.Writer) error {
+		_, err := io.WriteString(w, "{}\n")
+		return err
+	}
+
+	export, err := GlobalExports.Spool(context.Background(), "api/diff?file_path=a", "a", "diff.ndjson", "application/x-ndjson", write)
+	assert.NoError(t, err)
+	assert.FileExists(t, export.FilePath)
+	// left behind by an earlier run
+	stray := filepath.Join(ExportsDir(), "0123456789abcdef.ndjson")
+	assert.NoError(t, os.WriteFile(stray, []byte("{}\n"), 0600))
+
+	clock.Advance(time.Hour)
+	assert.Empty(t, GlobalExports.Expire())
+	_, ok := GlobalExports.Latest("api/diff?file_path=a")
+	assert.True(t, ok)
+
+	clock.Advance(DefaultExportRetention)
+	assert.ElementsMatch(t, []string{export.FilePath, stray}, GlobalExports.Expire())
+	assert.NoFileExists(t, export.FilePath)
+	_, ok = GlobalExports.Get(export.ID)
+	assert.False(t, ok)
+	_, ok = GlobalExports.Latest("api/diff?file_path=a")
+	assert.False(t, ok)
+
+	GlobalDataDir = ""
+	_, err = GlobalExports.Spool(context.Background(), "api/diff?file_path=a", "a", "diff.ndjson", "application/x-ndjson", write)
+	assert.ErrorIs(t, err, ErrNoDataDir)
+}
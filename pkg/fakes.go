@@ -0,0 +1,198 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"sync"
+	"testing/fstest"
+	"time"
+)
+
+// FSFileOpener opens files of an fs.FS, e.g. an fstest.MapFS in tests.
+// Names are fs.FS paths: slash separated and without a leading slash.
+type FSFileOpener struct {
+	FS fs.FS
+}
+
+// NewMapFileOpener is a FileOpener over the files of fsys
+func NewMapFileOpener(fsys fstest.MapFS) *FSFileOpener {
+	return &FSFileOpener{FS: fsys}
+}
+
+func (o *FSFileOpener) Open(name string) (File, error) {
+	f, err := o.FS.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	file, ok := f.(File)
+	if !ok {
+		f.Close()
+		return nil, &fs.PathError{Op: "open", Path: name, Err: errors.ErrUnsupported}
+	}
+	return file, nil
+}
+
+func (o *FSFileOpener) Stat(name string) (fs.FileInfo, error) {
+	return fs.Stat(o.FS, name)
+}
+
+func (o *FSFileOpener) Glob(pattern string) ([]string, error) {
+	return fs.Glob(o.FS, pattern)
+}
+
+func (o *FSFileOpener) WalkDir(root string, fn fs.WalkDirFunc) error {
+	return fs.WalkDir(o.FS, root, fn)
+}
+
+// ScriptedRemoteRunner answers commands from a script instead of running them on a host
+type ScriptedRemoteRunner struct {
+	mutex sync.Mutex
+	// Outputs and Errors are keyed by "host command", e.g. "web1 cat /var/log/app.log"
+	Outputs map[string]string
+	Errors  map[string]error
+	// Calls are the keys of the commands run, in order
+	Calls []string
+	// Gates hold the commands of their key until closed, like a slow host
+	Gates map[string]chan struct{}
+}
+
+func (r *ScriptedRemoteRunner) Run(ctx context.Context, config *SSHConfig, cmd string) ([]byte, error) {
+	key := config.Host + " " + cmd
+	r.mutex.Lock()
+	r.Calls = append(r.Calls, key)
+	r.mutex.Unlock()
+	if gate, ok := r.Gates[key]; ok {
+		select {
+		case <-gate:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err, ok := r.Errors[key]; ok {
+		return nil, err
+	}
+	output, ok := r.Outputs[key]
+	if !ok {
+		return nil, fmt.Errorf("unscripted command on %s: %s", config.Host, cmd)
+	}
+	return []byte(output), nil
+}
+
+// ManualClock only moves when Advance is called
+type ManualClock struct {
+	mutex   sync.Mutex
+	now     time.Time
+	tickers []*manualTicker
+	timers  []*manualTimer
+}
+
+type manualTicker struct {
+	ticks   chan time.Time
+	period  time.Duration
+	next    time.Time
+	stopped bool
+}
+
+type manualTimer struct {
+	at    time.Time
+	fired chan time.Time
+}
+
+func NewManualClock(now time.Time) *ManualClock {
+	return &ManualClock{now: now}
+}
+
+func (c *ManualClock) Now() time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return c.now
+}
+
+func (c *ManualClock) Tick(d time.Duration) (<-chan time.Time, func()) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	ticker := &manualTicker{ticks: make(chan time.Time), period: d, next: c.now.Add(d)}
+	c.tickers = append(c.tickers, ticker)
+	return ticker.ticks, func() {
+		c.mutex.Lock()
+		defer c.mutex.Unlock()
+		ticker.stopped = true
+	}
+}
+
+func (c *ManualClock) After(d time.Duration) <-chan time.Time {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	timer := &manualTimer{at: c.now.Add(d), fired: make(chan time.Time, 1)}
+	if d <= 0 {
+		timer.fired <- c.now
+		return timer.fired
+	}
+	c.timers = append(c.timers, timer)
+	return timer.fired
+}
+
+// Timers is the number of After calls still waiting, wait for the timer of a goroutine before advancing
+func (c *ManualClock) Timers() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.timers)
+}
+
+// Tickers is the number of Tick calls, wait for the ticker of a goroutine before advancing
+func (c *ManualClock) Tickers() int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	return len(c.tickers)
+}
+
+// Advance moves the clock by d, fires the timers and delivers the ticks due meanwhile. Ticks are unbuffered,
+// so Advance returns once every tick is received, that is once the work of the previous tick is done.
+func (c *ManualClock) Advance(d time.Duration) {
+	c.mutex.Lock()
+	c.now = c.now.Add(d)
+	now := c.now
+	tickers := append([]*manualTicker{}, c.tickers...)
+	waiting := c.timers[:0]
+	for _, timer := range c.timers {
+		if timer.at.After(now) {
+			waiting = append(waiting, timer)
+			continue
+		}
+		timer.fired <- timer.at
+	}
+	c.timers = waiting
+	c.mutex.Unlock()
+
+	for _, ticker := range tickers {
+		for {
+			c.mutex.Lock()
+			due := !ticker.stopped && !ticker.next.After(now)
+			tick := ticker.next
+			if due {
+				ticker.next = ticker.next.Add(ticker.period)
+			}
+			c.mutex.Unlock()
+			if !due {
+				break
+			}
+			ticker.ticks <- tick
+		}
+	}
+}
+
+// Close ends the tick channels, ranging over them returns
+func (c *ManualClock) Close() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, ticker := range c.tickers {
+		if !ticker.stopped {
+			ticker.stopped = true
+			close(ticker.ticks)
+		}
+	}
+}
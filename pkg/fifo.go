@@ -0,0 +1,99 @@
+package pkg
+
+import (
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"sync"
+)
+
+// FIFOs copy the named pipes matched by -f patterns to temp files, as they come like stdin. The pipes
+// are listed as stdin sources, named after their path.
+type FIFOs struct {
+	mutex sync.Mutex
+	limit int
+	// copies are the pipes read, by path of the pipe
+	copies map[string]*fifoCopy
+}
+
+// fifoCopy is a named pipe read into the temp file buffer
+type fifoCopy struct {
+	fifo   *os.File
+	buffer string
+	pipe   *StdinPipe
+}
+
+func NewFIFOs(limit int) *FIFOs {
+	return &FIFOs{limit: limit, copies: map[string]*fifoCopy{}}
+}
+
+// IsFIFO tells whether the local file at filePath is a named pipe
+func IsFIFO(filePath string) bool {
+	info, err := GlobalFileOpener.Stat(filePath)
+	return err == nil && info.Mode()&fs.ModeNamedPipe != 0
+}
+
+// Buffer is the temp file the named pipe at filePath is copied to, reading it from the first call on.
+// The pipe stays open for writing by gol too, so that producers closing and reopening it do not end it.
+func (f *FIFOs) Buffer(filePath string) (string, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	if copied, ok := f.copies[filePath]; ok {
+		return copied.buffer, nil
+	}
+	if err := EnsureFreeDisk(TmpDir(), 0); err != nil {
+		return "", err
+	}
+	fifo, err := openFIFO(filePath)
+	if err != nil {
+		return "", err
+	}
+	tmpFile, err := os.Create(GetTmpFileNameForSTDIN())
+	if err != nil {
+		fifo.Close()
+		return "", err
+	}
+	slog.Info("Temporary file created for named pipe", "pipe", filePath, "path", tmpFile.Name())
+	pipe := NewStdinPipe(tmpFile, filePath, f.limit, nil)
+	f.copies[filePath] = &fifoCopy{fifo: fifo, buffer: tmpFile.Name(), pipe: pipe}
+	go func() {
+		defer tmpFile.Close()
+		defer fifo.Close()
+		if err := pipe.Run(fifo); err != nil && !errors.Is(err, os.ErrClosed) {
+			slog.Error("reading from named pipe", "pipe", filePath, "error", err)
+		}
+	}()
+	return tmpFile.Name(), nil
+}
+
+// Pipe is the named pipe copied to the temp file bufferPath, nil for other files
+func (f *FIFOs) Pipe(bufferPath string) *StdinPipe {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for _, copied := range f.copies {
+		if copied.buffer == bufferPath {
+			return copied.pipe
+		}
+	}
+	return nil
+}
+
+// Close stops reading the named pipes and removes their temp files
+func (f *FIFOs) Close() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for filePath, copied := range f.copies {
+		copied.fifo.Close()
+		os.Remove(copied.buffer)
+		delete(f.copies, filePath)
+	}
+}
+
+// stdinPipe is the pipe copied to filePath, of stdin or of a named pipe, nil for other files
+func stdinPipe(filePath string) *StdinPipe {
+	if filePath == PipeTmpFilePath() {
+		return GlobalStdinPipe
+	}
+	return GlobalFIFOs.Pipe(filePath)
+}
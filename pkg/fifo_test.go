@@ -0,0 +1,71 @@
+//go:build !windows
+
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFIFOs(t *testing.T) {
+	fifos := GlobalFIFOs
+	t.Cleanup(func() { GlobalFIFOs = fifos })
+	GlobalFIFOs = NewFIFOs(100)
+	defer GlobalFIFOs.Close()
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	dir := t.TempDir()
+	fifoPath := filepath.Join(dir, "app1")
+	assert.NoError(t, syscall.Mkfifo(fifoPath, 0600))
+
+	// listing the pipe neither blocks nor reads it
+	ok, err := IsReadableFile(fifoPath, false, nil, false)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	fileInfos := GetFileInfos(filepath.Join(dir, "*"), 10, false, nil)
+	assert.Len(t, fileInfos, 1)
+	assert.Equal(t, TypeStdin, fileInfos[0].Type)
+	assert.Equal(t, fifoPath, fileInfos[0].Name)
+	assert.NotEqual(t, fifoPath, fileInfos[0].FilePath)
+	GlobalFileRegistry.Replace(SetFileIDs(fileInfos))
+	id := fileInfos[0].ID
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	lines := func() []string {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api?id="+id+"&per_page=100", nil))
+		var response APIResponse
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &response) != nil {
+			return nil
+		}
+		return mergedContents(response.Result.Lines)
+	}
+
+	// producers closing and reopening the pipe do not end the reading
+	for producer := 1; producer <= 3; producer++ {
+		writer, err := os.OpenFile(fifoPath, os.O_WRONLY, 0)
+		assert.NoError(t, err)
+		_, err = fmt.Fprintf(writer, "producer %d\n", producer)
+		assert.NoError(t, err)
+		assert.NoError(t, writer.Close())
+		assert.Eventually(t, func() bool { return len(lines()) == producer }, 2*time.Second, 10*time.Millisecond)
+	}
+	assert.Equal(t, []string{"producer 1", "producer 2", "producer 3"}, lines())
+
+	// the pipe is read once, listed under the same temp file
+	fileInfos = GetFileInfos(fifoPath, 10, false, nil)
+	assert.Equal(t, GlobalFileRegistry.Snapshot()[0].FilePath, fileInfos[0].FilePath)
+	assert.Equal(t, 3, fileInfos[0].LinesCount)
+	assert.False(t, fileInfos[0].Closed)
+
+	GlobalFIFOs.Close()
+	_, err = os.Stat(fileInfos[0].FilePath)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
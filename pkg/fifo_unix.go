@@ -0,0 +1,14 @@
+//go:build !windows
+
+package pkg
+
+import (
+	"os"
+	"syscall"
+)
+
+// openFIFO opens the named pipe at filePath without waiting for a writer. Open for writing as well,
+// reads wait for the next writer instead of ending when the current one closes it.
+func openFIFO(filePath string) (*os.File, error) {
+	return os.OpenFile(filePath, os.O_RDWR|syscall.O_NONBLOCK, 0)
+}
@@ -0,0 +1,13 @@
+//go:build windows
+
+package pkg
+
+import (
+	"errors"
+	"os"
+)
+
+// openFIFO is not implemented on windows, it has no named pipes in the file system
+func openFIFO(string) (*os.File, error) {
+	return nil, errors.New("named pipes are not supported on windows")
+}
@@ -0,0 +1,76 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// FileAccessError is the structured error of a request for a file outside of the watched files
+type FileAccessError struct {
+	Code     string `json:"code"`
+	FilePath string `json:"file_path"`
+	Type     string `json:"type"`
+	Host     string `json:"host,omitempty"`
+}
+
+func (e *FileAccessError) Error() string {
+	return fmt.Sprintf("%s is not a watched %s file", e.FilePath, e.Type)
+}
+
+// AuthorizeFilePath lets a request read filePath only when it is a watched file of fileType, and of host
+// for the files of a host, so that the API serves nothing but the files of the configured patterns. A
+// local path is compared once its symlinks are resolved, as it is opened: neither .. nor a symlink
+// reaches a file that is not watched, while another path of a watched file does. A path differing in
+// case only is the watched file when both are the same file, as on case-insensitive file systems. The
+// paths of other types are compared as they are, their host resolves them.
+func AuthorizeFilePath(filePath string, fileType string, host string) error {
+	candidates := []string{}
+	for _, fileInfo := range GlobalFileRegistry.Snapshot() {
+		if fileInfo.Type != fileType || (fileInfo.Host != "" && fileInfo.Host != host) {
+			continue
+		}
+		for _, watched := range append([]string{fileInfo.FilePath}, fileInfo.Aliases...) {
+			if watched == filePath {
+				return nil
+			}
+			candidates = append(candidates, watched)
+		}
+	}
+
+	if fileType == TypeFile {
+		if resolved, err := filepath.EvalSymlinks(filePath); err == nil {
+			for _, watched := range candidates {
+				if sameLocalFile(resolved, watched) {
+					return nil
+				}
+			}
+		}
+	}
+	return &FileAccessError{Code: ErrorCodeFileNotAllowed, FilePath: filePath, Type: fileType, Host: host}
+}
+
+// sameLocalFile tells whether resolved, a path with its symlinks resolved, is the watched file. Only
+// watched files of the same name, in any case, are resolved and compared.
+func sameLocalFile(resolved string, watched string) bool {
+	if !strings.EqualFold(filepath.Base(resolved), filepath.Base(watched)) {
+		return false
+	}
+	resolvedWatched, err := filepath.EvalSymlinks(watched)
+	if err != nil {
+		return false
+	}
+	if resolved == resolvedWatched {
+		return true
+	}
+	if !strings.EqualFold(resolved, resolvedWatched) {
+		return false
+	}
+	resolvedInfo, err := os.Stat(resolved)
+	if err != nil {
+		return false
+	}
+	watchedInfo, err := os.Stat(resolvedWatched)
+	return err == nil && os.SameFile(resolvedInfo, watchedInfo)
+}
@@ -0,0 +1,86 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAuthorizeFilePath(t *testing.T) {
+	dir := t.TempDir()
+	logs := filepath.Join(dir, "logs")
+	assert.NoError(t, os.Mkdir(logs, 0700))
+	logFile := filepath.Join(logs, "app.log")
+	secret := filepath.Join(dir, "secret.txt")
+	assert.NoError(t, os.WriteFile(logFile, []byte("line 1\n"), 0600))
+	assert.NoError(t, os.WriteFile(secret, []byte("secret\n"), 0600))
+	// a symlink to a file outside of the watched ones, and one to the watched file
+	assert.NoError(t, os.Symlink(secret, filepath.Join(logs, "escape.log")))
+	assert.NoError(t, os.Symlink(logFile, filepath.Join(logs, "alias.log")))
+	assert.NoError(t, os.Symlink(dir, filepath.Join(logs, "up")))
+	// a hard link stands for the same file in another case, as on a case-insensitive file system,
+	// while another file of that name in another case is not the watched one
+	assert.NoError(t, os.Link(logFile, filepath.Join(logs, "APP.log")))
+	assert.NoError(t, os.WriteFile(filepath.Join(logs, "App.LOG"), []byte("other\n"), 0600))
+
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	GlobalFileRegistry.Replace([]FileInfo{
+		{FilePath: logFile, Type: TypeFile},
+		{FilePath: "/var/log/web.log", Type: TypeSSH, Host: "web1"},
+	})
+
+	for _, tt := range []struct {
+		name     string
+		filePath string
+		fileType string
+		host     string
+		allowed  bool
+	}{
+		{"watched", logFile, TypeFile, "", true},
+		{"dot segments to the watched file", filepath.Join(logs, "..", "logs", "app.log"), TypeFile, "", true},
+		{"unclean path", logs + "//./app.log", TypeFile, "", true},
+		{"traversal", logs + "/../secret.txt", TypeFile, "", false},
+		{"traversal from root", logs + "/../../../../../etc/passwd", TypeFile, "", false},
+		{"symlink escape", filepath.Join(logs, "escape.log"), TypeFile, "", false},
+		{"symlink to the watched file", filepath.Join(logs, "alias.log"), TypeFile, "", true},
+		{"traversal through a symlinked directory", filepath.Join(logs, "up", "logs", "app.log"), TypeFile, "", true},
+		{"dot segments after a symlinked directory", logs + "/up/../secret.txt", TypeFile, "", false},
+		{"other case, same file", filepath.Join(logs, "APP.log"), TypeFile, "", true},
+		{"other case, other file", filepath.Join(logs, "App.LOG"), TypeFile, "", false},
+		{"other case, missing", filepath.Join(logs, "APP.LOG"), TypeFile, "", false},
+		{"other type", logFile, TypeSSH, "web1", false},
+		{"file of the host", "/var/log/web.log", TypeSSH, "web1", true},
+		{"file of another host", "/var/log/web.log", TypeSSH, "web2", false},
+		{"dot segments on a host", "/var/log/../log/web.log", TypeSSH, "web1", false},
+		{"host file as a local file", "/var/log/web.log", TypeFile, "", false},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			err := AuthorizeFilePath(tt.filePath, tt.fileType, tt.host)
+			if tt.allowed {
+				assert.NoError(t, err)
+				return
+			}
+			var accessErr *FileAccessError
+			if assert.ErrorAs(t, err, &accessErr) {
+				assert.Equal(t, ErrorCodeFileNotAllowed, accessErr.Code)
+				assert.Equal(t, tt.filePath, accessErr.FilePath)
+			}
+		})
+	}
+
+	// the endpoints answer 403 with the error
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/tail?type=file&file_path="+filepath.Join(logs, "escape.log"), nil))
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	res := struct {
+		Error FileAccessError `json:"error"`
+	}{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Equal(t, FileAccessError{Code: ErrorCodeFileNotAllowed, FilePath: filepath.Join(logs, "escape.log"), Type: TypeFile}, res.Error)
+}
@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"net/http"
+	"sort"
+
+	"github.com/labstack/echo/v4"
+)
+
+// FileRef identifies a watched file across rescans
+type FileRef struct {
+	FilePath string `json:"file_path"`
+	Host     string `json:"host"`
+	Type     string `json:"type"`
+}
+
+func fileRefOf(fileInfo FileInfo) FileRef {
+	return FileRef{FilePath: fileInfo.FilePath, Host: fileInfo.Host, Type: fileInfo.Type}
+}
+
+// key is the store key of the file
+func (r FileRef) key() string {
+	return r.Type + "|" + r.Host + "|" + r.FilePath
+}
+
+const (
+	storeBucketHiddenFiles = "hidden_files"
+	storeBucketPinnedFiles = "pinned_files"
+)
+
+// FileCuration is the server side curation of the file list, shared by all users:
+// hidden files are left out of the list and pinned files are listed first
+type FileCuration struct {
+	hidden *Bucket[FileRef]
+	pinned *Bucket[FileRef]
+}
+
+func NewFileCuration(store Store) *FileCuration {
+	return &FileCuration{
+		hidden: NewBucket[FileRef](store, storeBucketHiddenFiles),
+		pinned: NewBucket[FileRef](store, storeBucketPinnedFiles),
+	}
+}
+
+func (c *FileCuration) SetHidden(ref FileRef, hidden bool) error {
+	return setRef(c.hidden, ref, hidden)
+}
+
+func (c *FileCuration) SetPinned(ref FileRef, pinned bool) error {
+	return setRef(c.pinned, ref, pinned)
+}
+
+func setRef(refs *Bucket[FileRef], ref FileRef, set bool) error {
+	if set {
+		return refs.Put(ref.key(), ref)
+	}
+	return refs.Delete(ref.key())
+}
+
+// Apply marks hidden and pinned files and sorts pinned files first, keeping the order otherwise.
+// Hidden files are left out unless includeHidden is set.
+func (c *FileCuration) Apply(fileInfos []FileInfo, includeHidden bool) []FileInfo {
+	hidden := c.hidden.All()
+	pinned := c.pinned.All()
+	curated := make([]FileInfo, 0, len(fileInfos))
+	for _, fileInfo := range fileInfos {
+		key := fileRefOf(fileInfo).key()
+		_, fileInfo.Hidden = hidden[key]
+		_, fileInfo.Pinned = pinned[key]
+		if fileInfo.Hidden && !includeHidden {
+			continue
+		}
+		curated = append(curated, fileInfo)
+	}
+	sort.SliceStable(curated, func(i, j int) bool {
+		return curated[i].Pinned && !curated[j].Pinned
+	})
+	return curated
+}
+
+type FileCurationRequest struct {
+	ID       string `json:"id" query:"id"`
+	FilePath string `json:"file_path" query:"file_path" validate:"required" message:"file_path is required"`
+	Host     string `json:"host" query:"host"`
+	Type     string `json:"type" query:"type" validate:"required" message:"type is required"`
+	// Undo unhides or unpins the file
+	Undo bool `json:"undo" query:"undo"`
+}
+
+// PostHideFile hides a file from the file list, it can still be read by a direct request
+func (h *AdminHandler) PostHideFile(c echo.Context) error {
+	return h.curateFile(c, GlobalFileCuration.SetHidden)
+}
+
+// PostPinFile lists a file first in the file list
+func (h *AdminHandler) PostPinFile(c echo.Context) error {
+	return h.curateFile(c, GlobalFileCuration.SetPinned)
+}
+
+func (h *AdminHandler) curateFile(c echo.Context, set func(FileRef, bool) error) error {
+	if err := h.authorizeShared(c); err != nil {
+		return err
+	}
+	req := new(FileCurationRequest)
+	if err := BindRequest(c, req); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err)
+	}
+	if err := resolveFileID(req.ID, &req.FilePath, &req.Host, &req.Type); err != nil {
+		return err
+	}
+	msgs, err := ValidateRequest(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, msgs)
+	}
+	if err := set(FileRef{FilePath: req.FilePath, Host: req.Host, Type: req.Type}, !req.Undo); err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, FileListResponse{
+		FilePaths: GlobalFileCuration.Apply(GlobalFileRegistry.Snapshot(), true),
+	})
+}
@@ -0,0 +1,97 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileCuration_Apply(t *testing.T) {
+	fileInfos := []FileInfo{
+		{FilePath: "a.log", Type: TypeFile},
+		{FilePath: "a.log.1", Type: TypeFile},
+		{FilePath: "b.log", Type: TypeFile},
+		{FilePath: "b.log", Type: TypeSSH, Host: "remote"},
+	}
+	path := filepath.Join(t.TempDir(), "store.json")
+	store, err := OpenFileStore(path)
+	assert.NoError(t, err)
+	curation := NewFileCuration(store)
+	assert.NoError(t, curation.SetHidden(FileRef{FilePath: "a.log.1", Type: TypeFile}, true))
+	assert.NoError(t, curation.SetPinned(FileRef{FilePath: "b.log", Type: TypeSSH, Host: "remote"}, true))
+
+	paths := func(fileInfos []FileInfo) []string {
+		ps := []string{}
+		for _, fileInfo := range fileInfos {
+			ps = append(ps, fileInfo.Host+":"+fileInfo.FilePath)
+		}
+		return ps
+	}
+
+	curated := curation.Apply(fileInfos, false)
+	assert.Equal(t, []string{"remote:b.log", ":a.log", ":b.log"}, paths(curated))
+	assert.True(t, curated[0].Pinned)
+
+	curated = curation.Apply(fileInfos, true)
+	assert.Equal(t, []string{"remote:b.log", ":a.log", ":a.log.1", ":b.log"}, paths(curated))
+	assert.True(t, curated[2].Hidden)
+
+	// persisted across restarts
+	reopened, err := OpenFileStore(path)
+	assert.NoError(t, err)
+	assert.Equal(t, curated, NewFileCuration(reopened).Apply(fileInfos, true))
+
+	assert.NoError(t, curation.SetHidden(FileRef{FilePath: "a.log.1", Type: TypeFile}, false))
+	assert.Len(t, curation.Apply(fileInfos, false), 4)
+}
+
+func TestAdminHandler_PostHideFile(t *testing.T) {
+	GlobalFileRegistry.Replace([]FileInfo{
+		{FilePath: "a.log", Type: TypeFile},
+		{FilePath: "noise.log", Type: TypeFile},
+	})
+	SetGlobalStore(NewMemoryStore())
+	defer SetGlobalStore(NewMemoryStore())
+
+	post := func(options *EchoOptions, body string, token string) int {
+		e := newTestEcho(options)
+		req := httptest.NewRequest(http.MethodPost, "/api/files/hide", strings.NewReader(body))
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+		if token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec.Code
+	}
+	listed := func(options *EchoOptions, query string) []FileInfo {
+		e := newTestEcho(options)
+		req := httptest.NewRequest(http.MethodGet, "/api/files"+query, nil)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		var list FileListResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+		return list.FilePaths
+	}
+
+	// single user, no admin token
+	options := &EchoOptions{BaseURL: "/", Compression: CompressionOff}
+	assert.Equal(t, http.StatusUnprocessableEntity, post(options, `{"file_path":"noise.log"}`, ""))
+	assert.Equal(t, http.StatusOK, post(options, `{"file_path":"noise.log","type":"file"}`, ""))
+	assert.Len(t, listed(options, ""), 1)
+	all := listed(options, "?include_hidden=true")
+	assert.Len(t, all, 2)
+	assert.True(t, all[1].Hidden)
+
+	// shared server, admin scope required
+	options.AdminToken = "secret"
+	assert.Equal(t, http.StatusUnauthorized, post(options, `{"file_path":"noise.log","type":"file","undo":true}`, ""))
+	assert.Equal(t, http.StatusOK, post(options, `{"file_path":"noise.log","type":"file","undo":true}`, "secret"))
+	assert.Len(t, listed(options, ""), 2)
+}
@@ -0,0 +1,223 @@
+package pkg
+
+import (
+	"cmp"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	GroupByHost = "host"
+	GroupByType = "type"
+	// GroupByDir groups the files by their directory
+	GroupByDir = "dir"
+	// GroupByLabel groups the files by their group, the label of their pattern or their source
+	GroupByLabel = "label"
+
+	// SortNatural orders numbers by value, app.log.2 before app.log.10, it is the default
+	SortNatural = "natural"
+	// SortLexical orders names byte by byte
+	SortLexical = "lexical"
+	// SortName orders the file names in natural order, SortSize, SortLines and SortMtime order the
+	// sizes, the lines counts and the modification times
+	SortName  = "name"
+	SortSize  = "size"
+	SortLines = "lines"
+	SortMtime = "mtime"
+)
+
+// FileListRequest holds the filters applied server side over the file list
+type FileListRequest struct {
+	Type     string `json:"type" query:"type" validate:"omitempty,oneof=file ssh docker stdin remote internal k8s journal http s3" message:"type must be one of file ssh docker stdin remote internal k8s journal http s3"`
+	Host     string `json:"host" query:"host"`
+	PathGlob string `json:"path_glob" query:"path_glob"`
+	Q        string `json:"q" query:"q"`
+	GroupBy  string `json:"group_by" query:"group_by" validate:"omitempty,oneof=host type dir label" message:"group_by must be one of host type dir label"`
+	Logical  bool   `json:"logical" query:"logical"`
+	Sort     string `json:"sort" query:"sort" validate:"omitempty,oneof=natural lexical name size lines mtime" message:"sort must be one of natural lexical name size lines mtime"`
+	Order    string `json:"order" query:"order" validate:"omitempty,oneof=asc desc" message:"order must be one of asc desc"`
+	// Limit and Offset page the list, a limit of 0 lists every file
+	Limit  int `json:"limit" query:"limit" validate:"gte=0" message:"limit >=0 is required"`
+	Offset int `json:"offset" query:"offset" validate:"gte=0" message:"offset >=0 is required"`
+	// IncludeHidden lists the hidden files too, marked as hidden
+	IncludeHidden bool `json:"include_hidden" query:"include_hidden"`
+	// Preview adds the last lines of the local files, all adds those of the remote sources too
+	Preview string `json:"preview" query:"preview" validate:"omitempty,oneof=true false all" message:"preview must be one of true false all"`
+}
+
+type FileGroup struct {
+	Name      string     `json:"name"`
+	Count     int        `json:"count"`
+	FilePaths []FileInfo `json:"file_paths"`
+}
+
+type FileListResponse struct {
+	FilePaths []FileInfo  `json:"file_paths"`
+	Groups    []FileGroup `json:"groups,omitempty"`
+	// Total is the count of the files before limit and offset
+	Total int `json:"total"`
+}
+
+// filePatternLabel is the label of a local pattern, "nginx:/var/log/nginx/*.log". A single letter is
+// not a label but a Windows drive.
+var filePatternLabel = regexp.MustCompile(`^([A-Za-z0-9_.-]{2,}):(.+)$`)
+
+// ParseFilePattern splits a local pattern into its label, empty without any, and the pattern itself.
+// The scheme of an HTTP URL is not a label.
+func ParseFilePattern(pattern string) (string, string) {
+	if IsHTTPURL(pattern) {
+		return "", pattern
+	}
+	match := filePatternLabel.FindStringSubmatch(pattern)
+	if match == nil {
+		return "", pattern
+	}
+	return match[1], match[2]
+}
+
+// SetFileGroups sets the group of the files and their segments without a label: the directory of
+// local files, the host of SSH, Kubernetes and remote files, the container of Docker logs, or else
+// the name of the source
+func SetFileGroups(fileInfos []FileInfo) []FileInfo {
+	for i, fileInfo := range fileInfos {
+		if fileInfo.Group == "" {
+			fileInfos[i].Group = fileGroup(fileInfo)
+		}
+		SetFileGroups(fileInfo.Segments)
+	}
+	return fileInfos
+}
+
+func fileGroup(fileInfo FileInfo) string {
+	switch {
+	case fileInfo.Type == TypeFile:
+		return filepath.Dir(fileInfo.FilePath)
+	case fileInfo.Type == TypeStdin:
+		return TypeStdin
+	case fileInfo.Type == TypeDocker && fileInfo.Name != "":
+		return fileInfo.Name
+	case fileInfo.Host != "":
+		return fileInfo.Host
+	}
+	return fileInfo.Name
+}
+
+// FilterFileInfos returns the file infos matching all the given filters, keeping the input order
+func FilterFileInfos(fileInfos []FileInfo, req *FileListRequest) []FileInfo {
+	filtered := make([]FileInfo, 0, len(fileInfos))
+	for _, fileInfo := range fileInfos {
+		if req.Type != "" && fileInfo.Type != req.Type {
+			continue
+		}
+		if req.Host != "" && fileInfo.Host != req.Host {
+			continue
+		}
+		if req.PathGlob != "" {
+			matched, err := filepath.Match(req.PathGlob, fileInfo.FilePath)
+			if err != nil || !matched {
+				continue
+			}
+		}
+		if req.Q != "" && !strings.Contains(strings.ToLower(fileInfo.FilePath), strings.ToLower(req.Q)) {
+			continue
+		}
+		// rotated siblings are listed as segments of their base file
+		if req.Logical && IsRotatedSegment(fileInfo, fileInfos) {
+			continue
+		}
+		filtered = append(filtered, fileInfo)
+	}
+	return filtered
+}
+
+// SortFileList sorts the file infos by sort and order. The files of equal keys, like files of the same
+// name on several hosts, keep the order of the list, so that they stay in place between refreshes.
+func SortFileList(fileInfos []FileInfo, sortBy string, order string) []FileInfo {
+	var compare func(a, b FileInfo) int
+	switch sortBy {
+	case SortLexical:
+		compare = fileInfoOrder(strings.Compare)
+	case SortName:
+		compare = func(a, b FileInfo) int { return CompareNatural(filepath.Base(a.FilePath), filepath.Base(b.FilePath)) }
+	case SortSize:
+		compare = func(a, b FileInfo) int { return cmp.Compare(a.FileSize, b.FileSize) }
+	case SortLines:
+		compare = func(a, b FileInfo) int { return cmp.Compare(a.LinesCount, b.LinesCount) }
+	case SortMtime:
+		compare = func(a, b FileInfo) int { return compareModTimes(a.ModTime, b.ModTime) }
+	default:
+		compare = fileInfoOrder(CompareNatural)
+	}
+	slices.SortStableFunc(fileInfos, func(a, b FileInfo) int {
+		if order == OrderDesc {
+			return compare(b, a)
+		}
+		return compare(a, b)
+	})
+	return fileInfos
+}
+
+// compareModTimes orders the files without a modification time first
+func compareModTimes(a, b *time.Time) int {
+	switch {
+	case a == nil && b == nil:
+		return 0
+	case a == nil:
+		return -1
+	case b == nil:
+		return 1
+	}
+	return a.Compare(*b)
+}
+
+// PageFileInfos returns the files of limit and offset, every file from offset with a limit of 0
+func PageFileInfos(fileInfos []FileInfo, limit int, offset int) []FileInfo {
+	offset = min(offset, len(fileInfos))
+	end := len(fileInfos)
+	if limit > 0 {
+		end = min(offset+limit, end)
+	}
+	return fileInfos[offset:end]
+}
+
+// GroupFileInfos groups the file infos by host, type, directory or label with counts per group
+func GroupFileInfos(fileInfos []FileInfo, groupBy string) []FileGroup {
+	if groupBy == "" {
+		return nil
+	}
+	groups := []FileGroup{}
+	index := map[string]int{}
+	for _, fileInfo := range fileInfos {
+		name := fileGroupName(fileInfo, groupBy)
+		i, ok := index[name]
+		if !ok {
+			i = len(groups)
+			index[name] = i
+			groups = append(groups, FileGroup{Name: name, FilePaths: []FileInfo{}})
+		}
+		groups[i].Count++
+		groups[i].FilePaths = append(groups[i].FilePaths, fileInfo)
+	}
+	sort.SliceStable(groups, func(i, j int) bool {
+		return CompareNatural(groups[i].Name, groups[j].Name) < 0
+	})
+	return groups
+}
+
+func fileGroupName(fileInfo FileInfo, groupBy string) string {
+	switch groupBy {
+	case GroupByHost:
+		return fileInfo.Host
+	case GroupByType:
+		return fileInfo.Type
+	case GroupByDir:
+		return filepath.Dir(fileInfo.FilePath)
+	case GroupByLabel:
+		return fileInfo.Group
+	}
+	return ""
+}
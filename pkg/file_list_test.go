@@ -0,0 +1,344 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterFileInfos(t *testing.T) {
+	fileInfos := []FileInfo{
+		{FilePath: "/var/log/app.log", Type: TypeFile},
+		{FilePath: "/var/log/nginx/access.log", Type: TypeSSH, Host: "web1"},
+		{FilePath: "/var/log/nginx/error.log", Type: TypeSSH, Host: "web2"},
+		{FilePath: "/tmp/GOL-CONTAINER-abc", Type: TypeDocker, Host: "0123456789ab", Name: "redis"},
+	}
+
+	tests := []struct {
+		name string
+		req  FileListRequest
+		want int
+	}{
+		{"no filters", FileListRequest{}, 4},
+		{"by type", FileListRequest{Type: TypeSSH}, 2},
+		{"by host", FileListRequest{Host: "web1"}, 1},
+		{"by glob", FileListRequest{PathGlob: "/var/log/nginx/*.log"}, 2},
+		{"by substring", FileListRequest{Q: "ERROR"}, 1},
+		{"combined", FileListRequest{Type: TypeSSH, Q: "access"}, 1},
+		{"no match", FileListRequest{Type: TypeStdin}, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			assert.Len(t, FilterFileInfos(fileInfos, &tt.req), tt.want)
+		})
+	}
+
+	groups := GroupFileInfos(fileInfos, GroupByType)
+	assert.Len(t, groups, 3)
+	assert.Equal(t, "docker", groups[0].Name)
+	assert.Equal(t, "ssh", groups[2].Name)
+	assert.Equal(t, 2, groups[2].Count)
+	assert.Nil(t, GroupFileInfos(fileInfos, ""))
+}
+
+func TestAPIHandler_GetFiles_Type(t *testing.T) {
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	GlobalFileRegistry.Replace([]FileInfo{
+		{FilePath: "/var/log/app.log", Type: TypeFile},
+		{FilePath: "https://logs.example.com/app.log", Type: TypeHTTP, Host: "logs.example.com"},
+		{FilePath: "s3://logs/app/2024-05-01.log", Type: TypeS3, Host: "logs"},
+	})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+
+	for typ, want := range map[string]string{TypeFile: "/var/log/app.log", TypeHTTP: "https://logs.example.com/app.log", TypeS3: "s3://logs/app/2024-05-01.log"} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/files?type="+typ, nil))
+		assert.Equal(t, http.StatusOK, rec.Code, typ)
+		res := FileListResponse{}
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		if assert.Len(t, res.FilePaths, 1, typ) {
+			assert.Equal(t, want, res.FilePaths[0].FilePath)
+		}
+	}
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/files?type=ftp", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
+
+func TestSortFileInfos(t *testing.T) {
+	fileInfos := []FileInfo{
+		{FilePath: "/var/log/b.log", Type: TypeFile},
+		{FilePath: "/tmp/GOL-CONTAINER-abc", Type: TypeDocker, Host: "0123456789ab", Name: "redis"},
+		{FilePath: "/var/log/a.log", Type: TypeSSH, Host: "web2"},
+		{FilePath: "/var/log/a.log", Type: TypeSSH, Host: "web1"},
+		{FilePath: "/var/log/a.log", Type: TypeFile},
+	}
+	shuffled := []FileInfo{fileInfos[3], fileInfos[0], fileInfos[4], fileInfos[2], fileInfos[1]}
+
+	sorted := SortFileInfos(append([]FileInfo{}, fileInfos...))
+	assert.Equal(t, sorted, SortFileInfos(shuffled))
+	assert.Equal(t, []FileInfo{fileInfos[4], fileInfos[0], fileInfos[3], fileInfos[2], fileInfos[1]}, sorted)
+}
+
+func TestFileID(t *testing.T) {
+	id := FileID("/var/log/a.log", "web1", TypeSSH)
+	assert.Len(t, id, 16)
+	assert.Equal(t, id, FileID("/var/log/a.log", "web1", TypeSSH))
+	assert.NotEqual(t, id, FileID("/var/log/a.log", "web2", TypeSSH))
+	assert.NotEqual(t, id, FileID("/var/log/a.log", "", TypeFile))
+
+	fileInfos := SetFileIDs([]FileInfo{{
+		FilePath: "a.log",
+		Type:     TypeFile,
+		Segments: []FileInfo{{FilePath: "a.log.1", Type: TypeFile}, {FilePath: "a.log", Type: TypeFile}},
+	}})
+	assert.Equal(t, FileID("a.log", "", TypeFile), fileInfos[0].ID)
+	assert.Equal(t, FileID("a.log.1", "", TypeFile), fileInfos[0].Segments[0].ID)
+}
+
+func TestAPIHandler_GetFiles_Preview(t *testing.T) {
+	content := ""
+	for i := 1; i <= 1000; i++ {
+		content += fmt.Sprintf("2024-06-01T12:00:00Z INFO request %d\n", i)
+	}
+	content += "\x1b[31m" + strings.Repeat("x", 300) + "\x1b[0m\n"
+	modTime := time.Now()
+	fsys := fstest.MapFS{
+		"logs/access.log": {Data: []byte(content), ModTime: modTime},
+		"logs/app.log.gz": {Data: gzipped(t, "INFO gzipped\n"), ModTime: modTime},
+	}
+	gate := make(chan struct{})
+	defer close(gate)
+	runner := &ScriptedRemoteRunner{
+		Outputs: map[string]string{"web1 tail -n 3 /var/log/web.log": "GET /\nGET /health\n"},
+		Gates:   map[string]chan struct{}{"web2 tail -n 3 /var/log/slow.log": gate},
+	}
+	useFakes(t, fsys, runner, nil)
+	defer func(previews *Previews, sshConfigs []SSHPathConfig) {
+		GlobalPreviews, GlobalPathSSHConfig = previews, sshConfigs
+	}(GlobalPreviews, GlobalPathSSHConfig)
+	GlobalPreviews = NewPreviews(50 * time.Millisecond)
+	GlobalPathSSHConfig = []SSHPathConfig{{Host: "web1", Port: "22"}, {Host: "web2", Port: "22"}}
+	GlobalFileRegistry.Replace([]FileInfo{
+		{FilePath: "logs/access.log", Type: TypeFile},
+		{FilePath: "logs/app.log.gz", Type: TypeFile},
+		{FilePath: "/var/log/web.log", Type: TypeSSH, Host: "web1", FileSize: 15},
+		{FilePath: "/var/log/slow.log", Type: TypeSSH, Host: "web2", FileSize: 15},
+	})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	list := func(query string) map[string]*FilePreview {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/files"+query, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var res FileListResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		previews := map[string]*FilePreview{}
+		for _, fileInfo := range res.FilePaths {
+			previews[fileInfo.FilePath] = fileInfo.Preview
+		}
+		return previews
+	}
+
+	assert.Nil(t, list("")["logs/access.log"])
+
+	previews := list("?preview=true")
+	if assert.NotNil(t, previews["logs/access.log"]) {
+		lines := previews["logs/access.log"].Lines
+		assert.Equal(t, []string{"2024-06-01T12:00:00Z INFO request 999", "2024-06-01T12:00:00Z INFO request 1000", strings.Repeat("x", PreviewMaxLineLength)}, lines)
+	}
+	assert.Equal(t, PreviewSkippedUnsupported, previews["logs/app.log.gz"].Skipped)
+	assert.Equal(t, PreviewSkippedRemote, previews["/var/log/web.log"].Skipped)
+	assert.Empty(t, runner.Calls)
+
+	// the slow host blows the budget, the listing still answers
+	previews = list("?preview=all")
+	assert.Equal(t, []string{"GET /", "GET /health"}, previews["/var/log/web.log"].Lines)
+	assert.Equal(t, PreviewSkippedBudget, previews["/var/log/slow.log"].Skipped)
+
+	// previews are cached until the file changes
+	list("?preview=all")
+	assert.Equal(t, 1, strings.Count(strings.Join(runner.Calls, "\n"), "web1 tail"))
+	fsys["logs/access.log"] = &fstest.MapFile{Data: []byte("INFO new\n"), ModTime: modTime.Add(time.Second)}
+	assert.Equal(t, []string{"INFO new"}, list("?preview=true")["logs/access.log"].Lines)
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/files?preview=maybe", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
+
+func TestAPIHandler_GetFiles_Sort(t *testing.T) {
+	GlobalFileRegistry.Replace(SortFileInfos([]FileInfo{
+		{FilePath: "/var/log/app.log.10", Type: TypeFile},
+		{FilePath: "/var/log/app.log.2", Type: TypeFile},
+		{FilePath: "/var/log/App.log", Type: TypeFile},
+		{FilePath: "/var/log/app.log.1", Type: TypeFile},
+	}))
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	list := func(query string) []string {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/files"+query, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		var res FileListResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		filePaths := []string{}
+		for _, fileInfo := range res.FilePaths {
+			filePaths = append(filePaths, fileInfo.FilePath)
+		}
+		return filePaths
+	}
+
+	natural := []string{"/var/log/App.log", "/var/log/app.log.1", "/var/log/app.log.2", "/var/log/app.log.10"}
+	assert.Equal(t, natural, list(""))
+	assert.Equal(t, natural, list("?sort=natural"))
+	assert.Equal(t, []string{"/var/log/App.log", "/var/log/app.log.1", "/var/log/app.log.10", "/var/log/app.log.2"}, list("?sort=lexical"))
+}
+
+func TestFileGroups(t *testing.T) {
+	for _, tt := range []struct {
+		pattern string
+		label   string
+		want    string
+	}{
+		{"nginx:/var/log/nginx/*.log", "nginx", "/var/log/nginx/*.log"},
+		{"web-1.prod:logs/*.log", "web-1.prod", "logs/*.log"},
+		{"/var/log/*.log", "", "/var/log/*.log"},
+		{`C:\logs\*.log`, "", `C:\logs\*.log`},
+		{"nginx:", "", "nginx:"},
+	} {
+		label, pattern := ParseFilePattern(tt.pattern)
+		assert.Equal(t, tt.label, label, tt.pattern)
+		assert.Equal(t, tt.want, pattern, tt.pattern)
+	}
+
+	fileInfos := SetFileGroups([]FileInfo{
+		{FilePath: "/var/log/nginx/access.log", Type: TypeFile, Group: "nginx"},
+		{FilePath: "/var/log/app.log", Type: TypeFile, Segments: []FileInfo{{FilePath: "/var/log/app.log.1", Type: TypeFile}}},
+		{FilePath: "/var/log/nginx/error.log", Type: TypeSSH, Host: "web1"},
+		{FilePath: "/tmp/GOL-CONTAINER-abc", Type: TypeDocker, Host: "0123456789ab", Name: "redis"},
+		{FilePath: "/tmp/journal", Type: TypeJournal, Name: "sshd"},
+	})
+	groups := []string{}
+	for _, fileInfo := range fileInfos {
+		groups = append(groups, fileInfo.Group)
+	}
+	assert.Equal(t, []string{"nginx", "/var/log", "web1", "redis", "sshd"}, groups)
+	assert.Equal(t, "/var/log", fileInfos[1].Segments[0].Group)
+
+	byLabel := GroupFileInfos(fileInfos, GroupByLabel)
+	assert.Equal(t, "/var/log", byLabel[0].Name)
+	assert.Equal(t, "nginx", byLabel[1].Name)
+	byDir := GroupFileInfos(fileInfos, GroupByDir)
+	assert.Equal(t, "/tmp", byDir[0].Name)
+	assert.Equal(t, 2, byDir[0].Count)
+	assert.Equal(t, "/var/log/nginx", byDir[2].Name)
+	assert.Equal(t, 2, byDir[2].Count)
+
+	// the group is shown only, it does not tell files apart
+	unique := UniqueFileInfos([]FileInfo{fileInfos[0], {FilePath: "/var/log/nginx/access.log", Type: TypeFile, Group: "/var/log/nginx"}})
+	assert.Equal(t, []FileInfo{fileInfos[0]}, unique)
+}
+
+func TestAPIHandler_GetFiles_GroupByLabel(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "nginx"), 0700))
+	for _, name := range []string{"nginx/access.log", "nginx/error.log", "app.log"} {
+		assert.NoError(t, os.WriteFile(filepath.Join(dir, name), []byte("line\n"), 0600))
+	}
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	UpdateGlobalFilePaths(SliceFlags{"nginx:" + filepath.Join(dir, "nginx", "*.log"), filepath.Join(dir, "*.log")}, nil, nil, 10)
+
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/files?group_by=label", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	res := FileListResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	if assert.Len(t, res.Groups, 2) {
+		assert.Equal(t, dir, res.Groups[0].Name)
+		assert.Equal(t, 1, res.Groups[0].Count)
+		assert.Equal(t, "nginx", res.Groups[1].Name)
+		assert.Equal(t, 2, res.Groups[1].Count)
+	}
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/files?group_by=pod", nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
+
+func TestSortFileList(t *testing.T) {
+	older, newer := time.Unix(100, 0), time.Unix(200, 0)
+	fileInfos := []FileInfo{
+		{FilePath: "/var/log/app.log", Type: TypeFile, FileSize: 30, LinesCount: 1, ModTime: &newer},
+		{FilePath: "/var/log/b.log", Type: TypeSSH, Host: "web1", FileSize: 10, LinesCount: 3, ModTime: &older},
+		{FilePath: "/var/log/b.log", Type: TypeSSH, Host: "web2", FileSize: 20, LinesCount: 3},
+		{FilePath: "/srv/a.log", Type: TypeFile, FileSize: 20, LinesCount: 2, ModTime: &older},
+	}
+	paths := func(fileInfos []FileInfo) []string {
+		paths := []string{}
+		for _, fileInfo := range fileInfos {
+			paths = append(paths, fileInfo.Host+fileInfo.FilePath)
+		}
+		return paths
+	}
+
+	for _, tt := range []struct {
+		sort  string
+		order string
+		want  []string
+	}{
+		{"", "", []string{"/srv/a.log", "/var/log/app.log", "web1/var/log/b.log", "web2/var/log/b.log"}},
+		{SortName, "", []string{"/srv/a.log", "/var/log/app.log", "web1/var/log/b.log", "web2/var/log/b.log"}},
+		// files of equal keys keep their order in either order
+		{SortName, OrderDesc, []string{"web1/var/log/b.log", "web2/var/log/b.log", "/var/log/app.log", "/srv/a.log"}},
+		{SortSize, OrderAsc, []string{"web1/var/log/b.log", "/srv/a.log", "web2/var/log/b.log", "/var/log/app.log"}},
+		{SortLines, OrderDesc, []string{"web1/var/log/b.log", "web2/var/log/b.log", "/srv/a.log", "/var/log/app.log"}},
+		// files without a modification time come first
+		{SortMtime, "", []string{"web2/var/log/b.log", "/srv/a.log", "web1/var/log/b.log", "/var/log/app.log"}},
+	} {
+		sorted := SortFileList(SortFileInfos(append([]FileInfo{}, fileInfos...)), tt.sort, tt.order)
+		assert.Equal(t, tt.want, paths(sorted), tt.sort+" "+tt.order)
+	}
+
+	assert.Len(t, PageFileInfos(fileInfos, 0, 0), 4)
+	assert.Equal(t, fileInfos[1:3], PageFileInfos(fileInfos, 2, 1))
+	assert.Equal(t, fileInfos[3:], PageFileInfos(fileInfos, 10, 3))
+	assert.Empty(t, PageFileInfos(fileInfos, 2, 10))
+}
+
+func TestAPIHandler_GetFiles_Page(t *testing.T) {
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	GlobalFileRegistry.Replace([]FileInfo{
+		{FilePath: "/var/log/a.log", Type: TypeFile, FileSize: 30},
+		{FilePath: "/var/log/b.log", Type: TypeFile, FileSize: 10},
+		{FilePath: "/var/log/c.log", Type: TypeFile, FileSize: 20},
+		{FilePath: "/var/log/nginx/access.log", Type: TypeFile, FileSize: 40},
+	})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/files?q=var/log/&sort=size&order=desc&limit=2&offset=1", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	res := FileListResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Equal(t, 4, res.Total)
+	if assert.Len(t, res.FilePaths, 2) {
+		assert.Equal(t, "/var/log/a.log", res.FilePaths[0].FilePath)
+		assert.Equal(t, "/var/log/c.log", res.FilePaths[1].FilePath)
+	}
+
+	for _, query := range []string{"sort=random", "order=up", "limit=-1", "offset=-1"} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/files?"+query, nil))
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, query)
+	}
+}
@@ -0,0 +1,171 @@
+package pkg
+
+import (
+	"reflect"
+	"slices"
+	"sync"
+)
+
+const (
+	// RegistryEventAdded is sent for a file that was not listed
+	RegistryEventAdded = "added"
+	// RegistryEventRemoved is sent for a file no longer listed
+	RegistryEventRemoved = "removed"
+	// RegistryEventUpdated is sent for a listed file whose information changed
+	RegistryEventUpdated = "updated"
+
+	// registryEventsBuffered are the events a subscriber may lag behind before it is dropped
+	registryEventsBuffered = 256
+)
+
+// RegistryEvent is a change of the file list
+type RegistryEvent struct {
+	Type string
+	File FileInfo
+}
+
+// FileRegistry keeps the file list: the files of every source, grouped and with their defaults
+// applied. It is safe for concurrent use, the slices of Snapshot are never modified by it.
+type FileRegistry struct {
+	mutex       sync.RWMutex
+	fileInfos   []FileInfo
+	subscribers map[chan RegistryEvent]struct{}
+	// replaced, when set, is called with the file list after it was replaced or a file removed from it
+	replaced func(fileInfos []FileInfo)
+}
+
+func NewFileRegistry() *FileRegistry {
+	return &FileRegistry{subscribers: map[chan RegistryEvent]struct{}{}}
+}
+
+// registryKey tells the files of a registry apart, as AuthorizeFilePath does, and the files of a peer
+// by their type and host on it
+type registryKey struct {
+	filePath string
+	fileType string
+	host     string
+	peerType string
+	peerHost string
+}
+
+func newRegistryKey(fileInfo FileInfo) registryKey {
+	return registryKey{filePath: fileInfo.FilePath, fileType: fileInfo.Type, host: fileInfo.Host, peerType: fileInfo.PeerType, peerHost: fileInfo.PeerHost}
+}
+
+// Snapshot is the file list, callers must not modify it
+func (r *FileRegistry) Snapshot() []FileInfo {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.fileInfos
+}
+
+// Replace replaces the file list, sending an event for each file added, removed or updated
+func (r *FileRegistry) Replace(fileInfos []FileInfo) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	before := map[registryKey]FileInfo{}
+	for _, fileInfo := range r.fileInfos {
+		before[newRegistryKey(fileInfo)] = fileInfo
+	}
+	after := map[registryKey]struct{}{}
+	events := []RegistryEvent{}
+	for _, fileInfo := range fileInfos {
+		key := newRegistryKey(fileInfo)
+		after[key] = struct{}{}
+		previous, ok := before[key]
+		switch {
+		case !ok:
+			events = append(events, RegistryEvent{Type: RegistryEventAdded, File: fileInfo})
+		case !reflect.DeepEqual(previous, fileInfo):
+			events = append(events, RegistryEvent{Type: RegistryEventUpdated, File: fileInfo})
+		}
+	}
+	for _, fileInfo := range r.fileInfos {
+		if _, ok := after[newRegistryKey(fileInfo)]; !ok {
+			events = append(events, RegistryEvent{Type: RegistryEventRemoved, File: fileInfo})
+		}
+	}
+	// clipped, appending to a snapshot never writes to the list
+	r.fileInfos = slices.Clip(slices.Clone(fileInfos))
+	if r.replaced != nil {
+		r.replaced(r.fileInfos)
+	}
+	r.send(events)
+}
+
+// Upsert adds fileInfo to the file list, or updates the listed file of the same path, type and host
+func (r *FileRegistry) Upsert(fileInfo FileInfo) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	key := newRegistryKey(fileInfo)
+	i := slices.IndexFunc(r.fileInfos, func(listed FileInfo) bool { return newRegistryKey(listed) == key })
+	if i < 0 {
+		r.fileInfos = slices.Clip(append(slices.Clone(r.fileInfos), fileInfo))
+		r.send([]RegistryEvent{{Type: RegistryEventAdded, File: fileInfo}})
+		return
+	}
+	if reflect.DeepEqual(r.fileInfos[i], fileInfo) {
+		return
+	}
+	fileInfos := slices.Clone(r.fileInfos)
+	fileInfos[i] = fileInfo
+	r.fileInfos = fileInfos
+	r.send([]RegistryEvent{{Type: RegistryEventUpdated, File: fileInfo}})
+}
+
+// Remove removes the file of filePath, fileType and host from the file list, telling whether it was listed
+func (r *FileRegistry) Remove(filePath string, fileType string, host string) bool {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	key := registryKey{filePath: filePath, fileType: fileType, host: host}
+	i := slices.IndexFunc(r.fileInfos, func(listed FileInfo) bool { return newRegistryKey(listed) == key })
+	if i < 0 {
+		return false
+	}
+	removed := r.fileInfos[i]
+	r.fileInfos = slices.Delete(slices.Clone(r.fileInfos), i, i+1)
+	if r.replaced != nil {
+		r.replaced(r.fileInfos)
+	}
+	r.send([]RegistryEvent{{Type: RegistryEventRemoved, File: removed}})
+	return true
+}
+
+// Subscribe returns a channel receiving the events of the changes made from then on. A subscriber
+// lagging more than registryEventsBuffered events behind is dropped and its channel closed, it then
+// subscribes again and reads the Snapshot.
+func (r *FileRegistry) Subscribe() <-chan RegistryEvent {
+	events := make(chan RegistryEvent, registryEventsBuffered)
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.subscribers[events] = struct{}{}
+	return events
+}
+
+// Unsubscribe stops sending events to a channel of Subscribe and closes it
+func (r *FileRegistry) Unsubscribe(events <-chan RegistryEvent) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	for subscriber := range r.subscribers {
+		if subscriber == events {
+			delete(r.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+}
+
+// send is called with the mutex held, so that subscribers get the events in the order of the changes
+func (r *FileRegistry) send(events []RegistryEvent) {
+	for subscriber := range r.subscribers {
+		for _, event := range events {
+			select {
+			case subscriber <- event:
+				continue
+			default:
+			}
+			delete(r.subscribers, subscriber)
+			close(subscriber)
+			break
+		}
+	}
+}
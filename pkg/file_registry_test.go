@@ -0,0 +1,107 @@
+package pkg
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func receiveRegistryEvents(events <-chan RegistryEvent) []RegistryEvent {
+	received := []RegistryEvent{}
+	for {
+		select {
+		case event, ok := <-events:
+			if !ok {
+				return received
+			}
+			received = append(received, event)
+		default:
+			return received
+		}
+	}
+}
+
+func TestFileRegistry(t *testing.T) {
+	registry := NewFileRegistry()
+	events := registry.Subscribe()
+	defer registry.Unsubscribe(events)
+
+	a := FileInfo{FilePath: "/var/log/a.log", Type: TypeFile, LinesCount: 1}
+	b := FileInfo{FilePath: "/var/log/b.log", Type: TypeFile}
+	web1 := FileInfo{FilePath: "/var/log/a.log", Type: TypeSSH, Host: "web1"}
+	registry.Replace([]FileInfo{a, b})
+	assert.Equal(t, []FileInfo{a, b}, registry.Snapshot())
+	assert.Equal(t, []RegistryEvent{{RegistryEventAdded, a}, {RegistryEventAdded, b}}, receiveRegistryEvents(events))
+
+	// a snapshot is not changed by the changes after it, nor by appending to it
+	snapshot := registry.Snapshot()
+	_ = append(snapshot, web1)
+	grown := a
+	grown.LinesCount = 2
+	registry.Replace([]FileInfo{grown, web1})
+	assert.Equal(t, []FileInfo{a, b}, snapshot)
+	assert.Equal(t, []RegistryEvent{{RegistryEventUpdated, grown}, {RegistryEventAdded, web1}, {RegistryEventRemoved, b}}, receiveRegistryEvents(events))
+
+	// a file of the same path is another file for another type or host
+	registry.Upsert(b)
+	registry.Upsert(b)
+	registry.Upsert(a)
+	assert.Equal(t, []FileInfo{a, web1, b}, registry.Snapshot())
+	assert.Equal(t, []RegistryEvent{{RegistryEventAdded, b}, {RegistryEventUpdated, a}}, receiveRegistryEvents(events))
+
+	assert.False(t, registry.Remove("/var/log/a.log", TypeSSH, "web2"))
+	assert.True(t, registry.Remove("/var/log/a.log", TypeSSH, "web1"))
+	assert.Equal(t, []FileInfo{a, b}, registry.Snapshot())
+	assert.Equal(t, []RegistryEvent{{RegistryEventRemoved, web1}}, receiveRegistryEvents(events))
+}
+
+func TestFileRegistry_LaggingSubscriber(t *testing.T) {
+	registry := NewFileRegistry()
+	lagging := registry.Subscribe()
+	fileInfos := []FileInfo{}
+	for i := 0; i <= registryEventsBuffered; i++ {
+		fileInfos = append(fileInfos, FileInfo{FilePath: fmt.Sprintf("/var/log/%d.log", i), Type: TypeFile})
+	}
+	registry.Replace(fileInfos)
+
+	// the subscriber gets the events buffered, then its channel is closed
+	assert.Len(t, receiveRegistryEvents(lagging), registryEventsBuffered)
+	_, ok := <-lagging
+	assert.False(t, ok)
+	registry.Unsubscribe(lagging)
+}
+
+func TestFileRegistry_Concurrent(t *testing.T) {
+	registry := NewFileRegistry()
+	events := registry.Subscribe()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for range events {
+		}
+	}()
+
+	wg := sync.WaitGroup{}
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			fileInfo := FileInfo{FilePath: fmt.Sprintf("/var/log/%d.log", i), Type: TypeFile}
+			for j := 0; j < 100; j++ {
+				registry.Upsert(fileInfo)
+				for _, listed := range registry.Snapshot() {
+					_ = listed.FilePath
+				}
+				registry.Remove(fileInfo.FilePath, fileInfo.Type, fileInfo.Host)
+				if j%10 == 0 {
+					registry.Replace(append(registry.Snapshot(), fileInfo))
+				}
+			}
+		}(i)
+	}
+	wg.Wait()
+	registry.Unsubscribe(events)
+	<-done
+}
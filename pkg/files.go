@@ -3,222 +3,533 @@ package pkg
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
+	"context"
+	"crypto/sha1" // nolint: gosec
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"log/slog"
-	"net/http"
+	"net"
 	"os"
 	"path/filepath"
 	"slices"
+	"sort"
+	"strconv"
 	"strings"
-	"unicode/utf8"
+	"time"
 
 	"golang.org/x/crypto/ssh"
 )
 
 // IsReadableFile checks if the file is readable and optionally checks for valid UTF-8 encoded content
+//
+// Deprecated: use IsReadableFileContext.
 func IsReadableFile(filename string, isRemote bool, sshConfig *SSHConfig, checkUTF8 bool) (bool, error) {
-	var file *os.File
+	return IsReadableFileContext(context.Background(), filename, isRemote, sshConfig, checkUTF8)
+}
+
+// IsReadableFileContext checks if the file is readable and optionally checks for valid UTF-8 encoded content.
+// Remote files are streamed, only their first bytes are transferred.
+func IsReadableFileContext(ctx context.Context, filename string, isRemote bool, sshConfig *SSHConfig, checkUTF8 bool) (bool, error) {
+	var file io.ReadCloser
 	var err error
 
+	// a named pipe is not sniffed, the lines read would be lost to gol
+	if !isRemote && IsFIFO(filename) {
+		return true, nil
+	}
 	if isRemote {
-		file, err = sshOpenFile(filename, sshConfig)
+		file, err = sshStreamFile(ctx, filename, sshConfig)
 	} else {
-		file, err = os.Open(filename)
+		file, err = GlobalFileOpener.Open(filename)
 	}
 	if err != nil {
 		return false, err
 	}
 	defer file.Close()
+	return isReadable(file, checkUTF8)
+}
 
-	// Check if the file is empty
-	fileInfo, err := file.Stat()
+// isReadable sniffs the first bytes of r, decompressed when it is compressed
+func isReadable(r io.Reader, checkUTF8 bool) (bool, error) {
+	reader, _, err := NewDecompressingReader(r)
 	if err != nil {
 		return false, err
 	}
-	if fileInfo.Size() == 0 {
-		return true, nil
-	}
-
 	buffer := make([]byte, 512)
-	n, err := file.Read(buffer)
-	if err != nil {
+	n, err := io.ReadFull(reader, buffer)
+	if err != nil && !errors.Is(err, io.EOF) && !errors.Is(err, io.ErrUnexpectedEOF) {
 		return false, err
 	}
-
-	// Check if the file is gzip compressed
-	if IsGzip(buffer[:n]) {
-		_, err = file.Seek(0, io.SeekStart) // Reset file pointer
-		if err != nil {
-			return false, err
-		}
-
-		gzipReader, err := gzip.NewReader(file)
-		if err != nil {
-			return false, err
-		}
-		defer gzipReader.Close()
-
-		n, err = gzipReader.Read(buffer)
-		if err != nil && !errors.Is(err, io.EOF) {
-			return false, err
-		}
-
-		if checkUTF8 {
-			return utf8.Valid(buffer[:n]), nil
-		}
+	buffer = buffer[:n]
+	// Check if the file is empty
+	if len(buffer) == 0 {
 		return true, nil
 	}
 
 	if checkUTF8 {
-		return utf8.Valid(buffer[:n]), nil
+		return isValidText(buffer), nil
 	}
 	return true, nil
 }
 
+// isValidText checks the sniffed bytes are text once transcoded from their encoding. Invalid and control
+// bytes are replaced as the lines are returned, only content that is mostly not text is refused.
+func isValidText(buffer []byte) bool {
+	encoding := DetectEncoding(buffer)
+	if encoding == EncodingUTF16LE || encoding == EncodingUTF16BE {
+		// the sniffed buffer may end in the middle of a code unit
+		buffer = buffer[:len(buffer)&^1]
+	}
+	text := trimPartialRune(transcodeBytes(buffer, encoding))
+	_, replaced := SanitizeContent(string(text))
+	return !isBinaryShare(replaced, len(text))
+}
+
 // IsGzip checks if the given buffer starts with the gzip magic number
 func IsGzip(buffer []byte) bool {
 	return len(buffer) >= 2 && buffer[0] == 0x1f && buffer[1] == 0x8b
 }
 
+// Deprecated: use FilesByPatternContext.
 func FilesByPattern(pattern string, isRemote bool, sshConfig *SSHConfig) ([]string, error) {
+	return FilesByPatternContext(context.Background(), pattern, isRemote, sshConfig)
+}
+
+// FilesByPatternContext lists the files matching a glob pattern, or all the files under a directory
+func FilesByPatternContext(ctx context.Context, pattern string, isRemote bool, sshConfig *SSHConfig) ([]string, error) {
 	if isRemote {
-		return sshFilesByPattern(pattern, sshConfig)
+		return sshFilesByPattern(ctx, pattern, sshConfig)
 	}
 
 	// Check if the pattern is a directory
-	info, err := os.Stat(pattern)
+	info, err := GlobalFileOpener.Stat(pattern)
 	if err == nil && info.IsDir() {
-		// List all files in the directory
+		// List all files in the directory, following symlinked directories once each. The files under
+		// a symlink are listed under it, the path the user configured.
 		var files []string
-		err := filepath.Walk(pattern, func(path string, info os.FileInfo, err error) error {
-			if err != nil {
-				return err
-			}
-			if !info.IsDir() {
+		visited := map[string]bool{}
+		var walk func(root string, shown string) error
+		walk = func(root string, shown string) error {
+			return GlobalFileOpener.WalkDir(root, func(path string, d fs.DirEntry, err error) error {
+				if err != nil {
+					if path == root {
+						return err
+					}
+					// an unreadable directory or a file removed meanwhile does not stop the walk
+					slog.Warn("walking directory", "path", path, "error", err)
+					return nil
+				}
+				if err := ctx.Err(); err != nil {
+					return err
+				}
+				path = shown + strings.TrimPrefix(path, root)
+				if d.IsDir() {
+					if identity := fileIdentity(path); identity != "" {
+						if visited[identity] {
+							slog.Warn("directory listed already, through a symlink", "path", path)
+							return fs.SkipDir
+						}
+						visited[identity] = true
+					}
+					return nil
+				}
+				if resolver, ok := GlobalFileOpener.(SymlinkResolver); ok && d.Type()&fs.ModeSymlink != 0 {
+					if info, err := GlobalFileOpener.Stat(path); err == nil && info.IsDir() {
+						target, err := resolver.EvalSymlinks(path)
+						if err != nil {
+							slog.Warn("resolving symlink", "path", path, "error", err)
+							return nil
+						}
+						return walk(target, path)
+					}
+				}
 				files = append(files, path)
-			}
-			return nil
-		})
-		if err != nil {
+				return nil
+			})
+		}
+		if err := walk(pattern, pattern); err != nil {
 			return nil, err
 		}
 		return files, nil
 	}
 
 	// If pattern is not a directory, use Glob to match the pattern
-	files, err := filepath.Glob(pattern)
+	files, err := GlobalFileOpener.Glob(pattern)
 	if err != nil {
 		return nil, err
 	}
-	return files, nil
-}
-
-func detectMimeType(file *os.File) (string, error) {
-	buffer := make([]byte, 512)
-	_, err := file.Read(buffer)
-	if err != nil {
-		return "", err
-	}
-	// Reset the file pointer to the beginning of the file
-	_, err = file.Seek(0, 0)
-	if err != nil {
-		return "", err
-	}
-	return http.DetectContentType(buffer), nil
+	// directories matched, or symlinks to them, are not files to read
+	return slices.DeleteFunc(files, func(path string) bool {
+		info, err := GlobalFileOpener.Stat(path)
+		return err == nil && info.IsDir()
+	}), nil
 }
 
 // FileStats returns the number of lines and size of the file at the given path.
+//
+// Deprecated: use FileStatsContext.
 func FileStats(filePath string, isRemote bool, sshConfig *SSHConfig) (int, int64, error) {
-	var file *os.File
-	var err error
+	return FileStatsContext(context.Background(), filePath, isRemote, sshConfig)
+}
+
+// FileStatsContext returns the number of lines and size of the file at the given path.
+// The count is aborted with ctx's error as soon as ctx is done.
+func FileStatsContext(ctx context.Context, filePath string, isRemote bool, sshConfig *SSHConfig) (linesCount int, fileSize int64, err error) {
+	defer func(start time.Time) { GlobalMetrics.ObserveRead(ReadOpFileStats, start, err) }(time.Now())
+	return fileStats(ctx, filePath, isRemote, sshConfig)
+}
 
+func fileStats(ctx context.Context, filePath string, isRemote bool, sshConfig *SSHConfig) (int, int64, error) {
 	if isRemote {
-		file, err = sshOpenFile(filePath, sshConfig)
-	} else {
-		file, err = os.Open(filePath)
+		return remoteFileStats(ctx, filePath, sshConfig)
 	}
+	file, err := openFile(GlobalFileOpener, filePath)
 	if err != nil {
 		return 0, 0, err
 	}
 	defer file.Close()
 
-	mimeType, err := detectMimeType(file)
+	fileInfo, err := file.Stat()
 	if err != nil {
 		return 0, 0, err
 	}
+	fileSize := fileInfo.Size()
 
-	var reader *bufio.Reader
-	if mimeType == "application/x-gzip" {
-		gzReader, err := gzip.NewReader(file)
+	// an index of the file left by a previous run stands for the cached stats
+	loadLineIndex(filePath, fileInfo)
+
+	// files whose size, mtime, first bytes and symlink target did not change are not rescanned
+	fingerprint, err := Fingerprint(file)
+	if err != nil {
+		return 0, 0, err
+	}
+	target, err := symlinkTarget(filePath)
+	if err != nil {
+		return 0, 0, err
+	}
+	if entry, ok := GlobalFileStatsCache.Get(filePath, fileSize, fileInfo.ModTime().UnixNano(), fingerprint); ok && entry.Target == target {
+		return entry.LinesCount, fileSize, nil
+	}
+	// a file that only grew is counted from where the last count stopped
+	if entry, ok := GlobalFileStatsCache.Peek(filePath); ok {
+		counter, ok, err := countAppendedLines(ctx, file, entry, fileSize, target)
 		if err != nil {
 			return 0, 0, err
 		}
-		defer gzReader.Close()
-		reader = bufio.NewReader(gzReader)
-	} else {
-		reader = bufio.NewReader(file)
+		if ok {
+			setFileStats(filePath, fileInfo, fingerprint, target, counter)
+			return counter.total(), fileSize, nil
+		}
 	}
 
-	var linesCount int
-	scanner := bufio.NewScanner(reader)
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return 0, 0, err
+	}
+	decompressed, compression, err := NewDecompressingReader(file)
+	if err != nil {
+		return 0, 0, err
+	}
 
-	scanner := bufio.NewScanner(reader)
-        buf := make([]byte, 1024*1024) // 1MB buffer
-        scanner.Buffer(buf, len(buf))  // Increase the scanner buffer size
+	// the first scan of a large file is a job, reporting progress and cancellable
+	var job *JobTracker
+	if fileSize >= ScanJobMinSize {
+		total := fileSize
+		if compression != "" {
+			total = 0
+		}
+		ctx, job = GlobalJobs.Start(ctx, JobKindScan, filePath, total, true)
+	}
+	reader := utf8BufferedReader(NewContextReader(ctx, decompressed))
 
-	for scanner.Scan() {
-		linesCount++
+	counter := &lineCounter{checkpoints: []int64{}}
+	if err := finishJob(job, counter.count(reader, job)); err != nil {
+		return 0, 0, err
 	}
 
-	if err := scanner.Err(); err != nil {
+	setFileStats(filePath, fileInfo, fingerprint, target, counter)
+	return counter.total(), fileSize, nil
+}
+
+// remoteFileStats counts the lines of a remote file as it streams in, its size is the bytes streamed.
+// Remote counts are not cached.
+func remoteFileStats(ctx context.Context, filePath string, sshConfig *SSHConfig) (int, int64, error) {
+	stream, err := sshStreamFile(ctx, filePath, sshConfig)
+	if err != nil {
 		return 0, 0, err
 	}
+	defer stream.Close()
 
-	fileInfo, err := file.Stat()
+	counted := &countingReader{r: stream}
+	reader := bufio.NewReaderSize(counted, lineCountChunk)
+	head, err := reader.Peek(512)
+	if len(head) == 0 {
+		if err == nil {
+			err = io.EOF
+		}
+		return 0, 0, err
+	}
+	decoded, _, err := NewDecompressingReader(reader)
 	if err != nil {
 		return 0, 0, err
 	}
-	fileSize := fileInfo.Size()
 
-	return linesCount, fileSize, nil
+	counter := &lineCounter{}
+	if err := counter.count(utf8BufferedReader(NewContextReader(ctx, decoded)), nil); err != nil {
+		return 0, 0, err
+	}
+	return counter.total(), counted.n, nil
+}
+
+// countingReader counts the bytes read from r
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+func setFileStats(filePath string, fileInfo fs.FileInfo, fingerprint string, target string, counter *lineCounter) {
+	entry := FileStatsCacheEntry{
+		FilePath:    filePath,
+		Fingerprint: fingerprint,
+		Size:        fileInfo.Size(),
+		ModTime:     fileInfo.ModTime().UnixNano(),
+		LinesCount:  counter.total(),
+		Partial:     counter.partial,
+		Checkpoints: counter.checkpoints,
+		Generation:  nextGeneration(filePath, fileInfo.Size(), fingerprint, target),
+		Target:      target,
+	}
+	GlobalFileStatsCache.Set(entry)
+	saveLineIndex(entry, fileInfo)
+}
+
+// finishJob finishes job, when there is one, with err and returns err
+func finishJob(job *JobTracker, err error) error {
+	if job != nil {
+		job.Finish(err)
+	}
+	return err
+}
+
+// tailReadBlock is the size of the blocks ReadTailLines reads backwards from the end of a file
+const tailReadBlock = 64 * 1024
+
+// ReadTailLines returns the last n lines of the file at the given path, oldest first.
+func ReadTailLines(filePath string, n int, isRemote bool, sshConfig *SSHConfig) ([]string, error) {
+	return ReadTailLinesContext(context.Background(), filePath, n, isRemote, sshConfig)
+}
+
+// ReadTailLinesContext returns the last n lines of the file at the given path, oldest first. Plain
+// files are read backwards from their end a block at a time, lines longer than a block included.
+// Compressed and transcoded files cannot be read from their end, they are scanned from their start instead,
+// as remote files are while they stream in.
+func ReadTailLinesContext(ctx context.Context, filePath string, n int, isRemote bool, sshConfig *SSHConfig) ([]string, error) {
+	if n <= 0 {
+		return []string{}, nil
+	}
+	if isRemote {
+		stream, err := sshStreamFile(ctx, filePath, sshConfig)
+		if err != nil {
+			return nil, err
+		}
+		defer stream.Close()
+		reader, _, err := NewDecompressingReader(stream)
+		if err != nil {
+			return nil, err
+		}
+		return scanTailLines(ctx, reader, n)
+	}
+	file, err := openFile(GlobalFileOpener, filePath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, err
+	}
+	head := make([]byte, 512)
+	headSize, err := file.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	if IsCompressed(head[:headSize]) {
+		reader, _, err := NewDecompressingReader(file)
+		if err != nil {
+			return nil, err
+		}
+		return scanTailLines(ctx, reader, n)
+	}
+	if DetectEncoding(head[:headSize]) != EncodingUTF8 {
+		return scanTailLines(ctx, file, n)
+	}
+	return readTailLines(ctx, file, fileInfo.Size(), n)
+}
+
+// readTailLines reads the last n lines of a file of size bytes backwards from its end
+func readTailLines(ctx context.Context, file io.ReaderAt, size int64, n int) ([]string, error) {
+	lines := make([]string, 0, n)
+	// pending is the end of a line whose start is in a block not read yet
+	var pending []byte
+	offset := size
+	for offset > 0 && len(lines) < n {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		blockSize := min(int64(tailReadBlock), offset)
+		offset -= blockSize
+		block := make([]byte, blockSize)
+		if _, err := file.ReadAt(block, offset); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		if offset+blockSize == size {
+			// the newline ending the file does not start another line
+			block = bytes.TrimSuffix(block, []byte("\n"))
+		}
+		for len(lines) < n {
+			i := bytes.LastIndexByte(block, '\n')
+			if i < 0 {
+				pending = append(slices.Clone(block), pending...)
+				break
+			}
+			lines = append(lines, tailLine(block[i+1:], pending))
+			pending = nil
+			block = block[:i]
+		}
+	}
+	// the first line of the file has no newline before it
+	if offset == 0 && size > 0 && len(lines) < n {
+		lines = append(lines, tailLine(pending, nil))
+	}
+	slices.Reverse(lines)
+	return lines, nil
+}
+
+// tailLine joins the start and end of a line, without the carriage return the line scanners drop too
+func tailLine(start []byte, end []byte) string {
+	return strings.TrimSuffix(string(start)+string(end), "\r")
+}
+
+// scanTailLines reads r to its end keeping its last n lines
+func scanTailLines(ctx context.Context, r io.Reader, n int) ([]string, error) {
+	ring := make([]string, n)
+	count := 0
+	scanner := newLineScanner(utf8BufferedReader(NewContextReader(ctx, r)))
+	for scanner.Scan() {
+		ring[count%n] = scanner.Text()
+		count++
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if count <= n {
+		return ring[:count], nil
+	}
+	return append(ring[count%n:], ring[:count%n]...), nil
 }
 
+// Deprecated: use GetFileInfosContext.
 func GetFileInfos(pattern string, limit int, isRemote bool, sshConfig *SSHConfig) []FileInfo {
-	filePaths, err := FilesByPattern(pattern, isRemote, sshConfig)
+	fileInfos, _ := GetFileInfosContext(context.Background(), pattern, limit, isRemote, sshConfig)
+	return fileInfos
+}
+
+// GetFileInfosContext returns the stats of the files matching pattern. Errors of single files are logged
+// and the files skipped, only ctx being done or a full disk stops it, returning that error.
+func GetFileInfosContext(ctx context.Context, pattern string, limit int, isRemote bool, sshConfig *SSHConfig) ([]FileInfo, error) {
+	filePaths, err := FilesByPatternContext(ctx, pattern, isRemote, sshConfig)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return nil, ctxErr
+	}
 	if err != nil {
 		slog.Error("getting file paths by pattern", pattern, err)
-		return nil
+		return nil, nil
 	}
 	if len(filePaths) == 0 {
 		slog.Error("No files found", "pattern", pattern)
-		return nil
+		return nil, nil
 	}
 	fileInfos := make([]FileInfo, 0)
 	if len(filePaths) > limit {
 		slog.Warn("Limiting to files", "limit", limit)
 		filePaths = filePaths[:limit]
 	}
+	modTimes := map[string]time.Time{}
+	if isRemote {
+		modTimes = sshModTimes(ctx, filePaths, sshConfig)
+	}
 
 	for _, filePath := range filePaths {
-		isText, err := IsReadableFile(filePath, isRemote, sshConfig, false)
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		// a named pipe is listed as the temp file it is copied to
+		if !isRemote && IsFIFO(filePath) {
+			buffer, err := GlobalFIFOs.Buffer(filePath)
+			if err != nil {
+				slog.Error("reading named pipe", "filePath", filePath, "error", err)
+				continue
+			}
+			filePath = buffer
+		}
+		// a broken symlink is listed with a warning, the other files of the pattern are still listed
+		var target, canonical, compression string
+		if !isRemote {
+			if target, err = symlinkTarget(filePath); err != nil {
+				slog.Warn("File is a broken symlink", "filePath", filePath, "error", err)
+				fileInfos = append(fileInfos, FileInfo{FilePath: filePath, Type: TypeFile, Generation: FileGeneration(filePath), Warning: err.Error()})
+				continue
+			}
+			canonical = canonicalPath(filePath)
+			compression = FileCompression(filePath)
+		}
+		isText, err := IsReadableFileContext(ctx, filePath, isRemote, sshConfig, false)
+		if errors.Is(err, ErrDiskFull) {
+			return nil, err
+		}
+		corrupt := ""
+		if IsCorrupt(err) {
+			slog.Warn("File is corrupt", "filePath", filePath, "error", err)
+			corrupt, isText, err = err.Error(), true, nil
+		}
 		if err != nil {
 			slog.Error("checking if file is readable", filePath, err)
-			return nil
+			return nil, nil
 		}
 		if !isText {
 			slog.Warn("File is not a text file", "filePath", filePath)
 			continue
 		}
-		linesCount, fileSize, err := FileStats(filePath, isRemote, sshConfig)
+		var linesCount int
+		var fileSize int64
+		if corrupt == "" {
+			linesCount, fileSize, err = FileStatsContext(ctx, filePath, isRemote, sshConfig)
+		}
+		if ctxErr := ctx.Err(); ctxErr != nil {
+			return nil, ctxErr
+		}
+		if errors.Is(err, ErrDiskFull) {
+			return nil, err
+		}
 		if err != nil {
-			if errors.Is(err, io.EOF) {
+			switch {
+			case errors.Is(err, io.EOF):
 				slog.Warn("File is empty", "filePath", filePath)
 				linesCount = 0
 				fileSize = 0
-			} else {
+			case IsCorrupt(err):
+				slog.Warn("File is corrupt", "filePath", filePath, "error", err)
+				corrupt = err.Error()
+			default:
 				slog.Error("getting file stats", filePath, err)
 				continue
 			}
@@ -229,12 +540,27 @@ func GetFileInfos(pattern string, limit int, isRemote bool, sshConfig *SSHConfig
 			t = TypeSSH
 			h = sshConfig.Host
 		}
-		if filePath == GlobalPipeTmpFilePath {
+		pipe := stdinPipe(filePath)
+		if filePath == PipeTmpFilePath() || pipe != nil {
 			t = TypeStdin
 		}
-		fileInfos = append(fileInfos, FileInfo{FilePath: filePath, LinesCount: linesCount, FileSize: fileSize, Type: t, Host: h})
+		if !isRemote {
+			if info, err := GlobalFileOpener.Stat(filePath); err == nil && !info.ModTime().IsZero() {
+				modTimes[filePath] = info.ModTime()
+			}
+		}
+		var modTime *time.Time
+		if mtime, ok := modTimes[filePath]; ok {
+			modTime = &mtime
+		}
+		fileInfo := FileInfo{FilePath: filePath, LinesCount: linesCount, FileSize: fileSize, ModTime: modTime, Type: t, Host: h, Generation: FileGeneration(filePath), Corrupt: corrupt, Target: target, CanonicalPath: canonical, Compression: compression}
+		if pipe != nil {
+			fileInfo.Name = pipe.Name()
+			fileInfo.Dropped, fileInfo.Closed = pipe.Status()
+		}
+		fileInfos = append(fileInfos, fileInfo)
 	}
-	return fileInfos
+	return fileInfos, nil
 }
 
 // SSHConfig holds the SSH connection parameters
@@ -244,6 +570,9 @@ type SSHConfig struct {
 	User           string
 	Password       string
 	PrivateKeyPath string
+	// StrictHostKey verifies the host key against KnownHostsPath, the default known_hosts when empty
+	StrictHostKey  bool
+	KnownHostsPath string
 }
 
 type SSHPathConfig struct {
@@ -253,6 +582,9 @@ type SSHPathConfig struct {
 	Password       string
 	PrivateKeyPath string
 	FilePath       string
+	KnownHostsPath string
+	// Insecure skips the verification of the host key
+	Insecure bool
 }
 
 type DockerPathConfig struct {
@@ -260,22 +592,22 @@ type DockerPathConfig struct {
 	FilePath    string
 }
 
-// s is an input of the form "container_id /path/to/file"
+// s is an input of the form "container_id /path/to/file" for a file inside the container,
+// or "container_id" for its stdout and stderr
 func StringToDockerPathConfig(s string) (*DockerPathConfig, error) {
-	// Split the input string into parts
 	parts := strings.Fields(s)
-
-	// There should be 2 parts: "container_id" and "/path/to/file"
-	if len(parts) < 2 {
+	if len(parts) == 0 || len(parts) > 2 {
 		return nil, fmt.Errorf("input string does not have the correct format")
 	}
-	return &DockerPathConfig{
-		ContainerID: parts[0],
-		FilePath:    parts[1],
-	}, nil
+	config := &DockerPathConfig{ContainerID: parts[0]}
+	if len(parts) == 2 {
+		config.FilePath = parts[1]
+	}
+	return config, nil
 }
 
-// s is an input of the form "user@host[:port] [password=/path/to/password] [private_key=/path/to/key] /path/to/file"
+// s is an input of the form
+// "user@host[:port] [password=/path/to/password] [private_key=/path/to/key] [known_hosts=/path/to/known_hosts] [insecure=true] /path/to/file"
 func StringToSSHPathConfig(s string) (*SSHPathConfig, error) {
 	config := &SSHPathConfig{}
 
@@ -306,6 +638,7 @@ func StringToSSHPathConfig(s string) (*SSHPathConfig, error) {
 
 	// Default private key path
 	config.PrivateKeyPath = fmt.Sprintf("%s/.ssh/id_rsa", os.Getenv("HOME"))
+	config.KnownHostsPath = DefaultKnownHostsPath()
 
 	// Extract optional parts and file path
 	for _, part := range parts[1:] {
@@ -314,6 +647,14 @@ func StringToSSHPathConfig(s string) (*SSHPathConfig, error) {
 			config.Password = strings.TrimPrefix(part, "password=")
 		} else if strings.HasPrefix(part, "private_key=") {
 			config.PrivateKeyPath = strings.TrimPrefix(part, "private_key=")
+		} else if strings.HasPrefix(part, "known_hosts=") {
+			config.KnownHostsPath = strings.TrimPrefix(part, "known_hosts=")
+		} else if strings.HasPrefix(part, "insecure=") {
+			insecure, err := strconv.ParseBool(strings.TrimPrefix(part, "insecure="))
+			if err != nil {
+				return nil, fmt.Errorf("insecure must be true or false")
+			}
+			config.Insecure = insecure
 		} else {
 			config.FilePath = part
 		}
@@ -326,7 +667,12 @@ func StringToSSHPathConfig(s string) (*SSHPathConfig, error) {
 	return config, nil
 }
 
-func sshConnect(config *SSHConfig) (*ssh.Client, error) {
+func sshConnect(ctx context.Context, config *SSHConfig) (client *ssh.Client, err error) {
+	defer func() {
+		if err != nil {
+			GlobalMetrics.SSHDialFailed(config.Host)
+		}
+	}()
 	var auth []ssh.AuthMethod
 
 	if config.Password != "" {
@@ -344,78 +690,283 @@ func sshConnect(config *SSHConfig) (*ssh.Client, error) {
 		auth = append(auth, ssh.PublicKeys(signer))
 	}
 
+	callback, err := hostKeyCallback(config)
+	if err != nil {
+		return nil, err
+	}
 	clientConfig := &ssh.ClientConfig{
 		User:            config.User,
 		Auth:            auth,
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // nolint:gosec
+		HostKeyCallback: callback,
 	}
 
-	client, err := ssh.Dial("tcp", config.Host+":"+config.Port, clientConfig)
+	addr := net.JoinHostPort(config.Host, config.Port)
+	var dialer net.Dialer
+	conn, err := dialer.DialContext(ctx, "tcp", addr)
 	if err != nil {
 		return nil, err
 	}
+	// the handshake is bound to ctx too, closing the connection aborts it
+	stop := context.AfterFunc(ctx, func() { conn.Close() })
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, clientConfig)
+	if !stop() {
+		if err == nil {
+			c.Close()
+		}
+		return nil, ctx.Err()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return ssh.NewClient(c, chans, reqs), nil
+}
 
-	return client, nil
+// sshStreamFile streams the content of a remote file, closing it ends the transfer
+func sshStreamFile(ctx context.Context, filename string, config *SSHConfig) (io.ReadCloser, error) {
+	return remoteStream(ctx, config, "cat "+filename)
 }
 
-func sshOpenFile(filename string, config *SSHConfig) (*os.File, error) {
-	session, err := NewSession(config)
+// sshCopyChunk is how much of a remote file is copied between two checks of the free disk space
+const sshCopyChunk = 16 << 20
+
+// sshOpenFile copies a remote file to a temp file, for the reads that need random access. The copy
+// is streamed to the disk, which is checked for free space as it fills.
+func sshOpenFile(ctx context.Context, filename string, config *SSHConfig) (File, error) {
+	if err := EnsureFreeDisk(TmpDir(), 0); err != nil {
+		return nil, fmt.Errorf("copying %s of %s: %w", filename, config.Host, err)
+	}
+	stream, err := sshStreamFile(ctx, filename, config)
 	if err != nil {
 		return nil, err
 	}
-	defer session.Close()
+	defer stream.Close()
 
 	tmpFile, err := os.Create(GetTmpFileNameForSTDIN())
 	if err != nil {
 		return nil, err
 	}
+	copied := &tmpCopy{File: tmpFile}
 
-	// Execute the cat command to read the file
-	var stdout bytes.Buffer
-	session.Stdout = &stdout
-	if err := session.Run("cat " + filename); err != nil {
-		if err.Error() != ErrorMsgSessionAlreadyStarted {
+	for {
+		if err := EnsureFreeDisk(TmpDir(), sshCopyChunk); err != nil {
+			copied.Close()
+			return nil, fmt.Errorf("copying %s of %s: %w", filename, config.Host, err)
+		}
+		n, err := io.CopyN(tmpFile, stream, sshCopyChunk)
+		if errors.Is(err, io.EOF) || (err == nil && n < sshCopyChunk) {
+			break
+		}
+		if err != nil {
+			copied.Close()
 			return nil, err
 		}
 	}
 
-	// Write the remote file content to the temporary file
-	if _, err := tmpFile.Write(stdout.Bytes()); err != nil {
-		return nil, err
-	}
-
-	// Seek to the beginning of the temporary file
 	if _, err := tmpFile.Seek(0, io.SeekStart); err != nil {
+		copied.Close()
 		return nil, err
 	}
+	return copied, nil
+}
+
+// tmpCopy is a temp copy of a remote file, removed once closed
+type tmpCopy struct {
+	*os.File
+}
 
-	return tmpFile, nil
+func (f *tmpCopy) Close() error {
+	err := f.File.Close()
+	if removeErr := os.Remove(f.Name()); removeErr != nil && err == nil {
+		err = removeErr
+	}
+	return err
 }
 
-func sshFilesByPattern(pattern string, config *SSHConfig) ([]string, error) {
-	session, err := NewSession(config)
+func sshFilesByPattern(ctx context.Context, pattern string, config *SSHConfig) ([]string, error) {
+	// Execute the ls command to list files matching the pattern
+	output, err := GlobalRemoteRunner.Run(ctx, config, "ls "+pattern)
 	if err != nil {
 		return nil, err
 	}
-	defer session.Close()
+	return strings.Split(strings.TrimSpace(string(output)), "\n"), nil
+}
 
-	var buf bytes.Buffer
-	session.Stdout = &buf
+// sshModTimes stats the remote files in one command, the files it could not stat have no time
+func sshModTimes(ctx context.Context, filePaths []string, config *SSHConfig) map[string]time.Time {
+	// stat fails for a file gone since it was listed, the times of the others are still printed
+	output, err := GlobalRemoteRunner.Run(ctx, config, "stat -c '%Y %n' "+strings.Join(filePaths, " "))
+	if err != nil {
+		slog.Warn("getting modification times", "host", config.Host, "error", err)
+	}
+	modTimes := map[string]time.Time{}
+	for _, line := range strings.Split(string(output), "\n") {
+		seconds, filePath, ok := strings.Cut(line, " ")
+		unix, err := strconv.ParseInt(seconds, 10, 64)
+		if ok && err == nil {
+			modTimes[filePath] = time.Unix(unix, 0)
+		}
+	}
+	return modTimes
+}
 
-	// Execute the ls command to list files matching the pattern
-	if err := session.Run("ls " + pattern); err != nil {
-		if err.Error() != ErrorMsgSessionAlreadyStarted {
-			return nil, err
+// FileID is the stable ID of a file, the same path, host and type always get the same ID
+func FileID(filePath string, host string, sourceType string) string {
+	sum := sha1.Sum([]byte(sourceType + "|" + host + "|" + filePath)) // nolint: gosec
+	return hex.EncodeToString(sum[:8])
+}
+
+// SetFileIDs sets the ID of the files and their segments. The ID of a remote file includes its type and
+// host on the peer.
+func SetFileIDs(fileInfos []FileInfo) []FileInfo {
+	for i := range fileInfos {
+		host := fileInfos[i].Host
+		if fileInfos[i].PeerType != "" || fileInfos[i].PeerHost != "" {
+			host += "|" + fileInfos[i].PeerType + "|" + fileInfos[i].PeerHost
 		}
+		fileInfos[i].ID = FileID(fileInfos[i].FilePath, host, fileInfos[i].Type)
+		SetFileIDs(fileInfos[i].Segments)
 	}
+	return fileInfos
+}
 
-	filePaths := buf.String()
-	return strings.Split(strings.TrimSpace(filePaths), "\n"), nil
+// SortFileInfos sorts by label, then host, then path in natural order, so the list does not reshuffle
+// between watch cycles when sources resolve in a different order
+func SortFileInfos(fileInfos []FileInfo) []FileInfo {
+	return SortFileInfosBy(fileInfos, CompareNatural)
 }
 
+// SortFileInfosBy sorts by label, then host, then path with compare
+func SortFileInfosBy(fileInfos []FileInfo, compare func(a, b string) int) []FileInfo {
+	slices.SortStableFunc(fileInfos, fileInfoOrder(compare))
+	return fileInfos
+}
+
+// fileInfoOrder compares file infos by label, then host, then path with compare
+func fileInfoOrder(compare func(a, b string) int) func(a, b FileInfo) int {
+	return func(a, b FileInfo) int {
+		if a.Name != b.Name {
+			return compare(a.Name, b.Name)
+		}
+		if a.Host != b.Host {
+			return compare(a.Host, b.Host)
+		}
+		if a.FilePath != b.FilePath {
+			return compare(a.FilePath, b.FilePath)
+		}
+		return strings.Compare(a.Type, b.Type)
+	}
+}
+
+// UniqueFileInfos drops the files listed before with the same type, host and canonical path, the path
+// they resolve to through symlinks
 func UniqueFileInfos(fileInfos []FileInfo) []FileInfo {
-	eq := func(a, b FileInfo) bool {
-		return a.FilePath == b.FilePath && a.Type == b.Type && a.Host == b.Host
+	seen := map[[3]string]bool{}
+	return slices.DeleteFunc(fileInfos, func(fileInfo FileInfo) bool {
+		filePath := fileInfo.FilePath
+		if fileInfo.CanonicalPath != "" {
+			filePath = fileInfo.CanonicalPath
+		}
+		key := [3]string{fileInfo.Type, fileInfo.Host, filePath}
+		if seen[key] {
+			return true
+		}
+		seen[key] = true
+		return false
+	})
+}
+
+// ExcludeFileInfos drops the files matching one of the excludes globs. A glob with a / is matched
+// against the path, one without against the file name, so "*.gz" excludes the gzipped files of every
+// pattern.
+func ExcludeFileInfos(fileInfos []FileInfo, excludes []string) []FileInfo {
+	if len(excludes) == 0 {
+		return fileInfos
+	}
+	return slices.DeleteFunc(fileInfos, func(fileInfo FileInfo) bool {
+		for _, exclude := range excludes {
+			name := fileInfo.FilePath
+			if !strings.Contains(exclude, "/") {
+				name = filepath.Base(name)
+			}
+			if matched, err := filepath.Match(exclude, name); err == nil && matched {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// MergeDuplicateFileInfos merges the local files reached by more than one path, through symlinks,
+// hard links or overlapping patterns, so that the same file is not listed, counted and watched twice.
+// The shortest path is kept, the first one listed on a tie, the others are its Aliases.
+func MergeDuplicateFileInfos(fileInfos []FileInfo) []FileInfo {
+	merged := make([]FileInfo, 0, len(fileInfos))
+	byIdentity := map[string]int{}
+	for _, fileInfo := range fileInfos {
+		identity := ""
+		if fileInfo.Type == TypeFile && fileInfo.Warning == "" {
+			identity = fileIdentity(fileInfo.FilePath)
+		}
+		i, ok := byIdentity[identity]
+		if identity == "" || !ok {
+			if identity != "" {
+				byIdentity[identity] = len(merged)
+			}
+			merged = append(merged, fileInfo)
+			continue
+		}
+		if merged[i].FilePath == fileInfo.FilePath {
+			continue
+		}
+		canonical, alias := merged[i], fileInfo
+		if len(alias.FilePath) < len(canonical.FilePath) {
+			canonical, alias = alias, canonical
+		}
+		if canonical.Group == "" {
+			canonical.Group = alias.Group
+		}
+		canonical.Aliases = append(append(canonical.Aliases, alias.FilePath), alias.Aliases...)
+		sort.Strings(canonical.Aliases)
+		merged[i] = canonical
+	}
+	return merged
+}
+
+// fileIdentity identifies the file behind filePath by its device and inode, or by the path it
+// resolves to where the file system has no inodes. It is empty when the file cannot be stat'ed.
+func fileIdentity(filePath string) string {
+	info, err := GlobalFileOpener.Stat(filePath)
+	if err != nil {
+		return ""
+	}
+	if id, ok := fileSysID(info); ok {
+		return id
+	}
+	if resolver, ok := GlobalFileOpener.(SymlinkResolver); ok {
+		if target, err := resolver.EvalSymlinks(filePath); err == nil {
+			filePath = target
+		}
+	}
+	if abs, err := filepath.Abs(filePath); err == nil {
+		filePath = abs
+	}
+	return "path:" + filePath
+}
+
+// ContextReader fails reads with ctx's error once ctx is done, so long reads stop between chunks
+type ContextReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func NewContextReader(ctx context.Context, r io.Reader) *ContextReader {
+	return &ContextReader{ctx: ctx, r: r}
+}
+
+func (r *ContextReader) Read(p []byte) (int, error) {
+	if err := r.ctx.Err(); err != nil {
+		return 0, err
 	}
-	return slices.CompactFunc(fileInfos, eq)
+	return r.r.Read(p)
 }
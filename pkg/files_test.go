@@ -3,9 +3,15 @@ package pkg
 import (
 	"bytes"
 	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"slices"
+	"strings"
 	"testing"
+	"time"
 )
 
 func TestIsReadableFile(t *testing.T) {
@@ -118,3 +124,319 @@ func TestFilesByPattern(t *testing.T) {
 		})
 	}
 }
+
+// endlessReader never runs out of lines, a scan over it only ends through its context
+type endlessReader struct {
+	reads int
+}
+
+func (r *endlessReader) Read(p []byte) (int, error) {
+	r.reads++
+	for i := range p {
+		p[i] = 'x'
+		if i%80 == 79 {
+			p[i] = '\n'
+		}
+	}
+	return len(p), nil
+}
+
+func TestContextReader(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	endless := &endlessReader{}
+	scanner := newLineScanner(NewContextReader(ctx, endless))
+	lines, readsAtCancel := 0, 0
+	for scanner.Scan() {
+		lines++
+		if lines == 1000 {
+			cancel()
+			readsAtCancel = endless.reads
+		}
+	}
+	if !errors.Is(scanner.Err(), context.Canceled) {
+		t.Fatalf("scan error = %v, want %v", scanner.Err(), context.Canceled)
+	}
+	// the buffered chunk is scanned through, nothing more is read
+	if endless.reads != readsAtCancel {
+		t.Errorf("read %d chunks after cancel", endless.reads-readsAtCancel)
+	}
+}
+
+func TestFileStatsContext_Canceled(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "big.log")
+	if err := os.WriteFile(logFile, bytes.Repeat([]byte("INFO a line of a big file\n"), 200000), 0600); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	started := time.Now()
+	if _, _, err := FileStatsContext(ctx, logFile, false, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("FileStatsContext error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := GetFileInfosContext(ctx, filepath.Join(dir, "*.log"), 10, false, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("GetFileInfosContext error = %v, want %v", err, context.Canceled)
+	}
+	if _, err := FilesByPatternContext(ctx, dir, false, nil); !errors.Is(err, context.Canceled) {
+		t.Errorf("FilesByPatternContext error = %v, want %v", err, context.Canceled)
+	}
+	if elapsed := time.Since(started); elapsed > time.Second {
+		t.Errorf("canceled scans took %s", elapsed)
+	}
+	if _, ok := GlobalFileStatsCache.Peek(logFile); ok {
+		t.Errorf("aborted scan was cached")
+	}
+
+	fileInfos, err := GetFileInfosContext(context.Background(), logFile, 10, false, nil)
+	if err != nil || len(fileInfos) != 1 || fileInfos[0].LinesCount != 200000 {
+		t.Errorf("GetFileInfosContext = %v, %v", fileInfos, err)
+	}
+}
+
+func TestGetFileInfosContext_Symlinks(t *testing.T) {
+	dir := t.TempDir()
+	first := filepath.Join(dir, "app-2024-06-01.txt")
+	second := filepath.Join(dir, "app-2024-06-02.txt")
+	current := filepath.Join(dir, "current.log")
+	broken := filepath.Join(dir, "broken.log")
+	if err := os.WriteFile(first, bytes.Repeat([]byte("INFO first day\n"), 10), 0600); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	if err := os.WriteFile(second, bytes.Repeat([]byte("INFO second day\n"), 20), 0600); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	if err := os.Symlink(first, current); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	if err := os.Symlink(filepath.Join(dir, "gone.txt"), broken); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+
+	list := func() map[string]FileInfo {
+		fileInfos, err := GetFileInfosContext(context.Background(), filepath.Join(dir, "*.log"), 10, false, nil)
+		if err != nil {
+			t.Fatalf("GetFileInfosContext error = %v", err)
+		}
+		byPath := map[string]FileInfo{}
+		for _, fileInfo := range fileInfos {
+			byPath[fileInfo.FilePath] = fileInfo
+		}
+		return byPath
+	}
+
+	// the broken symlink does not hide the rest of the pattern
+	fileInfos := list()
+	if len(fileInfos) != 2 {
+		t.Fatalf("GetFileInfosContext = %v, want current.log and broken.log", fileInfos)
+	}
+	if fileInfos[broken].Warning == "" {
+		t.Errorf("broken symlink has no warning")
+	}
+	wantTarget, _ := filepath.EvalSymlinks(first)
+	if got := fileInfos[current]; got.Target != wantTarget || got.LinesCount != 10 {
+		t.Errorf("current.log = %+v, want target %s and 10 lines", got, wantTarget)
+	}
+	generation := fileInfos[current].Generation
+
+	// retargeting the link is a rotation, even to a larger file too small to be fingerprinted
+	if err := os.Remove(current); err != nil {
+		t.Fatalf("failed to remove symlink: %v", err)
+	}
+	if err := os.Symlink(second, current); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	wantTarget, _ = filepath.EvalSymlinks(second)
+	got := list()[current]
+	if got.Target != wantTarget || got.LinesCount != 20 || got.Generation != generation+1 {
+		t.Errorf("retargeted current.log = %+v, want target %s, 20 lines and generation %d", got, wantTarget, generation+1)
+	}
+}
+
+func TestUpdateGlobalFilePaths_MergesDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	appDir := filepath.Join(dir, "data", "applications")
+	if err := os.MkdirAll(appDir, 0700); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	real := filepath.Join(appDir, "app.log")
+	hardLink := filepath.Join(appDir, "app-hard.log")
+	link := filepath.Join(dir, "app.log")
+	other := filepath.Join(dir, "other.log")
+	for _, filePath := range []string{real, other} {
+		if err := os.WriteFile(filePath, []byte("INFO a\nINFO b\n"), 0600); err != nil {
+			t.Fatalf("failed to create file: %v", err)
+		}
+	}
+	if err := os.Link(real, hardLink); err != nil {
+		t.Fatalf("failed to create hard link: %v", err)
+	}
+	if err := os.Symlink(real, link); err != nil {
+		t.Fatalf("failed to create symlink: %v", err)
+	}
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+
+	// the file is reached through a symlink, a hard link and two patterns, it is listed once
+	UpdateGlobalFilePaths(SliceFlags{filepath.Join(appDir, "*.log"), filepath.Join(dir, "*.log")}, nil, nil, 10)
+	fileInfos := GlobalFileRegistry.Snapshot()
+	if len(fileInfos) != 2 {
+		t.Fatalf("FilePaths = %+v, want app.log and other.log", fileInfos)
+	}
+	byPath := map[string]FileInfo{}
+	for _, fileInfo := range fileInfos {
+		byPath[fileInfo.FilePath] = fileInfo
+	}
+	got, ok := byPath[link]
+	if !ok || got.LinesCount != 2 {
+		t.Fatalf("FilePaths = %+v, want %s with 2 lines", fileInfos, link)
+	}
+	if want := []string{hardLink, real}; !slices.Equal(got.Aliases, want) {
+		t.Errorf("aliases = %v, want %v", got.Aliases, want)
+	}
+	if len(byPath[other].Aliases) != 0 {
+		t.Errorf("other.log has aliases %v", byPath[other].Aliases)
+	}
+	// a path typed by the user is found under its alias
+	if !FilePathInGlobalFilePaths(real) {
+		t.Errorf("%s is not found by its alias", real)
+	}
+}
+
+func TestExcludeFileInfos(t *testing.T) {
+	fileInfos := []FileInfo{
+		{FilePath: "/var/log/app.log", Type: TypeFile},
+		{FilePath: "/var/log/app.log.1.gz", Type: TypeFile},
+		{FilePath: "/var/log/nginx/old-access.log", Type: TypeFile},
+		{FilePath: "/var/log/nginx/access.log", Type: TypeFile},
+		{FilePath: "/srv/app/old-access.log", Type: TypeSSH, Host: "web1"},
+	}
+	if kept := ExcludeFileInfos(append([]FileInfo{}, fileInfos...), nil); len(kept) != len(fileInfos) {
+		t.Errorf("ExcludeFileInfos without excludes kept %d files, want %d", len(kept), len(fileInfos))
+	}
+	// a glob without a / matches the file name, one with a / the path
+	kept := ExcludeFileInfos(append([]FileInfo{}, fileInfos...), []string{"*.gz", "/var/log/nginx/old-*"})
+	filePaths := []string{}
+	for _, fileInfo := range kept {
+		filePaths = append(filePaths, fileInfo.FilePath)
+	}
+	want := []string{"/var/log/app.log", "/var/log/nginx/access.log", "/srv/app/old-access.log"}
+	if !slices.Equal(filePaths, want) {
+		t.Errorf("ExcludeFileInfos kept %v, want %v", filePaths, want)
+	}
+}
+
+func TestFilesByPatternContext_SymlinkedDirs(t *testing.T) {
+	dir := t.TempDir()
+	logs := filepath.Join(dir, "logs")
+	dated := filepath.Join(logs, "2024-01-02")
+	if err := os.MkdirAll(dated, 0700); err != nil {
+		t.Fatalf("failed to create dir: %v", err)
+	}
+	if err := os.WriteFile(filepath.Join(dated, "app.log"), []byte("INFO a\n"), 0600); err != nil {
+		t.Fatalf("failed to create file: %v", err)
+	}
+	current := filepath.Join(logs, "current")
+	for link, target := range map[string]string{
+		current:                      dated,
+		filepath.Join(dated, "loop"): logs,
+		filepath.Join(logs, "gone"):  filepath.Join(dir, "missing"),
+	} {
+		if err := os.Symlink(target, link); err != nil {
+			t.Fatalf("failed to create symlink: %v", err)
+		}
+	}
+
+	// the dated directory is listed once although current points to it, and the loop back to logs
+	// neither repeats nor stops the walk
+	files, err := FilesByPatternContext(context.Background(), logs, false, nil)
+	if err != nil {
+		t.Fatalf("FilesByPatternContext error = %v", err)
+	}
+	if want := []string{filepath.Join(dated, "app.log"), filepath.Join(logs, "gone")}; !slices.Equal(files, want) {
+		t.Errorf("FilesByPatternContext = %v, want %v", files, want)
+	}
+
+	// directories matched by a glob are skipped, files under a symlinked one keep its path
+	files, err = FilesByPatternContext(context.Background(), filepath.Join(logs, "*"), false, nil)
+	if err != nil || !slices.Equal(files, []string{filepath.Join(logs, "gone")}) {
+		t.Errorf("FilesByPatternContext = %v, %v, want the broken symlink only", files, err)
+	}
+	fileInfos, err := GetFileInfosContext(context.Background(), filepath.Join(current, "*.log"), 10, false, nil)
+	if err != nil || len(fileInfos) != 1 {
+		t.Fatalf("GetFileInfosContext = %v, %v", fileInfos, err)
+	}
+	wantCanonical, _ := filepath.EvalSymlinks(filepath.Join(dated, "app.log"))
+	if got := fileInfos[0]; got.FilePath != filepath.Join(current, "app.log") || got.CanonicalPath != wantCanonical {
+		t.Errorf("file = %+v, want canonical path %s", got, wantCanonical)
+	}
+}
+
+// countingReaderAt counts the bytes read from r
+type countingReaderAt struct {
+	r    *bytes.Reader
+	read int64
+}
+
+func (c *countingReaderAt) ReadAt(p []byte, off int64) (int, error) {
+	n, err := c.r.ReadAt(p, off)
+	c.read += int64(n)
+	return n, err
+}
+
+func TestReadTailLines(t *testing.T) {
+	dir := t.TempDir()
+	long := strings.Repeat("x", 3*tailReadBlock+17)
+	tests := []struct {
+		name    string
+		content string
+		n       int
+		want    []string
+	}{
+		{"smaller than a block", "a\nb\nc\n", 2, []string{"b", "c"}},
+		{"fewer lines than asked", "a\nb\n", 5, []string{"a", "b"}},
+		{"no trailing newline", "a\nb\nc", 2, []string{"b", "c"}},
+		{"carriage returns", "a\r\nb\r\n", 5, []string{"a", "b"}},
+		{"empty lines", "\n\na\n\n", 5, []string{"", "", "a", ""}},
+		{"empty file", "", 5, []string{}},
+		{"lines spanning blocks", "first\n" + long + "\nlast\n" + long, 3, []string{long, "last", long}},
+		{"none asked", "a\n", 0, []string{}},
+	}
+	for i, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			logFile := filepath.Join(dir, fmt.Sprintf("%d.log", i))
+			if err := os.WriteFile(logFile, []byte(test.content), 0600); err != nil {
+				t.Fatalf("failed to create file: %v", err)
+			}
+			got, err := ReadTailLines(logFile, test.n, false, nil)
+			if err != nil || !slices.Equal(got, test.want) {
+				t.Errorf("ReadTailLines = %q, %v, want %q", got, err, test.want)
+			}
+
+			// gzip files are scanned from their start, to the same lines
+			gzFile := logFile + ".gz"
+			var buf bytes.Buffer
+			gz := gzip.NewWriter(&buf)
+			gz.Write([]byte(test.content)) //nolint: errcheck
+			gz.Close()
+			if err := os.WriteFile(gzFile, buf.Bytes(), 0600); err != nil {
+				t.Fatalf("failed to create file: %v", err)
+			}
+			got, err = ReadTailLines(gzFile, test.n, false, nil)
+			if err != nil || !slices.Equal(got, test.want) {
+				t.Errorf("ReadTailLines(gzip) = %q, %v, want %q", got, err, test.want)
+			}
+		})
+	}
+
+	// only the end of a large file is read
+	content := bytes.Repeat([]byte("INFO a line of a big file\n"), 200000)
+	reader := &countingReaderAt{r: bytes.NewReader(content)}
+	got, err := readTailLines(context.Background(), reader, int64(len(content)), 500)
+	if err != nil || len(got) != 500 || got[499] != "INFO a line of a big file" {
+		t.Errorf("readTailLines = %d lines, %v", len(got), err)
+	}
+	if reader.read > 2*tailReadBlock {
+		t.Errorf("read %d bytes of %d", reader.read, len(content))
+	}
+}
@@ -0,0 +1,179 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// File is an open file, an *os.File or one of an in memory file system
+type File interface {
+	io.Reader
+	io.ReaderAt
+	io.Seeker
+	io.Closer
+	Stat() (fs.FileInfo, error)
+}
+
+// FileOpener is how the file functions reach the local file system
+type FileOpener interface {
+	Open(name string) (File, error)
+	Stat(name string) (fs.FileInfo, error)
+	Glob(pattern string) ([]string, error)
+	WalkDir(root string, fn fs.WalkDirFunc) error
+}
+
+// SymlinkResolver is implemented by the FileOpeners of file systems with symlinks
+type SymlinkResolver interface {
+	Lstat(name string) (fs.FileInfo, error)
+	// EvalSymlinks returns the file name points to, following every link
+	EvalSymlinks(name string) (string, error)
+}
+
+var ErrBrokenSymlink = errors.New("broken symlink")
+
+// symlinkTarget returns the file the symlink filePath currently points to, empty when filePath
+// is not a symlink or the file system has none
+func symlinkTarget(filePath string) (string, error) {
+	resolver, ok := GlobalFileOpener.(SymlinkResolver)
+	if !ok {
+		return "", nil
+	}
+	info, err := resolver.Lstat(filePath)
+	if err != nil || info.Mode()&fs.ModeSymlink == 0 {
+		return "", nil
+	}
+	target, err := resolver.EvalSymlinks(filePath)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrBrokenSymlink, err)
+	}
+	return target, nil
+}
+
+// canonicalPath returns filePath with every symlink in it resolved, empty when it has none, cannot
+// be resolved or the file system has no symlinks
+func canonicalPath(filePath string) string {
+	resolver, ok := GlobalFileOpener.(SymlinkResolver)
+	if !ok {
+		return ""
+	}
+	canonical, err := resolver.EvalSymlinks(filePath)
+	if err != nil || canonical == filepath.Clean(filePath) {
+		return ""
+	}
+	return canonical
+}
+
+// RemoteRunner runs a command on an SSH host and returns its stdout
+type RemoteRunner interface {
+	Run(ctx context.Context, config *SSHConfig, cmd string) ([]byte, error)
+}
+
+// RemoteStreamer is implemented by the RemoteRunners that can stream the stdout of a command
+type RemoteStreamer interface {
+	// Stream starts cmd and returns its stdout, closing it ends the command
+	Stream(ctx context.Context, config *SSHConfig, cmd string) (io.ReadCloser, error)
+}
+
+// remoteStream streams the stdout of cmd, from the output of Run when the runner cannot stream
+func remoteStream(ctx context.Context, config *SSHConfig, cmd string) (io.ReadCloser, error) {
+	if streamer, ok := GlobalRemoteRunner.(RemoteStreamer); ok {
+		return streamer.Stream(ctx, config, cmd)
+	}
+	output, err := GlobalRemoteRunner.Run(ctx, config, cmd)
+	if err != nil {
+		return nil, err
+	}
+	return io.NopCloser(bytes.NewReader(output)), nil
+}
+
+// Clock is the time source of periodic work
+type Clock interface {
+	Now() time.Time
+	// Tick delivers a tick every d until stop is called
+	Tick(d time.Duration) (ticks <-chan time.Time, stop func())
+	// After delivers the time once d has passed
+	After(d time.Duration) <-chan time.Time
+}
+
+// OSFileOpener is the real file system
+type OSFileOpener struct{}
+
+func (OSFileOpener) Open(name string) (File, error) {
+	file, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return file, nil
+}
+
+func (OSFileOpener) Stat(name string) (fs.FileInfo, error) {
+	return os.Stat(name)
+}
+
+func (OSFileOpener) Lstat(name string) (fs.FileInfo, error) {
+	return os.Lstat(name)
+}
+
+func (OSFileOpener) EvalSymlinks(name string) (string, error) {
+	return filepath.EvalSymlinks(name)
+}
+
+func (OSFileOpener) Glob(pattern string) ([]string, error) {
+	return filepath.Glob(pattern)
+}
+
+func (OSFileOpener) WalkDir(root string, fn fs.WalkDirFunc) error {
+	return filepath.WalkDir(root, fn)
+}
+
+// SSHRemoteRunner runs commands in sessions of the pooled SSH clients
+type SSHRemoteRunner struct{}
+
+func (SSHRemoteRunner) Run(ctx context.Context, config *SSHConfig, cmd string) ([]byte, error) {
+	session, err := GlobalSSHPool.Session(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	defer session.Close()
+
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	if err := runSession(ctx, session.Session, cmd); err != nil {
+		if err.Error() != ErrorMsgSessionAlreadyStarted {
+			return nil, err
+		}
+	}
+	return stdout.Bytes(), nil
+}
+
+// Stream starts cmd in a session of the pooled client, closing the returned stdout closes the session
+func (SSHRemoteRunner) Stream(ctx context.Context, config *SSHConfig, cmd string) (io.ReadCloser, error) {
+	session, err := GlobalSSHPool.Session(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return startSession(ctx, session, cmd)
+}
+
+// SystemClock is the wall clock
+type SystemClock struct{}
+
+func (SystemClock) Now() time.Time {
+	return time.Now()
+}
+
+func (SystemClock) Tick(d time.Duration) (<-chan time.Time, func()) {
+	ticker := time.NewTicker(d)
+	return ticker.C, ticker.Stop
+}
+
+func (SystemClock) After(d time.Duration) <-chan time.Time {
+	return time.After(d)
+}
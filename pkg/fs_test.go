@@ -0,0 +1,138 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// useFakes swaps the file system, SSH hosts and clock for the test
+func useFakes(t *testing.T, fsys fstest.MapFS, runner RemoteRunner, clock Clock) {
+	opener, remoteRunner, globalClock := GlobalFileOpener, GlobalRemoteRunner, GlobalClock
+	t.Cleanup(func() {
+		GlobalFileOpener, GlobalRemoteRunner, GlobalClock = opener, remoteRunner, globalClock
+	})
+	GlobalFileOpener = NewMapFileOpener(fsys)
+	if runner != nil {
+		GlobalRemoteRunner = runner
+	}
+	if clock != nil {
+		GlobalClock = clock
+	}
+}
+
+func gzipped(t *testing.T, content string) []byte {
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err := gz.Write([]byte(content))
+	assert.NoError(t, err)
+	assert.NoError(t, gz.Close())
+	return buf.Bytes()
+}
+
+func TestIsReadableFile_Fake(t *testing.T) {
+	useFakes(t, fstest.MapFS{
+		"fake/plain.log":  {Data: []byte("INFO plain\n")},
+		"fake/app.log.gz": {Data: gzipped(t, "INFO gzipped\n")},
+		"fake/binary.gz":  {Data: gzipped(t, "\x80\x81\x82\x83")},
+		"fake/empty.log":  {Data: nil},
+	}, nil, nil)
+
+	ctx := context.Background()
+	for name, want := range map[string]bool{
+		"fake/plain.log":  true,
+		"fake/app.log.gz": true,
+		"fake/binary.gz":  false,
+		"fake/empty.log":  true,
+	} {
+		readable, err := IsReadableFileContext(ctx, name, false, nil, true)
+		assert.NoError(t, err, name)
+		assert.Equal(t, want, readable, name)
+	}
+	_, err := IsReadableFileContext(ctx, "fake/missing.log", false, nil, true)
+	assert.Error(t, err)
+}
+
+func TestGetFileInfos_Fake(t *testing.T) {
+	useFakes(t, fstest.MapFS{
+		"fakeinfos/a.log":    {Data: []byte("1\n2\n3\n")},
+		"fakeinfos/b.log.gz": {Data: gzipped(t, "1\n2\n")},
+		"fakeinfos/c.log":    {Data: []byte("1\n")},
+		"fakeinfos/empty":    {Data: nil},
+	}, nil, nil)
+	ctx := context.Background()
+
+	fileInfos, err := GetFileInfosContext(ctx, "fakeinfos/*.log*", 10, false, nil)
+	assert.NoError(t, err)
+	assert.Len(t, fileInfos, 3)
+	assert.Equal(t, 3, fileInfos[0].LinesCount)
+	assert.Equal(t, 2, fileInfos[1].LinesCount)
+	assert.Equal(t, TypeFile, fileInfos[1].Type)
+
+	// the limit truncates the matched files
+	fileInfos, err = GetFileInfosContext(ctx, "fakeinfos/*.log*", 2, false, nil)
+	assert.NoError(t, err)
+	assert.Len(t, fileInfos, 2)
+
+	// an empty file is listed with no lines instead of failing on EOF
+	fileInfos, err = GetFileInfosContext(ctx, "fakeinfos/empty", 10, false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []FileInfo{{FilePath: "fakeinfos/empty", Type: TypeFile}}, fileInfos)
+
+	// a directory lists every file under it
+	filePaths, err := FilesByPatternContext(ctx, "fakeinfos", false, nil)
+	assert.NoError(t, err)
+	assert.Len(t, filePaths, 4)
+}
+
+func TestGetFileInfos_SSH(t *testing.T) {
+	runner := &ScriptedRemoteRunner{Outputs: map[string]string{
+		"web1 ls /var/log/*.log":    "/var/log/app.log\n/var/log/db.log\n",
+		"web1 cat /var/log/app.log": "INFO a\nERROR b\n",
+		"web1 cat /var/log/db.log":  "INFO c\n",
+		// db.log cannot be stated, it has no modification time
+		"web1 stat -c '%Y %n' /var/log/app.log /var/log/db.log": "1717243200 /var/log/app.log\n",
+	}}
+	useFakes(t, fstest.MapFS{}, runner, nil)
+	sshConfig := &SSHConfig{Host: "web1", Port: "22"}
+
+	fileInfos, err := GetFileInfosContext(context.Background(), "/var/log/*.log", 10, true, sshConfig)
+	assert.NoError(t, err)
+	assert.Len(t, fileInfos, 2)
+	modTime := time.Unix(1717243200, 0)
+	assert.Equal(t, FileInfo{FilePath: "/var/log/app.log", LinesCount: 2, FileSize: 15, ModTime: &modTime, Type: TypeSSH, Host: "web1"}, fileInfos[0])
+	assert.Nil(t, fileInfos[1].ModTime)
+	assert.Equal(t, "web1 ls /var/log/*.log", runner.Calls[0])
+}
+
+func TestWatchFilePaths_Fake(t *testing.T) {
+	fsys := fstest.MapFS{"fakewatch/a.log": {Data: []byte("1\n")}}
+	clock := NewManualClock(time.Unix(0, 0))
+	useFakes(t, fsys, nil, clock)
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	GlobalFileRegistry.Replace(nil)
+
+	done := make(chan struct{})
+	go func() {
+		WatchFilePaths(10*time.Second, SliceFlags{"fakewatch/*.log"}, nil, nil, 10)
+		close(done)
+	}()
+
+	assert.Eventually(t, func() bool { return clock.Tickers() == 1 }, time.Second, time.Millisecond)
+	// not yet due
+	clock.Advance(5 * time.Second)
+	fsys["fakewatch/b.log"] = &fstest.MapFile{Data: []byte("1\n2\n")}
+	// the second tick is received once the first rescan is done
+	clock.Advance(5 * time.Second)
+	clock.Advance(10 * time.Second)
+	clock.Close()
+	<-done
+
+	assert.Len(t, GlobalFileRegistry.Snapshot(), 2)
+	assert.Equal(t, "fakewatch/b.log", GlobalFileRegistry.Snapshot()[1].FilePath)
+}
@@ -1,106 +1,387 @@
 package pkg
 
 import (
+	"context"
 	"log/slog"
 	"os"
-	"strings"
+	"sync"
 	"time"
-
-	"golang.org/x/crypto/ssh"
 )
 
-var GlobalFilePaths []FileInfo
+// GlobalFileRegistry is the file list, the cached stats of the files it no longer lists are dropped
+var GlobalFileRegistry = newGlobalFileRegistry()
+
+func newGlobalFileRegistry() *FileRegistry {
+	registry := NewFileRegistry()
+	registry.replaced = retainListedFileStats
+	return registry
+}
+
+// GlobalPipeTmpFilePath is the temp file stdin is copied to.
+//
+// Deprecated: use PipeTmpFilePath.
 var GlobalPipeTmpFilePath string
+
+// GlobalStdinPipe copies stdin to PipeTmpFilePath, nil unless gol reads a pipe
+var GlobalStdinPipe *StdinPipe
+
+// GlobalFIFOs copy the named pipes of -f patterns to temp files
+var GlobalFIFOs = NewFIFOs(DefaultStdinBuffer)
+var filePathsMutex sync.RWMutex
 var GlobalPathSSHConfig []SSHPathConfig
-var GlobalSSHClients = make(map[string]*ssh.Client)
+var GlobalRemoteClients []*RemoteClient
+
+// GlobalK8sSource follows the pods of -k8s, nil without any
+var GlobalK8sSource *K8sSource
+
+// GlobalS3Source lists the keys of -s3, nil without any
+var GlobalS3Source *S3Source
+
+// GlobalJournalSource reads the units of -journal, nil without any
+var GlobalJournalSource *JournalSource
+
+// GlobalHTTPSources reads the -f patterns that are HTTP(S) URLs
+var GlobalHTTPSources = NewHTTPSources(nil, DefaultHTTPMaxSize, DefaultHTTPMaxRedirects)
+var GlobalSSHPool = NewSSHPool(DefaultSSHIdleTimeout, DefaultSSHMaxSessions)
+var GlobalDataDir string
+
+// GlobalMinFreeDisk is the free space temp copies and caches must leave, 0 disables the check
+var GlobalMinFreeDisk int64
+var GlobalFileStatsCache = NewFileStatsCache()
+
+// GlobalLineIndexes keep the line checkpoints of large files on disk, nil without -data-dir or -index-dir
+var GlobalLineIndexes *LineIndexes
+var GlobalSegmentTimeRanges = NewSegmentTimeRanges()
+var GlobalStore Store = NewMemoryStore()
+var GlobalFileCuration = NewFileCuration(GlobalStore)
+var GlobalShares = NewShares(GlobalStore, DefaultShareTTL)
+var GlobalMaxLineLength = DefaultMaxLineLength
+var GlobalMaxPerPage = DefaultMaxPerPage
+var GlobalMaxResponseBytes int64 = DefaultMaxResponseBytes
+var GlobalCharset = CharsetAuto
+var GlobalMaxMergeFiles = DefaultMaxMergeFiles
+var GlobalExportMaxLines = DefaultExportMaxLines
+var GlobalPatternLimits = DefaultPatternLimits
+var GlobalRotationSuffixes []RotationSuffix
+
+// GlobalExcludes are the globs of -exclude, files matching one are not listed
+var GlobalExcludes []string
+var GlobalNotifierStatuses = NewNotifierStatuses()
+
+// GlobalAlerts matches the lines appended to the watched files against the -alert rules, nil without any
+var GlobalAlerts *Alerts
+var GlobalSourceStatuses = NewSourceStatuses()
+var GlobalSSHDiscovery = NewSSHDiscovery(DefaultSSHWorkers, DefaultSSHDeadline)
+var GlobalDiscoveredSources = &DiscoveredSources{}
+
+// GlobalPathWatcher lists the files of the local patterns as they are created, nil when polling
+var GlobalPathWatcher *PathWatcher
+var GlobalFileListChanges = NewChanges()
+
+// GlobalSourceReloads tells the streams a reload is done, those of files no longer listed end
+var GlobalSourceReloads = NewChanges()
+var GlobalProcessors = NewProcessors(Base64JSONProcessor{})
+
+// GlobalLogBuffer keeps gol's own log lines, nil when the internal source is disabled
+var GlobalLogBuffer *LogBuffer
+var GlobalJobs = NewJobs()
+
+// GlobalMetrics are the Prometheus metrics, collected whether they are served or not
+var GlobalMetrics = NewMetrics()
+var GlobalExports = NewExports(DefaultExportRetention)
+var GlobalPreviews = NewPreviews(DefaultPreviewBudget)
+var GlobalDeepCheckDeadline = DefaultDeepCheckDeadline
+var GlobalHistogramDeadline = DefaultHistogramDeadline
+
+// GlobalMemory keeps the in-memory buffers under -max-buffer-memory
+var GlobalMemory = NewMemoryAccountant(DefaultMaxBufferMemory)
+
+// GlobalSelfReporter sends the self report to a fleet inventory, nil unless -report-to is set
+var GlobalSelfReporter *SelfReporter
+var GlobalPathDefaults = &PathDefaults{}
+var GlobalReadLimiter = NewLimiter(DefaultMaxScans, DefaultMaxLocalReads, DefaultMaxReadsPerHost, DefaultMaxTails, DefaultMaxReadWait)
+var GlobalTailHub = NewTailHub(tailPollInterval)
+
+var GlobalWatchedPatterns = &WatchedPatterns{}
+
+// the file system, SSH hosts and time as seen by pkg, replaced by fakes in tests
+var GlobalFileOpener FileOpener = OSFileOpener{}
+var GlobalRemoteRunner RemoteRunner = SSHRemoteRunner{}
+var GlobalClock Clock = SystemClock{}
 
-func WatchFilePaths(seconds int64, filePaths SliceFlags, sshPaths SliceFlags, dockerPaths SliceFlags, limit int) {
-	interval := time.Duration(seconds) * time.Second
-	ticker := time.NewTicker(interval)
-	defer ticker.Stop()
+// FilePaths is the file list.
+//
+// Deprecated: use GlobalFileRegistry.Snapshot.
+func FilePaths() []FileInfo {
+	return GlobalFileRegistry.Snapshot()
+}
+
+// SetFilePaths replaces the file list.
+//
+// Deprecated: use GlobalFileRegistry.Replace.
+func SetFilePaths(fileInfos []FileInfo) {
+	GlobalFileRegistry.Replace(fileInfos)
+}
+
+// SSHPathConfigs are the SSH paths of the last rescan
+func SSHPathConfigs() []SSHPathConfig {
+	filePathsMutex.RLock()
+	defer filePathsMutex.RUnlock()
+	return GlobalPathSSHConfig
+}
+
+// SetSSHPathConfigs replaces the SSH paths
+func SetSSHPathConfigs(sshConfigs []SSHPathConfig) {
+	filePathsMutex.Lock()
+	defer filePathsMutex.Unlock()
+	GlobalPathSSHConfig = sshConfigs
+}
+
+// PipeTmpFilePath is the temp file stdin is copied to, empty unless gol reads a pipe
+func PipeTmpFilePath() string {
+	filePathsMutex.RLock()
+	defer filePathsMutex.RUnlock()
+	return GlobalPipeTmpFilePath
+}
+
+// SetPipeTmpFilePath sets the temp file stdin is copied to
+func SetPipeTmpFilePath(filePath string) {
+	filePathsMutex.Lock()
+	defer filePathsMutex.Unlock()
+	GlobalPipeTmpFilePath = filePath
+}
+
+// SetGlobalStore makes store the persisted state of all the features using one
+func SetGlobalStore(store Store) {
+	GlobalStore = store
+	GlobalFileCuration = NewFileCuration(store)
+	GlobalShares = NewShares(store, GlobalShares.ttl)
+}
+
+// WatchedPatterns are the patterns WatchFilePaths rescans, they can change on config reload
+type WatchedPatterns struct {
+	mutex       sync.RWMutex
+	filePaths   SliceFlags
+	sshPaths    SliceFlags
+	dockerPaths SliceFlags
+	limit       int
+}
+
+func (p *WatchedPatterns) Set(filePaths SliceFlags, sshPaths SliceFlags, dockerPaths SliceFlags, limit int) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.filePaths = filePaths
+	p.sshPaths = sshPaths
+	p.dockerPaths = dockerPaths
+	p.limit = limit
+}
+
+func (p *WatchedPatterns) SetFilePaths(filePaths SliceFlags) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.filePaths = filePaths
+}
+
+// Get returns the arguments of UpdateGlobalFilePaths
+func (p *WatchedPatterns) Get() (SliceFlags, SliceFlags, SliceFlags, int) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	return p.filePaths, p.sshPaths, p.dockerPaths, p.limit
+}
 
-	for range ticker.C {
-		slog.Info("Checking for filepaths", "interval", interval)
-		UpdateGlobalFilePaths(filePaths, sshPaths, dockerPaths, limit)
+func WatchFilePaths(interval time.Duration, filePaths SliceFlags, sshPaths SliceFlags, dockerPaths SliceFlags, limit int) {
+	WatchFilePathsContext(context.Background(), interval, filePaths, sshPaths, dockerPaths, limit)
+}
+
+// WatchFilePathsContext rescans the file paths every interval until ctx is done
+func WatchFilePathsContext(ctx context.Context, interval time.Duration, filePaths SliceFlags, sshPaths SliceFlags, dockerPaths SliceFlags, limit int) {
+	GlobalWatchedPatterns.Set(filePaths, sshPaths, dockerPaths, limit)
+	ticks, stop := GlobalClock.Tick(interval)
+	defer stop()
+
+	// with a path watcher, the local patterns are rescanned when files are created, removed or renamed,
+	// and on ticks only when written to
+	var listed <-chan struct{}
+	if GlobalPathWatcher != nil {
+		GlobalPathWatcher.Watch(filePaths)
+		listed = GlobalPathWatcher.Listed()
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-ticks:
+			if !ok {
+				return
+			}
+			slog.Info("Checking for filepaths", "interval", interval)
+			start := time.Now()
+			filePaths, sshPaths, dockerPaths, limit := GlobalWatchedPatterns.Get()
+			if GlobalPathWatcher != nil && !GlobalPathWatcher.TakeWritten() {
+				UpdateSourceFilePaths(filePaths, sshPaths, dockerPaths, limit)
+			} else {
+				UpdateGlobalFilePaths(filePaths, sshPaths, dockerPaths, limit)
+			}
+			SaveGlobalFileStatsCache()
+			GlobalMetrics.ObserveWatchLoop(start)
+		case <-listed:
+			start := time.Now()
+			filePaths, _, _, limit := GlobalWatchedPatterns.Get()
+			UpdateLocalFilePaths(filePaths, limit)
+			GlobalMetrics.ObserveWatchLoop(start)
+		}
 	}
 }
 
-func HandleStdinPipe() {
+// HandleStdinPipe copies stdin to a temp file listed as the stdin source named name, keeping its last
+// bufferLines lines, until stdin ends
+func HandleStdinPipe(bufferLines int, name string) {
+	if err := EnsureFreeDisk(TmpDir(), 0); err != nil {
+		slog.Error("not copying stdin", "error", err)
+		return
+	}
 	tmpFile, err := os.Create(GetTmpFileNameForSTDIN())
 	if err != nil {
-		slog.Error("creating temp file", tmpFile.Name(), err)
+		slog.Error("creating temp file", "error", err)
 		return
 	}
-	GlobalPipeTmpFilePath = tmpFile.Name()
-	defer tmpFile.Close()
-	go func(tmpFile *os.File) {
-		err := PipeLinesToTmp(tmpFile)
-		if err != nil {
-			slog.Error("piping lines to temp file", tmpFile.Name(), err)
-			return
+	SetPipeTmpFilePath(tmpFile.Name())
+	slog.Info("Temporary file created for stdin", "path", tmpFile.Name())
+	GlobalFileRegistry.Upsert(FileInfo{FilePath: tmpFile.Name(), Type: TypeStdin})
+
+	GlobalStdinPipe = NewStdinPipe(tmpFile, name, bufferLines, func() {
+		slog.Info("stdin closed", "path", tmpFile.Name())
+		filePaths, _, _, limit := GlobalWatchedPatterns.Get()
+		UpdateLocalFilePaths(filePaths, limit)
+	})
+	go func() {
+		defer tmpFile.Close()
+		if err := GlobalStdinPipe.Run(os.Stdin); err != nil {
+			slog.Error("reading from pipe", "path", tmpFile.Name(), "error", err)
 		}
-	}(tmpFile)
+	}()
 }
 
 func UpdateGlobalFilePaths(filePaths SliceFlags, sshPaths SliceFlags, dockerPaths SliceFlags, limit int) {
+	GlobalDiscoveredSources.SetLocal(localFileInfos(filePaths, limit))
+	UpdateSourceFilePaths(filePaths, sshPaths, dockerPaths, limit)
+}
+
+// UpdateLocalFilePaths rescans the local patterns only, keeping the other sources of the last rescan
+func UpdateLocalFilePaths(filePaths SliceFlags, limit int) {
+	GlobalDiscoveredSources.SetLocal(localFileInfos(filePaths, limit))
+	GlobalDiscoveredSources.Publish()
+	GlobalSourceStatuses.MarkScanned()
+}
+
+func localFileInfos(filePaths SliceFlags, limit int) ([]FileInfo, []SourceStatus) {
 	fileInfos := []FileInfo{}
-	for _, pattern := range filePaths {
-		fileInfo := GetFileInfos(pattern, limit, false, nil)
-		fileInfos = append(fileInfo, fileInfos...)
+	statuses := []SourceStatus{}
+	for _, labeled := range filePaths {
+		label, pattern := ParseFilePattern(labeled)
+		if IsHTTPURL(pattern) {
+			continue
+		}
+		fileInfo, err := GetFileInfosContext(context.Background(), pattern, limit, false, nil)
+		statuses = append(statuses, newSourceStatus(labeled, TypeFile, "", fileInfo, err))
+		for i := range fileInfo {
+			fileInfo[i].Group = label
+		}
+		fileInfos = append(fileInfos, fileInfo...)
 	}
+	return MergeDuplicateFileInfos(fileInfos), statuses
+}
+
+// UpdateSourceFilePaths rescans the sources other than the local patterns: the HTTP URLs among the
+// file patterns, SSH, Docker, Kubernetes, S3, the journal and the remote instances, keeping the local
+// files of the last rescan
+func UpdateSourceFilePaths(filePaths SliceFlags, sshPaths SliceFlags, dockerPaths SliceFlags, limit int) {
+	fileInfos, statuses := httpFileInfos(filePaths)
+	sshConfigs := []SSHPathConfig{}
 	for _, pattern := range sshPaths {
 		sshFilePathConfig, err := StringToSSHPathConfig(pattern)
 		if err != nil {
 			slog.Error("parsing SSH path", pattern, err)
 			break
 		}
-		sshConfig := SSHConfig{
-			Host:           sshFilePathConfig.Host,
-			Port:           sshFilePathConfig.Port,
-			User:           sshFilePathConfig.User,
-			Password:       sshFilePathConfig.Password,
-			PrivateKeyPath: sshFilePathConfig.PrivateKeyPath,
-		}
-		GlobalPathSSHConfig = append(GlobalPathSSHConfig, *sshFilePathConfig)
-		fileInfo := GetFileInfos(sshFilePathConfig.FilePath, limit, true, &sshConfig)
-		fileInfos = append(fileInfo, fileInfos...)
+		sshConfigs = append(sshConfigs, *sshFilePathConfig)
 	}
+	SetSSHPathConfigs(sshConfigs)
+	GlobalSSHDiscovery.Resolve(sshConfigs, limit)
 
 	for _, pattern := range dockerPaths {
+		dockerPathConfig := &DockerPathConfig{}
+		if pattern != "" {
+			var err error
+			if dockerPathConfig, err = StringToDockerPathConfig(pattern); err != nil {
+				slog.Error("parsing Docker path", pattern, err)
+				break
+			}
+		}
+		if dockerPathConfig.FilePath != "" {
+			fileInfo, err := ContainerFileInfosContext(context.Background(), dockerPathConfig.FilePath, limit, dockerPathConfig.ContainerID)
+			if err != nil {
+				slog.Error("listing container files", "containerID", dockerPathConfig.ContainerID, "error", err)
+			}
+			statuses = append(statuses, newSourceStatus(pattern, TypeDocker, dockerPathConfig.ContainerID, fileInfo, err))
+			fileInfos = append(fileInfo, fileInfos...)
+			continue
+		}
+
 		containers, err := ListDockerContainers()
 		if err != nil {
 			slog.Error("listing Docker containers", pattern, err)
 			break
 		}
-		if pattern == "" || len(strings.Fields(pattern)) == 1 {
-			for _, container := range containers {
-				if pattern != "" && !strings.Contains(container.Names[0], pattern) {
-					continue
-				}
-				tmpFile := ContainerStdoutToTmp(container.ID)
-				if tmpFile == nil {
-					slog.Error("creating temp file for container logs", "containerID", container.ID)
-					continue
-				}
-				fileInfo := GetFileInfos(tmpFile.Name(), limit, false, nil)
-				if len(fileInfo) > 0 {
-					fileInfo[0].Host = container.ID[:12]
-					fileInfo[0].Type = TypeDocker
-					fileInfo[0].Name = container.Names[0][1:]
-					fileInfos = append(fileInfo, fileInfos...)
-				}
+		for _, container := range containers {
+			if !MatchContainer(container, dockerPathConfig.ContainerID) {
+				continue
 			}
-		}
-		if len(strings.Fields(pattern)) == 2 {
-			dockerFilePathConfig, err := StringToDockerPathConfig(pattern)
+			name := ContainerName(container)
+			if err := EnsureFreeDisk(TmpDir(), 0); err != nil {
+				slog.Error("not copying container logs", "containerID", container.ID, "error", err)
+				statuses = append(statuses, newSourceStatus(name, TypeDocker, name, nil, err))
+				continue
+			}
+			tmpFile, err := ContainerStdoutToTmpContext(context.Background(), container.ID)
 			if err != nil {
-				slog.Error("parsing Docker path", pattern, err)
-				break
+				slog.Error("copying container logs", "containerID", container.ID, "error", err)
+				statuses = append(statuses, newSourceStatus(name, TypeDocker, name, nil, err))
+				continue
+			}
+			tmpFile.Close()
+			fileInfo := GetFileInfos(tmpFile.Name(), limit, false, nil)
+			statuses = append(statuses, newSourceStatus(name, TypeDocker, name, fileInfo, nil))
+			if len(fileInfo) > 0 {
+				fileInfo[0].Host = name
+				fileInfo[0].Type = TypeDocker
+				fileInfo[0].Name = name
+				fileInfos = append(fileInfo, fileInfos...)
 			}
-			fileInfo := GetContainerFileInfos(dockerFilePathConfig.FilePath, limit, dockerFilePathConfig.ContainerID)
-			fileInfos = append(fileInfo, fileInfos...)
 		}
 	}
 
-	GlobalFilePaths = UniqueFileInfos(fileInfos)
+	if GlobalK8sSource != nil {
+		k8sInfos, k8sStatuses := GlobalK8sSource.Refresh(context.Background(), limit)
+		fileInfos = append(k8sInfos, fileInfos...)
+		statuses = append(statuses, k8sStatuses...)
+	}
+	if GlobalS3Source != nil {
+		s3Infos, s3Statuses := GlobalS3Source.Refresh(context.Background(), limit)
+		fileInfos = append(s3Infos, fileInfos...)
+		statuses = append(statuses, s3Statuses...)
+	}
+	if GlobalJournalSource != nil {
+		journalInfos, journalStatuses := GlobalJournalSource.Refresh(context.Background())
+		fileInfos = append(journalInfos, fileInfos...)
+		statuses = append(statuses, journalStatuses...)
+	}
+
+	fileInfos = append(fileInfos, RemoteFileInfos(GlobalRemoteClients)...)
+	if GlobalLogBuffer != nil {
+		fileInfos = append(fileInfos, GlobalLogBuffer.FileInfo())
+	}
+	GlobalDiscoveredSources.Set(fileInfos, statuses, sshConfigs)
+	GlobalDiscoveredSources.Publish()
+	GlobalSourceStatuses.MarkScanned()
 }
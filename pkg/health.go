@@ -0,0 +1,291 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	HealthPass    = "pass"
+	HealthPartial = "partial"
+	HealthFail    = "fail"
+
+	// DefaultDeepCheckDeadline is the time all the checks of a deep health check may take
+	DefaultDeepCheckDeadline = 5 * time.Second
+)
+
+// HealthCheck is the outcome of one real operation on a source
+type HealthCheck struct {
+	Type string `json:"type"`
+	Host string `json:"host,omitempty"`
+	// Target is what was checked: a file, a Docker daemon or a peer
+	Target    string    `json:"target"`
+	Status    string    `json:"status"`
+	LatencyMs int64     `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+}
+
+type DeepHealthResponse struct {
+	// Status is pass when every check passed, partial when some failed and fail when all failed
+	Status string        `json:"status"`
+	Checks []HealthCheck `json:"checks"`
+}
+
+// healthProbe is one check to run, run does the operation
+type healthProbe struct {
+	check HealthCheck
+	run   func(ctx context.Context) error
+}
+
+// GetDeepHealth performs one cheap real operation per source type within GlobalDeepCheckDeadline: it
+// stats a local file, runs true and stats a file on every SSH host, pings the Docker daemon and asks
+// every peer for its version. It answers 200 when all passed, 207 when some failed and 503 when all
+// failed. The checks are kept with the source statuses, GET api/sources shows the last ones.
+func (h *AdminHandler) GetDeepHealth(c echo.Context) error {
+	if err := h.authorize(c); err != nil {
+		return err
+	}
+	checks := DeepCheck(c.Request().Context(), GlobalDeepCheckDeadline)
+	GlobalSourceStatuses.SetHealthChecks(checks)
+
+	res := DeepHealthResponse{Status: HealthPass, Checks: checks}
+	failed := 0
+	for _, check := range checks {
+		if check.Status == HealthFail {
+			failed++
+		}
+	}
+	switch {
+	case failed > 0 && failed == len(checks):
+		res.Status = HealthFail
+		return c.JSON(http.StatusServiceUnavailable, res)
+	case failed > 0:
+		res.Status = HealthPartial
+		return c.JSON(http.StatusMultiStatus, res)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// DeepCheck runs the checks of the configured sources concurrently. A check still running at the
+// deadline fails with it.
+func DeepCheck(ctx context.Context, deadline time.Duration) []HealthCheck {
+	ctx, cancel := context.WithTimeout(ctx, deadline)
+	defer cancel()
+	type result struct {
+		i     int
+		check HealthCheck
+	}
+	probes := healthProbes()
+	checks := make([]HealthCheck, len(probes))
+	// the checks answering after the deadline are dropped, they never block on the buffered channel
+	results := make(chan result, len(probes))
+	started := time.Now()
+	for i, probe := range probes {
+		checks[i] = probe.check
+		checks[i].Status = HealthFail
+		checks[i].Error = fmt.Sprintf("no answer within %s", deadline)
+		go func() {
+			err := probe.run(ctx)
+			check := probe.check
+			check.LatencyMs = time.Since(started).Milliseconds()
+			check.Status = HealthPass
+			if err != nil {
+				check.Status = HealthFail
+				check.Error = err.Error()
+			}
+			results <- result{i: i, check: check}
+		}()
+	}
+	answered := make([]bool, len(probes))
+collect:
+	for remaining := len(probes); remaining > 0; remaining-- {
+		select {
+		case r := <-results:
+			checks[r.i], answered[r.i] = r.check, true
+		case <-ctx.Done():
+			break collect
+		}
+	}
+
+	now := GlobalClock.Now()
+	for i := range checks {
+		if !answered[i] {
+			checks[i].LatencyMs = time.Since(started).Milliseconds()
+		}
+		checks[i].CheckedAt = now
+	}
+	return checks
+}
+
+// healthProbes picks one local file, one file per SSH host, the Docker daemon when containers
+// are watched and every peer
+func healthProbes() []healthProbe {
+	probes := []healthProbe{}
+	fileInfos := GlobalFileRegistry.Snapshot()
+	for _, fileInfo := range fileInfos {
+		if fileInfo.Type != TypeFile {
+			continue
+		}
+		filePath := fileInfo.FilePath
+		probes = append(probes, healthProbe{
+			check: HealthCheck{Type: TypeFile, Target: filePath},
+			run: func(context.Context) error {
+				_, err := GlobalFileOpener.Stat(filePath)
+				return err
+			},
+		})
+		break
+	}
+
+	hosts := map[string]bool{}
+	for _, sshConfig := range SSHPathConfigs() {
+		if hosts[sshConfig.Host] {
+			continue
+		}
+		hosts[sshConfig.Host] = true
+		cmd, target := "true", sshConfig.Host
+		for _, fileInfo := range fileInfos {
+			if fileInfo.Type == TypeSSH && fileInfo.Host == sshConfig.Host {
+				cmd, target = "true && stat -c %s "+fileInfo.FilePath, fileInfo.FilePath
+				break
+			}
+		}
+		config := sshConfig.ToSSHConfig()
+		probes = append(probes, healthProbe{
+			check: HealthCheck{Type: TypeSSH, Host: sshConfig.Host, Target: target},
+			run: func(ctx context.Context) error {
+				_, err := GlobalRemoteRunner.Run(ctx, config, cmd)
+				return err
+			},
+		})
+	}
+
+	if _, _, dockerPaths, _ := GlobalWatchedPatterns.Get(); len(dockerPaths) > 0 {
+		probes = append(probes, healthProbe{
+			check: HealthCheck{Type: TypeDocker, Target: "docker"},
+			run:   PingDocker,
+		})
+	}
+
+	if GlobalK8sSource != nil {
+		probes = append(probes, healthProbe{
+			check: HealthCheck{Type: TypeK8s, Target: "k8s"},
+			run:   GlobalK8sSource.Ping,
+		})
+	}
+
+	for _, client := range GlobalRemoteClients {
+		probes = append(probes, healthProbe{
+			check: HealthCheck{Type: TypeRemoteGol, Host: client.Label(), Target: client.config.URL},
+			run: func(ctx context.Context) error {
+				res, err := client.Do(ctx, "api/version", nil)
+				if err != nil {
+					return err
+				}
+				return res.Body.Close()
+			},
+		})
+	}
+	return probes
+}
+
+// The statuses of the sources reported by /readyz
+const (
+	SourceOK          = "ok"
+	SourceNoMatches   = "no-matches"
+	SourceAuthFailed  = "auth-failed"
+	SourceUnreachable = "unreachable"
+)
+
+// authFailures are the parts of the errors of a source refusing the credentials of gol
+var authFailures = []string{"unable to authenticate", "permission denied", "unauthorized", "forbidden"}
+
+// HealthHandler answers the liveness and readiness probes, outside of the API and its token
+type HealthHandler struct {
+	requireAll bool
+}
+
+func NewHealthHandler(options *EchoOptions) *HealthHandler {
+	return &HealthHandler{requireAll: options.RequireAllSources}
+}
+
+type HealthzResponse struct {
+	Status string `json:"status"`
+}
+
+// SourceReadiness is the status of one configured source at the last rescan
+type SourceReadiness struct {
+	Pattern string `json:"pattern"`
+	Type    string `json:"type"`
+	Host    string `json:"host,omitempty"`
+	// Status is ok, no-matches, auth-failed or unreachable
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+type ReadinessResponse struct {
+	Ready bool `json:"ready"`
+	// LastScanAt is the end of the last rescan, null before the first one
+	LastScanAt *time.Time        `json:"last_scan_at"`
+	Sources    []SourceReadiness `json:"sources"`
+}
+
+// GetHealthz answers 200 as long as the server serves
+func (h *HealthHandler) GetHealthz(c echo.Context) error {
+	return c.JSON(http.StatusOK, HealthzResponse{Status: HealthPass})
+}
+
+// GetReadyz answers 503 until the first rescan of the sources completed and one of them is reachable,
+// or with -require-all-sources until all of them are. A source without matches is reachable. Without any
+// source, the server is ready after the first rescan.
+func (h *HealthHandler) GetReadyz(c echo.Context) error {
+	res := ReadinessResponse{Sources: []SourceReadiness{}}
+	reachable := 0
+	for _, status := range GlobalSourceStatuses.List() {
+		readiness := sourceReadiness(status)
+		if readiness.Status == SourceOK || readiness.Status == SourceNoMatches {
+			reachable++
+		}
+		res.Sources = append(res.Sources, readiness)
+	}
+	if scannedAt := GlobalSourceStatuses.ScannedAt(); !scannedAt.IsZero() {
+		res.LastScanAt = &scannedAt
+		res.Ready = len(res.Sources) == 0 || reachable > 0
+		if h.requireAll {
+			res.Ready = reachable == len(res.Sources)
+		}
+	}
+	if !res.Ready {
+		return c.JSON(http.StatusServiceUnavailable, res)
+	}
+	return c.JSON(http.StatusOK, res)
+}
+
+// sourceReadiness tells an unreachable source from one refusing the credentials of gol. An SSH source
+// still resolving is unreachable until it answers, or ok with the files of its previous answer.
+func sourceReadiness(status SourceStatus) SourceReadiness {
+	readiness := SourceReadiness{Pattern: status.Source, Type: status.Type, Host: status.Host, Error: status.Error, Status: SourceOK}
+	switch {
+	case status.Error != "":
+		readiness.Status = SourceUnreachable
+		lowered := strings.ToLower(status.Error)
+		for _, failure := range authFailures {
+			if strings.Contains(lowered, failure) {
+				readiness.Status = SourceAuthFailed
+				break
+			}
+		}
+	case status.Files > 0:
+	case status.Pending:
+		readiness.Status = SourceUnreachable
+	default:
+		readiness.Status = SourceNoMatches
+	}
+	return readiness
+}
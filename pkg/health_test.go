@@ -0,0 +1,155 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAdminHandler_GetDeepHealth(t *testing.T) {
+	fsys := fstest.MapFS{"logs/app.log": {Data: []byte("INFO a\n")}}
+	gate := make(chan struct{})
+	runner := &ScriptedRemoteRunner{
+		Outputs: map[string]string{
+			"web1 true && stat -c %s /var/log/web.log": "15\n",
+			"web2 true": "",
+		},
+		Errors: map[string]error{},
+		Gates:  map[string]chan struct{}{"web2 true": gate},
+	}
+	useFakes(t, fsys, runner, nil)
+	defer func(deadline time.Duration, sshConfigs []SSHPathConfig) {
+		GlobalDeepCheckDeadline, GlobalPathSSHConfig = deadline, sshConfigs
+		GlobalSourceStatuses.Set(nil)
+		GlobalSourceStatuses.SetHealthChecks(nil)
+	}(GlobalDeepCheckDeadline, GlobalPathSSHConfig)
+	GlobalDeepCheckDeadline = 100 * time.Millisecond
+	GlobalPathSSHConfig = []SSHPathConfig{{Host: "web1", Port: "22", FilePath: "/var/log/*.log"}, {Host: "web2", Port: "22", FilePath: "/var/log/*.log"}}
+	GlobalFileRegistry.Replace([]FileInfo{
+		{FilePath: "logs/app.log", Type: TypeFile},
+		{FilePath: "/var/log/web.log", Type: TypeSSH, Host: "web1"},
+	})
+	GlobalSourceStatuses.Set([]SourceStatus{{Source: "/var/log/*.log", Type: TypeSSH, Host: "web2", Files: 0}})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff, AdminToken: "secret"})
+	check := func(token string) (int, DeepHealthResponse) {
+		req := httptest.NewRequest(http.MethodGet, "/api/healthz/deep", nil)
+		if token != "" {
+			req.Header.Set(echo.HeaderAuthorization, "Bearer "+token)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		var res DeepHealthResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		return rec.Code, res
+	}
+	byHost := func(checks []HealthCheck) map[string]HealthCheck {
+		hosts := map[string]HealthCheck{}
+		for _, check := range checks {
+			hosts[check.Type+" "+check.Host] = check
+		}
+		return hosts
+	}
+
+	code, _ := check("")
+	assert.Equal(t, http.StatusUnauthorized, code)
+
+	// web2 does not answer within the deadline
+	code, res := check("secret")
+	assert.Equal(t, http.StatusMultiStatus, code)
+	assert.Equal(t, HealthPartial, res.Status)
+	checks := byHost(res.Checks)
+	assert.Len(t, checks, 3)
+	assert.Equal(t, HealthPass, checks[TypeFile+" "].Status)
+	assert.Equal(t, "logs/app.log", checks[TypeFile+" "].Target)
+	assert.Equal(t, HealthPass, checks[TypeSSH+" web1"].Status)
+	assert.Equal(t, "/var/log/web.log", checks[TypeSSH+" web1"].Target)
+	assert.Equal(t, HealthFail, checks[TypeSSH+" web2"].Status)
+	assert.GreaterOrEqual(t, checks[TypeSSH+" web2"].LatencyMs, int64(100))
+
+	// the sources endpoint reflects the last deep check
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/sources", nil))
+	var sources SourcesResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &sources))
+	if assert.Len(t, sources.Sources, 1) && assert.NotNil(t, sources.Sources[0].HealthCheck) {
+		assert.Equal(t, HealthFail, sources.Sources[0].HealthCheck.Status)
+	}
+
+	close(gate)
+	code, res = check("secret")
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, HealthPass, res.Status)
+
+	delete(fsys, "logs/app.log")
+	runner.Errors["web1 true && stat -c %s /var/log/web.log"] = errors.New("permission denied")
+	runner.Errors["web2 true"] = errors.New("connection refused")
+	code, res = check("secret")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Equal(t, HealthFail, res.Status)
+	assert.Equal(t, "connection refused", byHost(res.Checks)[TypeSSH+" web2"].Error)
+}
+
+func TestHealthHandler_GetReadyz(t *testing.T) {
+	defer func(statuses *SourceStatuses) { GlobalSourceStatuses = statuses }(GlobalSourceStatuses)
+	GlobalSourceStatuses = NewSourceStatuses()
+	// the probes never carry the token
+	options := &EchoOptions{BaseURL: "/", Compression: CompressionOff, Token: "secret"}
+	e := newTestEcho(options)
+	get := func(path string) (int, ReadinessResponse) {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, path, nil))
+		var res ReadinessResponse
+		assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		return rec.Code, res
+	}
+
+	code, _ := get("/healthz")
+	assert.Equal(t, http.StatusOK, code)
+
+	// not ready before the first rescan
+	code, res := get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.Nil(t, res.LastScanAt)
+
+	GlobalSourceStatuses.Set([]SourceStatus{
+		{Source: "/var/log/*.log", Type: TypeFile, Files: 2},
+		{Source: "/var/log/*.log", Type: TypeSSH, Host: "web1", Error: "ssh: handshake failed: ssh: unable to authenticate, attempted methods [none publickey]"},
+		{Source: "/var/log/*.log", Type: TypeSSH, Host: "web2", Error: "dial tcp 10.0.0.2:22: connect: connection refused"},
+		{Source: "/srv/*.log", Type: TypeFile},
+	})
+	GlobalSourceStatuses.MarkScanned()
+	code, res = get("/readyz")
+	assert.Equal(t, http.StatusOK, code)
+	assert.True(t, res.Ready)
+	assert.NotNil(t, res.LastScanAt)
+	statuses := map[string]string{}
+	for _, source := range res.Sources {
+		statuses[source.Type+" "+source.Host+" "+source.Pattern] = source.Status
+	}
+	assert.Equal(t, map[string]string{
+		"file  /var/log/*.log":    SourceOK,
+		"file  /srv/*.log":        SourceNoMatches,
+		"ssh web1 /var/log/*.log": SourceAuthFailed,
+		"ssh web2 /var/log/*.log": SourceUnreachable,
+	}, statuses)
+
+	options.RequireAllSources = true
+	e = newTestEcho(options)
+	code, res = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+	assert.False(t, res.Ready)
+
+	// no source reachable
+	options.RequireAllSources = false
+	e = newTestEcho(options)
+	GlobalSourceStatuses.Set([]SourceStatus{{Source: "/var/log/*.log", Type: TypeSSH, Host: "web2", Pending: true}})
+	code, _ = get("/readyz")
+	assert.Equal(t, http.StatusServiceUnavailable, code)
+}
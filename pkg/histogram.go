@@ -0,0 +1,326 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/acarl005/stripansi"
+	"github.com/labstack/echo/v4"
+	"github.com/mcuadros/go-defaults"
+)
+
+const (
+	// DefaultHistogramBuckets is the number of buckets of a histogram without buckets
+	DefaultHistogramBuckets = 60
+	// MaxHistogramBuckets is the number of buckets a histogram has at most
+	MaxHistogramBuckets = 1000
+	// DefaultHistogramDeadline is how long a histogram is counted before its partial counts are returned
+	DefaultHistogramDeadline = 10 * time.Second
+
+	// histogramByteSlots is how many slots per bucket lines are counted in by offset, before the file
+	// size they are divided by is known
+	histogramByteSlots = 8
+)
+
+// HistogramBucket is the count of the matching lines of a bucket, starting at a time or, for files
+// without timestamps, at a byte offset
+type HistogramBucket struct {
+	Start  *time.Time `json:"bucket_start,omitempty"`
+	Offset *int64     `json:"bucket_offset,omitempty"`
+	Count  int        `json:"count"`
+}
+
+// Histogram is the number of matching lines over time, or over the file for files without timestamps
+type Histogram struct {
+	Buckets []HistogramBucket `json:"buckets"`
+	// ByOffset is set when no line had a timestamp, the buckets split the bytes of the file instead
+	ByOffset bool `json:"by_offset"`
+	// Truncated is set when the deadline passed before the end of the range, the buckets are partial
+	Truncated bool             `json:"truncated"`
+	Warnings  []SegmentWarning `json:"warnings,omitempty"`
+}
+
+// Histogram counts the lines of filePaths kept by the patterns and filters of the watcher into buckets
+// of equal length from from to to, by the timestamp of the line or, for a line without one, of the dated
+// line before it. The time range set on the watcher seeks to from in local plain files. When no line has
+// a timestamp, the lines are counted by their offset in the files instead, as if they were one. When ctx
+// passes its deadline the counts so far are returned as truncated.
+func (w *Watcher) Histogram(ctx context.Context, filePaths []string, from, to time.Time, buckets int) (*Histogram, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	match, ignore, err := w.lineMatchers()
+	if err != nil {
+		return nil, err
+	}
+	counts := make([]int, buckets)
+	histogram := &Histogram{}
+	dated := false
+	for _, filePath := range filePaths {
+		segmentDated, err := w.histogramSegment(ctx, filePath, match, ignore, from, to, counts)
+		dated = dated || segmentDated
+		if done, err := histogram.stop(ctx, filePaths, err); done {
+			if err != nil {
+				return histogram, err
+			}
+			break
+		}
+	}
+	if !dated && !histogram.Truncated {
+		return w.byteHistogram(ctx, filePaths, match, ignore, buckets)
+	}
+
+	span := to.Sub(from)
+	for i, count := range counts {
+		start := from.Add(span * time.Duration(i) / time.Duration(buckets))
+		histogram.Buckets = append(histogram.Buckets, HistogramBucket{Start: &start, Count: count})
+	}
+	return histogram, nil
+}
+
+// stop tells whether counting stops after the error of a segment: a segment that cannot be read is
+// skipped with a warning, unless it is the only one, and a passed deadline truncates the histogram
+func (h *Histogram) stop(ctx context.Context, filePaths []string, err error) (bool, error) {
+	var readErr *SegmentReadError
+	switch {
+	case err == nil:
+		return false, nil
+	case errors.Is(err, context.DeadlineExceeded) && ctx.Err() != nil:
+		h.Truncated = true
+		return true, nil
+	case len(filePaths) > 1 && errors.As(err, &readErr):
+		h.Warnings = append(h.Warnings, readErr.Warning())
+		return false, nil
+	default:
+		return true, err
+	}
+}
+
+// histogramSegment counts the lines of a segment into counts, and tells whether it had a timestamp
+func (w *Watcher) histogramSegment(ctx context.Context, filePath string, match, ignore lineMatcher, from, to time.Time, counts []int) (bool, error) {
+	file, scanner, start, err := w.openScannerAt(filePath)
+	if err != nil {
+		return false, &SegmentReadError{FilePath: filePath, Err: err}
+	}
+	if file != nil {
+		defer file.Close()
+	}
+	var loc *time.Location
+	if w.timeRange != nil {
+		loc = w.timeRange.Location
+	}
+	span := to.Sub(from)
+	var last time.Time
+	dated := false
+	for scanned := 0; scanner.Scan(); scanned++ {
+		if scanned%collectCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return dated, err
+			}
+		}
+		line := scanner.Bytes()
+		content := ""
+		if hasANSI(line) {
+			content = stripansi.Strip(string(line))
+			line = []byte(content)
+		}
+		if ts, ok := LineTime(line, loc); ok {
+			last, dated = ts, true
+		}
+		if start.timeRange != nil {
+			in, done := start.timeRange.line(line)
+			if done {
+				break
+			}
+			if !in {
+				continue
+			}
+		}
+		if _, _, _, ok := w.keepLine(match, ignore, line, content); !ok {
+			continue
+		}
+		if last.Before(from) || last.After(to) {
+			continue
+		}
+		i := int(last.Sub(from) * time.Duration(len(counts)) / span)
+		counts[min(i, len(counts)-1)]++
+	}
+	return dated, scanner.Err()
+}
+
+// byteHistogram counts the lines of filePaths, read as one, into buckets splitting their bytes. Lines
+// are counted in slots by offset, merged by two whenever there are too many, so that the size is known
+// once they are read only.
+func (w *Watcher) byteHistogram(ctx context.Context, filePaths []string, match, ignore lineMatcher, buckets int) (*Histogram, error) {
+	histogram := &Histogram{ByOffset: true}
+	slots := &byteSlots{size: 1, max: buckets * histogramByteSlots}
+	var offset int64
+	for _, filePath := range filePaths {
+		err := w.byteHistogramSegment(ctx, filePath, match, ignore, slots, &offset)
+		if done, err := histogram.stop(ctx, filePaths, err); done {
+			if err != nil {
+				return histogram, err
+			}
+			break
+		}
+	}
+
+	counts := make([]int, buckets)
+	for i, count := range slots.counts {
+		if offset > 0 {
+			middle := int64(i)*slots.size + slots.size/2
+			counts[min(int(middle*int64(buckets)/offset), buckets-1)] += count
+		}
+	}
+	for i, count := range counts {
+		start := offset * int64(i) / int64(buckets)
+		histogram.Buckets = append(histogram.Buckets, HistogramBucket{Offset: &start, Count: count})
+	}
+	return histogram, nil
+}
+
+func (w *Watcher) byteHistogramSegment(ctx context.Context, filePath string, match, ignore lineMatcher, slots *byteSlots, offset *int64) error {
+	file, scanner, err := w.openScanner(filePath)
+	if err != nil {
+		return &SegmentReadError{FilePath: filePath, Err: err}
+	}
+	if file != nil {
+		defer file.Close()
+	}
+	for scanned := 0; scanner.Scan(); scanned++ {
+		if scanned%collectCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		line := scanner.Bytes()
+		lineOffset := *offset
+		*offset += int64(len(line)) + 1
+		content := ""
+		if hasANSI(line) {
+			content = stripansi.Strip(string(line))
+			line = []byte(content)
+		}
+		if _, _, _, ok := w.keepLine(match, ignore, line, content); ok {
+			slots.add(lineOffset)
+		}
+	}
+	return scanner.Err()
+}
+
+// byteSlots count lines by offset in slots of size bytes, doubled whenever more than max are needed
+type byteSlots struct {
+	size   int64
+	max    int
+	counts []int
+}
+
+func (s *byteSlots) add(offset int64) {
+	for offset/s.size >= int64(s.max) {
+		merged := make([]int, (len(s.counts)+1)/2, s.max)
+		for i, count := range s.counts {
+			merged[i/2] += count
+		}
+		s.counts, s.size = merged, s.size*2
+	}
+	i := int(offset / s.size)
+	for len(s.counts) <= i {
+		s.counts = append(s.counts, 0)
+	}
+	s.counts[i]++
+}
+
+type HistogramRequest struct {
+	Query    string `json:"query" query:"query"`
+	Ignore   string `json:"ignore" query:"ignore"`
+	ID       string `json:"id" query:"id"`
+	FilePath string `json:"file_path" query:"file_path" validate:"required" message:"file_path is required"`
+	Host     string `json:"host" query:"host"`
+	Type     string `json:"type" query:"type" validate:"required" message:"type is required"`
+	// From and To (RFC 3339, or without an offset in Timezone) are the time the buckets split
+	From     string `json:"from" query:"from" validate:"required" message:"from is required"`
+	To       string `json:"to" query:"to" validate:"required" message:"to is required"`
+	Timezone string `json:"tz" query:"tz"`
+	// Levels (comma separated, like error,warn) count the lines of these levels only
+	Levels  string `json:"levels" query:"levels"`
+	Buckets int    `json:"buckets" query:"buckets" default:"60" validate:"gte=1,lte=1000" message:"buckets between 1 and 1000 is required"`
+}
+
+// GetHistogram counts the matching lines of a file in buckets of time from from to to
+func (h *APIHandler) GetHistogram(c echo.Context) error {
+	req := new(HistogramRequest)
+	if err := BindRequest(c, req); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err)
+	}
+	if err := resolveFileID(req.ID, &req.FilePath, &req.Host, &req.Type); err != nil {
+		return err
+	}
+	defaults.SetDefaults(req)
+	msgs, err := ValidateRequest(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, msgs)
+	}
+	if err := AuthorizeFilePath(req.FilePath, req.Type, req.Host); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err)
+	}
+	if _, err := GlobalPatternLimits.Check(req.Query, req.Ignore); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	loc, err := timeLocation(req.Timezone, GlobalPathDefaults.For(req.FilePath))
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	from, to, err := parseTimeRangeIn(req.From, req.To, loc)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	if !to.After(from) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "to must be after from")
+	}
+	levels, err := ParseLevels(req.Levels)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	release, err := acquireRead(c, req.Type, req.Host, req.FilePath)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	switch {
+	case req.Type == TypeRemoteGol:
+		return h.proxyRemote(c, "api/histogram", req.Host, req.FilePath)
+	case req.Type == TypeDocker && !strings.HasPrefix(req.FilePath, TmpContainerPath):
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "histograms are not supported for files inside containers")
+	}
+	watcher, err := h.newWatcher(req.Type, req.Host, req.FilePath, req.Query, req.Ignore)
+	if err != nil {
+		return err
+	}
+	if levels != nil {
+		watcher.SetLevels(levels)
+	}
+	watcher.SetTimeRange(TimeRange{From: from, To: to, Location: loc})
+
+	filePaths := []string{req.FilePath}
+	var warnings []SegmentWarning
+	if req.Type == TypeFile {
+		resolution, err := GlobalSegmentTimeRanges.Resolve(LogicalSegments(req.FilePath), from, to)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		filePaths, warnings = resolution.FilePaths(), resolution.Warnings
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), GlobalHistogramDeadline)
+	defer cancel()
+	histogram, err := watcher.Histogram(ctx, filePaths, from, to, req.Buckets)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, fmt.Errorf("counting %s: %w", req.FilePath, err))
+	}
+	histogram.Warnings = append(warnings, histogram.Warnings...)
+	return c.JSON(http.StatusOK, histogram)
+}
@@ -0,0 +1,107 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIHandler_GetHistogram(t *testing.T) {
+	dir := t.TempDir()
+	dated := filepath.Join(dir, "app.log")
+	lines := []string{"starting"}
+	for minute := 0; minute < 10; minute++ {
+		lines = append(lines,
+			fmt.Sprintf("2024-06-01T12:%02d:00Z INFO request %d", minute, minute),
+			"  continued",
+			fmt.Sprintf("2024-06-01T12:%02d:30Z ERROR request %d failed", minute, minute))
+	}
+	assert.NoError(t, os.WriteFile(dated, []byte(strings.Join(lines, "\n")+"\n"), 0600))
+	undated := filepath.Join(dir, "plain.log")
+	assert.NoError(t, os.WriteFile(undated, []byte(numberedLines(1, 100)), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: dated, Type: TypeFile}, {FilePath: undated, Type: TypeFile}})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	get := func(filePath, query string) (*httptest.ResponseRecorder, Histogram) {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/histogram?type=file&file_path="+filePath+query, nil))
+		histogram := Histogram{}
+		if rec.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &histogram))
+		}
+		return rec, histogram
+	}
+	counts := func(histogram Histogram) []int {
+		counts := []int{}
+		for _, bucket := range histogram.Buckets {
+			counts = append(counts, bucket.Count)
+		}
+		return counts
+	}
+
+	// lines without a timestamp count with the line before them, those before any are not in the range
+	rec, histogram := get(dated, "&from=2024-06-01T12:00:00Z&to=2024-06-01T12:10:00Z&buckets=5")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.False(t, histogram.ByOffset)
+	assert.False(t, histogram.Truncated)
+	assert.Equal(t, []int{6, 6, 6, 6, 6}, counts(histogram))
+	assert.Equal(t, "2024-06-01T12:02:00Z", histogram.Buckets[1].Start.UTC().Format("2006-01-02T15:04:05Z07:00"))
+	assert.Nil(t, histogram.Buckets[1].Offset)
+
+	// the query and levels filter the lines counted, the range seeks into the file
+	_, histogram = get(dated, "&from=2024-06-01T12:04:00Z&to=2024-06-01T12:08:00Z&buckets=2&levels=error")
+	assert.Equal(t, []int{2, 2}, counts(histogram))
+	_, histogram = get(dated, "&from=2024-06-01T12:00:00Z&to=2024-06-01T12:10:00Z&buckets=1&query=request+[13]")
+	assert.Equal(t, []int{4}, counts(histogram))
+	_, histogram = get(dated, "&from=2024-06-01T11:00:00Z&to=2024-06-01T12:10:00Z&buckets=1&query=starting")
+	assert.Equal(t, []int{0}, counts(histogram))
+
+	// without any timestamp, lines are counted by their offset in the file
+	rec, histogram = get(undated, "&from=2024-06-01T12:00:00Z&to=2024-06-01T12:10:00Z&buckets=4&query=line+[0-9]$")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, histogram.ByOffset)
+	assert.Equal(t, []int{9, 0, 0, 0}, counts(histogram))
+	assert.Nil(t, histogram.Buckets[0].Start)
+	assert.Equal(t, int64(0), *histogram.Buckets[0].Offset)
+	_, histogram = get(undated, "&from=2024-06-01T12:00:00Z&to=2024-06-01T12:10:00Z&buckets=4")
+	total := 0
+	for _, count := range counts(histogram) {
+		assert.InDelta(t, 25, count, 4)
+		total += count
+	}
+	assert.Equal(t, 100, total)
+	assert.Equal(t, int64(len(numberedLines(1, 100))*3/4), *histogram.Buckets[3].Offset)
+
+	// past the deadline the counts so far are returned
+	deadline := GlobalHistogramDeadline
+	t.Cleanup(func() { GlobalHistogramDeadline = deadline })
+	GlobalHistogramDeadline = -1
+	rec, histogram = get(dated, "&from=2024-06-01T12:00:00Z&to=2024-06-01T12:10:00Z&buckets=5")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.True(t, histogram.Truncated)
+	assert.Len(t, histogram.Buckets, 5)
+	GlobalHistogramDeadline = deadline
+
+	rec, _ = get(dated, "&from=2024-06-01T12:10:00Z&to=2024-06-01T12:00:00Z")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec, _ = get(dated, "&from=2024-06-01T12:00:00Z&to=2024-06-01T12:10:00Z&buckets=1001")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec, _ = get(dated, "&to=2024-06-01T12:10:00Z")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
+
+func TestByteSlots(t *testing.T) {
+	slots := &byteSlots{size: 1, max: 4}
+	for offset := int64(0); offset < 16; offset++ {
+		slots.add(offset)
+	}
+	// the slots double until the offsets fit in max of them
+	assert.Equal(t, int64(4), slots.size)
+	assert.Equal(t, []int{4, 4, 4, 4}, slots.counts)
+}
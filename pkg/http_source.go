@@ -0,0 +1,378 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultHTTPMaxSize is how much of an HTTP source served without Range support is downloaded
+	DefaultHTTPMaxSize      = 64 * 1024 * 1024
+	DefaultHTTPMaxRedirects = 5
+	// httpHeaderTimeout is how long a request to an HTTP source waits for the headers of its answer
+	httpHeaderTimeout = 30 * time.Second
+)
+
+// IsHTTPURL tells whether a file pattern is the URL of an HTTP source
+func IsHTTPURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// HTTPHeader is a header sent to the HTTP sources whose URL starts with Prefix, to all of them when empty
+type HTTPHeader struct {
+	Prefix string
+	Name   string
+	Value  string
+}
+
+// ParseHTTPHeader parses "[https://host/prefix] Name: value"
+func ParseHTTPHeader(s string) (HTTPHeader, error) {
+	header := HTTPHeader{}
+	s = strings.TrimSpace(s)
+	if IsHTTPURL(s) {
+		prefix, rest, _ := strings.Cut(s, " ")
+		header.Prefix, s = prefix, strings.TrimSpace(rest)
+	}
+	name, value, ok := strings.Cut(s, ":")
+	name = strings.TrimSpace(name)
+	if !ok || name == "" || strings.ContainsAny(name, " \t") {
+		return header, fmt.Errorf("http header must be \"[url-prefix] Name: value\", got %q", s)
+	}
+	header.Name, header.Value = http.CanonicalHeaderKey(name), strings.TrimSpace(value)
+	return header, nil
+}
+
+// HTTPStatusError is an HTTP source answering other than 200 or 206
+type HTTPStatusError struct {
+	URL        string
+	StatusCode int
+	Status     string
+}
+
+func (e *HTTPStatusError) Error() string {
+	return fmt.Sprintf("%s: %s", e.URL, e.Status)
+}
+
+// HTTPSources read the log files served at HTTP(S) URLs. Servers supporting Range requests are
+// read a range at a time, so that a tail or a page reads only its bytes, the others are downloaded
+// whole up to maxSize.
+type HTTPSources struct {
+	client  *http.Client
+	headers []HTTPHeader
+	maxSize int64
+}
+
+func NewHTTPSources(headers []HTTPHeader, maxSize int64, maxRedirects int) *HTTPSources {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.ResponseHeaderTimeout = httpHeaderTimeout
+	// the ranges read are of the bytes as stored
+	transport.DisableCompression = true
+	return &HTTPSources{
+		client: &http.Client{
+			Transport: transport,
+			// net/http sends the headers along redirects, an Authorization to the same domain only
+			CheckRedirect: func(_ *http.Request, via []*http.Request) error {
+				if len(via) > maxRedirects {
+					return fmt.Errorf("stopped after %d redirects", maxRedirects)
+				}
+				return nil
+			},
+		},
+		headers: headers,
+		maxSize: maxSize,
+	}
+}
+
+// headersOf are the headers sent to rawURL
+func (s *HTTPSources) headersOf(rawURL string) []HTTPHeader {
+	headers := []HTTPHeader{}
+	for _, header := range s.headers {
+		if strings.HasPrefix(rawURL, header.Prefix) {
+			headers = append(headers, header)
+		}
+	}
+	return headers
+}
+
+// request sends a request to rawURL, asking for the bytes of byteRange unless empty. Answers other
+// than 200 and 206 are returned as an *HTTPStatusError.
+func (s *HTTPSources) request(ctx context.Context, method string, rawURL string, byteRange string) (*http.Response, error) {
+	req, err := http.NewRequestWithContext(ctx, method, rawURL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for _, header := range s.headersOf(rawURL) {
+		req.Header.Set(header.Name, header.Value)
+	}
+	if byteRange != "" {
+		req.Header.Set("Range", "bytes="+byteRange)
+	}
+	res, err := s.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if res.StatusCode != http.StatusOK && res.StatusCode != http.StatusPartialContent {
+		res.Body.Close()
+		return nil, &HTTPStatusError{URL: rawURL, StatusCode: res.StatusCode, Status: res.Status}
+	}
+	return res, nil
+}
+
+// httpFileInfo is the fs.FileInfo of an HTTP source
+type httpFileInfo struct {
+	name    string
+	size    int64
+	modTime time.Time
+}
+
+func (i httpFileInfo) Name() string       { return i.name }
+func (i httpFileInfo) Size() int64        { return i.size }
+func (i httpFileInfo) Mode() fs.FileMode  { return 0444 }
+func (i httpFileInfo) ModTime() time.Time { return i.modTime }
+func (i httpFileInfo) IsDir() bool        { return false }
+func (i httpFileInfo) Sys() interface{}   { return nil }
+
+// Stat returns the size and modification time of the source from a HEAD request, and whether it
+// answers Range requests. Servers refusing HEAD, like presigned URLs signed for GET, are asked for
+// their first byte instead.
+func (s *HTTPSources) Stat(ctx context.Context, rawURL string) (fs.FileInfo, bool, error) {
+	info := httpFileInfo{name: path.Base(rawURL)}
+	if u, err := url.Parse(rawURL); err == nil {
+		info.name = path.Base(u.Path)
+	}
+	res, err := s.request(ctx, http.MethodHead, rawURL, "")
+	var statusErr *HTTPStatusError
+	if errors.As(err, &statusErr) && (statusErr.StatusCode == http.StatusForbidden || statusErr.StatusCode == http.StatusMethodNotAllowed) {
+		res, err = s.request(ctx, http.MethodGet, rawURL, "0-0")
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	res.Body.Close()
+	info.modTime, _ = http.ParseTime(res.Header.Get("Last-Modified"))
+
+	ranges := res.Header.Get("Accept-Ranges") == "bytes"
+	info.size = res.ContentLength
+	if res.StatusCode == http.StatusPartialContent {
+		// "bytes 0-0/12345"
+		_, total, _ := strings.Cut(res.Header.Get("Content-Range"), "/")
+		info.size, err = strconv.ParseInt(total, 10, 64)
+		ranges = err == nil
+	}
+	if info.size < 0 {
+		info.size, ranges = 0, false
+	}
+	return info, ranges, nil
+}
+
+// Open opens the source for reading. A source answering Range requests is read a range at a time,
+// another is downloaded into memory, failing when larger than maxSize.
+func (s *HTTPSources) Open(ctx context.Context, rawURL string) (File, error) {
+	info, ranges, err := s.Stat(ctx, rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if ranges {
+		get := func(ctx context.Context, byteRange string) (*http.Response, error) {
+			return s.request(ctx, http.MethodGet, rawURL, byteRange)
+		}
+		return &httpFile{get: get, name: rawURL, info: info.(httpFileInfo)}, nil
+	}
+	res, err := s.request(ctx, http.MethodGet, rawURL, "")
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	b, err := io.ReadAll(io.LimitReader(res.Body, s.maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(b)) > s.maxSize {
+		return nil, fmt.Errorf("%s: larger than -http-max-size %d without Range support", rawURL, s.maxSize)
+	}
+	memInfo := info.(httpFileInfo)
+	memInfo.size = int64(len(b))
+	return &memoryFile{Reader: bytes.NewReader(b), info: memInfo}, nil
+}
+
+// FileInfo lists the source, with the error of the source as its warning when it cannot be read.
+// Its line count is the cached one of an unchanged source, sources are counted by the reads needing it.
+func (s *HTTPSources) FileInfo(ctx context.Context, rawURL string) (FileInfo, error) {
+	fileInfo := FileInfo{FilePath: rawURL, Type: TypeHTTP, Name: path.Base(rawURL)}
+	if u, err := url.Parse(rawURL); err == nil {
+		fileInfo.Host = u.Host
+		fileInfo.Name = path.Base(u.Path)
+	}
+	info, _, err := s.Stat(ctx, rawURL)
+	if err != nil {
+		fileInfo.Warning = err.Error()
+		return fileInfo, err
+	}
+	fileInfo.FileSize = info.Size()
+	if !info.ModTime().IsZero() {
+		modTime := info.ModTime()
+		fileInfo.ModTime = &modTime
+	}
+	setCachedLinesCount(&fileInfo, info.ModTime())
+	return fileInfo, nil
+}
+
+// setCachedLinesCount sets the line count of a source listed without being read, the count cached
+// by the last read when its size and modTime did not change since
+func setCachedLinesCount(fileInfo *FileInfo, modTime time.Time) {
+	if entry, ok := GlobalFileStatsCache.Peek(fileInfo.FilePath); ok && entry.Size == fileInfo.FileSize && entry.ModTime == modTime.UnixNano() {
+		fileInfo.LinesCount = entry.LinesCount
+		fileInfo.Generation = entry.Generation
+	}
+}
+
+// httpFile reads an HTTP source or an S3 object with Range requests. Reads stream from the offset
+// until the next seek, a ReadAt is a request of its own.
+type httpFile struct {
+	// get requests the bytes of byteRange, "from-" or "from-to"
+	get    func(ctx context.Context, byteRange string) (*http.Response, error)
+	name   string
+	info   httpFileInfo
+	offset int64
+	// body is the stream of the reads, from the offset it was opened at on
+	body io.ReadCloser
+}
+
+func (f *httpFile) Read(p []byte) (int, error) {
+	if f.offset >= f.info.size {
+		return 0, io.EOF
+	}
+	if f.body == nil {
+		res, err := f.get(context.Background(), fmt.Sprintf("%d-", f.offset))
+		if err != nil {
+			return 0, err
+		}
+		if err := skipToRange(res, f.offset); err != nil {
+			res.Body.Close()
+			return 0, err
+		}
+		f.body = res.Body
+	}
+	n, err := f.body.Read(p)
+	f.offset += int64(n)
+	return n, err
+}
+
+func (f *httpFile) ReadAt(p []byte, off int64) (int, error) {
+	if off >= f.info.size {
+		return 0, io.EOF
+	}
+	end := min(off+int64(len(p)), f.info.size) - 1
+	res, err := f.get(context.Background(), fmt.Sprintf("%d-%d", off, end))
+	if err != nil {
+		return 0, err
+	}
+	defer res.Body.Close()
+	if err := skipToRange(res, off); err != nil {
+		return 0, err
+	}
+	n, err := io.ReadFull(res.Body, p[:end-off+1])
+	if err == nil && n < len(p) {
+		err = io.EOF
+	}
+	return n, err
+}
+
+// skipToRange skips the body of a source that ignored the Range request to offset
+func skipToRange(res *http.Response, offset int64) error {
+	if res.StatusCode == http.StatusPartialContent || offset == 0 {
+		return nil
+	}
+	_, err := io.CopyN(io.Discard, res.Body, offset)
+	return err
+}
+
+func (f *httpFile) Seek(offset int64, whence int) (int64, error) {
+	switch whence {
+	case io.SeekCurrent:
+		offset += f.offset
+	case io.SeekEnd:
+		offset += f.info.size
+	}
+	if offset < 0 {
+		return 0, fmt.Errorf("%s: negative offset", f.name)
+	}
+	if offset != f.offset && f.body != nil {
+		f.body.Close()
+		f.body = nil
+	}
+	f.offset = offset
+	return offset, nil
+}
+
+func (f *httpFile) Close() error {
+	if f.body != nil {
+		return f.body.Close()
+	}
+	return nil
+}
+
+func (f *httpFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// memoryFile is an HTTP source downloaded whole
+type memoryFile struct {
+	*bytes.Reader
+	info httpFileInfo
+}
+
+func (f *memoryFile) Close() error {
+	return nil
+}
+
+func (f *memoryFile) Stat() (fs.FileInfo, error) {
+	return f.info, nil
+}
+
+// httpFileInfos lists the HTTP URLs among the file patterns, a source that cannot be read is listed
+// with its error as warning
+func httpFileInfos(filePaths SliceFlags) ([]FileInfo, []SourceStatus) {
+	fileInfos := []FileInfo{}
+	statuses := []SourceStatus{}
+	for _, labeled := range filePaths {
+		label, rawURL := ParseFilePattern(labeled)
+		if !IsHTTPURL(rawURL) {
+			continue
+		}
+		fileInfo, err := GlobalHTTPSources.FileInfo(context.Background(), rawURL)
+		if err != nil {
+			slog.Warn("reading HTTP source", "url", rawURL, "error", err)
+		}
+		fileInfo.Group = label
+		listed := []FileInfo{fileInfo}
+		if err != nil {
+			listed = nil
+		}
+		statuses = append(statuses, newSourceStatus(labeled, TypeHTTP, fileInfo.Host, listed, err))
+		fileInfos = append(fileInfos, fileInfo)
+	}
+	return fileInfos, statuses
+}
+
+// openFile opens the source of an HTTP URL, the object of an S3 URL, or else the file of opener
+func openFile(opener FileOpener, filePath string) (File, error) {
+	if IsHTTPURL(filePath) {
+		return GlobalHTTPSources.Open(context.Background(), filePath)
+	}
+	if IsS3URL(filePath) && GlobalS3Source != nil {
+		return GlobalS3Source.Open(context.Background(), filePath)
+	}
+	return opener.Open(filePath)
+}
@@ -0,0 +1,207 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func useHTTPSources(t *testing.T, headers []HTTPHeader, maxSize int64, maxRedirects int) {
+	sources, cache := GlobalHTTPSources, GlobalFileStatsCache
+	t.Cleanup(func() { GlobalHTTPSources, GlobalFileStatsCache = sources, cache })
+	GlobalHTTPSources = NewHTTPSources(headers, maxSize, maxRedirects)
+	GlobalFileStatsCache = NewFileStatsCache()
+}
+
+// httpRequests records the requests of a test server
+type httpRequests struct {
+	mutex    sync.Mutex
+	requests []*http.Request
+}
+
+func (r *httpRequests) add(req *http.Request) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.requests = append(r.requests, req)
+}
+
+// gets are the Range headers of the GET requests, "" for a request of the whole body
+func (r *httpRequests) gets() []string {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	ranges := []string{}
+	for _, req := range r.requests {
+		if req.Method == http.MethodGet {
+			ranges = append(ranges, req.Header.Get("Range"))
+		}
+	}
+	return ranges
+}
+
+func newHTTPSourceServer(t *testing.T, content string, ranges bool) (*httptest.Server, *httpRequests) {
+	requests := &httpRequests{}
+	modTime := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.add(r)
+		if r.URL.Path == "/missing.log" {
+			http.NotFound(w, r)
+			return
+		}
+		if ranges {
+			http.ServeContent(w, r, "app.log", modTime, strings.NewReader(content))
+			return
+		}
+		w.Header().Set("Content-Length", strconv.Itoa(len(content)))
+		w.Header().Set("Last-Modified", modTime.Format(http.TimeFormat))
+		if r.Method != http.MethodHead {
+			io.WriteString(w, content) //nolint: errcheck
+		}
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestParseHTTPHeader(t *testing.T) {
+	header, err := ParseHTTPHeader("Authorization: Bearer xyz")
+	assert.NoError(t, err)
+	assert.Equal(t, HTTPHeader{Name: "Authorization", Value: "Bearer xyz"}, header)
+
+	header, err = ParseHTTPHeader("https://logs.example.com/app/ x-api-key:  abc ")
+	assert.NoError(t, err)
+	assert.Equal(t, HTTPHeader{Prefix: "https://logs.example.com/app/", Name: "X-Api-Key", Value: "abc"}, header)
+
+	for _, invalid := range []string{"", "Authorization", ": value", "https://logs.example.com Bad Name: value"} {
+		_, err := ParseHTTPHeader(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestHTTPSources_Range(t *testing.T) {
+	useHTTPSources(t, nil, DefaultHTTPMaxSize, DefaultHTTPMaxRedirects)
+	content := numberedLines(1, 20000)
+	server, requests := newHTTPSourceServer(t, content, true)
+	rawURL := server.URL + "/app.log"
+
+	fileInfo, err := GlobalHTTPSources.FileInfo(context.Background(), rawURL)
+	assert.NoError(t, err)
+	assert.Equal(t, TypeHTTP, fileInfo.Type)
+	assert.Equal(t, strings.TrimPrefix(server.URL, "http://"), fileInfo.Host)
+	assert.Equal(t, "app.log", fileInfo.Name)
+	assert.Equal(t, int64(len(content)), fileInfo.FileSize)
+	assert.Empty(t, fileInfo.Warning)
+
+	// a tail reads the end of the source only
+	lines, err := ReadTailLinesContext(context.Background(), rawURL, 3, false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"line 19998", "line 19999", "line 20000"}, lines)
+	for _, byteRange := range requests.gets() {
+		assert.NotEmpty(t, byteRange)
+		assert.NotEqual(t, "bytes=0-", byteRange)
+	}
+
+	// a byte window reads its bytes
+	window, err := ReadByteWindow(rawURL, int64(len(content))-20, 20, "", false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, "line 20000\n", window.Content[len(window.Content)-11:])
+	assert.True(t, window.EOF)
+
+	// the lines are counted streaming the source once
+	linesCount, fileSize, err := FileStatsContext(context.Background(), rawURL, false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 20000, linesCount)
+	assert.Equal(t, int64(len(content)), fileSize)
+	fileInfo, err = GlobalHTTPSources.FileInfo(context.Background(), rawURL)
+	assert.NoError(t, err)
+	assert.Equal(t, 20000, fileInfo.LinesCount)
+}
+
+func TestHTTPSources_WithoutRange(t *testing.T) {
+	content := numberedLines(1, 100)
+	server, requests := newHTTPSourceServer(t, content, false)
+	rawURL := server.URL + "/app.log"
+
+	useHTTPSources(t, nil, int64(len(content)), DefaultHTTPMaxRedirects)
+	lines, err := ReadTailLinesContext(context.Background(), rawURL, 2, false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"line 99", "line 100"}, lines)
+	assert.Equal(t, []string{""}, requests.gets())
+
+	// larger than the cap, the source is not downloaded
+	useHTTPSources(t, nil, int64(len(content))-1, DefaultHTTPMaxRedirects)
+	_, err = ReadTailLinesContext(context.Background(), rawURL, 2, false, nil)
+	assert.ErrorContains(t, err, "larger than -http-max-size")
+}
+
+func TestHTTPSources_Errors(t *testing.T) {
+	useHTTPSources(t, []HTTPHeader{{Name: "Authorization", Value: "Bearer xyz"}, {Prefix: "http://other.example.com", Name: "X-Other", Value: "1"}}, DefaultHTTPMaxSize, 2)
+	server, requests := newHTTPSourceServer(t, "line 1\n", true)
+	redirects := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n, _ := strconv.Atoi(strings.TrimPrefix(r.URL.Path, "/"))
+		if n == 0 {
+			http.Redirect(w, r, server.URL+"/app.log", http.StatusFound)
+			return
+		}
+		http.Redirect(w, r, "/"+strconv.Itoa(n-1), http.StatusFound)
+	}))
+	defer redirects.Close()
+
+	// a missing source is listed with the status as warning
+	fileInfo, err := GlobalHTTPSources.FileInfo(context.Background(), server.URL+"/missing.log")
+	var statusErr *HTTPStatusError
+	assert.ErrorAs(t, err, &statusErr)
+	assert.Equal(t, http.StatusNotFound, statusErr.StatusCode)
+	assert.Equal(t, server.URL+"/missing.log: 404 Not Found", fileInfo.Warning)
+	assert.Equal(t, "missing.log", fileInfo.Name)
+
+	// redirects are followed up to the limit, with the headers of the URL
+	_, err = GlobalHTTPSources.FileInfo(context.Background(), redirects.URL+"/1")
+	assert.NoError(t, err)
+	_, err = GlobalHTTPSources.FileInfo(context.Background(), redirects.URL+"/2")
+	assert.ErrorContains(t, err, "stopped after 2 redirects")
+	requests.mutex.Lock()
+	defer requests.mutex.Unlock()
+	for _, req := range requests.requests {
+		assert.Equal(t, "Bearer xyz", req.Header.Get("Authorization"))
+		assert.Empty(t, req.Header.Get("X-Other"))
+	}
+}
+
+func TestAPIHandler_HTTPSource(t *testing.T) {
+	useHTTPSources(t, nil, DefaultHTTPMaxSize, DefaultHTTPMaxRedirects)
+	server, _ := newHTTPSourceServer(t, numberedLines(1, 50), true)
+	rawURL := server.URL + "/app.log"
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+
+	fileInfos, statuses := httpFileInfos([]string{"web:" + rawURL, server.URL + "/missing.log", "/var/log/*.log"})
+	assert.Len(t, fileInfos, 2)
+	assert.Equal(t, "web", fileInfos[0].Group)
+	assert.NotEmpty(t, fileInfos[1].Warning)
+	assert.Equal(t, 1, statuses[0].Files)
+	assert.NotEmpty(t, statuses[1].Error)
+	fileInfos = SetFileIDs(fileInfos)
+	GlobalFileRegistry.Replace(fileInfos)
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api?id="+fileInfos[0].ID+"&query=line+4&per_page=3&reverse=true", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var response APIResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, []string{"line 47", "line 48", "line 49"}, mergedContents(response.Result.Lines))
+
+	// the source is not tailed, there is nothing to watch
+	rec = httptest.NewRecorder()
+	query := url.Values{"type": {TypeHTTP}, "host": {fileInfos[0].Host}, "file_path": {rawURL}}
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/tail?"+query.Encode(), nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
@@ -0,0 +1,18 @@
+//go:build !windows
+
+package pkg
+
+import (
+	"fmt"
+	"io/fs"
+	"syscall"
+)
+
+// fileSysID returns the device and inode of info, the same for every path of a file
+func fileSysID(info fs.FileInfo) (string, bool) {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return "", false
+	}
+	return fmt.Sprintf("inode:%d:%d", stat.Dev, stat.Ino), true
+}
@@ -0,0 +1,10 @@
+//go:build windows
+
+package pkg
+
+import "io/fs"
+
+// fileSysID is not implemented on windows, files are told apart by the path they resolve to
+func fileSysID(fs.FileInfo) (string, bool) {
+	return "", false
+}
@@ -0,0 +1,291 @@
+package pkg
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	JobStateRunning  = "running"
+	JobStateDone     = "done"
+	JobStateFailed   = "failed"
+	JobStateCanceled = "canceled"
+
+	// JobKindScan is the first scan of a large file counting its lines
+	JobKindScan = "scan"
+	// JobKindReplay is a time window of a file streamed at its original pace
+	JobKindReplay = "replay"
+
+	maxFinishedJobs = 100
+)
+
+// ScanJobMinSize is the size from which the first scan of a file is reported as a job
+var ScanJobMinSize int64 = 64 << 20
+
+var (
+	ErrJobNotFound       = errors.New("job not found")
+	ErrJobNotCancellable = errors.New("job is not cancellable")
+	ErrJobNotPausable    = errors.New("job is not pausable")
+)
+
+// Job is a long operation whose progress is reported while it runs
+type Job struct {
+	ID     string `json:"id"`
+	Kind   string `json:"kind"`
+	Target string `json:"target"`
+	State  string `json:"state"`
+	// Processed is in bytes, out of Total, which is 0 when unknown
+	Processed   int64      `json:"processed"`
+	Total       int64      `json:"total"`
+	Percent     float64    `json:"percent"`
+	Cancellable bool       `json:"cancellable"`
+	Pausable    bool       `json:"pausable"`
+	Paused      bool       `json:"paused"`
+	Error       string     `json:"error,omitempty"`
+	StartedAt   time.Time  `json:"started_at"`
+	FinishedAt  *time.Time `json:"finished_at,omitempty"`
+
+	cancel context.CancelFunc
+	// resume is closed when a paused job is resumed
+	resume chan struct{}
+}
+
+// Jobs is the registry of running and recently finished jobs
+type Jobs struct {
+	mutex       sync.Mutex
+	jobs        []*Job
+	subscribers map[chan struct{}]struct{}
+}
+
+func NewJobs() *Jobs {
+	return &Jobs{subscribers: map[chan struct{}]struct{}{}}
+}
+
+// JobTracker reports the progress of one job
+type JobTracker struct {
+	jobs *Jobs
+	job  *Job
+}
+
+// Start registers a job. The returned context is canceled when a cancellable job is canceled,
+// the operation must run under it and report through the tracker until Finish.
+func (j *Jobs) Start(ctx context.Context, kind string, target string, total int64, cancellable bool) (context.Context, *JobTracker) {
+	ctx, cancel := context.WithCancel(ctx)
+	job := &Job{
+		ID:          newJobID(),
+		Kind:        kind,
+		Target:      target,
+		State:       JobStateRunning,
+		Total:       total,
+		Cancellable: cancellable,
+		StartedAt:   GlobalClock.Now(),
+		cancel:      cancel,
+	}
+	j.mutex.Lock()
+	j.jobs = append(j.jobs, job)
+	j.prune()
+	j.mutex.Unlock()
+	j.notify()
+	return ctx, &JobTracker{jobs: j, job: job}
+}
+
+// Progress sets the bytes processed so far
+func (t *JobTracker) Progress(processed int64) {
+	t.jobs.mutex.Lock()
+	t.job.Processed = processed
+	if t.job.Total > 0 {
+		t.job.Percent = min(100, float64(processed)*100/float64(t.job.Total))
+	}
+	t.jobs.mutex.Unlock()
+	t.jobs.notify()
+}
+
+func (t *JobTracker) ID() string {
+	return t.job.ID
+}
+
+// EnablePause lets the job be paused, it must then call WaitWhilePaused between its steps
+func (t *JobTracker) EnablePause() {
+	t.jobs.mutex.Lock()
+	t.job.Pausable = true
+	t.jobs.mutex.Unlock()
+	t.jobs.notify()
+}
+
+// WaitWhilePaused blocks until the job is resumed or ctx is done, it returns at once when not paused
+func (t *JobTracker) WaitWhilePaused(ctx context.Context) error {
+	t.jobs.mutex.Lock()
+	resume := t.job.resume
+	t.jobs.mutex.Unlock()
+	if resume == nil {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-resume:
+		return nil
+	}
+}
+
+// Finish ends the job with the error of the operation, a canceled context makes it canceled
+func (t *JobTracker) Finish(err error) {
+	t.jobs.mutex.Lock()
+	finishedAt := GlobalClock.Now()
+	t.job.FinishedAt = &finishedAt
+	switch {
+	case err == nil:
+		t.job.State = JobStateDone
+		t.job.Percent = 100
+	case errors.Is(err, context.Canceled):
+		t.job.State = JobStateCanceled
+	default:
+		t.job.State = JobStateFailed
+		t.job.Error = err.Error()
+	}
+	t.jobs.unpause(t.job)
+	t.jobs.mutex.Unlock()
+	t.job.cancel()
+	t.jobs.notify()
+}
+
+// List returns the jobs, oldest first
+func (j *Jobs) List() []Job {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	jobs := make([]Job, 0, len(j.jobs))
+	for _, job := range j.jobs {
+		jobs = append(jobs, *job)
+	}
+	sort.SliceStable(jobs, func(a, b int) bool { return jobs[a].StartedAt.Before(jobs[b].StartedAt) })
+	return jobs
+}
+
+func (j *Jobs) Get(id string) (Job, bool) {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	for _, job := range j.jobs {
+		if job.ID == id {
+			return *job, true
+		}
+	}
+	return Job{}, false
+}
+
+// Cancel cancels the context of a running job, a finished job is left as is
+func (j *Jobs) Cancel(id string) error {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	for _, job := range j.jobs {
+		if job.ID != id {
+			continue
+		}
+		if !job.Cancellable {
+			return ErrJobNotCancellable
+		}
+		job.cancel()
+		return nil
+	}
+	return ErrJobNotFound
+}
+
+// Pause holds a running pausable job at its next step until Resume
+func (j *Jobs) Pause(id string) error {
+	j.mutex.Lock()
+	job, err := j.pausable(id)
+	if err == nil && job.resume == nil {
+		job.Paused = true
+		job.resume = make(chan struct{})
+	}
+	j.mutex.Unlock()
+	j.notify()
+	return err
+}
+
+// Resume continues a paused job, a job that is not paused is left as is
+func (j *Jobs) Resume(id string) error {
+	j.mutex.Lock()
+	job, err := j.pausable(id)
+	if err == nil {
+		j.unpause(job)
+	}
+	j.mutex.Unlock()
+	j.notify()
+	return err
+}
+
+func (j *Jobs) pausable(id string) (*Job, error) {
+	for _, job := range j.jobs {
+		if job.ID != id {
+			continue
+		}
+		if !job.Pausable || job.State != JobStateRunning {
+			return nil, ErrJobNotPausable
+		}
+		return job, nil
+	}
+	return nil, ErrJobNotFound
+}
+
+func (j *Jobs) unpause(job *Job) {
+	if job.resume != nil {
+		close(job.resume)
+		job.resume = nil
+	}
+	job.Paused = false
+}
+
+// Subscribe signals every change of the jobs on the returned channel. Signals are coalesced,
+// a subscriber reads the current state with List when signaled.
+func (j *Jobs) Subscribe() (<-chan struct{}, func()) {
+	changed := make(chan struct{}, 1)
+	j.mutex.Lock()
+	j.subscribers[changed] = struct{}{}
+	j.mutex.Unlock()
+	return changed, func() {
+		j.mutex.Lock()
+		delete(j.subscribers, changed)
+		j.mutex.Unlock()
+	}
+}
+
+func (j *Jobs) notify() {
+	j.mutex.Lock()
+	defer j.mutex.Unlock()
+	for changed := range j.subscribers {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// prune forgets the oldest finished jobs beyond maxFinishedJobs
+func (j *Jobs) prune() {
+	finished := 0
+	for _, job := range j.jobs {
+		if job.State != JobStateRunning {
+			finished++
+		}
+	}
+	kept := j.jobs[:0]
+	for _, job := range j.jobs {
+		if job.State != JobStateRunning && finished > maxFinishedJobs {
+			finished--
+			continue
+		}
+		kept = append(kept, job)
+	}
+	j.jobs = kept
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	rand.Read(b) //nolint: errcheck
+	return hex.EncodeToString(b)
+}
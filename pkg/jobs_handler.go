@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// ServerEventJobs carries the list of jobs on the events stream
+	ServerEventJobs = "jobs"
+	// ServerEventFiles carries the file list on the events stream
+	ServerEventFiles = "files"
+
+	// eventsMinInterval throttles the events stream while a job reports progress
+	eventsMinInterval = 250 * time.Millisecond
+)
+
+type JobsResponse struct {
+	Jobs []Job `json:"jobs"`
+}
+
+// GetJobs lists the running and recently finished jobs
+func (h *APIHandler) GetJobs(c echo.Context) error {
+	return c.JSON(http.StatusOK, JobsResponse{Jobs: GlobalJobs.List()})
+}
+
+// GetEvents streams server events. A "jobs" event with every job is sent first and
+// again whenever a job starts, progresses or finishes. A "files" event with the file list
+// is sent whenever it changes, as SSH paths resolved in the background appear.
+func (h *APIHandler) GetEvents(c echo.Context) error {
+	jobsChanged, unsubscribeJobs := GlobalJobs.Subscribe()
+	defer unsubscribeJobs()
+	filesChanged, unsubscribeFiles := GlobalFileListChanges.Subscribe()
+	defer unsubscribeFiles()
+
+	SetHeadersResponseSSE(c.Response().Header())
+	c.Response().WriteHeader(http.StatusOK)
+
+	ctx := c.Request().Context()
+	event := ServerEventJobs
+	for {
+		var data interface{} = JobsResponse{Jobs: GlobalJobs.List()}
+		if event == ServerEventFiles {
+			data = FileListResponse{FilePaths: GlobalFileCuration.Apply(GlobalFileRegistry.Snapshot(), false)}
+		}
+		if err := WriteSSE(c.Response(), event, data); err != nil {
+			return nil
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(eventsMinInterval):
+		}
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-jobsChanged:
+			event = ServerEventJobs
+		case <-filesChanged:
+			event = ServerEventFiles
+		}
+	}
+}
+
+// DeleteJob cancels a running job
+func (h *AdminHandler) DeleteJob(c echo.Context) error {
+	if err := h.authorizeShared(c); err != nil {
+		return err
+	}
+	err := GlobalJobs.Cancel(c.Param("id"))
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrJobNotCancellable):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	job, _ := GlobalJobs.Get(c.Param("id"))
+	return c.JSON(http.StatusOK, job)
+}
@@ -0,0 +1,101 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestJobs(t *testing.T) {
+	jobs := NewJobs()
+	changed, unsubscribe := jobs.Subscribe()
+	defer unsubscribe()
+
+	ctx, tracker := jobs.Start(context.Background(), JobKindScan, "big.log", 200, true)
+	<-changed
+	tracker.Progress(50)
+	job, ok := jobs.Get(tracker.job.ID)
+	assert.True(t, ok)
+	assert.Equal(t, JobStateRunning, job.State)
+	assert.Equal(t, 25.0, job.Percent)
+
+	assert.NoError(t, jobs.Cancel(job.ID))
+	<-ctx.Done()
+	tracker.Finish(ctx.Err())
+	job, _ = jobs.Get(job.ID)
+	assert.Equal(t, JobStateCanceled, job.State)
+	assert.NotNil(t, job.FinishedAt)
+
+	_, failing := jobs.Start(context.Background(), JobKindScan, "other.log", 0, false)
+	assert.ErrorIs(t, jobs.Cancel(failing.job.ID), ErrJobNotCancellable)
+	failing.Finish(errors.New("disk on fire"))
+	assert.ErrorIs(t, jobs.Cancel("unknown"), ErrJobNotFound)
+
+	list := jobs.List()
+	assert.Len(t, list, 2)
+	assert.Equal(t, JobStateFailed, list[1].State)
+	assert.Equal(t, "disk on fire", list[1].Error)
+}
+
+func TestFileStatsContext_Job(t *testing.T) {
+	defer func(jobs *Jobs, minSize int64) { GlobalJobs, ScanJobMinSize = jobs, minSize }(GlobalJobs, ScanJobMinSize)
+	GlobalJobs = NewJobs()
+	ScanJobMinSize = 1024
+
+	dir := t.TempDir()
+	small := filepath.Join(dir, "small.log")
+	big := filepath.Join(dir, "big.log")
+	assert.NoError(t, os.WriteFile(small, []byte("INFO small\n"), 0600))
+	assert.NoError(t, os.WriteFile(big, []byte(strings.Repeat("INFO a line of a big file\n", 50000)), 0600))
+
+	_, _, err := FileStatsContext(context.Background(), small, false, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, GlobalJobs.List())
+
+	linesCount, _, err := FileStatsContext(context.Background(), big, false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 50000, linesCount)
+	jobs := GlobalJobs.List()
+	assert.Len(t, jobs, 1)
+	assert.Equal(t, big, jobs[0].Target)
+	assert.Equal(t, JobStateDone, jobs[0].State)
+	assert.Equal(t, 100.0, jobs[0].Percent)
+
+	// cached stats are not scanned again
+	_, _, err = FileStatsContext(context.Background(), big, false, nil)
+	assert.NoError(t, err)
+	assert.Len(t, GlobalJobs.List(), 1)
+}
+
+func TestAdminHandler_DeleteJob(t *testing.T) {
+	defer func(jobs *Jobs) { GlobalJobs = jobs }(GlobalJobs)
+	GlobalJobs = NewJobs()
+	ctx, tracker := GlobalJobs.Start(context.Background(), JobKindScan, "big.log", 0, true)
+
+	options := &EchoOptions{BaseURL: "/", Compression: CompressionOff}
+	e := newTestEcho(options)
+	request := func(method string, url string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(method, url, nil))
+		return rec
+	}
+
+	rec := request(http.MethodGet, "/api/jobs")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var list JobsResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &list))
+	assert.Len(t, list.Jobs, 1)
+	assert.Equal(t, JobStateRunning, list.Jobs[0].State)
+
+	assert.Equal(t, http.StatusNotFound, request(http.MethodDelete, "/api/jobs/unknown").Code)
+	assert.Equal(t, http.StatusOK, request(http.MethodDelete, "/api/jobs/"+tracker.job.ID).Code)
+	assert.ErrorIs(t, ctx.Err(), context.Canceled)
+}
@@ -0,0 +1,261 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrJournalctlNotFound is returned where journalctl is not installed, as on macOS
+var ErrJournalctlNotFound = errors.New("journalctl not found, journal units are not read")
+
+// JournalPriorities are the syslog priority names journalctl takes, by level
+var JournalPriorities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// journalTimeFormat is the timestamp the journal lines start with
+const journalTimeFormat = "2006-01-02T15:04:05.000000Z07:00"
+
+// journalctl runs journalctl with args and returns its stdout, replaced in tests
+var journalctl = func(ctx context.Context, args ...string) ([]byte, error) {
+	path, err := exec.LookPath("journalctl")
+	if err != nil {
+		return nil, ErrJournalctlNotFound
+	}
+	cmd := exec.CommandContext(ctx, path, args...) // nolint: gosec
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return output, nil
+}
+
+type JournalPathConfig struct {
+	Unit string
+	// Priority is the lowest priority read, empty for all of them
+	Priority string
+}
+
+// s is an input of the form "unit [priority=err]"
+func StringToJournalPathConfig(s string) (*JournalPathConfig, error) {
+	parts := strings.Fields(s)
+	if len(parts) == 0 || len(parts) > 2 {
+		return nil, fmt.Errorf("input string does not have the correct format, unit [priority=err]")
+	}
+	config := &JournalPathConfig{Unit: parts[0]}
+	if len(parts) == 2 {
+		priority, ok := strings.CutPrefix(parts[1], "priority=")
+		if !ok {
+			return nil, fmt.Errorf("unknown option %q, only priority= is supported", parts[1])
+		}
+		if !isJournalPriority(priority) {
+			return nil, fmt.Errorf("priority must be one of %s or 0-7", strings.Join(JournalPriorities, " "))
+		}
+		config.Priority = priority
+	}
+	return config, nil
+}
+
+func isJournalPriority(priority string) bool {
+	if level, err := strconv.Atoi(priority); err == nil {
+		return level >= 0 && level < len(JournalPriorities)
+	}
+	for _, name := range JournalPriorities {
+		if priority == name {
+			return true
+		}
+	}
+	return false
+}
+
+// journalEntry holds the fields of a journalctl -o json entry gol reads
+type journalEntry struct {
+	Cursor   string          `json:"__CURSOR"`
+	Realtime string          `json:"__REALTIME_TIMESTAMP"`
+	Priority string          `json:"PRIORITY"`
+	Message  json.RawMessage `json:"MESSAGE"`
+}
+
+// line flattens the entry into its timestamp, priority name and message, the newlines of which are
+// escaped to keep one line per entry
+func (e journalEntry) line() string {
+	timestamp := ""
+	if micros, err := strconv.ParseInt(e.Realtime, 10, 64); err == nil {
+		timestamp = time.UnixMicro(micros).UTC().Format(journalTimeFormat)
+	}
+	priority := e.Priority
+	if level, err := strconv.Atoi(e.Priority); err == nil && level >= 0 && level < len(JournalPriorities) {
+		priority = JournalPriorities[level]
+	}
+	message := strings.ReplaceAll(strings.TrimRight(journalMessage(e.Message), "\n"), "\n", `\n`)
+	return timestamp + " " + priority + " " + message
+}
+
+// journalMessage is the text of a MESSAGE field, which journalctl gives as an array of bytes when
+// it is not valid UTF-8 or holds control characters
+func journalMessage(raw json.RawMessage) string {
+	var text string
+	if err := json.Unmarshal(raw, &text); err == nil {
+		return text
+	}
+	var codes []int
+	if err := json.Unmarshal(raw, &codes); err == nil {
+		b := make([]byte, 0, len(codes))
+		for _, c := range codes {
+			b = append(b, byte(c))
+		}
+		return string(b)
+	}
+	return ""
+}
+
+type journalFollow struct {
+	filePath  string
+	cursor    string
+	lineCount int
+}
+
+// JournalSource copies the journal entries of units into temp files, which are served as local files.
+// Every refresh only reads the entries after the last one copied.
+type JournalSource struct {
+	patterns []string
+	mutex    sync.Mutex
+	follows  map[string]*journalFollow
+	// warned is set once the missing journalctl was logged
+	warned bool
+}
+
+func NewJournalSource(patterns []string) *JournalSource {
+	return &JournalSource{patterns: patterns, follows: map[string]*journalFollow{}}
+}
+
+// Refresh copies the new entries of every unit and returns their files and the status of every pattern
+func (s *JournalSource) Refresh(ctx context.Context) ([]FileInfo, []SourceStatus) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	fileInfos := []FileInfo{}
+	statuses := []SourceStatus{}
+	for _, pattern := range s.patterns {
+		config, err := StringToJournalPathConfig(pattern)
+		if err != nil {
+			slog.Error("parsing journal path", pattern, err)
+			statuses = append(statuses, newSourceStatus(pattern, TypeJournal, "", nil, err))
+			continue
+		}
+		follow, err := s.read(ctx, pattern, config)
+		if errors.Is(err, ErrJournalctlNotFound) {
+			if !s.warned {
+				slog.Warn("reading journal", "error", err)
+				s.warned = true
+			}
+			statuses = append(statuses, newSourceStatus(pattern, TypeJournal, "", nil, err))
+			continue
+		}
+		if err != nil {
+			slog.Error("reading journal", "unit", config.Unit, "error", err)
+			statuses = append(statuses, newSourceStatus(pattern, TypeJournal, "", nil, err))
+			continue
+		}
+		fileInfo := FileInfo{FilePath: follow.filePath, Type: TypeJournal, Name: config.Unit}
+		if stats := GetFileInfos(fileInfo.FilePath, 1, false, nil); len(stats) > 0 {
+			fileInfo.LinesCount = stats[0].LinesCount
+			fileInfo.FileSize = stats[0].FileSize
+		}
+		statuses = append(statuses, newSourceStatus(pattern, TypeJournal, "", []FileInfo{fileInfo}, nil))
+		fileInfos = append(fileInfos, fileInfo)
+	}
+	return fileInfos, statuses
+}
+
+// read appends the entries of the unit after the last one read to its file, the last
+// containerStdoutMaxLines ones the first time
+func (s *JournalSource) read(ctx context.Context, pattern string, config *JournalPathConfig) (*journalFollow, error) {
+	args := []string{"-u", config.Unit, "-o", "json", "--no-pager"}
+	if config.Priority != "" {
+		args = append(args, "-p", config.Priority)
+	}
+	follow, ok := s.follows[pattern]
+	if ok && follow.cursor != "" {
+		args = append(args, "--after-cursor="+follow.cursor)
+	} else {
+		args = append(args, "-n", strconv.Itoa(containerStdoutMaxLines))
+	}
+	output, err := journalctl(ctx, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	if !ok {
+		if err := EnsureFreeDisk(TmpDir(), 0); err != nil {
+			return nil, err
+		}
+		file, err := os.Create(GetTmpFileNameForJournal())
+		if err != nil {
+			return nil, fmt.Errorf("creating temp file: %w", err)
+		}
+		file.Close()
+		follow = &journalFollow{filePath: file.Name()}
+		s.follows[pattern] = follow
+	}
+	file, err := os.OpenFile(follow.filePath, os.O_WRONLY|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	return follow, follow.copyEntries(bytes.NewReader(output), file)
+}
+
+// copyEntries appends the entries of r to file one line each, emptying the file once it holds
+// containerStdoutMaxLines lines
+func (f *journalFollow) copyEntries(r io.Reader, file *os.File) error {
+	writer := bufio.NewWriter(file)
+	scanner := newLineScanner(r)
+	for scanner.Scan() {
+		var entry journalEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			slog.Warn("parsing journal entry", "error", err)
+			continue
+		}
+		if f.lineCount >= containerStdoutMaxLines {
+			if err := writer.Flush(); err != nil {
+				return err
+			}
+			if err := file.Truncate(0); err != nil {
+				slog.Error("truncating file", "scan", err)
+			}
+			f.lineCount = 0
+		}
+		if _, err := writer.WriteString(entry.line() + "\n"); err != nil {
+			return err
+		}
+		f.lineCount++
+		f.cursor = entry.Cursor
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	return writer.Flush()
+}
+
+// Close removes the files of the units
+func (s *JournalSource) Close() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for pattern, follow := range s.follows {
+		os.Remove(follow.filePath)
+		delete(s.follows, pattern)
+	}
+}
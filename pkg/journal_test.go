@@ -0,0 +1,108 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// useFakeJournalctl answers journalctl with outputs, keyed by its arguments joined by spaces
+func useFakeJournalctl(t *testing.T, outputs map[string]string, calls *[]string) {
+	run := journalctl
+	t.Cleanup(func() { journalctl = run })
+	journalctl = func(_ context.Context, args ...string) ([]byte, error) {
+		key := strings.Join(args, " ")
+		*calls = append(*calls, key)
+		output, ok := outputs[key]
+		if !ok {
+			return nil, errors.New("unscripted journalctl " + key)
+		}
+		return []byte(output), nil
+	}
+}
+
+func TestStringToJournalPathConfig(t *testing.T) {
+	tests := map[string]*JournalPathConfig{
+		"nginx.service":                    {Unit: "nginx.service"},
+		"nginx.service priority=err":       {Unit: "nginx.service", Priority: "err"},
+		"nginx.service priority=3":         {Unit: "nginx.service", Priority: "3"},
+		"":                                 nil,
+		"nginx.service priority=8":         nil,
+		"nginx.service priority=bad":       nil,
+		"nginx.service since=today":        nil,
+		"nginx.service priority=err extra": nil,
+	}
+	for input, want := range tests {
+		config, err := StringToJournalPathConfig(input)
+		if want == nil {
+			assert.Error(t, err, input)
+			continue
+		}
+		assert.NoError(t, err, input)
+		assert.Equal(t, want, config, input)
+	}
+}
+
+func TestJournalSource_Refresh(t *testing.T) {
+	calls := []string{}
+	outputs := map[string]string{
+		"-u nginx.service -o json --no-pager -p err -n 10000": `{"__CURSOR":"c1","__REALTIME_TIMESTAMP":"1704067200000001","PRIORITY":"3","MESSAGE":"upstream timed out"}
+{"__CURSOR":"c2","__REALTIME_TIMESTAMP":"1704067201000000","PRIORITY":"2","MESSAGE":[98,105,110,10,97,114,121]}
+`,
+		"-u nginx.service -o json --no-pager -p err --after-cursor=c2": `{"__CURSOR":"c3","__REALTIME_TIMESTAMP":"1704067202500000","PRIORITY":"3","MESSAGE":"connect() failed"}
+`,
+	}
+	useFakeJournalctl(t, outputs, &calls)
+	source := NewJournalSource([]string{"nginx.service priority=err", "nginx.service priority=bad"})
+	t.Cleanup(source.Close)
+
+	fileInfos, statuses := source.Refresh(context.Background())
+	if assert.Len(t, fileInfos, 1) {
+		assert.Equal(t, TypeJournal, fileInfos[0].Type)
+		assert.Equal(t, "nginx.service", fileInfos[0].Name)
+		assert.Equal(t, 2, fileInfos[0].LinesCount)
+	}
+	if assert.Len(t, statuses, 2) {
+		assert.Empty(t, statuses[0].Error)
+		assert.NotEmpty(t, statuses[1].Error)
+	}
+	filePath := fileInfos[0].FilePath
+	content, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+	assert.Equal(t, "2024-01-01T00:00:00.000001Z err upstream timed out\n"+
+		"2024-01-01T00:00:01.000000Z crit bin\\nary\n", string(content))
+
+	// the next refresh continues from the cursor of the last entry
+	fileInfos, _ = source.Refresh(context.Background())
+	assert.Equal(t, filePath, fileInfos[0].FilePath)
+	content, err = os.ReadFile(filePath)
+	assert.NoError(t, err)
+	assert.True(t, strings.HasSuffix(string(content), "2024-01-01T00:00:02.500000Z err connect() failed\n"))
+	assert.Equal(t, []string{
+		"-u nginx.service -o json --no-pager -p err -n 10000",
+		"-u nginx.service -o json --no-pager -p err --after-cursor=c2",
+	}, calls)
+
+	source.Close()
+	_, err = os.Stat(filePath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestJournalSource_RefreshWithoutJournalctl(t *testing.T) {
+	run := journalctl
+	t.Cleanup(func() { journalctl = run })
+	journalctl = func(context.Context, ...string) ([]byte, error) {
+		return nil, ErrJournalctlNotFound
+	}
+
+	source := NewJournalSource([]string{"nginx.service"})
+	fileInfos, statuses := source.Refresh(context.Background())
+	assert.Empty(t, fileInfos)
+	if assert.Len(t, statuses, 1) {
+		assert.Equal(t, ErrJournalctlNotFound.Error(), statuses[0].Error)
+	}
+}
@@ -0,0 +1,77 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"strings"
+)
+
+// parseJSONLine parses a line holding one JSON object, numbers are kept as written
+func parseJSONLine(line []byte) (map[string]interface{}, bool) {
+	line = bytes.TrimSpace(line)
+	if len(line) < 2 || line[0] != '{' || line[len(line)-1] != '}' {
+		return nil, false
+	}
+	decoder := json.NewDecoder(bytes.NewReader(line))
+	decoder.UseNumber()
+	object := map[string]interface{}{}
+	if err := decoder.Decode(&object); err != nil {
+		return nil, false
+	}
+	if _, err := decoder.Token(); !errors.Is(err, io.EOF) {
+		return nil, false
+	}
+	return object, true
+}
+
+// jsonField looks up key in object, a key as is first and then as a dot separated path
+// into nested objects, like http.status
+func jsonField(object map[string]interface{}, key string) (interface{}, bool) {
+	if value, ok := object[key]; ok {
+		return value, true
+	}
+	head, rest, ok := strings.Cut(key, ".")
+	if !ok {
+		return nil, false
+	}
+	nested, ok := object[head].(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	return jsonField(nested, rest)
+}
+
+// jsonFieldString is a JSON value as filters compare it: strings unquoted, anything else as JSON
+func jsonFieldString(value interface{}) string {
+	if s, ok := value.(string); ok {
+		return s
+	}
+	b, _ := json.Marshal(value)
+	return string(b)
+}
+
+// matchJSONFilters reports whether line is a JSON object with fields matching every filter
+func matchJSONFilters(filters []FieldFilter, line []byte) bool {
+	object, ok := parseJSONLine(line)
+	if !ok {
+		return false
+	}
+	for _, filter := range filters {
+		value, ok := jsonField(object, filter.Key)
+		if !ok || jsonFieldString(value) != filter.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// appendJSON sets the parsed object of the lines that are JSON objects
+func appendJSON(lines []LineResult) {
+	for i, line := range lines {
+		if object, ok := parseJSONLine([]byte(line.Content)); ok {
+			lines[i].JSON = object
+		}
+	}
+}
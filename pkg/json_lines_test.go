@@ -0,0 +1,116 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseJSONLine(t *testing.T) {
+	object, ok := parseJSONLine([]byte(` {"level":"error","http":{"status":500,"req":{"path":"/login"}},"id":12345678901234567890} `))
+	assert.True(t, ok)
+	assert.Equal(t, "error", object["level"])
+
+	for key, want := range map[string]string{
+		"level":         "error",
+		"http.status":   "500",
+		"http.req.path": "/login",
+		"http.req":      `{"path":"/login"}`,
+		"id":            "12345678901234567890",
+	} {
+		value, ok := jsonField(object, key)
+		assert.True(t, ok, key)
+		assert.Equal(t, want, jsonFieldString(value), key)
+	}
+	for _, key := range []string{"missing", "http.missing", "level.nested", "http.status.code"} {
+		_, ok := jsonField(object, key)
+		assert.False(t, ok, key)
+	}
+
+	// a key with dots is looked up as is first
+	object, _ = parseJSONLine([]byte(`{"http.status":"literal","http":{"status":"nested"}}`))
+	value, _ := jsonField(object, "http.status")
+	assert.Equal(t, "literal", value)
+
+	for _, line := range []string{"", "plain text", "[1,2]", `{"a":1} trailing`, `{"a":1}{"b":2}`, `{"a":`} {
+		_, ok := parseJSONLine([]byte(line))
+		assert.False(t, ok, line)
+	}
+}
+
+func TestAPIHandler_GetJSONFilters(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+	content := strings.Join([]string{
+		`{"level":"info","service":"auth","http":{"status":200,"route":{"path":"/login"}}}`,
+		`plain text line`,
+		`{"level":"error","service":"auth","http":{"status":500,"route":{"path":"/login"}}}`,
+		`{"level":"error","service":"billing","http":{"status":500,"route":{"path":"/pay"}}}`,
+		`2024-01-01 ERROR {"level":"error"} embedded`,
+		`{"level":"error","service":"auth","retry":true,"http":{"status":503}}`,
+	}, "\n") + "\n"
+	assert.NoError(t, os.WriteFile(logFile, []byte(content), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: logFile, Type: TypeFile}})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	get := func(filters ...string) (*httptest.ResponseRecorder, ScanResult) {
+		query := ""
+		for _, filter := range filters {
+			query += "&filter=" + url.QueryEscape(filter)
+		}
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api?type=file&page=1&per_page=2&file_path="+logFile+query, nil))
+		res := APIResponse{}
+		if rec.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		}
+		return rec, res.Result
+	}
+	lineNumbers := func(result ScanResult) []int {
+		numbers := []int{}
+		for _, line := range result.Lines {
+			numbers = append(numbers, line.LineNumber)
+		}
+		return numbers
+	}
+
+	// without filters every line passes, the JSON ones with their parsed object
+	rec, result := get()
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 6, result.Total)
+	assert.NotNil(t, result.Lines[0].JSON)
+	assert.Nil(t, result.Lines[1].JSON)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api?type=file&page=1&per_page=1&reverse=false&file_path="+logFile, nil))
+	assert.Contains(t, rec.Body.String(), `"json":{"http":{"route":{"path":"/login"},"status":200},"level":"info","service":"auth"}`)
+
+	// filters apply before pagination, non JSON lines never match
+	_, result = get("level=error")
+	assert.Equal(t, 3, result.Total)
+	assert.Equal(t, []int{3, 4}, lineNumbers(result))
+	_, result = get("level=error", "service=auth")
+	assert.Equal(t, 2, result.Total)
+	assert.Equal(t, []int{3, 6}, lineNumbers(result))
+	_, result = get("http.status=500")
+	assert.Equal(t, []int{3, 4}, lineNumbers(result))
+	_, result = get("http.route.path=/pay")
+	assert.Equal(t, []int{4}, lineNumbers(result))
+	_, result = get("retry=true")
+	assert.Equal(t, []int{6}, lineNumbers(result))
+	_, result = get("http.route.path=/none")
+	assert.Equal(t, 0, result.Total)
+
+	for _, filters := range [][]string{{"level"}, {"=error"}} {
+		rec, _ := get(filters...)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, filters)
+	}
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api?type=file&tail=2&filter=level=error&file_path="+logFile, nil))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
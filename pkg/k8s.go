@@ -0,0 +1,317 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acarl005/stripansi"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// k8sReconnectInterval is the wait before following a container again once its log stream ended
+const k8sReconnectInterval = 5 * time.Second
+
+type K8sPathConfig struct {
+	Namespace string
+	Pod       string
+	// Container is empty for every container of the pods
+	Container string
+	// Selector is a label selector picking the pods instead of Pod
+	Selector string
+}
+
+// s is an input of the form "namespace/pod[/container]", or "namespace/selector" with a label selector
+// like "namespace/app=myapp" for every pod matching it
+func StringToK8sPathConfig(s string) (*K8sPathConfig, error) {
+	namespace, rest, ok := strings.Cut(strings.TrimSpace(s), "/")
+	if !ok || namespace == "" || rest == "" {
+		return nil, fmt.Errorf("input string does not have the correct format, namespace/pod[/container] or namespace/selector")
+	}
+	if strings.Contains(rest, "=") {
+		return &K8sPathConfig{Namespace: namespace, Selector: rest}, nil
+	}
+	pod, container, _ := strings.Cut(rest, "/")
+	if pod == "" || strings.Contains(container, "/") {
+		return nil, fmt.Errorf("input string does not have the correct format, namespace/pod[/container] or namespace/selector")
+	}
+	return &K8sPathConfig{Namespace: namespace, Pod: pod, Container: container}, nil
+}
+
+// NewK8sClient connects to the cluster gol runs in, or else to the current context of the default
+// kubeconfig ($KUBECONFIG, ~/.kube/config)
+func NewK8sClient() (kubernetes.Interface, error) {
+	config, err := rest.InClusterConfig()
+	if err != nil {
+		loadingRules := clientcmd.NewDefaultClientConfigLoadingRules()
+		config, err = clientcmd.NewNonInteractiveDeferredLoadingClientConfig(loadingRules, &clientcmd.ConfigOverrides{}).ClientConfig()
+		if err != nil {
+			return nil, fmt.Errorf("loading kubeconfig: %w", err)
+		}
+	}
+	return kubernetes.NewForConfig(config)
+}
+
+// K8sContainer is a container of a pod, the unit that has logs
+type K8sContainer struct {
+	Namespace string
+	Pod       string
+	Container string
+}
+
+func (c K8sContainer) String() string {
+	return c.Namespace + "/" + c.Pod + "/" + c.Container
+}
+
+type k8sFollow struct {
+	filePath string
+	cancel   context.CancelFunc
+	done     chan struct{}
+}
+
+// K8sSource follows the logs of the containers of the pods matching its patterns into temp files, which
+// are served as local files. The patterns are expanded again on every refresh, new pods are followed and
+// the temp files of gone ones removed.
+type K8sSource struct {
+	client   kubernetes.Interface
+	patterns []string
+	mutex    sync.Mutex
+	follows  map[string]*k8sFollow
+}
+
+func NewK8sSource(client kubernetes.Interface, patterns []string) *K8sSource {
+	return &K8sSource{client: client, patterns: patterns, follows: map[string]*k8sFollow{}}
+}
+
+// Ping checks that the API server answers
+func (s *K8sSource) Ping(context.Context) error {
+	_, err := s.client.Discovery().ServerVersion()
+	return err
+}
+
+// Containers expands a pattern to the containers of the pods it matches, pending pods have no logs yet
+func (s *K8sSource) Containers(ctx context.Context, config *K8sPathConfig) ([]K8sContainer, error) {
+	pods := []corev1.Pod{}
+	if config.Selector != "" {
+		list, err := s.client.CoreV1().Pods(config.Namespace).List(ctx, metav1.ListOptions{LabelSelector: config.Selector})
+		if err != nil {
+			return nil, err
+		}
+		pods = list.Items
+	} else {
+		pod, err := s.client.CoreV1().Pods(config.Namespace).Get(ctx, config.Pod, metav1.GetOptions{})
+		if err != nil {
+			return nil, err
+		}
+		pods = append(pods, *pod)
+	}
+	sort.Slice(pods, func(i, j int) bool { return pods[i].Name < pods[j].Name })
+
+	containers := []K8sContainer{}
+	for _, pod := range pods {
+		if pod.Status.Phase == corev1.PodPending {
+			continue
+		}
+		found := false
+		for _, container := range pod.Spec.Containers {
+			if config.Container != "" && container.Name != config.Container {
+				continue
+			}
+			found = true
+			containers = append(containers, K8sContainer{Namespace: pod.Namespace, Pod: pod.Name, Container: container.Name})
+		}
+		if !found && config.Container != "" {
+			return nil, fmt.Errorf("pod %s/%s has no container %s", pod.Namespace, pod.Name, config.Container)
+		}
+	}
+	return containers, nil
+}
+
+// Refresh expands the patterns, follows the containers not followed yet and stops following the ones
+// no pattern matches anymore. It returns the files of the followed containers and the status of every pattern.
+func (s *K8sSource) Refresh(ctx context.Context, limit int) ([]FileInfo, []SourceStatus) {
+	fileInfos := []FileInfo{}
+	statuses := []SourceStatus{}
+	matched := map[string]bool{}
+	for _, pattern := range s.patterns {
+		config, err := StringToK8sPathConfig(pattern)
+		if err != nil {
+			slog.Error("parsing k8s path", pattern, err)
+			statuses = append(statuses, newSourceStatus(pattern, TypeK8s, "", nil, err))
+			continue
+		}
+		containers, err := s.Containers(ctx, config)
+		if err != nil {
+			slog.Error("listing pods", "pattern", pattern, "error", err)
+			statuses = append(statuses, newSourceStatus(pattern, TypeK8s, config.Namespace, nil, err))
+			continue
+		}
+		if len(containers) > limit {
+			slog.Warn("Limiting to containers", "k8s", limit)
+			containers = containers[:limit]
+		}
+		patternInfos := []FileInfo{}
+		for _, container := range containers {
+			if matched[container.String()] {
+				continue
+			}
+			matched[container.String()] = true
+			filePath, err := s.follow(container)
+			if err != nil {
+				slog.Error("following pod logs", "container", container.String(), "error", err)
+				continue
+			}
+			patternInfos = append(patternInfos, FileInfo{
+				FilePath: filePath,
+				Type:     TypeK8s,
+				Host:     container.Namespace + "/" + container.Pod,
+				Name:     container.String(),
+			})
+		}
+		for i, fileInfo := range patternInfos {
+			if stats := GetFileInfos(fileInfo.FilePath, 1, false, nil); len(stats) > 0 {
+				patternInfos[i].LinesCount = stats[0].LinesCount
+				patternInfos[i].FileSize = stats[0].FileSize
+			}
+		}
+		statuses = append(statuses, newSourceStatus(pattern, TypeK8s, config.Namespace, patternInfos, nil))
+		fileInfos = append(fileInfos, patternInfos...)
+	}
+
+	s.mutex.Lock()
+	gone := []*k8sFollow{}
+	for key, follow := range s.follows {
+		if !matched[key] {
+			gone = append(gone, follow)
+			delete(s.follows, key)
+		}
+	}
+	s.mutex.Unlock()
+	for _, follow := range gone {
+		follow.stop()
+		os.Remove(follow.filePath)
+	}
+	return fileInfos, statuses
+}
+
+// Close stops following every container
+func (s *K8sSource) Close() {
+	s.mutex.Lock()
+	follows := s.follows
+	s.follows = map[string]*k8sFollow{}
+	s.mutex.Unlock()
+	for _, follow := range follows {
+		follow.stop()
+	}
+}
+
+// follow starts following a container into a new temp file, unless it is followed already
+func (s *K8sSource) follow(container K8sContainer) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if follow, ok := s.follows[container.String()]; ok {
+		return follow.filePath, nil
+	}
+	if err := EnsureFreeDisk(TmpDir(), 0); err != nil {
+		return "", err
+	}
+	file, err := os.Create(GetTmpFileNameForK8s())
+	if err != nil {
+		return "", fmt.Errorf("creating temp file: %w", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	follow := &k8sFollow{filePath: file.Name(), cancel: cancel, done: make(chan struct{})}
+	s.follows[container.String()] = follow
+	go func() {
+		defer close(follow.done)
+		defer file.Close()
+		s.followLogs(ctx, container, file)
+	}()
+	return follow.filePath, nil
+}
+
+func (f *k8sFollow) stop() {
+	f.cancel()
+	<-f.done
+}
+
+// followLogs copies the last lines of a container to file then follows it, following it again from
+// the last line copied whenever the stream ends, as it does when the container restarts
+func (s *K8sSource) followLogs(ctx context.Context, container K8sContainer, file *os.File) {
+	var since time.Time
+	lineCount := 0
+	for {
+		options := &corev1.PodLogOptions{Container: container.Container, Follow: true, Timestamps: true}
+		if since.IsZero() {
+			tailLines := int64(containerStdoutMaxLines)
+			options.TailLines = &tailLines
+		} else {
+			options.SinceTime = &metav1.Time{Time: since}
+		}
+		err := s.copyLogs(ctx, container, options, file, &since, &lineCount)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			slog.Warn("following pod logs", "container", container.String(), "error", err)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-GlobalClock.After(k8sReconnectInterval):
+		}
+	}
+}
+
+// copyLogs appends the lines of one log stream of container to file
+func (s *K8sSource) copyLogs(ctx context.Context, container K8sContainer, options *corev1.PodLogOptions, file *os.File, since *time.Time, lineCount *int) error {
+	stream, err := s.client.CoreV1().Pods(container.Namespace).GetLogs(container.Pod, options).Stream(ctx)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+	return copyLogLines(stream, file, since, lineCount)
+}
+
+// copyLogLines appends the timestamped lines of r to file without their timestamps. Lines up to since, the
+// time of the last line copied, are skipped: the API server only resumes from the second. The file is
+// emptied once it holds containerStdoutMaxLines lines.
+func copyLogLines(r io.Reader, file *os.File, since *time.Time, lineCount *int) error {
+	scanner := newLineScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if stamp, rest, ok := strings.Cut(line, " "); ok {
+			if at, err := time.Parse(time.RFC3339Nano, stamp); err == nil {
+				if !since.IsZero() && !at.After(*since) {
+					continue
+				}
+				*since = at
+				line = rest
+			}
+		}
+		if *lineCount >= containerStdoutMaxLines {
+			if err := file.Truncate(0); err != nil {
+				slog.Error("truncating file", "scan", err)
+			}
+			if _, err := file.Seek(0, 0); err != nil {
+				slog.Error("seeking file", "scan", err)
+			}
+			*lineCount = 0
+		}
+		if _, err := io.WriteString(file, stripansi.Strip(line)+"\n"); err != nil {
+			return err
+		}
+		*lineCount++
+	}
+	return scanner.Err()
+}
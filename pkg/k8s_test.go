@@ -0,0 +1,181 @@
+package pkg
+
+import (
+	"context"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func fakePod(namespace string, name string, labels map[string]string, phase corev1.PodPhase, containers ...string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name, Labels: labels},
+		Status:     corev1.PodStatus{Phase: phase},
+	}
+	for _, container := range containers {
+		pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: container})
+	}
+	return pod
+}
+
+// logActions are the log requests made to client
+func logActions(client *fake.Clientset) []*corev1.PodLogOptions {
+	options := []*corev1.PodLogOptions{}
+	for _, action := range client.Actions() {
+		if action.GetVerb() == "get" && action.GetSubresource() == "log" {
+			options = append(options, action.(k8stesting.GenericAction).GetValue().(*corev1.PodLogOptions))
+		}
+	}
+	return options
+}
+
+func TestStringToK8sPathConfig(t *testing.T) {
+	tests := map[string]*K8sPathConfig{
+		"default/web":               {Namespace: "default", Pod: "web"},
+		"default/web/nginx":         {Namespace: "default", Pod: "web", Container: "nginx"},
+		"prod/app=myapp":            {Namespace: "prod", Selector: "app=myapp"},
+		"prod/app=myapp,tier!=test": {Namespace: "prod", Selector: "app=myapp,tier!=test"},
+		"web":                       nil,
+		"/web":                      nil,
+		"default/":                  nil,
+		"default/web/nginx/extra":   nil,
+	}
+	for input, want := range tests {
+		config, err := StringToK8sPathConfig(input)
+		if want == nil {
+			assert.Error(t, err, input)
+			continue
+		}
+		assert.NoError(t, err, input)
+		assert.Equal(t, want, config, input)
+	}
+}
+
+func TestK8sSource_Containers(t *testing.T) {
+	client := fake.NewSimpleClientset(
+		fakePod("default", "web-1", map[string]string{"app": "web"}, corev1.PodRunning, "nginx", "sidecar"),
+		fakePod("default", "web-2", map[string]string{"app": "web"}, corev1.PodPending, "nginx", "sidecar"),
+		fakePod("default", "db-1", map[string]string{"app": "db"}, corev1.PodRunning, "postgres"),
+		fakePod("prod", "web-1", map[string]string{"app": "web"}, corev1.PodRunning, "nginx"),
+	)
+	source := NewK8sSource(client, nil)
+
+	tests := map[string][]string{
+		"default/web-1":         {"default/web-1/nginx", "default/web-1/sidecar"},
+		"default/web-1/sidecar": {"default/web-1/sidecar"},
+		"default/app=web":       {"default/web-1/nginx", "default/web-1/sidecar"},
+		"prod/app=web":          {"prod/web-1/nginx"},
+		"default/app=none":      {},
+	}
+	for input, want := range tests {
+		config, err := StringToK8sPathConfig(input)
+		assert.NoError(t, err)
+		containers, err := source.Containers(context.Background(), config)
+		assert.NoError(t, err, input)
+		names := []string{}
+		for _, container := range containers {
+			names = append(names, container.String())
+		}
+		assert.Equal(t, want, names, input)
+	}
+
+	for _, input := range []string{"default/missing", "default/web-1/missing"} {
+		config, _ := StringToK8sPathConfig(input)
+		_, err := source.Containers(context.Background(), config)
+		assert.Error(t, err, input)
+	}
+}
+
+func TestK8sSource_Refresh(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	globalClock := GlobalClock
+	GlobalClock = clock
+	t.Cleanup(func() { GlobalClock = globalClock })
+
+	client := fake.NewSimpleClientset(
+		fakePod("default", "web-1", map[string]string{"app": "web"}, corev1.PodRunning, "nginx"),
+	)
+	source := NewK8sSource(client, []string{"default/app=web", "default/missing"})
+	t.Cleanup(source.Close)
+
+	fileInfos, statuses := source.Refresh(context.Background(), 10)
+	if assert.Len(t, fileInfos, 1) {
+		assert.Equal(t, TypeK8s, fileInfos[0].Type)
+		assert.Equal(t, "default/web-1", fileInfos[0].Host)
+		assert.Equal(t, "default/web-1/nginx", fileInfos[0].Name)
+		assert.True(t, strings.HasPrefix(fileInfos[0].FilePath, TmpK8sPath))
+	}
+	if assert.Len(t, statuses, 2) {
+		assert.Empty(t, statuses[0].Error)
+		assert.NotEmpty(t, statuses[1].Error)
+	}
+	webPath := fileInfos[0].FilePath
+
+	// the fake API server answers every log request with "fake logs"
+	assert.Eventually(t, func() bool {
+		content, _ := os.ReadFile(webPath)
+		return string(content) == "fake logs\n"
+	}, time.Second, 10*time.Millisecond)
+	options := logActions(client)
+	if assert.Len(t, options, 1) {
+		assert.Equal(t, "nginx", options[0].Container)
+		assert.True(t, options[0].Follow)
+		assert.True(t, options[0].Timestamps)
+		assert.Equal(t, int64(containerStdoutMaxLines), *options[0].TailLines)
+	}
+
+	// the stream ended, the container is followed again after a wait
+	assert.Eventually(t, func() bool { return clock.Timers() == 1 }, time.Second, 10*time.Millisecond)
+	clock.Advance(k8sReconnectInterval)
+	assert.Eventually(t, func() bool { return len(logActions(client)) == 2 }, time.Second, 10*time.Millisecond)
+
+	// a new pod matching the selector is followed, the followed ones keep their file
+	_, err := client.CoreV1().Pods("default").Create(context.Background(),
+		fakePod("default", "web-2", map[string]string{"app": "web"}, corev1.PodRunning, "nginx"), metav1.CreateOptions{})
+	assert.NoError(t, err)
+	fileInfos, _ = source.Refresh(context.Background(), 10)
+	if assert.Len(t, fileInfos, 2) {
+		assert.Equal(t, webPath, fileInfos[0].FilePath)
+		assert.Equal(t, "default/web-2/nginx", fileInfos[1].Name)
+	}
+
+	// a gone pod is not followed anymore and its file removed
+	assert.NoError(t, client.CoreV1().Pods("default").Delete(context.Background(), "web-1", metav1.DeleteOptions{}))
+	fileInfos, _ = source.Refresh(context.Background(), 10)
+	if assert.Len(t, fileInfos, 1) {
+		assert.Equal(t, "default/web-2/nginx", fileInfos[0].Name)
+	}
+	_, err = os.Stat(webPath)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestCopyLogLines(t *testing.T) {
+	file, err := os.CreateTemp(t.TempDir(), "k8s")
+	assert.NoError(t, err)
+	defer file.Close()
+
+	var since time.Time
+	lineCount := 0
+	first := "2024-01-01T00:00:01.000000001Z one\n" +
+		"2024-01-01T00:00:02.5Z \x1b[31mtwo\x1b[0m\n"
+	assert.NoError(t, copyLogLines(strings.NewReader(first), file, &since, &lineCount))
+	assert.Equal(t, time.Date(2024, 1, 1, 0, 0, 2, 500000000, time.UTC), since)
+
+	// a reconnect from the second repeats the lines up to since
+	second := "2024-01-01T00:00:02Z repeated\n" +
+		"2024-01-01T00:00:02.5Z two\n" +
+		"2024-01-01T00:00:03Z three\n"
+	assert.NoError(t, copyLogLines(strings.NewReader(second), file, &since, &lineCount))
+
+	content, err := os.ReadFile(file.Name())
+	assert.NoError(t, err)
+	assert.Equal(t, "one\ntwo\nthree\n", string(content))
+	assert.Equal(t, 3, lineCount)
+}
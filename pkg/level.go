@@ -0,0 +1,152 @@
+package pkg
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// Line levels, the severity detected in the text of a line. Unlike its class, a level is never
+// a stack continuation or an access line.
+const (
+	LevelError   = "error"
+	LevelWarn    = "warn"
+	LevelInfo    = "info"
+	LevelDebug   = "debug"
+	LevelTrace   = "trace"
+	LevelUnknown = "unknown"
+
+	// levelScanBytes is how much of the start of a line is looked at, a level further in is part
+	// of the message
+	levelScanBytes = 128
+)
+
+// Levels are every level a line can have
+var Levels = []string{LevelError, LevelWarn, LevelInfo, LevelDebug, LevelTrace, LevelUnknown}
+
+// levelNames normalizes the names of levels, syslog severities included
+var levelNames = map[string]string{
+	"emerg": LevelError, "emergency": LevelError, "alert": LevelError, "crit": LevelError, "critical": LevelError,
+	"fatal": LevelError, "panic": LevelError, "severe": LevelError, "err": LevelError, "error": LevelError, "eror": LevelError,
+	"warn": LevelWarn, "warning": LevelWarn, "wrn": LevelWarn,
+	"notice": LevelInfo, "info": LevelInfo, "inf": LevelInfo, "information": LevelInfo,
+	"debug": LevelDebug, "dbg": LevelDebug,
+	"trace": LevelTrace, "trc": LevelTrace,
+}
+
+// syslogLevels are the levels of the syslog severities 0 to 7
+var syslogLevels = []string{LevelError, LevelError, LevelError, LevelError, LevelWarn, LevelInfo, LevelInfo, LevelDebug}
+
+var (
+	// levelPriority is the <PRI> a syslog line starts with
+	levelPriority = regexp.MustCompile(`^<(\d{1,3})>`)
+	// levelKeyValue is a level=warn or "level":"warn" field
+	levelKeyValue = regexp.MustCompile(`^(?:level|lvl|severity)"?[=:] ?"?([A-Za-z]+)`)
+	// levelBracket is a [ERROR] or <warn>
+	levelBracket = regexp.MustCompile(`^[\[<]([A-Za-z]+)[\]>]`)
+	// levelWord is an upper case word like WARN, followed by a colon or not
+	levelWord = regexp.MustCompile(`^([A-Z]{3,11})(?:[^A-Za-z0-9_]|$)`)
+
+	levelKeys = [][]byte{[]byte("level"), []byte("lvl"), []byte("severity")}
+)
+
+// DetectLevel returns the normalized level of a line, LevelUnknown when it has none. The start of the
+// line is looked at for a level field, a syslog priority, and then from left to right for a bracketed
+// level or an upper case level word. The regexes only run where the bytes before could start a match.
+func DetectLevel(line []byte) string {
+	if len(line) > levelScanBytes {
+		line = line[:levelScanBytes]
+	}
+	for _, key := range levelKeys {
+		for rest := line; ; {
+			i := bytes.Index(rest, key)
+			if i < 0 {
+				break
+			}
+			if match := levelKeyValue.FindSubmatch(rest[i:]); match != nil {
+				if level, ok := levelName(match[1]); ok {
+					return level
+				}
+			}
+			rest = rest[i+len(key):]
+		}
+	}
+	if len(line) > 0 && line[0] == '<' {
+		if match := levelPriority.FindSubmatch(line); match != nil {
+			if priority, err := strconv.Atoi(string(match[1])); err == nil && priority < 192 {
+				return syslogLevels[priority%8]
+			}
+		}
+	}
+	wordStart := true
+	for i, c := range line {
+		switch {
+		case c == '[' || c == '<':
+			if match := levelBracket.FindSubmatch(line[i:]); match != nil {
+				if level, ok := levelName(match[1]); ok {
+					return level
+				}
+			}
+		case c >= 'A' && c <= 'Z' && wordStart:
+			if match := levelWord.FindSubmatch(line[i:]); match != nil {
+				if level, ok := levelName(match[1]); ok {
+					return level
+				}
+			}
+		}
+		wordStart = !isWordByte(c)
+	}
+	return LevelUnknown
+}
+
+func isWordByte(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_'
+}
+
+// levelName is the level of a name in any case
+func levelName(name []byte) (string, bool) {
+	var lower [16]byte
+	if len(name) > len(lower) {
+		return "", false
+	}
+	for i, c := range name {
+		if c >= 'A' && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		lower[i] = c
+	}
+	level, ok := levelNames[string(lower[:len(name)])]
+	return level, ok
+}
+
+// levelEvent sets the level and class of a line event, false when it has none of levels
+func levelEvent(event *TailEvent, classifier *Classifier, levels map[string]bool) bool {
+	event.Level = DetectLevel([]byte(event.Content))
+	if levels != nil && !levels[event.Level] {
+		return false
+	}
+	event.Class = classifier.Classify(event.Content, event.Level)
+	return true
+}
+
+// ParseLevels parses a comma separated list of levels, nil for an empty one
+func ParseLevels(s string) (map[string]bool, error) {
+	if s == "" {
+		return nil, nil
+	}
+	levels := map[string]bool{}
+	for _, level := range strings.Split(s, ",") {
+		level = strings.ToLower(strings.TrimSpace(level))
+		known := false
+		for _, l := range Levels {
+			known = known || l == level
+		}
+		if !known {
+			return nil, fmt.Errorf("levels must be a comma separated list of %s, got %q", strings.Join(Levels, " "), level)
+		}
+		levels[level] = true
+	}
+	return levels, nil
+}
@@ -0,0 +1,185 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDetectLevel(t *testing.T) {
+	tests := map[string]string{
+		"2024-01-02 15:04:05 ERROR connection refused":            LevelError,
+		"2024-01-02 15:04:05 [ERROR] connection refused":          LevelError,
+		"2024-01-02 15:04:05 [warn] disk at 91%":                  LevelWarn,
+		"WARN: deprecated flag":                                   LevelWarn,
+		"time=2024-01-02T15:04:05Z level=warn msg=slow":           LevelWarn,
+		`{"time":"2024-01-02T15:04:05Z","level":"debug","msg":1}`: LevelDebug,
+		`{"severity": "INFO", "message": "ok"}`:                   LevelInfo,
+		"<11>Jan  2 15:04:05 host app: failed":                    LevelError,
+		"<14>Jan  2 15:04:05 host app: started":                   LevelInfo,
+		"<12>Jan  2 15:04:05 host app: low memory":                LevelWarn,
+		"Jan  2 15:04:05 host kernel: <crit> thermal":             LevelError,
+		"I0102 15:04:05.000000 TRACE entering handler":            LevelTrace,
+		"2024-01-02 FATAL out of memory":                          LevelError,
+		"2024-01-02 NOTICE reloaded":                              LevelInfo,
+		"GET /api HTTP/1.1 200":                                   LevelUnknown,
+		"plain text without a level":                              LevelUnknown,
+		"an error in the middle of a lower case message":          LevelUnknown,
+		"": LevelUnknown,
+		// a level beyond the start of the line is part of the message
+		"x" + string(make([]byte, levelScanBytes)) + " ERROR": LevelUnknown,
+	}
+	for line, want := range tests {
+		assert.Equal(t, want, DetectLevel([]byte(line)), line)
+	}
+}
+
+func TestParseLevels(t *testing.T) {
+	levels, err := ParseLevels("error, WARN,unknown")
+	assert.NoError(t, err)
+	assert.Equal(t, map[string]bool{LevelError: true, LevelWarn: true, LevelUnknown: true}, levels)
+
+	levels, err = ParseLevels("")
+	assert.NoError(t, err)
+	assert.Nil(t, levels)
+
+	_, err = ParseLevels("error,fatal")
+	assert.Error(t, err)
+}
+
+func BenchmarkDetectLevel(b *testing.B) {
+	lines := map[string]string{
+		"word":      "2024-01-02 15:04:05 INFO server started on :8080 in 35ms",
+		"bracket":   "2024-01-02 15:04:05 [ERROR] upstream timed out while reading response header",
+		"key_value": "time=2024-01-02T15:04:05Z level=warn msg=\"slow query\" duration=1.2s",
+		"none":      "plain text line with nothing that looks like a level at all",
+	}
+	for name, line := range lines {
+		b.Run(name, func(b *testing.B) {
+			line := []byte(line)
+			b.ReportAllocs()
+			for i := 0; i < b.N; i++ {
+				DetectLevel(line)
+			}
+		})
+	}
+}
+
+func TestAPIHandler_GetLevels(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	content := strings.Join([]string{
+		"2024-01-02 15:04:05 INFO started",
+		"2024-01-02 15:04:06 [ERROR] connection refused",
+		"plain continuation",
+		"time=2024-01-02T15:04:07Z level=warn msg=slow",
+		"2024-01-02 15:04:08 ERROR: retry failed",
+	}, "\n") + "\n"
+	assert.NoError(t, os.WriteFile(logFile, []byte(content), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: logFile, Type: TypeFile}})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	get := func(query string) (*httptest.ResponseRecorder, ScanResult) {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api?type=file&file_path="+logFile+query, nil))
+		res := APIResponse{}
+		if rec.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		}
+		return rec, res.Result
+	}
+
+	// every line has its level, filters apply before pagination
+	rec, result := get("&per_page=10")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	levels := []string{}
+	for _, line := range result.Lines {
+		levels = append(levels, line.Level)
+	}
+	assert.Equal(t, []string{LevelInfo, LevelError, LevelUnknown, LevelWarn, LevelError}, levels)
+	_, result = get("&levels=error&per_page=1")
+	assert.Equal(t, 2, result.Total)
+	assert.Equal(t, 2, result.Lines[0].LineNumber)
+	_, result = get("&levels=error&per_page=1&page=2")
+	assert.Equal(t, 5, result.Lines[0].LineNumber)
+	_, result = get("&levels=warn,unknown&per_page=10")
+	assert.Equal(t, 2, result.Total)
+	assert.Equal(t, "plain continuation", result.Lines[0].Content)
+
+	rec, _ = get("&levels=fatal")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec, _ = get("&levels=error&tail=2")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
+
+func TestAPIHandler_GetTailAndStreamLevels(t *testing.T) {
+	useTailHub(t)
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte("INFO one\nERROR two\nINFO three\n"), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: logFile, Type: TypeFile}})
+	server := httptest.NewServer(newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff}))
+	defer server.Close()
+
+	// the snapshot and the lines appended of the levels only
+	conn := dialStream(t, server, "type=file&tail=3&levels=error&file_path="+logFile)
+	defer conn.Close()
+	snapshot := readStreamMessage(t, conn)
+	if assert.Len(t, snapshot.Lines, 1) {
+		assert.Equal(t, "ERROR two", snapshot.Lines[0].Content)
+		assert.Equal(t, LevelError, snapshot.Lines[0].Level)
+		assert.Equal(t, ClassError, snapshot.Lines[0].Class)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/tail?type=file&levels=error,unknown&file_path="+logFile, nil)
+	assert.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer res.Body.Close()
+	scanner := bufio.NewScanner(res.Body)
+	nextLine := func() TailEvent {
+		name, event := "", TailEvent{}
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: ") && name == TailEventLine:
+				assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+				return event
+			}
+		}
+		return event
+	}
+
+	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0600)
+	assert.NoError(t, err)
+	defer f.Close()
+	_, err = f.WriteString("INFO four\nWARN five\nsix\nERROR seven\n")
+	assert.NoError(t, err)
+
+	event := nextLine()
+	assert.Equal(t, "six", event.Content)
+	assert.Equal(t, LevelUnknown, event.Level)
+	assert.Equal(t, int64(1), event.Seq)
+	event = nextLine()
+	assert.Equal(t, "ERROR seven", event.Content)
+	assert.Equal(t, int64(2), event.Seq)
+
+	message := readStreamMessage(t, conn)
+	if assert.NotNil(t, message.Line) {
+		assert.Equal(t, "ERROR seven", message.Line.Content)
+		assert.Equal(t, 7, message.Line.LineNumber)
+		assert.Equal(t, int64(1), message.Line.Seq)
+	}
+}
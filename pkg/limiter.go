@@ -0,0 +1,200 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	DefaultMaxLocalReads   = 4
+	DefaultMaxReadsPerHost = 2
+	DefaultMaxTails        = 64
+	DefaultMaxReadWait     = 10 * time.Second
+
+	limiterKeyLocal = "local"
+)
+
+// DefaultMaxScans is the searches, exports and counts running at once over all sources
+var DefaultMaxScans = runtime.NumCPU()
+
+var ErrLimiterBusy = errors.New("too many concurrent reads")
+
+// ErrTooManyScans is returned when the scans of all sources together are at their budget
+var ErrTooManyScans = errors.New("too many concurrent scans")
+
+// Limiter caps concurrent expensive reads: one budget for the scans of all sources, which bounds the
+// CPU they take, then one for the local disk and one per remote host, and a separate, larger budget
+// for streaming tails which are cheap after the initial seek
+type Limiter struct {
+	mutex   sync.Mutex
+	scans   chan struct{}
+	local   chan struct{}
+	hosts   map[string]chan struct{}
+	perHost int
+	tails   chan struct{}
+	maxWait time.Duration
+}
+
+// LimiterInFlight is a snapshot of the reads currently holding a slot
+type LimiterInFlight struct {
+	Scans int            `json:"scans"`
+	Reads map[string]int `json:"reads"`
+	Tails int            `json:"tails"`
+}
+
+// ReadLimits are the budgets of the limiter a user sets, each of at least one slot
+type ReadLimits struct {
+	MaxReads        int
+	MaxReadsPerHost int
+	MaxTails        int
+}
+
+func (l ReadLimits) Validate() error {
+	var errs []error
+	for _, limit := range []struct {
+		name  string
+		value int
+	}{{"max-reads", l.MaxReads}, {"max-reads-per-host", l.MaxReadsPerHost}, {"max-tails", l.MaxTails}} {
+		if limit.value < 1 {
+			errs = append(errs, fmt.Errorf("%s must be at least 1, got %d", limit.name, limit.value))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// NewLimiter makes a limiter of the budgets, the scans are not capped when maxScans is 0
+func NewLimiter(maxScans int, maxLocal int, maxPerHost int, maxTails int, maxWait time.Duration) *Limiter {
+	var scans chan struct{}
+	if maxScans > 0 {
+		scans = make(chan struct{}, maxScans)
+	}
+	return &Limiter{
+		scans:   scans,
+		local:   make(chan struct{}, maxLocal),
+		hosts:   make(map[string]chan struct{}),
+		perHost: maxPerHost,
+		tails:   make(chan struct{}, maxTails),
+		maxWait: maxWait,
+	}
+}
+
+// AcquireRead waits up to the max wait for a scan slot and a read slot of the source, returning
+// ErrTooManyScans or ErrLimiterBusy after. The returned func releases the slots. Reads proxied to a
+// remote gol take no scan slot, the remote scans within budgets of its own.
+func (l *Limiter) AcquireRead(ctx context.Context, sourceType string, host string, filePath string) (func(), error) {
+	timer := time.NewTimer(l.maxWait)
+	defer timer.Stop()
+	releaseScan := func() {}
+	if l.scans != nil && sourceType != TypeRemoteGol {
+		var err error
+		if releaseScan, err = l.acquireUntil(ctx, timer, l.scans, ErrTooManyScans); err != nil {
+			return nil, err
+		}
+	}
+	release, err := l.acquireUntil(ctx, timer, l.semaphore(limiterKey(sourceType, host, filePath)), ErrLimiterBusy)
+	if err != nil {
+		releaseScan()
+		return nil, err
+	}
+	return func() {
+		release()
+		releaseScan()
+	}, nil
+}
+
+// AcquireSourceRead waits for a read slot of the source only, for a scan reading another source too
+func (l *Limiter) AcquireSourceRead(ctx context.Context, sourceType string, host string, filePath string) (func(), error) {
+	return l.acquire(ctx, l.semaphore(limiterKey(sourceType, host, filePath)))
+}
+
+// AcquireTail waits for a streaming tail slot
+func (l *Limiter) AcquireTail(ctx context.Context) (func(), error) {
+	return l.acquire(ctx, l.tails)
+}
+
+// RetryAfter is the Retry-After header value, in seconds, sent to clients when busy
+func (l *Limiter) RetryAfter() string {
+	return strconv.Itoa(int(math.Ceil(l.maxWait.Seconds())))
+}
+
+// Budgets returns the number of scan, local read, per host read and tail slots, 0 scans when not capped
+func (l *Limiter) Budgets() (int, int, int, int) {
+	return cap(l.scans), cap(l.local), l.perHost, cap(l.tails)
+}
+
+func (l *Limiter) InFlight() LimiterInFlight {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	inFlight := LimiterInFlight{
+		Scans: len(l.scans),
+		Reads: map[string]int{limiterKeyLocal: len(l.local)},
+		Tails: len(l.tails),
+	}
+	keys := make([]string, 0, len(l.hosts))
+	for key := range l.hosts {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		inFlight.Reads[key] = len(l.hosts[key])
+	}
+	return inFlight
+}
+
+func (l *Limiter) semaphore(key string) chan struct{} {
+	if key == limiterKeyLocal {
+		return l.local
+	}
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	semaphore, ok := l.hosts[key]
+	if !ok {
+		semaphore = make(chan struct{}, l.perHost)
+		l.hosts[key] = semaphore
+	}
+	return semaphore
+}
+
+func (l *Limiter) acquire(ctx context.Context, semaphore chan struct{}) (func(), error) {
+	timer := time.NewTimer(l.maxWait)
+	defer timer.Stop()
+	return l.acquireUntil(ctx, timer, semaphore, ErrLimiterBusy)
+}
+
+// acquireUntil waits for a slot of semaphore until timer fires, returning busy then
+func (l *Limiter) acquireUntil(ctx context.Context, timer *time.Timer, semaphore chan struct{}, busy error) (func(), error) {
+	select {
+	case semaphore <- struct{}{}:
+		return func() { <-semaphore }, nil
+	case <-timer.C:
+		return nil, busy
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// limiterKey is the budget a source draws from, local disk reads share one.
+// Container logs copied to a local tmp file are local disk reads.
+func limiterKey(sourceType string, host string, filePath string) string {
+	switch {
+	case sourceType == TypeSSH:
+		return TypeSSH + ":" + host
+	case sourceType == TypeRemoteGol:
+		return TypeRemoteGol + ":" + host
+	case sourceType == TypeHTTP:
+		return TypeHTTP + ":" + host
+	case sourceType == TypeS3:
+		return TypeS3 + ":" + host
+	case sourceType == TypeDocker && !strings.HasPrefix(filePath, TmpContainerPath):
+		return TypeDocker + ":" + host
+	}
+	return limiterKeyLocal
+}
@@ -0,0 +1,90 @@
+package pkg
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimiter_AcquireRead(t *testing.T) {
+	limiter := NewLimiter(0, 1, 1, 2, 20*time.Millisecond)
+	ctx := context.Background()
+
+	release, err := limiter.AcquireRead(ctx, TypeFile, "", "/var/log/app.log")
+	assert.NoError(t, err)
+
+	// local disk is one budget, docker logs copied to tmp included
+	_, err = limiter.AcquireRead(ctx, TypeDocker, "abc", TmpContainerPath+"abc")
+	assert.ErrorIs(t, err, ErrLimiterBusy)
+
+	// remote hosts have their own budgets
+	releaseSSH, err := limiter.AcquireRead(ctx, TypeSSH, "host-a", "/var/log/app.log")
+	assert.NoError(t, err)
+	_, err = limiter.AcquireRead(ctx, TypeSSH, "host-a", "/var/log/app.log")
+	assert.ErrorIs(t, err, ErrLimiterBusy)
+	releaseB, err := limiter.AcquireRead(ctx, TypeSSH, "host-b", "/var/log/app.log")
+	assert.NoError(t, err)
+
+	// tails do not draw from the read budgets
+	releaseTail, err := limiter.AcquireTail(ctx)
+	assert.NoError(t, err)
+
+	assert.Equal(t, LimiterInFlight{
+		Reads: map[string]int{"local": 1, "ssh:host-a": 1, "ssh:host-b": 1},
+		Tails: 1,
+	}, limiter.InFlight())
+
+	// a queued read gets the slot once it is released
+	go func() {
+		time.Sleep(5 * time.Millisecond)
+		release()
+	}()
+	releaseQueued, err := limiter.AcquireRead(ctx, TypeFile, "", "/var/log/other.log")
+	assert.NoError(t, err)
+
+	releaseQueued()
+	releaseSSH()
+	releaseB()
+	releaseTail()
+	assert.Equal(t, 0, limiter.InFlight().Reads["local"])
+	assert.Equal(t, "1", limiter.RetryAfter())
+}
+
+func TestLimiter_AcquireReadScans(t *testing.T) {
+	limiter := NewLimiter(1, 2, 2, 2, 20*time.Millisecond)
+	ctx := context.Background()
+
+	release, err := limiter.AcquireRead(ctx, TypeFile, "", "/var/log/app.log")
+	assert.NoError(t, err)
+	// the scans of every source share one budget
+	_, err = limiter.AcquireRead(ctx, TypeSSH, "host-a", "/var/log/app.log")
+	assert.ErrorIs(t, err, ErrTooManyScans)
+	assert.Equal(t, 1, limiter.InFlight().Scans)
+	// a remote gol scans within its own budget
+	releaseRemote, err := limiter.AcquireRead(ctx, TypeRemoteGol, "dc1", "/var/log/app.log")
+	assert.NoError(t, err)
+	releaseRemote()
+	// another source of the same scan takes its read slot only
+	releaseOther, err := limiter.AcquireSourceRead(ctx, TypeSSH, "host-a", "/var/log/app.log")
+	assert.NoError(t, err)
+	releaseOther()
+	release()
+
+	// a scan slot is given back when the source is busy
+	releaseA, err := limiter.AcquireRead(ctx, TypeSSH, "host-a", "/var/log/app.log")
+	assert.NoError(t, err)
+	full := NewLimiter(2, 1, 1, 1, 20*time.Millisecond)
+	releaseB, err := full.AcquireRead(ctx, TypeFile, "", "/var/log/app.log")
+	assert.NoError(t, err)
+	_, err = full.AcquireRead(ctx, TypeFile, "", "/var/log/other.log")
+	assert.ErrorIs(t, err, ErrLimiterBusy)
+	assert.Equal(t, 1, full.InFlight().Scans)
+
+	releaseA()
+	releaseB()
+	assert.Equal(t, LimiterInFlight{Reads: map[string]int{"local": 0, "remote:dc1": 0, "ssh:host-a": 0}}, limiter.InFlight())
+	scans, _, _, _ := NewLimiter(0, 1, 1, 1, time.Second).Budgets()
+	assert.Equal(t, 0, scans)
+}
@@ -0,0 +1,82 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"regexp"
+
+	"github.com/acarl005/stripansi"
+)
+
+// ReadAround returns the lines around line, n before and n after it, cut at the start and end of the
+// file. A plain local file is counted first, which is cached, so that the read seeks to the checkpoint
+// of the stats cache before the lines instead of scanning the file from its start. Compressed and
+// remote files are scanned. No lines are returned for a line past the end of the file.
+func (w *Watcher) ReadAround(ctx context.Context, line int, n int) (*ScanResult, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	re, err := regexp.Compile(w.matchPattern)
+	if err != nil {
+		return nil, err
+	}
+
+	from, to := max(line-n, 1), line+n
+	var reader io.ReadCloser
+	var scanner *bufio.Scanner
+	current := 0
+	if !w.isRemote && w.filePath != InternalLogPath {
+		// a file grown since is counted on from its last count, a truncated one again, checkpoints included
+		if _, _, err := FileStatsContext(ctx, w.filePath, false, nil); err != nil && !isEmptyFileErr(err) {
+			return nil, err
+		}
+		// from the line before, for the anchor of the first line
+		if file, startLine, ok := seekToLine(w.filePath, from-1); ok {
+			reader, scanner, current = file, newLineScanner(file), startLine-1
+		}
+	}
+	if scanner == nil {
+		if reader, scanner, err = w.openScanner(w.filePath); err != nil {
+			return nil, err
+		}
+	}
+	if reader != nil {
+		defer reader.Close()
+	}
+
+	lines := []LineResult{}
+	var prevHash uint32
+	for scanned := 0; current < to && scanner.Scan(); scanned++ {
+		if scanned%collectCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return nil, err
+			}
+		}
+		current++
+		content := stripansi.Strip(scanner.Text())
+		hash := lineHash(content)
+		if len(lines) > 0 {
+			lines[len(lines)-1].nextHash = hash
+		}
+		if current >= from {
+			lines = append(lines, LineResult{LineNumber: current, Content: content, prevHash: prevHash, hash: hash})
+		}
+		prevHash = hash
+	}
+	// the line after the last one is read for its anchor
+	if len(lines) > 0 && current == to && scanner.Scan() {
+		lines[len(lines)-1].nextHash = lineHash(stripansi.Strip(scanner.Text()))
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(lines) == 0 || lines[len(lines)-1].LineNumber < line {
+		lines = []LineResult{}
+	}
+
+	TruncateLines(lines, GlobalMaxLineLength, re)
+	sources := []LineSource{{FilePath: w.filePath, Host: w.sshHost}}
+	w.finalizeLines(lines, sources)
+	return w.scanResult(lines, len(lines), len(lines), sources), nil
+}
@@ -0,0 +1,146 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func numberedLines(from int, to int) string {
+	var b strings.Builder
+	for i := from; i <= to; i++ {
+		fmt.Fprintf(&b, "line %d\n", i)
+	}
+	return b.String()
+}
+
+func lineNumbers(lines []LineResult) []int {
+	numbers := []int{}
+	for _, line := range lines {
+		numbers = append(numbers, line.LineNumber)
+	}
+	return numbers
+}
+
+func TestWatcher_ReadAround(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte(numberedLines(1, 25000)), 0600))
+	watcher, err := NewWatcher(logFile, "", "", false, "", "", "", "", "")
+	assert.NoError(t, err)
+	ctx := context.Background()
+
+	// past a checkpoint, read from it
+	result, err := watcher.ReadAround(ctx, 20000, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{19998, 19999, 20000, 20001, 20002}, lineNumbers(result.Lines))
+	assert.Equal(t, "line 19998", result.Lines[0].Content)
+	assert.Equal(t, 5, result.Total)
+	entry, ok := GlobalFileStatsCache.Peek(logFile)
+	assert.True(t, ok)
+	assert.Len(t, entry.Checkpoints, 2)
+
+	// the anchors are those of a scan from the start
+	page, err := watcher.Scan(9999, 2, false)
+	assert.NoError(t, err)
+	assert.Equal(t, page.Lines[1].Anchor, result.Lines[0].Anchor)
+	assert.Equal(t, page.Lines[1].Content, result.Lines[0].Content)
+
+	// near the start and the end the context is cut
+	result, err = watcher.ReadAround(ctx, 2, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, lineNumbers(result.Lines))
+	result, err = watcher.ReadAround(ctx, 24999, 3)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{24996, 24997, 24998, 24999, 25000}, lineNumbers(result.Lines))
+
+	// past the end there are no lines
+	result, err = watcher.ReadAround(ctx, 25001, 3)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Lines)
+
+	// the index is extended as the file grows
+	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0600)
+	assert.NoError(t, err)
+	_, err = f.WriteString(numberedLines(25001, 30001))
+	assert.NoError(t, err)
+	f.Close()
+	result, err = watcher.ReadAround(ctx, 30001, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{30000, 30001}, lineNumbers(result.Lines))
+	assert.Equal(t, "line 30001", result.Lines[1].Content)
+	entry, _ = GlobalFileStatsCache.Peek(logFile)
+	assert.Len(t, entry.Checkpoints, 3)
+
+	// and dropped as it is truncated
+	assert.NoError(t, os.WriteFile(logFile, []byte("again 1\nagain 2\n"), 0600))
+	result, err = watcher.ReadAround(ctx, 2, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{1, 2}, lineNumbers(result.Lines))
+	assert.Equal(t, "again 2", result.Lines[1].Content)
+	entry, _ = GlobalFileStatsCache.Peek(logFile)
+	assert.Empty(t, entry.Checkpoints)
+
+	// a gzip file is scanned
+	var gz bytes.Buffer
+	gzipWriter := gzip.NewWriter(&gz)
+	_, err = gzipWriter.Write([]byte(numberedLines(1, 12000)))
+	assert.NoError(t, err)
+	assert.NoError(t, gzipWriter.Close())
+	gzFile := filepath.Join(t.TempDir(), "app.log.gz")
+	assert.NoError(t, os.WriteFile(gzFile, gz.Bytes(), 0600))
+	watcher, err = NewWatcher(gzFile, "", "", false, "", "", "", "", "")
+	assert.NoError(t, err)
+	result, err = watcher.ReadAround(ctx, 11000, 1)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{10999, 11000, 11001}, lineNumbers(result.Lines))
+	assert.Equal(t, "line 11000", result.Lines[1].Content)
+}
+
+func TestAPIHandler_GetContext(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte(numberedLines(1, 200)), 0600))
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: logFile, Type: TypeFile}})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	get := func(query url.Values) *httptest.ResponseRecorder {
+		query.Set("type", TypeFile)
+		query.Set("file_path", logFile)
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/context?"+query.Encode(), nil))
+		return rec
+	}
+
+	rec := get(url.Values{"line": {"100"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	result := ScanResult{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Len(t, result.Lines, 101)
+	assert.Equal(t, 50, result.Lines[0].LineNumber)
+	assert.Equal(t, "line 150", result.Lines[100].Content)
+
+	rec = get(url.Values{"line": {"1"}, "context": {"2"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, []int{1, 2, 3}, lineNumbers(result.Lines))
+
+	rec = get(url.Values{"line": {"200"}, "context": {"2"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.Equal(t, []int{198, 199, 200}, lineNumbers(result.Lines))
+
+	assert.Equal(t, http.StatusNotFound, get(url.Values{"line": {"201"}}).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, get(url.Values{"line": {"0"}}).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, get(url.Values{"line": {"1"}, "context": {"-1"}}).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, get(url.Values{"line": {"1"}, "context": {"5000"}}).Code)
+}
@@ -0,0 +1,117 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"slices"
+)
+
+// lineCountChunk is the size of the blocks newlines are counted in
+const lineCountChunk = 1 << 20
+
+// lineCounter counts the lines of a stream in large blocks, recording the offset after every
+// lineCheckpointsEvery-th line as a checkpoint
+type lineCounter struct {
+	// lines are the lines ended by a newline, offset the bytes counted
+	lines       int
+	offset      int64
+	checkpoints []int64
+	// partial is set while the bytes counted end in a line without newline
+	partial bool
+}
+
+// total is the number of lines, a last line without newline included
+func (c *lineCounter) total() int {
+	if c.partial {
+		return c.lines + 1
+	}
+	return c.lines
+}
+
+// count reads r to its end, reporting the offset reached to job when there is one
+func (c *lineCounter) count(r io.Reader, job *JobTracker) error {
+	buffer := make([]byte, lineCountChunk)
+	for {
+		n, err := r.Read(buffer)
+		if n > 0 {
+			c.add(buffer[:n])
+			if job != nil {
+				job.Progress(c.offset)
+			}
+		}
+		if errors.Is(err, io.EOF) {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (c *lineCounter) add(chunk []byte) {
+	c.partial = chunk[len(chunk)-1] != '\n'
+	for len(chunk) > 0 {
+		next := (c.lines/lineCheckpointsEvery + 1) * lineCheckpointsEvery
+		newlines := bytes.Count(chunk, []byte{'\n'})
+		if c.lines+newlines < next {
+			c.lines += newlines
+			c.offset += int64(len(chunk))
+			return
+		}
+		// only the block with the checkpoint is walked line by line, up to the checkpoint
+		for c.lines < next {
+			i := bytes.IndexByte(chunk, '\n')
+			c.lines++
+			c.offset += int64(i + 1)
+			chunk = chunk[i+1:]
+		}
+		c.checkpoints = append(c.checkpoints, c.offset)
+	}
+}
+
+// countAppendedLines counts the lines of a plain UTF-8 file that only grew since entry was cached,
+// reading only the appended bytes. It returns false when the file has to be counted from the start.
+func countAppendedLines(ctx context.Context, file File, entry FileStatsCacheEntry, size int64, target string) (*lineCounter, bool, error) {
+	if entry.Size <= 0 || size <= entry.Size || entry.Target != target || entry.Fingerprint == "" {
+		return nil, false, nil
+	}
+	fingerprint, err := fingerprintPrefix(file, min(entry.Size, fingerprintSize))
+	if err != nil {
+		return nil, false, err
+	}
+	if fingerprint != entry.Fingerprint {
+		return nil, false, nil
+	}
+	// checkpoints are offsets in the decoded stream, they are file offsets of plain UTF-8 files only
+	head := make([]byte, 512)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, false, err
+	}
+	if IsCompressed(head[:n]) || DetectEncoding(head[:n]) != EncodingUTF8 {
+		return nil, false, nil
+	}
+
+	counter := &lineCounter{
+		lines:       entry.LinesCount,
+		offset:      entry.Size,
+		checkpoints: slices.Clone(entry.Checkpoints),
+		partial:     entry.Partial,
+	}
+	if counter.partial {
+		// the appended bytes continue the last line
+		counter.lines--
+	}
+	if counter.checkpoints == nil {
+		counter.checkpoints = []int64{}
+	}
+	if _, err := file.Seek(entry.Size, io.SeekStart); err != nil {
+		return nil, false, err
+	}
+	if err := counter.count(NewContextReader(ctx, file), nil); err != nil {
+		return nil, false, err
+	}
+	return counter, true, nil
+}
@@ -0,0 +1,167 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// countingOpener counts the bytes read from the files it opens with Read, ReadAt is not counted
+type countingOpener struct {
+	OSFileOpener
+	read int64
+}
+
+type countingFile struct {
+	File
+	opener *countingOpener
+}
+
+func (o *countingOpener) Open(name string) (File, error) {
+	file, err := o.OSFileOpener.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	return &countingFile{File: file, opener: o}, nil
+}
+
+func (f *countingFile) Read(p []byte) (int, error) {
+	n, err := f.File.Read(p)
+	f.opener.read += int64(n)
+	return n, err
+}
+
+func useCountingOpener(t *testing.T) *countingOpener {
+	previous := GlobalFileOpener
+	t.Cleanup(func() { GlobalFileOpener = previous })
+	opener := &countingOpener{}
+	GlobalFileOpener = opener
+	return opener
+}
+
+func TestLineCounter(t *testing.T) {
+	var content bytes.Buffer
+	for i := 0; i < 2*lineCheckpointsEvery+5; i++ {
+		fmt.Fprintf(&content, "line %d%s\n", i, strings.Repeat("x", i%7))
+	}
+	want := []int64{}
+	scanner := bufio.NewScanner(bytes.NewReader(content.Bytes()))
+	var offset int64
+	for lines := 1; scanner.Scan(); lines++ {
+		offset += int64(len(scanner.Bytes())) + 1
+		if lines%lineCheckpointsEvery == 0 {
+			want = append(want, offset)
+		}
+	}
+
+	// blocks of any size, checkpoints included, count the same
+	for _, size := range []int{1, 7, 4096, content.Len()} {
+		counter := &lineCounter{checkpoints: []int64{}}
+		for b := content.Bytes(); len(b) > 0; b = b[min(size, len(b)):] {
+			counter.add(b[:min(size, len(b))])
+		}
+		assert.Equal(t, 2*lineCheckpointsEvery+5, counter.total(), "blocks of %d", size)
+		assert.Equal(t, want, counter.checkpoints, "blocks of %d", size)
+	}
+
+	counter := &lineCounter{}
+	assert.NoError(t, counter.count(strings.NewReader("a\nb\nno newline"), nil))
+	assert.Equal(t, 3, counter.total())
+	assert.True(t, counter.partial)
+}
+
+func TestFileStats_CountsAppendedBytesOnly(t *testing.T) {
+	opener := useCountingOpener(t)
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	first := strings.Repeat("INFO a line of the first part\n", 1000)
+	assert.NoError(t, os.WriteFile(logFile, []byte(first+"partial"), 0600))
+
+	linesCount, _, err := FileStats(logFile, false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1001, linesCount)
+
+	// the appended bytes continue the partial line
+	appended := " line ends\n" + strings.Repeat("INFO appended\n", 10)
+	file, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0600)
+	assert.NoError(t, err)
+	_, err = file.WriteString(appended)
+	assert.NoError(t, err)
+	assert.NoError(t, file.Close())
+
+	opener.read = 0
+	linesCount, size, err := FileStats(logFile, false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1011, linesCount)
+	assert.Equal(t, int64(len(first)+len("partial")+len(appended)), size)
+	assert.Equal(t, int64(len(appended)), opener.read)
+	assert.Equal(t, 0, FileGeneration(logFile))
+
+	// a file that shrank is counted from the start, as a new generation
+	assert.NoError(t, os.WriteFile(logFile, []byte("INFO rotated\n"), 0600))
+	opener.read = 0
+	linesCount, _, err = FileStats(logFile, false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, linesCount)
+	assert.Equal(t, 1, FileGeneration(logFile))
+
+	// so is a file replaced by a larger one with other first bytes
+	assert.NoError(t, os.WriteFile(logFile, []byte("WARN replaced\nWARN replaced\n"), 0600))
+	linesCount, _, err = FileStats(logFile, false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, linesCount)
+}
+
+// BenchmarkFileStats counts the lines of a synthetic file of GOL_BENCH_FILE_SIZE bytes (default 64MiB),
+// e.g. GOL_BENCH_FILE_SIZE=5368709120 go test ./pkg -run - -bench FileStats -benchtime 1x
+func BenchmarkFileStats(b *testing.B) {
+	size := int64(64 << 20)
+	if env := os.Getenv("GOL_BENCH_FILE_SIZE"); env != "" {
+		parsed, err := strconv.ParseInt(env, 10, 64)
+		assert.NoError(b, err)
+		size = parsed
+	}
+	logFile := filepath.Join(b.TempDir(), "big.log")
+	file, err := os.Create(logFile)
+	assert.NoError(b, err)
+	block := bytes.Repeat([]byte("2024-06-01T12:00:00 INFO request_id=1234 path=/api/v1/items took 12ms\n"), 1<<14)
+	writer := bufio.NewWriterSize(file, 1<<20)
+	for written := int64(0); written < size; written += int64(len(block)) {
+		_, err := writer.Write(block)
+		assert.NoError(b, err)
+	}
+	assert.NoError(b, writer.Flush())
+	assert.NoError(b, file.Close())
+	stat, err := os.Stat(logFile)
+	assert.NoError(b, err)
+
+	b.Run("scanner", func(b *testing.B) {
+		b.SetBytes(stat.Size())
+		for i := 0; i < b.N; i++ {
+			file, err := os.Open(logFile)
+			assert.NoError(b, err)
+			scanner := bufio.NewScanner(file)
+			scanner.Buffer(make([]byte, 1024*1024), 1024*1024)
+			lines := 0
+			for scanner.Scan() {
+				lines++
+			}
+			assert.NoError(b, scanner.Err())
+			file.Close()
+		}
+	})
+	b.Run("count", func(b *testing.B) {
+		b.SetBytes(stat.Size())
+		for i := 0; i < b.N; i++ {
+			GlobalFileStatsCache.Delete(logFile)
+			_, _, err := FileStats(logFile, false, nil)
+			assert.NoError(b, err)
+		}
+	})
+}
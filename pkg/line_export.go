@@ -0,0 +1,264 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/acarl005/stripansi"
+)
+
+// The formats the lines of a search are downloaded in with download=true
+const (
+	ExportFormatTxt  = "txt"
+	ExportFormatJSON = "json"
+	ExportFormatCSV  = "csv"
+
+	// DefaultExportMaxLines is how many lines a download has at most, the rest is cut with a trailer
+	DefaultExportMaxLines = 500000
+)
+
+// exportContentTypes are the content types of the downloads, by format
+var exportContentTypes = map[string]string{
+	ExportFormatTxt:  "text/plain; charset=UTF-8",
+	ExportFormatJSON: "application/json",
+	ExportFormatCSV:  "text/csv; charset=UTF-8",
+}
+
+// ExportRecord is a line of a download, its timestamp and level are set when one is detected.
+// Class is the class of the line as a page of it has it.
+type ExportRecord struct {
+	FilePath   string `json:"file_path"`
+	LineNumber int    `json:"line_number"`
+	Timestamp  string `json:"timestamp,omitempty"`
+	Level      string `json:"level,omitempty"`
+	Class      string `json:"class"`
+	Line       string `json:"line"`
+}
+
+// ExportTruncation is the last record of a JSON download cut at maxLines
+type ExportTruncation struct {
+	Truncated bool `json:"truncated"`
+	MaxLines  int  `json:"max_lines"`
+}
+
+func newExportRecord(classifier *Classifier, filePath string, lineNumber int, line string) ExportRecord {
+	record := ExportRecord{FilePath: filePath, LineNumber: lineNumber, Line: line}
+	if ts, ok := extractTime(line); ok {
+		record.Timestamp = ts.Format(time.RFC3339Nano)
+	}
+	if level := DetectLevel([]byte(line)); level != LevelUnknown {
+		record.Level = level
+	}
+	record.Class = classifier.Classify(line, record.Level)
+	return record
+}
+
+// Export streams every line of filePaths kept by the patterns, filters and time range of the watcher to
+// emit, in file order, instead of collecting a page of them. Lines are exported as in the file, not as
+// processed. After maxLines lines it stops and reports the export as truncated. Segments that cannot be
+// read are skipped with a warning, unless there is only one.
+func (w *Watcher) Export(ctx context.Context, filePaths []string, maxLines int, emit func(ExportRecord) error) (bool, []SegmentWarning, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.sampler != nil {
+		w.sampler.reset()
+	}
+	match, ignore, err := w.lineMatchers()
+	if err != nil {
+		return false, nil, err
+	}
+
+	exported := 0
+	var warnings []SegmentWarning
+	for _, filePath := range filePaths {
+		truncated, err := w.exportSegment(ctx, filePath, match, ignore, maxLines, &exported, emit)
+		var readErr *SegmentReadError
+		if len(filePaths) > 1 && errors.As(err, &readErr) {
+			warnings = append(warnings, readErr.Warning())
+			continue
+		}
+		if err != nil || truncated {
+			return truncated, warnings, err
+		}
+	}
+	return false, warnings, nil
+}
+
+func (w *Watcher) exportSegment(ctx context.Context, filePath string, match, ignore lineMatcher, maxLines int, exported *int, emit func(ExportRecord) error) (bool, error) {
+	file, scanner, start, err := w.openScannerAt(filePath)
+	if err != nil {
+		return false, &SegmentReadError{FilePath: filePath, Err: err}
+	}
+	if file != nil {
+		defer file.Close()
+	}
+	if w.sampler != nil {
+		w.sampler.reseed(file, filePath)
+	}
+	classifier := ClassifierFor(filePath)
+	export := func(lineNumber int, line string) (bool, error) {
+		if *exported >= maxLines {
+			return true, nil
+		}
+		*exported++
+		return false, emit(newExportRecord(classifier, filePath, lineNumber, line))
+	}
+
+	// entries are grouped as a page of them is, a download of entries is as long as a read of them
+	if w.multiline != nil {
+		entries, _, err := w.collectMatchingEntries(ctx, scanner, start)
+		if err != nil {
+			return false, err
+		}
+		for _, entry := range entries {
+			if truncated, err := export(entry.LineNumber, entry.Content); truncated || err != nil {
+				return truncated, err
+			}
+		}
+		return false, nil
+	}
+
+	lineNumber := start.skipped
+	for scanner.Scan() {
+		if lineNumber%collectCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return false, err
+			}
+		}
+		line := scanner.Bytes()
+		content := ""
+		if hasANSI(line) {
+			content = stripansi.Strip(string(line))
+			line = []byte(content)
+		}
+		lineNumber++
+		if start.timeRange != nil {
+			in, done := start.timeRange.line(line)
+			if done {
+				break
+			}
+			if !in {
+				continue
+			}
+		}
+		if w.sampler != nil && !w.sampler.sampleLine(lineNumber) {
+			continue
+		}
+		raw := string(line)
+		if _, _, _, ok := w.keepLine(match, ignore, line, content); !ok {
+			continue
+		}
+		if w.sampler != nil && !w.sampler.keepMatch() {
+			continue
+		}
+		if truncated, err := export(lineNumber, raw); truncated || err != nil {
+			return truncated, err
+		}
+	}
+	return false, scanner.Err()
+}
+
+// ExportWriter writes the records of a download in one of the export formats
+type ExportWriter struct {
+	format  string
+	writer  *bufio.Writer
+	csv     *csv.Writer
+	records int
+}
+
+func NewExportWriter(w io.Writer, format string) *ExportWriter {
+	writer := &ExportWriter{format: format, writer: bufio.NewWriterSize(w, exportWriteBufferSize)}
+	if format == ExportFormatCSV {
+		writer.csv = csv.NewWriter(writer.writer)
+	}
+	return writer
+}
+
+// Write writes a record, after the header of the format before the first one
+func (w *ExportWriter) Write(record ExportRecord) error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	w.records++
+	switch w.format {
+	case ExportFormatJSON:
+		if w.records > 1 {
+			if _, err := w.writer.WriteString(",\n"); err != nil {
+				return err
+			}
+		}
+		return writeJSONRecord(w.writer, record)
+	case ExportFormatCSV:
+		return w.csv.Write([]string{record.FilePath, strconv.Itoa(record.LineNumber), record.Timestamp, record.Level, record.Class, record.Line})
+	default:
+		_, err := w.writer.WriteString(record.Line + "\n")
+		return err
+	}
+}
+
+// Close ends the download, with a trailer telling that it was cut at maxLines when it is truncated
+func (w *ExportWriter) Close(truncated bool, maxLines int) error {
+	if err := w.start(); err != nil {
+		return err
+	}
+	trailer := fmt.Sprintf("# truncated after %d lines, the -export-max-lines of the server", maxLines)
+	var err error
+	switch w.format {
+	case ExportFormatJSON:
+		if truncated {
+			if w.records > 0 {
+				if _, err = w.writer.WriteString(",\n"); err != nil {
+					return err
+				}
+			}
+			if err = writeJSONRecord(w.writer, ExportTruncation{Truncated: true, MaxLines: maxLines}); err != nil {
+				return err
+			}
+		}
+		_, err = w.writer.WriteString("\n]\n")
+	case ExportFormatCSV:
+		if truncated {
+			err = w.csv.Write([]string{"", "", "", "", "", trailer})
+		}
+		if err == nil {
+			w.csv.Flush()
+			err = w.csv.Error()
+		}
+	default:
+		if truncated {
+			_, err = w.writer.WriteString(trailer + "\n")
+		}
+	}
+	if err != nil {
+		return err
+	}
+	return w.writer.Flush()
+}
+
+// start writes the header of the format, once
+func (w *ExportWriter) start() error {
+	if w.records > 0 || w.format == ExportFormatTxt {
+		return nil
+	}
+	if w.format == ExportFormatJSON {
+		_, err := w.writer.WriteString("[\n")
+		return err
+	}
+	return w.csv.Write([]string{"file_path", "line_number", "timestamp", "level", "class", "line"})
+}
+
+func writeJSONRecord(w io.Writer, record any) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(data)
+	return err
+}
@@ -0,0 +1,137 @@
+package pkg
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAPIHandler_GetDownloadLines(t *testing.T) {
+	defer func(maxLines int) { GlobalExportMaxLines = maxLines }(GlobalExportMaxLines)
+	lines := []string{}
+	for i := 1; i <= 10; i++ {
+		level := "INFO"
+		if i%3 == 0 {
+			level = "ERROR"
+		}
+		lines = append(lines, fmt.Sprintf(`2024-06-01T12:00:%02dZ %s request "%d", done`, i, level, i))
+	}
+	filePath := filepath.Join(t.TempDir(), "app.log")
+	assert.NoError(t, os.WriteFile(filePath, []byte(strings.Join(lines, "\n")+"\n"), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: filePath, Type: TypeFile}})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api?type=file&file_path="+filePath+"&"+query, nil))
+		return rec
+	}
+
+	// every matching line, not a page of them
+	rec := get("levels=error&per_page=1&download=true")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `attachment; filename="app.export.txt"`, rec.Header().Get(echo.HeaderContentDisposition))
+	assert.Equal(t, strings.Join([]string{lines[2], lines[5], lines[8]}, "\n")+"\n", rec.Body.String())
+
+	rec = get("query=ERROR&download=true&format=json")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get(echo.HeaderContentType))
+	var records []ExportRecord
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &records))
+	assert.Len(t, records, 3)
+	assert.Equal(t, ExportRecord{FilePath: filePath, LineNumber: 3, Timestamp: "2024-06-01T12:00:03Z", Level: LevelError, Class: ClassError, Line: lines[2]}, records[0])
+
+	// quoted as lines with quotes and commas need
+	rec = get("query=ERROR&download=true&format=csv")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"file_path", "line_number", "timestamp", "level", "class", "line"},
+		{filePath, "3", "2024-06-01T12:00:03Z", LevelError, ClassError, lines[2]},
+		{filePath, "6", "2024-06-01T12:00:06Z", LevelError, ClassError, lines[5]},
+		{filePath, "9", "2024-06-01T12:00:09Z", LevelError, ClassError, lines[8]},
+	}, rows)
+
+	// cut after the max lines, with a trailer telling so
+	GlobalExportMaxLines = 2
+	rec = get("download=true")
+	assert.Equal(t, strings.Join(lines[:2], "\n")+"\n# truncated after 2 lines, the -export-max-lines of the server\n", rec.Body.String())
+	rec = get("download=true&format=json")
+	var truncated []map[string]interface{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &truncated))
+	assert.Len(t, truncated, 3)
+	assert.Equal(t, map[string]interface{}{"truncated": true, "max_lines": float64(2)}, truncated[2])
+	rec = get("download=true&format=csv")
+	rows, err = csv.NewReader(rec.Body).ReadAll()
+	assert.NoError(t, err)
+	assert.Len(t, rows, 4)
+	assert.Equal(t, "# truncated after 2 lines, the -export-max-lines of the server", rows[3][5])
+
+	// the classes of the path defaults of the file apply
+	defer GlobalPathDefaults.Set(nil)
+	GlobalPathDefaults.Set([]PathConfig{{Pattern: filePath, Defaults: &ViewDefaults{Classes: map[string][]string{ClassWarn: {"INFO"}}}}})
+	rec = get("query=INFO&download=true&format=json")
+	records = nil
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &records))
+	assert.Equal(t, LevelInfo, records[0].Level)
+	assert.Equal(t, ClassWarn, records[0].Class)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, get("format=csv").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, get("download=true&format=xml").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, get("download=true&tail=5").Code)
+}
+
+func TestAPIHandler_GetDownloadLines_Async(t *testing.T) {
+	useExports(t)
+	filePath := filepath.Join(t.TempDir(), "app.log")
+	assert.NoError(t, os.WriteFile(filePath, []byte("2024-06-01T12:00:01Z INFO ok\n2024-06-01T12:00:02Z ERROR failed, retrying\n"), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: filePath, Type: TypeFile}})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	get := func(target string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	// the job ID at once, the download from the exports once its job is done
+	rec := get("/api?type=file&file_path=" + filePath + "&query=ERROR&download=true&format=csv&async=true")
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	result := ExportJobResult{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &result))
+	assert.NotEmpty(t, result.JobID)
+	assert.Eventually(t, func() bool {
+		job, ok := GlobalJobs.Get(result.JobID)
+		return ok && job.Kind == JobKindExport && job.State == JobStateDone
+	}, 2*time.Second, 10*time.Millisecond)
+	rec = get("/api/exports/" + result.JobID)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, `attachment; filename="app.export.csv"`, rec.Header().Get(echo.HeaderContentDisposition))
+	rows, err := csv.NewReader(rec.Body).ReadAll()
+	assert.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"file_path", "line_number", "timestamp", "level", "class", "line"},
+		{filePath, "2", "2024-06-01T12:00:02Z", LevelError, ClassError, "2024-06-01T12:00:02Z ERROR failed, retrying"},
+	}, rows)
+
+	// an export still being written answers its job
+	_, job := GlobalJobs.Start(context.Background(), JobKindExport, filePath, 0, true)
+	defer job.Finish(nil)
+	rec = get("/api/exports/" + job.ID())
+	assert.Equal(t, http.StatusAccepted, rec.Code)
+	assert.Contains(t, rec.Body.String(), `"state":"running"`)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, get("/api?type=file&file_path="+filePath+"&async=true").Code)
+	GlobalDataDir = ""
+	assert.Equal(t, http.StatusUnprocessableEntity, get("/api?type=file&file_path="+filePath+"&download=true&async=true").Code)
+}
@@ -0,0 +1,188 @@
+package pkg
+
+import (
+	"context"
+	"crypto/sha1" // nolint: gosec
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+const (
+	lineIndexVersion = 1
+	lineIndexExt     = ".idx"
+)
+
+// LineIndexes keep the line counts and checkpoints of large local files on disk, an index file per
+// file, so that a restart neither counts them again nor scans them from the start for a line
+type LineIndexes struct {
+	dir string
+	// persist keeps the index files when the server stops, they are removed otherwise
+	persist bool
+}
+
+// lineIndexFile is an index file, an index failing its checksum is rebuilt
+type lineIndexFile struct {
+	Version int `json:"version"`
+	// Identity is the device and inode of the file indexed, a file replaced under its path is indexed again
+	Identity string              `json:"identity"`
+	Entry    FileStatsCacheEntry `json:"entry"`
+	Checksum string              `json:"checksum"`
+}
+
+func NewLineIndexes(dir string, persist bool) *LineIndexes {
+	return &LineIndexes{dir: dir, persist: persist}
+}
+
+// Path is the index file of filePath
+func (x *LineIndexes) Path(filePath string) string {
+	sum := sha1.Sum([]byte(filePath)) // nolint: gosec
+	return filepath.Join(x.dir, hex.EncodeToString(sum[:])+lineIndexExt)
+}
+
+func (f lineIndexFile) checksum() string {
+	f.Checksum = ""
+	b, _ := json.Marshal(f)
+	sum := sha1.Sum(b) // nolint: gosec
+	return hex.EncodeToString(sum[:])
+}
+
+// Load returns the index of filePath when the file indexed is still the file of identity. An index
+// that cannot be read, is corrupted or of another version is removed, the file is counted again.
+func (x *LineIndexes) Load(filePath string, identity string) (FileStatsCacheEntry, bool) {
+	b, err := os.ReadFile(x.Path(filePath))
+	if err != nil {
+		return FileStatsCacheEntry{}, false
+	}
+	var index lineIndexFile
+	if err := json.Unmarshal(b, &index); err != nil || index.Version != lineIndexVersion || index.Checksum != index.checksum() || index.Entry.FilePath != filePath {
+		slog.Debug("discarding line index", "path", x.Path(filePath), "filePath", filePath)
+		x.Delete(filePath)
+		return FileStatsCacheEntry{}, false
+	}
+	if index.Identity != identity {
+		return FileStatsCacheEntry{}, false
+	}
+	return index.Entry, true
+}
+
+// Save writes the index of entry atomically, files without checkpoints are counted fast enough and
+// have their index removed instead
+func (x *LineIndexes) Save(entry FileStatsCacheEntry, identity string) error {
+	if len(entry.Checkpoints) == 0 {
+		x.Delete(entry.FilePath)
+		return nil
+	}
+	if err := os.MkdirAll(x.dir, 0700); err != nil {
+		return err
+	}
+	if err := EnsureFreeDisk(x.dir, 0); err != nil {
+		return err
+	}
+	index := lineIndexFile{Version: lineIndexVersion, Identity: identity, Entry: entry}
+	index.Checksum = index.checksum()
+	b, err := json.Marshal(index)
+	if err != nil {
+		return err
+	}
+	path := x.Path(entry.FilePath)
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Delete removes the index of filePath
+func (x *LineIndexes) Delete(filePath string) {
+	if err := os.Remove(x.Path(filePath)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Warn("removing line index", "path", x.Path(filePath), "error", err)
+	}
+}
+
+// Close removes every index file unless they persist
+func (x *LineIndexes) Close() {
+	if x.persist {
+		return
+	}
+	entries, err := os.ReadDir(x.dir)
+	if err != nil {
+		return
+	}
+	for _, entry := range entries {
+		if strings.HasSuffix(entry.Name(), lineIndexExt) || strings.HasSuffix(entry.Name(), lineIndexExt+".tmp") {
+			os.Remove(filepath.Join(x.dir, entry.Name()))
+		}
+	}
+}
+
+// lineIndexIdentity is the identity of the file indexed, its device and inode where there are some
+func lineIndexIdentity(filePath string, info fs.FileInfo) string {
+	if id, ok := fileSysID(info); ok {
+		return id
+	}
+	return fileIdentity(filePath)
+}
+
+// loadLineIndex puts the index of filePath into the stats cache when the cache has no entry for it.
+// HTTP sources and S3 objects have no identity to check an index against and are not indexed.
+func loadLineIndex(filePath string, info fs.FileInfo) {
+	if GlobalLineIndexes == nil || IsHTTPURL(filePath) || IsS3URL(filePath) {
+		return
+	}
+	if _, ok := GlobalFileStatsCache.Peek(filePath); ok {
+		return
+	}
+	if entry, ok := GlobalLineIndexes.Load(filePath, lineIndexIdentity(filePath, info)); ok {
+		GlobalFileStatsCache.Set(entry)
+	}
+}
+
+// saveLineIndex writes the index of a file just counted, logging but never failing
+func saveLineIndex(entry FileStatsCacheEntry, info fs.FileInfo) {
+	if GlobalLineIndexes == nil || IsHTTPURL(entry.FilePath) || IsS3URL(entry.FilePath) {
+		return
+	}
+	if err := GlobalLineIndexes.Save(entry, lineIndexIdentity(entry.FilePath, info)); err != nil {
+		slog.Warn("saving line index", "filePath", entry.FilePath, "error", err)
+	}
+}
+
+// checkpointBefore is the closest checkpoint of the cached stats of filePath before line, the line
+// number and byte offset it is at
+func checkpointBefore(filePath string, line int) (int, int64) {
+	entry, _ := GlobalFileStatsCache.Peek(filePath)
+	startLine := 1
+	var offset int64
+	for i, checkpoint := range entry.Checkpoints {
+		checkpointLine := (i+1)*lineCheckpointsEvery + 1
+		if checkpointLine > line {
+			break
+		}
+		startLine = checkpointLine
+		offset = checkpoint
+	}
+	return startLine, offset
+}
+
+// seekLineIndex positions file, a plain local UTF-8 file, at the closest checkpoint before line, the
+// file counted first for its checkpoints to be current. It returns the line number it is positioned at.
+func seekLineIndex(ctx context.Context, file File, filePath string, line int) (int, error) {
+	if _, _, err := FileStatsContext(ctx, filePath, false, nil); err != nil {
+		if isEmptyFileErr(err) {
+			return 1, nil
+		}
+		return 0, err
+	}
+	startLine, offset := checkpointBefore(filePath, line)
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return 0, err
+	}
+	return startLine, nil
+}
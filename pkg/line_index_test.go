@@ -0,0 +1,135 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func useLineIndexes(t *testing.T, persist bool) *LineIndexes {
+	indexes, cache := GlobalLineIndexes, GlobalFileStatsCache
+	t.Cleanup(func() { GlobalLineIndexes, GlobalFileStatsCache = indexes, cache })
+	GlobalLineIndexes = NewLineIndexes(t.TempDir(), persist)
+	GlobalFileStatsCache = NewFileStatsCache()
+	return GlobalLineIndexes
+}
+
+func TestLineIndexes(t *testing.T) {
+	indexes := NewLineIndexes(t.TempDir(), true)
+	entry := FileStatsCacheEntry{FilePath: "/var/log/app.log", Size: 100, LinesCount: 20000, Checkpoints: []int64{50, 90}}
+	assert.NoError(t, indexes.Save(entry, "inode:1:2"))
+	loaded, ok := indexes.Load("/var/log/app.log", "inode:1:2")
+	assert.True(t, ok)
+	assert.Equal(t, entry, loaded)
+
+	// the index of a file replaced under its path is not used
+	_, ok = indexes.Load("/var/log/app.log", "inode:1:3")
+	assert.False(t, ok)
+	assert.FileExists(t, indexes.Path("/var/log/app.log"))
+
+	// a corrupted index is removed
+	b, err := os.ReadFile(indexes.Path("/var/log/app.log"))
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(indexes.Path("/var/log/app.log"), bytes.Replace(b, []byte(`"lines_count":20000`), []byte(`"lines_count":20001`), 1), 0600))
+	_, ok = indexes.Load("/var/log/app.log", "inode:1:2")
+	assert.False(t, ok)
+	assert.NoFileExists(t, indexes.Path("/var/log/app.log"))
+	assert.NoError(t, os.WriteFile(indexes.Path("/var/log/app.log"), []byte("not an index"), 0600))
+	_, ok = indexes.Load("/var/log/app.log", "inode:1:2")
+	assert.False(t, ok)
+
+	// files without checkpoints are not indexed
+	assert.NoError(t, indexes.Save(entry, "inode:1:2"))
+	assert.NoError(t, indexes.Save(FileStatsCacheEntry{FilePath: "/var/log/app.log", Size: 10, LinesCount: 2}, "inode:1:2"))
+	assert.NoFileExists(t, indexes.Path("/var/log/app.log"))
+
+	// the index files persist unless asked not to
+	assert.NoError(t, indexes.Save(entry, "inode:1:2"))
+	indexes.Close()
+	assert.FileExists(t, indexes.Path("/var/log/app.log"))
+	indexes = NewLineIndexes(filepath.Dir(indexes.Path("")), false)
+	indexes.Close()
+	assert.NoFileExists(t, indexes.Path("/var/log/app.log"))
+}
+
+func TestFileStats_LineIndex(t *testing.T) {
+	indexes := useLineIndexes(t, true)
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte(numberedLines(1, 25000)), 0600))
+	ctx := context.Background()
+
+	linesCount, _, err := FileStatsContext(ctx, logFile, false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 25000, linesCount)
+	assert.FileExists(t, indexes.Path(logFile))
+
+	// a restart counts the file from its index, the index is trusted while the file looks the same
+	info, err := os.Stat(logFile)
+	assert.NoError(t, err)
+	b, err := os.ReadFile(logFile)
+	assert.NoError(t, err)
+	newline := len(b)/2 + bytes.IndexByte(b[len(b)/2:], '\n')
+	b[newline] = ' '
+	assert.NoError(t, os.WriteFile(logFile, b, 0600))
+	assert.NoError(t, os.Chtimes(logFile, info.ModTime(), info.ModTime()))
+	GlobalFileStatsCache = NewFileStatsCache()
+	linesCount, _, err = FileStatsContext(ctx, logFile, false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 25000, linesCount)
+	b[newline] = '\n'
+	assert.NoError(t, os.WriteFile(logFile, b, 0600))
+
+	// the index grows with the file
+	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0600)
+	assert.NoError(t, err)
+	_, err = f.WriteString(numberedLines(25001, 30001))
+	assert.NoError(t, err)
+	f.Close()
+	GlobalFileStatsCache = NewFileStatsCache()
+	linesCount, _, err = FileStatsContext(ctx, logFile, false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 30001, linesCount)
+	entry, ok := indexes.Load(logFile, fileIdentity(logFile))
+	assert.True(t, ok)
+	assert.Len(t, entry.Checkpoints, 3)
+
+	// and is rebuilt for a file written anew
+	rewritten := logFile + ".new"
+	assert.NoError(t, os.WriteFile(rewritten, []byte(numberedLines(1, 10001)), 0600))
+	assert.NoError(t, os.Rename(rewritten, logFile))
+	GlobalFileStatsCache = NewFileStatsCache()
+	linesCount, _, err = FileStatsContext(ctx, logFile, false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 10001, linesCount)
+	entry, ok = indexes.Load(logFile, fileIdentity(logFile))
+	assert.True(t, ok)
+	assert.Len(t, entry.Checkpoints, 1)
+}
+
+func TestAPIHandler_GetDownload_LineIndex(t *testing.T) {
+	useLineIndexes(t, true)
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte(numberedLines(1, 25000)), 0600))
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: logFile, Type: TypeFile}})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+
+	for _, lines := range []string{"20000-20002", "10001-10001", "1-2", "24999-25005"} {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/download?type=file&file_path="+logFile+"&lines="+lines, nil))
+		assert.Equal(t, http.StatusOK, rec.Code)
+		expected := map[string]string{
+			"20000-20002": numberedLines(20000, 20002),
+			"10001-10001": numberedLines(10001, 10001),
+			"1-2":         numberedLines(1, 2),
+			"24999-25005": numberedLines(24999, 25000),
+		}[lines]
+		assert.Equal(t, expected, rec.Body.String(), lines)
+	}
+}
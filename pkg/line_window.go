@@ -0,0 +1,223 @@
+package pkg
+
+import (
+	"math"
+	"regexp"
+	"strings"
+)
+
+const (
+	// DefaultMaxResponseBytes is the content of the lines a response holds at most
+	DefaultMaxResponseBytes = 16 << 20
+
+	// lineOverheadBytes is what a line adds to a response besides its content: its number, anchor,
+	// level, class, date and agent
+	lineOverheadBytes = 200
+)
+
+// lineWindow keeps the matches of a scan that its page returns, while the scan counts all of them, so
+// that a scan holds at most a page of lines however many match. A forward window keeps the matches of
+// the page from its start on, a reverse one the last page*size matches in a ring, the newest page being
+// the first. The window is truncated past maxLines lines, or budget bytes of them: a forward window
+// keeps no more lines, a reverse one drops its oldest.
+type lineWindow struct {
+	start    int
+	size     int
+	reverse  bool
+	maxLines int
+	budget   int64
+	// lines longer than maxLength are truncated for display as they are kept, with the highlights of
+	// re, so that they are not held in full
+	maxLength int
+	re        *regexp.Regexp
+	// source is set on the lines kept, the index of the segment being scanned
+	source int
+
+	// clamped is set on a reverse page of more than maxLines lines
+	clamped   bool
+	matches   int
+	lines     []LineResult
+	head      int
+	bytes     int64
+	truncated bool
+}
+
+// newLineWindow keeps every match, as is
+func newLineWindow() *lineWindow {
+	return &lineWindow{size: math.MaxInt, maxLines: math.MaxInt}
+}
+
+// newPageWindow keeps the matches of page of pageSize lines, at most maxLines and budget bytes of them,
+// truncated to maxLength bytes with the highlights of re. A budget of 0 keeps any size. Reverse pages are
+// of maxLines lines at most, so that the ring of the pages up to them stays bounded.
+func newPageWindow(page int, pageSize int, reverse bool, maxLines int, budget int64, maxLength int, re *regexp.Regexp) *lineWindow {
+	if maxLines <= 0 {
+		maxLines = math.MaxInt
+	}
+	w := &lineWindow{size: pageSize, maxLines: maxLines, budget: budget, maxLength: maxLength, re: re}
+	if reverse {
+		pageSize = min(pageSize, maxLines)
+		w.reverse, w.clamped, w.maxLines = true, w.size > pageSize, pageSize
+		w.size = math.MaxInt
+		if pageSize == 0 || page <= math.MaxInt/pageSize {
+			w.size = page * pageSize
+		}
+		return w
+	}
+	w.start = math.MaxInt
+	if pageSize == 0 || page-1 <= math.MaxInt/pageSize {
+		w.start = (page - 1) * pageSize
+	}
+	return w
+}
+
+// add counts a match and keeps it when it is in the window, telling whether it was kept
+func (w *lineWindow) add(line LineResult) bool {
+	index := w.matches
+	w.matches++
+	if w.reverse {
+		if w.size == 0 {
+			return false
+		}
+		w.keep(&line)
+		if len(w.lines) < w.size {
+			w.lines = append(w.lines, line)
+		} else {
+			w.lines[w.head] = line
+			w.head = (w.head + 1) % len(w.lines)
+		}
+		return true
+	}
+	if index < w.start || index-w.start >= w.size || w.truncated {
+		return false
+	}
+	w.keep(&line)
+	cost := lineBytes(line)
+	if len(w.lines) >= w.maxLines || (w.budget > 0 && len(w.lines) > 0 && w.bytes+cost > w.budget) {
+		w.truncated = true
+		return false
+	}
+	w.bytes += cost
+	w.lines = append(w.lines, line)
+	return true
+}
+
+// wants tells whether the next match is kept, the scan makes no string of the others
+func (w *lineWindow) wants() bool {
+	if w.reverse {
+		return w.size > 0
+	}
+	return w.matches >= w.start && w.matches-w.start < w.size && !w.truncated
+}
+
+// full tells whether a forward window keeps no more lines
+func (w *lineWindow) full() bool {
+	return !w.reverse && (w.truncated || len(w.lines) >= w.size)
+}
+
+func (w *lineWindow) keep(line *LineResult) {
+	line.Source = w.source
+	if w.maxLength > 0 && len(line.Content) > w.maxLength {
+		TruncateLine(line, w.maxLength, w.re)
+		line.Content = strings.Clone(line.Content)
+	}
+}
+
+// windowMark is the state of a window, restored by rollback
+type windowMark struct {
+	matches   int
+	kept      int
+	bytes     int64
+	truncated bool
+	ring      []LineResult
+	head      int
+}
+
+// mark is the state of the window before a segment is scanned, for a segment failing halfway to be
+// left out. A ring is copied, lines kept since could overwrite it.
+func (w *lineWindow) mark() windowMark {
+	m := windowMark{matches: w.matches, kept: len(w.lines), bytes: w.bytes, truncated: w.truncated, head: w.head}
+	if w.reverse {
+		m.ring = append([]LineResult(nil), w.lines...)
+	}
+	return m
+}
+
+// rollback forgets the matches since m
+func (w *lineWindow) rollback(m windowMark) {
+	w.matches, w.bytes, w.truncated, w.head = m.matches, m.bytes, m.truncated, m.head
+	if w.reverse {
+		w.lines = m.ring
+		return
+	}
+	w.lines = w.lines[:m.kept]
+}
+
+// last is the line kept last, nil before any, for the scan to link it to the line after it
+func (w *lineWindow) last() *LineResult {
+	if len(w.lines) == 0 {
+		return nil
+	}
+	return &w.lines[(w.head+len(w.lines)-1)%len(w.lines)]
+}
+
+// page returns the lines of the page in file order, truncated for display. A reverse page is cut to
+// its newest lines within the budget.
+func (w *lineWindow) page() []LineResult {
+	lines := w.lines
+	if w.reverse {
+		lines = append(w.lines[w.head:len(w.lines):len(w.lines)], w.lines[:w.head]...)
+		lines = lines[:min(w.maxLines, len(lines))]
+		w.truncated = w.clamped && w.matches > w.size
+		bytes := int64(0)
+		for _, line := range lines {
+			bytes += lineBytes(line)
+		}
+		for w.budget > 0 && bytes > w.budget && len(lines) > 1 {
+			bytes -= lineBytes(lines[0])
+			lines = lines[1:]
+			w.truncated = true
+		}
+	}
+	if lines == nil {
+		lines = []LineResult{}
+	}
+	for i := range lines {
+		if !lines[i].Truncated {
+			TruncateLine(&lines[i], w.maxLength, w.re)
+		}
+	}
+	return lines
+}
+
+// lineBytes estimates what a line adds to a response: its content, twice for a JSON object which is
+// parsed along, and its fields
+func lineBytes(line LineResult) int64 {
+	bytes := int64(len(line.Content)) + lineOverheadBytes
+	if strings.HasPrefix(line.Content, "{") {
+		bytes += int64(len(line.Content))
+	}
+	for key, value := range line.Fields {
+		bytes += int64(len(key) + len(value))
+	}
+	return bytes
+}
+
+// cutToBudget keeps the last lines within maxLines and budget bytes, telling whether any was cut
+func cutToBudget(lines []LineResult, maxLines int, budget int64) ([]LineResult, bool) {
+	cut := false
+	if maxLines > 0 && len(lines) > maxLines {
+		lines, cut = lines[len(lines)-maxLines:], true
+	}
+	if budget <= 0 {
+		return lines, cut
+	}
+	bytes := int64(0)
+	for i := len(lines) - 1; i >= 0; i-- {
+		bytes += lineBytes(lines[i])
+		if bytes > budget && i < len(lines)-1 {
+			return lines[i+1:], true
+		}
+	}
+	return lines, cut
+}
@@ -0,0 +1,218 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func windowContents(lines []LineResult) []string {
+	contents := []string{}
+	for _, line := range lines {
+		contents = append(contents, line.Content)
+	}
+	return contents
+}
+
+func TestLineWindow(t *testing.T) {
+	add := func(window *lineWindow, n int) {
+		for i := 1; i <= n; i++ {
+			window.add(LineResult{LineNumber: i, Content: fmt.Sprintf("line %d", i)})
+		}
+	}
+
+	// the second page of 3, every match counted
+	window := newPageWindow(2, 3, false, 10, 0, 0, nil)
+	add(window, 10)
+	assert.Equal(t, []string{"line 4", "line 5", "line 6"}, windowContents(window.page()))
+	assert.Equal(t, 10, window.matches)
+	assert.False(t, window.truncated)
+
+	// a page over maxLines stops at them
+	window = newPageWindow(1, 100, false, 4, 0, 0, nil)
+	add(window, 10)
+	assert.Equal(t, []string{"line 1", "line 2", "line 3", "line 4"}, windowContents(window.page()))
+	assert.True(t, window.truncated)
+	assert.True(t, window.full())
+
+	// or over the budget, keeping a line however large
+	window = newPageWindow(1, 100, false, 100, 2*lineOverheadBytes+20, 0, nil)
+	add(window, 10)
+	assert.Equal(t, []string{"line 1", "line 2"}, windowContents(window.page()))
+	assert.True(t, window.truncated)
+	window = newPageWindow(1, 100, false, 100, 1, 0, nil)
+	add(window, 10)
+	assert.Equal(t, []string{"line 1"}, windowContents(window.page()))
+
+	// a reverse page is the last of its lines, clamped to maxLines
+	window = newPageWindow(1, 3, true, 10, 0, 0, nil)
+	add(window, 10)
+	assert.Equal(t, []string{"line 8", "line 9", "line 10"}, windowContents(window.page()))
+	assert.False(t, window.truncated)
+	window = newPageWindow(1, 5, true, 3, 0, 0, nil)
+	add(window, 10)
+	assert.Equal(t, []string{"line 8", "line 9", "line 10"}, windowContents(window.page()))
+	assert.True(t, window.truncated)
+	window = newPageWindow(1, 3, true, 10, 2*lineOverheadBytes+20, 0, nil)
+	add(window, 10)
+	assert.Equal(t, []string{"line 9", "line 10"}, windowContents(window.page()))
+	assert.True(t, window.truncated)
+
+	// long lines are truncated as they are kept
+	window = newPageWindow(1, 3, false, 10, 0, 4, nil)
+	window.add(LineResult{Content: "abcdefgh"})
+	lines := window.page()
+	assert.Equal(t, "abcd", lines[0].Content)
+	assert.Equal(t, 8, lines[0].FullLength)
+
+	// a rollback forgets the matches since the mark
+	for _, reverse := range []bool{false, true} {
+		window = newPageWindow(1, 3, reverse, 10, 0, 0, nil)
+		add(window, 2)
+		mark := window.mark()
+		add(window, 5)
+		window.rollback(mark)
+		assert.Equal(t, 2, window.matches)
+		assert.Equal(t, []string{"line 1", "line 2"}, windowContents(window.page()), "reverse %t", reverse)
+	}
+
+	cut, truncated := cutToBudget([]LineResult{{Content: "a"}, {Content: "b"}, {Content: "c"}}, 2, 0)
+	assert.Equal(t, []string{"b", "c"}, windowContents(cut))
+	assert.True(t, truncated)
+	cut, truncated = cutToBudget([]LineResult{{Content: "a"}, {Content: "b"}}, 2, lineOverheadBytes+1)
+	assert.Equal(t, []string{"b"}, windowContents(cut))
+	assert.True(t, truncated)
+	_, truncated = cutToBudget([]LineResult{{Content: "a"}}, 2, 0)
+	assert.False(t, truncated)
+}
+
+func TestWriteAPIResponse(t *testing.T) {
+	response := APIResponse{
+		Result: ScanResult{
+			FilePath:     "app.log",
+			MatchPattern: `"lines":null <b>`,
+			Total:        2,
+			Lines:        []LineResult{{LineNumber: 1, Content: "a <b>"}, {LineNumber: 2, Content: `"lines":null`, Lines: 2}},
+			Sources:      []LineSource{{FilePath: "app.log"}},
+			Truncated:    true,
+		},
+		FilePaths: []FileInfo{{FilePath: "app.log", Type: TypeFile}},
+	}
+	for _, response := range []APIResponse{response, {}} {
+		e := echo.New()
+		rec := httptest.NewRecorder()
+		assert.NoError(t, writeAPIResponse(e.NewContext(httptest.NewRequest(http.MethodGet, "/api", nil), rec), response))
+		expected := httptest.NewRecorder()
+		assert.NoError(t, e.NewContext(httptest.NewRequest(http.MethodGet, "/api", nil), expected).JSON(http.StatusOK, response))
+		assert.Equal(t, expected.Body.String(), rec.Body.String())
+		assert.Equal(t, expected.Header(), rec.Header())
+	}
+}
+
+// TestAPIHandler_GetHugePage asks a page of a million lines of a file of GOL_HUGE_FILE_SIZE bytes (default
+// 64MiB), every line matching, e.g. GOL_HUGE_FILE_SIZE=2147483648 go test ./pkg -run GetHugePage
+func TestAPIHandler_GetHugePage(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes a large file")
+	}
+	size := int64(64 << 20)
+	if env := os.Getenv("GOL_HUGE_FILE_SIZE"); env != "" {
+		parsed, err := strconv.ParseInt(env, 10, 64)
+		assert.NoError(t, err)
+		size = parsed
+	}
+	logFile := filepath.Join(t.TempDir(), "huge.log")
+	file, err := os.Create(logFile)
+	assert.NoError(t, err)
+	block := bytes.Repeat([]byte("2024-06-01T12:00:00 INFO request_id=1234 path=/api/v1/items took 12ms\n"), 1<<14)
+	writer := bufio.NewWriterSize(file, 1<<20)
+	for written := int64(0); written < size; written += int64(len(block)) {
+		_, err := writer.Write(block)
+		assert.NoError(t, err)
+	}
+	assert.NoError(t, writer.Flush())
+	assert.NoError(t, file.Close())
+
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: logFile, Type: TypeFile}})
+	defer func(maxResponseBytes int64) { GlobalMaxResponseBytes = maxResponseBytes }(GlobalMaxResponseBytes)
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+
+	// the peak of the heap while the request is served, sampled
+	peak := func(serve func()) int64 {
+		var before runtime.MemStats
+		runtime.GC()
+		runtime.ReadMemStats(&before)
+		var top atomic.Int64
+		done := make(chan struct{})
+		sampled := make(chan struct{})
+		go func() {
+			defer close(sampled)
+			ticker := time.NewTicker(time.Millisecond)
+			defer ticker.Stop()
+			var stats runtime.MemStats
+			for {
+				runtime.ReadMemStats(&stats)
+				top.Store(max(top.Load(), int64(stats.HeapInuse)))
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+				}
+			}
+		}()
+		serve()
+		close(done)
+		<-sampled
+		return top.Load() - int64(before.HeapInuse)
+	}
+	get := func(query string) APIResponse {
+		response := APIResponse{}
+		growth := peak(func() {
+			rec := httptest.NewRecorder()
+			e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api?type=file&file_path="+url.QueryEscape(logFile)+query, nil))
+			assert.Equal(t, http.StatusOK, rec.Code)
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+		})
+		// the lines of the page, the response and its decoding, whatever the size of the file
+		assert.Less(t, growth, int64(64<<20), query)
+		return response
+	}
+
+	response := get("&per_page=1000000")
+	assert.True(t, response.Result.Truncated)
+	assert.Len(t, response.Result.Lines, GlobalMaxPerPage)
+	assert.Equal(t, GlobalMaxPerPage, response.Result.Lines[len(response.Result.Lines)-1].LineNumber)
+	assert.Greater(t, response.Result.Total, GlobalMaxPerPage)
+	assert.NotEmpty(t, response.Result.NextCursor)
+
+	// the next lines are read from the cursor
+	next := get("&per_page=2&cursor=" + response.Result.NextCursor)
+	assert.Equal(t, GlobalMaxPerPage+1, next.Result.Lines[0].LineNumber)
+
+	response = get("&per_page=1000000&reverse=true")
+	assert.True(t, response.Result.Truncated)
+	assert.Len(t, response.Result.Lines, GlobalMaxPerPage)
+
+	GlobalMaxResponseBytes = 1 << 20
+	response = get("&per_page=1000000&query=INFO")
+	assert.True(t, response.Result.Truncated)
+	assert.Less(t, len(response.Result.Lines), GlobalMaxPerPage)
+	assert.True(t, strings.HasPrefix(response.Result.Lines[0].Content, "2024-06-01T12:00:00 INFO"))
+	assert.NotEmpty(t, response.Result.NextCursor)
+}
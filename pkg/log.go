@@ -11,7 +11,7 @@ import (
 	"github.com/mattn/go-isatty"
 )
 
-func SetupLoggingStdout(logLevel slog.Leveler) {
+func SetupLoggingStdout(logLevel slog.Leveler, internalLogLines int) {
 	w := os.Stderr
 	handler := tint.NewHandler(w, &tint.Options{
 		NoColor:   !isatty.IsTerminal(w.Fd()),
@@ -30,5 +30,14 @@ func SetupLoggingStdout(logLevel slog.Leveler) {
 		},
 	})
 
-	slog.SetDefault(slog.New(handler))
+	if internalLogLines <= 0 {
+		GlobalLogBuffer = nil
+		slog.SetDefault(slog.New(handler))
+		return
+	}
+	// gol's own log is also kept in memory, served as the internal source
+	GlobalLogBuffer = NewLogBuffer(internalLogLines)
+	GlobalLogBuffer.AccountTo(GlobalMemory)
+	internal := slog.NewTextHandler(GlobalLogBuffer, &slog.HandlerOptions{Level: logLevel})
+	slog.SetDefault(slog.New(teeHandler{handler, internal}))
 }
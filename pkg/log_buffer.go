@@ -0,0 +1,317 @@
+package pkg
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"math"
+	"os"
+	"strings"
+	"sync"
+)
+
+const (
+	// DefaultInternalLogLines is the number of gol's own log lines kept in memory
+	DefaultInternalLogLines = 5000
+	// InternalLogPath is the file path of the internal source, gol's own log
+	InternalLogPath = "gol://internal"
+	InternalLogName = "gol (internal)"
+
+	// internalLogSubscriberBuffer is the number of lines a slow subscriber may lag behind before losing lines
+	internalLogSubscriberBuffer = 256
+	// sourceStatusLogLines is the number of internal log lines attached to a failing source status
+	sourceStatusLogLines = 20
+)
+
+// LogBuffer keeps the last lines of gol's own log, written by the slog handler of SetupLoggingStdout.
+// Once accounted, its oldest lines are spilled to a temp file when the buffers need memory back,
+// and read back transparently.
+type LogBuffer struct {
+	mutex sync.RWMutex
+	// lines are the lines kept in memory, oldest first, newer than the spilled ones
+	lines       []string
+	size        int
+	subscribers map[chan string]struct{}
+	accountant  *MemoryAccountant
+	spill       *os.File
+	// spilledLines are written to spill, the first spillSkip of them were evicted since
+	spilledLines int
+	spillSkip    int
+}
+
+func NewLogBuffer(size int) *LogBuffer {
+	return &LogBuffer{
+		lines:       make([]string, 0, size),
+		size:        size,
+		subscribers: map[chan string]struct{}{},
+	}
+}
+
+// AccountTo counts the memory of the buffer against the ceiling of accountant
+func (b *LogBuffer) AccountTo(accountant *MemoryAccountant) {
+	b.mutex.Lock()
+	b.accountant = accountant
+	var held int64
+	for _, line := range b.lines {
+		held += int64(len(line))
+	}
+	b.mutex.Unlock()
+	accountant.Register(b)
+	accountant.Account(b, held)
+}
+
+// Write appends every line of p, the oldest lines are dropped once the buffer is full.
+// Subscribers too slow to keep up lose lines rather than block the logger.
+func (b *LogBuffer) Write(p []byte) (int, error) {
+	var delta int64
+	b.mutex.Lock()
+	for _, line := range strings.Split(strings.TrimRight(string(p), "\n"), "\n") {
+		if b.len() >= b.size {
+			delta -= b.evictOldest()
+		}
+		b.lines = append(b.lines, line)
+		delta += int64(len(line))
+		for subscriber := range b.subscribers {
+			select {
+			case subscriber <- line:
+			default:
+			}
+		}
+	}
+	accountant := b.accountant
+	b.mutex.Unlock()
+	if accountant != nil && delta != 0 {
+		accountant.Account(b, delta)
+	}
+	return len(p), nil
+}
+
+func (b *LogBuffer) len() int {
+	return b.spilledLines - b.spillSkip + len(b.lines)
+}
+
+// evictOldest drops the oldest line and returns the memory it held
+func (b *LogBuffer) evictOldest() int64 {
+	if b.spilledLines > b.spillSkip {
+		b.spillSkip++
+		if b.spillSkip == b.spilledLines {
+			b.resetSpill()
+		}
+		return 0
+	}
+	if len(b.lines) == 0 {
+		return 0
+	}
+	held := int64(len(b.lines[0]))
+	b.lines[0] = ""
+	b.lines = b.lines[1:]
+	return held
+}
+
+func (b *LogBuffer) BufferName() string {
+	return InternalLogName
+}
+
+// Release spills the oldest lines holding at least n bytes to a temp file in the data dir.
+// They are dropped when the file cannot be written.
+func (b *LogBuffer) Release(n int64) (int64, int64) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	count := 0
+	var released int64
+	for count < len(b.lines) && released < n {
+		released += int64(len(b.lines[count]))
+		count++
+	}
+	if count == 0 {
+		return 0, 0
+	}
+	err := b.spillLines(b.lines[:count])
+	clear(b.lines[:count])
+	b.lines = b.lines[count:]
+	if err != nil {
+		// the spilled lines are older than the dropped ones, keeping them would leave a gap
+		b.spillSkip = b.spilledLines
+		b.resetSpill()
+		return 0, released
+	}
+	return released, 0
+}
+
+func (b *LogBuffer) spillLines(lines []string) error {
+	if b.spill == nil {
+		dir := GlobalDataDir
+		if dir == "" {
+			dir = TmpDir()
+		}
+		if err := EnsureFreeDisk(dir, 0); err != nil {
+			return err
+		}
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+		spill, err := os.CreateTemp(dir, "gol-buffer-*.spill")
+		if err != nil {
+			return err
+		}
+		b.spill = spill
+	}
+	if _, err := b.spill.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		return err
+	}
+	b.spilledLines += len(lines)
+	return nil
+}
+
+// resetSpill empties the spill file once none of its lines are kept
+func (b *LogBuffer) resetSpill() {
+	b.spilledLines = 0
+	b.spillSkip = 0
+	if b.spill == nil {
+		return
+	}
+	if err := b.spill.Truncate(0); err != nil {
+		b.closeSpill()
+		return
+	}
+	if _, err := b.spill.Seek(0, io.SeekStart); err != nil {
+		b.closeSpill()
+	}
+}
+
+func (b *LogBuffer) closeSpill() {
+	b.spill.Close()
+	os.Remove(b.spill.Name())
+	b.spill = nil
+}
+
+// Close removes the spill file
+func (b *LogBuffer) Close() {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	b.spilledLines = 0
+	b.spillSkip = 0
+	if b.spill != nil {
+		b.closeSpill()
+	}
+}
+
+// Lines returns the buffered lines, oldest first
+func (b *LogBuffer) Lines() []string {
+	b.mutex.RLock()
+	defer b.mutex.RUnlock()
+	return b.ordered()
+}
+
+// ordered reads the spilled lines back before the lines in memory
+func (b *LogBuffer) ordered() []string {
+	lines := make([]string, 0, b.len())
+	if b.spilledLines > b.spillSkip {
+		scanner := newLineScanner(io.NewSectionReader(b.spill, 0, math.MaxInt64))
+		for i := 0; i < b.spilledLines && scanner.Scan(); i++ {
+			if i >= b.spillSkip {
+				lines = append(lines, scanner.Text())
+			}
+		}
+	}
+	return append(lines, b.lines...)
+}
+
+// Bytes returns the buffered lines as the content of a file
+func (b *LogBuffer) Bytes() []byte {
+	lines := b.Lines()
+	if len(lines) == 0 {
+		return nil
+	}
+	return []byte(strings.Join(lines, "\n") + "\n")
+}
+
+// Subscribe returns the buffered lines and a channel receiving the lines written from then on,
+// until the returned func is called
+func (b *LogBuffer) Subscribe() ([]string, <-chan string, func()) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	subscriber := make(chan string, internalLogSubscriberBuffer)
+	b.subscribers[subscriber] = struct{}{}
+	unsubscribe := func() {
+		b.mutex.Lock()
+		defer b.mutex.Unlock()
+		delete(b.subscribers, subscriber)
+	}
+	return b.ordered(), subscriber, unsubscribe
+}
+
+// Matching returns the last n lines containing any of substrs, oldest first
+func (b *LogBuffer) Matching(n int, substrs ...string) []string {
+	lines := b.Lines()
+	matching := []string{}
+	for i := len(lines) - 1; i >= 0 && len(matching) < n; i-- {
+		for _, substr := range substrs {
+			if substr != "" && strings.Contains(lines[i], substr) {
+				matching = append(matching, lines[i])
+				break
+			}
+		}
+	}
+	for i, j := 0, len(matching)-1; i < j; i, j = i+1, j-1 {
+		matching[i], matching[j] = matching[j], matching[i]
+	}
+	return matching
+}
+
+// FileInfo lists the buffer as the internal source
+func (b *LogBuffer) FileInfo() FileInfo {
+	lines := b.Lines()
+	var size int64
+	for _, line := range lines {
+		size += int64(len(line)) + 1
+	}
+	return FileInfo{
+		FilePath:   InternalLogPath,
+		LinesCount: len(lines),
+		FileSize:   size,
+		Name:       InternalLogName,
+		Type:       TypeInternal,
+	}
+}
+
+// teeHandler sends every record to all of its handlers
+type teeHandler []slog.Handler
+
+func (t teeHandler) Enabled(ctx context.Context, level slog.Level) bool {
+	for _, handler := range t {
+		if handler.Enabled(ctx, level) {
+			return true
+		}
+	}
+	return false
+}
+
+func (t teeHandler) Handle(ctx context.Context, record slog.Record) error {
+	var err error
+	for _, handler := range t {
+		if !handler.Enabled(ctx, record.Level) {
+			continue
+		}
+		if handleErr := handler.Handle(ctx, record.Clone()); handleErr != nil {
+			err = handleErr
+		}
+	}
+	return err
+}
+
+func (t teeHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, handler := range t {
+		handlers[i] = handler.WithAttrs(attrs)
+	}
+	return handlers
+}
+
+func (t teeHandler) WithGroup(name string) slog.Handler {
+	handlers := make(teeHandler, len(t))
+	for i, handler := range t {
+		handlers[i] = handler.WithGroup(name)
+	}
+	return handlers
+}
@@ -0,0 +1,118 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func useLogBuffer(t *testing.T, size int) *LogBuffer {
+	previous := GlobalLogBuffer
+	GlobalLogBuffer = NewLogBuffer(size)
+	t.Cleanup(func() { GlobalLogBuffer = previous })
+	return GlobalLogBuffer
+}
+
+func TestLogBuffer(t *testing.T) {
+	buffer := NewLogBuffer(3)
+	assert.Nil(t, buffer.Bytes())
+
+	buffer.Write([]byte("one\n"))        //nolint: errcheck
+	buffer.Write([]byte("two\nthree\n")) //nolint: errcheck
+	buffered, lines, unsubscribe := buffer.Subscribe()
+	assert.Equal(t, []string{"one", "two", "three"}, buffered)
+
+	buffer.Write([]byte("four\n")) //nolint: errcheck
+	assert.Equal(t, []string{"two", "three", "four"}, buffer.Lines())
+	assert.Equal(t, "two\nthree\nfour\n", string(buffer.Bytes()))
+	assert.Equal(t, "four", <-lines)
+
+	unsubscribe()
+	buffer.Write([]byte("five\n")) //nolint: errcheck
+	assert.Empty(t, lines)
+
+	fileInfo := buffer.FileInfo()
+	assert.Equal(t, InternalLogPath, fileInfo.FilePath)
+	assert.Equal(t, TypeInternal, fileInfo.Type)
+	assert.Equal(t, 3, fileInfo.LinesCount)
+	assert.Equal(t, int64(len("three\nfour\nfive\n")), fileInfo.FileSize)
+}
+
+func TestLogBuffer_SlowSubscriber(t *testing.T) {
+	buffer := NewLogBuffer(10)
+	_, lines, unsubscribe := buffer.Subscribe()
+	defer unsubscribe()
+	for i := 0; i < internalLogSubscriberBuffer+10; i++ {
+		buffer.Write([]byte("line\n")) //nolint: errcheck
+	}
+	assert.Len(t, lines, internalLogSubscriberBuffer)
+}
+
+func TestLogBuffer_Matching(t *testing.T) {
+	buffer := NewLogBuffer(10)
+	for _, line := range []string{"a host1", "b", "c host2", "d host1", "e path"} {
+		buffer.Write([]byte(line + "\n")) //nolint: errcheck
+	}
+	assert.Equal(t, []string{"c host2", "d host1"}, buffer.Matching(2, "host1", "host2"))
+	assert.Equal(t, []string{"e path"}, buffer.Matching(5, "path", ""))
+	assert.Empty(t, buffer.Matching(5, ""))
+}
+
+func TestTeeHandler(t *testing.T) {
+	info := NewLogBuffer(10)
+	errorsOnly := NewLogBuffer(10)
+	logger := slog.New(teeHandler{
+		slog.NewTextHandler(info, &slog.HandlerOptions{Level: slog.LevelInfo}),
+		slog.NewTextHandler(errorsOnly, &slog.HandlerOptions{Level: slog.LevelError}),
+	}).With("source", "app.log")
+
+	logger.Debug("not logged")
+	logger.Info("listed")
+	logger.Error("listing", "error", errors.New("denied"))
+	assert.Len(t, info.Lines(), 2)
+	assert.Len(t, errorsOnly.Lines(), 1)
+	assert.Contains(t, errorsOnly.Lines()[0], "source=app.log")
+	assert.Contains(t, errorsOnly.Lines()[0], "error=denied")
+}
+
+func TestAPIHandler_InternalSource(t *testing.T) {
+	buffer := useLogBuffer(t, 100)
+	buffer.Write([]byte("time=2024-06-01T12:00:00Z level=INFO msg=started\n"))                                       //nolint: errcheck
+	buffer.Write([]byte("time=2024-06-01T12:00:01Z level=ERROR msg=\"listing SSH files\" host=box1 error=denied\n")) //nolint: errcheck
+	GlobalFileRegistry.Replace([]FileInfo{buffer.FileInfo()})
+	GlobalSourceStatuses.Set([]SourceStatus{
+		{Source: "/var/log/*.log", Type: TypeSSH, Host: "box1", Error: "denied"},
+		{Source: "/var/log/app.log", Type: TypeFile, Files: 1},
+	})
+	t.Cleanup(func() { GlobalSourceStatuses.Set([]SourceStatus{}) })
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api?type=internal&file_path="+InternalLogPath+"&query=ERROR", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var response APIResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, 1, response.Result.Total)
+	assert.Equal(t, 2, response.Result.Lines[0].LineNumber)
+	assert.Equal(t, ClassError, response.Result.Lines[0].Class)
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/sources", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var sources SourcesResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &sources))
+	assert.Len(t, sources.Sources, 2)
+	for _, status := range sources.Sources {
+		if status.Error == "" {
+			assert.Empty(t, status.Logs)
+			continue
+		}
+		assert.Len(t, status.Logs, 1)
+		assert.Contains(t, status.Logs[0], "host=box1")
+	}
+}
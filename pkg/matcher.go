@@ -0,0 +1,18 @@
+package pkg
+
+import "github.com/kevincobain2000/gol/pkg/search"
+
+// lineMatcher matches the raw bytes of a line against a search or ignore pattern
+type lineMatcher = search.Matcher
+
+func newLineMatcher(pattern string) (lineMatcher, error) {
+	return search.NewMatcher(pattern)
+}
+
+func hasANSI(line []byte) bool {
+	return search.HasANSI(line)
+}
+
+func stripANSI(dst []byte, line []byte) []byte {
+	return search.StripANSI(dst, line)
+}
@@ -0,0 +1,159 @@
+package pkg
+
+import (
+	"sort"
+	"sync"
+)
+
+// DefaultMaxBufferMemory is the memory the in-memory buffers may hold together
+const DefaultMaxBufferMemory int64 = 256 << 20
+
+// memoryReclaimTarget is the fraction of the ceiling the buffers are brought back to once they exceed it,
+// so a buffer growing line by line does not spill on every line
+const memoryReclaimTarget = 0.9
+
+// AccountedBuffer is an in-memory buffer whose size counts against the memory ceiling
+type AccountedBuffer interface {
+	BufferName() string
+	// Release moves at least n bytes of its oldest data out of memory, to a spill file when it can.
+	// It returns the number of bytes spilled and dropped.
+	Release(n int64) (spilled int64, dropped int64)
+}
+
+// BufferMemoryUsage is the memory held by one buffer
+type BufferMemoryUsage struct {
+	Name  string `json:"name"`
+	Bytes int64  `json:"bytes"`
+}
+
+// MemoryUsage is the accounted memory of the buffers, served with the metrics and the version
+type MemoryUsage struct {
+	Ceiling int64 `json:"ceiling"`
+	Used    int64 `json:"used"`
+	// Spilled and Dropped are the bytes moved out of memory since the start to stay under the ceiling
+	Spilled int64               `json:"spilled"`
+	Dropped int64               `json:"dropped"`
+	Buffers []BufferMemoryUsage `json:"buffers"`
+}
+
+// MemoryAccountant keeps the in-memory buffers under a ceiling. Buffers report their growth with
+// Account, once the total exceeds the ceiling the oldest data of the largest buffers is released.
+type MemoryAccountant struct {
+	mutex   sync.Mutex
+	ceiling int64
+	used    int64
+	buffers map[AccountedBuffer]int64
+	spilled int64
+	dropped int64
+	// reclaiming lets one writer release memory while the others keep writing
+	reclaiming sync.Mutex
+}
+
+// NewMemoryAccountant returns an accountant keeping the buffers under ceiling bytes, 0 disables the ceiling
+func NewMemoryAccountant(ceiling int64) *MemoryAccountant {
+	return &MemoryAccountant{
+		ceiling: ceiling,
+		buffers: map[AccountedBuffer]int64{},
+	}
+}
+
+// SetCeiling changes the ceiling, the buffers are brought under it on their next growth
+func (a *MemoryAccountant) SetCeiling(ceiling int64) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.ceiling = ceiling
+}
+
+func (a *MemoryAccountant) Register(b AccountedBuffer) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if _, ok := a.buffers[b]; !ok {
+		a.buffers[b] = 0
+	}
+}
+
+// Unregister stops accounting b, its memory is no longer counted
+func (a *MemoryAccountant) Unregister(b AccountedBuffer) {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.used -= a.buffers[b]
+	delete(a.buffers, b)
+}
+
+// Account adds delta bytes to the memory held by b and releases memory when the ceiling is exceeded.
+// Buffers must call it without holding their own lock, Release may be called on them.
+func (a *MemoryAccountant) Account(b AccountedBuffer, delta int64) {
+	a.mutex.Lock()
+	if _, ok := a.buffers[b]; !ok {
+		a.mutex.Unlock()
+		return
+	}
+	a.buffers[b] += delta
+	a.used += delta
+	over := a.ceiling > 0 && a.used > a.ceiling
+	a.mutex.Unlock()
+	if !over || !a.reclaiming.TryLock() {
+		return
+	}
+	defer a.reclaiming.Unlock()
+	a.reclaim()
+}
+
+// reclaim releases the oldest data of the largest buffers until the total is back under the target
+func (a *MemoryAccountant) reclaim() {
+	for {
+		a.mutex.Lock()
+		ceiling := a.ceiling
+		excess := a.used - int64(float64(ceiling)*memoryReclaimTarget)
+		largest, held := a.largest()
+		a.mutex.Unlock()
+		if ceiling <= 0 || excess <= 0 || largest == nil {
+			return
+		}
+
+		spilled, dropped := largest.Release(min(excess, held))
+		released := spilled + dropped
+		a.mutex.Lock()
+		if _, ok := a.buffers[largest]; ok {
+			a.buffers[largest] -= released
+			a.used -= released
+		}
+		a.spilled += spilled
+		a.dropped += dropped
+		a.mutex.Unlock()
+		if released <= 0 {
+			return
+		}
+	}
+}
+
+func (a *MemoryAccountant) largest() (AccountedBuffer, int64) {
+	var largest AccountedBuffer
+	var held int64
+	for b, bytes := range a.buffers {
+		if bytes > held {
+			largest, held = b, bytes
+		}
+	}
+	return largest, held
+}
+
+// Usage returns the accounted memory, buffers sorted by name
+func (a *MemoryAccountant) Usage() MemoryUsage {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	usage := MemoryUsage{
+		Ceiling: a.ceiling,
+		Used:    a.used,
+		Spilled: a.spilled,
+		Dropped: a.dropped,
+		Buffers: make([]BufferMemoryUsage, 0, len(a.buffers)),
+	}
+	for b, bytes := range a.buffers {
+		usage.Buffers = append(usage.Buffers, BufferMemoryUsage{Name: b.BufferName(), Bytes: bytes})
+	}
+	sort.Slice(usage.Buffers, func(i, j int) bool {
+		return usage.Buffers[i].Name < usage.Buffers[j].Name
+	})
+	return usage
+}
@@ -0,0 +1,158 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func useDataDir(t *testing.T) string {
+	previous := GlobalDataDir
+	t.Cleanup(func() { GlobalDataDir = previous })
+	GlobalDataDir = t.TempDir()
+	return GlobalDataDir
+}
+
+type fakeBuffer struct {
+	name string
+	held int64
+}
+
+func (b *fakeBuffer) BufferName() string {
+	return b.name
+}
+
+func (b *fakeBuffer) Release(n int64) (int64, int64) {
+	released := min(n, b.held)
+	b.held -= released
+	return 0, released
+}
+
+func TestMemoryAccountant(t *testing.T) {
+	accountant := NewMemoryAccountant(1000)
+	small := &fakeBuffer{name: "small"}
+	large := &fakeBuffer{name: "large"}
+	accountant.Register(small)
+	accountant.Register(large)
+
+	small.held = 200
+	accountant.Account(small, 200)
+	large.held = 700
+	accountant.Account(large, 700)
+	assert.Equal(t, int64(900), accountant.Usage().Used)
+
+	// past the ceiling the largest buffer is brought back under 90% of it
+	large.held += 300
+	accountant.Account(large, 300)
+	usage := accountant.Usage()
+	assert.Equal(t, int64(900), usage.Used)
+	assert.Equal(t, int64(300), usage.Dropped)
+	assert.Equal(t, []BufferMemoryUsage{{Name: "large", Bytes: 700}, {Name: "small", Bytes: 200}}, usage.Buffers)
+
+	accountant.Unregister(large)
+	assert.Equal(t, int64(200), accountant.Usage().Used)
+
+	// without a ceiling nothing is released
+	accountant.SetCeiling(0)
+	small.held += 5000
+	accountant.Account(small, 5000)
+	assert.Equal(t, int64(5200), accountant.Usage().Used)
+	assert.Equal(t, int64(300), accountant.Usage().Dropped)
+}
+
+func TestLogBuffer_Spill(t *testing.T) {
+	dataDir := useDataDir(t)
+	accountant := NewMemoryAccountant(100)
+	buffer := NewLogBuffer(20)
+	buffer.AccountTo(accountant)
+	defer buffer.Close()
+
+	want := []string{}
+	for i := 0; i < 30; i++ {
+		line := fmt.Sprintf("line %02d", i)
+		buffer.Write([]byte(line + "\n")) //nolint: errcheck
+		want = append(want, line)
+	}
+	want = want[10:]
+	usage := accountant.Usage()
+	assert.LessOrEqual(t, usage.Used, int64(100))
+	assert.Positive(t, usage.Spilled)
+	assert.Zero(t, usage.Dropped)
+	assert.Equal(t, want, buffer.Lines())
+	buffered, _, unsubscribe := buffer.Subscribe()
+	unsubscribe()
+	assert.Equal(t, want, buffered)
+
+	spills, err := filepath.Glob(filepath.Join(dataDir, "gol-buffer-*.spill"))
+	assert.NoError(t, err)
+	assert.Len(t, spills, 1)
+	buffer.Close()
+	_, err = os.Stat(spills[0])
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestLogBuffer_SpillUnwritable(t *testing.T) {
+	useMinFreeDisk(t, 1<<62)
+	useDataDir(t)
+	accountant := NewMemoryAccountant(100)
+	buffer := NewLogBuffer(20)
+	buffer.AccountTo(accountant)
+	defer buffer.Close()
+
+	for i := 0; i < 30; i++ {
+		buffer.Write([]byte(fmt.Sprintf("line %02d\n", i))) //nolint: errcheck
+	}
+	usage := accountant.Usage()
+	assert.LessOrEqual(t, usage.Used, int64(100))
+	assert.Zero(t, usage.Spilled)
+	assert.Positive(t, usage.Dropped)
+	lines := buffer.Lines()
+	assert.NotEmpty(t, lines)
+	assert.Equal(t, "line 29", lines[len(lines)-1])
+}
+
+func TestLogBuffer_SpillStress(t *testing.T) {
+	if testing.Short() {
+		t.Skip("writes 64MiB")
+	}
+	useDataDir(t)
+	const ceiling = 4 << 20
+	accountant := NewMemoryAccountant(ceiling)
+	buffer := NewLogBuffer(1 << 20)
+	buffer.AccountTo(accountant)
+	defer buffer.Close()
+
+	var before runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+
+	// 16 times the ceiling, the lines not evicted by the buffer size must be read back in order
+	line := strings.Repeat("x", 1000)
+	const lines = 64 << 10
+	for i := 0; i < lines; i++ {
+		buffer.Write([]byte(fmt.Sprintf("%06d %s\n", i, line))) //nolint: errcheck
+		assert.LessOrEqual(t, accountant.Usage().Used, int64(ceiling))
+	}
+
+	var after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&after)
+	// the ceiling plus slack for the slice headers and the runtime, far below the 64MiB written
+	assert.Less(t, int64(after.HeapInuse)-int64(before.HeapInuse), int64(4*ceiling))
+
+	usage := accountant.Usage()
+	assert.Positive(t, usage.Spilled)
+	assert.Zero(t, usage.Dropped)
+	read := buffer.Lines()
+	assert.Len(t, read, lines)
+	for i, content := range read {
+		if !assert.True(t, strings.HasPrefix(content, fmt.Sprintf("%06d ", i)), "line %d", i) {
+			break
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package pkg
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+
+	"github.com/acarl005/stripansi"
+)
+
+// DefaultMaxMergeFiles is how many files a merged view reads at once, each keeping a file and a line open
+const DefaultMaxMergeFiles = 20
+
+// MergeCursor is the position of a merged view in each of its files, handed out as an opaque token
+type MergeCursor struct {
+	Files []Cursor `json:"f"`
+	// Times are the times of the last dated lines before the positions, the lines without a timestamp
+	// after them are merged at that time, in unix nanoseconds and 0 for none
+	Times []int64 `json:"t"`
+}
+
+func (c MergeCursor) String() string {
+	b, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// ParseMergeCursor parses the output of MergeCursor.String for a view of n files
+func ParseMergeCursor(s string, n int) (MergeCursor, error) {
+	var c MergeCursor
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || json.Unmarshal(b, &c) != nil || len(c.Files) != n || len(c.Times) != n {
+		return c, fmt.Errorf("invalid cursor %q", s)
+	}
+	for _, cursor := range c.Files {
+		if cursor.Offset < 0 || cursor.LineNumber < 0 {
+			return c, fmt.Errorf("invalid cursor %q", s)
+		}
+	}
+	return c, nil
+}
+
+// mergeHead is the next line of a merged source, at the time it is merged at
+type mergeHead struct {
+	source int
+	time   time.Time
+}
+
+// mergeHeads is the heap of a k-way merge, the earliest line first and the lines of equal times in the
+// order of their sources
+type mergeHeads []mergeHead
+
+func (h mergeHeads) Len() int { return len(h) }
+func (h mergeHeads) Less(i, j int) bool {
+	if !h[i].time.Equal(h[j].time) {
+		return h[i].time.Before(h[j].time)
+	}
+	return h[i].source < h[j].source
+}
+func (h mergeHeads) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *mergeHeads) Push(x interface{}) { *h = append(*h, x.(mergeHead)) }
+func (h *mergeHeads) Pop() interface{} {
+	old := *h
+	head := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return head
+}
+
+// mergeTime is the time a line is merged at: its timestamp, or else the time of the dated line before it
+// in its file, so that it stays after that line. Lines before any dated line are merged first.
+func mergeTime(line []byte, loc *time.Location, last time.Time) time.Time {
+	if ts, ok := LineTime(line, loc); ok {
+		return ts
+	}
+	return last
+}
+
+// mergeReader reads the matching lines of a file of a merged view, one ahead of those taken
+type mergeReader struct {
+	watcher    *Watcher
+	file       File
+	scanner    *bufio.Scanner
+	offset     int64
+	lineNumber int
+	prevHash   uint32
+	lastTime   time.Time
+	// head is the next matching line, before is the position before it, the end of the file without one
+	head   *LineResult
+	time   time.Time
+	before MergeCursor
+	// taken is the index of the last line taken, whose next hash is set as the following line is read
+	taken int
+}
+
+// next reads the next matching line of the reader into head
+func (r *mergeReader) next(ctx context.Context, match lineMatcher, ignore lineMatcher, loc *time.Location, lines []LineResult) error {
+	r.head = nil
+	r.before = MergeCursor{Files: []Cursor{{Offset: r.offset, LineNumber: r.lineNumber, Hash: r.prevHash}}, Times: []int64{unixNano(r.lastTime)}}
+	for scanned := 0; r.scanner.Scan(); scanned++ {
+		if scanned%collectCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+		}
+		line := r.scanner.Bytes()
+		content := ""
+		if hasANSI(line) {
+			content = stripansi.Strip(string(line))
+			line = []byte(content)
+		}
+		r.lineNumber++
+		hash := lineHash(line)
+		if r.taken >= 0 && lines[r.taken].LineNumber == r.lineNumber-1 {
+			lines[r.taken].nextHash = hash
+		}
+		lineTime := mergeTime(line, loc, r.lastTime)
+		if (ignore == nil || !ignore.Match(line)) && match.Match(line) {
+			if content == "" {
+				content = string(line)
+			}
+			r.head = &LineResult{LineNumber: r.lineNumber, Content: content, prevHash: r.prevHash, hash: hash}
+			r.time = lineTime
+			r.prevHash, r.lastTime = hash, lineTime
+			return nil
+		}
+		r.prevHash, r.lastTime = hash, lineTime
+		r.before = MergeCursor{Files: []Cursor{{Offset: r.offset, LineNumber: r.lineNumber, Hash: r.prevHash}}, Times: []int64{unixNano(r.lastTime)}}
+	}
+	return r.scanner.Err()
+}
+
+func unixNano(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.UnixNano()
+}
+
+// MergeFiles returns the first pageSize lines after cursor, nil for the start of the files, of several
+// local files merged by their timestamps, with the cursor after them. It is a k-way merge of the matching
+// lines of each file, each line referring to its file in the sources. Times without an offset are in loc.
+// The page is truncated at GlobalMaxPerPage lines or GlobalMaxResponseBytes, the cursor is after its last line.
+func MergeFiles(ctx context.Context, filePaths []string, cursor *MergeCursor, matchPattern string, ignorePattern string, loc *time.Location, pageSize int) (*ScanResult, error) {
+	match, err := newLineMatcher(matchPattern)
+	if err != nil {
+		return nil, err
+	}
+	var ignore lineMatcher
+	if ignorePattern != "" {
+		if ignore, err = newLineMatcher(ignorePattern); err != nil {
+			return nil, err
+		}
+	}
+
+	readers := make([]*mergeReader, 0, len(filePaths))
+	defer func() {
+		for _, r := range readers {
+			r.file.Close()
+		}
+	}()
+	heads := &mergeHeads{}
+	sources := make([]LineSource, 0, len(filePaths))
+	for i, filePath := range filePaths {
+		watcher, err := NewWatcher(filePath, matchPattern, ignorePattern, false, "", "", "", "", "")
+		if err != nil {
+			return nil, err
+		}
+		var fileCursor *Cursor
+		r := &mergeReader{watcher: watcher, taken: -1}
+		if cursor != nil {
+			fileCursor = &cursor.Files[i]
+			r.offset, r.lineNumber, r.prevHash = fileCursor.Offset, fileCursor.LineNumber, fileCursor.Hash
+			if cursor.Times[i] != 0 {
+				r.lastTime = time.Unix(0, cursor.Times[i]).In(loc)
+			}
+		}
+		if r.file, err = watcher.openCursor(fileCursor); err != nil {
+			return nil, fmt.Errorf("%s: %w", filePath, err)
+		}
+		readers = append(readers, r)
+		r.scanner = newLineScanner(r.file)
+		r.scanner.Split(completeLines(&r.offset))
+		if err := r.next(ctx, match, ignore, loc, nil); err != nil {
+			return nil, err
+		}
+		if r.head != nil {
+			heap.Push(heads, mergeHead{source: i, time: r.time})
+		}
+		sources = append(sources, LineSource{FilePath: filePath})
+	}
+
+	re := regexp.MustCompile(matchPattern)
+	window := newPageWindow(1, pageSize, false, GlobalMaxPerPage, GlobalMaxResponseBytes, GlobalMaxLineLength, re)
+	for !window.full() && heads.Len() > 0 {
+		head := heap.Pop(heads).(mergeHead)
+		r := readers[head.source]
+		window.source = head.source
+		// a line past the budget stays the head of its reader, the cursor is before it
+		if !window.add(*r.head) {
+			break
+		}
+		r.taken = len(window.lines) - 1
+		if err := r.next(ctx, match, ignore, loc, window.lines); err != nil {
+			return nil, err
+		}
+		if r.head != nil {
+			heap.Push(heads, mergeHead{source: head.source, time: r.time})
+		}
+	}
+	lines := window.page()
+
+	next := MergeCursor{Files: make([]Cursor, 0, len(readers)), Times: make([]int64, 0, len(readers))}
+	for _, r := range readers {
+		position := r.before.Files[0]
+		fileCursor, err := r.watcher.newCursor(r.file, position.Offset, position.LineNumber, position.Hash)
+		if err != nil {
+			return nil, err
+		}
+		next.Files = append(next.Files, fileCursor)
+		next.Times = append(next.Times, r.before.Times[0])
+	}
+
+	readers[0].watcher.finalizeLines(lines, sources)
+	result := &ScanResult{
+		Type:         TypeFile,
+		MatchPattern: matchPattern,
+		Total:        len(lines),
+		Lines:        lines,
+		Sources:      sources,
+		NextCursor:   next.String(),
+		Truncated:    window.truncated,
+	}
+	result.SetSourceType(TypeFile, "")
+	return result, nil
+}
+
+// MergeTailEvents merges the lines of the snapshots of several files by their timestamps, as MergeFiles
+// does, keeping the last n. Each line is set the index of its snapshot as its source.
+func MergeTailEvents(snapshots []StreamMessage, loc *time.Location, n int) []TailEvent {
+	times := make([][]time.Time, len(snapshots))
+	heads := &mergeHeads{}
+	for i, snapshot := range snapshots {
+		var last time.Time
+		for _, event := range snapshot.Lines {
+			last = mergeTime([]byte(event.Content), loc, last)
+			times[i] = append(times[i], last)
+		}
+		if len(snapshot.Lines) > 0 {
+			heap.Push(heads, mergeHead{source: i, time: times[i][0]})
+		}
+	}
+	merged := []TailEvent{}
+	taken := make([]int, len(snapshots))
+	for heads.Len() > 0 {
+		head := heap.Pop(heads).(mergeHead)
+		event := snapshots[head.source].Lines[taken[head.source]]
+		event.Source = head.source
+		merged = append(merged, event)
+		if taken[head.source]++; taken[head.source] < len(times[head.source]) {
+			heap.Push(heads, mergeHead{source: head.source, time: times[head.source][taken[head.source]]})
+		}
+	}
+	return merged[max(len(merged)-n, 0):]
+}
@@ -0,0 +1,298 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/mcuadros/go-defaults"
+)
+
+// MergeRequest reads several local files as one, their lines merged by timestamp
+type MergeRequest struct {
+	// IDs are the files merged, repeated like ids=a&ids=b, at most GlobalMaxMergeFiles of them
+	IDs     []string `json:"ids" query:"ids"`
+	Query   string   `json:"query" query:"query"`
+	Ignore  string   `json:"ignore" query:"ignore"`
+	PerPage int      `json:"per_page" query:"per_page" default:"15" validate:"required" message:"per_page is required"`
+	// Cursor is the next_cursor of a previous page of the same files, the page after it is returned
+	Cursor string `json:"cursor" query:"cursor"`
+	// Timezone (IANA) is of the times without an offset, the server's when missing
+	Timezone string `json:"tz" query:"tz"`
+}
+
+// GetMerge returns a page of the lines of several local files merged by their timestamps, each line
+// referring to its file in the sources of the result. A line without a timestamp stays after the line
+// before it in its file. Pages after the first are read from the cursor, which holds a position per file.
+func (h *APIHandler) GetMerge(c echo.Context) error {
+	req := new(MergeRequest)
+	if err := BindRequest(c, req); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err)
+	}
+	defaults.SetDefaults(req)
+	msgs, err := ValidateRequest(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, msgs)
+	}
+	fileInfos, err := mergedFileInfos(req.IDs)
+	if err != nil {
+		return err
+	}
+	var cursor *MergeCursor
+	if req.Cursor != "" {
+		parsed, err := ParseMergeCursor(req.Cursor, len(fileInfos))
+		if err != nil {
+			return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+		}
+		cursor = &parsed
+	}
+	// a sample of the lines of each file would not merge into a page
+	if _, err := GlobalPatternLimits.Check(req.Query, req.Ignore); err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	loc, err := timeLocation(req.Timezone, nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+
+	release, err := acquireRead(c, TypeFile, "", fileInfos[0].FilePath)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	filePaths := make([]string, 0, len(fileInfos))
+	for _, fileInfo := range fileInfos {
+		filePaths = append(filePaths, fileInfo.FilePath)
+	}
+	result, err := MergeFiles(c.Request().Context(), filePaths, cursor, req.Query, req.Ignore, loc, req.PerPage)
+	if errors.Is(err, ErrCursorExpired) {
+		return echo.NewHTTPError(http.StatusConflict, ErrorCodeCursorExpired)
+	}
+	if errors.Is(err, ErrCursorUnsupported) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	for i, fileInfo := range fileInfos {
+		result.Sources[i].Type, result.Sources[i].Host = fileInfo.Type, fileInfo.Host
+		result.Sources[i].Label = fileLabel(fileInfo.FilePath, fileInfo.Type, fileInfo.Host)
+	}
+	return writeAPIResponse(c, APIResponse{
+		Result:    *result,
+		FilePaths: fileInfos,
+	})
+}
+
+// mergedFileInfos are the listed files of ids, in their order, refused unless there are 1 to
+// GlobalMaxMergeFiles distinct local files that may be read
+func mergedFileInfos(ids []string) ([]FileInfo, error) {
+	if len(ids) == 0 {
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "ids are required")
+	}
+	if len(ids) > GlobalMaxMergeFiles {
+		return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("at most %d files are merged", GlobalMaxMergeFiles))
+	}
+	fileInfos := make([]FileInfo, 0, len(ids))
+	for i, id := range ids {
+		if slices.Contains(ids[:i], id) {
+			return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("file %q is merged twice", id))
+		}
+		fileInfo, ok := FileInfoByID(id)
+		if !ok {
+			return nil, echo.NewHTTPError(http.StatusNotFound, "file not found")
+		}
+		if err := AuthorizeFilePath(fileInfo.FilePath, fileInfo.Type, fileInfo.Host); err != nil {
+			return nil, echo.NewHTTPError(http.StatusForbidden, err)
+		}
+		if !isLocalFile(fileInfo.Type, fileInfo.FilePath) {
+			return nil, echo.NewHTTPError(http.StatusUnprocessableEntity, "merging is only supported for local files")
+		}
+		fileInfos = append(fileInfos, fileInfo)
+	}
+	return fileInfos, nil
+}
+
+// getMergedStream follows several local files over one WebSocket. The snapshot is the last tail lines
+// of the files merged by their timestamps, with the files as its sources, then the lines appended to
+// any of them are sent as they are read, each with the index of its file. Resets and removals name
+// their file too, the stream ends once every file is removed.
+func (h *APIHandler) getMergedStream(c echo.Context, req *StreamRequest) error {
+	if req.ID != "" || req.FilePath != "" {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "ids do not combine with id or file_path")
+	}
+	if req.Tail < 0 {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "tail >=0 is required")
+	}
+	if req.Tail > GlobalMaxPerPage {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("tail must be at most %d", GlobalMaxPerPage))
+	}
+	levels, err := ParseLevels(req.Levels)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	fileInfos, err := mergedFileInfos(req.IDs)
+	if err != nil {
+		return err
+	}
+	loc, err := timeLocation("", nil)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	release, err := acquireTail(c)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// subscribed before the snapshots are read, the lines appended meanwhile are in both and skipped once
+	subscriptions := make([]<-chan TailEvent, 0, len(fileInfos))
+	unsubscribes := make([]func(), 0, len(fileInfos))
+	defer func() {
+		for _, unsubscribe := range unsubscribes {
+			unsubscribe()
+		}
+	}()
+	for _, fileInfo := range fileInfos {
+		events, unsubscribe, err := GlobalTailHub.Subscribe(fileInfo.FilePath)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		subscriptions = append(subscriptions, events)
+		unsubscribes = append(unsubscribes, unsubscribe)
+	}
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+	classifiers := make([]*Classifier, 0, len(fileInfos))
+	snapshots := make([]StreamMessage, 0, len(fileInfos))
+	generations := make([]int, 0, len(fileInfos))
+	seen := make([]int, 0, len(fileInfos))
+	sources := make([]LineSource, 0, len(fileInfos))
+	for _, fileInfo := range fileInfos {
+		classifier := ClassifierFor(fileInfo.FilePath)
+		snapshot, lines, err := streamSnapshot(ctx, fileInfo.FilePath, req.Tail, classifier, levels)
+		if err != nil {
+			return echo.NewHTTPError(http.StatusInternalServerError, err)
+		}
+		classifiers = append(classifiers, classifier)
+		snapshots = append(snapshots, snapshot)
+		generations = append(generations, snapshot.Generation)
+		seen = append(seen, lines)
+		sources = append(sources, LineSource{
+			FilePath: fileInfo.FilePath,
+			Host:     fileInfo.Host,
+			Type:     fileInfo.Type,
+			Label:    fileLabel(fileInfo.FilePath, fileInfo.Type, fileInfo.Host),
+		})
+	}
+	snapshot := StreamMessage{Type: StreamMessageSnapshot, Lines: MergeTailEvents(snapshots, loc, req.Tail), Sources: sources}
+
+	conn, err := streamUpgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		// the upgrader answered the request already
+		return nil
+	}
+	defer conn.Close()
+	// the client sends nothing, reading notices it going away
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	// the events of every file are sent on one channel, a file falling behind ends the stream
+	merged := make(chan TailEvent)
+	lagging := make(chan struct{}, len(subscriptions))
+	for i, events := range subscriptions {
+		go func() {
+			for event := range events {
+				event.Source = i
+				select {
+				case merged <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+			lagging <- struct{}{}
+		}()
+	}
+
+	send := func(message StreamMessage) error {
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout)) //nolint: errcheck
+		return conn.WriteJSON(message)
+	}
+	if err := send(snapshot); err != nil {
+		return nil
+	}
+	ping, stopPing := GlobalClock.Tick(streamPingInterval)
+	defer stopPing()
+	reloaded, unsubscribeReloads := GlobalSourceReloads.Subscribe()
+	defer unsubscribeReloads()
+	removed := make([]bool, len(fileInfos))
+	var seq int64
+	for {
+		select {
+		case <-reloaded:
+			for i, fileInfo := range fileInfos {
+				if removed[i] || AuthorizeFilePath(fileInfo.FilePath, fileInfo.Type, fileInfo.Host) == nil {
+					continue
+				}
+				removed[i] = true
+				if err := send(StreamMessage{Type: StreamMessageSourceRemoved, Generation: generations[i], Source: i}); err != nil {
+					return nil
+				}
+			}
+			if !slices.Contains(removed, false) {
+				closing := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "source removed")
+				conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(streamWriteTimeout)) //nolint: errcheck
+				return nil
+			}
+		case <-ctx.Done():
+			// the client went away or the server shuts down, a client still there reconnects
+			closing := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+			conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(streamWriteTimeout)) //nolint: errcheck
+			return nil
+		case <-ping:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return nil
+			}
+		case <-lagging:
+			closing := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "stream fell behind, reconnect")
+			conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(streamWriteTimeout)) //nolint: errcheck
+			return nil
+		case event := <-merged:
+			i := event.Source
+			if removed[i] {
+				continue
+			}
+			message := StreamMessage{Type: StreamMessageReset, Reason: event.Type, Generation: event.Generation, Target: event.Target, Source: i}
+			if event.Type == TailEventLine {
+				if event.Generation == generations[i] && event.LineNumber <= seen[i] {
+					// in the file when the snapshot was taken
+					continue
+				}
+				if !levelEvent(&event, classifiers[i], levels) {
+					continue
+				}
+				seq++
+				event.Seq = seq
+				message = StreamMessage{Type: StreamMessageLine, Line: &event, Generation: event.Generation}
+			} else {
+				generations[i], seen[i] = event.Generation, 0
+			}
+			if err := send(message); err != nil {
+				return nil
+			}
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func mergedContents(lines []LineResult) []string {
+	contents := []string{}
+	for _, line := range lines {
+		contents = append(contents, line.Content)
+	}
+	return contents
+}
+
+func TestMergeFiles(t *testing.T) {
+	dir := t.TempDir()
+	app := filepath.Join(dir, "app.log")
+	db := filepath.Join(dir, "db.log")
+	assert.NoError(t, os.WriteFile(app, []byte(strings.Join([]string{
+		"2024-01-02T10:00:00Z app start",
+		"2024-01-02T10:00:02Z app ready",
+		"  at stack line",
+		"2024-01-02T10:00:04Z app stop",
+	}, "\n")+"\n"), 0600))
+	assert.NoError(t, os.WriteFile(db, []byte(strings.Join([]string{
+		"db banner",
+		"2024-01-02T10:00:01Z db start",
+		"2024-01-02T10:00:02Z db ready",
+		"2024-01-02T10:00:03Z db query",
+	}, "\n")+"\n"), 0600))
+	ctx := context.Background()
+
+	// lines without a timestamp stay after the line before them, ties keep the order of the files
+	result, err := MergeFiles(ctx, []string{app, db}, nil, "", "", time.UTC, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{
+		"db banner",
+		"2024-01-02T10:00:00Z app start",
+		"2024-01-02T10:00:01Z db start",
+		"2024-01-02T10:00:02Z app ready",
+		"  at stack line",
+		"2024-01-02T10:00:02Z db ready",
+		"2024-01-02T10:00:03Z db query",
+		"2024-01-02T10:00:04Z app stop",
+	}, mergedContents(result.Lines))
+	assert.Equal(t, 1, result.Lines[0].Source)
+	assert.Equal(t, 1, result.Lines[0].LineNumber)
+	assert.Equal(t, 0, result.Lines[4].Source)
+	assert.Equal(t, 3, result.Lines[4].LineNumber)
+	assert.Equal(t, []LineSource{{FilePath: app, Type: TypeFile, Label: fileLabel(app, TypeFile, "")}, {FilePath: db, Type: TypeFile, Label: fileLabel(db, TypeFile, "")}}, result.Sources)
+
+	// paged, the cursor resumes every file where the page ended
+	pages := []string{}
+	var cursor *MergeCursor
+	for i := 0; i < 4; i++ {
+		result, err := MergeFiles(ctx, []string{app, db}, cursor, "", "", time.UTC, 3)
+		assert.NoError(t, err)
+		pages = append(pages, mergedContents(result.Lines)...)
+		parsed, err := ParseMergeCursor(result.NextCursor, 2)
+		assert.NoError(t, err)
+		cursor = &parsed
+	}
+	assert.Equal(t, mergedContents(result.Lines), pages)
+
+	// a page past the max is truncated, its cursor resumes after its last line
+	func() {
+		defer func(maxPerPage int) { GlobalMaxPerPage = maxPerPage }(GlobalMaxPerPage)
+		GlobalMaxPerPage = 5
+		truncated, err := MergeFiles(ctx, []string{app, db}, nil, "", "", time.UTC, 100)
+		assert.NoError(t, err)
+		assert.True(t, truncated.Truncated)
+		assert.Equal(t, pages[:5], mergedContents(truncated.Lines))
+		parsed, err := ParseMergeCursor(truncated.NextCursor, 2)
+		assert.NoError(t, err)
+		rest, err := MergeFiles(ctx, []string{app, db}, &parsed, "", "", time.UTC, 100)
+		assert.NoError(t, err)
+		assert.False(t, rest.Truncated)
+		assert.Equal(t, pages[5:], mergedContents(rest.Lines))
+	}()
+
+	// matching lines only
+	result, err = MergeFiles(ctx, []string{app, db}, nil, "ready", "", time.UTC, 100)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"2024-01-02T10:00:02Z app ready", "2024-01-02T10:00:02Z db ready"}, mergedContents(result.Lines))
+
+	// a file replaced since expires the cursor
+	assert.NoError(t, os.WriteFile(db, []byte("replaced\n"), 0600))
+	_, err = MergeFiles(ctx, []string{app, db}, cursor, "", "", time.UTC, 3)
+	assert.ErrorIs(t, err, ErrCursorExpired)
+
+	_, err = ParseMergeCursor(cursor.String(), 3)
+	assert.Error(t, err)
+}
+
+func TestAPIHandler_GetMerge(t *testing.T) {
+	dir := t.TempDir()
+	app := filepath.Join(dir, "app.log")
+	db := filepath.Join(dir, "db.log")
+	assert.NoError(t, os.WriteFile(app, []byte("2024-01-02T10:00:00Z app start\n2024-01-02T10:00:02Z app ready\n"), 0600))
+	assert.NoError(t, os.WriteFile(db, []byte("2024-01-02T10:00:01Z db start\n"), 0600))
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	fileInfos := SetFileIDs([]FileInfo{{FilePath: app, Type: TypeFile}, {FilePath: db, Type: TypeFile}, {FilePath: "/var/log/remote.log", Type: TypeSSH, Host: "web1"}})
+	GlobalFileRegistry.Replace(fileInfos)
+	maxMergeFiles := GlobalMaxMergeFiles
+	defer func() { GlobalMaxMergeFiles = maxMergeFiles }()
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	get := func(query url.Values) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/merge?"+query.Encode(), nil))
+		return rec
+	}
+
+	rec := get(url.Values{"ids": {fileInfos[0].ID, fileInfos[1].ID}, "per_page": {"2"}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	response := APIResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, []string{"2024-01-02T10:00:00Z app start", "2024-01-02T10:00:01Z db start"}, mergedContents(response.Result.Lines))
+	assert.Equal(t, []int{0, 1}, []int{response.Result.Lines[0].Source, response.Result.Lines[1].Source})
+	assert.Len(t, response.FilePaths, 2)
+
+	rec = get(url.Values{"ids": {fileInfos[0].ID, fileInfos[1].ID}, "per_page": {"2"}, "cursor": {response.Result.NextCursor}})
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.Equal(t, []string{"2024-01-02T10:00:02Z app ready"}, mergedContents(response.Result.Lines))
+
+	// a cursor of other files, remote files, unknown files and more files than allowed are refused
+	assert.Equal(t, http.StatusUnprocessableEntity, get(url.Values{"ids": {fileInfos[0].ID}, "cursor": {response.Result.NextCursor}}).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, get(url.Values{"ids": {fileInfos[0].ID, fileInfos[2].ID}}).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, get(url.Values{"ids": {fileInfos[0].ID, fileInfos[0].ID}}).Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, get(url.Values{}).Code)
+	assert.Equal(t, http.StatusNotFound, get(url.Values{"ids": {"missing"}}).Code)
+	GlobalMaxMergeFiles = 1
+	assert.Equal(t, http.StatusUnprocessableEntity, get(url.Values{"ids": {fileInfos[0].ID, fileInfos[1].ID}}).Code)
+}
+
+func TestAPIHandler_GetStream_Merged(t *testing.T) {
+	useTailHub(t)
+	dir := t.TempDir()
+	app := filepath.Join(dir, "app.log")
+	db := filepath.Join(dir, "db.log")
+	assert.NoError(t, os.WriteFile(app, []byte("2024-01-02T10:00:00Z app start\n2024-01-02T10:00:02Z app ready\n"), 0600))
+	assert.NoError(t, os.WriteFile(db, []byte("2024-01-02T10:00:01Z db start\n"), 0600))
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	fileInfos := SetFileIDs([]FileInfo{{FilePath: app, Type: TypeFile}, {FilePath: db, Type: TypeFile}})
+	GlobalFileRegistry.Replace(fileInfos)
+	server := httptest.NewServer(newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff}))
+	defer server.Close()
+
+	conn := dialStream(t, server, "tail=2&ids="+fileInfos[0].ID+"&ids="+fileInfos[1].ID)
+	defer conn.Close()
+	snapshot := readStreamMessage(t, conn)
+	assert.Equal(t, StreamMessageSnapshot, snapshot.Type)
+	assert.Len(t, snapshot.Sources, 2)
+	if assert.Len(t, snapshot.Lines, 2) {
+		assert.Equal(t, "2024-01-02T10:00:01Z db start", snapshot.Lines[0].Content)
+		assert.Equal(t, 1, snapshot.Lines[0].Source)
+		assert.Equal(t, "2024-01-02T10:00:02Z app ready", snapshot.Lines[1].Content)
+		assert.Equal(t, 0, snapshot.Lines[1].Source)
+	}
+
+	f, err := os.OpenFile(db, os.O_APPEND|os.O_WRONLY, 0600)
+	assert.NoError(t, err)
+	_, err = f.WriteString("2024-01-02T10:00:03Z db query\n")
+	assert.NoError(t, err)
+	f.Close()
+	message := readStreamMessage(t, conn)
+	assert.Equal(t, StreamMessageLine, message.Type)
+	if assert.NotNil(t, message.Line) {
+		assert.Equal(t, "2024-01-02T10:00:03Z db query", message.Line.Content)
+		assert.Equal(t, 1, message.Line.Source)
+		assert.Equal(t, 2, message.Line.LineNumber)
+		assert.Equal(t, int64(1), message.Line.Seq)
+	}
+}
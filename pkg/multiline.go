@@ -0,0 +1,234 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"regexp"
+
+	"github.com/acarl005/stripansi"
+)
+
+const (
+	// multilineMaxLines is the most physical lines an entry spans, the line after starts another one
+	multilineMaxLines = 1000
+	// multilineTailMaxLines is the most lines a tail reads back looking for the start of its first entry
+	multilineTailMaxLines = 100000
+)
+
+// DefaultMultilineStart is the start of an entry unless the request or the path defaults say otherwise:
+// a line starting with a timestamp, like 2024-01-02, 02/Jan/2024, Jan  2 15:04:05, I0102 15:04:05 or
+// 15:04:05, bracketed or not
+var DefaultMultilineStart = regexp.MustCompile(
+	`^\[?(?:\d{4}[-/]\d{2}[-/]\d{2}|\d{2}/[A-Z][a-z]{2}/\d{4}|[A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}|[IWEF]\d{4} \d{2}:\d{2}|\d{2}:\d{2}:\d{2})`,
+)
+
+// ParseMultilineStart compiles the start of entry regex of a request, DefaultMultilineStart when empty
+func ParseMultilineStart(pattern string) (*regexp.Regexp, error) {
+	if pattern == "" {
+		return DefaultMultilineStart, nil
+	}
+	start, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("multiline_start: %w", err)
+	}
+	return start, nil
+}
+
+// SetMultiline makes the following scans and tails group lines into entries, a line matching start
+// beginning an entry that the lines up to the next start continue, like the lines of a stack trace
+func (w *Watcher) SetMultiline(start *regexp.Regexp) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.multiline = start
+}
+
+// logEntry is one or more physical lines read as one
+type logEntry struct {
+	lineNumber int
+	lines      int
+	content    []byte
+	// hashes of the first, second and last physical lines, anchors are made of them
+	hash       uint32
+	secondHash uint32
+	lastHash   uint32
+}
+
+// entryGrouper merges physical lines into entries. Lines before the first start of an entry, as all
+// the lines of a file without timestamps, are entries of their own.
+type entryGrouper struct {
+	start   *regexp.Regexp
+	started bool
+	entry   logEntry
+	spare   []byte
+}
+
+// add groups the next physical line, returning the entry it ends if any. The content of the entry
+// returned is only valid until the next entry is ended.
+func (g *entryGrouper) add(lineNumber int, line []byte, hash uint32) (logEntry, bool) {
+	start := g.start.Match(line)
+	g.started = g.started || start
+	var done logEntry
+	ended := g.entry.lines > 0 && (start || !g.started || g.entry.lines >= multilineMaxLines)
+	if ended {
+		done = g.entry
+		g.entry = logEntry{content: g.spare[:0]}
+		g.spare = done.content
+	}
+	if g.entry.lines == 0 {
+		g.entry = logEntry{lineNumber: lineNumber, content: append(g.entry.content[:0], line...), hash: hash}
+	} else {
+		g.entry.content = append(append(g.entry.content, '\n'), line...)
+		if g.entry.lines == 1 {
+			g.entry.secondHash = hash
+		}
+	}
+	g.entry.lines++
+	g.entry.lastHash = hash
+	return done, ended
+}
+
+// flush returns the entry of the last lines added
+func (g *entryGrouper) flush() (logEntry, bool) {
+	done := g.entry
+	g.entry = logEntry{}
+	return done, done.lines > 0
+}
+
+// appendEntry appends the result of an entry, linking the entry before to its first line
+func appendEntry(results []LineResult, entry logEntry, content string, fields map[string]string, prevHash uint32) []LineResult {
+	var last *LineResult
+	if n := len(results); n > 0 {
+		last = &results[n-1]
+	}
+	return append(results, entryResult(last, entry, content, fields, prevHash))
+}
+
+// entryResult is the result of an entry, linking last, the entry before, to its first line
+func entryResult(last *LineResult, entry logEntry, content string, fields map[string]string, prevHash uint32) LineResult {
+	if last != nil && last.Lines == 1 && last.LineNumber+1 == entry.lineNumber {
+		last.nextHash = entry.hash
+	}
+	return LineResult{
+		LineNumber: entry.lineNumber,
+		Content:    content,
+		Lines:      entry.lines,
+		Fields:     fields,
+		prevHash:   prevHash,
+		hash:       entry.hash,
+		nextHash:   entry.secondHash,
+	}
+}
+
+// collectMatchingEntries collects every matching entry of a scanner
+func (w *Watcher) collectMatchingEntries(ctx context.Context, scanner *bufio.Scanner, start scanStart) ([]LineResult, int, error) {
+	window := newLineWindow()
+	counts, err := w.collectEntriesInto(ctx, scanner, start, window)
+	if err != nil {
+		return nil, 0, err
+	}
+	return window.lines, counts, nil
+}
+
+// collectEntriesInto is collectLinesInto over entries: the patterns and filters match the lines of an
+// entry joined by newlines
+func (w *Watcher) collectEntriesInto(ctx context.Context, scanner *bufio.Scanner, start scanStart, window *lineWindow) (int, error) {
+	match, ignore, err := w.lineMatchers()
+	if err != nil {
+		return 0, err
+	}
+
+	lineNumber := start.skipped
+	counts := 0
+	var prevHash uint32
+	grouper := &entryGrouper{start: w.multiline}
+	collect := func(entry logEntry) {
+		defer func() { prevHash = entry.lastHash }()
+		if start.timeRange != nil {
+			if in, _ := start.timeRange.line(entry.content); !in {
+				return
+			}
+		}
+		if w.sampler != nil && !w.sampler.sampleLine(entry.lineNumber) {
+			return
+		}
+		line, content, fields, ok := w.keepLine(match, ignore, entry.content, "")
+		if !ok {
+			return
+		}
+		counts++
+		if w.sampler == nil || w.sampler.keepMatch() {
+			if content == "" && window.wants() {
+				content = string(line)
+			}
+			window.add(entryResult(window.last(), entry, content, fields, prevHash))
+		}
+	}
+
+	for scanner.Scan() {
+		if lineNumber%collectCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+		}
+		line := scanner.Bytes()
+		if hasANSI(line) {
+			line = []byte(stripansi.Strip(string(line)))
+		}
+		lineNumber++
+		if entry, ok := grouper.add(lineNumber, line, lineHash(line)); ok {
+			collect(entry)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return 0, err
+	}
+	if entry, ok := grouper.flush(); ok {
+		collect(entry)
+	}
+	return counts, nil
+}
+
+// tailEntries is Tail over entries. The end of the file is read back further until it holds more than
+// n entries, so that none is cut in half, or until its start or multilineTailMaxLines.
+func (w *Watcher) tailEntries(ctx context.Context, n int, linesCount int, sshConfig *SSHConfig) ([]LineResult, error) {
+	for count := n + 1; ; count = min(count*2, multilineTailMaxLines) {
+		contents, err := ReadTailLinesContext(ctx, w.filePath, count, w.isRemote, sshConfig)
+		if err != nil {
+			return nil, err
+		}
+		last := len(contents) < count || count == multilineTailMaxLines
+		first := max(linesCount-len(contents), 0) + 1
+		entries := groupTailLines(contents, first, w.multiline, !last)
+		if len(entries) > n || last {
+			return entries[max(len(entries)-n, 0):], nil
+		}
+	}
+}
+
+// groupTailLines groups the lines read from the end of a file numbered from first. When partial, the
+// lines before the first start of an entry end an entry that started before them and are left out.
+func groupTailLines(contents []string, first int, start *regexp.Regexp, partial bool) []LineResult {
+	var results []LineResult
+	var prevHash uint32
+	grouper := &entryGrouper{start: start}
+	collect := func(entry logEntry) {
+		results = appendEntry(results, entry, string(entry.content), nil, prevHash)
+		prevHash = entry.lastHash
+	}
+	for i, content := range contents {
+		line := []byte(stripansi.Strip(content))
+		hash := lineHash(line)
+		if partial && !grouper.started && !start.Match(line) {
+			prevHash = hash
+			continue
+		}
+		if entry, ok := grouper.add(first+i, line, hash); ok {
+			collect(entry)
+		}
+	}
+	if entry, ok := grouper.flush(); ok {
+		collect(entry)
+	}
+	return results
+}
@@ -0,0 +1,164 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const javaTrace = `2024-01-02 15:04:05 INFO started
+2024-01-02 15:04:06 ERROR request failed
+java.lang.IllegalStateException: connection closed
+	at com.example.Client.send(Client.java:42)
+	at com.example.Handler.handle(Handler.java:17)
+Caused by: java.io.IOException: broken pipe
+	at java.base/sun.nio.ch.IOUtil.write(IOUtil.java:62)
+	... 2 more
+2024-01-02 15:04:07 INFO retrying
+`
+
+const pythonTraceback = `[2024-01-02 15:04:05] INFO worker started
+[2024-01-02 15:04:06] ERROR task failed
+Traceback (most recent call last):
+  File "/app/worker.py", line 12, in run
+    result = task()
+  File "/app/tasks.py", line 3, in task
+    return 1 / 0
+ZeroDivisionError: division by zero
+[2024-01-02 15:04:07] INFO worker stopped
+`
+
+func TestWatcher_ScanMultiline(t *testing.T) {
+	tests := []struct {
+		name    string
+		content string
+		query   string
+		// line numbers and physical line counts of the entries matching query
+		want [][2]int
+	}{
+		{"java", javaTrace, "", [][2]int{{1, 1}, {2, 7}, {9, 1}}},
+		{"java exception", javaTrace, "IOException", [][2]int{{2, 7}}},
+		{"python", pythonTraceback, "", [][2]int{{1, 1}, {2, 7}, {9, 1}}},
+		{"python error", pythonTraceback, "ZeroDivisionError", [][2]int{{2, 7}}},
+		{"no timestamps", "first\n\tindented\nlast\n", "", [][2]int{{1, 1}, {2, 1}, {3, 1}}},
+		{"continuation first", "\tat orphan\n2024-01-02 INFO a\n  more\n", "", [][2]int{{1, 1}, {2, 2}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			logFile := filepath.Join(t.TempDir(), "app.log")
+			assert.NoError(t, os.WriteFile(logFile, []byte(tt.content), 0600))
+			watcher, err := NewWatcher(logFile, tt.query, "", false, "", "", "", "", "")
+			assert.NoError(t, err)
+			watcher.SetMultiline(DefaultMultilineStart)
+			result, err := watcher.ScanContext(context.Background(), 1, 10, false)
+			assert.NoError(t, err)
+			got := [][2]int{}
+			for _, line := range result.Lines {
+				got = append(got, [2]int{line.LineNumber, line.Lines})
+				assert.Equal(t, line.Lines, strings.Count(line.Content, "\n")+1)
+			}
+			assert.Equal(t, tt.want, got)
+			assert.Equal(t, len(tt.want), result.Total)
+		})
+	}
+}
+
+func TestWatcher_TailMultiline(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte(javaTrace+pythonTraceback), 0600))
+	watcher, err := NewWatcher(logFile, "", "", false, "", "", "", "", "")
+	assert.NoError(t, err)
+	watcher.SetMultiline(DefaultMultilineStart)
+
+	// the last entries are whole although the traces are longer than the lines asked for
+	result, err := watcher.Tail(context.Background(), 2)
+	assert.NoError(t, err)
+	if assert.Len(t, result.Lines, 2) {
+		assert.Equal(t, 11, result.Lines[0].LineNumber)
+		assert.Equal(t, 7, result.Lines[0].Lines)
+		assert.True(t, strings.HasPrefix(result.Lines[0].Content, "[2024-01-02 15:04:06] ERROR task failed\nTraceback"))
+		assert.Equal(t, "[2024-01-02 15:04:07] INFO worker stopped", result.Lines[1].Content)
+	}
+
+	// the anchors of the tail are those of the scan
+	scan, err := watcher.ScanContext(context.Background(), 1, 2, true)
+	assert.NoError(t, err)
+	assert.Equal(t, scan.Lines[0].Anchor, result.Lines[0].Anchor)
+	assert.Equal(t, scan.Lines[1].Anchor, result.Lines[1].Anchor)
+
+	// more entries than the file has
+	result, err = watcher.Tail(context.Background(), 10)
+	assert.NoError(t, err)
+	assert.Len(t, result.Lines, 6)
+	assert.Equal(t, 1, result.Lines[0].LineNumber)
+}
+
+func TestGroupTailLines(t *testing.T) {
+	contents := []string{"\tat a", "\tat b", "2024-01-02 ERROR c", "\tat d", "2024-01-02 INFO e"}
+	entries := groupTailLines(contents, 8, DefaultMultilineStart, true)
+	if assert.Len(t, entries, 2) {
+		assert.Equal(t, 10, entries[0].LineNumber)
+		assert.Equal(t, 2, entries[0].Lines)
+		assert.Equal(t, lineHash("\tat b"), entries[0].prevHash)
+	}
+	// from the start of the file, the lines before a start are entries of their own
+	entries = groupTailLines(contents, 1, DefaultMultilineStart, false)
+	assert.Len(t, entries, 4)
+}
+
+func TestAPIHandler_GetMultiline(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte(javaTrace), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: logFile, Type: TypeFile}})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	get := func(query string) (*httptest.ResponseRecorder, ScanResult) {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api?type=file&file_path="+logFile+query, nil))
+		res := APIResponse{}
+		if rec.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		}
+		return rec, res.Result
+	}
+
+	// grouping is opt in
+	_, result := get("&query=Exception")
+	assert.Equal(t, 2, result.Total)
+	_, result = get("&query=Exception&multiline=true")
+	assert.Equal(t, 1, result.Total)
+	assert.Equal(t, 2, result.Lines[0].LineNumber)
+	assert.Equal(t, 7, result.Lines[0].Lines)
+	assert.Empty(t, result.NextCursor)
+	_, result = get("&multiline_start=" + url.QueryEscape("^Caused by"))
+	assert.Equal(t, []int{1, 2, 3, 4, 5, 6}, lineNumbersOf(result))
+	_, result = get("&multiline=true&tail=1")
+	assert.Equal(t, 9, result.Lines[0].LineNumber)
+
+	// a path default groups unless the request says otherwise
+	defer GlobalPathDefaults.Set(nil)
+	GlobalPathDefaults.Set([]PathConfig{{Pattern: filepath.Join(dir, "*.log"), Defaults: &ViewDefaults{Multiline: `^\d{4}-`}}})
+	_, result = get("&per_page=10")
+	assert.Equal(t, 3, result.Total)
+	_, result = get("&per_page=10&multiline=false")
+	assert.Equal(t, 9, result.Total)
+
+	rec, _ := get("&multiline_start=" + url.QueryEscape("(unclosed"))
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
+
+func lineNumbersOf(result ScanResult) []int {
+	numbers := []int{}
+	for _, line := range result.Lines {
+		numbers = append(numbers, line.LineNumber)
+	}
+	return numbers
+}
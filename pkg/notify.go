@@ -0,0 +1,437 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/smtp"
+	"net/url"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/template"
+	"time"
+)
+
+const (
+	NotifierTypeEmail   = "email"
+	NotifierTypeCommand = "command"
+	NotifierTypeWebhook = "webhook"
+
+	defaultEmailSubject = `[gol] {{.Name}} matched in {{.FilePath}}`
+	defaultEmailBody    = `{{.Name}} matched {{len .Lines}} line(s) in {{.FilePath}} at {{.TriggeredAt.Format "2006-01-02T15:04:05Z07:00"}}
+{{range .Lines}}
+{{.LineNumber}}: {{.Content}}{{end}}
+{{range .Contexts}}
+-- around line {{.LineNumber}}{{if .Link}} {{.Link}}{{end}}
+{{range .Lines}}{{.LineNumber}}: {{.Content}}
+{{end}}{{end}}{{if .OmittedContexts}}
+{{.OmittedContexts}} more match(es) without context
+{{end}}`
+
+	defaultCommandTimeout       = 30 * time.Second
+	defaultCommandMaxConcurrent = 4
+	defaultWebhookTimeout       = 10 * time.Second
+	defaultNotifyAttempts       = 3
+	defaultNotifyBackoff        = 2 * time.Second
+)
+
+// AlertPayload is what notifiers are given when an alert fires
+type AlertPayload struct {
+	Name        string       `json:"name"`
+	FilePath    string       `json:"file_path"`
+	Host        string       `json:"host"`
+	Type        string       `json:"type"`
+	Pattern     string       `json:"pattern"`
+	Lines       []LineResult `json:"lines"`
+	TriggeredAt time.Time    `json:"triggered_at"`
+	// Contexts are the surrounding lines of the first matched lines, see ResolveContexts
+	Contexts []AlertContext `json:"contexts,omitempty"`
+	// OmittedContexts is the number of matched lines without a context snippet
+	OmittedContexts int `json:"omitted_contexts,omitempty"`
+}
+
+// Notifier delivers an alert payload somewhere
+type Notifier interface {
+	Name() string
+	Type() string
+	Notify(ctx context.Context, payload AlertPayload) error
+}
+
+// EmailNotifierConfig is the email entry of the alerts config section
+type EmailNotifierConfig struct {
+	Name     string   `json:"name" yaml:"name"`
+	Host     string   `json:"host" yaml:"host"`
+	Port     int      `json:"port" yaml:"port"`
+	StartTLS bool     `json:"starttls" yaml:"starttls"`
+	Username string   `json:"username" yaml:"username"`
+	Password string   `json:"password" yaml:"password"`
+	From     string   `json:"from" yaml:"from"`
+	To       []string `json:"to" yaml:"to"`
+	// Subject and Body are text/template executed with the AlertPayload
+	Subject string `json:"subject" yaml:"subject"`
+	Body    string `json:"body" yaml:"body"`
+}
+
+type EmailNotifier struct {
+	config  EmailNotifierConfig
+	subject *template.Template
+	body    *template.Template
+	send    func(config EmailNotifierConfig, msg []byte) error
+}
+
+func NewEmailNotifier(config EmailNotifierConfig) (*EmailNotifier, error) {
+	if config.Host == "" || config.From == "" || len(config.To) == 0 {
+		return nil, errors.New("email notifier requires host, from and to")
+	}
+	if config.Port == 0 {
+		config.Port = 25
+	}
+	if config.Subject == "" {
+		config.Subject = defaultEmailSubject
+	}
+	if config.Body == "" {
+		config.Body = defaultEmailBody
+	}
+	subject, err := template.New("subject").Parse(config.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("parsing email subject: %w", err)
+	}
+	body, err := template.New("body").Parse(config.Body)
+	if err != nil {
+		return nil, fmt.Errorf("parsing email body: %w", err)
+	}
+	return &EmailNotifier{config: config, subject: subject, body: body, send: sendMail}, nil
+}
+
+func (n *EmailNotifier) Name() string {
+	if n.config.Name != "" {
+		return n.config.Name
+	}
+	return NotifierTypeEmail + ":" + strings.Join(n.config.To, ",")
+}
+
+func (n *EmailNotifier) Type() string {
+	return NotifierTypeEmail
+}
+
+func (n *EmailNotifier) Notify(_ context.Context, payload AlertPayload) error {
+	msg, err := n.message(payload)
+	if err != nil {
+		return err
+	}
+	return n.send(n.config, msg)
+}
+
+// message renders the RFC 5322 message for payload
+func (n *EmailNotifier) message(payload AlertPayload) ([]byte, error) {
+	var subject, body bytes.Buffer
+	if err := n.subject.Execute(&subject, payload); err != nil {
+		return nil, fmt.Errorf("rendering email subject: %w", err)
+	}
+	if err := n.body.Execute(&body, payload); err != nil {
+		return nil, fmt.Errorf("rendering email body: %w", err)
+	}
+	// a header must stay on one line
+	subjectLine := strings.NewReplacer("\r", " ", "\n", " ").Replace(subject.String())
+
+	var msg bytes.Buffer
+	fmt.Fprintf(&msg, "From: %s\r\n", n.config.From)
+	fmt.Fprintf(&msg, "To: %s\r\n", strings.Join(n.config.To, ", "))
+	fmt.Fprintf(&msg, "Subject: %s\r\n", subjectLine)
+	fmt.Fprintf(&msg, "Date: %s\r\n", payload.TriggeredAt.Format(time.RFC1123Z))
+	msg.WriteString("MIME-Version: 1.0\r\n")
+	msg.WriteString("Content-Type: text/plain; charset=utf-8\r\n\r\n")
+	msg.WriteString(strings.ReplaceAll(body.String(), "\n", "\r\n"))
+	return msg.Bytes(), nil
+}
+
+// sendMail sends msg over SMTP, requiring STARTTLS when configured
+func sendMail(config EmailNotifierConfig, msg []byte) error {
+	addr := net.JoinHostPort(config.Host, strconv.Itoa(config.Port))
+	c, err := smtp.Dial(addr)
+	if err != nil {
+		return err
+	}
+	defer c.Close()
+
+	if config.StartTLS {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			return fmt.Errorf("smtp server %s does not support STARTTLS", addr)
+		}
+		if err := c.StartTLS(&tls.Config{ServerName: config.Host, MinVersion: tls.VersionTLS12}); err != nil {
+			return err
+		}
+	}
+	if config.Username != "" {
+		if err := c.Auth(smtp.PlainAuth("", config.Username, config.Password, config.Host)); err != nil {
+			return err
+		}
+	}
+	if err := c.Mail(config.From); err != nil {
+		return err
+	}
+	for _, to := range config.To {
+		if err := c.Rcpt(to); err != nil {
+			return err
+		}
+	}
+	w, err := c.Data()
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(msg); err != nil {
+		return err
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+	return c.Quit()
+}
+
+// CommandNotifierConfig is the command entry of the alerts config section.
+// The payload is passed as JSON on stdin, log content is never passed as arguments.
+type CommandNotifierConfig struct {
+	Name          string        `json:"name" yaml:"name"`
+	Path          string        `json:"path" yaml:"path"`
+	Args          []string      `json:"args" yaml:"args"`
+	Timeout       time.Duration `json:"timeout" yaml:"timeout"`
+	MaxConcurrent int           `json:"max_concurrent" yaml:"max_concurrent"`
+}
+
+type CommandNotifier struct {
+	config    CommandNotifierConfig
+	semaphore chan struct{}
+}
+
+func NewCommandNotifier(config CommandNotifierConfig) (*CommandNotifier, error) {
+	if config.Path == "" {
+		return nil, errors.New("command notifier requires path")
+	}
+	if config.Timeout <= 0 {
+		config.Timeout = defaultCommandTimeout
+	}
+	if config.MaxConcurrent <= 0 {
+		config.MaxConcurrent = defaultCommandMaxConcurrent
+	}
+	return &CommandNotifier{
+		config:    config,
+		semaphore: make(chan struct{}, config.MaxConcurrent),
+	}, nil
+}
+
+func (n *CommandNotifier) Name() string {
+	if n.config.Name != "" {
+		return n.config.Name
+	}
+	return NotifierTypeCommand + ":" + n.config.Path
+}
+
+func (n *CommandNotifier) Type() string {
+	return NotifierTypeCommand
+}
+
+func (n *CommandNotifier) Notify(ctx context.Context, payload AlertPayload) error {
+	select {
+	case n.semaphore <- struct{}{}:
+		defer func() { <-n.semaphore }()
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+
+	input, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(ctx, n.config.Timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, n.config.Path, n.config.Args...) // nolint: gosec
+	cmd.Stdin = bytes.NewReader(input)
+	// do not hang on children of the command holding stderr open after a timeout
+	cmd.WaitDelay = time.Second
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return fmt.Errorf("command timed out after %s", n.config.Timeout)
+		}
+		return fmt.Errorf("%w: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// WebhookNotifier POSTs the payload as JSON to a URL, like a Slack incoming webhook
+type WebhookNotifier struct {
+	name   string
+	url    string
+	client *http.Client
+}
+
+// NewWebhookNotifier posts to rawURL with client, or a client of its own when nil
+func NewWebhookNotifier(name string, rawURL string, client *http.Client) (*WebhookNotifier, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("webhook url %q must be an http(s) URL", rawURL)
+	}
+	if client == nil {
+		client = &http.Client{Timeout: defaultWebhookTimeout}
+	}
+	return &WebhookNotifier{name: name, url: rawURL, client: client}, nil
+}
+
+func (n *WebhookNotifier) Name() string {
+	if n.name != "" {
+		return n.name
+	}
+	return NotifierTypeWebhook + ":" + redactURL(n.url)
+}
+
+func (n *WebhookNotifier) Type() string {
+	return NotifierTypeWebhook
+}
+
+func (n *WebhookNotifier) Notify(ctx context.Context, payload AlertPayload) error {
+	body, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, n.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := n.client.Do(req)
+	if err != nil {
+		// the error names the URL, whose path is often the secret of the hook
+		var urlErr *url.Error
+		if errors.As(err, &urlErr) {
+			return fmt.Errorf("posting to %s: %w", redactURL(n.url), urlErr.Err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("webhook answered %s", resp.Status)
+	}
+	return nil
+}
+
+// redactURL is rawURL without its path, query and credentials, which hold the secret of most webhooks
+func redactURL(rawURL string) string {
+	if rawURL == "" {
+		return ""
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return redacted
+	}
+	return u.Scheme + "://" + u.Host + "/" + redacted
+}
+
+// NotifierStatus is the delivery state of a notifier, served by the alert status API
+type NotifierStatus struct {
+	Name          string    `json:"name"`
+	Type          string    `json:"type"`
+	Attempts      int       `json:"attempts"`
+	Failures      int       `json:"failures"`
+	LastAttemptAt time.Time `json:"last_attempt_at"`
+	LastSuccessAt time.Time `json:"last_success_at"`
+	LastError     string    `json:"last_error"`
+}
+
+type NotifierStatuses struct {
+	mutex    sync.RWMutex
+	statuses map[string]*NotifierStatus
+}
+
+func NewNotifierStatuses() *NotifierStatuses {
+	return &NotifierStatuses{statuses: make(map[string]*NotifierStatus)}
+}
+
+func (s *NotifierStatuses) record(n Notifier, err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	status, ok := s.statuses[n.Name()]
+	if !ok {
+		status = &NotifierStatus{Name: n.Name(), Type: n.Type()}
+		s.statuses[n.Name()] = status
+	}
+	status.Attempts++
+	status.LastAttemptAt = time.Now()
+	if err != nil {
+		status.Failures++
+		status.LastError = err.Error()
+		return
+	}
+	status.LastSuccessAt = status.LastAttemptAt
+	status.LastError = ""
+}
+
+// List returns a copy of the statuses sorted by name
+func (s *NotifierStatuses) List() []NotifierStatus {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	list := make([]NotifierStatus, 0, len(s.statuses))
+	for _, status := range s.statuses {
+		list = append(list, *status)
+	}
+	sort.Slice(list, func(i, j int) bool {
+		return list[i].Name < list[j].Name
+	})
+	return list
+}
+
+// NotifyWithRetry delivers payload, retrying failures with exponential backoff.
+// Every attempt is recorded in statuses.
+func NotifyWithRetry(ctx context.Context, n Notifier, payload AlertPayload, attempts int, backoff time.Duration, statuses *NotifierStatuses) error {
+	var err error
+	for attempt := 1; attempt <= attempts; attempt++ {
+		err = n.Notify(ctx, payload)
+		statuses.record(n, err)
+		if err == nil {
+			return nil
+		}
+		slog.Warn("alert notification failed", "notifier", n.Name(), "attempt", attempt, "error", err)
+		if attempt == attempts {
+			break
+		}
+		select {
+		case <-time.After(backoff):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		backoff *= 2
+	}
+	return err
+}
+
+// NotifyAll delivers payload to every notifier concurrently, each retried like NotifyWithRetry, and
+// returns the errors of the notifiers that gave up
+func NotifyAll(ctx context.Context, notifiers []Notifier, payload AlertPayload, attempts int, backoff time.Duration, statuses *NotifierStatuses) error {
+	var wg sync.WaitGroup
+	errs := make([]error, len(notifiers))
+	for i, n := range notifiers {
+		wg.Add(1)
+		go func(i int, n Notifier) {
+			defer wg.Done()
+			if err := NotifyWithRetry(ctx, n, payload, attempts, backoff, statuses); err != nil {
+				slog.Error("alert notification gave up", "notifier", n.Name(), "error", err)
+				errs[i] = err
+			}
+		}(i, n)
+	}
+	wg.Wait()
+	return errors.Join(errs...)
+}
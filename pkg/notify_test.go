@@ -0,0 +1,142 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func testAlertPayload() AlertPayload {
+	return AlertPayload{
+		Name:        "errors",
+		FilePath:    "/var/log/app.log",
+		Type:        TypeFile,
+		Pattern:     "ERROR",
+		Lines:       []LineResult{{LineNumber: 7, Content: "ERROR disk full"}},
+		TriggeredAt: time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC),
+	}
+}
+
+func TestEmailNotifier_Notify(t *testing.T) {
+	n, err := NewEmailNotifier(EmailNotifierConfig{
+		Host: "smtp.example.com",
+		From: "gol@example.com",
+		To:   []string{"ops@example.com", "dev@example.com"},
+	})
+	assert.NoError(t, err)
+
+	var sent string
+	n.send = func(_ EmailNotifierConfig, msg []byte) error {
+		sent = string(msg)
+		return nil
+	}
+	assert.NoError(t, n.Notify(context.Background(), testAlertPayload()))
+	assert.Contains(t, sent, "To: ops@example.com, dev@example.com\r\n")
+	assert.Contains(t, sent, "Subject: [gol] errors matched in /var/log/app.log\r\n")
+	assert.Contains(t, sent, "7: ERROR disk full")
+
+	_, err = NewEmailNotifier(EmailNotifierConfig{Host: "smtp.example.com"})
+	assert.Error(t, err)
+	_, err = NewEmailNotifier(EmailNotifierConfig{Host: "h", From: "f", To: []string{"t"}, Subject: "{{.Nope"})
+	assert.Error(t, err)
+}
+
+func TestCommandNotifier_Notify(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "payload.json")
+	n, err := NewCommandNotifier(CommandNotifierConfig{
+		Path: "/bin/sh",
+		Args: []string{"-c", `cat > "$0"`, out},
+	})
+	assert.NoError(t, err)
+	assert.NoError(t, n.Notify(context.Background(), testAlertPayload()))
+
+	b, err := os.ReadFile(out)
+	assert.NoError(t, err)
+	var payload AlertPayload
+	assert.NoError(t, json.Unmarshal(b, &payload))
+	assert.Equal(t, "ERROR disk full", payload.Lines[0].Content)
+
+	slow, err := NewCommandNotifier(CommandNotifierConfig{
+		Path:    "/bin/sh",
+		Args:    []string{"-c", "exec sleep 5"},
+		Timeout: 50 * time.Millisecond,
+	})
+	assert.NoError(t, err)
+	err = slow.Notify(context.Background(), testAlertPayload())
+	assert.Error(t, err)
+	assert.True(t, strings.Contains(err.Error(), "timed out"))
+}
+
+type flakyNotifier struct {
+	failures int
+	calls    int
+}
+
+func (n *flakyNotifier) Name() string { return "flaky" }
+func (n *flakyNotifier) Type() string { return NotifierTypeCommand }
+func (n *flakyNotifier) Notify(_ context.Context, _ AlertPayload) error {
+	n.calls++
+	if n.calls <= n.failures {
+		return errors.New("unavailable")
+	}
+	return nil
+}
+
+func TestWebhookNotifier_Notify(t *testing.T) {
+	var received AlertPayload
+	var contentType string
+	status := http.StatusNoContent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contentType = r.Header.Get("Content-Type")
+		json.NewDecoder(r.Body).Decode(&received) //nolint: errcheck
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	n, err := NewWebhookNotifier("", server.URL+"/services/T0/B0/secret", server.Client())
+	assert.NoError(t, err)
+	assert.Equal(t, "webhook:"+server.URL+"/REDACTED", n.Name())
+	assert.NoError(t, n.Notify(context.Background(), testAlertPayload()))
+	assert.Equal(t, "application/json", contentType)
+	assert.Equal(t, testAlertPayload().Lines, received.Lines)
+	assert.Equal(t, "/var/log/app.log", received.FilePath)
+
+	status = http.StatusBadGateway
+	assert.EqualError(t, n.Notify(context.Background(), testAlertPayload()), "webhook answered 502 Bad Gateway")
+	server.Close()
+	err = n.Notify(context.Background(), testAlertPayload())
+	assert.Error(t, err)
+	assert.NotContains(t, err.Error(), "secret")
+
+	for _, invalid := range []string{"hooks.example.com/x", "ftp://hooks.example.com/x", "https://"} {
+		_, err := NewWebhookNotifier("", invalid, nil)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestNotifyWithRetry(t *testing.T) {
+	statuses := NewNotifierStatuses()
+
+	n := &flakyNotifier{failures: 2}
+	assert.NoError(t, NotifyWithRetry(context.Background(), n, testAlertPayload(), 3, time.Millisecond, statuses))
+	assert.Equal(t, 3, n.calls)
+	status := statuses.List()[0]
+	assert.Equal(t, 3, status.Attempts)
+	assert.Equal(t, 2, status.Failures)
+	assert.Empty(t, status.LastError)
+
+	n = &flakyNotifier{failures: 5}
+	assert.Error(t, NotifyWithRetry(context.Background(), n, testAlertPayload(), 2, time.Millisecond, statuses))
+	status = statuses.List()[0]
+	assert.Equal(t, "unavailable", status.LastError)
+	assert.Equal(t, 4, status.Failures)
+}
@@ -0,0 +1,366 @@
+package pkg
+
+import (
+	"net/http"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// APIVersion is the version of the API contract described by /api/openapi.json.
+// It changes whenever a field of a response is renamed, removed or changes type.
+const APIVersion = "1.0"
+
+// APIRoute documents one route of the API, the OpenAPI document is generated from them
+type APIRoute struct {
+	Method string
+	// Path is relative to the base URL, with echo's :param placeholders
+	Path    string
+	Summary string
+	// Request is the struct the query parameters are bound to, nil without parameters
+	Request interface{}
+	// Response is the body of a 200, nil for event streams
+	Response interface{}
+	// Events are the data of each event of an event stream
+	Events map[string]interface{}
+	// Messages are the JSON messages of a WebSocket by their type, its 101 is documented instead of a 200
+	Messages map[string]interface{}
+	// Download is the content type of a file served as an attachment, with Range support
+	Download string
+	// Admin routes require the admin token when the server has one
+	Admin bool
+}
+
+// APIRoutes are the documented routes, every API route of SetupRoutes must be listed
+var APIRoutes = []APIRoute{
+	{Method: http.MethodGet, Path: "api", Summary: "Search a file, one page of matching lines", Request: APIRequest{}, Response: APIResponse{}},
+	{Method: http.MethodGet, Path: "api/search", Summary: "Search a whole file for a text or regex, one page of matching lines with the offsets of the matches", Request: SearchRequest{}, Response: APIResponse{}},
+	{Method: http.MethodGet, Path: "api/bytes", Summary: "Read a byte window of a file", Request: BytesRequest{}, Response: ByteWindowResult{}},
+	{Method: http.MethodGet, Path: "api/files", Summary: "List the watched files", Request: FileListRequest{}, Response: FileListResponse{}},
+	{Method: http.MethodGet, Path: "api/anchor", Summary: "Locate a line by its anchor", Request: AnchorRequest{}, Response: AnchorResult{}},
+	{Method: http.MethodGet, Path: "api/tail", Summary: "Stream the lines appended to a file", Request: TailRequest{}, Events: map[string]interface{}{
+		TailEventSources:   []LineSource{},
+		TailEventLine:      TailEvent{},
+		TailEventTruncated: TailEvent{},
+		TailEventReopened:  TailEvent{},
+	}},
+	{Method: http.MethodGet, Path: "api/merge", Summary: "Several local files read as one, one page of their lines merged by timestamp", Request: MergeRequest{}, Response: APIResponse{}},
+	{Method: http.MethodGet, Path: "api/stream", Summary: "Follow a file, or several merged with ids, over a WebSocket, a snapshot of its last lines then the lines appended", Request: StreamRequest{}, Messages: map[string]interface{}{
+		StreamMessageSnapshot: StreamMessage{},
+		StreamMessageLine:     StreamMessage{},
+		StreamMessageReset:    StreamMessage{},
+	}},
+	{Method: http.MethodGet, Path: "api/line", Summary: "Read one complete line", Request: LineRequest{}, Response: LineResult{}},
+	{Method: http.MethodGet, Path: "api/context", Summary: "The lines around a line number, seeking to it with the line index of the file", Request: ContextRequest{}, Response: ScanResult{}},
+	{Method: http.MethodGet, Path: "api/alerts/status", Summary: "Delivery state of the alert notifiers", Response: AlertsStatusResponse{}},
+	{Method: http.MethodGet, Path: "api/metrics", Summary: "Runtime counters and disk usage", Response: MetricsResponse{}},
+	{Method: http.MethodGet, Path: "api/sources", Summary: "Status of every source and disk usage", Response: SourcesResponse{}},
+	{Method: http.MethodGet, Path: "api/jobs", Summary: "Running and recently finished jobs", Response: JobsResponse{}},
+	{Method: http.MethodGet, Path: "api/events", Summary: "Stream server events", Events: map[string]interface{}{
+		ServerEventJobs:  JobsResponse{},
+		ServerEventFiles: FileListResponse{},
+	}},
+	{Method: http.MethodGet, Path: "api/replay", Summary: "Replay a time window of a file at its original pace", Request: ReplayRequest{}, Events: map[string]interface{}{
+		ServerEventReplay:    ReplayStart{},
+		TailEventLine:        ReplayLine{},
+		ServerEventReplayEnd: ReplayEnd{},
+	}},
+	{Method: http.MethodGet, Path: "api/diff", Summary: "Lines of a file missing from another file or time window, format=ndjson exports every line", Request: DiffRequest{}, Response: DiffResult{}},
+	{Method: http.MethodGet, Path: "api/histogram", Summary: "Matching lines of a file counted in buckets of time, or of bytes for files without timestamps", Request: HistogramRequest{}, Response: Histogram{}},
+	{Method: http.MethodGet, Path: "api/download", Summary: "Download a file as is, or a range of its lines, Range requests resume it", Request: DownloadRequest{}, Download: "application/octet-stream"},
+	{Method: http.MethodGet, Path: "api/exports/:id", Summary: "Download a spooled export, again or once its async job is done, 202 with the job while it runs. Range requests resume it", Download: "application/x-ndjson"},
+	{Method: http.MethodGet, Path: "api/shares/:id", Summary: "Expand a share link into the file, line and filters it was made of, 404, 409 or 410 when it no longer opens", Response: ShareResult{}},
+	{Method: http.MethodGet, Path: "api/self-report", Summary: "The self report sent to the fleet inventory of -report-to", Response: SelfReport{}},
+	{Method: http.MethodGet, Path: "api/version", Summary: "Server and API versions", Response: VersionResponse{}},
+	{Method: http.MethodGet, Path: "api/capabilities", Summary: "Features and limits of the server", Response: Capabilities{}},
+	{Method: http.MethodGet, Path: "api/openapi.json", Summary: "This document"},
+	{Method: http.MethodGet, Path: "api/healthz/deep", Summary: "Check every source type with one real operation", Response: DeepHealthResponse{}, Admin: true},
+	{Method: http.MethodGet, Path: "healthz", Summary: "Liveness probe, without the token", Response: HealthzResponse{}},
+	{Method: http.MethodGet, Path: "readyz", Summary: "Readiness probe with the status of every source, 503 until ready, without the token", Response: ReadinessResponse{}},
+	{Method: http.MethodPost, Path: "api/admin/reload", Summary: "Reload the config file", Response: ConfigReload{}, Admin: true},
+	{Method: http.MethodPost, Path: "api/files/hide", Summary: "Hide a file from the file list", Request: FileCurationRequest{}, Response: FileListResponse{}, Admin: true},
+	{Method: http.MethodPost, Path: "api/files/pin", Summary: "Pin a file first in the file list", Request: FileCurationRequest{}, Response: FileListResponse{}, Admin: true},
+	{Method: http.MethodPost, Path: "api/shares", Summary: "Save a share link of a line of a file with the filters of the view", Request: ShareRequest{}, Response: ShareResult{}},
+	{Method: http.MethodDelete, Path: "api/jobs/:id", Summary: "Cancel a job", Response: Job{}, Admin: true},
+	{Method: http.MethodPost, Path: "api/replay/pause", Summary: "Pause a replay", Request: ReplayControlRequest{}, Response: Job{}, Admin: true},
+	{Method: http.MethodPost, Path: "api/replay/resume", Summary: "Resume a paused replay", Request: ReplayControlRequest{}, Response: Job{}, Admin: true},
+}
+
+type OpenAPI struct {
+	OpenAPI    string                                 `json:"openapi"`
+	Info       OpenAPIInfo                            `json:"info"`
+	Servers    []OpenAPIServer                        `json:"servers"`
+	Paths      map[string]map[string]OpenAPIOperation `json:"paths"`
+	Components OpenAPIComponents                      `json:"components"`
+}
+
+type OpenAPIInfo struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+type OpenAPIServer struct {
+	URL string `json:"url"`
+}
+
+type OpenAPIOperation struct {
+	Summary    string                     `json:"summary"`
+	Parameters []OpenAPIParameter         `json:"parameters,omitempty"`
+	Responses  map[string]OpenAPIResponse `json:"responses"`
+	Security   []map[string][]string      `json:"security,omitempty"`
+}
+
+type OpenAPIParameter struct {
+	Name     string         `json:"name"`
+	In       string         `json:"in"`
+	Required bool           `json:"required"`
+	Schema   *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPIResponse struct {
+	Description string                      `json:"description"`
+	Content     map[string]OpenAPIMediaType `json:"content,omitempty"`
+}
+
+type OpenAPIMediaType struct {
+	Schema *OpenAPISchema `json:"schema"`
+}
+
+type OpenAPISchema struct {
+	Ref                  string                    `json:"$ref,omitempty"`
+	Type                 string                    `json:"type,omitempty"`
+	Format               string                    `json:"format,omitempty"`
+	Description          string                    `json:"description,omitempty"`
+	Nullable             bool                      `json:"nullable,omitempty"`
+	Items                *OpenAPISchema            `json:"items,omitempty"`
+	Properties           map[string]*OpenAPISchema `json:"properties,omitempty"`
+	Required             []string                  `json:"required,omitempty"`
+	AdditionalProperties *OpenAPISchema            `json:"additionalProperties,omitempty"`
+}
+
+type OpenAPIComponents struct {
+	Schemas         map[string]*OpenAPISchema        `json:"schemas"`
+	SecuritySchemes map[string]OpenAPISecurityScheme `json:"securitySchemes"`
+}
+
+type OpenAPISecurityScheme struct {
+	Type   string `json:"type"`
+	Scheme string `json:"scheme"`
+}
+
+const (
+	openAPISchemaPrefix = "#/components/schemas/"
+	openAPIAdminScheme  = "adminToken"
+)
+
+var pathParam = regexp.MustCompile(`:([a-z_]+)`)
+
+// NewOpenAPI describes routes, served under baseURL
+func NewOpenAPI(routes []APIRoute, baseURL string) *OpenAPI {
+	schemas := openAPISchemas{}
+	errorResponse := OpenAPIResponse{
+		Description: "error",
+		Content:     map[string]OpenAPIMediaType{"application/json": {Schema: schemas.of(reflect.TypeOf(HTTPErrorResponse{}))}},
+	}
+	doc := &OpenAPI{
+		OpenAPI: "3.0.3",
+		Info:    OpenAPIInfo{Title: "gol", Version: APIVersion},
+		Servers: []OpenAPIServer{{URL: baseURL}},
+		Paths:   map[string]map[string]OpenAPIOperation{},
+		Components: OpenAPIComponents{
+			Schemas:         schemas,
+			SecuritySchemes: map[string]OpenAPISecurityScheme{openAPIAdminScheme: {Type: "http", Scheme: "bearer"}},
+		},
+	}
+	for _, route := range routes {
+		path := "/" + pathParam.ReplaceAllString(route.Path, "{$1}")
+		operation := OpenAPIOperation{
+			Summary:    route.Summary,
+			Parameters: []OpenAPIParameter{},
+			Responses:  map[string]OpenAPIResponse{"default": errorResponse},
+		}
+		for _, match := range pathParam.FindAllStringSubmatch(route.Path, -1) {
+			operation.Parameters = append(operation.Parameters, OpenAPIParameter{
+				Name: match[1], In: "path", Required: true, Schema: &OpenAPISchema{Type: "string"},
+			})
+		}
+		if route.Request != nil {
+			operation.Parameters = append(operation.Parameters, schemas.queryParameters(reflect.TypeOf(route.Request))...)
+		}
+		if route.Messages != nil {
+			operation.Responses["101"] = schemas.response(route)
+		} else {
+			operation.Responses["200"] = schemas.response(route)
+		}
+		if route.Admin {
+			operation.Security = []map[string][]string{{openAPIAdminScheme: {}}}
+		}
+		if doc.Paths[path] == nil {
+			doc.Paths[path] = map[string]OpenAPIOperation{}
+		}
+		doc.Paths[path][strings.ToLower(route.Method)] = operation
+	}
+	return doc
+}
+
+// openAPISchemas are the component schemas, one per named struct
+type openAPISchemas map[string]*OpenAPISchema
+
+func (s openAPISchemas) response(route APIRoute) OpenAPIResponse {
+	switch {
+	case route.Events != nil:
+		return OpenAPIResponse{
+			Description: "server sent events, the data of each event is JSON. " + s.describeAll(route.Events),
+			Content:     map[string]OpenAPIMediaType{"text/event-stream": {Schema: &OpenAPISchema{Type: "string"}}},
+		}
+	case route.Messages != nil:
+		return OpenAPIResponse{
+			Description: "switched to a WebSocket, each message is a JSON object with its type in type. " + s.describeAll(route.Messages),
+		}
+	case route.Download != "":
+		return OpenAPIResponse{
+			Description: "file as an attachment, a Range request gets a 206 of the part asked for",
+			Content:     map[string]OpenAPIMediaType{route.Download: {Schema: &OpenAPISchema{Type: "string", Format: "binary"}}},
+		}
+	case route.Response != nil:
+		return OpenAPIResponse{
+			Description: "ok",
+			Content:     map[string]OpenAPIMediaType{"application/json": {Schema: s.of(reflect.TypeOf(route.Response))}},
+		}
+	}
+	return OpenAPIResponse{
+		Description: "ok",
+		Content:     map[string]OpenAPIMediaType{"application/json": {Schema: &OpenAPISchema{Type: "object"}}},
+	}
+}
+
+// describeAll describes the types by name, sorted
+func (s openAPISchemas) describeAll(types map[string]interface{}) string {
+	names := make([]string, 0, len(types))
+	for name := range types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	described := make([]string, 0, len(names))
+	for _, name := range names {
+		described = append(described, name+": "+s.describe(reflect.TypeOf(types[name])))
+	}
+	return strings.Join(described, ", ")
+}
+
+// describe registers the schema of t and names it for an event description
+func (s openAPISchemas) describe(t reflect.Type) string {
+	schema := s.of(t)
+	if schema.Type == "array" {
+		return "array of " + strings.TrimPrefix(schema.Items.Ref, openAPISchemaPrefix)
+	}
+	return strings.TrimPrefix(schema.Ref, openAPISchemaPrefix)
+}
+
+func (s openAPISchemas) queryParameters(t reflect.Type) []OpenAPIParameter {
+	parameters := []OpenAPIParameter{}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		name := field.Tag.Get("query")
+		if name == "" || !field.IsExported() {
+			continue
+		}
+		rules := strings.Split(field.Tag.Get("validate"), ",")
+		parameters = append(parameters, OpenAPIParameter{
+			Name:     name,
+			In:       "query",
+			Required: rules[0] == "required",
+			Schema:   s.of(field.Type),
+		})
+	}
+	return parameters
+}
+
+func (s openAPISchemas) of(t reflect.Type) *OpenAPISchema {
+	switch t {
+	case reflect.TypeOf(time.Time{}):
+		return &OpenAPISchema{Type: "string", Format: "date-time"}
+	case reflect.TypeOf(time.Duration(0)):
+		return &OpenAPISchema{Type: "integer", Format: "int64", Description: "nanoseconds"}
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		schema := *s.of(t.Elem())
+		if schema.Ref != "" {
+			// siblings of $ref are ignored in OpenAPI 3.0
+			return &schema
+		}
+		schema.Nullable = true
+		return &schema
+	case reflect.Bool:
+		return &OpenAPISchema{Type: "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32:
+		return &OpenAPISchema{Type: "integer"}
+	case reflect.Int64, reflect.Uint64:
+		return &OpenAPISchema{Type: "integer", Format: "int64"}
+	case reflect.Float32, reflect.Float64:
+		return &OpenAPISchema{Type: "number"}
+	case reflect.String:
+		return &OpenAPISchema{Type: "string"}
+	case reflect.Slice, reflect.Array:
+		if t.Elem().Kind() == reflect.Uint8 {
+			return &OpenAPISchema{Type: "string", Format: "byte"}
+		}
+		return &OpenAPISchema{Type: "array", Items: s.of(t.Elem())}
+	case reflect.Map:
+		return &OpenAPISchema{Type: "object", AdditionalProperties: s.of(t.Elem())}
+	case reflect.Struct:
+		if t.Name() == "" {
+			return s.object(t)
+		}
+		if _, ok := s[t.Name()]; !ok {
+			// registered before its fields, for types referring to themselves
+			s[t.Name()] = &OpenAPISchema{}
+			*s[t.Name()] = *s.object(t)
+		}
+		return &OpenAPISchema{Ref: openAPISchemaPrefix + t.Name()}
+	}
+	// interfaces hold any JSON value
+	return &OpenAPISchema{}
+}
+
+// object is the schema of the JSON fields of a struct, those without omitempty are required
+func (s openAPISchemas) object(t reflect.Type) *OpenAPISchema {
+	schema := &OpenAPISchema{Type: "object", Properties: map[string]*OpenAPISchema{}, Required: []string{}}
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		tag := field.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name, options, _ := strings.Cut(tag, ",")
+		if name == "" {
+			name = field.Name
+		}
+		schema.Properties[name] = s.of(field.Type)
+		if !strings.Contains(options, "omitempty") {
+			schema.Required = append(schema.Required, name)
+		}
+	}
+	return schema
+}
+
+type OpenAPIHandler struct {
+	openAPI *OpenAPI
+}
+
+func NewOpenAPIHandler(options *EchoOptions) *OpenAPIHandler {
+	return &OpenAPIHandler{openAPI: NewOpenAPI(APIRoutes, options.BaseURL)}
+}
+
+// Get serves the OpenAPI 3 document of the API
+func (h *OpenAPIHandler) Get(c echo.Context) error {
+	return c.JSON(http.StatusOK, h.openAPI)
+}
@@ -0,0 +1,244 @@
+package pkg
+
+import (
+	"encoding/json"
+	"flag"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite the golden files of testdata/golden")
+
+// responseFixtures has a value of every response type with all of its fields set
+func responseFixtures() map[string]interface{} {
+	at := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	finished := at.Add(time.Minute)
+	fileInfo := FileInfo{
+		ID:         "f1",
+		FilePath:   "/var/log/app.log",
+		LinesCount: 2,
+		FileSize:   64,
+		Name:       "app",
+		Type:       TypeFile,
+		Host:       "",
+		Generation: 1,
+		Segments:   []FileInfo{{ID: "f2", FilePath: "/var/log/app.log.1.gz", Type: TypeFile, Corrupt: "unexpected EOF", Warning: "broken symlink: lstat /data/app.log.1.gz: no such file or directory"}},
+		Target:     "/data/app-2024-06-01.log",
+		Aliases:    []string{"/data/app.log"},
+		Defaults:   &ViewDefaults{Parser: "json", View: "table", Order: OrderDesc, Multiline: "^\\S", Timezone: "UTC", Processor: ProcessorBase64JSON, Classes: map[string][]string{ClassError: {"SEVERE"}}},
+		Hidden:     true,
+		Pinned:     true,
+		Preview:    &FilePreview{Lines: []string{"INFO started"}, Skipped: PreviewSkippedBudget},
+	}
+	line := LineResult{
+		LineNumber: 2,
+		Content:    "2024-06-01T12:00:00Z ERROR failed",
+		Level:      "error",
+		Class:      ClassError,
+		Date:       "2024-06-01 12:00:00 +0000 UTC",
+		Anchor:     "1-abc-2",
+		Truncated:  true,
+		FullLength: 120,
+		Highlights: []Highlight{{Start: 21, End: 26, OutOfWindow: true}},
+		Source:     0,
+		Fields:     map[string]string{"level": "error"},
+	}
+	line.Agent.Device = "desktop"
+	source := LineSource{FilePath: "/var/log/app.log", Host: "", Type: TypeFile, Label: "app"}
+	timeRange := &TimeRangeResolution{
+		From:      at,
+		To:        finished,
+		Consulted: []SegmentTimeRange{{FilePath: "/var/log/app.log", First: at, Last: finished, Undated: false}},
+		Undated:   false,
+	}
+	job := Job{
+		ID:          "j1",
+		Kind:        JobKindReplay,
+		Target:      "/var/log/app.log",
+		State:       JobStateDone,
+		Processed:   64,
+		Total:       64,
+		Percent:     100,
+		Cancellable: true,
+		Pausable:    true,
+		Paused:      false,
+		Error:       "canceled",
+		StartedAt:   at,
+		FinishedAt:  &finished,
+	}
+	warnings := []SegmentWarning{{FilePath: "/var/log/app.log.1.gz", Error: "unexpected EOF"}}
+	healthCheck := HealthCheck{Type: TypeSSH, Host: "box1", Target: "/var/log/app.log", Status: HealthFail, LatencyMs: 5000, Error: "no answer within 5s", CheckedAt: at}
+	disk := DiskUsage{Path: "/tmp", Total: 1 << 30, Free: 1 << 29, Used: 1024, MinFree: 1 << 20, Pressure: true, Error: "unsupported"}
+	memory := MemoryUsage{Ceiling: 256 << 20, Used: 4096, Spilled: 1024, Dropped: 0, Buffers: []BufferMemoryUsage{{Name: InternalLogName, Bytes: 4096}}}
+
+	return map[string]interface{}{
+		"search": APIResponse{
+			Result: ScanResult{
+				FilePath:       "/var/log/app.log",
+				Host:           "",
+				Type:           TypeFile,
+				MatchPattern:   "ERROR",
+				Total:          1,
+				Lines:          []LineResult{line},
+				Sources:        []LineSource{source},
+				Sample:         &SampleInfo{Rate: 0.1, Every: 2, SampledLines: 10, SampledMatches: 1, EstimatedTotal: 10, Margin: 3, Exact: false},
+				TimeRange:      timeRange,
+				Warnings:       warnings,
+				PatternLimited: true,
+				PatternCost:    &PatternCost{ProgramSize: 12, LeadingWildcard: true, Alternations: 3, Cost: 48, Literal: false},
+			},
+			FilePaths: []FileInfo{fileInfo},
+		},
+		"bytes": ByteWindowResult{FilePath: "/var/log/app.log", Host: "", Type: TypeFile, Offset: 0, End: 64, FileSize: 64, Content: "line\n", NextOffset: 64, PrevOffset: 0, EOF: true, Matches: []int64{21}},
+		"files": FileListResponse{
+			FilePaths: []FileInfo{fileInfo},
+			Groups:    []FileGroup{{Name: TypeFile, Count: 1, FilePaths: []FileInfo{fileInfo}}},
+			Total:     1,
+		},
+		"anchor": AnchorResult{FilePath: "/var/log/app.log", Host: "", Type: TypeFile, LineNumber: 2, Anchor: "1-abc-2", Rotated: true},
+		"line":   line,
+		"alerts_status": AlertsStatusResponse{Notifiers: []NotifierStatus{
+			{Name: "ops", Type: NotifierTypeEmail, Attempts: 2, Failures: 1, LastAttemptAt: finished, LastSuccessAt: at, LastError: "timeout"},
+		}, Rules: []AlertRuleStatus{
+			{Name: "fatal", Pattern: "FATAL|panic", URL: "https://hooks.example.com/REDACTED", Notifiers: []string{"fatal"}, Cooldown: 300, Triggers: 2, Suppressed: 5, LastTriggerAt: &finished,
+				LastFilePath: "/var/log/app.log", LastLineNumber: 42, LastDeliveryError: "webhook answered 502 Bad Gateway"},
+		}},
+		"self_report": SelfReport{
+			SchemaVersion: SelfReportSchemaVersion,
+			Version:       "v1.0.0",
+			APIVersion:    APIVersion,
+			StartedAt:     at,
+			UptimeSeconds: 60,
+			Sources:       map[string]int{TypeFile: 1, TypeSSH: 2},
+			Files:         map[string]int{TypeFile: 3},
+			Health:        SelfReportHealth{FailingSources: 1, PendingSources: 1, CorruptFiles: 1, Reads: 2, Tails: 1, RunningJobs: 1, DiskPressure: true},
+		},
+		"metrics": MetricsResponse{InFlight: LimiterInFlight{Scans: 1, Reads: map[string]int{"local": 1}, Tails: 1}, Disk: []DiskUsage{disk}, Memory: memory},
+		"sources": SourcesResponse{
+			Sources: []SourceStatus{{Source: "/var/log/*.log", Type: TypeSSH, Host: "box1", Files: 0, Error: "denied", CheckedAt: at, Pending: true, Logs: []string{"level=ERROR host=box1"}, HealthCheck: &healthCheck}},
+			Disk:    []DiskUsage{disk},
+		},
+		"deep_health": DeepHealthResponse{Status: HealthPartial, Checks: []HealthCheck{healthCheck}},
+		"jobs":        JobsResponse{Jobs: []Job{job}},
+		"job":         job,
+		"version":     VersionResponse{Version: "v1.2.3", APIVersion: APIVersion, Capabilities: VersionCapabilities{ReadOnly: true, Mutations: false}, Memory: memory},
+		"capabilities": Capabilities{
+			SchemaVersion: CapabilitiesSchemaVersion,
+			Version:       "v1.2.3",
+			Features:      []string{FeatureRegexSearch},
+			Limits:        CapabilitiesLimits{MaxPageSize: 100, MaxLineLength: 1000, MaxReads: 4, MaxReadsPerHost: 2, MaxTails: 8, MaxPatternCost: 5000, PatternLimit: PatternLimitSample, MaxMergeFiles: 20},
+			ExportFormats: []string{},
+			Streaming:     true,
+			AuthMode:      AuthModeNone,
+			ReadOnly:      true,
+			Classification: CapabilitiesClassification{
+				Classes:             []string{ClassError},
+				Rules:               []ClassRule{{Class: ClassError, Keywords: []string{"error"}}},
+				MaxWords:            8,
+				StackStarts:         []string{"at "},
+				StackIndentedStarts: []string{"File "},
+				AccessParsers:       []string{"common"},
+			},
+			Processors: []string{ProcessorBase64JSON},
+		},
+		"reload": ConfigReload{
+			Added: []string{"/var/log/new.log"}, Removed: []string{"/var/log/old.log"},
+			FilesAdded:      []LineSource{{FilePath: "/var/log/new.log", Type: TypeFile}},
+			FilesRemoved:    []LineSource{{FilePath: "/var/log/old.log", Type: TypeFile}},
+			RestartRequired: []string{"port"},
+		},
+		"tail_sources":  []LineSource{source},
+		"tail_line":     TailEvent{Type: TailEventLine, LineNumber: 3, Content: "INFO started", Class: ClassInfo, Generation: 1, Source: 0, Seq: 7},
+		"tail_reopened": TailEvent{Type: TailEventReopened, Generation: 2, Source: 0, Target: "/data/app-2024-06-02.log"},
+		"replay_start":  ReplayStart{JobID: "j1", Sources: []LineSource{source}, TimeRange: timeRange},
+		"replay_line":   ReplayLine{LineNumber: 2, Content: "ERROR failed", Date: "2024-06-01 12:00:00 +0000 UTC", Class: ClassError, Source: 0, DelayMs: 1000},
+		"replay_end":    ReplayEnd{Lines: 4, Warnings: warnings},
+		"diff": DiffResult{
+			A:       DiffSource{FilePath: "/var/log/app.log", Host: "", Type: TypeFile, Lines: 4, TimeRange: timeRange, Warnings: warnings},
+			B:       DiffSource{FilePath: "/var/log/app.log", Host: "box1", Type: TypeSSH, Lines: 2},
+			Added:   DiffSet{Distinct: 1, Lines: 2, Examples: []DiffExample{{Kind: DiffAdded, Template: "<ts> ERROR key <n>", Content: "2024-06-01T12:00:00Z ERROR key 42", CountA: 2, CountB: 0}}},
+			Removed: DiffSet{Distinct: 1, Lines: 1, Examples: []DiffExample{{Kind: DiffRemoved, Template: "<ts> WARN slow disk", Content: "2024-06-01T12:00:00Z WARN slow disk", CountA: 0, CountB: 1}}},
+			Common:  DiffSet{Distinct: 1, Lines: 2, Examples: []DiffExample{{Kind: DiffCommon, Template: "<ts> INFO started", Content: "2024-06-01T12:00:00Z INFO started", CountA: 2, CountB: 1}}},
+		},
+		"error": HTTPErrorResponse{Error: "file not found"},
+		"error_file_not_allowed": HTTPErrorResponse{Error: &FileAccessError{
+			Code: ErrorCodeFileNotAllowed, FilePath: "/var/log/../../etc/passwd", Type: TypeSSH, Host: "box1",
+		}},
+	}
+}
+
+// TestResponseGolden fails when a response field is renamed, removed or changes type.
+// Such a change breaks API consumers, bump APIVersion and run go test ./pkg -run Golden -update.
+func TestResponseGolden(t *testing.T) {
+	for name, fixture := range responseFixtures() {
+		t.Run(name, func(t *testing.T) {
+			assertGolden(t, name, fixture)
+		})
+	}
+}
+
+func TestOpenAPIGolden(t *testing.T) {
+	assertGolden(t, "openapi", NewOpenAPI(APIRoutes, "/"))
+}
+
+func assertGolden(t *testing.T, name string, value interface{}) {
+	got, err := json.MarshalIndent(value, "", "  ")
+	assert.NoError(t, err)
+	golden := filepath.Join("testdata", "golden", name+".json")
+	if *updateGolden {
+		assert.NoError(t, os.MkdirAll(filepath.Dir(golden), 0755))
+		assert.NoError(t, os.WriteFile(golden, append(got, '\n'), 0600))
+		return
+	}
+	want, err := os.ReadFile(golden)
+	assert.NoError(t, err)
+	assert.Equal(t, string(want), string(got)+"\n")
+}
+
+func TestOpenAPI_DocumentsEveryRoute(t *testing.T) {
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	openAPI := NewOpenAPI(APIRoutes, "/")
+	for _, route := range e.Routes() {
+		if !strings.HasPrefix(route.Path, "/api") {
+			continue
+		}
+		path := pathParam.ReplaceAllString(route.Path, "{$1}")
+		_, ok := openAPI.Paths[path][strings.ToLower(route.Method)]
+		assert.True(t, ok, "%s %s is not in APIRoutes", route.Method, route.Path)
+	}
+	assert.Len(t, e.Routes(), len(APIRoutes)+1)
+}
+
+func TestOpenAPIHandler(t *testing.T) {
+	e := newTestEcho(&EchoOptions{BaseURL: "/logs/", Compression: CompressionOff})
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/logs/api/openapi.json", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	var openAPI OpenAPI
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &openAPI))
+	assert.Equal(t, "/logs/", openAPI.Servers[0].URL)
+	assert.Equal(t, APIVersion, openAPI.Info.Version)
+
+	search := openAPI.Paths["/api"]["get"]
+	assert.Equal(t, openAPISchemaPrefix+"APIResponse", search.Responses["200"].Content["application/json"].Schema.Ref)
+	assert.Equal(t, openAPISchemaPrefix+"HTTPErrorResponse", search.Responses["default"].Content["application/json"].Schema.Ref)
+	assert.Contains(t, search.Parameters, OpenAPIParameter{Name: "page", In: "query", Required: true, Schema: &OpenAPISchema{Type: "integer"}})
+
+	cancel := openAPI.Paths["/api/jobs/{id}"]["delete"]
+	assert.Equal(t, OpenAPIParameter{Name: "id", In: "path", Required: true, Schema: &OpenAPISchema{Type: "string"}}, cancel.Parameters[0])
+	assert.Equal(t, []map[string][]string{{openAPIAdminScheme: {}}}, cancel.Security)
+
+	fileInfo := openAPI.Components.Schemas["FileInfo"]
+	assert.Equal(t, openAPISchemaPrefix+"FileInfo", fileInfo.Properties["segments"].Items.Ref)
+	assert.Contains(t, fileInfo.Required, "file_path")
+	assert.NotContains(t, fileInfo.Required, "segments")
+	assert.Equal(t, &OpenAPISchema{Type: "string", Format: "date-time", Nullable: true}, openAPI.Components.Schemas["Job"].Properties["finished_at"])
+}
@@ -0,0 +1,244 @@
+package pkg
+
+import (
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// pathWatchDebounce is how long a rescan waits for more events after one, a rotation comes as a few
+const pathWatchDebounce = 100 * time.Millisecond
+
+// PathWatcher watches the directories of local patterns with fsnotify, so that files created, removed or
+// renamed are listed without waiting for the next rescan. A directory that does not exist, or is removed,
+// is waited for by watching its closest existing parent.
+type PathWatcher struct {
+	watcher  *fsnotify.Watcher
+	mutex    sync.Mutex
+	patterns []string
+	dirs     map[string]bool
+	written  bool
+	listed   chan struct{}
+}
+
+// NewPathWatcher starts a watcher, which watches nothing until Watch is called
+func NewPathWatcher() (*PathWatcher, error) {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	w := &PathWatcher{
+		watcher: watcher,
+		dirs:    map[string]bool{},
+		listed:  make(chan struct{}, 1),
+	}
+	go w.run()
+	return w, nil
+}
+
+// Watch replaces the watched patterns, their labels are dropped and HTTP URLs skipped
+func (w *PathWatcher) Watch(patterns []string) {
+	w.mutex.Lock()
+	w.patterns = make([]string, 0, len(patterns))
+	for _, pattern := range patterns {
+		_, pattern = ParseFilePattern(pattern)
+		if IsHTTPURL(pattern) {
+			continue
+		}
+		w.patterns = append(w.patterns, pattern)
+	}
+	w.mutex.Unlock()
+	w.sync()
+}
+
+// Listed receives once files matching the patterns were created, removed, renamed or truncated
+func (w *PathWatcher) Listed() <-chan struct{} {
+	return w.listed
+}
+
+// TakeWritten tells whether a file matching the patterns was written to since the last call
+func (w *PathWatcher) TakeWritten() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	written := w.written
+	w.written = false
+	return written
+}
+
+// Dirs are the watched directories
+func (w *PathWatcher) Dirs() []string {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	dirs := make([]string, 0, len(w.dirs))
+	for dir := range w.dirs {
+		dirs = append(dirs, dir)
+	}
+	return dirs
+}
+
+func (w *PathWatcher) Close() error {
+	return w.watcher.Close()
+}
+
+func (w *PathWatcher) run() {
+	var debounce <-chan time.Time
+	for {
+		select {
+		case event, ok := <-w.watcher.Events:
+			if !ok {
+				return
+			}
+			if !event.Has(fsnotify.Create) && !event.Has(fsnotify.Remove) && !event.Has(fsnotify.Rename) {
+				if !event.Has(fsnotify.Write) || !w.matches(event.Name) {
+					continue
+				}
+				// a truncation, as by copytruncate rotation, is rescanned at once for its stats to be reset
+				if truncated(event.Name) {
+					slog.Info("File truncated", "filePath", event.Name)
+					if debounce == nil {
+						debounce = GlobalClock.After(pathWatchDebounce)
+					}
+					continue
+				}
+				w.mutex.Lock()
+				w.written = true
+				w.mutex.Unlock()
+				continue
+			}
+			// a removed directory is no longer watched, even when one is created again under its name
+			w.mutex.Lock()
+			delete(w.dirs, event.Name)
+			w.mutex.Unlock()
+			if (w.sync() || w.matches(event.Name)) && debounce == nil {
+				debounce = GlobalClock.After(pathWatchDebounce)
+			}
+		case <-debounce:
+			debounce = nil
+			select {
+			case w.listed <- struct{}{}:
+			default:
+			}
+		case err, ok := <-w.watcher.Errors:
+			if !ok {
+				return
+			}
+			slog.Warn("watching file paths", "error", err)
+		}
+	}
+}
+
+// truncated tells whether filePath is smaller than when its stats were last counted
+func truncated(filePath string) bool {
+	entry, ok := GlobalFileStatsCache.Peek(filePath)
+	if !ok {
+		return false
+	}
+	info, err := os.Stat(filePath)
+	return err == nil && info.Size() < entry.Size
+}
+
+// matches tells whether filePath is matched by a pattern, or is in a directory given as one
+func (w *PathWatcher) matches(filePath string) bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	for _, pattern := range w.patterns {
+		if matched, err := filepath.Match(pattern, filePath); err == nil && matched {
+			return true
+		}
+		if strings.HasPrefix(filePath, filepath.Clean(pattern)+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// sync watches the directories of the patterns and stops watching the others, reporting any change
+func (w *PathWatcher) sync() bool {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	wanted := map[string]bool{}
+	for _, pattern := range w.patterns {
+		for _, dir := range patternDirs(pattern) {
+			wanted[existingDir(dir)] = true
+		}
+	}
+	changed := false
+	for dir := range w.dirs {
+		if !wanted[dir] {
+			// the watch of a removed directory is gone already
+			_ = w.watcher.Remove(dir)
+			delete(w.dirs, dir)
+			changed = true
+		}
+	}
+	for dir := range wanted {
+		if w.dirs[dir] {
+			continue
+		}
+		if err := w.watcher.Add(dir); err != nil {
+			slog.Warn("watching directory", "dir", dir, "error", err)
+			continue
+		}
+		w.dirs[dir] = true
+		changed = true
+	}
+	return changed
+}
+
+// patternDirs are the directories files matching pattern are created in: the directory of a file
+// pattern and those matching it when it has wildcards, or a directory given as a pattern and all
+// the directories under it
+func patternDirs(pattern string) []string {
+	pattern = filepath.Clean(pattern)
+	if info, err := os.Stat(pattern); err == nil && info.IsDir() {
+		dirs := []string{}
+		_ = filepath.WalkDir(pattern, func(path string, d fs.DirEntry, err error) error {
+			if err == nil && d.IsDir() {
+				dirs = append(dirs, path)
+			}
+			return nil
+		})
+		return dirs
+	}
+	dir := filepath.Dir(pattern)
+	if !hasGlobMeta(dir) {
+		return []string{dir}
+	}
+	// the parent without wildcards is watched for the directories created in it
+	static := dir
+	for hasGlobMeta(static) {
+		static = filepath.Dir(static)
+	}
+	dirs := []string{static}
+	matches, _ := filepath.Glob(dir)
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && info.IsDir() {
+			dirs = append(dirs, match)
+		}
+	}
+	return dirs
+}
+
+func hasGlobMeta(path string) bool {
+	return strings.ContainsAny(path, `*?[\`)
+}
+
+// existingDir is dir, or its closest parent that exists
+func existingDir(dir string) string {
+	for {
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
@@ -0,0 +1,144 @@
+package pkg
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatternDirs(t *testing.T) {
+	dir := t.TempDir()
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "a", "nested"), 0700))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "b"), 0700))
+
+	assert.Equal(t, []string{dir}, patternDirs(filepath.Join(dir, "*.log")))
+	assert.Equal(t, []string{dir, filepath.Join(dir, "a"), filepath.Join(dir, "b")}, patternDirs(filepath.Join(dir, "*", "*.log")))
+	assert.Equal(t, []string{filepath.Join(dir, "a"), filepath.Join(dir, "a", "nested")}, patternDirs(filepath.Join(dir, "a")))
+	// a missing directory is waited for in its parent
+	assert.Equal(t, dir, existingDir(filepath.Join(dir, "missing", "deeper")))
+}
+
+func TestPathWatcher(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	defer func(c Clock) { GlobalClock = c }(GlobalClock)
+	GlobalClock = clock
+	dir := t.TempDir()
+	appDir := filepath.Join(dir, "app")
+
+	w, err := NewPathWatcher()
+	assert.NoError(t, err)
+	defer w.Close()
+	w.Watch([]string{filepath.Join(appDir, "*.log")})
+	assert.Equal(t, []string{dir}, w.Dirs())
+	listed := func() {
+		assert.Eventually(t, func() bool {
+			clock.Advance(pathWatchDebounce)
+			select {
+			case <-w.Listed():
+				return true
+			default:
+				return false
+			}
+		}, time.Second, 5*time.Millisecond)
+	}
+	watching := func(dir string) {
+		assert.Eventually(t, func() bool { return slices.Contains(w.Dirs(), dir) }, time.Second, time.Millisecond)
+	}
+
+	// the directory is watched once created
+	assert.NoError(t, os.Mkdir(appDir, 0700))
+	watching(appDir)
+	listed()
+	assert.NoError(t, os.WriteFile(filepath.Join(appDir, "a.log"), []byte("1\n"), 0600))
+	listed()
+	assert.Eventually(t, w.TakeWritten, time.Second, time.Millisecond)
+
+	// and again once removed and created again
+	assert.NoError(t, os.RemoveAll(appDir))
+	assert.Eventually(t, func() bool { return slices.Equal(w.Dirs(), []string{dir}) }, time.Second, time.Millisecond)
+	assert.NoError(t, os.Mkdir(appDir, 0700))
+	watching(appDir)
+	listed()
+	w.TakeWritten()
+	assert.NoError(t, os.WriteFile(filepath.Join(appDir, "b.log"), []byte("1\n"), 0600))
+	listed()
+	assert.Eventually(t, w.TakeWritten, time.Second, time.Millisecond)
+
+	// files not matching the patterns are not listed
+	w.TakeWritten()
+	assert.NoError(t, os.WriteFile(filepath.Join(appDir, "b.txt"), []byte("1\n"), 0600))
+	time.Sleep(50 * time.Millisecond)
+	assert.False(t, w.TakeWritten())
+}
+
+func TestWatchFilePaths_PathWatcher(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	defer func(c Clock) { GlobalClock = c }(GlobalClock)
+	GlobalClock = clock
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	defer GlobalDiscoveredSources.SetLocal(nil, nil)
+	watcher, err := NewPathWatcher()
+	assert.NoError(t, err)
+	defer func() { GlobalPathWatcher = nil }()
+	GlobalPathWatcher = watcher
+	defer watcher.Close()
+	dir := t.TempDir()
+
+	done := make(chan struct{})
+	go func() {
+		WatchFilePaths(time.Hour, SliceFlags{filepath.Join(dir, "*.log")}, nil, nil, 10)
+		close(done)
+	}()
+	assert.Eventually(t, func() bool { return clock.Tickers() == 1 }, time.Second, time.Millisecond)
+
+	// listed without waiting for the next tick
+	logFile := filepath.Join(dir, "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte("1\n"), 0600))
+	assert.Eventually(t, func() bool {
+		clock.Advance(pathWatchDebounce)
+		filePaths := GlobalFileRegistry.Snapshot()
+		return len(filePaths) == 1 && filePaths[0].FilePath == logFile
+	}, time.Second, 5*time.Millisecond)
+
+	assert.NoError(t, os.Remove(logFile))
+	assert.Eventually(t, func() bool {
+		clock.Advance(pathWatchDebounce)
+		return len(GlobalFileRegistry.Snapshot()) == 0
+	}, time.Second, 5*time.Millisecond)
+
+	clock.Close()
+	<-done
+}
+
+func TestPathWatcher_Truncation(t *testing.T) {
+	clock := NewManualClock(time.Unix(0, 0))
+	defer func(c Clock) { GlobalClock = c }(GlobalClock)
+	GlobalClock = clock
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte("1\n2\n3\n"), 0600))
+	_, _, err := FileStatsContext(context.Background(), logFile, false, nil)
+	assert.NoError(t, err)
+
+	w, err := NewPathWatcher()
+	assert.NoError(t, err)
+	defer w.Close()
+	w.Watch([]string{logFile})
+
+	// copytruncate empties the file in place, it is listed again at once instead of on the next tick
+	assert.NoError(t, os.Truncate(logFile, 0))
+	assert.Eventually(t, func() bool {
+		clock.Advance(pathWatchDebounce)
+		select {
+		case <-w.Listed():
+			return true
+		default:
+			return false
+		}
+	}, time.Second, 5*time.Millisecond)
+	assert.False(t, w.TakeWritten())
+}
@@ -0,0 +1,95 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/kevincobain2000/gol/pkg/search"
+)
+
+const (
+	// DefaultMaxPatternCost lets through alternations of a few hundred words, 0 disables the check
+	DefaultMaxPatternCost = 5000
+	// DefaultPatternSampleRate is the fraction of lines a limited pattern is tested against
+	DefaultPatternSampleRate = 0.1
+
+	// PatternLimitReject answers patterns over the max cost with a 400
+	PatternLimitReject = "reject"
+	// PatternLimitSample scans only a sample of the lines with patterns over the max cost
+	PatternLimitSample = "sample"
+)
+
+// ErrPatternTooComplex is returned for search patterns estimated to cost more than the max cost
+var ErrPatternTooComplex = errors.New("pattern is too complex")
+
+// PatternCost is the estimated cost of matching a regex against one line.
+//
+// Deprecated: use search.PatternCost.
+type PatternCost = search.PatternCost
+
+// AnalyzePattern estimates the cost of pattern.
+//
+// Deprecated: use search.AnalyzePattern.
+func AnalyzePattern(pattern string) PatternCost {
+	return search.AnalyzePattern(pattern)
+}
+
+// PatternLimits are the max cost of the search patterns and what happens to the patterns over it
+type PatternLimits struct {
+	// MaxCost of a pattern, 0 disables the check
+	MaxCost int
+	// Mode is PatternLimitReject or PatternLimitSample
+	Mode string
+	// SampleRate is the fraction of the lines tested against limited patterns
+	SampleRate float64
+}
+
+var DefaultPatternLimits = PatternLimits{
+	MaxCost:    DefaultMaxPatternCost,
+	Mode:       PatternLimitSample,
+	SampleRate: DefaultPatternSampleRate,
+}
+
+func (l PatternLimits) Validate() error {
+	if l.MaxCost < 0 {
+		return fmt.Errorf("max-pattern-cost must be 0 or more, got %d", l.MaxCost)
+	}
+	if l.MaxCost == 0 {
+		return nil
+	}
+	switch l.Mode {
+	case PatternLimitReject:
+	case PatternLimitSample:
+		if l.SampleRate <= 0 || l.SampleRate > 1 {
+			return fmt.Errorf("pattern-sample must be a fraction in (0, 1], got %g", l.SampleRate)
+		}
+	default:
+		return fmt.Errorf("pattern-limit must be %s or %s, got %q", PatternLimitReject, PatternLimitSample, l.Mode)
+	}
+	return nil
+}
+
+// Check returns the cost of the most expensive of patterns and an error wrapping ErrPatternTooComplex,
+// with what to simplify, when it is over the max cost
+func (l PatternLimits) Check(patterns ...string) (PatternCost, error) {
+	var highest PatternCost
+	for i, pattern := range patterns {
+		if cost := AnalyzePattern(pattern); i == 0 || cost.Cost > highest.Cost {
+			highest = cost
+		}
+	}
+	if l.MaxCost <= 0 || highest.Cost <= l.MaxCost {
+		return highest, nil
+	}
+	return highest, fmt.Errorf("%w: estimated cost %d is over %d, %s",
+		ErrPatternTooComplex, highest.Cost, l.MaxCost, strings.Join(highest.Advice(), "; "))
+}
+
+// Sampler is the sampler a limited pattern is scanned with, the requested one when it samples fewer lines
+func (l PatternLimits) Sampler(requested *Sampler) *Sampler {
+	if requested != nil && requested.Rate > 0 && requested.Rate <= l.SampleRate {
+		return requested
+	}
+	return &Sampler{Rate: l.SampleRate}
+}
@@ -0,0 +1,84 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPatternLimits_Check(t *testing.T) {
+	limits := PatternLimits{MaxCost: 10, Mode: PatternLimitReject}
+
+	cost, err := limits.Check("timeout", "")
+	assert.NoError(t, err)
+	assert.True(t, cost.Literal)
+
+	cost, err = limits.Check("timeout", "error|warn|fatal")
+	assert.ErrorIs(t, err, ErrPatternTooComplex)
+	assert.ErrorContains(t, err, "cut down the 3 alternatives, or anchor the pattern with ^")
+	assert.Equal(t, 3, cost.Alternations)
+
+	_, err = PatternLimits{}.Check("error|warn|fatal")
+	assert.NoError(t, err)
+}
+
+func TestPatternLimits_Validate(t *testing.T) {
+	assert.NoError(t, DefaultPatternLimits.Validate())
+	assert.NoError(t, PatternLimits{}.Validate())
+	assert.ErrorContains(t, PatternLimits{MaxCost: 10, Mode: "drop"}.Validate(), `pattern-limit must be reject or sample, got "drop"`)
+	assert.ErrorContains(t, PatternLimits{MaxCost: 10, Mode: PatternLimitSample, SampleRate: 2}.Validate(), "pattern-sample must be a fraction in (0, 1]")
+	assert.ErrorContains(t, PatternLimits{MaxCost: -1}.Validate(), "max-pattern-cost must be 0 or more")
+}
+
+func TestPatternLimits_Sampler(t *testing.T) {
+	limits := PatternLimits{MaxCost: 10, Mode: PatternLimitSample, SampleRate: 0.1}
+	assert.Equal(t, 0.1, limits.Sampler(nil).Rate)
+	assert.Equal(t, 0.1, limits.Sampler(&Sampler{Every: 5}).Rate)
+	assert.Equal(t, 0.1, limits.Sampler(&Sampler{Rate: 0.5}).Rate)
+	assert.Equal(t, 0.05, limits.Sampler(&Sampler{Rate: 0.05}).Rate)
+}
+
+func TestAPIHandler_Get_PatternLimits(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "test.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte("INFO Starting service\nERROR An error occurred\nWARN Disk almost full\n"), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: logFile, LinesCount: 3, Type: TypeFile}})
+	previous := GlobalPatternLimits
+	t.Cleanup(func() { GlobalPatternLimits = previous })
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		target := "/api?type=file&file_path=" + url.QueryEscape(logFile) + "&query=" + url.QueryEscape(query)
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, target, nil))
+		return rec
+	}
+
+	GlobalPatternLimits = PatternLimits{MaxCost: 10, Mode: PatternLimitReject}
+	rec := get("ERROR|WARN|FATAL")
+	assert.Equal(t, http.StatusBadRequest, rec.Code)
+	assert.Contains(t, rec.Body.String(), "pattern is too complex")
+	// the literal fast path is never limited
+	assert.Equal(t, http.StatusOK, get("ERROR").Code)
+
+	GlobalPatternLimits = PatternLimits{MaxCost: 10, Mode: PatternLimitSample, SampleRate: 1}
+	rec = get("ERROR|WARN|FATAL")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var response APIResponse
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.True(t, response.Result.PatternLimited)
+	assert.Equal(t, 3, response.Result.PatternCost.Alternations)
+	assert.NotNil(t, response.Result.Sample)
+	assert.Equal(t, 2, response.Result.Total)
+
+	rec = get("ERROR")
+	response = APIResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &response))
+	assert.False(t, response.Result.PatternLimited)
+	assert.Nil(t, response.Result.Sample)
+}
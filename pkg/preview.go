@@ -0,0 +1,207 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/acarl005/stripansi"
+)
+
+const (
+	// PreviewAll previews the remote sources too, which costs a command per file
+	PreviewAll = "all"
+
+	PreviewSkippedBudget      = "budget"
+	PreviewSkippedRemote      = "remote"
+	PreviewSkippedUnsupported = "unsupported"
+
+	// DefaultPreviewBudget is the time the previews of a whole file listing may take
+	DefaultPreviewBudget = 500 * time.Millisecond
+	// PreviewLines is the number of last lines of a file previewed
+	PreviewLines = 3
+	// PreviewMaxLineLength is the number of bytes of a previewed line
+	PreviewMaxLineLength = 200
+
+	previewReadChunk = 4 * 1024
+	previewMaxRead   = 64 * 1024
+)
+
+// FilePreview is the end of a file, shown in the file list to tell similar files apart
+type FilePreview struct {
+	Lines []string `json:"lines"`
+	// Skipped is why the file was not previewed: budget, remote or unsupported
+	Skipped string `json:"skipped,omitempty"`
+}
+
+// Previews caches the previews of files by size and modification time
+type Previews struct {
+	mutex   sync.Mutex
+	budget  time.Duration
+	entries map[string]previewEntry
+}
+
+type previewEntry struct {
+	size    int64
+	modTime time.Time
+	lines   []string
+}
+
+func NewPreviews(budget time.Duration) *Previews {
+	return &Previews{
+		budget:  budget,
+		entries: map[string]previewEntry{},
+	}
+}
+
+// Add sets the preview of the files, within the budget for all of them. A file that is not cached
+// once the budget is spent is marked as skipped. Remote sources are previewed only when all is set.
+func (p *Previews) Add(ctx context.Context, fileInfos []FileInfo, all bool, findSSHConfig func(host string) *SSHPathConfig) {
+	ctx, cancel := context.WithTimeout(ctx, p.budget)
+	defer cancel()
+	for i := range fileInfos {
+		fileInfos[i].Preview = p.preview(ctx, fileInfos[i], all, findSSHConfig)
+	}
+}
+
+func (p *Previews) preview(ctx context.Context, fileInfo FileInfo, all bool, findSSHConfig func(host string) *SSHPathConfig) *FilePreview {
+	switch {
+	case fileInfo.Type == TypeFile || fileInfo.Type == TypeStdin || fileInfo.Type == TypeK8s || fileInfo.Type == TypeJournal || (fileInfo.Type == TypeDocker && strings.HasPrefix(fileInfo.FilePath, TmpContainerPath)):
+		return p.localPreview(ctx, fileInfo)
+	case fileInfo.Type == TypeSSH && !all:
+		return &FilePreview{Lines: []string{}, Skipped: PreviewSkippedRemote}
+	case fileInfo.Type == TypeSSH:
+		sshConfig := findSSHConfig(fileInfo.Host)
+		if sshConfig == nil {
+			return &FilePreview{Lines: []string{}, Skipped: PreviewSkippedUnsupported}
+		}
+		return p.sshPreview(ctx, fileInfo, sshConfig.ToSSHConfig())
+	}
+	return &FilePreview{Lines: []string{}, Skipped: PreviewSkippedUnsupported}
+}
+
+func (p *Previews) localPreview(ctx context.Context, fileInfo FileInfo) *FilePreview {
+	info, err := GlobalFileOpener.Stat(fileInfo.FilePath)
+	if err != nil {
+		return &FilePreview{Lines: []string{}, Skipped: PreviewSkippedUnsupported}
+	}
+	key := fileInfo.Type + "|" + fileInfo.FilePath
+	if lines, ok := p.get(key, info.Size(), info.ModTime()); ok {
+		return &FilePreview{Lines: lines}
+	}
+	if ctx.Err() != nil {
+		return &FilePreview{Lines: []string{}, Skipped: PreviewSkippedBudget}
+	}
+	file, err := GlobalFileOpener.Open(fileInfo.FilePath)
+	if err != nil {
+		return &FilePreview{Lines: []string{}, Skipped: PreviewSkippedUnsupported}
+	}
+	defer file.Close()
+	// the end of a compressed file is only reached by decompressing all of it
+	header := make([]byte, compressionSniffBytes)
+	if n, _ := file.ReadAt(header, 0); IsCompressed(header[:n]) {
+		return &FilePreview{Lines: []string{}, Skipped: PreviewSkippedUnsupported}
+	}
+	lines, err := LastLines(ctx, file, info.Size(), PreviewLines)
+	if err != nil {
+		return &FilePreview{Lines: []string{}, Skipped: previewSkipped(err)}
+	}
+	lines = previewLines(lines)
+	p.set(key, info.Size(), info.ModTime(), lines)
+	return &FilePreview{Lines: lines}
+}
+
+// sshPreview runs tail on the host, its cache entry is checked against the size of the last listing
+func (p *Previews) sshPreview(ctx context.Context, fileInfo FileInfo, sshConfig *SSHConfig) *FilePreview {
+	key := fileInfo.Type + "|" + fileInfo.Host + "|" + fileInfo.FilePath
+	if lines, ok := p.get(key, fileInfo.FileSize, time.Time{}); ok {
+		return &FilePreview{Lines: lines}
+	}
+	if ctx.Err() != nil {
+		return &FilePreview{Lines: []string{}, Skipped: PreviewSkippedBudget}
+	}
+	output, err := GlobalRemoteRunner.Run(ctx, sshConfig, fmt.Sprintf("tail -n %d %s", PreviewLines, fileInfo.FilePath))
+	if err != nil {
+		return &FilePreview{Lines: []string{}, Skipped: previewSkipped(err)}
+	}
+	lines := previewLines(splitPreview(output, PreviewLines))
+	p.set(key, fileInfo.FileSize, time.Time{}, lines)
+	return &FilePreview{Lines: lines}
+}
+
+func previewSkipped(err error) string {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return PreviewSkippedBudget
+	}
+	return PreviewSkippedUnsupported
+}
+
+func (p *Previews) get(key string, size int64, modTime time.Time) ([]string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	entry, ok := p.entries[key]
+	if !ok || entry.size != size || !entry.modTime.Equal(modTime) {
+		return nil, false
+	}
+	return entry.lines, true
+}
+
+func (p *Previews) set(key string, size int64, modTime time.Time, lines []string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.entries[key] = previewEntry{size: size, modTime: modTime, lines: lines}
+}
+
+// LastLines reads the last n lines of a file of size bytes from its end, a chunk at a time, so that
+// only the end of a large file is read. At most previewMaxRead bytes are read, a longer line is cut
+// at its start.
+func LastLines(ctx context.Context, file io.ReaderAt, size int64, n int) ([]string, error) {
+	buf := []byte{}
+	offset := size
+	for offset > 0 && len(buf) < previewMaxRead {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+		chunk := min(int64(previewReadChunk), offset)
+		offset -= chunk
+		b := make([]byte, chunk)
+		if _, err := file.ReadAt(b, offset); err != nil && !errors.Is(err, io.EOF) {
+			return nil, err
+		}
+		buf = append(b, buf...)
+		// the newline ending the last line does not count, n more are needed before it
+		if bytes.Count(bytes.TrimSuffix(buf, []byte("\n")), []byte("\n")) >= n {
+			break
+		}
+	}
+	return splitPreview(buf, n), nil
+}
+
+// splitPreview returns the last n lines of b
+func splitPreview(b []byte, n int) []string {
+	b = bytes.TrimSuffix(b, []byte("\n"))
+	if len(b) == 0 {
+		return []string{}
+	}
+	lines := strings.Split(string(b), "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines
+}
+
+// previewLines strips the colors and carriage returns of the lines and truncates them
+func previewLines(lines []string) []string {
+	previewed := make([]string, 0, len(lines))
+	for _, content := range lines {
+		line := LineResult{Content: strings.TrimSuffix(stripansi.Strip(content), "\r")}
+		TruncateLine(&line, PreviewMaxLineLength, nil)
+		previewed = append(previewed, line.Content)
+	}
+	return previewed
+}
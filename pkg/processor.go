@@ -0,0 +1,167 @@
+package pkg
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ProcessorBase64JSON is the name of the example processor decoding lines of base64 encoded JSON
+const ProcessorBase64JSON = "base64json"
+
+var ErrProcessorExists = errors.New("processor already registered")
+
+// LineProcessor turns the lines of a format no generic parser understands into text and fields.
+// Processors are called from concurrent reads, they must be safe for concurrent use.
+type LineProcessor interface {
+	Name() string
+	// Process returns false for a line it does not understand, the line is then kept as raw text
+	Process(line []byte) (ProcessedLine, bool)
+}
+
+// ProcessedLine is what a processor made of a line
+type ProcessedLine struct {
+	// Text is displayed and searched instead of the raw line
+	Text string
+	// Fields are filtered on with field=key=value
+	Fields map[string]string
+}
+
+// Processors are the line processors requests can select by name
+type Processors struct {
+	mutex      sync.RWMutex
+	processors map[string]LineProcessor
+}
+
+func NewProcessors(processors ...LineProcessor) *Processors {
+	p := &Processors{processors: map[string]LineProcessor{}}
+	for _, processor := range processors {
+		p.processors[processor.Name()] = processor
+	}
+	return p
+}
+
+func (p *Processors) Register(processor LineProcessor) error {
+	name := processor.Name()
+	if name == "" {
+		return errors.New("processor name is required")
+	}
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if _, ok := p.processors[name]; ok {
+		return fmt.Errorf("%w: %s", ErrProcessorExists, name)
+	}
+	p.processors[name] = processor
+	return nil
+}
+
+func (p *Processors) Get(name string) (LineProcessor, bool) {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	processor, ok := p.processors[name]
+	return processor, ok
+}
+
+// Names are the names of the registered processors, sorted
+func (p *Processors) Names() []string {
+	p.mutex.RLock()
+	defer p.mutex.RUnlock()
+	names := make([]string, 0, len(p.processors))
+	for name := range p.processors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// RegisterProcessor makes processor selectable with processor= or the processor path default
+func RegisterProcessor(processor LineProcessor) error {
+	return GlobalProcessors.Register(processor)
+}
+
+// processLine runs processor on line, a processor panicking on a line leaves it raw
+func processLine(processor LineProcessor, line []byte) (processed ProcessedLine, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			slog.Warn("processing line", "processor", processor.Name(), "panic", r)
+			processed, ok = ProcessedLine{}, false
+		}
+	}()
+	return processor.Process(line)
+}
+
+// FieldFilter keeps the processed lines with Key equal to Value
+type FieldFilter struct {
+	Key   string
+	Value string
+}
+
+// ParseFieldFilters parses key=value filters
+func ParseFieldFilters(filters []string) ([]FieldFilter, error) {
+	return parseFilters("field", filters)
+}
+
+// ParseJSONFilters parses key=value filters on the fields of JSON lines
+func ParseJSONFilters(filters []string) ([]FieldFilter, error) {
+	return parseFilters("filter", filters)
+}
+
+// parseFilters parses the key=value filters of the query parameter param
+func parseFilters(param string, filters []string) ([]FieldFilter, error) {
+	parsed := make([]FieldFilter, 0, len(filters))
+	for _, filter := range filters {
+		key, value, ok := strings.Cut(filter, "=")
+		if !ok || key == "" {
+			return nil, fmt.Errorf("%s must be key=value, got %q", param, filter)
+		}
+		parsed = append(parsed, FieldFilter{Key: key, Value: value})
+	}
+	return parsed, nil
+}
+
+// matchFields reports whether fields match every filter
+func matchFields(filters []FieldFilter, fields map[string]string) bool {
+	for _, filter := range filters {
+		if value, ok := fields[filter.Key]; !ok || value != filter.Value {
+			return false
+		}
+	}
+	return true
+}
+
+// Base64JSONProcessor decodes lines of base64 encoded JSON objects. The text is the JSON and
+// the fields its top level values, nested values as JSON.
+type Base64JSONProcessor struct{}
+
+func (Base64JSONProcessor) Name() string {
+	return ProcessorBase64JSON
+}
+
+func (Base64JSONProcessor) Process(line []byte) (ProcessedLine, bool) {
+	line = bytes.TrimSpace(line)
+	decoded := make([]byte, base64.StdEncoding.DecodedLen(len(line)))
+	n, err := base64.StdEncoding.Decode(decoded, line)
+	if err != nil {
+		return ProcessedLine{}, false
+	}
+	decoded = decoded[:n]
+	object := map[string]json.RawMessage{}
+	if err := json.Unmarshal(decoded, &object); err != nil {
+		return ProcessedLine{}, false
+	}
+	fields := make(map[string]string, len(object))
+	for key, raw := range object {
+		var value string
+		if err := json.Unmarshal(raw, &value); err != nil {
+			value = string(raw)
+		}
+		fields[key] = value
+	}
+	return ProcessedLine{Text: string(decoded), Fields: fields}, true
+}
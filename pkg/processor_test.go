@@ -0,0 +1,112 @@
+package pkg
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func encodeBase64JSON(t *testing.T, value interface{}) string {
+	b, err := json.Marshal(value)
+	assert.NoError(t, err)
+	return base64.StdEncoding.EncodeToString(b)
+}
+
+func TestBase64JSONProcessor(t *testing.T) {
+	processed, ok := Base64JSONProcessor{}.Process([]byte(encodeBase64JSON(t, map[string]interface{}{
+		"level": "error",
+		"code":  42,
+		"user":  map[string]string{"id": "u1"},
+	}) + "\n"))
+	assert.True(t, ok)
+	assert.Equal(t, `{"code":42,"level":"error","user":{"id":"u1"}}`, processed.Text)
+	assert.Equal(t, map[string]string{"level": "error", "code": "42", "user": `{"id":"u1"}`}, processed.Fields)
+
+	for _, line := range []string{"INFO plain text", base64.StdEncoding.EncodeToString([]byte("not json")), encodeBase64JSON(t, []int{1})} {
+		_, ok := Base64JSONProcessor{}.Process([]byte(line))
+		assert.False(t, ok, line)
+	}
+}
+
+type panickingProcessor struct{}
+
+func (panickingProcessor) Name() string { return "panicking" }
+
+func (panickingProcessor) Process(line []byte) (ProcessedLine, bool) {
+	panic("corrupt frame")
+}
+
+func TestProcessors(t *testing.T) {
+	processors := NewProcessors(Base64JSONProcessor{})
+	assert.ErrorIs(t, processors.Register(Base64JSONProcessor{}), ErrProcessorExists)
+	assert.NoError(t, processors.Register(panickingProcessor{}))
+	assert.Equal(t, []string{ProcessorBase64JSON, "panicking"}, processors.Names())
+
+	processor, ok := processors.Get("panicking")
+	assert.True(t, ok)
+	_, ok = processLine(processor, []byte("line"))
+	assert.False(t, ok)
+}
+
+func TestParseFieldFilters(t *testing.T) {
+	filters, err := ParseFieldFilters([]string{"level=error", "msg=a=b", "empty="})
+	assert.NoError(t, err)
+	assert.Equal(t, []FieldFilter{{Key: "level", Value: "error"}, {Key: "msg", Value: "a=b"}, {Key: "empty", Value: ""}}, filters)
+	_, err = ParseFieldFilters([]string{"level"})
+	assert.Error(t, err)
+	_, err = ParseFieldFilters([]string{"=error"})
+	assert.Error(t, err)
+}
+
+func TestAPIHandler_GetProcessor(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "frames.log")
+	content := strings.Join([]string{
+		encodeBase64JSON(t, map[string]string{"level": "info", "msg": "started"}),
+		"not a frame",
+		encodeBase64JSON(t, map[string]string{"level": "error", "msg": "failed"}),
+	}, "\n") + "\n"
+	assert.NoError(t, os.WriteFile(logFile, []byte(content), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: logFile, Type: TypeFile}})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	get := func(query string) (*httptest.ResponseRecorder, APIResponse) {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api?type=file&page=1&per_page=10&file_path="+logFile+query, nil))
+		res := APIResponse{}
+		if rec.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		}
+		return rec, res
+	}
+
+	// lines are searched as processed, those not understood stay raw
+	rec, res := get("&processor=base64json&query=failed")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 1, res.Result.Total)
+	assert.Equal(t, `{"level":"error","msg":"failed"}`, res.Result.Lines[0].Content)
+	assert.Equal(t, map[string]string{"level": "error", "msg": "failed"}, res.Result.Lines[0].Fields)
+	_, res = get("&processor=base64json&query=frame")
+	assert.Equal(t, "not a frame", res.Result.Lines[0].Content)
+
+	// the path default applies when the request does not select one
+	defer GlobalPathDefaults.Set(nil)
+	GlobalPathDefaults.Set([]PathConfig{{Pattern: filepath.Join(dir, "*.log"), Defaults: &ViewDefaults{Processor: ProcessorBase64JSON}}})
+	_, res = get("&field=level=info")
+	assert.Equal(t, 1, res.Result.Total)
+	assert.Equal(t, "started", res.Result.Lines[0].Fields["msg"])
+	GlobalPathDefaults.Set(nil)
+
+	rec, _ = get("&processor=protobuf")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec, _ = get("&field=level=info")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+	rec, _ = get("&processor=base64json&field=level")
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+}
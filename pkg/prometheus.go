@@ -0,0 +1,181 @@
+package pkg
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/collectors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// The reads timed by gol_read_duration_seconds, by the op label
+const (
+	ReadOpFileStats = "file_stats"
+	ReadOpSearch    = "search"
+	ReadOpTail      = "tail"
+)
+
+// The reasons of the requests counted by gol_rejected_requests_total
+const (
+	RejectedRateLimit = "rate_limit"
+	RejectedScans     = "scans"
+	RejectedReads     = "reads"
+	RejectedStreams   = "streams"
+)
+
+// metricsFileTypes are always reported by gol_watched_files, with 0 when none is watched
+var metricsFileTypes = []string{TypeFile, TypeSSH, TypeDocker, TypeStdin}
+
+// Metrics are the Prometheus metrics of gol. They are kept in a registry of their own, so that an app
+// embedding gol keeps the default registry to itself.
+type Metrics struct {
+	Registry *prometheus.Registry
+
+	requestDuration   *prometheus.HistogramVec
+	readDuration      *prometheus.HistogramVec
+	readErrors        *prometheus.CounterVec
+	sshDialFailures   *prometheus.CounterVec
+	rejectedRequests  *prometheus.CounterVec
+	watchLoopDuration prometheus.Histogram
+}
+
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		Registry: prometheus.NewRegistry(),
+		requestDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gol_http_request_duration_seconds",
+			Help:    "Latency of the HTTP requests, by route, method and status code.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"route", "method", "code"}),
+		readDuration: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:    "gol_read_duration_seconds",
+			Help:    "Duration of the file stats counts, searches and tails.",
+			Buckets: prometheus.DefBuckets,
+		}, []string{"op"}),
+		readErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gol_read_errors_total",
+			Help: "File stats counts, searches and tails that failed.",
+		}, []string{"op"}),
+		sshDialFailures: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gol_ssh_dial_failures_total",
+			Help: "Connections to SSH hosts that failed, by host.",
+		}, []string{"host"}),
+		rejectedRequests: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name: "gol_rejected_requests_total",
+			Help: "Requests rejected for the rate limit or for busy scan, read or stream budgets, by reason.",
+		}, []string{"reason"}),
+		watchLoopDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:    "gol_watch_loop_duration_seconds",
+			Help:    "Duration of the rescans of the watched file paths.",
+			Buckets: prometheus.ExponentialBuckets(0.01, 4, 8),
+		}),
+	}
+	m.Registry.MustRegister(
+		m.requestDuration,
+		m.readDuration,
+		m.readErrors,
+		m.sshDialFailures,
+		m.rejectedRequests,
+		m.watchLoopDuration,
+		newFilesCollector(),
+		prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name: "gol_streaming_connections",
+			Help: "Tails and streams being followed.",
+		}, func() float64 { return float64(GlobalReadLimiter.InFlight().Tails) }),
+		collectors.NewGoCollector(),
+		collectors.NewProcessCollector(collectors.ProcessCollectorOpts{}),
+	)
+	return m
+}
+
+// Handler serves the metrics in the Prometheus exposition format
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.Registry, promhttp.HandlerOpts{Registry: m.Registry})
+}
+
+// Middleware times the requests by their route, the path they matched with its parameters unfilled
+func (m *Metrics) Middleware() echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			start := time.Now()
+			err := next(c)
+			code := c.Response().Status
+			// the error handler has not written the response of an error yet
+			if httpErr, ok := err.(*echo.HTTPError); ok { // nolint: errorlint
+				code = httpErr.Code
+			} else if err != nil {
+				code = http.StatusInternalServerError
+			}
+			route := c.Path()
+			if route == "" {
+				route = "unmatched"
+			}
+			m.requestDuration.WithLabelValues(route, c.Request().Method, strconv.Itoa(code)).Observe(time.Since(start).Seconds())
+			return err
+		}
+	}
+}
+
+// ObserveRead times a read of op started at start, counting it as failed with err
+func (m *Metrics) ObserveRead(op string, start time.Time, err error) {
+	m.readDuration.WithLabelValues(op).Observe(time.Since(start).Seconds())
+	if err != nil {
+		m.readErrors.WithLabelValues(op).Inc()
+	}
+}
+
+func (m *Metrics) SSHDialFailed(host string) {
+	m.sshDialFailures.WithLabelValues(host).Inc()
+}
+
+// Rejected counts a request rejected for reason
+func (m *Metrics) Rejected(reason string) {
+	m.rejectedRequests.WithLabelValues(reason).Inc()
+}
+
+func (m *Metrics) ObserveWatchLoop(start time.Time) {
+	m.watchLoopDuration.Observe(time.Since(start).Seconds())
+}
+
+// filesCollector reports the watched files by type, and the bytes and lines counted of them, as they are
+// when scraped
+type filesCollector struct {
+	files *prometheus.Desc
+	bytes *prometheus.Desc
+	lines *prometheus.Desc
+}
+
+func newFilesCollector() *filesCollector {
+	return &filesCollector{
+		files: prometheus.NewDesc("gol_watched_files", "Watched files, by type.", []string{"type"}, nil),
+		bytes: prometheus.NewDesc("gol_indexed_bytes", "Size of the watched files.", nil, nil),
+		lines: prometheus.NewDesc("gol_indexed_lines", "Lines counted of the watched files.", nil, nil),
+	}
+}
+
+func (c *filesCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.files
+	ch <- c.bytes
+	ch <- c.lines
+}
+
+func (c *filesCollector) Collect(ch chan<- prometheus.Metric) {
+	files := map[string]int{}
+	for _, fileType := range metricsFileTypes {
+		files[fileType] = 0
+	}
+	var bytes, lines int64
+	for _, fileInfo := range GlobalFileRegistry.Snapshot() {
+		files[fileInfo.Type]++
+		bytes += fileInfo.FileSize
+		lines += int64(fileInfo.LinesCount)
+	}
+	for fileType, count := range files {
+		ch <- prometheus.MustNewConstMetric(c.files, prometheus.GaugeValue, float64(count), fileType)
+	}
+	ch <- prometheus.MustNewConstMetric(c.bytes, prometheus.GaugeValue, float64(bytes))
+	ch <- prometheus.MustNewConstMetric(c.lines, prometheus.GaugeValue, float64(lines))
+}
@@ -0,0 +1,69 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics_Scrape(t *testing.T) {
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	filePath := filepath.Join(t.TempDir(), "app.log")
+	assert.NoError(t, os.WriteFile(filePath, []byte("INFO a\nERROR b\n"), 0600))
+	GlobalFileRegistry.Replace(GetFileInfos(filePath, 10, false, nil))
+	_, _, err := FileStatsContext(context.Background(), filePath, false, nil)
+	assert.NoError(t, err)
+	_, err = sshConnect(context.Background(), &SSHConfig{Host: "127.0.0.1", Port: "1", User: "gol"})
+	assert.Error(t, err)
+
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff, Metrics: true})
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api?type=file&file_path="+filePath, nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(rec.Body)
+	assert.NoError(t, err)
+	for _, name := range []string{
+		"gol_watched_files",
+		"gol_indexed_bytes",
+		"gol_indexed_lines",
+		"gol_http_request_duration_seconds",
+		"gol_streaming_connections",
+		"gol_ssh_dial_failures_total",
+		"gol_read_duration_seconds",
+		"go_goroutines",
+	} {
+		assert.Contains(t, families, name)
+	}
+	watched := map[string]float64{}
+	for _, metric := range families["gol_watched_files"].GetMetric() {
+		watched[metric.GetLabel()[0].GetValue()] = metric.GetGauge().GetValue()
+	}
+	assert.Equal(t, map[string]float64{TypeFile: 1, TypeSSH: 0, TypeDocker: 0, TypeStdin: 0}, watched)
+	assert.Equal(t, float64(2), families["gol_indexed_lines"].GetMetric()[0].GetGauge().GetValue())
+	routes := []string{}
+	for _, metric := range families["gol_http_request_duration_seconds"].GetMetric() {
+		for _, label := range metric.GetLabel() {
+			if label.GetName() == "route" {
+				routes = append(routes, label.GetValue())
+			}
+		}
+	}
+	assert.Contains(t, routes, "/api")
+
+	// served only when enabled
+	e = newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
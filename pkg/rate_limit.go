@@ -0,0 +1,95 @@
+package pkg
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// DefaultRateLimit and DefaultRateBurst are the API requests per second of an address, and the
+	// requests it may send at once, of the command line
+	DefaultRateLimit = 50
+	DefaultRateBurst = 100
+
+	// rateLimitsKept is how many addresses buckets are kept for, full ones are dropped past it
+	rateLimitsKept = 10000
+)
+
+// RateLimits are token buckets of each address, refilled at rate tokens per second up to burst
+type RateLimits struct {
+	rate  float64
+	burst float64
+
+	mutex  sync.Mutex
+	byAddr map[string]*rateBucket
+}
+
+type rateBucket struct {
+	tokens float64
+	last   time.Time
+}
+
+// NewRateLimits makes buckets of rate per second, burst at least 1
+func NewRateLimits(rate float64, burst int) *RateLimits {
+	return &RateLimits{rate: rate, burst: float64(max(burst, 1)), byAddr: map[string]*rateBucket{}}
+}
+
+// Take takes a token of addr, and returns how long it has to wait for one when its bucket is empty
+func (l *RateLimits) Take(addr string) time.Duration {
+	l.mutex.Lock()
+	defer l.mutex.Unlock()
+	now := GlobalClock.Now()
+	bucket, ok := l.byAddr[addr]
+	if !ok {
+		if len(l.byAddr) >= rateLimitsKept {
+			l.prune(now)
+		}
+		bucket = &rateBucket{tokens: l.burst, last: now}
+		l.byAddr[addr] = bucket
+	}
+	bucket.tokens = min(bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate, l.burst)
+	bucket.last = now
+	if bucket.tokens < 1 {
+		return time.Duration((1 - bucket.tokens) / l.rate * float64(time.Second))
+	}
+	bucket.tokens--
+	return 0
+}
+
+// prune drops the buckets refilled by now, they are made full again when needed
+func (l *RateLimits) prune(now time.Time) {
+	for addr, bucket := range l.byAddr {
+		if bucket.tokens+now.Sub(bucket.last).Seconds()*l.rate >= l.burst {
+			delete(l.byAddr, addr)
+		}
+	}
+}
+
+// RateLimit is a middleware limiting the API requests of each address to RateLimit per second, with
+// bursts of RateBurst, answering 429 with a Retry-After past them. It is disabled when RateLimit is 0.
+// A streaming request takes one token when it starts.
+func RateLimit(options *EchoOptions) echo.MiddlewareFunc {
+	if options.RateLimit <= 0 {
+		return func(next echo.HandlerFunc) echo.HandlerFunc { return next }
+	}
+	limits := NewRateLimits(options.RateLimit, options.RateBurst)
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		return func(c echo.Context) error {
+			if !strings.HasPrefix(c.Request().URL.Path, options.BaseURL+"api") {
+				return next(c)
+			}
+			if wait := limits.Take(connectionIP(c.Request())); wait > 0 {
+				GlobalMetrics.Rejected(RejectedRateLimit)
+				c.Response().Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+				return echo.NewHTTPError(http.StatusTooManyRequests, ErrorCodeRateLimited)
+			}
+			return next(c)
+		}
+	}
+}
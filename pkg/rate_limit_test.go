@@ -0,0 +1,87 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/prometheus/common/expfmt"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateLimits_Take(t *testing.T) {
+	defer func(clock Clock) { GlobalClock = clock }(GlobalClock)
+	clock := NewManualClock(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))
+	GlobalClock = clock
+
+	limits := NewRateLimits(2, 3)
+	for i := 0; i < 3; i++ {
+		assert.Equal(t, time.Duration(0), limits.Take("10.0.0.1"))
+	}
+	assert.Equal(t, 500*time.Millisecond, limits.Take("10.0.0.1"))
+	// addresses have buckets of their own
+	assert.Equal(t, time.Duration(0), limits.Take("10.0.0.2"))
+
+	// the bucket refills at the rate, up to the burst
+	clock.Advance(500 * time.Millisecond)
+	assert.Equal(t, time.Duration(0), limits.Take("10.0.0.1"))
+	assert.Equal(t, 500*time.Millisecond, limits.Take("10.0.0.1"))
+	clock.Advance(time.Hour)
+	limits.prune(clock.Now())
+	assert.Empty(t, limits.byAddr)
+}
+
+func TestRateLimit(t *testing.T) {
+	defer func(clock Clock) { GlobalClock = clock }(GlobalClock)
+	GlobalClock = NewManualClock(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))
+
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff, Metrics: true, RateLimit: 0.5, RateBurst: 2})
+	get := func(path string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		req.RemoteAddr = "10.0.0.1:41234"
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+	assert.Equal(t, http.StatusOK, get("/api/version").Code)
+	assert.Equal(t, http.StatusOK, get("/api/version").Code)
+	rec := get("/api/version")
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "2", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), ErrorCodeRateLimited)
+
+	// only the API is limited
+	rec = get("/metrics")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var parser expfmt.TextParser
+	families, err := parser.TextToMetricFamilies(rec.Body)
+	assert.NoError(t, err)
+	rejected := map[string]float64{}
+	for _, metric := range families["gol_rejected_requests_total"].GetMetric() {
+		rejected[metric.GetLabel()[0].GetValue()] = metric.GetCounter().GetValue()
+	}
+	assert.GreaterOrEqual(t, rejected[RejectedRateLimit], float64(1))
+}
+
+func TestAcquireRead_TooManyScans(t *testing.T) {
+	defer func(limiter *Limiter) { GlobalReadLimiter = limiter }(GlobalReadLimiter)
+	GlobalReadLimiter = NewLimiter(1, 2, 2, 2, 10*time.Millisecond)
+	release, err := GlobalReadLimiter.AcquireRead(context.Background(), TypeFile, "", "/var/log/app.log")
+	assert.NoError(t, err)
+	defer release()
+
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	filePath := filepath.Join(t.TempDir(), "app.log")
+	assert.NoError(t, os.WriteFile(filePath, []byte("INFO a\nERROR b\n"), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: filePath, Type: TypeFile}})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/search?type=file&file_path="+filePath+"&query=ERROR", nil))
+	assert.Equal(t, http.StatusTooManyRequests, rec.Code)
+	assert.Equal(t, "1", rec.Header().Get("Retry-After"))
+	assert.Contains(t, rec.Body.String(), ErrorCodeTooManyScans)
+}
@@ -0,0 +1,292 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	remoteListTimeout = 10 * time.Second
+	remoteReadTimeout = 60 * time.Second
+	// peer error bodies read into the error message
+	remoteErrorBodyLimit = 4 * 1024
+)
+
+// RemotePathConfig is a peer gol instance whose files are listed and read through its HTTP API
+type RemotePathConfig struct {
+	URL   string
+	Token string
+	Label string
+}
+
+// s is an input of the form "https://host[:port][/base-url/] [token=XYZ] [label=dc2]"
+func StringToRemotePathConfig(s string) (*RemotePathConfig, error) {
+	parts := strings.Fields(s)
+	if len(parts) == 0 {
+		return nil, fmt.Errorf("input string does not have the correct format")
+	}
+	u, err := url.Parse(parts[0])
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, fmt.Errorf("remote url must be http(s)://host[:port][/base-url/]")
+	}
+	if !strings.HasSuffix(u.Path, "/") {
+		u.Path += "/"
+	}
+
+	config := &RemotePathConfig{URL: u.String(), Label: u.Host}
+	for _, part := range parts[1:] {
+		switch {
+		case strings.HasPrefix(part, "token="):
+			config.Token = strings.TrimPrefix(part, "token=")
+		case strings.HasPrefix(part, "label="):
+			config.Label = strings.TrimPrefix(part, "label=")
+		default:
+			return nil, fmt.Errorf("unknown remote option %q", part)
+		}
+	}
+	if config.Label == "" {
+		return nil, fmt.Errorf("label is empty")
+	}
+	return config, nil
+}
+
+// RemoteError is a peer that could not be reached or that answered with an error
+type RemoteError struct {
+	Label      string
+	StatusCode int
+	Message    string
+}
+
+func (e *RemoteError) Error() string {
+	if e.StatusCode == 0 {
+		return fmt.Sprintf("remote %s: %s", e.Label, e.Message)
+	}
+	return fmt.Sprintf("remote %s: %d %s", e.Label, e.StatusCode, e.Message)
+}
+
+// RemoteClient talks to the HTTP API of a peer gol instance
+type RemoteClient struct {
+	config RemotePathConfig
+	client *http.Client
+
+	mutex sync.RWMutex
+	// fileInfos is the last listing of the peer, with the peer's own types and hosts
+	fileInfos []FileInfo
+}
+
+func NewRemoteClient(config RemotePathConfig, client *http.Client) *RemoteClient {
+	if client == nil {
+		client = &http.Client{}
+	}
+	return &RemoteClient{
+		config: config,
+		client: client,
+	}
+}
+
+func (r *RemoteClient) Label() string {
+	return r.config.Label
+}
+
+// Do sends a GET to the peer API endpoint, the peer is authenticated with the token when set.
+// Cancellation and deadline of ctx apply to the whole exchange, including reading the body.
+// A non 2xx answer is returned as a *RemoteError.
+func (r *RemoteClient) Do(ctx context.Context, endpoint string, query url.Values) (*http.Response, error) {
+	u := r.config.URL + strings.TrimPrefix(endpoint, "/")
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	if r.config.Token != "" {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+r.config.Token)
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return nil, &RemoteError{Label: r.config.Label, Message: err.Error()}
+	}
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		defer res.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(res.Body, remoteErrorBodyLimit))
+		return nil, &RemoteError{Label: r.config.Label, StatusCode: res.StatusCode, Message: remoteErrorMessage(body)}
+	}
+	return res, nil
+}
+
+// remoteErrorMessage is the message of an echo error body, or the body itself
+func remoteErrorMessage(body []byte) string {
+	var he struct {
+		Message interface{} `json:"message"`
+	}
+	if err := json.Unmarshal(body, &he); err == nil && he.Message != nil {
+		return fmt.Sprint(he.Message)
+	}
+	return strings.TrimSpace(string(body))
+}
+
+func (r *RemoteClient) getJSON(ctx context.Context, endpoint string, query url.Values, v interface{}) error {
+	res, err := r.Do(ctx, endpoint, query)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return &RemoteError{Label: r.config.Label, Message: err.Error()}
+	}
+	return nil
+}
+
+// ListFiles fetches the files watched by the peer and remembers them
+func (r *RemoteClient) ListFiles(ctx context.Context) ([]FileInfo, error) {
+	var list FileListResponse
+	if err := r.getJSON(ctx, "api/files", nil, &list); err != nil {
+		return nil, err
+	}
+	r.mutex.Lock()
+	r.fileInfos = list.FilePaths
+	r.mutex.Unlock()
+	return list.FilePaths, nil
+}
+
+// lastFileInfos is the last listing of the peer, nil before one succeeded
+func (r *RemoteClient) lastFileInfos() []FileInfo {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	return r.fileInfos
+}
+
+// PeerFileInfo returns the file of the peer's type and host and path as the peer lists it, from the
+// last listing. Without a type and host, a path the peer lists once is found.
+func (r *RemoteClient) PeerFileInfo(peerType string, peerHost string, filePath string) (FileInfo, bool) {
+	r.mutex.RLock()
+	defer r.mutex.RUnlock()
+	found, n := FileInfo{}, 0
+	for _, fileInfo := range r.fileInfos {
+		if fileInfo.FilePath != filePath {
+			continue
+		}
+		if fileInfo.Type == peerType && fileInfo.Host == peerHost {
+			return fileInfo, true
+		}
+		found, n = fileInfo, n+1
+	}
+	return found, peerType == "" && peerHost == "" && n == 1
+}
+
+// PeerQuery rewrites the query of a request for a remote file: the file is addressed by its path with
+// the peer's own type and host. The ID and token of this instance mean nothing to the peer and are dropped,
+// the peer is authenticated with its own token.
+func (r *RemoteClient) PeerQuery(query url.Values, peerType string, peerHost string, filePath string) (url.Values, bool) {
+	fileInfo, ok := r.PeerFileInfo(peerType, peerHost, filePath)
+	if !ok {
+		return nil, false
+	}
+	peerQuery := url.Values{}
+	for key, values := range query {
+		peerQuery[key] = append([]string(nil), values...)
+	}
+	peerQuery.Del("id")
+	peerQuery.Del("token")
+	peerQuery.Del("peer_type")
+	peerQuery.Del("peer_host")
+	peerQuery.Set("file_path", filePath)
+	peerQuery.Set("type", fileInfo.Type)
+	peerQuery.Set("host", fileInfo.Host)
+	return peerQuery, true
+}
+
+// DialStream opens the WebSocket stream of the peer, authenticated like Do. A refused upgrade is
+// returned as a *RemoteError with the status of the peer.
+func (r *RemoteClient) DialStream(ctx context.Context, query url.Values) (*websocket.Conn, error) {
+	u, err := url.Parse(r.config.URL + "api/stream")
+	if err != nil {
+		return nil, err
+	}
+	u.Scheme = strings.Replace(u.Scheme, "http", "ws", 1)
+	u.RawQuery = query.Encode()
+	header := http.Header{}
+	if r.config.Token != "" {
+		header.Set(echo.HeaderAuthorization, "Bearer "+r.config.Token)
+	}
+	dialer := websocket.Dialer{HandshakeTimeout: remoteListTimeout}
+	if transport, ok := r.client.Transport.(*http.Transport); ok {
+		dialer.TLSClientConfig = transport.TLSClientConfig
+	}
+	conn, res, err := dialer.DialContext(ctx, u.String(), header)
+	if err != nil {
+		if res == nil {
+			return nil, &RemoteError{Label: r.config.Label, Message: err.Error()}
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(res.Body, remoteErrorBodyLimit))
+		return nil, &RemoteError{Label: r.config.Label, StatusCode: res.StatusCode, Message: remoteErrorMessage(body)}
+	}
+	res.Body.Close()
+	return conn, nil
+}
+
+// Search runs a query on the peer, the result is labelled as coming from this remote
+func (r *RemoteClient) Search(ctx context.Context, query url.Values) (*APIResponse, error) {
+	var response APIResponse
+	if err := r.getJSON(ctx, "api", query, &response); err != nil {
+		return nil, err
+	}
+	response.Result.Type = TypeRemoteGol
+	response.Result.Host = r.config.Label
+	for i := range response.Result.Sources {
+		response.Result.Sources[i].Type = TypeRemoteGol
+		response.Result.Sources[i].Host = r.config.Label
+	}
+	return &response, nil
+}
+
+// RemoteFileInfos lists the files of every peer as TypeRemoteGol with the peer label as host, keeping
+// their type and host on the peer.
+// A peer that cannot be reached is logged and its files of the last listing stay listed, marked
+// stale with a warning, until it answers again. A peer never listed has no files.
+func RemoteFileInfos(clients []*RemoteClient) []FileInfo {
+	fileInfos := []FileInfo{}
+	for _, client := range clients {
+		ctx, cancel := context.WithTimeout(context.Background(), remoteListTimeout)
+		peerFileInfos, err := client.ListFiles(ctx)
+		cancel()
+		warning := ""
+		if err != nil {
+			slog.Error("listing remote files", "remote", client.Label(), "error", err)
+			peerFileInfos = client.lastFileInfos()
+			warning = err.Error()
+		}
+		for _, fileInfo := range peerFileInfos {
+			fileInfos = append(fileInfos, FileInfo{
+				FilePath:   fileInfo.FilePath,
+				LinesCount: fileInfo.LinesCount,
+				FileSize:   fileInfo.FileSize,
+				Name:       fileInfo.Name,
+				Type:       TypeRemoteGol,
+				Host:       client.Label(),
+				PeerType:   fileInfo.Type,
+				PeerHost:   fileInfo.Host,
+				Generation: fileInfo.Generation,
+				Stale:      warning != "",
+				Warning:    warning,
+			})
+		}
+	}
+	return fileInfos
+}
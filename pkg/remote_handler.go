@@ -0,0 +1,173 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+)
+
+// remotePeer finds the peer serving a remote file and rewrites the request query for it. The file is
+// told apart from the files of the peer with the same path by the peer_type and peer_host of the
+// request, or those of the file its id addresses.
+func (h *APIHandler) remotePeer(c echo.Context, host string, filePath string) (*RemoteClient, url.Values, error) {
+	client := h.API.FindRemoteClient(host)
+	if client == nil {
+		return nil, nil, echo.NewHTTPError(http.StatusNotFound, "remote not found")
+	}
+	peerType, peerHost := c.QueryParam("peer_type"), c.QueryParam("peer_host")
+	if fileInfo, ok := FileInfoByID(c.QueryParam("id")); ok && fileInfo.Type == TypeRemoteGol {
+		peerType, peerHost = fileInfo.PeerType, fileInfo.PeerHost
+	}
+	query, ok := client.PeerQuery(c.QueryParams(), peerType, peerHost, filePath)
+	if !ok {
+		return nil, nil, echo.NewHTTPError(http.StatusNotFound, "file not found")
+	}
+	return client, query, nil
+}
+
+// getRemote runs a search on the peer, the file list of the response stays the local one
+func (h *APIHandler) getRemote(c echo.Context, host string, filePath string) error {
+	client, query, err := h.remotePeer(c, host, filePath)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), remoteReadTimeout)
+	defer cancel()
+	response, err := client.Search(ctx, query)
+	if err != nil {
+		return remoteHTTPError(err)
+	}
+	response.FilePaths = GlobalFileRegistry.Snapshot()
+	return c.JSON(http.StatusOK, response)
+}
+
+// proxyRemote forwards a read to the same endpoint of the peer and copies its answer back
+func (h *APIHandler) proxyRemote(c echo.Context, endpoint string, host string, filePath string) error {
+	client, query, err := h.remotePeer(c, host, filePath)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), remoteReadTimeout)
+	defer cancel()
+	res, err := client.Do(ctx, endpoint, query)
+	if err != nil {
+		return remoteHTTPError(err)
+	}
+	defer res.Body.Close()
+	if disposition := res.Header.Get(echo.HeaderContentDisposition); disposition != "" {
+		c.Response().Header().Set(echo.HeaderContentDisposition, disposition)
+	}
+	return c.Stream(http.StatusOK, res.Header.Get(echo.HeaderContentType), res.Body)
+}
+
+// tailRemote relays the event stream of the peer until either side goes away
+func (h *APIHandler) tailRemote(c echo.Context, host string, filePath string) error {
+	client, query, err := h.remotePeer(c, host, filePath)
+	if err != nil {
+		return err
+	}
+	res, err := client.Do(c.Request().Context(), "api/tail", query)
+	if err != nil {
+		return remoteHTTPError(err)
+	}
+	defer res.Body.Close()
+
+	SetHeadersResponseSSE(c.Response().Header())
+	c.Response().WriteHeader(http.StatusOK)
+	c.Response().Flush()
+	buffer := make([]byte, 32*1024)
+	for {
+		n, err := res.Body.Read(buffer)
+		if n > 0 {
+			if _, err := c.Response().Write(buffer[:n]); err != nil {
+				return nil
+			}
+			c.Response().Flush()
+		}
+		if err != nil {
+			// the peer closing the stream or the client going away both end the relay
+			return nil
+		}
+	}
+}
+
+// streamRemote relays the WebSocket stream of the peer message by message until either side goes
+// away. The close of the peer is passed on, a peer going away closes the stream as going away too,
+// the client reconnects then.
+func (h *APIHandler) streamRemote(c echo.Context, host string, filePath string) error {
+	client, query, err := h.remotePeer(c, host, filePath)
+	if err != nil {
+		return err
+	}
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+	peer, err := client.DialStream(ctx, query)
+	if err != nil {
+		return remoteHTTPError(err)
+	}
+	defer peer.Close()
+	// the client going away or the server shutting down ends the read of the peer
+	context.AfterFunc(ctx, func() { peer.Close() })
+
+	conn, err := streamUpgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		// the upgrader answered the request already
+		return nil
+	}
+	defer conn.Close()
+	// the client sends nothing, reading notices it going away
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+	// the pings of the peer stop at this instance, the client gets its own
+	ping, stopPing := GlobalClock.Tick(streamPingInterval)
+	go func() {
+		defer stopPing()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ping:
+				conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)) //nolint: errcheck
+			}
+		}
+	}()
+
+	for {
+		messageType, message, err := peer.ReadMessage()
+		if err != nil {
+			closing := websocket.FormatCloseMessage(websocket.CloseGoingAway, "remote went away")
+			var closeErr *websocket.CloseError
+			if errors.As(err, &closeErr) && ctx.Err() == nil {
+				closing = websocket.FormatCloseMessage(closeErr.Code, closeErr.Text)
+			}
+			conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(streamWriteTimeout)) //nolint: errcheck
+			return nil
+		}
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout)) //nolint: errcheck
+		if err := conn.WriteMessage(messageType, message); err != nil {
+			return nil
+		}
+	}
+}
+
+// remoteHTTPError passes client errors of the peer through, anything else is the peer being unavailable
+func remoteHTTPError(err error) error {
+	var remoteErr *RemoteError
+	if errors.As(err, &remoteErr) && remoteErr.StatusCode >= 400 && remoteErr.StatusCode < 500 {
+		return echo.NewHTTPError(remoteErr.StatusCode, remoteErr.Message)
+	}
+	slog.Warn("reading remote", "error", err)
+	return echo.NewHTTPError(http.StatusBadGateway, ErrorCodeRemoteDown)
+}
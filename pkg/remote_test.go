@@ -0,0 +1,211 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStringToRemotePathConfig(t *testing.T) {
+	type TestCase struct {
+		Input   string
+		Want    *RemotePathConfig
+		WantErr bool
+	}
+
+	testCases := []TestCase{
+		{Input: "https://gol.dc2.internal:3003 token=XYZ label=dc2", Want: &RemotePathConfig{URL: "https://gol.dc2.internal:3003/", Token: "XYZ", Label: "dc2"}},
+		{Input: "http://10.0.0.2:3003/gol", Want: &RemotePathConfig{URL: "http://10.0.0.2:3003/gol/", Label: "10.0.0.2:3003"}},
+		{Input: "", WantErr: true},
+		{Input: "gol.dc2.internal:3003", WantErr: true},
+		{Input: "https://gol.dc2.internal:3003 secret=XYZ", WantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Input, func(t *testing.T) {
+			config, err := StringToRemotePathConfig(tc.Input)
+			if tc.WantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.Want, config)
+		})
+	}
+}
+
+func newTestPeer(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/files", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer XYZ" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(FileListResponse{FilePaths: []FileInfo{ //nolint: errcheck
+			{FilePath: "/var/log/app.log", LinesCount: 3, Type: TypeFile},
+			{FilePath: "/app/remote.log", Type: TypeSSH, Host: "10.0.0.9"},
+			{FilePath: "/var/log/app.log", Type: TypeSSH, Host: "10.0.0.9"},
+		}})
+	})
+	mux.HandleFunc("/api", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		if query.Get("type") != TypeFile || query.Get("host") != "" {
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"file not found"}`)) //nolint: errcheck
+			return
+		}
+		json.NewEncoder(w).Encode(APIResponse{ //nolint: errcheck
+			Result: ScanResult{
+				FilePath:     query.Get("file_path"),
+				Type:         TypeFile,
+				MatchPattern: query.Get("query"),
+				Total:        1,
+				Lines:        []LineResult{{LineNumber: 2, Content: "ERROR remote"}},
+				Sources:      []LineSource{{FilePath: query.Get("file_path"), Type: TypeFile}},
+			},
+		})
+	})
+	peer := httptest.NewServer(mux)
+	t.Cleanup(peer.Close)
+	return peer
+}
+
+func TestRemoteClient(t *testing.T) {
+	peer := newTestPeer(t)
+	client := NewRemoteClient(RemotePathConfig{URL: peer.URL + "/", Token: "XYZ", Label: "dc2"}, peer.Client())
+
+	fileInfos := RemoteFileInfos([]*RemoteClient{client})
+	require.Len(t, fileInfos, 3)
+	assert.Equal(t, FileInfo{FilePath: "/var/log/app.log", LinesCount: 3, Type: TypeRemoteGol, Host: "dc2", PeerType: TypeFile}, fileInfos[0])
+	assert.Equal(t, FileInfo{FilePath: "/var/log/app.log", Type: TypeRemoteGol, Host: "dc2", PeerType: TypeSSH, PeerHost: "10.0.0.9"}, fileInfos[2])
+	// files of the peer with the same path stay apart
+	SetFileIDs(fileInfos)
+	assert.NotEqual(t, fileInfos[0].ID, fileInfos[2].ID)
+	query, ok := client.PeerQuery(url.Values{"peer_type": {TypeSSH}, "peer_host": {"10.0.0.9"}}, TypeSSH, "10.0.0.9", "/var/log/app.log")
+	assert.True(t, ok)
+	assert.Equal(t, url.Values{"file_path": {"/var/log/app.log"}, "type": {TypeSSH}, "host": {"10.0.0.9"}}, query)
+	_, ok = client.PeerQuery(url.Values{}, "", "", "/var/log/app.log")
+	assert.False(t, ok)
+
+	query, ok = client.PeerQuery(url.Values{"file_path": {"/var/log/app.log"}, "type": {TypeRemoteGol}, "host": {"dc2"}, "query": {"ERROR"}}, TypeFile, "", "/var/log/app.log")
+	assert.True(t, ok)
+	response, err := client.Search(context.Background(), query)
+	assert.NoError(t, err)
+	assert.Equal(t, TypeRemoteGol, response.Result.Type)
+	assert.Equal(t, "dc2", response.Result.Host)
+	assert.Equal(t, "ERROR", response.Result.MatchPattern)
+	assert.Equal(t, []LineSource{{FilePath: "/var/log/app.log", Type: TypeRemoteGol, Host: "dc2"}}, response.Result.Sources)
+
+	_, ok = client.PeerQuery(url.Values{}, "", "", "/not/listed.log")
+	assert.False(t, ok)
+
+	// the peer's own error is passed on with its status, a path listed once needs no type and host
+	query, ok = client.PeerQuery(url.Values{"file_path": {"/app/remote.log"}}, "", "", "/app/remote.log")
+	assert.True(t, ok)
+	_, err = client.Search(context.Background(), query)
+	var remoteErr *RemoteError
+	assert.True(t, errors.As(err, &remoteErr))
+	assert.Equal(t, http.StatusNotFound, remoteErr.StatusCode)
+	assert.Equal(t, "file not found", remoteErr.Message)
+}
+
+func TestRemoteClient_Unavailable(t *testing.T) {
+	peer := newTestPeer(t)
+	unauthorized := NewRemoteClient(RemotePathConfig{URL: peer.URL + "/", Label: "dc2"}, peer.Client())
+	_, err := unauthorized.ListFiles(context.Background())
+	var remoteErr *RemoteError
+	assert.True(t, errors.As(err, &remoteErr))
+	assert.Equal(t, http.StatusUnauthorized, remoteErr.StatusCode)
+
+	peer.Close()
+	down := NewRemoteClient(RemotePathConfig{URL: peer.URL + "/", Label: "dc3"}, nil)
+	assert.Empty(t, RemoteFileInfos([]*RemoteClient{down}))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = down.ListFiles(ctx)
+	assert.Error(t, err)
+}
+
+func TestRemoteFederation(t *testing.T) {
+	useTailHub(t)
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte("line 1\nERROR line 2\nline 3\n"), 0600))
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	local := SetFileIDs([]FileInfo{{FilePath: logFile, Type: TypeFile, LinesCount: 3}})
+	GlobalFileRegistry.Replace(local)
+	agent := httptest.NewServer(newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff, Token: "XYZ", ReadOnly: true}))
+	defer agent.Close()
+	central := httptest.NewServer(newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff}))
+	defer central.Close()
+
+	config, err := StringToRemotePathConfig(agent.URL + " token=XYZ")
+	assert.NoError(t, err)
+	clients := GlobalRemoteClients
+	defer func() { GlobalRemoteClients = clients }()
+	GlobalRemoteClients = []*RemoteClient{NewRemoteClient(*config, agent.Client())}
+	// both servers share the registry, the agent keeps reading its local file
+	remote := RemoteFileInfos(GlobalRemoteClients)
+	if !assert.Len(t, remote, 1) {
+		t.FailNow()
+	}
+	host := strings.TrimPrefix(agent.URL, "http://")
+	assert.Equal(t, FileInfo{FilePath: logFile, LinesCount: 3, Type: TypeRemoteGol, Host: host, PeerType: TypeFile}, remote[0])
+	fileInfos := SetFileIDs(append(local, remote...))
+	GlobalFileRegistry.Replace(fileInfos)
+	remoteID := fileInfos[1].ID
+
+	// reads by id go through the agent with its token
+	var response APIResponse
+	res, err := http.Get(central.URL + "/api?id=" + remoteID + "&query=ERROR")
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, res.StatusCode)
+	assert.NoError(t, json.NewDecoder(res.Body).Decode(&response))
+	res.Body.Close()
+	assert.Equal(t, TypeRemoteGol, response.Result.Type)
+	assert.Equal(t, host, response.Result.Host)
+	assert.Equal(t, []string{"ERROR line 2"}, mergedContents(response.Result.Lines))
+
+	// the stream of the agent is relayed
+	conn := dialStream(t, central, "tail=2&id="+remoteID)
+	defer conn.Close()
+	snapshot := readStreamMessage(t, conn)
+	assert.Equal(t, StreamMessageSnapshot, snapshot.Type)
+	assert.Len(t, snapshot.Lines, 2)
+	f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0600)
+	assert.NoError(t, err)
+	_, err = f.WriteString("line 4\n")
+	assert.NoError(t, err)
+	f.Close()
+	message := readStreamMessage(t, conn)
+	assert.Equal(t, StreamMessageLine, message.Type)
+	if assert.NotNil(t, message.Line) {
+		assert.Equal(t, "line 4", message.Line.Content)
+		assert.Equal(t, 4, message.Line.LineNumber)
+	}
+
+	// an agent gone away keeps its files listed as stale, reads of them fail as the remote being down
+	conn.Close()
+	agent.CloseClientConnections()
+	agent.Close()
+	remote = RemoteFileInfos(GlobalRemoteClients)
+	if assert.Len(t, remote, 1) {
+		assert.True(t, remote[0].Stale)
+		assert.NotEmpty(t, remote[0].Warning)
+		assert.Equal(t, logFile, remote[0].FilePath)
+	}
+	res, err = http.Get(central.URL + "/api?id=" + remoteID)
+	assert.NoError(t, err)
+	res.Body.Close()
+	assert.Equal(t, http.StatusBadGateway, res.StatusCode)
+}
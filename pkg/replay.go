@@ -0,0 +1,154 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/acarl005/stripansi"
+)
+
+// ReplayLine is a line of a replay, sent once its delay after the previous line has passed
+type ReplayLine struct {
+	LineNumber int    `json:"line_number"`
+	Content    string `json:"content"`
+	Date       string `json:"date,omitempty"`
+	Class      string `json:"class"`
+	// Source is the index of the line's segment in the sources of the replay
+	Source int `json:"source"`
+	// DelayMs is how long the line was held back after the previous one, before the speed factor
+	DelayMs int64 `json:"delay_ms"`
+}
+
+// Replay streams the lines of segments, oldest first, between from and to at the pace of their
+// timestamps divided by speed, a speed of 0 sends them as fast as possible.
+// Lines without a timestamp are held back by the delay of the previous line, and are only
+// replayed after a timestamped line of the window. It returns the number of lines sent and the
+// segments skipped because they could not be read, the rest of the chain is still replayed.
+func (w *Watcher) Replay(ctx context.Context, segments []string, from, to time.Time, speed float64, tracker *JobTracker, emit func(ReplayLine) error) (int, []SegmentWarning, error) {
+	r := &replay{
+		watcher:    w,
+		from:       from,
+		to:         to,
+		speed:      speed,
+		tracker:    tracker,
+		emit:       emit,
+		classifier: ClassifierFor(w.filePath),
+		inWindow:   from.IsZero(),
+	}
+	var err error
+	if r.match, err = newLineMatcher(w.matchPattern); err != nil {
+		return 0, nil, err
+	}
+	if w.ignorePattern != "" {
+		if r.ignore, err = newLineMatcher(w.ignorePattern); err != nil {
+			return 0, nil, err
+		}
+	}
+	var warnings []SegmentWarning
+	for source, segment := range segments {
+		done, err := r.segment(ctx, source, segment)
+		var readErr *SegmentReadError
+		if len(segments) > 1 && errors.As(err, &readErr) {
+			warnings = append(warnings, readErr.Warning())
+			continue
+		}
+		if err != nil || done {
+			return r.sent, warnings, err
+		}
+	}
+	return r.sent, warnings, nil
+}
+
+type replay struct {
+	watcher    *Watcher
+	from       time.Time
+	to         time.Time
+	speed      float64
+	tracker    *JobTracker
+	emit       func(ReplayLine) error
+	classifier *Classifier
+	match      lineMatcher
+	ignore     lineMatcher
+
+	sent      int
+	processed int64
+	// last is the timestamp of the last line sent with one, delay the delay of the last line sent
+	last     time.Time
+	delay    time.Duration
+	inWindow bool
+}
+
+// segment replays one file, done is set once a line after the window is read
+func (r *replay) segment(ctx context.Context, source int, filePath string) (bool, error) {
+	file, scanner, err := r.watcher.openScanner(filePath)
+	if err != nil {
+		return false, &SegmentReadError{FilePath: filePath, Err: err}
+	}
+	if file != nil {
+		defer file.Close()
+	}
+	lineNumber := 0
+	for scanner.Scan() {
+		if err := ctx.Err(); err != nil {
+			return false, err
+		}
+		lineNumber++
+		r.processed += int64(len(scanner.Bytes())) + 1
+		content := stripansi.Strip(scanner.Text())
+
+		ts, dated := extractTime(content)
+		if dated {
+			if !r.to.IsZero() && ts.After(r.to) {
+				return true, nil
+			}
+			r.inWindow = r.from.IsZero() || !ts.Before(r.from)
+		}
+		if !r.inWindow {
+			continue
+		}
+		if (r.ignore != nil && r.ignore.Match([]byte(content))) || !r.match.Match([]byte(content)) {
+			continue
+		}
+
+		line := ReplayLine{
+			LineNumber: lineNumber,
+			Content:    content,
+			Class:      r.classifier.Classify(content, ""),
+			Source:     source,
+		}
+		if dated {
+			r.delay = 0
+			if !r.last.IsZero() && ts.After(r.last) {
+				r.delay = ts.Sub(r.last)
+			}
+			r.last = ts
+			line.Date = ts.String()
+		}
+		line.DelayMs = r.delay.Milliseconds()
+		if err := replayWait(ctx, r.delay, r.speed, r.tracker); err != nil {
+			return false, err
+		}
+		if err := r.emit(line); err != nil {
+			return false, err
+		}
+		r.sent++
+		r.tracker.Progress(r.processed)
+	}
+	if err := scanner.Err(); err != nil {
+		return false, &SegmentReadError{FilePath: filePath, Err: err}
+	}
+	return false, nil
+}
+
+// replayWait holds the next line back by delay scaled by speed, then while the replay is paused
+func replayWait(ctx context.Context, delay time.Duration, speed float64, tracker *JobTracker) error {
+	if speed > 0 && delay > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-GlobalClock.After(time.Duration(float64(delay) / speed)):
+		}
+	}
+	return tracker.WaitWhilePaused(ctx)
+}
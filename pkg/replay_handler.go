@@ -0,0 +1,163 @@
+package pkg
+
+import (
+	"errors"
+	"math"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// ServerEventReplay starts a replay with its job id, sources and time range
+	ServerEventReplay = "replay"
+	// ServerEventReplayEnd ends a replay that sent every line of its window
+	ServerEventReplayEnd = "end"
+)
+
+type ReplayRequest struct {
+	ID       string `json:"id" query:"id"`
+	FilePath string `json:"file_path" query:"file_path" validate:"required" message:"file_path is required"`
+	Host     string `json:"host" query:"host"`
+	Type     string `json:"type" query:"type" validate:"required" message:"type is required"`
+	Query    string `json:"query" query:"query"`
+	Ignore   string `json:"ignore" query:"ignore"`
+	// From and To (RFC 3339) bound the replayed window, a missing bound is open
+	From string `json:"from" query:"from"`
+	To   string `json:"to" query:"to"`
+	// Speed divides the original delays between lines, 0 replays as fast as possible, 1 when missing
+	Speed float64 `json:"speed" query:"speed"`
+}
+
+type ReplayStart struct {
+	JobID     string               `json:"job_id"`
+	Sources   []LineSource         `json:"sources"`
+	TimeRange *TimeRangeResolution `json:"time_range"`
+}
+
+type ReplayEnd struct {
+	Lines int `json:"lines"`
+	// Warnings are the segments skipped because they could not be read
+	Warnings []SegmentWarning `json:"warnings,omitempty"`
+}
+
+type ReplayControlRequest struct {
+	JobID string `json:"job_id" query:"job_id" validate:"required" message:"job_id is required"`
+}
+
+// GetReplay streams the lines of a time window of a local file as server sent events, "line"
+// events paced by the timestamps of the lines. A "replay" event with the job id pausing and
+// resuming the replay is sent first, an "end" event once the window is replayed.
+func (h *APIHandler) GetReplay(c echo.Context) error {
+	req := new(ReplayRequest)
+	if err := BindRequest(c, req); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err)
+	}
+	if err := resolveFileID(req.ID, &req.FilePath, &req.Host, &req.Type); err != nil {
+		return err
+	}
+	if !c.QueryParams().Has("speed") {
+		req.Speed = 1
+	}
+	msgs, err := ValidateRequest(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, msgs)
+	}
+	if req.Speed < 0 || math.IsNaN(req.Speed) || math.IsInf(req.Speed, 0) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "speed must be a number, 0 or more")
+	}
+	from, to, err := parseTimeRange(req.From, req.To)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	if err := AuthorizeFilePath(req.FilePath, req.Type, req.Host); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err)
+	}
+	if req.Type != TypeFile {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "replay is only supported for local files")
+	}
+
+	release, err := acquireTail(c)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	resolution, err := GlobalSegmentTimeRanges.Resolve(LogicalSegments(req.FilePath), from, to)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	watcher, err := NewWatcher(req.FilePath, req.Query, req.Ignore, false, "", "", "", "", "")
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	var total int64
+	sources := make([]LineSource, 0, len(resolution.Consulted))
+	for _, segment := range resolution.FilePaths() {
+		if fileInfo, err := GlobalFileOpener.Stat(segment); err == nil {
+			total += fileInfo.Size()
+		}
+		sources = append(sources, LineSource{
+			FilePath: segment,
+			Host:     req.Host,
+			Type:     req.Type,
+			Label:    fileLabel(segment, req.Type, req.Host),
+		})
+	}
+
+	ctx, tracker := GlobalJobs.Start(c.Request().Context(), JobKindReplay, req.FilePath, total, true)
+	tracker.EnablePause()
+
+	SetHeadersResponseSSE(c.Response().Header())
+	c.Response().WriteHeader(http.StatusOK)
+	start := ReplayStart{JobID: tracker.ID(), Sources: sources, TimeRange: resolution}
+	if err := WriteSSE(c.Response(), ServerEventReplay, start); err != nil {
+		tracker.Finish(err)
+		return nil
+	}
+	sent, warnings, err := watcher.Replay(ctx, resolution.FilePaths(), from, to, req.Speed, tracker, func(line ReplayLine) error {
+		return WriteSSE(c.Response(), TailEventLine, line)
+	})
+	tracker.Finish(err)
+	if err == nil {
+		WriteSSE(c.Response(), ServerEventReplayEnd, ReplayEnd{Lines: sent, Warnings: append(resolution.Warnings, warnings...)}) //nolint: errcheck
+	}
+	return nil
+}
+
+// PostReplayPause holds a replay before its next line
+func (h *AdminHandler) PostReplayPause(c echo.Context) error {
+	return h.controlReplay(c, GlobalJobs.Pause)
+}
+
+// PostReplayResume continues a paused replay
+func (h *AdminHandler) PostReplayResume(c echo.Context) error {
+	return h.controlReplay(c, GlobalJobs.Resume)
+}
+
+func (h *AdminHandler) controlReplay(c echo.Context, control func(id string) error) error {
+	if err := h.authorizeShared(c); err != nil {
+		return err
+	}
+	req := new(ReplayControlRequest)
+	if err := BindRequest(c, req); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err)
+	}
+	// echo binds the query of GET requests only, the job id may come in the query of the POST
+	if req.JobID == "" {
+		req.JobID = c.QueryParam("job_id")
+	}
+	msgs, err := ValidateRequest(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, msgs)
+	}
+	err = control(req.JobID)
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		return echo.NewHTTPError(http.StatusNotFound, err.Error())
+	case errors.Is(err, ErrJobNotPausable):
+		return echo.NewHTTPError(http.StatusConflict, err.Error())
+	}
+	job, _ := GlobalJobs.Get(req.JobID)
+	return c.JSON(http.StatusOK, job)
+}
@@ -0,0 +1,126 @@
+package pkg
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeReplayFixture(t *testing.T) string {
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	content := strings.Join([]string{
+		"2024-06-01T12:00:00Z INFO before the window",
+		"2024-06-01T12:00:02Z ERROR first",
+		"  continuation of first",
+		"2024-06-01T12:00:03Z INFO second",
+		"2024-06-01T12:00:04Z DEBUG filtered out",
+		"2024-06-01T12:00:07Z INFO third",
+		"2024-06-01T12:00:10Z INFO after the window",
+	}, "\n") + "\n"
+	assert.NoError(t, os.WriteFile(logFile, []byte(content), 0600))
+	return logFile
+}
+
+func TestWatcherReplay(t *testing.T) {
+	logFile := writeReplayFixture(t)
+	watcher, err := NewWatcher(logFile, "", "DEBUG", false, "", "", "", "", "")
+	assert.NoError(t, err)
+	from := time.Date(2024, 6, 1, 12, 0, 1, 0, time.UTC)
+	to := time.Date(2024, 6, 1, 12, 0, 8, 0, time.UTC)
+
+	ctx, tracker := NewJobs().Start(context.Background(), JobKindReplay, logFile, 0, true)
+	lines := []ReplayLine{}
+	sent, warnings, err := watcher.Replay(ctx, []string{logFile}, from, to, 0, tracker, func(line ReplayLine) error {
+		lines = append(lines, line)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 4, sent)
+	assert.Empty(t, warnings)
+
+	contents, delays, classes := []string{}, []int64{}, []string{}
+	for _, line := range lines {
+		contents = append(contents, line.Content)
+		delays = append(delays, line.DelayMs)
+		classes = append(classes, line.Class)
+	}
+	assert.Equal(t, []string{"2024-06-01T12:00:02Z ERROR first", "  continuation of first", "2024-06-01T12:00:03Z INFO second", "2024-06-01T12:00:07Z INFO third"}, contents)
+	// the continuation inherits the delay of its line, the filtered line does not count
+	assert.Equal(t, []int64{0, 0, 1000, 4000}, delays)
+	assert.Equal(t, []string{ClassError, ClassUnknown, ClassInfo, ClassInfo}, classes)
+	assert.Equal(t, 2, lines[0].LineNumber)
+}
+
+func TestWatcherReplay_PaceAndPause(t *testing.T) {
+	logFile := writeReplayFixture(t)
+	watcher, err := NewWatcher(logFile, "second|third", "", false, "", "", "", "", "")
+	assert.NoError(t, err)
+	clock := NewManualClock(time.Unix(0, 0))
+	useFakes(t, nil, nil, clock)
+
+	jobs := NewJobs()
+	ctx, tracker := jobs.Start(context.Background(), JobKindReplay, logFile, 0, true)
+	tracker.EnablePause()
+	lines := make(chan ReplayLine)
+	done := make(chan error)
+	go func() {
+		_, _, err := watcher.Replay(ctx, []string{logFile}, time.Time{}, time.Time{}, 2, tracker, func(line ReplayLine) error {
+			lines <- line
+			return nil
+		})
+		done <- err
+	}()
+
+	assert.Equal(t, "2024-06-01T12:00:03Z INFO second", (<-lines).Content)
+	// third is 4s later, held back 2s at speed 2
+	assert.Eventually(t, func() bool { return clock.Timers() == 1 }, time.Second, time.Millisecond)
+	assert.NoError(t, jobs.Pause(tracker.ID()))
+	clock.Advance(2 * time.Second)
+	select {
+	case line := <-lines:
+		t.Fatalf("paused replay sent %q", line.Content)
+	case <-time.After(50 * time.Millisecond):
+	}
+	job, _ := jobs.Get(tracker.ID())
+	assert.True(t, job.Paused)
+
+	assert.NoError(t, jobs.Resume(tracker.ID()))
+	assert.Equal(t, "2024-06-01T12:00:07Z INFO third", (<-lines).Content)
+	assert.NoError(t, <-done)
+	tracker.Finish(nil)
+	assert.ErrorIs(t, jobs.Pause(tracker.ID()), ErrJobNotPausable)
+}
+
+func TestAPIHandler_GetReplay(t *testing.T) {
+	logFile := writeReplayFixture(t)
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: logFile, Type: TypeFile}})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/replay?type=file&file_path="+logFile+query, nil))
+		return rec
+	}
+
+	rec := get("&speed=0&from=2024-06-01T12:00:01Z&to=2024-06-01T12:00:05Z")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	body := rec.Body.String()
+	assert.True(t, strings.HasPrefix(body, "event: replay\n"), body)
+	assert.Equal(t, 4, strings.Count(body, "event: line\n"))
+	assert.Contains(t, body, `"class":"error"`)
+	assert.Contains(t, body, "event: end\ndata: {\"lines\":4}\n\n")
+
+	assert.Equal(t, http.StatusUnprocessableEntity, get("&speed=-1").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, get("&from=yesterday").Code)
+
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/replay/pause?job_id=unknown", nil))
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
@@ -61,6 +61,15 @@ func SetHeadersResponsePlainText(header http.Header, cacheMS string) {
 	header.Set("Content-Security-Policy", "default-src 'none'; img-src 'self'; style-src 'self'; font-src 'self'; connect-src 'self'; script-src 'self';")
 }
 
+func SetHeadersResponseSSE(header http.Header) {
+	header.Set("Cache-Control", "no-cache")
+	header.Set("Connection", "keep-alive")
+	header.Set("Content-Type", "text/event-stream")
+	header.Set("X-Accel-Buffering", "no")
+	// security headers
+	header.Set("X-Content-Type-Options", "nosniff")
+}
+
 func ResponseHTML(c echo.Context, b []byte, cacheMS string) error {
 	SetHeadersResponseHTML(c.Response().Header(), cacheMS)
 	return c.Blob(http.StatusOK, "text/html", b)
@@ -0,0 +1,212 @@
+package pkg
+
+import (
+	"compress/bzip2"
+	"compress/flate"
+	"compress/gzip"
+	"errors"
+	"io"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// DefaultRotationSuffixes are the suffixes logrotate and friends append to rotated files:
+// app.log.1, app.log.2.gz, app.log-20240101, app.log.2024-01-01.gz
+var DefaultRotationSuffixes = []string{
+	`\.\d+`,
+	`[.-]\d{4}-?\d{2}-?\d{2}`,
+}
+
+// RotationSuffix matches the rotation suffix of a file name, with an optional .gz, .zst, .bz2 or .xz
+type RotationSuffix struct {
+	re *regexp.Regexp
+}
+
+// NewRotationSuffixes compiles suffix patterns, each is anchored at the end of the name
+func NewRotationSuffixes(patterns []string) ([]RotationSuffix, error) {
+	suffixes := make([]RotationSuffix, 0, len(patterns))
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(`(` + pattern + `)(\.gz|\.zst|\.bz2|\.xz)?$`)
+		if err != nil {
+			return nil, err
+		}
+		suffixes = append(suffixes, RotationSuffix{re: re})
+	}
+	return suffixes, nil
+}
+
+// RotationBase returns the file path with its rotation suffix removed and the suffix itself.
+// ok is false when the file path has no rotation suffix.
+func RotationBase(filePath string, suffixes []RotationSuffix) (string, string, bool) {
+	for _, suffix := range suffixes {
+		loc := suffix.re.FindStringSubmatchIndex(filePath)
+		if loc == nil || loc[0] == 0 {
+			continue
+		}
+		return filePath[:loc[0]], filePath[loc[2]:loc[3]], true
+	}
+	return "", "", false
+}
+
+// GroupRotatedFileInfos links rotated siblings to the FileInfo of their base file as Segments,
+// ordered oldest first and ending with the base file itself. Only local files are grouped and
+// a group is only formed when the base file itself is watched.
+func GroupRotatedFileInfos(fileInfos []FileInfo, suffixes []RotationSuffix) []FileInfo {
+	if len(suffixes) == 0 {
+		return fileInfos
+	}
+	bases := map[string]int{}
+	for i, fileInfo := range fileInfos {
+		fileInfos[i].Segments = nil
+		if fileInfo.Type == TypeFile {
+			bases[fileInfo.FilePath] = i
+		}
+	}
+
+	type segment struct {
+		fileInfo FileInfo
+		suffix   string
+	}
+	siblings := map[int][]segment{}
+	for _, fileInfo := range fileInfos {
+		if fileInfo.Type != TypeFile {
+			continue
+		}
+		base, suffix, ok := RotationBase(fileInfo.FilePath, suffixes)
+		if !ok {
+			continue
+		}
+		i, ok := bases[base]
+		if !ok {
+			continue
+		}
+		siblings[i] = append(siblings[i], segment{fileInfo: fileInfo, suffix: suffix})
+	}
+
+	for i, segments := range siblings {
+		sort.SliceStable(segments, func(a, b int) bool {
+			return rotationOlder(segments[a].suffix, segments[b].suffix)
+		})
+		group := make([]FileInfo, 0, len(segments)+1)
+		for _, s := range segments {
+			group = append(group, s.fileInfo)
+		}
+		base := fileInfos[i]
+		base.Segments = nil
+		fileInfos[i].Segments = append(group, base)
+	}
+	return fileInfos
+}
+
+// rotationOlder tells whether the segment with suffix a was rotated before the one with suffix b.
+// Dated suffixes are older by date, numbered ones are older the higher the number,
+// and dated segments are taken to be older than numbered ones. Both are compared in the natural
+// order of the file list.
+func rotationOlder(a, b string) bool {
+	aNumeric, bNumeric := rotationNumbered(a), rotationNumbered(b)
+	switch {
+	case aNumeric && bNumeric:
+		return CompareNatural(b, a) < 0
+	case aNumeric != bNumeric:
+		return bNumeric
+	}
+	return CompareNatural(rotationDate(a), rotationDate(b)) < 0
+}
+
+func rotationNumbered(suffix string) bool {
+	_, err := strconv.Atoi(strings.TrimLeft(suffix, ".-"))
+	return err == nil && len(strings.TrimLeft(suffix, ".-")) < 8
+}
+
+func rotationDate(suffix string) string {
+	return strings.NewReplacer(".", "", "-", "").Replace(suffix)
+}
+
+// LogicalSegments returns the physical files of the logical log filePath belongs to, oldest first.
+// A file that is not the base of a rotation group is its own single segment.
+func LogicalSegments(filePath string) []string {
+	for _, fileInfo := range GlobalFileRegistry.Snapshot() {
+		if fileInfo.FilePath != filePath || len(fileInfo.Segments) == 0 {
+			continue
+		}
+		segments := make([]string, 0, len(fileInfo.Segments))
+		for _, segment := range fileInfo.Segments {
+			segments = append(segments, segment.FilePath)
+		}
+		return segments
+	}
+	return []string{filePath}
+}
+
+// RotatedSibling is the file filePath was last rotated to: the segment before it in its rotation group,
+// or else filePath.1. It is empty when there is none.
+func RotatedSibling(filePath string) string {
+	segments := LogicalSegments(filePath)
+	if n := len(segments); n > 1 && segments[n-1] == filePath {
+		return segments[n-2]
+	}
+	if info, err := GlobalFileOpener.Stat(filePath + ".1"); err == nil && !info.IsDir() {
+		return filePath + ".1"
+	}
+	return ""
+}
+
+// SegmentWarning is a segment of a logical log skipped because it could not be read
+type SegmentWarning struct {
+	FilePath string `json:"file_path"`
+	Error    string `json:"error"`
+}
+
+// SegmentReadError is the failure to read one segment, the reads of a logical log skip it
+type SegmentReadError struct {
+	FilePath string
+	Err      error
+}
+
+func (e *SegmentReadError) Error() string {
+	return e.FilePath + ": " + e.Err.Error()
+}
+
+func (e *SegmentReadError) Unwrap() error {
+	return e.Err
+}
+
+// Warning is the warning naming the skipped segment
+func (e *SegmentReadError) Warning() SegmentWarning {
+	return SegmentWarning{FilePath: e.FilePath, Error: e.Err.Error()}
+}
+
+// IsCorrupt tells whether err comes from a damaged file, like a gzip member truncated by a full disk
+func IsCorrupt(err error) bool {
+	var corruptInput flate.CorruptInputError
+	var bzip2Structural bzip2.StructuralError
+	return errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, gzip.ErrChecksum) ||
+		errors.Is(err, gzip.ErrHeader) ||
+		errors.Is(err, zstd.ErrCRCMismatch) ||
+		errors.Is(err, zstd.ErrMagicMismatch) ||
+		errors.As(err, &corruptInput) ||
+		errors.As(err, &bzip2Structural)
+}
+
+// IsRotatedSegment tells whether fileInfo is a rotated sibling listed under another file's segments
+func IsRotatedSegment(fileInfo FileInfo, fileInfos []FileInfo) bool {
+	if fileInfo.Type != TypeFile {
+		return false
+	}
+	for _, base := range fileInfos {
+		if base.FilePath == fileInfo.FilePath {
+			continue
+		}
+		for _, segment := range base.Segments {
+			if segment.FilePath == fileInfo.FilePath {
+				return true
+			}
+		}
+	}
+	return false
+}
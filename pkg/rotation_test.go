@@ -0,0 +1,257 @@
+package pkg
+
+import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRotationBase(t *testing.T) {
+	suffixes, err := NewRotationSuffixes(DefaultRotationSuffixes)
+	assert.NoError(t, err)
+
+	type TestCase struct {
+		FilePath   string
+		WantBase   string
+		WantSuffix string
+		WantOK     bool
+	}
+
+	testCases := []TestCase{
+		{FilePath: "/var/log/app.log", WantOK: false},
+		{FilePath: "/var/log/app.log.1", WantBase: "/var/log/app.log", WantSuffix: ".1", WantOK: true},
+		{FilePath: "/var/log/app.log.2.gz", WantBase: "/var/log/app.log", WantSuffix: ".2", WantOK: true},
+		{FilePath: "/var/log/app.log-20240101", WantBase: "/var/log/app.log", WantSuffix: "-20240101", WantOK: true},
+		{FilePath: "/var/log/app.log.2024-01-01.gz", WantBase: "/var/log/app.log", WantSuffix: ".2024-01-01", WantOK: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.FilePath, func(t *testing.T) {
+			base, suffix, ok := RotationBase(tc.FilePath, suffixes)
+			assert.Equal(t, tc.WantOK, ok)
+			assert.Equal(t, tc.WantBase, base)
+			assert.Equal(t, tc.WantSuffix, suffix)
+		})
+	}
+}
+
+func TestGroupRotatedFileInfos(t *testing.T) {
+	suffixes, err := NewRotationSuffixes(DefaultRotationSuffixes)
+	assert.NoError(t, err)
+
+	fileInfos := []FileInfo{
+		{FilePath: "app.log.1", Type: TypeFile},
+		{FilePath: "app.log", Type: TypeFile},
+		{FilePath: "app.log.2.gz", Type: TypeFile},
+		{FilePath: "app.log.10.gz", Type: TypeFile},
+		{FilePath: "other.log.1", Type: TypeFile},
+		{FilePath: "app.log.1", Type: TypeSSH, Host: "remote"},
+	}
+	grouped := GroupRotatedFileInfos(fileInfos, suffixes)
+
+	segments := []string{}
+	for _, segment := range grouped[1].Segments {
+		segments = append(segments, segment.FilePath)
+	}
+	assert.Equal(t, []string{"app.log.10.gz", "app.log.2.gz", "app.log.1", "app.log"}, segments)
+	assert.Empty(t, grouped[4].Segments)
+
+	logical := FilterFileInfos(grouped, &FileListRequest{Logical: true})
+	paths := []string{}
+	for _, fileInfo := range logical {
+		paths = append(paths, fileInfo.FilePath)
+	}
+	assert.Equal(t, []string{"app.log", "other.log.1", "app.log.1"}, paths)
+
+	// dated segments, older than the numbered ones
+	grouped = GroupRotatedFileInfos([]FileInfo{
+		{FilePath: "web.log", Type: TypeFile},
+		{FilePath: "web.log.1", Type: TypeFile},
+		{FilePath: "web.log-20241001", Type: TypeFile},
+		{FilePath: "web.log.2024-09-30.gz", Type: TypeFile},
+	}, suffixes)
+	segments = []string{}
+	for _, segment := range grouped[0].Segments {
+		segments = append(segments, segment.FilePath)
+	}
+	assert.Equal(t, []string{"web.log.2024-09-30.gz", "web.log-20241001", "web.log.1", "web.log"}, segments)
+
+	// disabled
+	assert.Empty(t, GroupRotatedFileInfos([]FileInfo{{FilePath: "app.log", Type: TypeFile}, {FilePath: "app.log.1", Type: TypeFile}}, nil)[0].Segments)
+}
+
+func TestWatcher_ScanSegments(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+
+	f, err := os.Create(logFile + ".2.gz")
+	assert.NoError(t, err)
+	gz := gzip.NewWriter(f)
+	_, err = gz.Write([]byte("ERROR oldest\nINFO ok\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, gz.Close())
+	f.Close()
+	assert.NoError(t, os.WriteFile(logFile+".1", []byte("INFO ok\nERROR older\n"), 0600))
+	assert.NoError(t, os.WriteFile(logFile, []byte("ERROR newest\n"), 0600))
+
+	watcher, err := NewWatcher(logFile, "ERROR", "", false, "", "", "", "", "")
+	assert.NoError(t, err)
+
+	result, err := watcher.ScanSegments([]string{logFile + ".2.gz", logFile + ".1", logFile}, 1, 10, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, result.Total)
+	assert.Equal(t, "ERROR oldest", result.Lines[0].Content)
+	assert.Equal(t, logFile+".2.gz", result.Sources[result.Lines[0].Source].FilePath)
+	assert.Equal(t, 1, result.Lines[0].LineNumber)
+	assert.Equal(t, "ERROR older", result.Lines[1].Content)
+	assert.Equal(t, logFile+".1", result.Sources[result.Lines[1].Source].FilePath)
+	assert.Equal(t, 2, result.Lines[1].LineNumber)
+	assert.Equal(t, "ERROR newest", result.Lines[2].Content)
+	assert.Equal(t, logFile, result.Sources[result.Lines[2].Source].FilePath)
+	assert.Len(t, result.Sources, 3)
+
+	// reverse reads the newest page first
+	result, err = watcher.ScanSegments([]string{logFile + ".2.gz", logFile + ".1", logFile}, 1, 2, true)
+	assert.NoError(t, err)
+	assert.Equal(t, "ERROR older", result.Lines[0].Content)
+	assert.Equal(t, "ERROR newest", result.Lines[1].Content)
+}
+
+func TestWatcher_ScanSegments_TruncatedSegment(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+
+	// testdata/truncated.log.gz is a gzip member cut in half, as left by a full disk
+	truncated, err := os.ReadFile(filepath.Join("testdata", "truncated.log.gz"))
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(logFile+".2.gz", truncated, 0600))
+	assert.NoError(t, os.WriteFile(logFile+".1", []byte("2024-01-02T10:00:00Z ERROR older\n"), 0600))
+	assert.NoError(t, os.WriteFile(logFile, []byte("2024-01-03T10:00:00Z ERROR newest\n"), 0600))
+	segments := []string{logFile + ".2.gz", logFile + ".1", logFile}
+
+	watcher, err := NewWatcher(logFile, "ERROR", "", false, "", "", "", "", "")
+	assert.NoError(t, err)
+
+	result, err := watcher.ScanSegments(segments, 1, 10, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 2, result.Total)
+	assert.Equal(t, "2024-01-02T10:00:00Z ERROR older", result.Lines[0].Content)
+	assert.Equal(t, logFile+".1", result.Sources[result.Lines[0].Source].FilePath)
+	assert.Len(t, result.Warnings, 1)
+	assert.Equal(t, logFile+".2.gz", result.Warnings[0].FilePath)
+	assert.Contains(t, result.Warnings[0].Error, "unexpected EOF")
+
+	// alone, the segment is still an error
+	_, err = watcher.ScanSegments(segments[:1], 1, 10, false)
+	assert.Error(t, err)
+	assert.True(t, IsCorrupt(err))
+
+	resolution, err := NewSegmentTimeRanges().Resolve(segments, time.Time{}, time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC))
+	assert.NoError(t, err)
+	assert.Equal(t, []string{logFile + ".1"}, resolution.FilePaths())
+	assert.Len(t, resolution.Warnings, 1)
+
+	ctx, tracker := NewJobs().Start(context.Background(), JobKindReplay, logFile, 0, true)
+	// a replay streams the lines read before the damage, then goes on with the next segment
+	lines := []ReplayLine{}
+	sent, warnings, err := watcher.Replay(ctx, segments, time.Time{}, time.Time{}, 0, tracker, func(line ReplayLine) error {
+		lines = append(lines, line)
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, len(lines), sent)
+	assert.Greater(t, sent, 2)
+	assert.Equal(t, "2024-01-03T10:00:00Z ERROR newest", lines[len(lines)-1].Content)
+	assert.Len(t, warnings, 1)
+
+	// the file list keeps the segment, marked corrupt
+	fileInfos, err := GetFileInfosContext(context.Background(), filepath.Join(dir, "app.log*"), 10, false, nil)
+	assert.NoError(t, err)
+	assert.Len(t, fileInfos, 3)
+	for _, fileInfo := range fileInfos {
+		assert.Equal(t, fileInfo.FilePath == logFile+".2.gz", fileInfo.Corrupt != "", fileInfo.FilePath)
+	}
+}
+
+func TestWatcher_TailFollowRotation(t *testing.T) {
+	rotations := map[string]func(logFile string) error{
+		"copytruncate": func(logFile string) error {
+			content, err := os.ReadFile(logFile)
+			if err != nil {
+				return err
+			}
+			if err := os.WriteFile(logFile+".1", content, 0600); err != nil {
+				return err
+			}
+			return os.Truncate(logFile, 0)
+		},
+		"rename and create": func(logFile string) error {
+			return os.Rename(logFile, logFile+".1")
+		},
+	}
+	for name, rotate := range rotations {
+		t.Run(name, func(t *testing.T) {
+			logFile := filepath.Join(t.TempDir(), "app.log")
+			assert.NoError(t, os.WriteFile(logFile, []byte("old 1\nold 2\nold 3\nold 4\n"), 0600))
+			_, _, err := FileStatsContext(context.Background(), logFile, false, nil)
+			assert.NoError(t, err)
+			assert.NoError(t, rotate(logFile))
+			f, err := os.OpenFile(logFile, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+			assert.NoError(t, err)
+			_, err = f.WriteString("new 1\nnew 2\n")
+			assert.NoError(t, err)
+			assert.NoError(t, f.Close())
+
+			watcher, err := NewWatcher(logFile, "", "", false, "", "", "", "", "")
+			assert.NoError(t, err)
+			result, err := watcher.Tail(context.Background(), 3)
+			assert.NoError(t, err)
+			assert.Len(t, result.Lines, 2)
+
+			// the tail continues into app.log.1, numbered as in it
+			watcher.SetFollowRotation(true)
+			result, err = watcher.Tail(context.Background(), 3)
+			assert.NoError(t, err)
+			if assert.Len(t, result.Lines, 3) && assert.Len(t, result.Sources, 2) {
+				assert.Equal(t, "old 4", result.Lines[0].Content)
+				assert.Equal(t, 4, result.Lines[0].LineNumber)
+				assert.Equal(t, logFile+".1", result.Sources[result.Lines[0].Source].FilePath)
+				assert.Equal(t, 1, result.Lines[1].LineNumber)
+				assert.Equal(t, logFile, result.Sources[result.Lines[2].Source].FilePath)
+			}
+			// the line counts of the file were reset by the rotation
+			assert.Equal(t, 2, result.Total)
+		})
+	}
+}
+
+func TestAPIHandler_GetFollowRotation(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+	assert.NoError(t, os.WriteFile(logFile+".1", []byte("old 1\nold 2\n"), 0600))
+	assert.NoError(t, os.WriteFile(logFile, []byte("new 1\n"), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: logFile, Type: TypeFile}})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	get := func(query string) *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api?type=file&file_path="+logFile+query, nil))
+		return rec
+	}
+
+	rec := get("&tail=10&follow_rotation=true")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	res := APIResponse{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+	assert.Len(t, res.Result.Lines, 3)
+
+	assert.Equal(t, http.StatusUnprocessableEntity, get("&follow_rotation=true").Code)
+	assert.Equal(t, http.StatusUnprocessableEntity, get("&tail=10&follow_rotation=true&multiline=true").Code)
+}
@@ -0,0 +1,252 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awshttp "github.com/aws/aws-sdk-go-v2/aws/transport/http"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/aws/smithy-go"
+)
+
+const (
+	// DefaultS3Region is the region of the requests when neither the environment nor the shared config name one
+	DefaultS3Region = "us-east-1"
+	// s3ListPageSize is the number of keys asked for per ListObjectsV2 page
+	s3ListPageSize = 1000
+)
+
+// IsS3URL tells whether a file path is the URL of an S3 object, s3://bucket/key
+func IsS3URL(s string) bool {
+	return strings.HasPrefix(s, "s3://")
+}
+
+// S3URL is the file path of key in bucket
+func S3URL(bucket string, key string) string {
+	return "s3://" + bucket + "/" + key
+}
+
+// splitS3URL returns the bucket and key of an S3 URL
+func splitS3URL(s string) (string, string) {
+	bucket, key, _ := strings.Cut(strings.TrimPrefix(s, "s3://"), "/")
+	return bucket, key
+}
+
+// S3PathConfig is a pattern of -s3: the keys of Bucket matching Pattern, whose * does not match a /
+type S3PathConfig struct {
+	Bucket  string
+	Pattern string
+}
+
+// s is an input of the form "s3://bucket/prefix/*.log"
+func StringToS3PathConfig(s string) (*S3PathConfig, error) {
+	s = strings.TrimSpace(s)
+	bucket, pattern := splitS3URL(s)
+	if !IsS3URL(s) || bucket == "" || pattern == "" {
+		return nil, fmt.Errorf("input string does not have the correct format, s3://bucket/prefix/*.log")
+	}
+	if _, err := path.Match(pattern, ""); err != nil {
+		return nil, fmt.Errorf("pattern %q: %w", pattern, err)
+	}
+	return &S3PathConfig{Bucket: bucket, Pattern: pattern}, nil
+}
+
+// Prefix is the pattern up to its first wildcard, the prefix the keys are listed under
+func (c *S3PathConfig) Prefix() string {
+	if i := strings.IndexAny(c.Pattern, "*?[\\"); i >= 0 {
+		return c.Pattern[:i]
+	}
+	return c.Pattern
+}
+
+// S3Error is an S3 request answered with an error status, with the code and message of its body. The
+// SDK derives both from the status of a body without them, like the body of a HEAD request.
+type S3Error struct {
+	StatusCode int
+	Code       string
+	Message    string
+}
+
+func (e *S3Error) Error() string {
+	switch {
+	case e.Code == "" || e.Message == http.StatusText(e.StatusCode):
+		return fmt.Sprintf("s3: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	case e.Message == "":
+		return "s3: " + e.Code
+	}
+	return fmt.Sprintf("s3: %s: %s", e.Code, e.Message)
+}
+
+// s3Error is err as an S3Error when S3 answered it, err as is when the request was not answered
+func s3Error(err error) error {
+	var responseErr *awshttp.ResponseError
+	if !errors.As(err, &responseErr) {
+		return err
+	}
+	s3Err := &S3Error{StatusCode: responseErr.HTTPStatusCode()}
+	var apiErr smithy.APIError
+	if errors.As(err, &apiErr) {
+		s3Err.Code, s3Err.Message = apiErr.ErrorCode(), apiErr.ErrorMessage()
+	}
+	return s3Err
+}
+
+// S3Client sends the requests of the AWS SDK to AWS, or path style to the endpoint of an S3 compatible
+// store like MinIO
+type S3Client struct {
+	client *s3.Client
+}
+
+// NewS3Client loads the config of the default credential chain of the AWS SDK: the environment, the
+// shared config and credentials files, and the instance and container roles. The region is
+// DefaultS3Region when none is configured. Requests are sent to endpoint, AWS when empty. optFns
+// are applied to the loaded config, like the credentials or the HTTP client of tests.
+func NewS3Client(ctx context.Context, endpoint string, optFns ...func(*config.LoadOptions) error) (*S3Client, error) {
+	if endpoint != "" {
+		u, err := url.Parse(endpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			return nil, fmt.Errorf("s3 endpoint must be an http(s) URL, got %q", endpoint)
+		}
+	}
+	cfg, err := config.LoadDefaultConfig(ctx, optFns...)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.Region == "" {
+		cfg.Region = DefaultS3Region
+	}
+	client := s3.NewFromConfig(cfg, func(o *s3.Options) {
+		if endpoint != "" {
+			o.BaseEndpoint = aws.String(strings.TrimSuffix(endpoint, "/"))
+			o.UsePathStyle = true
+		}
+	})
+	return &S3Client{client: client}, nil
+}
+
+// S3Object is a key listed by ListObjects
+type S3Object struct {
+	Key          string
+	Size         int64
+	LastModified time.Time
+}
+
+// ListObjects lists the keys of bucket under prefix that match, paging through ListObjectsV2 until
+// limit keys matched or the listing ends
+func (c *S3Client) ListObjects(ctx context.Context, bucket string, prefix string, limit int, match func(key string) bool) ([]S3Object, error) {
+	objects := []S3Object{}
+	paginator := s3.NewListObjectsV2Paginator(c.client, &s3.ListObjectsV2Input{
+		Bucket:  aws.String(bucket),
+		Prefix:  aws.String(prefix),
+		MaxKeys: aws.Int32(s3ListPageSize),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, s3Error(err)
+		}
+		for _, object := range page.Contents {
+			key := aws.ToString(object.Key)
+			if !match(key) {
+				continue
+			}
+			objects = append(objects, S3Object{Key: key, Size: aws.ToInt64(object.Size), LastModified: aws.ToTime(object.LastModified)})
+			if len(objects) >= limit {
+				return objects, nil
+			}
+		}
+	}
+	return objects, nil
+}
+
+// Open opens key in bucket for ranged reads, its size and modification time from HeadObject
+func (c *S3Client) Open(ctx context.Context, bucket string, key string) (File, error) {
+	head, err := c.client.HeadObject(ctx, &s3.HeadObjectInput{Bucket: aws.String(bucket), Key: aws.String(key)})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", S3URL(bucket, key), s3Error(err))
+	}
+	info := httpFileInfo{name: path.Base(key), size: max(aws.ToInt64(head.ContentLength), 0), modTime: aws.ToTime(head.LastModified)}
+	get := func(ctx context.Context, byteRange string) (*http.Response, error) {
+		object, err := c.client.GetObject(ctx, &s3.GetObjectInput{Bucket: aws.String(bucket), Key: aws.String(key), Range: aws.String("bytes=" + byteRange)})
+		if err != nil {
+			return nil, fmt.Errorf("%s: %w", S3URL(bucket, key), s3Error(err))
+		}
+		// a store ignoring the range answers the whole object
+		res := &http.Response{StatusCode: http.StatusOK, Body: object.Body}
+		if object.ContentRange != nil {
+			res.StatusCode = http.StatusPartialContent
+		}
+		return res, nil
+	}
+	return &httpFile{get: get, name: S3URL(bucket, key), info: info}, nil
+}
+
+// S3Source lists the keys of the -s3 patterns. Listing reads no object, their lines are counted by
+// the first read needing the count, which caches it for the following listings.
+type S3Source struct {
+	client   *S3Client
+	patterns []string
+}
+
+func NewS3Source(client *S3Client, patterns []string) *S3Source {
+	return &S3Source{client: client, patterns: patterns}
+}
+
+// Refresh lists the keys matching every pattern, up to limit per pattern, and the status of every pattern
+func (s *S3Source) Refresh(ctx context.Context, limit int) ([]FileInfo, []SourceStatus) {
+	fileInfos := []FileInfo{}
+	statuses := []SourceStatus{}
+	for _, pattern := range s.patterns {
+		config, err := StringToS3PathConfig(pattern)
+		if err != nil {
+			slog.Error("parsing s3 path", pattern, err)
+			statuses = append(statuses, newSourceStatus(pattern, TypeS3, "", nil, err))
+			continue
+		}
+		objects, err := s.client.ListObjects(ctx, config.Bucket, config.Prefix(), limit, func(key string) bool {
+			matched, _ := path.Match(config.Pattern, key)
+			return matched
+		})
+		if err != nil {
+			slog.Error("listing s3 keys", "pattern", pattern, "error", err)
+			statuses = append(statuses, newSourceStatus(pattern, TypeS3, config.Bucket, nil, err))
+			continue
+		}
+		if len(objects) >= limit {
+			slog.Warn("Limiting to keys", "s3", limit)
+		}
+		patternInfos := []FileInfo{}
+		for _, object := range objects {
+			// HEAD answers Last-Modified to the second
+			modTime := object.LastModified.Truncate(time.Second)
+			fileInfo := FileInfo{
+				FilePath: S3URL(config.Bucket, object.Key),
+				Type:     TypeS3,
+				Host:     config.Bucket,
+				Name:     path.Base(object.Key),
+				FileSize: object.Size,
+				ModTime:  &modTime,
+			}
+			setCachedLinesCount(&fileInfo, modTime)
+			patternInfos = append(patternInfos, fileInfo)
+		}
+		statuses = append(statuses, newSourceStatus(pattern, TypeS3, config.Bucket, patternInfos, nil))
+		fileInfos = append(fileInfos, patternInfos...)
+	}
+	return fileInfos, statuses
+}
+
+// Open opens the object of an S3 URL
+func (s *S3Source) Open(ctx context.Context, filePath string) (File, error) {
+	bucket, key := splitS3URL(filePath)
+	return s.client.Open(ctx, bucket, key)
+}
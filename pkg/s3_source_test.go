@@ -0,0 +1,232 @@
+package pkg
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStringToS3PathConfig(t *testing.T) {
+	config, err := StringToS3PathConfig("s3://logs/app/2024-*/*.log.gz")
+	assert.NoError(t, err)
+	assert.Equal(t, &S3PathConfig{Bucket: "logs", Pattern: "app/2024-*/*.log.gz"}, config)
+	assert.Equal(t, "app/2024-", config.Prefix())
+
+	config, err = StringToS3PathConfig("s3://logs/app/current.log")
+	assert.NoError(t, err)
+	assert.Equal(t, "app/current.log", config.Prefix())
+
+	for _, invalid := range []string{"logs/app/*.log", "s3://logs", "s3:///app/*.log", "s3://logs/app/[.log"} {
+		_, err := StringToS3PathConfig(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// isolateAWSConfig keeps the AWS environment and shared files of the machine out of a test
+func isolateAWSConfig(t *testing.T) string {
+	dir := t.TempDir()
+	for _, name := range []string{"AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY", "AWS_SESSION_TOKEN", "AWS_REGION", "AWS_DEFAULT_REGION", "AWS_PROFILE", "AWS_CA_BUNDLE"} {
+		t.Setenv(name, "")
+	}
+	t.Setenv("AWS_SHARED_CREDENTIALS_FILE", filepath.Join(dir, "credentials"))
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "config"))
+	t.Setenv("AWS_EC2_METADATA_DISABLED", "true")
+	return dir
+}
+
+func TestNewS3Client(t *testing.T) {
+	dir := isolateAWSConfig(t)
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "credentials"), []byte("[default]\naws_access_key_id = AKID1\naws_secret_access_key = SECRET1\n\n[ops]\naws_access_key_id=AKID2\naws_secret_access_key=SECRET2\naws_session_token=TOKEN2\n"), 0600))
+	assert.NoError(t, os.WriteFile(filepath.Join(dir, "config"), []byte("[default]\nregion = eu-west-1\n[profile ops]\nregion = ap-northeast-1\n"), 0600))
+
+	loaded := func() (aws.Credentials, string) {
+		client, err := NewS3Client(context.Background(), "")
+		assert.NoError(t, err)
+		options := client.client.Options()
+		credentials, err := options.Credentials.Retrieve(context.Background())
+		assert.NoError(t, err)
+		return credentials, options.Region
+	}
+	credentials, region := loaded()
+	assert.Equal(t, "AKID1", credentials.AccessKeyID)
+	assert.Equal(t, "eu-west-1", region)
+
+	t.Setenv("AWS_PROFILE", "ops")
+	credentials, region = loaded()
+	assert.Equal(t, "AKID2", credentials.AccessKeyID)
+	assert.Equal(t, "TOKEN2", credentials.SessionToken)
+	assert.Equal(t, "ap-northeast-1", region)
+
+	// the environment comes first
+	t.Setenv("AWS_ACCESS_KEY_ID", "AKID3")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "SECRET3")
+	t.Setenv("AWS_REGION", "us-west-2")
+	credentials, region = loaded()
+	assert.Equal(t, "AKID3", credentials.AccessKeyID)
+	assert.Equal(t, "us-west-2", region)
+
+	// without a region configured
+	t.Setenv("AWS_REGION", "")
+	t.Setenv("AWS_CONFIG_FILE", filepath.Join(dir, "missing"))
+	_, region = loaded()
+	assert.Equal(t, DefaultS3Region, region)
+
+	// -s3-endpoint is addressed path style
+	client, err := NewS3Client(context.Background(), "http://localhost:9000/")
+	assert.NoError(t, err)
+	assert.Equal(t, "http://localhost:9000", aws.ToString(client.client.Options().BaseEndpoint))
+	assert.True(t, client.client.Options().UsePathStyle)
+	for _, invalid := range []string{"localhost:9000", "ftp://localhost", "http://"} {
+		_, err := NewS3Client(context.Background(), invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+// newS3Server is a path style S3 store of objects, listing two keys per page
+func newS3Server(t *testing.T, bucket string, objects map[string][]byte) (*httptest.Server, *httpRequests) {
+	requests := &httpRequests{}
+	modTime := time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC)
+	keys := make([]string, 0, len(objects))
+	for key := range objects {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.add(r)
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			w.WriteHeader(http.StatusForbidden)
+			fmt.Fprint(w, "<Error><Code>AccessDenied</Code><Message>Access Denied</Message></Error>")
+			return
+		}
+		key, ok := strings.CutPrefix(r.URL.Path, "/"+bucket)
+		key = strings.TrimPrefix(key, "/")
+		if !ok || (key == "" && r.URL.Query().Get("list-type") != "2") {
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, "<Error><Code>NoSuchBucket</Code><Message>The specified bucket does not exist</Message></Error>")
+			return
+		}
+		if key != "" {
+			content, ok := objects[key]
+			if !ok {
+				w.WriteHeader(http.StatusNotFound)
+				fmt.Fprint(w, "<Error><Code>NoSuchKey</Code><Message>The specified key does not exist.</Message></Error>")
+				return
+			}
+			http.ServeContent(w, r, key, modTime, strings.NewReader(string(content)))
+			return
+		}
+		query := r.URL.Query()
+		matching := []string{}
+		for _, key := range keys {
+			if strings.HasPrefix(key, query.Get("prefix")) {
+				matching = append(matching, key)
+			}
+		}
+		start, _ := strconv.Atoi(query.Get("continuation-token"))
+		end := min(start+2, len(matching))
+		fmt.Fprint(w, "<ListBucketResult>")
+		for _, key := range matching[start:end] {
+			fmt.Fprintf(w, "<Contents><Key>%s</Key><LastModified>2024-05-01T10:00:00.000Z</LastModified><Size>%d</Size></Contents>", key, len(objects[key]))
+		}
+		if end < len(matching) {
+			fmt.Fprintf(w, "<IsTruncated>true</IsTruncated><NextContinuationToken>%d</NextContinuationToken>", end)
+		}
+		fmt.Fprint(w, "</ListBucketResult>")
+	}))
+	t.Cleanup(server.Close)
+	return server, requests
+}
+
+func TestS3Source(t *testing.T) {
+	cache := GlobalFileStatsCache
+	t.Cleanup(func() { GlobalFileStatsCache = cache })
+	GlobalFileStatsCache = NewFileStatsCache()
+	content := numberedLines(1, 5000)
+	server, requests := newS3Server(t, "logs", map[string][]byte{
+		"app/2024-05-01.log":    []byte(content),
+		"app/2024-05-02.log.gz": gzipped(t, numberedLines(1, 20)),
+		"app/2024-05-03.log":    []byte("line 1\n"),
+		"app/sub/nested.log":    []byte("line 1\n"),
+		"app/notes.txt":         []byte("line 1\n"),
+		"other/app.log":         []byte("line 1\n"),
+	})
+	isolateAWSConfig(t)
+	client, err := NewS3Client(context.Background(), server.URL, config.WithCredentialsProvider(credentials.NewStaticCredentialsProvider("AKID", "SECRET", "")))
+	assert.NoError(t, err)
+	sources := GlobalS3Source
+	t.Cleanup(func() { GlobalS3Source = sources })
+	GlobalS3Source = NewS3Source(client, []string{"s3://logs/app/*.log*", "s3://missing/*.log"})
+
+	// listing pages through the keys and reads none of them
+	fileInfos, statuses := GlobalS3Source.Refresh(context.Background(), 1000)
+	filePaths := []string{}
+	for _, fileInfo := range fileInfos {
+		filePaths = append(filePaths, fileInfo.FilePath)
+	}
+	assert.Equal(t, []string{"s3://logs/app/2024-05-01.log", "s3://logs/app/2024-05-02.log.gz", "s3://logs/app/2024-05-03.log"}, filePaths)
+	assert.Equal(t, FileInfo{}.LinesCount, fileInfos[0].LinesCount)
+	assert.Equal(t, int64(len(content)), fileInfos[0].FileSize)
+	assert.Equal(t, TypeS3, fileInfos[0].Type)
+	assert.Equal(t, "logs", fileInfos[0].Host)
+	assert.Equal(t, "2024-05-01.log", fileInfos[0].Name)
+	assert.Len(t, statuses, 2)
+	assert.Equal(t, 3, statuses[0].Files)
+	// the SDK reads no message for the errors it models
+	assert.Equal(t, "s3: NoSuchBucket", statuses[1].Error)
+	requests.mutex.Lock()
+	for _, req := range requests.requests {
+		assert.Equal(t, "2", req.URL.Query().Get("list-type"))
+	}
+	requests.mutex.Unlock()
+
+	// -limit caps the keys loaded
+	fileInfos, _ = GlobalS3Source.Refresh(context.Background(), 2)
+	assert.Len(t, fileInfos, 2)
+
+	// reads are ranged
+	requests.mutex.Lock()
+	requests.requests = nil
+	requests.mutex.Unlock()
+	lines, err := ReadTailLinesContext(context.Background(), "s3://logs/app/2024-05-01.log", 2, false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"line 4999", "line 5000"}, lines)
+	for _, byteRange := range requests.gets() {
+		assert.NotEmpty(t, byteRange)
+	}
+
+	// compressed keys are decompressed like local files
+	lines, err = ReadTailLinesContext(context.Background(), "s3://logs/app/2024-05-02.log.gz", 2, false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"line 19", "line 20"}, lines)
+	linesCount, _, err := FileStatsContext(context.Background(), "s3://logs/app/2024-05-02.log.gz", false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 20, linesCount)
+
+	// the count of a read is listed until the key changes
+	_, _, err = FileStatsContext(context.Background(), "s3://logs/app/2024-05-01.log", false, nil)
+	assert.NoError(t, err)
+	fileInfos, _ = GlobalS3Source.Refresh(context.Background(), 1000)
+	assert.Equal(t, 5000, fileInfos[0].LinesCount)
+	assert.Equal(t, 20, fileInfos[1].LinesCount)
+
+	// requests without the credentials are denied
+	anonymous, err := NewS3Client(context.Background(), server.URL, config.WithCredentialsProvider(aws.AnonymousCredentials{}))
+	assert.NoError(t, err)
+	_, err = anonymous.Open(context.Background(), "logs", "app/2024-05-03.log")
+	assert.ErrorContains(t, err, "s3: 403 Forbidden")
+	_, statuses = NewS3Source(anonymous, []string{"s3://logs/app/*.log"}).Refresh(context.Background(), 1000)
+	assert.Equal(t, "s3: AccessDenied: Access Denied", statuses[0].Error)
+}
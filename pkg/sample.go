@@ -0,0 +1,116 @@
+package pkg
+
+import (
+	"errors"
+	"hash/fnv"
+	"io"
+	"math"
+)
+
+// z score of a 95% confidence interval
+const sampleConfidenceZ = 1.96
+
+// Sampler keeps a deterministic subset of the lines of a scan, either a fraction of all lines (Rate)
+// or every nth matching line (Every). It is seeded by the fingerprint of the file, so repeated
+// scans of an unchanged file return the same lines.
+type Sampler struct {
+	Rate  float64
+	Every int
+
+	seed uint32
+	// lines the pattern was tested against and the matches among them, over all scanned files
+	lines   int
+	matches int
+}
+
+// SampleInfo reports how a sampled result was produced and the total it extrapolates to
+type SampleInfo struct {
+	Rate           float64 `json:"rate"`
+	Every          int     `json:"every,omitempty"`
+	SampledLines   int     `json:"sampled_lines"`
+	SampledMatches int     `json:"sampled_matches"`
+	EstimatedTotal int     `json:"estimated_total"`
+	// Margin is the half width of the 95% confidence interval of EstimatedTotal
+	Margin int  `json:"margin"`
+	Exact  bool `json:"exact"`
+}
+
+// NewSampler takes either a rate in (0, 1] or every >= 1, not both
+func NewSampler(rate float64, every int) (*Sampler, error) {
+	switch {
+	case rate != 0 && every != 0:
+		return nil, errors.New("sample and sample_every are exclusive")
+	case rate < 0 || rate > 1 || math.IsNaN(rate):
+		return nil, errors.New("sample must be a fraction in (0, 1]")
+	case every < 0:
+		return nil, errors.New("sample_every must be >= 1")
+	case rate == 0 && every == 0:
+		return nil, errors.New("sample or sample_every is required")
+	}
+	return &Sampler{Rate: rate, Every: every}, nil
+}
+
+// reset starts counting over for a new scan
+func (s *Sampler) reset() {
+	s.lines = 0
+	s.matches = 0
+}
+
+// reseed seeds the sampler for the next file, by its fingerprint when it is a local file
+func (s *Sampler) reseed(file io.Reader, filePath string) {
+	seed := filePath
+	if readerAt, ok := file.(io.ReaderAt); ok {
+		if fingerprint, err := Fingerprint(readerAt); err == nil {
+			seed = fingerprint
+		}
+	}
+	h := fnv.New32a()
+	h.Write([]byte(seed)) //nolint: errcheck
+	s.seed = h.Sum32()
+}
+
+// sampleLine tells whether the line is tested against the pattern at all
+func (s *Sampler) sampleLine(lineNumber int) bool {
+	if s.Rate > 0 {
+		h := fnv.New32a()
+		h.Write([]byte{ //nolint: errcheck
+			byte(s.seed >> 24), byte(s.seed >> 16), byte(s.seed >> 8), byte(s.seed),
+			byte(lineNumber >> 24), byte(lineNumber >> 16), byte(lineNumber >> 8), byte(lineNumber),
+		})
+		if float64(h.Sum32()) >= s.Rate*math.MaxUint32 {
+			return false
+		}
+	}
+	s.lines++
+	return true
+}
+
+// keepMatch records a matching line and tells whether it is returned
+func (s *Sampler) keepMatch() bool {
+	s.matches++
+	if s.Every > 0 {
+		return (s.matches+int(s.seed%uint32(s.Every)))%s.Every == 0
+	}
+	return true
+}
+
+// Info extrapolates the total match count from the sample
+func (s *Sampler) Info() *SampleInfo {
+	info := &SampleInfo{
+		Rate:           s.Rate,
+		Every:          s.Every,
+		SampledLines:   s.lines,
+		SampledMatches: s.matches,
+	}
+	if s.Every > 0 {
+		// every line was tested, only the returned ones were thinned out
+		info.Rate = 1 / float64(s.Every)
+		info.EstimatedTotal = s.matches
+		info.Exact = true
+		return info
+	}
+	info.EstimatedTotal = int(math.Round(float64(s.matches) / s.Rate))
+	info.Margin = int(math.Ceil(sampleConfidenceZ * math.Sqrt(float64(s.matches)*(1-s.Rate)) / s.Rate))
+	info.Exact = s.Rate == 1
+	return info
+}
@@ -0,0 +1,88 @@
+package pkg
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewSampler(t *testing.T) {
+	type TestCase struct {
+		Rate    float64
+		Every   int
+		WantErr bool
+	}
+
+	testCases := []TestCase{
+		{Rate: 0.01},
+		{Rate: 1},
+		{Every: 1000},
+		{WantErr: true},
+		{Rate: 1.5, WantErr: true},
+		{Rate: -0.1, WantErr: true},
+		{Every: -1, WantErr: true},
+		{Rate: 0.1, Every: 10, WantErr: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(fmt.Sprintf("%v-%d", tc.Rate, tc.Every), func(t *testing.T) {
+			_, err := NewSampler(tc.Rate, tc.Every)
+			if tc.WantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestWatcher_ScanSampled(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "big.log")
+	var content strings.Builder
+	for i := 1; i <= 20000; i++ {
+		level := "INFO"
+		if i%4 == 0 {
+			level = "ERROR"
+		}
+		fmt.Fprintf(&content, "%s line %d\n", level, i)
+	}
+	assert.NoError(t, os.WriteFile(logFile, []byte(content.String()), 0600))
+
+	watcher, err := NewWatcher(logFile, "ERROR", "", false, "", "", "", "", "")
+	assert.NoError(t, err)
+
+	t.Run("rate", func(t *testing.T) {
+		sampler, err := NewSampler(0.1, 0)
+		assert.NoError(t, err)
+		watcher.SetSampler(sampler)
+
+		first, err := watcher.Scan(1, 50, false)
+		assert.NoError(t, err)
+		again, err := watcher.Scan(1, 50, false)
+		assert.NoError(t, err)
+		assert.Equal(t, first.Lines, again.Lines)
+		assert.Equal(t, first.Sample, again.Sample)
+
+		assert.False(t, first.Sample.Exact)
+		assert.Equal(t, first.Sample.SampledMatches, first.Total)
+		assert.InDelta(t, 2000, first.Sample.SampledLines, 300)
+		assert.InDelta(t, 5000, first.Sample.EstimatedTotal, float64(2*first.Sample.Margin))
+	})
+
+	t.Run("every", func(t *testing.T) {
+		sampler, err := NewSampler(0, 100)
+		assert.NoError(t, err)
+		watcher.SetSampler(sampler)
+
+		result, err := watcher.Scan(1, 100, false)
+		assert.NoError(t, err)
+		assert.True(t, result.Sample.Exact)
+		assert.Equal(t, 5000, result.Sample.EstimatedTotal)
+		assert.Equal(t, 50, result.Total)
+		assert.Len(t, result.Lines, 50)
+	})
+}
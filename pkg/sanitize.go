@@ -0,0 +1,90 @@
+package pkg
+
+import (
+	"strings"
+	"unicode/utf8"
+)
+
+// binaryLinePercent is the share of the bytes of a line replaced or escaped past which it is marked binary
+const binaryLinePercent = 30
+
+// SanitizeLine makes the content of line displayable text with SanitizeContent, marking it binary when
+// more than binaryLinePercent of its bytes were replaced or escaped
+func SanitizeLine(line *LineResult) {
+	size := len(line.Content)
+	content, replaced := SanitizeContent(line.Content)
+	line.Content = content
+	if isBinaryShare(replaced, size) {
+		line.Binary = true
+	}
+}
+
+// SanitizeEvent is SanitizeLine for a streamed line
+func SanitizeEvent(event *TailEvent) {
+	size := len(event.Content)
+	content, replaced := SanitizeContent(event.Content)
+	event.Content, event.Binary = content, isBinaryShare(replaced, size)
+}
+
+// SanitizeContent replaces the invalid UTF-8 sequences of s with U+FFFD, a byte each, and escapes its
+// control characters as \xNN, but for tabs and the newlines joining the lines of an entry. It returns
+// the number of bytes replaced or escaped, s as is when there are none.
+func SanitizeContent(s string) (string, int) {
+	i := 0
+	for i < len(s) {
+		if s[i] < utf8.RuneSelf {
+			if isControlByte(s[i]) {
+				break
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			break
+		}
+		i += size
+	}
+	if i == len(s) {
+		return s, 0
+	}
+
+	const hex = "0123456789abcdef"
+	var b strings.Builder
+	b.Grow(len(s) + 16)
+	b.WriteString(s[:i])
+	replaced := 0
+	for i < len(s) {
+		c := s[i]
+		if c < utf8.RuneSelf {
+			if isControlByte(c) {
+				b.WriteString(`\x`)
+				b.WriteByte(hex[c>>4])
+				b.WriteByte(hex[c&0xf])
+				replaced++
+			} else {
+				b.WriteByte(c)
+			}
+			i++
+			continue
+		}
+		r, size := utf8.DecodeRuneInString(s[i:])
+		if r == utf8.RuneError && size == 1 {
+			b.WriteRune(utf8.RuneError)
+			replaced++
+		} else {
+			b.WriteString(s[i : i+size])
+		}
+		i += size
+	}
+	return b.String(), replaced
+}
+
+// isControlByte tells whether b is an ASCII control character escaped for display
+func isControlByte(b byte) bool {
+	return (b < 0x20 && b != '\t' && b != '\n') || b == 0x7f
+}
+
+func isBinaryShare(replaced int, size int) bool {
+	return replaced*100 > size*binaryLinePercent
+}
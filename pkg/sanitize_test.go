@@ -0,0 +1,73 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSanitizeContent(t *testing.T) {
+	tests := []struct {
+		content  string
+		want     string
+		replaced int
+	}{
+		{"INFO plain\ttext", "INFO plain\ttext", 0},
+		{"ERROR Müller 接続", "ERROR Müller 接続", 0},
+		{"bad \xff\xfe bytes", "bad �� bytes", 2},
+		{"cut \xe6\x8e", "cut ��", 2},
+		{"nul\x00bell\x07del\x7f", `nul\x00bell\x07del\x7f`, 3},
+		{"carriage\rreturn", `carriage\x0dreturn`, 1},
+		{"first line\n  at second line", "first line\n  at second line", 0},
+	}
+	for _, tt := range tests {
+		content, replaced := SanitizeContent(tt.content)
+		assert.Equal(t, tt.want, content, tt.content)
+		assert.Equal(t, tt.replaced, replaced, tt.content)
+	}
+}
+
+func TestSanitizeLine(t *testing.T) {
+	line := LineResult{Content: "INSERT INTO t VALUES ('\x00')"}
+	SanitizeLine(&line)
+	assert.Equal(t, `INSERT INTO t VALUES ('\x00')`, line.Content)
+	assert.False(t, line.Binary)
+
+	line = LineResult{Content: "id\x00\x00\x00\x00\xff\xff\xff"}
+	SanitizeLine(&line)
+	assert.True(t, line.Binary)
+	// sanitized again, the marker stays
+	SanitizeLine(&line)
+	assert.True(t, line.Binary)
+
+	// highlights are offsets into the sanitized content
+	line = LineResult{Content: "\x00ERROR"}
+	TruncateLine(&line, 0, regexp.MustCompile("ERROR"))
+	assert.Equal(t, []Highlight{{Start: 4, End: 9}}, line.Highlights)
+}
+
+func TestIsReadableFile_RawBytes(t *testing.T) {
+	dir := t.TempDir()
+	dump := filepath.Join(dir, "dump.sql")
+	assert.NoError(t, os.WriteFile(dump, []byte("INSERT INTO blobs VALUES (1, '\x00\x00\x00\xff\xd8\xff');\nINSERT INTO users VALUES (2, 'Müller');\n"), 0600))
+	binary := filepath.Join(dir, "image.png")
+	assert.NoError(t, os.WriteFile(binary, []byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR\x00\x00\x01\x00\x00\x00\x01\x00\x08\x06\x00\x00\x00"), 0600))
+
+	readable, err := IsReadableFile(dump, false, nil, true)
+	assert.NoError(t, err)
+	assert.True(t, readable)
+	readable, err = IsReadableFile(binary, false, nil, true)
+	assert.NoError(t, err)
+	assert.False(t, readable)
+
+	watcher, err := NewWatcher(dump, "blobs", "", false, "", "", "", "", "")
+	assert.NoError(t, err)
+	result, err := watcher.Scan(1, 10, false)
+	assert.NoError(t, err)
+	assert.Equal(t, `INSERT INTO blobs VALUES (1, '\x00\x00\x00`+"���');", result.Lines[0].Content)
+	assert.False(t, result.Lines[0].Binary)
+	assert.Equal(t, []Highlight{{Start: 12, End: 17}}, result.Lines[0].Highlights)
+}
@@ -0,0 +1,209 @@
+// Package search matches log lines against search patterns and normalizes them into templates.
+// It depends on the standard library only, tools can import it without the server.
+package search
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+// Matcher matches the raw bytes of a line against a search or ignore pattern
+type Matcher interface {
+	Match(line []byte) bool
+}
+
+// NewMatcher compiles pattern. Patterns without metacharacters are matched with
+// bytes.Contains, or an ASCII case folding search after (?i), instead of the regexp engine.
+// Both match exactly the lines the compiled regexp would.
+func NewMatcher(pattern string) (Matcher, error) {
+	if literal, fold, ok := LiteralPattern(pattern); ok {
+		if fold {
+			return foldMatcher(strings.ToLower(literal)), nil
+		}
+		return literalMatcher(literal), nil
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	return regexpMatcher{re: re}, nil
+}
+
+// LiteralPattern returns the literal text of pattern when a plain substring search matches the same lines,
+// and whether it is matched ignoring ASCII case
+func LiteralPattern(pattern string) (string, bool, bool) {
+	literal, fold := strings.CutPrefix(pattern, "(?i)")
+	if regexp.QuoteMeta(literal) != literal || !utf8.ValidString(literal) || strings.ContainsRune(literal, utf8.RuneError) {
+		return "", false, false
+	}
+	if !fold {
+		return literal, false, true
+	}
+	for i := 0; i < len(literal); i++ {
+		// k and s also fold to the Kelvin sign and the long s, leave those to the regexp
+		switch c := literal[i]; {
+		case c >= utf8.RuneSelf, c == 'k', c == 'K', c == 's', c == 'S':
+			return "", false, false
+		}
+	}
+	return literal, true, true
+}
+
+type literalMatcher []byte
+
+func (m literalMatcher) Match(line []byte) bool {
+	return bytes.Contains(line, m)
+}
+
+// foldMatcher is a lower case ASCII literal matched case insensitively
+type foldMatcher []byte
+
+func (m foldMatcher) Match(line []byte) bool {
+	if len(m) == 0 {
+		return true
+	}
+	first, firstUpper := m[0], upperASCII(m[0])
+	for i := 0; i+len(m) <= len(line); i++ {
+		if c := line[i]; c != first && c != firstUpper {
+			continue
+		}
+		if equalFoldASCII(line[i:i+len(m)], m) {
+			return true
+		}
+	}
+	return false
+}
+
+// equalFoldASCII compares b to the lower case lower, ignoring the case of ASCII letters in b
+func equalFoldASCII(b []byte, lower []byte) bool {
+	for i, c := range b {
+		if 'A' <= c && c <= 'Z' {
+			c += 'a' - 'A'
+		}
+		if c != lower[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func upperASCII(c byte) byte {
+	if 'a' <= c && c <= 'z' {
+		return c - ('a' - 'A')
+	}
+	return c
+}
+
+type regexpMatcher struct {
+	re *regexp.Regexp
+}
+
+func (m regexpMatcher) Match(line []byte) bool {
+	return m.re.Match(line)
+}
+
+// HasANSI tells whether the line may contain escape sequences stripansi removes,
+// which all start with ESC or the C1 control sequence introducer
+func HasANSI(line []byte) bool {
+	return bytes.IndexByte(line, 0x1b) >= 0 || bytes.Contains(line, []byte("\u009b"))
+}
+
+// StripANSI appends line to dst without the escape sequences stripansi removes, and returns dst.
+// It matches the sequences stripansi's expression would, in the order a backtracking engine tries
+// them, without the regexp engine and without allocating once dst has grown to a line.
+func StripANSI(dst []byte, line []byte) []byte {
+	for i := 0; i < len(line); {
+		start := 0
+		switch {
+		case line[i] == 0x1b:
+			start = i + 1
+		case line[i] == 0xc2 && i+1 < len(line) && line[i+1] == 0x9b:
+			start = i + 2
+		}
+		if start > 0 {
+			if end := ansiSequenceEnd(line, start); end >= 0 {
+				i = end
+				continue
+			}
+		}
+		dst = append(dst, line[i])
+		i++
+	}
+	return dst
+}
+
+// ansiSequenceEnd returns the end of the sequence whose introducer ends at start, -1 without one.
+// The sequence is [[\]()#;?]* then a string of [a-zA-Z\d;] ended by BEL, or parameters
+// (\d{1,4}(;\d{0,4})*)? and a final byte, the prefix giving back characters as the expression does.
+func ansiSequenceEnd(line []byte, start int) int {
+	prefix := start
+	for prefix < len(line) && strings.IndexByte("[]()#;?", line[prefix]) >= 0 {
+		prefix++
+	}
+	for ; prefix >= start; prefix-- {
+		i := prefix
+		for i < len(line) && (isAlnumASCII(line[i]) || line[i] == ';') {
+			i++
+		}
+		if i < len(line) && line[i] == 0x07 {
+			return i + 1
+		}
+		if end := ansiParamsEnd(line, prefix); end >= 0 {
+			return end
+		}
+		if end := ansiFinalEnd(line, prefix); end >= 0 {
+			return end
+		}
+	}
+	return -1
+}
+
+// ansiParamsEnd matches \d{1,4}(;\d{0,4})* and a final byte at i, longest parameters first
+func ansiParamsEnd(line []byte, i int) int {
+	for n := digitsASCII(line, i, 4); n >= 1; n-- {
+		if end := ansiMoreParamsEnd(line, i+n); end >= 0 {
+			return end
+		}
+	}
+	return -1
+}
+
+// ansiMoreParamsEnd matches (;\d{0,4})* and a final byte at i
+func ansiMoreParamsEnd(line []byte, i int) int {
+	if i < len(line) && line[i] == ';' {
+		for n := digitsASCII(line, i+1, 4); n >= 0; n-- {
+			if end := ansiMoreParamsEnd(line, i+1+n); end >= 0 {
+				return end
+			}
+		}
+	}
+	return ansiFinalEnd(line, i)
+}
+
+// ansiFinalEnd matches the final byte [\dA-PRZcf-ntqry=><~] at i
+func ansiFinalEnd(line []byte, i int) int {
+	if i >= len(line) {
+		return -1
+	}
+	switch c := line[i]; {
+	case '0' <= c && c <= '9', 'A' <= c && c <= 'P', c == 'R', c == 'Z', c == 'c', 'f' <= c && c <= 'n',
+		c == 't', c == 'q', c == 'r', c == 'y', c == '=', c == '>', c == '<', c == '~':
+		return i + 1
+	}
+	return -1
+}
+
+// digitsASCII counts the ASCII digits at i, up to most
+func digitsASCII(line []byte, i int, most int) int {
+	n := 0
+	for n < most && i+n < len(line) && '0' <= line[i+n] && line[i+n] <= '9' {
+		n++
+	}
+	return n
+}
+
+func isAlnumASCII(c byte) bool {
+	return '0' <= c && c <= '9' || 'a' <= c && c <= 'z' || 'A' <= c && c <= 'Z'
+}
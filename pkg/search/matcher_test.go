@@ -0,0 +1,82 @@
+package search
+
+import (
+	"math/rand"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewMatcher(t *testing.T) {
+	patterns := []string{
+		"", "ERROR", "error", "(?i)error", "(?i)ERROR", "(?i)task", "(?i)", "a.b", "ERR|WARN",
+		"(?i)err|warn", "192.168", "ünïcode", "(?i)ünïcode", "[", "(?i)x-request-id",
+	}
+	lines := []string{
+		"", "ERROR An error occurred", "error lowercase", "Error Mixed", "a.b and axb",
+		"WARN something", "task failed", "Kelvin task", "TAſK", "ünïcode ÜNÏCODE",
+		"X-Request-Id: 42", "192.168.0.1", "\xff\xfe invalid ERROR", "eRrOr",
+	}
+
+	for _, pattern := range patterns {
+		re, reErr := regexp.Compile(pattern)
+		matcher, err := NewMatcher(pattern)
+		if reErr != nil {
+			assert.Error(t, err, pattern)
+			continue
+		}
+		assert.NoError(t, err, pattern)
+		for _, line := range lines {
+			assert.Equal(t, re.MatchString(line), matcher.Match([]byte(line)), "%q on %q", pattern, line)
+		}
+	}
+}
+
+func TestLiteralPattern(t *testing.T) {
+	type TestCase struct {
+		Pattern string
+		Literal string
+		Fold    bool
+		OK      bool
+	}
+	testCases := []TestCase{
+		{Pattern: "ERROR", Literal: "ERROR", OK: true},
+		{Pattern: "(?i)error", Literal: "error", Fold: true, OK: true},
+		{Pattern: "(?i)task"},
+		{Pattern: "(?i)ünïcode"},
+		{Pattern: "a.b"},
+		{Pattern: "ERR|WARN"},
+	}
+	for _, tc := range testCases {
+		literal, fold, ok := LiteralPattern(tc.Pattern)
+		assert.Equal(t, tc, TestCase{Pattern: tc.Pattern, Literal: literal, Fold: fold, OK: ok})
+	}
+}
+
+// ansiExpression is the expression of github.com/acarl005/stripansi, which StripANSI replaces
+var ansiExpression = regexp.MustCompile("[\u001B\u009B][[\\]()#;?]*(?:(?:(?:[a-zA-Z\\d]*(?:;[a-zA-Z\\d]*)*)?\u0007)|(?:(?:\\d{1,4}(?:;\\d{0,4})*)?[\\dA-PRZcf-ntqry=><~]))")
+
+func TestStripANSI(t *testing.T) {
+	lines := []string{
+		"", "plain", "\x1b[31mERROR\x1b[0m done", "\x1b[1;31;40mbold\x1b[m", "\u009b31mcsi", "\x1b]0;title\x07text",
+		"\x1b[12", "\x1b[12345", "\x1b[1;23456;7x", "\x1b[;;m", "\x1b", "\x1b\x1b[0m", "\xc2", "ünï\x1b[2Kcode",
+		"\x1b[?25l hidden", "\x1b(B", "\x1b[;]x", "\xff\x1b[1m\xfe",
+	}
+	// sequences of the characters the expression looks at, with ones it does not
+	alphabet := []string{"\x1b", "\u009b", "\xc2", "[", "]", "(", "#", ";", "?", "1", "2", "0", "m", "A", "Q", "x", "\x07", "é", "\xff"}
+	random := rand.New(rand.NewSource(1))
+	for i := 0; i < 20000; i++ {
+		line := ""
+		for n := random.Intn(12); n > 0; n-- {
+			line += alphabet[random.Intn(len(alphabet))]
+		}
+		lines = append(lines, line)
+	}
+
+	var dst []byte
+	for _, line := range lines {
+		dst = StripANSI(dst[:0], []byte(line))
+		assert.Equal(t, ansiExpression.ReplaceAllString(line, ""), string(dst), "%q", line)
+	}
+}
@@ -0,0 +1,101 @@
+package search
+
+import (
+	"fmt"
+	"regexp/syntax"
+)
+
+// alternationCost is the cost of each alternative tried at every position of a line
+const alternationCost = 4
+
+// PatternCost is the estimated cost of matching a regex against one line
+type PatternCost struct {
+	// ProgramSize is the number of instructions of the compiled pattern
+	ProgramSize int `json:"program_size"`
+	// LeadingWildcard is set for patterns starting with .*, which doubles the work of an unanchored search
+	LeadingWildcard bool `json:"leading_wildcard"`
+	// Alternations is the number of alternatives of a pattern not anchored with ^
+	Alternations int  `json:"alternations"`
+	Cost         int  `json:"cost"`
+	Literal      bool `json:"literal"`
+}
+
+// AnalyzePattern estimates the cost of pattern. Literal patterns, matched without the regexp engine,
+// cost nothing. Invalid patterns are left for the matcher to report.
+func AnalyzePattern(pattern string) PatternCost {
+	if _, _, ok := LiteralPattern(pattern); ok {
+		return PatternCost{Literal: true}
+	}
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return PatternCost{}
+	}
+	re = re.Simplify()
+	prog, err := syntax.Compile(re)
+	if err != nil {
+		return PatternCost{}
+	}
+	cost := PatternCost{
+		ProgramSize:     len(prog.Inst),
+		LeadingWildcard: leadingWildcard(re),
+	}
+	if !anchoredAtStart(re) {
+		cost.Alternations = countAlternatives(re)
+	}
+	cost.Cost = cost.ProgramSize + alternationCost*cost.Alternations
+	if cost.LeadingWildcard {
+		cost.Cost *= 2
+	}
+	return cost
+}
+
+// Advice tells what to simplify in a pattern of this cost
+func (c PatternCost) Advice() []string {
+	advice := []string{}
+	if c.LeadingWildcard {
+		advice = append(advice, "remove the leading .*, searches are not anchored")
+	}
+	if c.Alternations > 0 {
+		advice = append(advice, fmt.Sprintf("cut down the %d alternatives, or anchor the pattern with ^", c.Alternations))
+	}
+	advice = append(advice, fmt.Sprintf("shorten the pattern or its repetition counts, it compiles to %d instructions", c.ProgramSize))
+	return advice
+}
+
+func firstElement(re *syntax.Regexp) *syntax.Regexp {
+	for {
+		switch {
+		case re.Op == syntax.OpCapture:
+			re = re.Sub[0]
+		case re.Op == syntax.OpConcat && len(re.Sub) > 0:
+			re = re.Sub[0]
+		default:
+			return re
+		}
+	}
+}
+
+func leadingWildcard(re *syntax.Regexp) bool {
+	first := firstElement(re)
+	if first.Op != syntax.OpStar && first.Op != syntax.OpPlus {
+		return false
+	}
+	op := first.Sub[0].Op
+	return op == syntax.OpAnyChar || op == syntax.OpAnyCharNotNL
+}
+
+func anchoredAtStart(re *syntax.Regexp) bool {
+	op := firstElement(re).Op
+	return op == syntax.OpBeginText || op == syntax.OpBeginLine
+}
+
+func countAlternatives(re *syntax.Regexp) int {
+	count := 0
+	if re.Op == syntax.OpAlternate {
+		count += len(re.Sub)
+	}
+	for _, sub := range re.Sub {
+		count += countAlternatives(sub)
+	}
+	return count
+}
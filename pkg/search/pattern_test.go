@@ -0,0 +1,46 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAnalyzePattern(t *testing.T) {
+	type TestCase struct {
+		Name            string
+		Pattern         string
+		Literal         bool
+		LeadingWildcard bool
+		Alternations    int
+	}
+
+	testCases := []TestCase{
+		{Name: "literal", Pattern: "timeout", Literal: true},
+		{Name: "case folded literal", Pattern: "(?i)timeout", Literal: true},
+		{Name: "alternation", Pattern: "error|warn|fatal", Alternations: 3},
+		{Name: "grouped alternation", Pattern: "(error|warn) in", Alternations: 2},
+		{Name: "anchored alternation", Pattern: "^(error|warn)"},
+		{Name: "leading wildcard", Pattern: ".*foo[0-9]", LeadingWildcard: true},
+		{Name: "nested repetition", Pattern: "(a+)+$"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			cost := AnalyzePattern(tc.Pattern)
+			assert.Equal(t, tc.Literal, cost.Literal)
+			assert.Equal(t, tc.LeadingWildcard, cost.LeadingWildcard)
+			assert.Equal(t, tc.Alternations, cost.Alternations)
+			if tc.Literal {
+				assert.Zero(t, cost.Cost)
+			} else {
+				assert.Greater(t, cost.Cost, 0)
+			}
+		})
+	}
+
+	plain := AnalyzePattern("foo[0-9]")
+	wildcard := AnalyzePattern(".*foo[0-9]")
+	assert.Greater(t, wildcard.Cost, plain.Cost)
+	assert.Equal(t, PatternCost{}, AnalyzePattern("(unclosed"))
+}
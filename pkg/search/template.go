@@ -0,0 +1,44 @@
+package search
+
+import "regexp"
+
+// lineTemplateRules replace the parts of a line that differ between occurrences of the same message
+var lineTemplateRules = []struct {
+	re          *regexp.Regexp
+	placeholder string
+}{
+	{regexp.MustCompile(`\d{4}[-/]\d{2}[-/]\d{2}[T ]\d{2}:\d{2}:\d{2}(?:[.,]\d+)?(?:Z|[+-]\d{2}:?\d{2})?`), "<ts>"},
+	{regexp.MustCompile(`\d{1,2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2}(?: [+-]\d{4})?`), "<ts>"},
+	{regexp.MustCompile(`\b[A-Z][a-z]{2} +\d{1,2} \d{2}:\d{2}:\d{2}\b`), "<ts>"},
+	{regexp.MustCompile(`\b\d{2}:\d{2}:\d{2}(?:[.,]\d+)?\b`), "<ts>"},
+	{regexp.MustCompile(`\b[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}\b`), "<id>"},
+	{regexp.MustCompile(`\b(?:0x[0-9a-fA-F]+|[0-9a-fA-F]{8,})\b`), "<id>"},
+	{regexp.MustCompile(`\d+(?:\.\d+)*`), "<n>"},
+}
+
+// LineTemplate is line with its timestamps, ids and numbers replaced by <ts>, <id> and <n>,
+// so that lines differing only by them compare equal
+func LineTemplate(line string) string {
+	hasDigit := false
+	for i := 0; i < len(line) && !hasDigit; i++ {
+		hasDigit = line[i] >= '0' && line[i] <= '9'
+	}
+	// lines without a digit have no timestamp, and rarely an id
+	if !hasDigit {
+		return line
+	}
+	for _, rule := range lineTemplateRules {
+		line = rule.re.ReplaceAllLiteralString(line, rule.placeholder)
+	}
+	return line
+}
+
+// TemplateHash is the 64 bit FNV-1a hash of template
+func TemplateHash(template string) uint64 {
+	hash := uint64(14695981039346656037)
+	for i := 0; i < len(template); i++ {
+		hash ^= uint64(template[i])
+		hash *= 1099511628211
+	}
+	return hash
+}
@@ -0,0 +1,26 @@
+package search
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineTemplate(t *testing.T) {
+	tests := []struct {
+		line string
+		want string
+	}{
+		{"INFO server started", "INFO server started"},
+		{"2024-06-01T12:00:00.123Z INFO request took 35ms", "<ts> INFO request took <n>ms"},
+		{"Jun  1 12:00:00 host sshd[4242]: accepted", "<ts> host sshd[<n>]: accepted"},
+		{`127.0.0.1 - - [01/Jun/2024:12:00:00 +0000] "GET / HTTP/1.1" 200`, `<n> - - [<ts>] "GET / HTTP/<n>" <n>`},
+		{"job 3f2b9c1e-8a4d-4e6f-9b2a-1c3d5e7f9a0b failed at 0x7ffd", "job <id> failed at <id>"},
+		{"commit deadbeef12 pushed", "commit <id> pushed"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.line, func(t *testing.T) {
+			assert.Equal(t, tt.want, LineTemplate(tt.line))
+		})
+	}
+}
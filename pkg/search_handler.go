@@ -0,0 +1,121 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/mcuadros/go-defaults"
+)
+
+const (
+	// maxSearchQueryLength is the longest query of a search, in bytes
+	maxSearchQueryLength = 4096
+	// searchTimeout bounds a search, a slow pattern on a huge file cannot hold on to the server
+	searchTimeout = 30 * time.Second
+)
+
+type SearchRequest struct {
+	ID       string `json:"id" query:"id"`
+	FilePath string `json:"file_path" query:"file_path" validate:"required" message:"file_path is required"`
+	Host     string `json:"host" query:"host"`
+	Type     string `json:"type" query:"type" validate:"required" message:"type is required"`
+	Query    string `json:"query" query:"query" validate:"required" message:"query is required"`
+	// Regex matches query as a regular expression instead of as text
+	Regex      bool `json:"regex" query:"regex"`
+	IgnoreCase bool `json:"ignore_case" query:"ignore_case"`
+	Page       int  `json:"page" query:"page" default:"1" validate:"required,gte=1" message:"page >=1 is required"`
+	PerPage    int  `json:"per_page" query:"per_page" default:"15" validate:"required" message:"per_page is required"`
+	Reverse    bool `json:"reverse" query:"reverse" default:"false"`
+}
+
+// SearchPattern is the pattern matching query, as text unless regex is set
+func SearchPattern(query string, regex bool, ignoreCase bool) string {
+	pattern := query
+	if !regex {
+		pattern = regexp.QuoteMeta(query)
+	}
+	if ignoreCase {
+		pattern = "(?i)" + pattern
+	}
+	return pattern
+}
+
+// GetSearch scans a whole file on the server for the lines matching query, returning a page of them
+// with the byte offsets of every match within the line as highlights. The scan is abandoned after
+// searchTimeout.
+func (h *APIHandler) GetSearch(c echo.Context) error {
+	req := new(SearchRequest)
+	if err := BindRequest(c, req); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err)
+	}
+	if err := resolveFileID(req.ID, &req.FilePath, &req.Host, &req.Type); err != nil {
+		return err
+	}
+	defaults.SetDefaults(req)
+	msgs, err := ValidateRequest(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, msgs)
+	}
+	if len(req.Query) > maxSearchQueryLength {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("query must be at most %d bytes", maxSearchQueryLength))
+	}
+	pattern := SearchPattern(req.Query, req.Regex, req.IgnoreCase)
+	if _, err := regexp.Compile(pattern); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("invalid regex: %s", err))
+	}
+	var sampler *Sampler
+	patternCost, patternErr := GlobalPatternLimits.Check(pattern)
+	if patternErr != nil {
+		if GlobalPatternLimits.Mode == PatternLimitReject {
+			return echo.NewHTTPError(http.StatusBadRequest, patternErr.Error())
+		}
+		sampler = GlobalPatternLimits.Sampler(nil)
+	}
+	if err := AuthorizeFilePath(req.FilePath, req.Type, req.Host); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err)
+	}
+	if req.Type == TypeRemoteGol || (req.Type == TypeDocker && !strings.HasPrefix(req.FilePath, TmpContainerPath)) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "search is only supported for local and SSH files")
+	}
+
+	release, err := acquireRead(c, req.Type, req.Host, req.FilePath)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	watcher, err := h.newWatcher(req.Type, req.Host, req.FilePath, pattern, "")
+	if err != nil {
+		return err
+	}
+	if sampler != nil {
+		watcher.SetSampler(sampler)
+	}
+	ctx, cancel := context.WithTimeout(c.Request().Context(), searchTimeout)
+	defer cancel()
+	result, err := watcher.ScanContext(ctx, req.Page, req.PerPage, req.Reverse)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return echo.NewHTTPError(http.StatusGatewayTimeout, fmt.Sprintf("search did not finish within %s, narrow down the query", searchTimeout))
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	result.Type = req.Type
+	result.Host = req.Host
+	result.SetSourceType(req.Type, req.Host)
+	if patternErr != nil {
+		result.PatternLimited = true
+		result.PatternCost = &patternCost
+	}
+
+	return writeAPIResponse(c, APIResponse{
+		Result:    *result,
+		FilePaths: GlobalFileRegistry.Snapshot(),
+	})
+}
@@ -0,0 +1,87 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"testing/fstest"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSearchPattern(t *testing.T) {
+	assert.Equal(t, `a\.b\(`, SearchPattern("a.b(", false, false))
+	assert.Equal(t, `(?i)a\.b`, SearchPattern("a.b", false, true))
+	assert.Equal(t, `ERR|WARN`, SearchPattern("ERR|WARN", true, false))
+	assert.Equal(t, `(?i)err|warn`, SearchPattern("err|warn", true, true))
+}
+
+func TestAPIHandler_GetSearch(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte("INFO a.b ok\nERROR a.b failed, a.b again\nerror axb\nWARN slow\n"), 0600))
+	gzFile := filepath.Join(dir, "app.log.1.gz")
+	assert.NoError(t, os.WriteFile(gzFile, gzipped(t, "INFO old\nERROR old failure\n"), 0600))
+	runner := &ScriptedRemoteRunner{Outputs: map[string]string{
+		"web1 cat /var/log/web.log": "GET /\nGET /error 500\n",
+	}}
+	useFakes(t, fstest.MapFS{}, runner, nil)
+	defer func(sshConfigs []SSHPathConfig) { GlobalPathSSHConfig = sshConfigs }(GlobalPathSSHConfig)
+	GlobalPathSSHConfig = []SSHPathConfig{{Host: "web1", Port: "22", FilePath: "/var/log/*.log"}}
+	GlobalFileRegistry.Replace([]FileInfo{
+		{FilePath: logFile, Type: TypeFile},
+		{FilePath: gzFile, Type: TypeFile},
+		{FilePath: "/var/log/web.log", Type: TypeSSH, Host: "web1"},
+	})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+
+	search := func(query string) (*httptest.ResponseRecorder, ScanResult) {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/search?"+query, nil))
+		res := APIResponse{}
+		if rec.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		}
+		return rec, res.Result
+	}
+
+	// text, the offsets of every match
+	rec, result := search("type=file&file_path=" + logFile + "&query=a.b")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, 2, result.Total)
+	assert.Equal(t, 2, result.Lines[1].LineNumber)
+	assert.Equal(t, []Highlight{{Start: 6, End: 9}, {Start: 18, End: 21}}, result.Lines[1].Highlights)
+
+	// regex, ignoring case
+	_, result = search("type=file&file_path=" + logFile + "&query=a.b&regex=true")
+	assert.Equal(t, 3, result.Total)
+	_, result = search("type=file&file_path=" + logFile + "&query=error&ignore_case=true")
+	assert.Equal(t, 2, result.Total)
+	assert.Equal(t, []Highlight{{Start: 0, End: 5}}, result.Lines[0].Highlights)
+	_, result = search("type=file&file_path=" + logFile + "&query=error|warn&regex=true&ignore_case=true")
+	assert.Equal(t, 3, result.Total)
+
+	// gzip and SSH files
+	_, result = search("type=file&file_path=" + gzFile + "&query=ERROR")
+	assert.Equal(t, 1, result.Total)
+	assert.Equal(t, "ERROR old failure", result.Lines[0].Content)
+	_, result = search("type=ssh&host=web1&file_path=/var/log/web.log&query=500")
+	assert.Equal(t, 1, result.Total)
+	assert.Equal(t, 2, result.Lines[0].LineNumber)
+
+	for query, status := range map[string]int{
+		"type=file&file_path=" + logFile:                                                           http.StatusUnprocessableEntity,
+		"type=file&file_path=" + logFile + "&query=(&regex=true":                                   http.StatusUnprocessableEntity,
+		"type=file&file_path=" + logFile + "&query=" + strings.Repeat("a", maxSearchQueryLength+1): http.StatusUnprocessableEntity,
+		"type=file&file_path=/var/log/missing.log&query=a":                                         http.StatusForbidden,
+		fmt.Sprintf("type=file&file_path=%s&query=a&per_page=%d", logFile, GlobalMaxPerPage+1):     http.StatusOK,
+	} {
+		rec, _ := search(query)
+		assert.Equal(t, status, rec.Code, query)
+	}
+}
@@ -0,0 +1,189 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SelfReportSchemaVersion is bumped when the self report changes, fields are only ever added
+const SelfReportSchemaVersion = 1
+
+const (
+	// SelfReportSecretHeader carries the shared secret of the inventory receiving the reports
+	SelfReportSecretHeader = "X-Gol-Report-Secret"
+	// DefaultSelfReportEvery is how often the self report is sent
+	DefaultSelfReportEvery = time.Hour
+
+	selfReportTimeout = 10 * time.Second
+)
+
+// SelfReport describes an instance for a fleet inventory. It holds versions and counts only,
+// never paths, hosts or log content.
+type SelfReport struct {
+	SchemaVersion int       `json:"schema_version"`
+	Version       string    `json:"version"`
+	APIVersion    string    `json:"api_version"`
+	StartedAt     time.Time `json:"started_at"`
+	UptimeSeconds int64     `json:"uptime_seconds"`
+	// Sources are the configured sources by type, Files the files listed from them by type
+	Sources map[string]int   `json:"sources"`
+	Files   map[string]int   `json:"files"`
+	Health  SelfReportHealth `json:"health"`
+}
+
+// SelfReportHealth sums up how the sources and their readers are doing
+type SelfReportHealth struct {
+	// FailingSources failed their last listing, PendingSources are SSH paths still resolving
+	FailingSources int `json:"failing_sources"`
+	PendingSources int `json:"pending_sources"`
+	CorruptFiles   int `json:"corrupt_files"`
+	// Reads and Tails are in flight, RunningJobs are long operations such as first scans
+	Reads        int  `json:"reads"`
+	Tails        int  `json:"tails"`
+	RunningJobs  int  `json:"running_jobs"`
+	DiskPressure bool `json:"disk_pressure"`
+}
+
+// NewSelfReport reports on the instance of version, started at startedAt
+func NewSelfReport(version string, startedAt time.Time) SelfReport {
+	report := SelfReport{
+		SchemaVersion: SelfReportSchemaVersion,
+		Version:       version,
+		APIVersion:    APIVersion,
+		StartedAt:     startedAt,
+		UptimeSeconds: int64(GlobalClock.Now().Sub(startedAt).Seconds()),
+		Sources:       map[string]int{},
+		Files:         map[string]int{},
+	}
+	for _, status := range GlobalSourceStatuses.List() {
+		report.Sources[status.Type]++
+		if status.Error != "" {
+			report.Health.FailingSources++
+		}
+		if status.Pending {
+			report.Health.PendingSources++
+		}
+	}
+	if len(GlobalRemoteClients) > 0 {
+		report.Sources[TypeRemoteGol] = len(GlobalRemoteClients)
+	}
+	for _, fileInfo := range GlobalFileRegistry.Snapshot() {
+		report.Files[fileInfo.Type]++
+		for _, segment := range append([]FileInfo{fileInfo}, fileInfo.Segments...) {
+			if segment.Corrupt != "" {
+				report.Health.CorruptFiles++
+			}
+		}
+	}
+	inFlight := GlobalReadLimiter.InFlight()
+	for _, reads := range inFlight.Reads {
+		report.Health.Reads += reads
+	}
+	report.Health.Tails = inFlight.Tails
+	for _, job := range GlobalJobs.List() {
+		if job.State == JobStateRunning {
+			report.Health.RunningJobs++
+		}
+	}
+	for _, usage := range DiskUsages() {
+		report.Health.DiskPressure = report.Health.DiskPressure || usage.Pressure
+	}
+	return report
+}
+
+// SelfReporter sends the self report to a fleet inventory, when -report-to is set
+type SelfReporter struct {
+	url       string
+	secret    string
+	version   string
+	startedAt time.Time
+	client    *http.Client
+}
+
+func NewSelfReporter(reportURL string, secret string, version string, client *http.Client) (*SelfReporter, error) {
+	u, err := url.Parse(reportURL)
+	if err != nil {
+		return nil, err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+		return nil, errors.New("report url must be http(s)://host[:port]/path")
+	}
+	if client == nil {
+		client = &http.Client{Timeout: selfReportTimeout}
+	}
+	return &SelfReporter{
+		url:       u.String(),
+		secret:    secret,
+		version:   version,
+		startedAt: GlobalClock.Now(),
+		client:    client,
+	}, nil
+}
+
+func (r *SelfReporter) Report() SelfReport {
+	return NewSelfReport(r.version, r.startedAt)
+}
+
+// Send POSTs the self report, a non 2xx answer is an error
+func (r *SelfReporter) Send(ctx context.Context) error {
+	body, err := json.Marshal(r.Report())
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, r.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	if r.secret != "" {
+		req.Header.Set(SelfReportSecretHeader, r.secret)
+	}
+	res, err := r.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	io.Copy(io.Discard, res.Body) //nolint: errcheck
+	if res.StatusCode < 200 || res.StatusCode > 299 {
+		return fmt.Errorf("report to %s: %s", r.url, res.Status)
+	}
+	return nil
+}
+
+// Run sends the self report now and every interval. Failures are only logged, the next report
+// is sent on schedule.
+func (r *SelfReporter) Run(interval time.Duration) {
+	ticks, stop := GlobalClock.Tick(interval)
+	defer stop()
+
+	r.send()
+	for range ticks {
+		r.send()
+	}
+}
+
+func (r *SelfReporter) send() {
+	ctx, cancel := context.WithTimeout(context.Background(), selfReportTimeout)
+	defer cancel()
+	if err := r.Send(ctx); err != nil {
+		slog.Warn("sending self report", "report-to", err)
+	}
+}
+
+// GetSelfReport returns the self report sent to the fleet inventory, 404 unless -report-to is set
+func (h *APIHandler) GetSelfReport(c echo.Context) error {
+	if GlobalSelfReporter == nil {
+		return echo.NewHTTPError(http.StatusNotFound, "self report is disabled")
+	}
+	return c.JSON(http.StatusOK, GlobalSelfReporter.Report())
+}
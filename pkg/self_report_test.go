@@ -0,0 +1,116 @@
+package pkg
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func useSelfReportSources(t *testing.T) {
+	filePaths, statuses, reporter := GlobalFileRegistry.Snapshot(), GlobalSourceStatuses, GlobalSelfReporter
+	t.Cleanup(func() {
+		GlobalFileRegistry.Replace(filePaths)
+		GlobalSourceStatuses, GlobalSelfReporter = statuses, reporter
+	})
+	GlobalSourceStatuses = NewSourceStatuses()
+	GlobalSourceStatuses.Set([]SourceStatus{
+		{Source: "/var/log/*.log", Type: TypeFile, Files: 2},
+		{Source: "/srv/secret/app.log", Type: TypeSSH, Host: "db1", Error: "connection refused"},
+		{Source: "/srv/app/*.log", Type: TypeSSH, Host: "web1", Pending: true},
+	})
+	GlobalFileRegistry.Replace([]FileInfo{
+		{FilePath: "/var/log/app.log", Type: TypeFile, Segments: []FileInfo{{FilePath: "/var/log/app.log.1.gz", Type: TypeFile, Corrupt: "unexpected EOF"}}},
+		{FilePath: "/var/log/db.log", Type: TypeFile},
+		{FilePath: "/srv/app/web.log", Type: TypeSSH, Host: "web1"},
+	})
+}
+
+func TestNewSelfReport(t *testing.T) {
+	startedAt := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+	useFakes(t, fstest.MapFS{}, nil, NewManualClock(startedAt.Add(90*time.Second)))
+	useSelfReportSources(t)
+
+	report := NewSelfReport("v1.2.3", startedAt)
+	assert.Equal(t, SelfReportSchemaVersion, report.SchemaVersion)
+	assert.Equal(t, "v1.2.3", report.Version)
+	assert.Equal(t, int64(90), report.UptimeSeconds)
+	assert.Equal(t, map[string]int{TypeFile: 1, TypeSSH: 2}, report.Sources)
+	assert.Equal(t, map[string]int{TypeFile: 2, TypeSSH: 1}, report.Files)
+	assert.Equal(t, 1, report.Health.FailingSources)
+	assert.Equal(t, 1, report.Health.PendingSources)
+	assert.Equal(t, 1, report.Health.CorruptFiles)
+
+	// paths, hosts and errors never leave the instance
+	body, err := json.Marshal(report)
+	assert.NoError(t, err)
+	for _, secret := range []string{"/var/log", "/srv", "web1", "db1", "refused"} {
+		assert.NotContains(t, string(body), secret)
+	}
+}
+
+func TestSelfReporter_Send(t *testing.T) {
+	useSelfReportSources(t)
+
+	received := make(chan SelfReport, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.Header.Get(SelfReportSecretHeader) != "s3cret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var report SelfReport
+		body, _ := io.ReadAll(r.Body)
+		if json.Unmarshal(body, &report) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- report
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	reporter, err := NewSelfReporter(server.URL+"/gol", "s3cret", "v1.2.3", nil)
+	assert.NoError(t, err)
+	assert.NoError(t, reporter.Send(context.Background()))
+	report := <-received
+	assert.Equal(t, "v1.2.3", report.Version)
+	assert.Equal(t, 2, report.Sources[TypeSSH])
+
+	// a wrong secret is an error of the report only
+	reporter, err = NewSelfReporter(server.URL+"/gol", "wrong", "v1.2.3", nil)
+	assert.NoError(t, err)
+	assert.ErrorContains(t, reporter.Send(context.Background()), "403")
+
+	_, err = NewSelfReporter("inventory.internal/gol", "", "v1.2.3", nil)
+	assert.Error(t, err)
+}
+
+func TestAPIHandler_GetSelfReport(t *testing.T) {
+	useSelfReportSources(t)
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+
+	// off unless -report-to is set
+	GlobalSelfReporter = nil
+	req := httptest.NewRequest(http.MethodGet, "/api/self-report", nil)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+
+	reporter, err := NewSelfReporter("https://inventory.internal/gol", "", "v1.2.3", nil)
+	assert.NoError(t, err)
+	GlobalSelfReporter = reporter
+	req = httptest.NewRequest(http.MethodGet, "/api/self-report", nil)
+	rec = httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var report SelfReport
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &report))
+	assert.Equal(t, "v1.2.3", report.Version)
+	assert.Equal(t, map[string]int{TypeFile: 2, TypeSSH: 1}, report.Files)
+}
@@ -0,0 +1,163 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"log/slog"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const MinEvery = time.Second
+
+// Settings are the user supplied values validated the same way whether they come
+// from flags, the config file or GolOptions
+type Settings struct {
+	Every   time.Duration
+	Limit   int
+	Port    int64
+	BaseURL string
+	// PatternLimits and ReadLimits are checked only when set
+	PatternLimits *PatternLimits
+	ReadLimits    *ReadLimits
+}
+
+// Validate rejects nonsensical values with a message per problem and normalizes BaseURL
+func (s *Settings) Validate() error {
+	var errs []error
+	if err := ValidateEvery(s.Every); err != nil {
+		errs = append(errs, err)
+	}
+	if err := ValidateLimit(s.Limit); err != nil {
+		errs = append(errs, err)
+	}
+	if err := ValidatePort(s.Port); err != nil {
+		errs = append(errs, err)
+	}
+	baseURL, err := NormalizeBaseURL(s.BaseURL)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	s.BaseURL = baseURL
+	if s.PatternLimits != nil {
+		if err := s.PatternLimits.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if s.ReadLimits != nil {
+		if err := s.ReadLimits.Validate(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func ValidateEvery(every time.Duration) error {
+	if every < MinEvery {
+		return fmt.Errorf("every must be at least %s, got %s", MinEvery, every)
+	}
+	return nil
+}
+
+func ValidateLimit(limit int) error {
+	if limit < 1 {
+		return fmt.Errorf("limit must be at least 1, got %d", limit)
+	}
+	return nil
+}
+
+// ValidatePort accepts 0, which listens on a free port
+func ValidatePort(port int64) error {
+	if port < 0 || port > 65535 {
+		return fmt.Errorf("port must be between 0 and 65535, got %d", port)
+	}
+	return nil
+}
+
+// NormalizeBaseURL requires a leading slash and adds the trailing one routes are joined with
+func NormalizeBaseURL(baseURL string) (string, error) {
+	if !strings.HasPrefix(baseURL, "/") {
+		return baseURL, fmt.Errorf("base-url must begin with '/', got %q", baseURL)
+	}
+	if !strings.HasSuffix(baseURL, "/") {
+		baseURL += "/"
+	}
+	return baseURL, nil
+}
+
+// EveryFlag is the -every flag value, a duration such as 30s.
+// A bare integer is still accepted as seconds but deprecated.
+type EveryFlag time.Duration
+
+func (e *EveryFlag) String() string {
+	return time.Duration(*e).String()
+}
+
+func (e *EveryFlag) Set(value string) error {
+	every, err := ParseEvery(value)
+	if err != nil {
+		return err
+	}
+	*e = EveryFlag(every)
+	return nil
+}
+
+// ParseEvery parses a duration, or a bare integer number of seconds (deprecated)
+func ParseEvery(value string) (time.Duration, error) {
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		slog.Warn("a bare number of seconds for every is deprecated, use a duration like 10s", "every", value)
+		return time.Duration(seconds) * time.Second, nil
+	}
+	every, err := time.ParseDuration(value)
+	if err != nil {
+		return 0, fmt.Errorf("every must be a duration like 30s: %w", err)
+	}
+	return every, nil
+}
+
+// ByteSizeFlag is a flag value of a size in bytes, e.g. 1GiB, 500MB or 1048576
+type ByteSizeFlag int64
+
+func (b *ByteSizeFlag) String() string {
+	return FormatByteSize(int64(*b))
+}
+
+func (b *ByteSizeFlag) Set(value string) error {
+	size, err := ParseByteSize(value)
+	if err != nil {
+		return err
+	}
+	*b = ByteSizeFlag(size)
+	return nil
+}
+
+var byteSizeUnits = map[string]int64{
+	"":    1,
+	"b":   1,
+	"kb":  1000,
+	"kib": 1 << 10,
+	"mb":  1000 * 1000,
+	"mib": 1 << 20,
+	"gb":  1000 * 1000 * 1000,
+	"gib": 1 << 30,
+	"tb":  1000 * 1000 * 1000 * 1000,
+	"tib": 1 << 40,
+}
+
+// ParseByteSize parses a number of bytes with an optional decimal (KB) or binary (KiB) unit
+func ParseByteSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	number := strings.TrimRightFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+	unit, ok := byteSizeUnits[strings.ToLower(strings.TrimSpace(value[len(number):]))]
+	if !ok {
+		return 0, fmt.Errorf("size must be a number of bytes with an optional unit like 1GiB, got %q", value)
+	}
+	size, err := strconv.ParseFloat(number, 64)
+	if err != nil || size < 0 {
+		return 0, fmt.Errorf("size must be a number of bytes with an optional unit like 1GiB, got %q", value)
+	}
+	return int64(size * float64(unit)), nil
+}
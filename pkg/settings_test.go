@@ -0,0 +1,103 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSettings_Validate(t *testing.T) {
+	type TestCase struct {
+		Name        string
+		Settings    Settings
+		WantErrs    []string
+		WantBaseURL string
+	}
+
+	valid := Settings{Every: 10 * time.Second, Limit: 1000, Port: 3003, BaseURL: "/"}
+
+	testCases := []TestCase{
+		{Name: "valid", Settings: valid, WantBaseURL: "/"},
+		{Name: "port 0 picks a free port", Settings: Settings{Every: time.Minute, Limit: 1, Port: 0, BaseURL: "/"}, WantBaseURL: "/"},
+		{Name: "trailing slash is added", Settings: Settings{Every: time.Minute, Limit: 1, Port: 65535, BaseURL: "/logs"}, WantBaseURL: "/logs/"},
+		{
+			Name:        "every of zero spins",
+			Settings:    Settings{Every: 0, Limit: 1000, Port: 3003, BaseURL: "/"},
+			WantErrs:    []string{"every must be at least 1s, got 0s"},
+			WantBaseURL: "/",
+		},
+		{
+			Name:        "everything wrong",
+			Settings:    Settings{Every: 500 * time.Millisecond, Limit: -1, Port: 70000, BaseURL: "logs/"},
+			WantErrs:    []string{"every must be at least 1s", "limit must be at least 1, got -1", "port must be between 0 and 65535, got 70000", "base-url must begin with '/'"},
+			WantBaseURL: "logs/",
+		},
+		{
+			Name:        "read limits below one slot",
+			Settings:    Settings{Every: time.Minute, Limit: 1, Port: 3003, BaseURL: "/", ReadLimits: &ReadLimits{MaxReads: -1, MaxReadsPerHost: 0, MaxTails: 1}},
+			WantErrs:    []string{"max-reads must be at least 1, got -1", "max-reads-per-host must be at least 1, got 0"},
+			WantBaseURL: "/",
+		},
+		{
+			Name:        "unknown pattern limit",
+			Settings:    Settings{Every: time.Minute, Limit: 1, Port: 3003, BaseURL: "/", PatternLimits: &PatternLimits{MaxCost: 10, Mode: "drop"}},
+			WantErrs:    []string{`pattern-limit must be reject or sample, got "drop"`},
+			WantBaseURL: "/",
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			settings := tc.Settings
+			err := settings.Validate()
+			if len(tc.WantErrs) == 0 {
+				assert.NoError(t, err)
+			}
+			for _, want := range tc.WantErrs {
+				assert.ErrorContains(t, err, want)
+			}
+			assert.Equal(t, tc.WantBaseURL, settings.BaseURL)
+		})
+	}
+}
+
+func TestParseEvery(t *testing.T) {
+	every, err := ParseEvery("30s")
+	assert.NoError(t, err)
+	assert.Equal(t, 30*time.Second, every)
+
+	every, err = ParseEvery("10")
+	assert.NoError(t, err)
+	assert.Equal(t, 10*time.Second, every)
+
+	_, err = ParseEvery("soon")
+	assert.Error(t, err)
+
+	var flag EveryFlag
+	assert.NoError(t, flag.Set("1m"))
+	assert.Equal(t, "1m0s", flag.String())
+}
+
+func TestParseByteSize(t *testing.T) {
+	tests := map[string]int64{
+		"1048576": 1 << 20,
+		"1GiB":    1 << 30,
+		"1.5 KiB": 1536,
+		"500MB":   500 * 1000 * 1000,
+		"0":       0,
+	}
+	for value, want := range tests {
+		size, err := ParseByteSize(value)
+		assert.NoError(t, err, value)
+		assert.Equal(t, want, size, value)
+	}
+	for _, value := range []string{"", "1XB", "GiB", "-1GiB"} {
+		_, err := ParseByteSize(value)
+		assert.Error(t, err, value)
+	}
+
+	var flag ByteSizeFlag
+	assert.NoError(t, flag.Set("2GiB"))
+	assert.Equal(t, "2GiB", flag.String())
+}
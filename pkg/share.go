@@ -0,0 +1,225 @@
+package pkg
+
+import (
+	"errors"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// DefaultShareTTL is how long share links are kept
+	DefaultShareTTL = 30 * 24 * time.Hour
+
+	storeBucketShares = "shares"
+	// SharePruneEvery is how often the expired share links are removed
+	SharePruneEvery = time.Hour
+)
+
+// ShareError is the structured error of a share link that no longer opens
+type ShareError struct {
+	Code     string `json:"code"`
+	Message  string `json:"message"`
+	FilePath string `json:"file_path,omitempty"`
+}
+
+func (e *ShareError) Error() string {
+	return e.Message
+}
+
+// status is the HTTP status answering the error
+func (e *ShareError) status() int {
+	switch e.Code {
+	case ErrorCodeShareNotFound:
+		return http.StatusNotFound
+	case ErrorCodeShareFileChanged:
+		return http.StatusConflict
+	default:
+		return http.StatusGone
+	}
+}
+
+// Share is a position in a file with the filters of the view, saved under a short ID that opens it again
+type Share struct {
+	ID         string `json:"id"`
+	FilePath   string `json:"file_path"`
+	Host       string `json:"host"`
+	Type       string `json:"type"`
+	LineNumber int    `json:"line_number"`
+	// Filters are the query parameters of the view, like query and ignore, kept as they are for the UI
+	Filters map[string]string `json:"filters,omitempty"`
+	// FileSize and ModTime are of the file when shared, to tell that it was rewritten since
+	FileSize  int64      `json:"file_size"`
+	ModTime   *time.Time `json:"mod_time,omitempty"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// Shares keeps the share links in the store, so that they survive restarts, and expires them after
+// the ttl. A link of a file that is no longer watched or was rewritten is not resolved.
+type Shares struct {
+	shares *Bucket[Share]
+	ttl    time.Duration
+	stop   chan struct{}
+	once   sync.Once
+}
+
+func NewShares(store Store, ttl time.Duration) *Shares {
+	return &Shares{shares: NewBucket[Share](store, storeBucketShares), ttl: ttl, stop: make(chan struct{})}
+}
+
+// Create saves the line lineNumber of the watched file fileInfo with the filters of the view
+func (s *Shares) Create(fileInfo FileInfo, lineNumber int, filters map[string]string) (Share, error) {
+	share := Share{
+		ID:         newJobID(),
+		FilePath:   fileInfo.FilePath,
+		Host:       fileInfo.Host,
+		Type:       fileInfo.Type,
+		LineNumber: lineNumber,
+		Filters:    filters,
+		FileSize:   fileInfo.FileSize,
+		ModTime:    fileInfo.ModTime,
+		CreatedAt:  GlobalClock.Now(),
+	}
+	return share, s.shares.Put(share.ID, share)
+}
+
+// Resolve returns the share of id with the file it points to, as currently watched. A file grown
+// since is the same file, a smaller one or one of the same size modified since was rewritten. The
+// links that do not open are a *ShareError.
+func (s *Shares) Resolve(id string) (Share, FileInfo, error) {
+	share, ok, err := s.shares.Get(id)
+	if err != nil {
+		return share, FileInfo{}, err
+	}
+	if !ok {
+		return share, FileInfo{}, &ShareError{Code: ErrorCodeShareNotFound, Message: "share link not found"}
+	}
+	if s.expired(share) {
+		return share, FileInfo{}, &ShareError{Code: ErrorCodeShareExpired, Message: "share link expired", FilePath: share.FilePath}
+	}
+	ref := FileRef{FilePath: share.FilePath, Host: share.Host, Type: share.Type}
+	for _, fileInfo := range GlobalFileRegistry.Snapshot() {
+		if fileRefOf(fileInfo) != ref {
+			continue
+		}
+		rewritten := fileInfo.FileSize < share.FileSize ||
+			(fileInfo.FileSize == share.FileSize && fileInfo.ModTime != nil && share.ModTime != nil && !fileInfo.ModTime.Equal(*share.ModTime))
+		if rewritten {
+			return share, fileInfo, &ShareError{Code: ErrorCodeShareFileChanged, Message: "the shared file was rewritten since", FilePath: share.FilePath}
+		}
+		return share, fileInfo, nil
+	}
+	return share, FileInfo{}, &ShareError{Code: ErrorCodeShareFileGone, Message: "the shared file is no longer watched", FilePath: share.FilePath}
+}
+
+func (s *Shares) expired(share Share) bool {
+	return s.ttl > 0 && GlobalClock.Now().Sub(share.CreatedAt) >= s.ttl
+}
+
+// Prune removes the expired shares and returns their IDs
+func (s *Shares) Prune() ([]string, error) {
+	expired := []string{}
+	for id, share := range s.shares.All() {
+		if s.expired(share) {
+			expired = append(expired, id)
+		}
+	}
+	if len(expired) == 0 {
+		return expired, nil
+	}
+	err := s.shares.store.Update(func(buckets StoreBuckets) error {
+		for _, id := range expired {
+			delete(buckets[storeBucketShares], id)
+		}
+		return nil
+	})
+	return expired, err
+}
+
+// Run prunes the expired shares every interval until Close
+func (s *Shares) Run(interval time.Duration) {
+	ticks, stop := GlobalClock.Tick(interval)
+	defer stop()
+	for {
+		if expired, err := s.Prune(); err != nil {
+			slog.Warn("pruning share links", "error", err)
+		} else if len(expired) > 0 {
+			slog.Info("share links expired", "count", len(expired))
+		}
+		select {
+		case _, ok := <-ticks:
+			if !ok {
+				return
+			}
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+func (s *Shares) Close() {
+	s.once.Do(func() { close(s.stop) })
+}
+
+type ShareRequest struct {
+	ID         string `json:"id" query:"id"`
+	FilePath   string `json:"file_path" query:"file_path" validate:"required" message:"file_path is required"`
+	Host       string `json:"host" query:"host"`
+	Type       string `json:"type" query:"type" validate:"required" message:"type is required"`
+	LineNumber int    `json:"line_number" query:"line_number" validate:"gte=1" message:"line_number >=1 is required"`
+	// Filters are the query parameters of the view to open the file with
+	Filters map[string]string `json:"filters"`
+}
+
+// ShareResult is a share with the ID of its file in the file list, to open it with
+type ShareResult struct {
+	Share
+	FileID string `json:"file_id"`
+}
+
+// PostShare saves a share link of a line of a watched file
+func (h *APIHandler) PostShare(c echo.Context) error {
+	req := new(ShareRequest)
+	if err := BindRequest(c, req); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err)
+	}
+	if err := resolveFileID(req.ID, &req.FilePath, &req.Host, &req.Type); err != nil {
+		return err
+	}
+	msgs, err := ValidateRequest(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, msgs)
+	}
+	if err := AuthorizeFilePath(req.FilePath, req.Type, req.Host); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err)
+	}
+	ref := FileRef{FilePath: req.FilePath, Host: req.Host, Type: req.Type}
+	fileInfo := FileInfo{FilePath: req.FilePath, Host: req.Host, Type: req.Type}
+	for _, watched := range GlobalFileRegistry.Snapshot() {
+		if fileRefOf(watched) == ref {
+			fileInfo = watched
+			break
+		}
+	}
+	share, err := GlobalShares.Create(fileInfo, req.LineNumber, req.Filters)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, ShareResult{Share: share, FileID: fileInfo.ID})
+}
+
+// GetShare expands a share link back into the file, line and filters it was made of
+func (h *APIHandler) GetShare(c echo.Context) error {
+	share, fileInfo, err := GlobalShares.Resolve(c.Param("id"))
+	var shareErr *ShareError
+	if errors.As(err, &shareErr) {
+		return echo.NewHTTPError(shareErr.status(), shareErr)
+	}
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	return c.JSON(http.StatusOK, ShareResult{Share: share, FileID: fileInfo.ID})
+}
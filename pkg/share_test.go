@@ -0,0 +1,127 @@
+package pkg
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShares(t *testing.T) {
+	defer func(clock Clock) { GlobalClock = clock }(GlobalClock)
+	clock := NewManualClock(time.Date(2024, 5, 1, 10, 0, 0, 0, time.UTC))
+	GlobalClock = clock
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	modTime := clock.Now()
+	fileInfo := FileInfo{FilePath: "app.log", Type: TypeFile, FileSize: 100, ModTime: &modTime}
+	GlobalFileRegistry.Replace(SetFileIDs([]FileInfo{fileInfo}))
+
+	path := filepath.Join(t.TempDir(), "store.json")
+	store, err := OpenFileStore(path)
+	assert.NoError(t, err)
+	shares := NewShares(store, time.Hour)
+	share, err := shares.Create(fileInfo, 42, map[string]string{"query": "ERROR"})
+	assert.NoError(t, err)
+
+	// persisted across restarts
+	reopened, err := OpenFileStore(path)
+	assert.NoError(t, err)
+	resolved, watched, err := NewShares(reopened, time.Hour).Resolve(share.ID)
+	assert.NoError(t, err)
+	assert.Equal(t, 42, resolved.LineNumber)
+	assert.Equal(t, map[string]string{"query": "ERROR"}, resolved.Filters)
+	assert.Equal(t, GlobalFileRegistry.Snapshot()[0].ID, watched.ID)
+
+	// a grown file still opens, a rewritten or gone one does not
+	grown := modTime.Add(time.Minute)
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: "app.log", Type: TypeFile, FileSize: 150, ModTime: &grown}})
+	_, _, err = shares.Resolve(share.ID)
+	assert.NoError(t, err)
+	var shareErr *ShareError
+	for _, changed := range []FileInfo{
+		{FilePath: "app.log", Type: TypeFile, FileSize: 50, ModTime: &grown},
+		{FilePath: "app.log", Type: TypeFile, FileSize: 100, ModTime: &grown},
+	} {
+		GlobalFileRegistry.Replace([]FileInfo{changed})
+		_, _, err = shares.Resolve(share.ID)
+		assert.ErrorAs(t, err, &shareErr)
+		assert.Equal(t, ErrorCodeShareFileChanged, shareErr.Code)
+	}
+	GlobalFileRegistry.Replace(nil)
+	_, _, err = shares.Resolve(share.ID)
+	assert.ErrorAs(t, err, &shareErr)
+	assert.Equal(t, ErrorCodeShareFileGone, shareErr.Code)
+	_, _, err = shares.Resolve("unknown")
+	assert.ErrorAs(t, err, &shareErr)
+	assert.Equal(t, ErrorCodeShareNotFound, shareErr.Code)
+
+	// expired after the ttl, then pruned every interval
+	clock.Advance(time.Hour)
+	_, _, err = shares.Resolve(share.ID)
+	assert.ErrorAs(t, err, &shareErr)
+	assert.Equal(t, ErrorCodeShareExpired, shareErr.Code)
+	later, err := shares.Create(fileInfo, 1, nil)
+	assert.NoError(t, err)
+	running := make(chan struct{})
+	go func() {
+		defer close(running)
+		shares.Run(time.Minute)
+	}()
+	assert.Eventually(t, func() bool { return clock.Tickers() == 1 }, time.Second, time.Millisecond)
+	assert.Eventually(t, func() bool { return len(NewBucket[Share](store, storeBucketShares).All()) == 1 }, time.Second, time.Millisecond)
+	clock.Advance(time.Hour)
+	assert.Empty(t, NewBucket[Share](store, storeBucketShares).All())
+	_, _, err = shares.Resolve(later.ID)
+	assert.ErrorAs(t, err, &shareErr)
+	assert.Equal(t, ErrorCodeShareNotFound, shareErr.Code)
+	shares.Close()
+	<-running
+}
+
+func TestAPIHandler_Shares(t *testing.T) {
+	SetGlobalStore(NewMemoryStore())
+	defer SetGlobalStore(NewMemoryStore())
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	GlobalFileRegistry.Replace(SetFileIDs([]FileInfo{{FilePath: "app.log", Type: TypeFile, FileSize: 100}}))
+	id := GlobalFileRegistry.Snapshot()[0].ID
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	serve := func(method string, target string, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.Header.Set("Content-Type", "application/json")
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, req)
+		return rec
+	}
+
+	rec := serve(http.MethodPost, "/api/shares", `{"id":"`+id+`","line_number":7,"filters":{"query":"ERROR","reverse":"true"}}`)
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var created ShareResult
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &created))
+	assert.NotEmpty(t, created.ID)
+
+	rec = serve(http.MethodGet, "/api/shares/"+created.ID, "")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	var resolved ShareResult
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &resolved))
+	assert.Equal(t, id, resolved.FileID)
+	assert.Equal(t, 7, resolved.LineNumber)
+	assert.Equal(t, "ERROR", resolved.Filters["query"])
+
+	// files that are not watched are not shared
+	rec = serve(http.MethodPost, "/api/shares", `{"file_path":"/etc/passwd","type":"file","line_number":1}`)
+	assert.Equal(t, http.StatusForbidden, rec.Code)
+	rec = serve(http.MethodPost, "/api/shares", `{"id":"`+id+`"}`)
+	assert.Equal(t, http.StatusUnprocessableEntity, rec.Code)
+
+	GlobalFileRegistry.Replace(nil)
+	rec = serve(http.MethodGet, "/api/shares/"+created.ID, "")
+	assert.Equal(t, http.StatusGone, rec.Code)
+	assert.Contains(t, rec.Body.String(), ErrorCodeShareFileGone)
+	rec = serve(http.MethodGet, "/api/shares/unknown", "")
+	assert.Equal(t, http.StatusNotFound, rec.Code)
+}
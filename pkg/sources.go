@@ -0,0 +1,153 @@
+package pkg
+
+import (
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// SourceStatus is the outcome of the last listing of one source: a file pattern, an SSH path or a container
+type SourceStatus struct {
+	Source    string    `json:"source"`
+	Type      string    `json:"type"`
+	Host      string    `json:"host,omitempty"`
+	Files     int       `json:"files"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	// Pending is set for SSH paths still resolving after the discovery deadline, listed with their previous files
+	Pending bool `json:"pending,omitempty"`
+	// Logs are the recent lines of gol's own log about a failing source
+	Logs []string `json:"logs,omitempty"`
+	// HealthCheck is the last deep health check of the type and host of the source
+	HealthCheck *HealthCheck `json:"health_check,omitempty"`
+}
+
+// SourceStatuses keeps the status of every source, replaced as a whole on each rescan, and the
+// checks of the last deep health check, kept until the next one
+type SourceStatuses struct {
+	mutex        sync.RWMutex
+	statuses     []SourceStatus
+	healthChecks []HealthCheck
+	// scannedAt is the end of the last rescan, zero until the first one completed
+	scannedAt time.Time
+}
+
+func NewSourceStatuses() *SourceStatuses {
+	return &SourceStatuses{statuses: []SourceStatus{}}
+}
+
+func (s *SourceStatuses) Set(statuses []SourceStatus) {
+	sort.SliceStable(statuses, func(i, j int) bool {
+		if statuses[i].Type != statuses[j].Type {
+			return statuses[i].Type < statuses[j].Type
+		}
+		return statuses[i].Source < statuses[j].Source
+	})
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.statuses = statuses
+}
+
+// MarkScanned records that a rescan of the sources completed
+func (s *SourceStatuses) MarkScanned() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.scannedAt = GlobalClock.Now()
+}
+
+// ScannedAt is the end of the last rescan, zero before the first one
+func (s *SourceStatuses) ScannedAt() time.Time {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	return s.scannedAt
+}
+
+func (s *SourceStatuses) SetHealthChecks(checks []HealthCheck) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.healthChecks = checks
+}
+
+// List returns the statuses, each with the last health check of its type and host
+func (s *SourceStatuses) List() []SourceStatus {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	statuses := append([]SourceStatus{}, s.statuses...)
+	for i, status := range statuses {
+		for _, check := range s.healthChecks {
+			if check.Type == status.Type && check.Host == status.Host {
+				statuses[i].HealthCheck = &check
+				break
+			}
+		}
+	}
+	return statuses
+}
+
+// newSourceStatus records the result of listing one source
+func newSourceStatus(source string, sourceType string, host string, fileInfos []FileInfo, err error) SourceStatus {
+	status := SourceStatus{Source: source, Type: sourceType, Host: host, Files: len(fileInfos), CheckedAt: GlobalClock.Now()}
+	if err != nil {
+		status.Error = err.Error()
+	}
+	return status
+}
+
+type SourcesResponse struct {
+	Sources []SourceStatus `json:"sources"`
+	Disk    []DiskUsage    `json:"disk"`
+}
+
+// GetSources reports the status of every source and the disk space of the temp and data dirs.
+// Failing sources come with the recent internal log lines mentioning them.
+func (h *APIHandler) GetSources(c echo.Context) error {
+	statuses := GlobalSourceStatuses.List()
+	if GlobalLogBuffer != nil {
+		for i, status := range statuses {
+			if status.Error != "" {
+				statuses[i].Logs = GlobalLogBuffer.Matching(sourceStatusLogLines, status.Source, status.Host)
+			}
+		}
+	}
+	return c.JSON(http.StatusOK, SourcesResponse{
+		Sources: statuses,
+		Disk:    DiskUsages(),
+	})
+}
+
+// Changes wakes up its subscribers when something changed, like Jobs does for the events stream
+type Changes struct {
+	mutex       sync.Mutex
+	subscribers map[chan struct{}]struct{}
+}
+
+func NewChanges() *Changes {
+	return &Changes{subscribers: map[chan struct{}]struct{}{}}
+}
+
+// Subscribe returns a channel receiving after each change, changes made while it is not read are merged
+func (c *Changes) Subscribe() (<-chan struct{}, func()) {
+	changed := make(chan struct{}, 1)
+	c.mutex.Lock()
+	c.subscribers[changed] = struct{}{}
+	c.mutex.Unlock()
+	return changed, func() {
+		c.mutex.Lock()
+		delete(c.subscribers, changed)
+		c.mutex.Unlock()
+	}
+}
+
+func (c *Changes) Notify() {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for changed := range c.subscribers {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	}
+}
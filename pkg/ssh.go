@@ -1,53 +1,103 @@
 package pkg
 
 import (
-	"sync"
+	"context"
+	"errors"
+	"io"
 
 	"golang.org/x/crypto/ssh"
 )
 
-var (
-	clientMutex = &sync.Mutex{}
-)
-
+// Deprecated: use GlobalSSHPool.Session.
 func NewSession(config *SSHConfig) (*ssh.Session, error) {
-	client, err := NewOrReusableClient(config)
-	if err != nil {
-		return nil, err
-	}
-	session, err := client.NewSession()
+	return NewSessionContext(context.Background(), config)
+}
+
+// Deprecated: use GlobalSSHPool.Session, whose sessions count against the max sessions of the client.
+func NewSessionContext(ctx context.Context, config *SSHConfig) (*ssh.Session, error) {
+	client, err := GlobalSSHPool.Client(ctx, config)
 	if err != nil {
 		return nil, err
 	}
-	return session, nil
+	return client.NewSession()
 }
 
+// Deprecated: use GlobalSSHPool.Client.
 func NewOrReusableClient(config *SSHConfig) (*ssh.Client, error) {
-	key := config.Host + ":" + config.Port
+	return GlobalSSHPool.Client(context.Background(), config)
+}
 
-	clientMutex.Lock()
-	client := GlobalSSHClients[key]
-	clientMutex.Unlock()
+// Deprecated: use GlobalSSHPool.Client.
+func NewOrReusableClientContext(ctx context.Context, config *SSHConfig) (*ssh.Client, error) {
+	return GlobalSSHPool.Client(ctx, config)
+}
 
-	if client == nil {
-		c, err := sshConnect(config)
-		if err != nil {
-			return nil, err
-		}
-		clientMutex.Lock()
-		GlobalSSHClients[key] = c
-		clientMutex.Unlock()
-		client = c
+// runSession runs cmd on the session, closing the session when ctx is done aborts the remote command
+func runSession(ctx context.Context, session *ssh.Session, cmd string) error {
+	stop := context.AfterFunc(ctx, func() { session.Close() })
+	defer stop()
+	err := session.Run(cmd)
+	if ctxErr := ctx.Err(); ctxErr != nil {
+		return ctxErr
 	}
+	return err
+}
+
+// sessionReader is the stdout of a command running in a session. Closing it, or ctx being done,
+// closes the session, which ends the command.
+type sessionReader struct {
+	ctx     context.Context
+	session *PooledSession
+	stdout  io.Reader
+	stop    func() bool
+	waited  bool
+	waitErr error
+}
 
-	// check if client is still connected
-	_, _, err := client.SendRequest("", true, nil)
+// startSession starts cmd on the session and returns its stdout, the session is closed with it
+func startSession(ctx context.Context, session *PooledSession, cmd string) (io.ReadCloser, error) {
+	stdout, err := session.StdoutPipe()
+	if err == nil {
+		err = session.Start(cmd)
+	}
 	if err != nil {
-		clientMutex.Lock()
-		delete(GlobalSSHClients, key)
-		clientMutex.Unlock()
-		return NewOrReusableClient(config)
+		session.Close()
+		return nil, err
+	}
+	return &sessionReader{
+		ctx:     ctx,
+		session: session,
+		stdout:  stdout,
+		stop:    context.AfterFunc(ctx, func() { session.Close() }),
+	}, nil
+}
+
+// Read returns the error of the command once its output is read, a failed cat does not look like an empty file
+func (r *sessionReader) Read(p []byte) (int, error) {
+	n, err := r.stdout.Read(p)
+	if ctxErr := r.ctx.Err(); err != nil && ctxErr != nil {
+		return n, ctxErr
 	}
+	if !errors.Is(err, io.EOF) {
+		return n, err
+	}
+	if !r.waited {
+		r.waited = true
+		r.waitErr = r.session.Wait()
+		if r.waitErr != nil && r.waitErr.Error() == ErrorMsgSessionAlreadyStarted {
+			r.waitErr = nil
+		}
+	}
+	if r.waitErr != nil {
+		return n, r.waitErr
+	}
+	return n, io.EOF
+}
 
-	return client, nil
+func (r *sessionReader) Close() error {
+	r.stop()
+	if err := r.session.Close(); err != nil && !errors.Is(err, io.EOF) {
+		return err
+	}
+	return nil
 }
@@ -0,0 +1,178 @@
+package pkg
+
+import (
+	"context"
+	"reflect"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultSSHWorkers is the number of SSH paths resolved at once
+	DefaultSSHWorkers = 4
+	// DefaultSSHDeadline is how long a rescan waits for the SSH paths, slower ones keep resolving in the background
+	DefaultSSHDeadline = 15 * time.Second
+
+	// sshResolveTimeout gives up on a host that never answers, freeing its worker
+	sshResolveTimeout = 5 * time.Minute
+)
+
+// SSHDiscovery lists the files of the SSH paths in parallel, with a bounded pool of workers.
+// Paths still resolving at the deadline keep resolving in the background, their previous files
+// are listed meanwhile and their status is pending. Once one resolves, the file list is republished.
+type SSHDiscovery struct {
+	mutex    sync.Mutex
+	workers  chan struct{}
+	deadline time.Duration
+	results  map[string]sshResult
+	inFlight map[string]*sshFlight
+}
+
+type sshResult struct {
+	fileInfos []FileInfo
+	status    SourceStatus
+}
+
+type sshFlight struct {
+	done chan struct{}
+	// late is set once the rescan that started the flight stopped waiting for it
+	late bool
+}
+
+func NewSSHDiscovery(workers int, deadline time.Duration) *SSHDiscovery {
+	if workers < 1 {
+		workers = 1
+	}
+	return &SSHDiscovery{
+		workers:  make(chan struct{}, workers),
+		deadline: deadline,
+		results:  map[string]sshResult{},
+		inFlight: map[string]*sshFlight{},
+	}
+}
+
+// Resolve lists the files of configs, waiting for them up to the deadline.
+// A path still resolving from a previous rescan is not started again.
+func (d *SSHDiscovery) Resolve(configs []SSHPathConfig, limit int) {
+	if len(configs) == 0 {
+		return
+	}
+	flights := make([]*sshFlight, 0, len(configs))
+	for _, config := range configs {
+		flights = append(flights, d.start(config, limit))
+	}
+	deadline := GlobalClock.After(d.deadline)
+	for _, flight := range flights {
+		select {
+		case <-flight.done:
+			continue
+		case <-deadline:
+		}
+		break
+	}
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	for _, flight := range d.inFlight {
+		flight.late = true
+	}
+}
+
+// Results returns the last files and status of every config, pending for those still resolving
+func (d *SSHDiscovery) Results(configs []SSHPathConfig) ([]FileInfo, []SourceStatus) {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	fileInfos := []FileInfo{}
+	statuses := []SourceStatus{}
+	for _, config := range configs {
+		key := sshDiscoveryKey(config)
+		result, ok := d.results[key]
+		if !ok {
+			result.status = SourceStatus{Source: config.FilePath, Type: TypeSSH, Host: config.Host, CheckedAt: GlobalClock.Now()}
+		}
+		if _, resolving := d.inFlight[key]; resolving {
+			result.status.Pending = true
+		}
+		fileInfos = append(fileInfos, result.fileInfos...)
+		statuses = append(statuses, result.status)
+	}
+	return fileInfos, statuses
+}
+
+func (d *SSHDiscovery) start(config SSHPathConfig, limit int) *sshFlight {
+	key := sshDiscoveryKey(config)
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if flight, ok := d.inFlight[key]; ok {
+		return flight
+	}
+	flight := &sshFlight{done: make(chan struct{})}
+	d.inFlight[key] = flight
+	go d.resolve(key, config, limit, flight)
+	return flight
+}
+
+func (d *SSHDiscovery) resolve(key string, config SSHPathConfig, limit int, flight *sshFlight) {
+	d.workers <- struct{}{}
+	ctx, cancel := context.WithTimeout(context.Background(), sshResolveTimeout)
+	fileInfos, err := GetFileInfosContext(ctx, config.FilePath, limit, true, config.ToSSHConfig())
+	cancel()
+	<-d.workers
+
+	d.mutex.Lock()
+	d.results[key] = sshResult{fileInfos: fileInfos, status: newSourceStatus(config.FilePath, TypeSSH, config.Host, fileInfos, err)}
+	delete(d.inFlight, key)
+	late := flight.late
+	d.mutex.Unlock()
+	close(flight.done)
+	if late {
+		GlobalDiscoveredSources.Publish()
+	}
+}
+
+func sshDiscoveryKey(config SSHPathConfig) string {
+	return config.User + "@" + config.Host + ":" + config.Port + " " + config.FilePath
+}
+
+// DiscoveredSources are the sources of the last rescan. The SSH paths are kept as configs, their
+// files are taken from the SSH discovery each time the file list is published. Local files are kept
+// apart, the path watcher rescanning them alone.
+type DiscoveredSources struct {
+	mutex         sync.Mutex
+	localInfos    []FileInfo
+	localStatuses []SourceStatus
+	fileInfos     []FileInfo
+	statuses      []SourceStatus
+	sshConfigs    []SSHPathConfig
+}
+
+func (s *DiscoveredSources) SetLocal(fileInfos []FileInfo, statuses []SourceStatus) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.localInfos = fileInfos
+	s.localStatuses = statuses
+}
+
+func (s *DiscoveredSources) Set(fileInfos []FileInfo, statuses []SourceStatus, sshConfigs []SSHPathConfig) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.fileInfos = fileInfos
+	s.statuses = statuses
+	s.sshConfigs = sshConfigs
+}
+
+// Publish replaces the file list and the source statuses, telling the events stream when the list changed
+func (s *DiscoveredSources) Publish() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	sshFileInfos, sshStatuses := GlobalSSHDiscovery.Results(s.sshConfigs)
+	fileInfos := append(append(append([]FileInfo{}, s.localInfos...), s.fileInfos...), sshFileInfos...)
+	GlobalSourceStatuses.Set(append(append(append([]SourceStatus{}, s.localStatuses...), s.statuses...), sshStatuses...))
+
+	fileInfos = UniqueFileInfos(SortFileInfos(ExcludeFileInfos(fileInfos, GlobalExcludes)))
+	filePaths := SetFileIDs(ApplyPathDefaults(GroupRotatedFileInfos(SetFileGroups(fileInfos), GlobalRotationSuffixes)))
+	changed := !reflect.DeepEqual(filePaths, GlobalFileRegistry.Snapshot())
+	GlobalFileRegistry.Replace(filePaths)
+	if changed {
+		GlobalFileListChanges.Notify()
+	}
+}
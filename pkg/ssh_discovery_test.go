@@ -0,0 +1,109 @@
+package pkg
+
+import (
+	"testing"
+	"testing/fstest"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSSHDiscovery_Deadline(t *testing.T) {
+	slow := make(chan struct{})
+	runner := &ScriptedRemoteRunner{
+		Outputs: map[string]string{
+			"web1 ls /var/log/*.log":    "/var/log/app.log\n",
+			"web1 cat /var/log/app.log": "INFO a\n",
+			"web2 ls /var/log/*.log":    "/var/log/db.log\n",
+			"web2 cat /var/log/db.log":  "INFO b\nINFO c\n",
+		},
+		Gates: map[string]chan struct{}{"web2 ls /var/log/*.log": slow},
+	}
+	clock := NewManualClock(time.Unix(0, 0))
+	useFakes(t, fstest.MapFS{}, runner, clock)
+	defer func(discovery *SSHDiscovery, fileInfos []FileInfo, sshConfigs []SSHPathConfig) {
+		GlobalSSHDiscovery, GlobalPathSSHConfig = discovery, sshConfigs
+		GlobalFileRegistry.Replace(fileInfos)
+		GlobalSourceStatuses.Set(nil)
+	}(GlobalSSHDiscovery, GlobalFileRegistry.Snapshot(), GlobalPathSSHConfig)
+	GlobalSSHDiscovery = NewSSHDiscovery(2, 15*time.Second)
+	sshPaths := SliceFlags{"user@web1 /var/log/*.log", "user@web2 /var/log/*.log"}
+	web1, err := StringToSSHPathConfig(sshPaths[0])
+	require.NoError(t, err)
+
+	rescan := func() {
+		done := make(chan struct{})
+		go func() {
+			UpdateGlobalFilePaths(nil, sshPaths, nil, 10)
+			close(done)
+		}()
+		// the deadline passes once web1 answered
+		assert.Eventually(t, func() bool {
+			_, statuses := GlobalSSHDiscovery.Results([]SSHPathConfig{*web1})
+			return clock.Timers() == 1 && !statuses[0].Pending
+		}, time.Second, time.Millisecond)
+		clock.Advance(15 * time.Second)
+		<-done
+	}
+
+	// web2 misses the deadline, it is listed as pending, web1 as checked when it answered
+	answered := clock.Now()
+	rescan()
+	require.Len(t, GlobalFileRegistry.Snapshot(), 1)
+	assert.Equal(t, "web1", GlobalFileRegistry.Snapshot()[0].Host)
+	statuses := GlobalSourceStatuses.List()
+	require.Len(t, statuses, 2)
+	assert.Equal(t, SourceStatus{Source: "/var/log/*.log", Type: TypeSSH, Host: "web1", Files: 1, CheckedAt: answered}, statuses[0])
+	assert.Equal(t, "web2", statuses[1].Host)
+	assert.True(t, statuses[1].Pending)
+	assert.Len(t, GlobalPathSSHConfig, 2)
+
+	// the next rescan waits for the same listing instead of starting another
+	rescan()
+	calls := 0
+	runner.mutex.Lock()
+	for _, call := range runner.Calls {
+		if call == "web2 ls /var/log/*.log" {
+			calls++
+		}
+	}
+	runner.mutex.Unlock()
+	assert.Equal(t, 1, calls)
+	assert.Len(t, GlobalPathSSHConfig, 2)
+
+	// once web2 answers, the file list is republished
+	changed, unsubscribe := GlobalFileListChanges.Subscribe()
+	defer unsubscribe()
+	close(slow)
+	select {
+	case <-changed:
+	case <-time.After(time.Second):
+		t.Fatal("the file list was not republished")
+	}
+	assert.Len(t, GlobalFileRegistry.Snapshot(), 2)
+	statuses = GlobalSourceStatuses.List()
+	require.Len(t, statuses, 2)
+	assert.False(t, statuses[1].Pending)
+	assert.Equal(t, 1, statuses[1].Files)
+}
+
+func TestChanges(t *testing.T) {
+	changes := NewChanges()
+	changed, unsubscribe := changes.Subscribe()
+	changes.Notify()
+	changes.Notify()
+	<-changed
+	select {
+	case <-changed:
+		t.Fatal("changes made while not read are merged")
+	default:
+	}
+	unsubscribe()
+	changes.Notify()
+	select {
+	case <-changed:
+		t.Fatal("unsubscribed")
+	default:
+	}
+}
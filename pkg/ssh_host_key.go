@@ -0,0 +1,57 @@
+package pkg
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"os"
+
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+var (
+	ErrUnknownHostKey  = errors.New("unknown SSH host key")
+	ErrHostKeyMismatch = errors.New("SSH host key mismatch")
+)
+
+// DefaultKnownHostsPath is the known_hosts file SSH paths verify host keys against
+func DefaultKnownHostsPath() string {
+	return fmt.Sprintf("%s/.ssh/known_hosts", os.Getenv("HOME"))
+}
+
+// hostKeyCallback verifies the host key against the known_hosts file of config. A missing file knows
+// no host. Host keys are not verified when StrictHostKey is unset, as for insecure=true SSH paths.
+func hostKeyCallback(config *SSHConfig) (ssh.HostKeyCallback, error) {
+	if !config.StrictHostKey {
+		return ssh.InsecureIgnoreHostKey(), nil // nolint:gosec
+	}
+	knownHostsPath := config.KnownHostsPath
+	if knownHostsPath == "" {
+		knownHostsPath = DefaultKnownHostsPath()
+	}
+	files := []string{knownHostsPath}
+	if _, err := os.Stat(knownHostsPath); errors.Is(err, os.ErrNotExist) {
+		files = nil
+	}
+	callback, err := knownhosts.New(files...)
+	if err != nil {
+		return nil, fmt.Errorf("reading known hosts %s: %w", knownHostsPath, err)
+	}
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		err := callback(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if !errors.As(err, &keyErr) {
+			return err
+		}
+		fingerprint := ssh.FingerprintSHA256(key)
+		if len(keyErr.Want) == 0 {
+			// the line to add, should the fingerprint be the one of the host
+			line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+			return fmt.Errorf("%w: %s presented %s key %s, not in %s. Once verified, add the line: %s",
+				ErrUnknownHostKey, hostname, key.Type(), fingerprint, knownHostsPath, line)
+		}
+		return fmt.Errorf("%w: %s presented %s key %s, which does not match %s:%d",
+			ErrHostKeyMismatch, hostname, key.Type(), fingerprint, keyErr.Want[0].Filename, keyErr.Want[0].Line)
+	}, nil
+}
@@ -0,0 +1,72 @@
+package pkg
+
+import (
+	"context"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func TestSSHConnect_HostKey(t *testing.T) {
+	host, port, hostKey := startTestSSHServer(t, nil)
+	dir := t.TempDir()
+	address := knownhosts.Normalize(net.JoinHostPort(host, port))
+	knownHosts := filepath.Join(dir, "known_hosts")
+	assert.NoError(t, os.WriteFile(knownHosts, []byte(knownhosts.Line([]string{address}, hostKey)+"\n"), 0600))
+	_, _, otherKey := startTestSSHServer(t, nil)
+	otherHosts := filepath.Join(dir, "other_hosts")
+	assert.NoError(t, os.WriteFile(otherHosts, []byte(knownhosts.Line([]string{address}, otherKey)+"\n"), 0600))
+
+	connect := func(config *SSHConfig) error {
+		config.Host, config.Port = host, port
+		client, err := sshConnect(context.Background(), config)
+		if err == nil {
+			client.Close()
+		}
+		return err
+	}
+
+	// a known host connects
+	assert.NoError(t, connect(&SSHConfig{StrictHostKey: true, KnownHostsPath: knownHosts}))
+
+	// an unknown host is refused with its fingerprint and the line to add
+	err := connect(&SSHConfig{StrictHostKey: true, KnownHostsPath: filepath.Join(dir, "missing")})
+	assert.ErrorIs(t, err, ErrUnknownHostKey)
+	assert.Contains(t, err.Error(), ssh.FingerprintSHA256(hostKey))
+	assert.Contains(t, err.Error(), knownhosts.Line([]string{address}, hostKey))
+
+	// a host with another key than the known one is refused
+	err = connect(&SSHConfig{StrictHostKey: true, KnownHostsPath: otherHosts})
+	assert.ErrorIs(t, err, ErrHostKeyMismatch)
+	assert.Contains(t, err.Error(), ssh.FingerprintSHA256(hostKey))
+	assert.Contains(t, err.Error(), otherHosts+":1")
+
+	// insecure paths do not verify the key
+	assert.NoError(t, connect(&SSHConfig{KnownHostsPath: otherHosts}))
+}
+
+func TestStringToSSHPathConfig_HostKey(t *testing.T) {
+	config, err := StringToSSHPathConfig("user@web1 /var/log/*.log")
+	assert.NoError(t, err)
+	assert.Equal(t, DefaultKnownHostsPath(), config.KnownHostsPath)
+	assert.False(t, config.Insecure)
+	assert.True(t, config.ToSSHConfig().StrictHostKey)
+
+	config, err = StringToSSHPathConfig("user@web1:2222 known_hosts=/etc/gol/known_hosts /var/log/*.log")
+	assert.NoError(t, err)
+	assert.Equal(t, "/etc/gol/known_hosts", config.ToSSHConfig().KnownHostsPath)
+	assert.Equal(t, "/var/log/*.log", config.FilePath)
+
+	config, err = StringToSSHPathConfig("user@web1 insecure=true /var/log/*.log")
+	assert.NoError(t, err)
+	assert.True(t, config.Insecure)
+	assert.False(t, config.ToSSHConfig().StrictHostKey)
+
+	_, err = StringToSSHPathConfig("user@web1 insecure=yes /var/log/*.log")
+	assert.Error(t, err)
+}
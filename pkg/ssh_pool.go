@@ -0,0 +1,256 @@
+package pkg
+
+import (
+	"context"
+	"net"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ssh"
+)
+
+const (
+	// DefaultSSHIdleTimeout is how long a pooled SSH client without sessions stays connected
+	DefaultSSHIdleTimeout = 5 * time.Minute
+	// DefaultSSHMaxSessions is the number of sessions open at once on a pooled client, the MaxSessions default of sshd
+	DefaultSSHMaxSessions = 10
+
+	// sshKeepaliveAfter is how long a client may go unused before its reuse is preceded by a keepalive
+	sshKeepaliveAfter = 30 * time.Second
+	// sshKeepaliveTimeout is how long a keepalive waits for its reply, a hung connection does not answer at all
+	sshKeepaliveTimeout = 10 * time.Second
+	sshKeepaliveRequest = "keepalive@openssh.com"
+)
+
+// SSHPool hands out the sessions of one client per user@host:port. The client is connected on first
+// use, checked with a keepalive when it is reused after a pause, connected again when that fails,
+// and closed once it had no session for the idle timeout. Sessions over the max per client wait
+// for one to close.
+type SSHPool struct {
+	mutex       sync.Mutex
+	clients     map[string]*pooledClient
+	idleTimeout time.Duration
+	maxSessions int
+	// keepaliveTimeout is sshKeepaliveTimeout, shorter in tests
+	keepaliveTimeout time.Duration
+}
+
+type pooledClient struct {
+	key    string
+	client *ssh.Client
+	err    error
+	// ready is closed once the client is connected, or failed to
+	ready    chan struct{}
+	slots    chan struct{}
+	sessions int
+	lastUsed time.Time
+	idle     *time.Timer
+}
+
+// PooledSession is a session of a pooled client, closing it frees its slot on the client
+type PooledSession struct {
+	*ssh.Session
+	release func()
+}
+
+func (s *PooledSession) Close() error {
+	err := s.Session.Close()
+	s.release()
+	return err
+}
+
+// NewSSHPool returns a pool closing clients idle for idleTimeout, 0 keeps them until Close, with at most
+// maxSessions sessions per client, 0 for DefaultSSHMaxSessions
+func NewSSHPool(idleTimeout time.Duration, maxSessions int) *SSHPool {
+	if maxSessions <= 0 {
+		maxSessions = DefaultSSHMaxSessions
+	}
+	return &SSHPool{
+		clients:          map[string]*pooledClient{},
+		idleTimeout:      idleTimeout,
+		maxSessions:      maxSessions,
+		keepaliveTimeout: sshKeepaliveTimeout,
+	}
+}
+
+func sshPoolKey(config *SSHConfig) string {
+	return config.User + "@" + net.JoinHostPort(config.Host, config.Port)
+}
+
+// Session opens a session on the pooled client of config, ctx bounds connecting and waiting for a slot
+func (p *SSHPool) Session(ctx context.Context, config *SSHConfig) (*PooledSession, error) {
+	for {
+		pc, err := p.get(ctx, config)
+		if err != nil {
+			return nil, err
+		}
+		select {
+		case pc.slots <- struct{}{}:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		p.mutex.Lock()
+		if p.clients[pc.key] != pc {
+			// closed while waiting for the slot
+			p.mutex.Unlock()
+			<-pc.slots
+			continue
+		}
+		pc.sessions++
+		if pc.idle != nil {
+			pc.idle.Stop()
+			pc.idle = nil
+		}
+		p.mutex.Unlock()
+
+		release := sync.OnceFunc(func() { p.release(pc) })
+		session, err := pc.client.NewSession()
+		if err != nil {
+			release()
+			if !p.keepalive(pc) {
+				// the connection dropped since the client was handed out
+				continue
+			}
+			return nil, err
+		}
+		return &PooledSession{Session: session, release: release}, nil
+	}
+}
+
+// Client returns the pooled client of config, its sessions are not counted against the max sessions
+func (p *SSHPool) Client(ctx context.Context, config *SSHConfig) (*ssh.Client, error) {
+	pc, err := p.get(ctx, config)
+	if err != nil {
+		return nil, err
+	}
+	return pc.client, nil
+}
+
+// get returns the live pooled client of config, connecting it when there is none
+func (p *SSHPool) get(ctx context.Context, config *SSHConfig) (*pooledClient, error) {
+	key := sshPoolKey(config)
+	for {
+		p.mutex.Lock()
+		pc, ok := p.clients[key]
+		if !ok {
+			pc = &pooledClient{key: key, ready: make(chan struct{}), slots: make(chan struct{}, p.maxSessions)}
+			p.clients[key] = pc
+			p.mutex.Unlock()
+			return p.connect(ctx, pc, config)
+		}
+		p.mutex.Unlock()
+
+		select {
+		case <-pc.ready:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+		if pc.err == nil && p.alive(pc) {
+			return pc, nil
+		}
+		// the client failed to connect or died, the next round connects a new one
+	}
+}
+
+func (p *SSHPool) connect(ctx context.Context, pc *pooledClient, config *SSHConfig) (*pooledClient, error) {
+	pc.client, pc.err = sshConnect(ctx, config)
+	if pc.err != nil {
+		p.remove(pc)
+		close(pc.ready)
+		return nil, pc.err
+	}
+	p.mutex.Lock()
+	pc.lastUsed = GlobalClock.Now()
+	p.mutex.Unlock()
+	close(pc.ready)
+	// a client whose connection drops leaves the pool right away
+	go func() {
+		pc.client.Wait() //nolint: errcheck
+		p.remove(pc)
+	}()
+	return pc, nil
+}
+
+// alive sends a keepalive on a client unused for a while, a client not answering is closed
+func (p *SSHPool) alive(pc *pooledClient) bool {
+	p.mutex.Lock()
+	if p.clients[pc.key] != pc {
+		p.mutex.Unlock()
+		return false
+	}
+	check := GlobalClock.Now().Sub(pc.lastUsed) >= sshKeepaliveAfter
+	pc.lastUsed = GlobalClock.Now()
+	p.mutex.Unlock()
+	if !check {
+		return true
+	}
+	return p.keepalive(pc)
+}
+
+// keepalive checks that the client answers, one that does not in time is closed and leaves the pool
+func (p *SSHPool) keepalive(pc *pooledClient) bool {
+	timeout := time.AfterFunc(p.keepaliveTimeout, func() { pc.client.Close() })
+	_, _, err := pc.client.SendRequest(sshKeepaliveRequest, true, nil)
+	if timeout.Stop() && err == nil {
+		return true
+	}
+	p.remove(pc)
+	pc.client.Close()
+	return false
+}
+
+func (p *SSHPool) release(pc *pooledClient) {
+	<-pc.slots
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	pc.sessions--
+	pc.lastUsed = GlobalClock.Now()
+	if pc.sessions == 0 && p.idleTimeout > 0 && p.clients[pc.key] == pc {
+		pc.idle = time.AfterFunc(p.idleTimeout, func() { p.closeIdle(pc) })
+	}
+}
+
+func (p *SSHPool) closeIdle(pc *pooledClient) {
+	p.mutex.Lock()
+	if pc.sessions > 0 || p.clients[pc.key] != pc {
+		p.mutex.Unlock()
+		return
+	}
+	delete(p.clients, pc.key)
+	p.mutex.Unlock()
+	pc.client.Close()
+}
+
+func (p *SSHPool) remove(pc *pooledClient) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	if p.clients[pc.key] == pc {
+		delete(p.clients, pc.key)
+	}
+	if pc.idle != nil {
+		pc.idle.Stop()
+		pc.idle = nil
+	}
+}
+
+// Len is the number of pooled clients
+func (p *SSHPool) Len() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return len(p.clients)
+}
+
+// Close closes every pooled client, the pool can still be used afterwards
+func (p *SSHPool) Close() {
+	p.mutex.Lock()
+	clients := p.clients
+	p.clients = map[string]*pooledClient{}
+	p.mutex.Unlock()
+	for _, pc := range clients {
+		<-pc.ready
+		p.remove(pc)
+		if pc.client != nil {
+			pc.client.Close()
+		}
+	}
+}
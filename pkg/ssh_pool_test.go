@@ -0,0 +1,210 @@
+package pkg
+
+import (
+	"context"
+	"io"
+	"net"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+// useSSHPool swaps the SSH pool for the test, draining it at the end
+func useSSHPool(t *testing.T, pool *SSHPool) {
+	globalPool := GlobalSSHPool
+	t.Cleanup(func() {
+		pool.Close()
+		GlobalSSHPool = globalPool
+	})
+	GlobalSSHPool = pool
+}
+
+// testSSHExec answers cat of app.log, and blocks the command block until release is closed
+func testSSHExec(release chan struct{}) func(cmd string, channel ssh.Channel) uint32 {
+	return func(cmd string, channel ssh.Channel) uint32 {
+		switch cmd {
+		case "cat /var/log/app.log":
+			io.WriteString(channel, "INFO a\nINFO b\n") //nolint: errcheck
+			return 0
+		case "block":
+			<-release
+			return 0
+		default:
+			return 1
+		}
+	}
+}
+
+func TestSSHPool_OneDialPerHost(t *testing.T) {
+	var accepts atomic.Int64
+	host, port, _ := startCountingSSHServer(t, testSSHExec(nil), &accepts)
+	useSSHPool(t, NewSSHPool(0, 0))
+	config := &SSHConfig{Host: host, Port: port, User: "gol"}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			linesCount, _, err := FileStatsContext(context.Background(), "/var/log/app.log", true, config)
+			assert.NoError(t, err)
+			assert.Equal(t, 2, linesCount)
+		}()
+	}
+	wg.Wait()
+	for i := 0; i < 8; i++ {
+		_, _, err := FileStatsContext(context.Background(), "/var/log/app.log", true, config)
+		assert.NoError(t, err)
+	}
+	assert.Equal(t, int64(1), accepts.Load())
+	assert.Equal(t, 1, GlobalSSHPool.Len())
+
+	// another user is another client
+	_, _, err := FileStatsContext(context.Background(), "/var/log/app.log", true, &SSHConfig{Host: host, Port: port, User: "root"})
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), accepts.Load())
+
+	// Cleanup drains the pool
+	Cleanup()
+	assert.Equal(t, 0, GlobalSSHPool.Len())
+	_, _, err = FileStatsContext(context.Background(), "/var/log/app.log", true, config)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), accepts.Load())
+}
+
+func TestSSHPool_MaxSessions(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	host, port, _ := startTestSSHServer(t, testSSHExec(release))
+	pool := NewSSHPool(0, 1)
+	useSSHPool(t, pool)
+	config := &SSHConfig{Host: host, Port: port}
+
+	session, err := pool.Session(context.Background(), config)
+	assert.NoError(t, err)
+	assert.NoError(t, session.Start("block"))
+
+	// the second session waits for the first to close
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = pool.Session(ctx, config)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+
+	assert.NoError(t, session.Close())
+	// closing it again does not free another slot
+	session.Close() //nolint: errcheck
+	second, err := pool.Session(context.Background(), config)
+	assert.NoError(t, err)
+	assert.NoError(t, second.Close())
+}
+
+func TestSSHPool_IdleTimeout(t *testing.T) {
+	var accepts atomic.Int64
+	host, port, _ := startCountingSSHServer(t, testSSHExec(nil), &accepts)
+	pool := NewSSHPool(50*time.Millisecond, 0)
+	useSSHPool(t, pool)
+	config := &SSHConfig{Host: host, Port: port}
+
+	_, err := SSHRemoteRunner{}.Run(context.Background(), config, "cat /var/log/app.log")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, pool.Len())
+	assert.Eventually(t, func() bool { return pool.Len() == 0 }, 5*time.Second, 10*time.Millisecond)
+
+	_, err = SSHRemoteRunner{}.Run(context.Background(), config, "cat /var/log/app.log")
+	assert.NoError(t, err)
+	assert.Equal(t, int64(2), accepts.Load())
+}
+
+func TestSSHPool_KeepaliveReconnect(t *testing.T) {
+	var accepts atomic.Int64
+	host, port, _ := startCountingSSHServer(t, testSSHExec(nil), &accepts)
+	proxy := startHangingProxy(t, net.JoinHostPort(host, port))
+	proxyHost, proxyPort, err := net.SplitHostPort(proxy.address)
+	assert.NoError(t, err)
+	clock := NewManualClock(time.Now())
+	useFakes(t, nil, nil, clock)
+	pool := NewSSHPool(0, 0)
+	pool.keepaliveTimeout = 100 * time.Millisecond
+	useSSHPool(t, pool)
+	config := &SSHConfig{Host: proxyHost, Port: proxyPort}
+
+	_, err = SSHRemoteRunner{}.Run(context.Background(), config, "cat /var/log/app.log")
+	assert.NoError(t, err)
+
+	// a client reused soon after is not checked
+	proxy.hang()
+	clock.Advance(sshKeepaliveAfter / 2)
+	_, err = pool.Client(context.Background(), config)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), accepts.Load())
+
+	// a client reused after a pause that does not answer its keepalive is replaced
+	clock.Advance(sshKeepaliveAfter)
+	output, err := SSHRemoteRunner{}.Run(context.Background(), config, "cat /var/log/app.log")
+	assert.NoError(t, err)
+	assert.Equal(t, "INFO a\nINFO b\n", string(output))
+	assert.Equal(t, int64(2), accepts.Load())
+	assert.Equal(t, 1, pool.Len())
+}
+
+// hangingProxy forwards connections to a server, hang stops forwarding on the open ones without closing them
+type hangingProxy struct {
+	address string
+	mutex   sync.Mutex
+	hung    []*atomic.Bool
+}
+
+func startHangingProxy(t *testing.T, target string) *hangingProxy {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	proxy := &hangingProxy{address: listener.Addr().String()}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			upstream, err := net.Dial("tcp", target)
+			if err != nil {
+				conn.Close()
+				continue
+			}
+			hung := &atomic.Bool{}
+			proxy.mutex.Lock()
+			proxy.hung = append(proxy.hung, hung)
+			proxy.mutex.Unlock()
+			go forwardUnlessHung(conn, upstream, hung)
+			go forwardUnlessHung(upstream, conn, hung)
+		}
+	}()
+	return proxy
+}
+
+func (p *hangingProxy) hang() {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	for _, hung := range p.hung {
+		hung.Store(true)
+	}
+}
+
+func forwardUnlessHung(dst net.Conn, src net.Conn, hung *atomic.Bool) {
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 && !hung.Load() {
+			if _, err := dst.Write(buf[:n]); err != nil {
+				return
+			}
+		}
+		if err != nil {
+			dst.Close()
+			return
+		}
+	}
+}
@@ -0,0 +1,153 @@
+package pkg
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"io"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+// startTestSSHServer serves SSH on an ephemeral port, without auth, with a new host key. The exec
+// requests of sessions are answered by exec with the exit status of the command, other channels are
+// rejected, as all are when exec is nil. It returns the host and port and the host key.
+func startTestSSHServer(t *testing.T, exec func(cmd string, channel ssh.Channel) uint32) (string, string, ssh.PublicKey) {
+	return startCountingSSHServer(t, exec, nil)
+}
+
+// startCountingSSHServer is startTestSSHServer counting the connections accepted in accepts
+func startCountingSSHServer(
+	t *testing.T,
+	exec func(cmd string, channel ssh.Channel) uint32,
+	accepts *atomic.Int64,
+) (string, string, ssh.PublicKey) {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	assert.NoError(t, err)
+	signer, err := ssh.NewSignerFromKey(private)
+	assert.NoError(t, err)
+	config := &ssh.ServerConfig{NoClientAuth: true}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if accepts != nil {
+				accepts.Add(1)
+			}
+			go serveTestSSH(conn, config, exec)
+		}
+	}()
+	host, port, err := net.SplitHostPort(listener.Addr().String())
+	assert.NoError(t, err)
+	return host, port, signer.PublicKey()
+}
+
+func serveTestSSH(conn net.Conn, config *ssh.ServerConfig, exec func(cmd string, channel ssh.Channel) uint32) {
+	defer conn.Close()
+	serverConn, channels, requests, err := ssh.NewServerConn(conn, config)
+	if err != nil {
+		return
+	}
+	defer serverConn.Close()
+	go ssh.DiscardRequests(requests)
+	for newChannel := range channels {
+		if exec == nil || newChannel.ChannelType() != "session" {
+			newChannel.Reject(ssh.Prohibited, "no sessions") //nolint: errcheck
+			continue
+		}
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		go func() {
+			for req := range requests {
+				var payload struct{ Command string }
+				if req.Type != "exec" || ssh.Unmarshal(req.Payload, &payload) != nil {
+					req.Reply(false, nil) //nolint: errcheck
+					continue
+				}
+				req.Reply(true, nil) //nolint: errcheck
+				status := struct{ Status uint32 }{exec(payload.Command, channel)}
+				channel.SendRequest("exit-status", false, ssh.Marshal(status)) //nolint: errcheck
+				channel.Close()
+			}
+		}()
+	}
+}
+
+func TestSSHRemoteRunner_Stream(t *testing.T) {
+	var written, closedEarly atomic.Int64
+	line := strings.Repeat("x", 1023) + "\n"
+	host, port, _ := startTestSSHServer(t, func(cmd string, channel ssh.Channel) uint32 {
+		switch cmd {
+		case "cat /var/log/big.log":
+			// 1GiB, far more than is read
+			for i := 0; i < 1<<20; i++ {
+				if _, err := io.WriteString(channel, line); err != nil {
+					closedEarly.Add(1)
+					return 1
+				}
+				written.Add(int64(len(line)))
+			}
+			return 0
+		case "cat /var/log/app.log":
+			io.WriteString(channel, "INFO a\nINFO b\n") //nolint: errcheck
+			return 0
+		default:
+			return 1
+		}
+	})
+	config := &SSHConfig{Host: host, Port: port}
+	useSSHPool(t, NewSSHPool(0, 0))
+
+	// the stream is read to its end
+	stream, err := SSHRemoteRunner{}.Stream(context.Background(), config, "cat /var/log/app.log")
+	assert.NoError(t, err)
+	content, err := io.ReadAll(stream)
+	assert.NoError(t, err)
+	assert.Equal(t, "INFO a\nINFO b\n", string(content))
+	assert.NoError(t, stream.Close())
+
+	// a failing command is an error, not an empty file
+	stream, err = SSHRemoteRunner{}.Stream(context.Background(), config, "cat /var/log/missing.log")
+	assert.NoError(t, err)
+	_, err = io.ReadAll(stream)
+	var exitErr *ssh.ExitError
+	assert.True(t, errors.As(err, &exitErr), "%v", err)
+	assert.NoError(t, stream.Close())
+
+	// closing the stream early ends the command, the file is not transferred
+	stream, err = SSHRemoteRunner{}.Stream(context.Background(), config, "cat /var/log/big.log")
+	assert.NoError(t, err)
+	_, err = io.ReadFull(stream, make([]byte, 4096))
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Close())
+	assert.Eventually(t, func() bool { return closedEarly.Load() == 1 }, 5*time.Second, 10*time.Millisecond)
+	assert.Less(t, written.Load(), int64(64<<20))
+
+	// one client is shared by the streams, a dead one is closed and replaced
+	client, err := GlobalSSHPool.Client(context.Background(), config)
+	assert.NoError(t, err)
+	client.Close()
+	stream, err = SSHRemoteRunner{}.Stream(context.Background(), config, "cat /var/log/app.log")
+	assert.NoError(t, err)
+	assert.NoError(t, stream.Close())
+	replaced, err := GlobalSSHPool.Client(context.Background(), config)
+	assert.NoError(t, err)
+	assert.NotSame(t, client, replaced)
+	assert.Equal(t, 1, GlobalSSHPool.Len())
+}
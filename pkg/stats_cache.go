@@ -0,0 +1,223 @@
+package pkg
+
+import (
+	"crypto/sha1" // nolint: gosec
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+const (
+	statsCacheVersion    = 2
+	statsCacheFileName   = "stats-cache.json"
+	fingerprintSize      = 1024
+	lineCheckpointsEvery = 10000
+)
+
+// FileStatsCacheEntry is what is remembered about a local file between scans and restarts
+type FileStatsCacheEntry struct {
+	FilePath    string `json:"file_path"`
+	Fingerprint string `json:"fingerprint"`
+	Size        int64  `json:"size"`
+	ModTime     int64  `json:"mod_time"`
+	LinesCount  int    `json:"lines_count"`
+	// Partial is set when the file ended in a line without newline, counted in LinesCount
+	Partial     bool    `json:"partial,omitempty"`
+	Checkpoints []int64 `json:"checkpoints"`
+	Generation  int     `json:"generation"`
+	// Target is the file a symlink pointed to, a retarget is a rotation
+	Target string `json:"target,omitempty"`
+}
+
+type FileStatsCache struct {
+	mutex   sync.RWMutex
+	entries map[string]FileStatsCacheEntry
+}
+
+type fileStatsCacheFile struct {
+	Version int                   `json:"version"`
+	Entries []FileStatsCacheEntry `json:"entries"`
+}
+
+func NewFileStatsCache() *FileStatsCache {
+	return &FileStatsCache{
+		entries: make(map[string]FileStatsCacheEntry),
+	}
+}
+
+// Get returns the cached entry only when size, mtime and fingerprint still match
+func (c *FileStatsCache) Get(filePath string, size int64, modTime int64, fingerprint string) (FileStatsCacheEntry, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	entry, ok := c.entries[filePath]
+	if !ok || entry.Size != size || entry.ModTime != modTime || entry.Fingerprint != fingerprint {
+		return FileStatsCacheEntry{}, false
+	}
+	return entry, true
+}
+
+// Peek returns the cached entry regardless of whether it is still current
+func (c *FileStatsCache) Peek(filePath string) (FileStatsCacheEntry, bool) {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	entry, ok := c.entries[filePath]
+	return entry, ok
+}
+
+func (c *FileStatsCache) Set(entry FileStatsCacheEntry) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.entries[entry.FilePath] = entry
+}
+
+func (c *FileStatsCache) Delete(filePath string) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	delete(c.entries, filePath)
+}
+
+// Retain drops the entries of files not in filePaths
+func (c *FileStatsCache) Retain(filePaths []string) {
+	keep := make(map[string]bool, len(filePaths))
+	for _, filePath := range filePaths {
+		keep[filePath] = true
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for filePath := range c.entries {
+		if !keep[filePath] {
+			delete(c.entries, filePath)
+		}
+	}
+}
+
+// retainListedFileStats drops the cached stats of the files that are neither in fileInfos nor a
+// segment of one, the files no longer listed and those of a cache loaded for other files
+func retainListedFileStats(fileInfos []FileInfo) {
+	filePaths := make([]string, 0, len(fileInfos))
+	for _, fileInfo := range fileInfos {
+		filePaths = append(filePaths, fileInfo.FilePath)
+		for _, segment := range fileInfo.Segments {
+			filePaths = append(filePaths, segment.FilePath)
+		}
+	}
+	GlobalFileStatsCache.Retain(filePaths)
+}
+
+func (c *FileStatsCache) Len() int {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	return len(c.entries)
+}
+
+// Save writes the cache atomically to path
+func (c *FileStatsCache) Save(path string) error {
+	c.mutex.RLock()
+	data := fileStatsCacheFile{Version: statsCacheVersion, Entries: make([]FileStatsCacheEntry, 0, len(c.entries))}
+	for _, entry := range c.entries {
+		data.Entries = append(data.Entries, entry)
+	}
+	c.mutex.RUnlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, b, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Load reads a previously saved cache from path.
+// Missing, corrupt or version mismatched files are silently discarded.
+func (c *FileStatsCache) Load(path string) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return
+	}
+	var data fileStatsCacheFile
+	if err := json.Unmarshal(b, &data); err != nil || data.Version != statsCacheVersion {
+		slog.Debug("discarding stats cache", "path", path)
+		return
+	}
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	for _, entry := range data.Entries {
+		c.entries[entry.FilePath] = entry
+	}
+}
+
+// StatsCacheFilePath is where the stats cache is persisted inside the data dir
+func StatsCacheFilePath() string {
+	return filepath.Join(GlobalDataDir, statsCacheFileName)
+}
+
+// SaveGlobalFileStatsCache persists the global cache, logging but never failing
+func SaveGlobalFileStatsCache() {
+	if GlobalDataDir == "" {
+		return
+	}
+	if err := EnsureFreeDisk(GlobalDataDir, 0); err != nil {
+		slog.Warn("not saving stats cache", "path", StatsCacheFilePath(), "error", err)
+		return
+	}
+	if err := GlobalFileStatsCache.Save(StatsCacheFilePath()); err != nil {
+		slog.Warn("saving stats cache", "path", StatsCacheFilePath(), "error", err)
+	}
+}
+
+// BumpGeneration records that filePath was truncated or replaced and returns its new generation.
+// The cached stats are invalidated but the generation is kept.
+func (c *FileStatsCache) BumpGeneration(filePath string) int {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	entry := c.entries[filePath]
+	c.entries[filePath] = FileStatsCacheEntry{FilePath: filePath, Generation: entry.Generation + 1}
+	return entry.Generation + 1
+}
+
+// FileGeneration is the rotation generation of a local file, bumped whenever it was
+// replaced or truncated under the same name
+func FileGeneration(filePath string) int {
+	entry, _ := GlobalFileStatsCache.Peek(filePath)
+	return entry.Generation
+}
+
+// nextGeneration returns the generation for a fresh scan of filePath, pointing to target when a symlink
+func nextGeneration(filePath string, size int64, fingerprint string, target string) int {
+	previous, ok := GlobalFileStatsCache.Peek(filePath)
+	if !ok {
+		return 0
+	}
+	retargeted := previous.Target != "" && previous.Target != target
+	if retargeted || size < previous.Size || (previous.Size >= fingerprintSize && previous.Fingerprint != fingerprint) {
+		return previous.Generation + 1
+	}
+	return previous.Generation
+}
+
+// Fingerprint hashes the first bytes of a file, to tell apart files replaced under the same name
+func Fingerprint(file io.ReaderAt) (string, error) {
+	return fingerprintPrefix(file, fingerprintSize)
+}
+
+// fingerprintPrefix hashes the first size bytes of a file, the fingerprint of a file that was size bytes long
+func fingerprintPrefix(file io.ReaderAt, size int64) (string, error) {
+	buffer := make([]byte, size)
+	n, err := file.ReadAt(buffer, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return "", err
+	}
+	sum := sha1.Sum(buffer[:n]) // nolint: gosec
+	return hex.EncodeToString(sum[:]), nil
+}
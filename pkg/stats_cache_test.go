@@ -0,0 +1,108 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStatsCache_SaveLoad(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "stats-cache.json")
+
+	cache := NewFileStatsCache()
+	cache.Set(FileStatsCacheEntry{FilePath: "/var/log/app.log", Fingerprint: "abc", Size: 10, ModTime: 1, LinesCount: 3})
+	assert.NoError(t, cache.Save(path))
+
+	loaded := NewFileStatsCache()
+	loaded.Load(path)
+	entry, ok := loaded.Get("/var/log/app.log", 10, 1, "abc")
+	assert.True(t, ok)
+	assert.Equal(t, 3, entry.LinesCount)
+
+	// any change of size, mtime or fingerprint is a miss
+	_, ok = loaded.Get("/var/log/app.log", 11, 1, "abc")
+	assert.False(t, ok)
+	_, ok = loaded.Get("/var/log/app.log", 10, 1, "def")
+	assert.False(t, ok)
+
+	// corrupt and version mismatched files are discarded
+	assert.NoError(t, os.WriteFile(path, []byte("{not json"), 0600))
+	corrupt := NewFileStatsCache()
+	corrupt.Load(path)
+	assert.Equal(t, 0, corrupt.Len())
+
+	assert.NoError(t, os.WriteFile(path, []byte(`{"version":999,"entries":[{"file_path":"x"}]}`), 0600))
+	mismatch := NewFileStatsCache()
+	mismatch.Load(path)
+	assert.Equal(t, 0, mismatch.Len())
+}
+
+func TestFileStats_UsesCache(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte("a\nb\nc\n"), 0600))
+
+	linesCount, _, err := FileStats(logFile, false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 3, linesCount)
+
+	// tamper with the cached count, an unchanged file must be served from the cache
+	file, err := os.Open(logFile)
+	assert.NoError(t, err)
+	defer file.Close()
+	fileInfo, err := file.Stat()
+	assert.NoError(t, err)
+	fingerprint, err := Fingerprint(file)
+	assert.NoError(t, err)
+	entry, ok := GlobalFileStatsCache.Get(logFile, fileInfo.Size(), fileInfo.ModTime().UnixNano(), fingerprint)
+	assert.True(t, ok)
+	entry.LinesCount = 42
+	GlobalFileStatsCache.Set(entry)
+
+	linesCount, _, err = FileStats(logFile, false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 42, linesCount)
+
+	// only the appended lines of a grown file are counted, on top of the cached count
+	assert.NoError(t, os.WriteFile(logFile, []byte("a\nb\nc\nd\n"), 0600))
+	linesCount, _, err = FileStats(logFile, false, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 43, linesCount)
+}
+
+func TestFileStatsCache_PrunedWithRegistry(t *testing.T) {
+	cache := GlobalFileStatsCache
+	t.Cleanup(func() { GlobalFileStatsCache = cache })
+	GlobalFileStatsCache = NewFileStatsCache()
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	for _, filePath := range []string{"/var/log/a.log", "/var/log/b.log", "/var/log/b.log.1", "/var/log/gone.log"} {
+		GlobalFileStatsCache.Set(FileStatsCacheEntry{FilePath: filePath, LinesCount: 1})
+	}
+	cached := func() []string {
+		filePaths := []string{}
+		for _, filePath := range []string{"/var/log/a.log", "/var/log/b.log", "/var/log/b.log.1", "/var/log/gone.log"} {
+			if _, ok := GlobalFileStatsCache.Peek(filePath); ok {
+				filePaths = append(filePaths, filePath)
+			}
+		}
+		return filePaths
+	}
+
+	// the files no longer listed are dropped, the segments of the listed ones kept
+	GlobalFileRegistry.Replace([]FileInfo{
+		{FilePath: "/var/log/a.log", Type: TypeFile},
+		{FilePath: "/var/log/a.log", Type: TypeSSH, Host: "web1"},
+		{FilePath: "/var/log/b.log", Type: TypeFile, Segments: []FileInfo{{FilePath: "/var/log/b.log.1", Type: TypeFile}}},
+	})
+	assert.Equal(t, []string{"/var/log/a.log", "/var/log/b.log", "/var/log/b.log.1"}, cached())
+
+	// a path still listed for another host is kept
+	assert.True(t, GlobalFileRegistry.Remove("/var/log/a.log", TypeFile, ""))
+	assert.Equal(t, []string{"/var/log/a.log", "/var/log/b.log", "/var/log/b.log.1"}, cached())
+	assert.True(t, GlobalFileRegistry.Remove("/var/log/a.log", TypeSSH, "web1"))
+	assert.Equal(t, []string{"/var/log/b.log", "/var/log/b.log.1"}, cached())
+	assert.Equal(t, 2, GlobalFileStatsCache.Len())
+}
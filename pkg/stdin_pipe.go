@@ -0,0 +1,163 @@
+package pkg
+
+import (
+	"bufio"
+	"io"
+	"log/slog"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/acarl005/stripansi"
+)
+
+// DefaultStdinBuffer is the number of lines piped to gol kept viewable
+const DefaultStdinBuffer = 100000
+
+// StdinPipe copies the lines piped to gol into its temp file as they come, keeping the last limit of
+// them. The lines read wait in a queue the writer drains, so that a producer faster than the disk is
+// never blocked: the oldest queued lines are dropped instead. Lines dropped from the queue and trimmed
+// off the file are counted as dropped.
+type StdinPipe struct {
+	file *os.File
+	// name is the source listed, the file path when empty
+	name  string
+	limit int
+	// onClose is called once the end of stdin is in the file
+	onClose func()
+	// lines is the number of lines in the file, of the writer only
+	lines int
+
+	mutex   sync.Mutex
+	cond    *sync.Cond
+	queue   []string
+	eof     bool
+	closed  bool
+	dropped int64
+}
+
+func NewStdinPipe(file *os.File, name string, limit int, onClose func()) *StdinPipe {
+	p := &StdinPipe{file: file, name: name, limit: max(limit, 1), onClose: onClose}
+	p.cond = sync.NewCond(&p.mutex)
+	return p
+}
+
+// Run copies the lines of r until its end, then marks the pipe closed. The lines copied stay viewable.
+func (p *StdinPipe) Run(r io.Reader) error {
+	written := make(chan struct{})
+	go func() {
+		defer close(written)
+		p.write()
+	}()
+
+	scanner := newLineScanner(r)
+	for scanner.Scan() {
+		p.push(stripansi.Strip(scanner.Text()))
+	}
+	p.mutex.Lock()
+	p.eof = true
+	p.cond.Signal()
+	p.mutex.Unlock()
+	<-written
+
+	p.mutex.Lock()
+	p.closed = true
+	p.mutex.Unlock()
+	if p.onClose != nil {
+		p.onClose()
+	}
+	return scanner.Err()
+}
+
+// Name is the source listed, empty for the file path
+func (p *StdinPipe) Name() string {
+	return p.name
+}
+
+// Status is the number of lines dropped so far, and whether stdin reached its end
+func (p *StdinPipe) Status() (int64, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	return p.dropped, p.closed
+}
+
+// push queues a line for the writer, dropping the oldest queued line beyond limit
+func (p *StdinPipe) push(line string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	p.queue = append(p.queue, line)
+	if len(p.queue) > p.limit {
+		p.queue = p.queue[1:]
+		p.dropped++
+	}
+	p.cond.Signal()
+}
+
+// write drains the queue into the file until the end of stdin
+func (p *StdinPipe) write() {
+	for {
+		p.mutex.Lock()
+		for len(p.queue) == 0 && !p.eof {
+			p.cond.Wait()
+		}
+		lines := p.queue
+		p.queue = nil
+		p.mutex.Unlock()
+		if len(lines) == 0 {
+			return
+		}
+		if err := p.append(lines); err != nil {
+			slog.Error("copying stdin", "path", p.file.Name(), "error", err)
+		}
+	}
+}
+
+// append writes lines at the end of the file. A file holding more than limit lines is trimmed to
+// nine tenths of it, so that it is not rewritten for every line.
+func (p *StdinPipe) append(lines []string) error {
+	var b strings.Builder
+	for _, line := range lines {
+		b.WriteString(line)
+		b.WriteByte('\n')
+	}
+	if _, err := p.file.WriteString(b.String()); err != nil {
+		return err
+	}
+	p.lines += len(lines)
+	if p.lines <= p.limit {
+		return nil
+	}
+	return p.trim(max(p.limit-p.limit/10, 1))
+}
+
+// trim drops the oldest lines of the file but keep, in place for the file to stay the one watched
+func (p *StdinPipe) trim(keep int) error {
+	if _, err := p.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	reader := bufio.NewReader(p.file)
+	skip := p.lines - keep
+	for i := 0; i < skip; i++ {
+		if _, err := reader.ReadString('\n'); err != nil {
+			return err
+		}
+	}
+	rest, err := io.ReadAll(reader)
+	if err != nil {
+		return err
+	}
+	if err := p.file.Truncate(0); err != nil {
+		return err
+	}
+	if _, err := p.file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+	if _, err := p.file.Write(rest); err != nil {
+		return err
+	}
+	p.lines = keep
+	p.mutex.Lock()
+	p.dropped += int64(skip)
+	p.mutex.Unlock()
+	return nil
+}
@@ -0,0 +1,111 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func useStdinPipe(t *testing.T, name string, limit int, onClose func()) (*io.PipeWriter, string, chan error) {
+	tmpFile, err := os.Create(filepath.Join(t.TempDir(), "stdin.log"))
+	assert.NoError(t, err)
+	pipe, filePath := GlobalStdinPipe, PipeTmpFilePath()
+	t.Cleanup(func() {
+		GlobalStdinPipe = pipe
+		SetPipeTmpFilePath(filePath)
+		tmpFile.Close()
+	})
+	SetPipeTmpFilePath(tmpFile.Name())
+	GlobalStdinPipe = NewStdinPipe(tmpFile, name, limit, onClose)
+	r, w := io.Pipe()
+	done := make(chan error, 1)
+	go func(p *StdinPipe) { done <- p.Run(r) }(GlobalStdinPipe)
+	return w, tmpFile.Name(), done
+}
+
+func TestStdinPipe_Phases(t *testing.T) {
+	closed := make(chan struct{})
+	w, filePath, done := useStdinPipe(t, "", 1000, func() { close(closed) })
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	lines := func() []string {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api?type=stdin&file_path="+filePath+"&per_page=100", nil))
+		var response APIResponse
+		if rec.Code != http.StatusOK || json.Unmarshal(rec.Body.Bytes(), &response) != nil {
+			return nil
+		}
+		return mergedContents(response.Result.Lines)
+	}
+
+	for phase := 0; phase < 3; phase++ {
+		_, err := io.WriteString(w, fmt.Sprintf("phase %d a\n\x1b[31mphase %d b\x1b[0m\n", phase, phase))
+		assert.NoError(t, err)
+		assert.Eventually(t, func() bool {
+			GlobalFileRegistry.Replace(SetFileIDs(GetFileInfos(filePath, 10, false, nil)))
+			got := lines()
+			return len(got) == 2*(phase+1) && got[len(got)-1] == fmt.Sprintf("phase %d b", phase)
+		}, 2*time.Second, 10*time.Millisecond)
+		fileInfo := GlobalFileRegistry.Snapshot()[0]
+		assert.Equal(t, TypeStdin, fileInfo.Type)
+		assert.False(t, fileInfo.Closed)
+	}
+
+	// the end of stdin closes the source, its lines stay
+	assert.NoError(t, w.Close())
+	<-closed
+	assert.NoError(t, <-done)
+	fileInfos := GetFileInfos(filePath, 10, false, nil)
+	assert.True(t, fileInfos[0].Closed)
+	assert.Equal(t, 6, fileInfos[0].LinesCount)
+	assert.Zero(t, fileInfos[0].Dropped)
+	assert.Len(t, lines(), 6)
+}
+
+func TestStdinPipe_Dropped(t *testing.T) {
+	w, filePath, done := useStdinPipe(t, "", 100, nil)
+	_, err := io.WriteString(w, numberedLines(1, 150))
+	assert.NoError(t, err)
+	assert.NoError(t, w.Close())
+	assert.NoError(t, <-done)
+
+	// beyond the buffer, the file keeps the last lines
+	content, err := os.ReadFile(filePath)
+	assert.NoError(t, err)
+	linesCount, _, err := FileStats(filePath, false, nil)
+	assert.NoError(t, err)
+	assert.LessOrEqual(t, linesCount, 100)
+	assert.Equal(t, numberedLines(150-linesCount+1, 150), string(content))
+	fileInfos := GetFileInfos(filePath, 10, false, nil)
+	assert.Equal(t, int64(150-linesCount), fileInfos[0].Dropped)
+	assert.True(t, fileInfos[0].Closed)
+}
+
+func TestStdinPipe_Backpressure(t *testing.T) {
+	p := NewStdinPipe(nil, "", 3, nil)
+	for i := 1; i <= 5; i++ {
+		p.push(fmt.Sprintf("line %d", i))
+	}
+	// without the writer draining it, the queue drops its oldest lines
+	assert.Equal(t, []string{"line 3", "line 4", "line 5"}, p.queue)
+	dropped, closed := p.Status()
+	assert.Equal(t, int64(2), dropped)
+	assert.False(t, closed)
+}
+
+func TestStdinPipe_Name(t *testing.T) {
+	w, filePath, done := useStdinPipe(t, "deploy logs", 10, nil)
+	assert.NoError(t, w.Close())
+	assert.NoError(t, <-done)
+	fileInfos := GetFileInfos(filePath, 10, false, nil)
+	assert.Equal(t, TypeStdin, fileInfos[0].Type)
+	assert.Equal(t, "deploy logs", fileInfos[0].Name)
+}
@@ -0,0 +1,263 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// StoreSchemaVersion is the version of the layout of the persisted buckets.
+// Bump it together with a StoreMigration from the previous version.
+const StoreSchemaVersion = 1
+
+const storeFileName = "store.json"
+
+// ErrStoreTooNew is a store file written by a newer gol
+var ErrStoreTooNew = errors.New("store was written by a newer version")
+
+// Store is the persisted state shared by handlers: saved filters, bookmarks, shares, alerts, pins.
+// Values are kept as JSON in named buckets, use Bucket for typed access.
+type Store interface {
+	Get(bucket string, key string) (json.RawMessage, bool)
+	List(bucket string) map[string]json.RawMessage
+	Put(bucket string, key string, value json.RawMessage) error
+	Delete(bucket string, key string) error
+	// Update applies fn to the buckets atomically, nothing is written when fn fails
+	Update(fn func(buckets StoreBuckets) error) error
+}
+
+// StoreBuckets are the values of every bucket by key
+type StoreBuckets map[string]map[string]json.RawMessage
+
+// StoreMigration upgrades the buckets of a store from the version From to From+1
+type StoreMigration struct {
+	From    int
+	Migrate func(buckets StoreBuckets) error
+}
+
+// StoreMigrations are applied in order when opening a store written by an older version
+var StoreMigrations = []StoreMigration{}
+
+type storeFile struct {
+	Version int          `json:"version"`
+	Buckets StoreBuckets `json:"buckets"`
+}
+
+// FileStore is a Store backed by a single JSON file. Writers hold a file lock while reading the
+// latest file, applying their change and atomically renaming the new file over it, so concurrent
+// handlers and processes sharing the data dir never lose or corrupt each other's writes.
+// A FileStore without a path is kept in memory only.
+type FileStore struct {
+	path    string
+	mutex   sync.RWMutex
+	buckets StoreBuckets
+}
+
+// OpenFileStore opens or creates the store at path, migrating it from an older version
+func OpenFileStore(path string) (*FileStore, error) {
+	s := &FileStore{path: path, buckets: StoreBuckets{}}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	err := s.Update(func(StoreBuckets) error { return nil })
+	if err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// NewMemoryStore returns a Store that is not persisted
+func NewMemoryStore() *FileStore {
+	return &FileStore{buckets: StoreBuckets{}}
+}
+
+// StoreFilePath is where the store is persisted inside the data dir
+func StoreFilePath(dataDir string) string {
+	return filepath.Join(dataDir, storeFileName)
+}
+
+func (s *FileStore) Get(bucket string, key string) (json.RawMessage, bool) {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	value, ok := s.buckets[bucket][key]
+	return value, ok
+}
+
+func (s *FileStore) List(bucket string) map[string]json.RawMessage {
+	s.mutex.RLock()
+	defer s.mutex.RUnlock()
+	values := make(map[string]json.RawMessage, len(s.buckets[bucket]))
+	for key, value := range s.buckets[bucket] {
+		values[key] = value
+	}
+	return values
+}
+
+func (s *FileStore) Put(bucket string, key string, value json.RawMessage) error {
+	return s.Update(func(buckets StoreBuckets) error {
+		if buckets[bucket] == nil {
+			buckets[bucket] = map[string]json.RawMessage{}
+		}
+		buckets[bucket][key] = value
+		return nil
+	})
+}
+
+func (s *FileStore) Delete(bucket string, key string) error {
+	return s.Update(func(buckets StoreBuckets) error {
+		delete(buckets[bucket], key)
+		return nil
+	})
+}
+
+func (s *FileStore) Update(fn func(buckets StoreBuckets) error) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.path == "" {
+		buckets := copyBuckets(s.buckets)
+		if err := fn(buckets); err != nil {
+			return err
+		}
+		s.buckets = buckets
+		return nil
+	}
+
+	unlock, err := lockFile(s.path + ".lock")
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	// another process may have written since, always start from the file
+	buckets, err := readStoreFile(s.path)
+	if err != nil {
+		return err
+	}
+	if err := fn(buckets); err != nil {
+		return err
+	}
+	if err := writeStoreFile(s.path, buckets); err != nil {
+		return err
+	}
+	s.buckets = buckets
+	return nil
+}
+
+// readStoreFile reads and migrates the store file, a missing file is an empty store
+func readStoreFile(path string) (StoreBuckets, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return StoreBuckets{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var data storeFile
+	if err := json.Unmarshal(b, &data); err != nil {
+		return nil, fmt.Errorf("reading store %s: %w", path, err)
+	}
+	if data.Buckets == nil {
+		data.Buckets = StoreBuckets{}
+	}
+	if data.Version > StoreSchemaVersion {
+		return nil, fmt.Errorf("%w: %s is version %d, expected at most %d", ErrStoreTooNew, path, data.Version, StoreSchemaVersion)
+	}
+	for _, migration := range StoreMigrations {
+		if migration.From < data.Version || migration.From >= StoreSchemaVersion {
+			continue
+		}
+		if err := migration.Migrate(data.Buckets); err != nil {
+			return nil, fmt.Errorf("migrating store from version %d: %w", migration.From, err)
+		}
+		data.Version = migration.From + 1
+	}
+	return data.Buckets, nil
+}
+
+func writeStoreFile(path string, buckets StoreBuckets) error {
+	b, err := json.Marshal(storeFile{Version: StoreSchemaVersion, Buckets: buckets})
+	if err != nil {
+		return err
+	}
+	if err := EnsureFreeDisk(filepath.Dir(path), int64(len(b))); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func copyBuckets(buckets StoreBuckets) StoreBuckets {
+	copied := make(StoreBuckets, len(buckets))
+	for name, values := range buckets {
+		copied[name] = make(map[string]json.RawMessage, len(values))
+		for key, value := range values {
+			copied[name][key] = value
+		}
+	}
+	return copied
+}
+
+// Bucket is typed access to one bucket of a Store
+type Bucket[T any] struct {
+	store Store
+	name  string
+}
+
+func NewBucket[T any](store Store, name string) *Bucket[T] {
+	return &Bucket[T]{store: store, name: name}
+}
+
+func (b *Bucket[T]) Get(key string) (T, bool, error) {
+	var v T
+	raw, ok := b.store.Get(b.name, key)
+	if !ok {
+		return v, false, nil
+	}
+	if err := json.Unmarshal(raw, &v); err != nil {
+		return v, false, err
+	}
+	return v, true, nil
+}
+
+func (b *Bucket[T]) Put(key string, v T) error {
+	raw, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.store.Put(b.name, key, raw)
+}
+
+func (b *Bucket[T]) Delete(key string) error {
+	return b.store.Delete(b.name, key)
+}
+
+// All returns the values by key, values that no longer decode are skipped
+func (b *Bucket[T]) All() map[string]T {
+	values := map[string]T{}
+	for key, raw := range b.store.List(b.name) {
+		var v T
+		if err := json.Unmarshal(raw, &v); err != nil {
+			continue
+		}
+		values[key] = v
+	}
+	return values
+}
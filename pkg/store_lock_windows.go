@@ -0,0 +1,8 @@
+//go:build windows
+
+package pkg
+
+// lockFile is a no-op on windows, writes of one process are still serialized by the store
+func lockFile(string) (func(), error) {
+	return func() {}, nil
+}
@@ -0,0 +1,130 @@
+package pkg
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type storeTestValue struct {
+	Name  string `json:"name"`
+	Count int    `json:"count"`
+}
+
+func TestBucket(t *testing.T) {
+	store, err := OpenFileStore(filepath.Join(t.TempDir(), "store.json"))
+	assert.NoError(t, err)
+	bucket := NewBucket[storeTestValue](store, "values")
+
+	_, ok, err := bucket.Get("a")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	assert.NoError(t, bucket.Put("a", storeTestValue{Name: "a", Count: 1}))
+	v, ok, err := bucket.Get("a")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, storeTestValue{Name: "a", Count: 1}, v)
+	assert.Equal(t, map[string]storeTestValue{"a": {Name: "a", Count: 1}}, bucket.All())
+
+	assert.NoError(t, bucket.Delete("a"))
+	assert.Empty(t, bucket.All())
+}
+
+func TestFileStore_Concurrent(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "store.json")
+	// two stores on one file stand in for two processes sharing the data dir
+	stores := make([]*FileStore, 2)
+	for i := range stores {
+		store, err := OpenFileStore(path)
+		assert.NoError(t, err)
+		stores[i] = store
+	}
+
+	var wg sync.WaitGroup
+	for g := 0; g < 8; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			bucket := NewBucket[storeTestValue](stores[g%len(stores)], "values")
+			counter := NewBucket[int](stores[g%len(stores)], "counters")
+			for i := 0; i < 20; i++ {
+				key := fmt.Sprintf("%d-%d", g, i)
+				assert.NoError(t, bucket.Put(key, storeTestValue{Name: key, Count: i}))
+				assert.NoError(t, stores[g%len(stores)].Update(func(buckets StoreBuckets) error {
+					var n int
+					json.Unmarshal(buckets["counters"]["n"], &n) //nolint: errcheck
+					if buckets["counters"] == nil {
+						buckets["counters"] = map[string]json.RawMessage{}
+					}
+					buckets["counters"]["n"] = json.RawMessage(fmt.Sprint(n + 1))
+					return nil
+				}))
+				counter.All()
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	reopened, err := OpenFileStore(path)
+	assert.NoError(t, err)
+	assert.Len(t, NewBucket[storeTestValue](reopened, "values").All(), 8*20)
+	n, ok, err := NewBucket[int](reopened, "counters").Get("n")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 8*20, n)
+}
+
+func TestFileStore_Update(t *testing.T) {
+	store := NewMemoryStore()
+	assert.NoError(t, store.Put("values", "a", json.RawMessage(`1`)))
+
+	// a failed update changes nothing
+	err := store.Update(func(buckets StoreBuckets) error {
+		buckets["values"]["a"] = json.RawMessage(`2`)
+		return errors.New("failed")
+	})
+	assert.Error(t, err)
+	value, ok := store.Get("values", "a")
+	assert.True(t, ok)
+	assert.Equal(t, json.RawMessage(`1`), value)
+}
+
+func TestOpenFileStore_Versions(t *testing.T) {
+	dir := t.TempDir()
+
+	old := filepath.Join(dir, "old.json")
+	assert.NoError(t, os.WriteFile(old, []byte(`{"version":0,"buckets":{"pins":{"a":"\"a.log\""}}}`), 0600))
+	defer func(migrations []StoreMigration) { StoreMigrations = migrations }(StoreMigrations)
+	StoreMigrations = []StoreMigration{{
+		From: 0,
+		Migrate: func(buckets StoreBuckets) error {
+			buckets["pinned_files"] = buckets["pins"]
+			delete(buckets, "pins")
+			return nil
+		},
+	}}
+	store, err := OpenFileStore(old)
+	assert.NoError(t, err)
+	assert.Empty(t, store.List("pins"))
+	assert.Len(t, store.List("pinned_files"), 1)
+	b, err := os.ReadFile(old)
+	assert.NoError(t, err)
+	assert.Contains(t, string(b), fmt.Sprintf(`"version":%d`, StoreSchemaVersion))
+
+	newer := filepath.Join(dir, "newer.json")
+	assert.NoError(t, os.WriteFile(newer, []byte(fmt.Sprintf(`{"version":%d,"buckets":{}}`, StoreSchemaVersion+1)), 0600))
+	_, err = OpenFileStore(newer)
+	assert.ErrorIs(t, err, ErrStoreTooNew)
+
+	corrupt := filepath.Join(dir, "corrupt.json")
+	assert.NoError(t, os.WriteFile(corrupt, []byte(`{"version":`), 0600))
+	_, err = OpenFileStore(corrupt)
+	assert.Error(t, err)
+}
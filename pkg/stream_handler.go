@@ -0,0 +1,244 @@
+package pkg
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/acarl005/stripansi"
+	"github.com/gorilla/websocket"
+	"github.com/labstack/echo/v4"
+	"github.com/mcuadros/go-defaults"
+)
+
+const (
+	// StreamMessageSnapshot is the first message of a stream, the last lines of the file
+	StreamMessageSnapshot = "snapshot"
+	// StreamMessageLine is a line appended to the file
+	StreamMessageLine = "line"
+	// StreamMessageReset is a truncation or replacement of the file, lines are numbered from 1 again
+	StreamMessageReset = "reset"
+	// StreamMessageSourceRemoved ends the stream of a file a reload stopped watching
+	StreamMessageSourceRemoved = "source_removed"
+
+	streamPingInterval = 30 * time.Second
+	streamWriteTimeout = 10 * time.Second
+)
+
+var streamUpgrader = websocket.Upgrader{}
+
+type StreamRequest struct {
+	ID       string `json:"id" query:"id"`
+	FilePath string `json:"file_path" query:"file_path" validate:"required" message:"file_path is required"`
+	Host     string `json:"host" query:"host"`
+	Type     string `json:"type" query:"type" validate:"required" message:"type is required"`
+	Tail     int    `json:"tail" query:"tail" default:"100" validate:"gte=0" message:"tail >=0 is required"`
+	// Levels (comma separated, like error,warn) keep the lines of these levels, of the snapshot too
+	Levels string `json:"levels" query:"levels"`
+	// IDs follow several files in one stream instead, repeated like ids=a&ids=b, see GetMergedStream
+	IDs []string `json:"ids" query:"ids"`
+}
+
+// StreamMessage is a message of a stream, its fields depend on its type
+type StreamMessage struct {
+	Type string `json:"type"`
+	// Lines of a snapshot
+	Lines []TailEvent `json:"lines,omitempty"`
+	// Line appended to the file
+	Line *TailEvent `json:"line,omitempty"`
+	// Reason of a reset, truncated or reopened
+	Reason     string `json:"reason,omitempty"`
+	Generation int    `json:"generation"`
+	// Target is the file a followed symlink points to after a reset
+	Target string `json:"target,omitempty"`
+	// Sources are the files of a merged stream, sent with its snapshot, the lines refer to them by index
+	Sources []LineSource `json:"sources,omitempty"`
+	// Source is the file of a merged stream reset or removed
+	Source int `json:"source,omitempty"`
+}
+
+// GetStream follows a local file over a WebSocket. A snapshot of its last tail lines is sent first,
+// then every line appended to it. A truncation or replacement of the file is sent as a reset, after
+// which lines are numbered from 1 again. The streams of a file share one tailer. A reload no longer
+// watching the file ends the stream with a source_removed message. The stream of a remote gol file
+// is the stream of its peer, relayed.
+func (h *APIHandler) GetStream(c echo.Context) error {
+	req := new(StreamRequest)
+	if err := BindRequest(c, req); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err)
+	}
+	defaults.SetDefaults(req)
+	if len(req.IDs) > 0 {
+		return h.getMergedStream(c, req)
+	}
+	if err := resolveFileID(req.ID, &req.FilePath, &req.Host, &req.Type); err != nil {
+		return err
+	}
+	msgs, err := ValidateRequest(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, msgs)
+	}
+	if req.Tail > GlobalMaxPerPage {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, fmt.Sprintf("tail must be at most %d", GlobalMaxPerPage))
+	}
+	levels, err := ParseLevels(req.Levels)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	if err := AuthorizeFilePath(req.FilePath, req.Type, req.Host); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err)
+	}
+	if req.Type == TypeRemoteGol {
+		release, err := acquireTail(c)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return h.streamRemote(c, req.Host, req.FilePath)
+	}
+	if !isLocalFile(req.Type, req.FilePath) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "streaming is only supported for local and remote gol files")
+	}
+
+	release, err := acquireTail(c)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	// subscribed before the snapshot is read, the lines appended meanwhile are in both and skipped once
+	events, unsubscribe, err := GlobalTailHub.Subscribe(req.FilePath)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+	defer unsubscribe()
+	ctx, cancel := context.WithCancel(c.Request().Context())
+	defer cancel()
+	classifier := ClassifierFor(req.FilePath)
+	snapshot, seen, err := streamSnapshot(ctx, req.FilePath, req.Tail, classifier, levels)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	conn, err := streamUpgrader.Upgrade(c.Response(), c.Request(), nil)
+	if err != nil {
+		// the upgrader answered the request already
+		return nil
+	}
+	defer conn.Close()
+	// the client sends nothing, reading notices it going away
+	go func() {
+		defer cancel()
+		for {
+			if _, _, err := conn.ReadMessage(); err != nil {
+				return
+			}
+		}
+	}()
+
+	send := func(message StreamMessage) error {
+		conn.SetWriteDeadline(time.Now().Add(streamWriteTimeout)) //nolint: errcheck
+		return conn.WriteJSON(message)
+	}
+	if err := send(snapshot); err != nil {
+		return nil
+	}
+	generation := snapshot.Generation
+	ping, stopPing := GlobalClock.Tick(streamPingInterval)
+	defer stopPing()
+	reloaded, unsubscribeReloads := GlobalSourceReloads.Subscribe()
+	defer unsubscribeReloads()
+	var seq int64
+	for {
+		select {
+		case <-reloaded:
+			if AuthorizeFilePath(req.FilePath, req.Type, req.Host) == nil {
+				continue
+			}
+			send(StreamMessage{Type: StreamMessageSourceRemoved, Generation: generation}) //nolint: errcheck
+			closing := websocket.FormatCloseMessage(websocket.CloseNormalClosure, "source removed")
+			conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(streamWriteTimeout)) //nolint: errcheck
+			return nil
+		case <-ctx.Done():
+			// the client went away or the server shuts down, a client still there reconnects
+			closing := websocket.FormatCloseMessage(websocket.CloseGoingAway, "server shutting down")
+			conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(streamWriteTimeout)) //nolint: errcheck
+			return nil
+		case <-ping:
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteTimeout)); err != nil {
+				return nil
+			}
+		case event, ok := <-events:
+			if !ok {
+				closing := websocket.FormatCloseMessage(websocket.CloseTryAgainLater, "stream fell behind, reconnect")
+				conn.WriteControl(websocket.CloseMessage, closing, time.Now().Add(streamWriteTimeout)) //nolint: errcheck
+				return nil
+			}
+			message := StreamMessage{Type: StreamMessageReset, Reason: event.Type, Generation: event.Generation, Target: event.Target}
+			if event.Type == TailEventLine {
+				if event.Generation == generation && event.LineNumber <= seen {
+					// in the file when the snapshot was taken
+					continue
+				}
+				if !levelEvent(&event, classifier, levels) {
+					continue
+				}
+				seq++
+				event.Seq = seq
+				message = StreamMessage{Type: StreamMessageLine, Line: &event, Generation: event.Generation}
+			} else {
+				generation, seen = event.Generation, 0
+			}
+			if err := send(message); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// isLocalFile tells whether a file of sourceType is read from the local disk, the files copied out of
+// containers included
+func isLocalFile(sourceType string, filePath string) bool {
+	switch sourceType {
+	case TypeSSH, TypeRemoteGol, TypeInternal, TypeHTTP, TypeS3:
+		return false
+	case TypeDocker:
+		return strings.HasPrefix(filePath, TmpContainerPath)
+	}
+	return true
+}
+
+// streamSnapshot is the last n lines of a local file of one of levels, numbered as the tailer numbers them,
+// and its line count
+func streamSnapshot(ctx context.Context, filePath string, n int, classifier *Classifier, levels map[string]bool) (StreamMessage, int, error) {
+	snapshot := StreamMessage{Type: StreamMessageSnapshot, Lines: []TailEvent{}, Generation: FileGeneration(filePath)}
+	linesCount, _, err := FileStatsContext(ctx, filePath, false, nil)
+	if errors.Is(err, os.ErrNotExist) || isEmptyFileErr(err) {
+		// followed from its start once it is written to
+		return snapshot, 0, nil
+	}
+	if err != nil {
+		return snapshot, 0, err
+	}
+	contents, err := ReadTailLinesContext(ctx, filePath, n, false, nil)
+	if err != nil {
+		return snapshot, 0, err
+	}
+	for i, content := range contents {
+		event := TailEvent{
+			Type:       TailEventLine,
+			LineNumber: linesCount - len(contents) + i + 1,
+			Content:    stripansi.Strip(content),
+			Generation: snapshot.Generation,
+		}
+		SanitizeEvent(&event)
+		if levelEvent(&event, classifier, levels) {
+			snapshot.Lines = append(snapshot.Lines, event)
+		}
+	}
+	return snapshot, linesCount, nil
+}
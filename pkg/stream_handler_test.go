@@ -0,0 +1,154 @@
+package pkg
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+	"github.com/stretchr/testify/assert"
+)
+
+// useTailHub swaps the tail hub for one polling every 10ms
+func useTailHub(t *testing.T) *TailHub {
+	hub := GlobalTailHub
+	t.Cleanup(func() { GlobalTailHub = hub })
+	GlobalTailHub = NewTailHub(10 * time.Millisecond)
+	return GlobalTailHub
+}
+
+func dialStream(t *testing.T, server *httptest.Server, query string) *websocket.Conn {
+	url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/stream?" + query
+	conn, res, err := websocket.DefaultDialer.Dial(url, nil)
+	if !assert.NoError(t, err) {
+		t.FailNow()
+	}
+	res.Body.Close()
+	return conn
+}
+
+func readStreamMessage(t *testing.T, conn *websocket.Conn) StreamMessage {
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second)) //nolint: errcheck
+	message := StreamMessage{}
+	assert.NoError(t, conn.ReadJSON(&message))
+	return message
+}
+
+func TestAPIHandler_GetStream(t *testing.T) {
+	hub := useTailHub(t)
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte("line 1\nline 2\nline 3\n"), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: logFile, Type: TypeFile}})
+	server := httptest.NewServer(newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff}))
+	defer server.Close()
+	appendTo := func(content string) {
+		f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0600)
+		assert.NoError(t, err)
+		_, err = f.WriteString(content)
+		assert.NoError(t, err)
+		f.Close()
+	}
+
+	// the last lines first
+	first := dialStream(t, server, "type=file&tail=2&file_path="+logFile)
+	defer first.Close()
+	snapshot := readStreamMessage(t, first)
+	assert.Equal(t, StreamMessageSnapshot, snapshot.Type)
+	assert.Len(t, snapshot.Lines, 2)
+	assert.Equal(t, 2, snapshot.Lines[0].LineNumber)
+	assert.Equal(t, "line 2", snapshot.Lines[0].Content)
+	assert.Equal(t, "line 3", snapshot.Lines[1].Content)
+
+	// then the lines appended, a second client shares the watcher of the first
+	second := dialStream(t, server, "type=file&tail=1&file_path="+logFile)
+	defer second.Close()
+	snapshot = readStreamMessage(t, second)
+	assert.Len(t, snapshot.Lines, 1)
+	assert.Equal(t, 1, hub.Len())
+	assert.Equal(t, 1, hub.Running())
+	appendTo("line 4\n")
+	for _, conn := range []*websocket.Conn{first, second} {
+		message := readStreamMessage(t, conn)
+		assert.Equal(t, StreamMessageLine, message.Type)
+		if assert.NotNil(t, message.Line) {
+			assert.Equal(t, 4, message.Line.LineNumber)
+			assert.Equal(t, "line 4", message.Line.Content)
+			assert.Equal(t, int64(1), message.Line.Seq)
+		}
+	}
+
+	// a truncation resets the stream, lines are numbered from 1 again
+	assert.NoError(t, os.WriteFile(logFile, []byte{}, 0600))
+	message := readStreamMessage(t, first)
+	assert.Equal(t, StreamMessageReset, message.Type)
+	assert.Equal(t, TailEventTruncated, message.Reason)
+	appendTo("again 1\n")
+	message = readStreamMessage(t, first)
+	assert.Equal(t, StreamMessageLine, message.Type)
+	if assert.NotNil(t, message.Line) {
+		assert.Equal(t, 1, message.Line.LineNumber)
+		assert.Equal(t, "again 1", message.Line.Content)
+		assert.Equal(t, message.Generation, message.Line.Generation)
+	}
+
+	// the watcher stops once the last client is gone
+	first.Close()
+	second.Close()
+	assert.Eventually(t, func() bool { return hub.Len() == 0 && hub.Running() == 0 }, 5*time.Second, 10*time.Millisecond)
+}
+
+func TestAPIHandler_GetStream_Errors(t *testing.T) {
+	useTailHub(t)
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte("line 1\n"), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: logFile, Type: TypeFile}, {FilePath: "/var/log/remote.log", Type: TypeSSH, Host: "web1"}})
+	server := httptest.NewServer(newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff}))
+	defer server.Close()
+
+	for query, status := range map[string]int{
+		"type=file&file_path=/var/log/missing.log":                                 http.StatusForbidden,
+		"type=ssh&host=web1&file_path=/var/log/remote.log":                         http.StatusUnprocessableEntity,
+		fmt.Sprintf("type=file&tail=-1&file_path=%s", logFile):                     http.StatusUnprocessableEntity,
+		fmt.Sprintf("type=file&tail=%d&file_path=%s", GlobalMaxPerPage+1, logFile): http.StatusUnprocessableEntity,
+	} {
+		url := "ws" + strings.TrimPrefix(server.URL, "http") + "/api/stream?" + query
+		_, res, err := websocket.DefaultDialer.Dial(url, nil)
+		assert.ErrorIs(t, err, websocket.ErrBadHandshake, query)
+		if assert.NotNil(t, res, query) {
+			assert.Equal(t, status, res.StatusCode, query)
+			res.Body.Close()
+		}
+	}
+}
+
+func TestTailHub_DropsSlowSubscriber(t *testing.T) {
+	hub := NewTailHub(time.Hour)
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte{}, 0600))
+	slow, unsubscribeSlow, err := hub.Subscribe(logFile)
+	assert.NoError(t, err)
+	defer unsubscribeSlow()
+	fast, unsubscribeFast, err := hub.Subscribe(logFile)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, hub.Len())
+
+	hub.mutex.Lock()
+	tail := hub.tails[logFile]
+	hub.mutex.Unlock()
+	for i := 0; i <= tailHubSubscriberBuffer; i++ {
+		hub.publish(logFile, tail, TailEvent{Type: TailEventLine, LineNumber: i + 1})
+		<-fast
+	}
+	// the slow subscriber is closed once its buffer is full, the fast one keeps the tail
+	for range slow {
+	}
+	assert.Equal(t, 1, hub.Len())
+	unsubscribeFast()
+	assert.Equal(t, 0, hub.Len())
+	assert.Eventually(t, func() bool { return hub.Running() == 0 }, 5*time.Second, 10*time.Millisecond)
+}
@@ -6,11 +6,14 @@ import (
 	"strconv"
 	"strings"
 	"sync"
+	"time"
 	"unicode"
 
 	"github.com/acarl005/stripansi"
 	"github.com/gravwell/gravwell/v3/timegrinder"
 	"github.com/mileusna/useragent"
+	"golang.org/x/text/collate"
+	"golang.org/x/text/language"
 )
 
 func F64NumberToK(num *float64) string {
@@ -37,15 +40,54 @@ func StringInSlice(s string, ss []string) bool {
 	}
 	return false
 }
+
+// naturalCollator orders names the way people read them: numbers by value, so app.log.2 comes
+// before app.log.10 and dates in order, case ignored and accented letters next to their base letter.
+// A Collator is not safe for concurrent use.
+var naturalCollator = collate.New(language.Und, collate.Numeric, collate.IgnoreCase)
+var naturalCollatorMutex sync.Mutex
+
+// CompareNatural compares a and b in natural order, names equal but for case are ordered bytewise
+func CompareNatural(a, b string) int {
+	naturalCollatorMutex.Lock()
+	c := naturalCollator.CompareString(a, b)
+	naturalCollatorMutex.Unlock()
+	if c == 0 {
+		return strings.Compare(a, b)
+	}
+	return c
+}
+
+// StringsMissingFrom returns the strings of ss that are not in other, in order
+func StringsMissingFrom(ss []string, other []string) []string {
+	missing := []string{}
+	for _, s := range ss {
+		if !StringInSlice(s, other) {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
+
 func FilePathInGlobalFilePaths(filePath string) bool {
-	for _, fileInfo := range GlobalFilePaths {
-		if fileInfo.FilePath == filePath {
+	for _, fileInfo := range GlobalFileRegistry.Snapshot() {
+		if fileInfo.FilePath == filePath || StringInSlice(filePath, fileInfo.Aliases) {
 			return true
 		}
 	}
 	return false
 }
 
+// FileInfoByID finds a watched file by its FileInfo.ID
+func FileInfoByID(id string) (FileInfo, bool) {
+	for _, fileInfo := range GlobalFileRegistry.Snapshot() {
+		if fileInfo.ID == id {
+			return fileInfo, true
+		}
+	}
+	return FileInfo{}, false
+}
+
 // CleanString removes non-printable characters from a string
 func CleanString(input string) string {
 	cleaned := make([]rune, 0, len(input))
@@ -159,17 +201,8 @@ func AppendGeneralInfo(lines *[]LineResult) {
 }
 
 func appendLogLevel(lines *[]LineResult) {
-	logLines := []string{}
-	for _, line := range *lines {
-		line.Content = stripansi.Strip(line.Content)
-		logLines = append(logLines, line.Content)
-	}
-
-	isConsistent, keywordPosition := ConsistentFormat(logLines)
-	if isConsistent {
-		for i, line := range *lines {
-			(*lines)[i].Level = JudgeLogLevel(line.Content, keywordPosition)
-		}
+	for i, line := range *lines {
+		(*lines)[i].Level = DetectLevel([]byte(stripansi.Strip(line.Content)))
 	}
 }
 
@@ -224,20 +257,26 @@ func initTimeGrinder() error {
 }
 
 func searchDate(input string) string {
+	ts, ok := extractTime(input)
+	if !ok {
+		return ""
+	}
+	return ts.String()
+}
+
+// extractTime finds the first timestamp in input
+func extractTime(input string) (time.Time, bool) {
 	var initErr error
 	once.Do(func() {
 		initErr = initTimeGrinder()
 	})
 	if initErr != nil {
 		slog.Error("Error initializing", "timegrinder", initErr)
-		return ""
+		return time.Time{}, false
 	}
 	ts, ok, err := tg.Extract([]byte(input))
-	if err != nil {
-		return ""
+	if err != nil || !ok {
+		return time.Time{}, false
 	}
-	if !ok {
-		return ""
-	}
-	return ts.String()
+	return ts, true
 }
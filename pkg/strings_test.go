@@ -89,3 +89,35 @@ func TestConsistentFormat(t *testing.T) {
 		})
 	}
 }
+
+func TestCompareNatural(t *testing.T) {
+	tests := []struct {
+		a, b string
+	}{
+		{"app.log.2", "app.log.10"},
+		{"app.log", "app.log.1"},
+		{"app.log.9.gz", "app.log.10.gz"},
+		{"web2", "web10"},
+		{"app-2024-06-01.log", "app-2024-06-10.log"},
+		{"app-2024-05-30.log", "app-2024-06-01.log"},
+		{"app-20240930.log", "app-20241001.log"},
+		{"access.log", "Error.log"},
+		{"App.log", "app.log"},
+		{"eclair.log", "éclair.log"},
+		{"éclair.log", "fig.log"},
+		{"zeta.log", "日本.log"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+" < "+tt.b, func(t *testing.T) {
+			if got := CompareNatural(tt.a, tt.b); got >= 0 {
+				t.Errorf("CompareNatural(%s, %s) = %d; want < 0", tt.a, tt.b, got)
+			}
+			if got := CompareNatural(tt.b, tt.a); got <= 0 {
+				t.Errorf("CompareNatural(%s, %s) = %d; want > 0", tt.b, tt.a, got)
+			}
+		})
+	}
+	if got := CompareNatural("app.log", "app.log"); got != 0 {
+		t.Errorf("CompareNatural(app.log, app.log) = %d; want 0", got)
+	}
+}
@@ -1,14 +1,13 @@
 package pkg
 
 import (
-	"bufio"
 	"log/slog"
 	"os"
 	"os/exec"
 	"os/signal"
 	"runtime"
+	"syscall"
 
-	"github.com/acarl005/stripansi"
 	"github.com/kevincobain2000/go-human-uuid/lib"
 )
 
@@ -31,48 +30,6 @@ func IsInputFromPipe() bool {
 	return (fileInfo.Mode() & os.ModeCharDevice) == 0
 }
 
-func PipeLinesToTmp(tmpFile *os.File) error {
-	scanner := bufio.NewScanner(os.Stdin)
-
-	slog.Info("Temporary file created for stdin", "path", GlobalPipeTmpFilePath)
-
-	linesCount, fileSize, err := FileStats(GlobalPipeTmpFilePath, false, nil)
-	if err != nil {
-		slog.Error("creating FileInfo for temp file", GlobalPipeTmpFilePath, err)
-		return err
-	}
-	tempFileInfo := FileInfo{FilePath: GlobalPipeTmpFilePath, LinesCount: linesCount, FileSize: fileSize, Type: TypeStdin}
-
-	GlobalFilePaths = append([]FileInfo{tempFileInfo}, GlobalFilePaths...)
-	slog.Info("Temporary file added to global file paths", "filePaths", GlobalFilePaths)
-
-	lineCount := 0
-	for scanner.Scan() {
-		line := scanner.Text()
-		line = stripansi.Strip(line)
-		if lineCount >= 10000 {
-			if err := tmpFile.Truncate(0); err != nil {
-				slog.Error("truncating file", GlobalPipeTmpFilePath, err)
-			}
-			if _, err := tmpFile.Seek(0, 0); err != nil {
-				slog.Error("seeking file", GlobalPipeTmpFilePath, err)
-			}
-			lineCount = 0
-		}
-		if _, err := tmpFile.WriteString(line + "\n"); err != nil {
-			slog.Error("writing to file", GlobalPipeTmpFilePath, err)
-		}
-		lineCount++
-	}
-
-	if err := scanner.Err(); err != nil {
-		slog.Error("reading from pipe", GlobalPipeTmpFilePath, err)
-		return err
-	}
-
-	return nil
-}
-
 func GetTmpFileNameForSTDIN() string {
 	gen, _ := lib.NewGenerator([]lib.Option{
 		func(opt *lib.Options) error {
@@ -93,6 +50,26 @@ func GetTmpFileNameForContainer() string {
 	return TmpContainerPath + gen.Generate()
 }
 
+func GetTmpFileNameForK8s() string {
+	gen, _ := lib.NewGenerator([]lib.Option{
+		func(opt *lib.Options) error {
+			opt.Length = 6
+			return nil
+		},
+	}...)
+	return TmpK8sPath + gen.Generate()
+}
+
+func GetTmpFileNameForJournal() string {
+	gen, _ := lib.NewGenerator([]lib.Option{
+		func(opt *lib.Options) error {
+			opt.Length = 6
+			return nil
+		},
+	}...)
+	return TmpJournalPath + gen.Generate()
+}
+
 func OpenBrowser(url string) {
 	var err error
 
@@ -112,26 +89,48 @@ func OpenBrowser(url string) {
 	}
 }
 
-func HandleCltrC(f func()) {
+// HandleSIGHUP calls f every time the process gets SIGHUP
+func HandleSIGHUP(f func()) {
 	c := make(chan os.Signal, 1)
-	signal.Notify(c, os.Interrupt)
+	signal.Notify(c, syscall.SIGHUP)
 	go func() {
-		s := <-c
-		slog.Warn("Got signal", "signal", s)
-		f()
-		close(c)
-		os.Exit(1)
+		for s := range c {
+			slog.Info("Got signal", "signal", s)
+			f()
+		}
 	}()
 }
 
 func Cleanup() {
-	if GlobalPipeTmpFilePath == "" {
+	SaveGlobalFileStatsCache()
+	if GlobalLineIndexes != nil {
+		GlobalLineIndexes.Close()
+	}
+	if GlobalLogBuffer != nil {
+		GlobalLogBuffer.Close()
+	}
+	GlobalSSHPool.Close()
+	if GlobalK8sSource != nil {
+		GlobalK8sSource.Close()
+	}
+	if GlobalJournalSource != nil {
+		GlobalJournalSource.Close()
+	}
+	if GlobalPathWatcher != nil {
+		GlobalPathWatcher.Close()
+	}
+	GlobalFIFOs.Close()
+	GlobalShares.Close()
+	if GlobalAlerts != nil {
+		GlobalAlerts.Close()
+	}
+	if PipeTmpFilePath() == "" {
 		return
 	}
-	err := os.Remove(GlobalPipeTmpFilePath)
+	err := os.Remove(PipeTmpFilePath())
 	if err != nil {
-		slog.Error("removing temp file", GlobalPipeTmpFilePath, err)
+		slog.Error("removing temp file", PipeTmpFilePath(), err)
 		return
 	}
-	slog.Info("temp file removed", "path", GlobalPipeTmpFilePath)
+	slog.Info("temp file removed", "path", PipeTmpFilePath())
 }
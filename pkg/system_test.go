@@ -78,6 +78,18 @@ func TestUniqueFileInfos(t *testing.T) {
 				{FilePath: "path2", Type: "type2", Host: "host2"},
 			},
 		},
+		{
+			name: "same canonical path",
+			input: []FileInfo{
+				{FilePath: "current/app.log", CanonicalPath: "2024-01-02/app.log", Type: "type1", Host: "host1"},
+				{FilePath: "2024-01-02/app.log", Type: "type1", Host: "host1"},
+				{FilePath: "2024-01-02/app.log", Type: "type2", Host: "host1"},
+			},
+			expected: []FileInfo{
+				{FilePath: "current/app.log", CanonicalPath: "2024-01-02/app.log", Type: "type1", Host: "host1"},
+				{FilePath: "2024-01-02/app.log", Type: "type2", Host: "host1"},
+			},
+		},
 		{
 			name: "all duplicates",
 			input: []FileInfo{
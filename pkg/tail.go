@@ -0,0 +1,270 @@
+package pkg
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"os"
+	"time"
+
+	"github.com/acarl005/stripansi"
+)
+
+const (
+	TailEventLine      = "line"
+	TailEventTruncated = "truncated"
+	TailEventReopened  = "reopened"
+	TailEventSources   = "sources"
+	// TailEventSourceRemoved is the last event of a tail whose file a reload stopped watching
+	TailEventSourceRemoved = "source_removed"
+
+	tailPollInterval = 500 * time.Millisecond
+	tailReadChunk    = 64 * 1024
+)
+
+// TailEvent is emitted by a Tailer, either a new line or a change of the followed file
+type TailEvent struct {
+	Type       string `json:"type"`
+	LineNumber int    `json:"line_number,omitempty"`
+	Content    string `json:"content,omitempty"`
+	Class      string `json:"class,omitempty"`
+	Level      string `json:"level,omitempty"`
+	Generation int    `json:"generation"`
+	Source     int    `json:"source"`
+	// Seq numbers the lines of a stream from 1 in the order they are sent, a gap is a lost line
+	Seq int64 `json:"seq,omitempty"`
+	// Target is the file a followed symlink points to after a reopened event
+	Target string `json:"target,omitempty"`
+	// Binary is set when much of the line was not text, as on the lines of a page
+	Binary bool `json:"binary,omitempty"`
+}
+
+// Tailer follows a growing local file by name, like tail -F
+type Tailer struct {
+	filePath   string
+	file       *os.File
+	offset     int64
+	lineNumber int
+	partial    []byte
+	generation int
+	interval   time.Duration
+	// encoding is that of the followed file, sniffed once it has content, its lines are transcoded from it
+	encoding string
+}
+
+// NewTailer starts following filePath from its end, or from the start when fromStart is set.
+// A file that does not exist yet is followed from its start once it is created.
+func NewTailer(filePath string, fromStart bool) (*Tailer, error) {
+	t := &Tailer{
+		filePath:   filePath,
+		interval:   tailPollInterval,
+		generation: FileGeneration(filePath),
+	}
+	file, err := os.Open(filePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return t, nil
+		}
+		return nil, err
+	}
+	t.file = file
+	if fromStart {
+		return t, nil
+	}
+	linesCount, fileSize, err := FileStats(filePath, false, nil)
+	if err != nil && !isEmptyFileErr(err) {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(fileSize, io.SeekStart); err != nil {
+		file.Close()
+		return nil, err
+	}
+	t.offset = fileSize
+	t.lineNumber = linesCount
+	return t, nil
+}
+
+// Poll reads whatever was appended since the last poll.
+// A file shrinking below the current offset is a truncation (copytruncate rotation):
+// a truncated event is emitted and the file is followed again from its start.
+// When the name points to another file (renamed over, deleted and recreated, symlink retargeted)
+// the rest of the old file is read, then a reopened event is emitted and the new file is followed from its start.
+func (t *Tailer) Poll() ([]TailEvent, error) {
+	events := []TailEvent{}
+	if t.file == nil {
+		file, err := os.Open(t.filePath)
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return events, nil
+			}
+			return nil, err
+		}
+		t.file = file
+	}
+
+	fileInfo, err := t.file.Stat()
+	if err != nil {
+		return nil, err
+	}
+
+	nameInfo, err := os.Stat(t.filePath)
+	switch {
+	case err == nil && !os.SameFile(fileInfo, nameInfo):
+		// drain the old file before switching over, nothing written before the replace is lost
+		drained, err := t.read(fileInfo.Size())
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, drained...)
+		events = append(events, t.flushPartial()...)
+		if err := t.reopen(); err != nil {
+			return events, err
+		}
+		target, _ := symlinkTarget(t.filePath)
+		events = append(events, TailEvent{Type: TailEventReopened, Generation: t.generation, Target: target})
+		if fileInfo, err = t.file.Stat(); err != nil {
+			return events, err
+		}
+	case err != nil && !errors.Is(err, os.ErrNotExist):
+		return nil, err
+	}
+	// an unlinked file is still read through the open handle until the name shows up again
+
+	if fileInfo.Size() < t.offset {
+		t.generation = GlobalFileStatsCache.BumpGeneration(t.filePath)
+		t.offset = 0
+		t.lineNumber = 0
+		t.partial = nil
+		t.encoding = ""
+		if _, err := t.file.Seek(0, io.SeekStart); err != nil {
+			return nil, err
+		}
+		events = append(events, TailEvent{Type: TailEventTruncated, Generation: t.generation})
+	}
+
+	read, err := t.read(fileInfo.Size())
+	events = append(events, read...)
+	return events, err
+}
+
+// read reads from the current offset up to size
+func (t *Tailer) read(size int64) ([]TailEvent, error) {
+	events := []TailEvent{}
+	buffer := make([]byte, tailReadChunk)
+	for t.offset < size {
+		n, err := t.file.Read(buffer)
+		if n > 0 {
+			t.offset += int64(n)
+			events = append(events, t.split(buffer[:n])...)
+		}
+		if err != nil {
+			if errors.Is(err, io.EOF) {
+				break
+			}
+			return events, err
+		}
+	}
+	return events, nil
+}
+
+// reopen switches to the file currently under the name, starting a new generation
+func (t *Tailer) reopen() error {
+	t.file.Close()
+	t.file = nil
+	t.generation = GlobalFileStatsCache.BumpGeneration(t.filePath)
+	t.offset = 0
+	t.lineNumber = 0
+	t.partial = nil
+	t.encoding = ""
+	file, err := os.Open(t.filePath)
+	if err != nil {
+		return err
+	}
+	t.file = file
+	return nil
+}
+
+// flushPartial emits a last line without a trailing newline, the file it belongs to is done
+func (t *Tailer) flushPartial() []TailEvent {
+	if len(t.partial) == 0 {
+		return nil
+	}
+	return t.split([]byte{'\n'})
+}
+
+// Close releases the followed file
+func (t *Tailer) Close() error {
+	if t.file == nil {
+		return nil
+	}
+	err := t.file.Close()
+	t.file = nil
+	return err
+}
+
+// split emits complete lines, keeping a trailing partial line for the next poll
+func (t *Tailer) split(chunk []byte) []TailEvent {
+	events := []TailEvent{}
+	if t.encoding == "" && t.file != nil {
+		head := make([]byte, 512)
+		if n, _ := t.file.ReadAt(head, 0); n > 0 {
+			t.encoding = DetectEncoding(head[:n])
+		}
+	}
+	data := append(t.partial, chunk...) //nolint: gocritic
+	for {
+		i := bytes.IndexByte(data, '\n')
+		if i < 0 {
+			break
+		}
+		line := bytes.TrimSuffix(data[:i], []byte{'\r'})
+		if t.encoding == EncodingLatin1 || t.encoding == EncodingShiftJIS {
+			line = transcodeBytes(line, t.encoding)
+		}
+		t.lineNumber++
+		event := TailEvent{
+			Type:       TailEventLine,
+			LineNumber: t.lineNumber,
+			Content:    stripansi.Strip(string(line)),
+			Generation: t.generation,
+		}
+		SanitizeEvent(&event)
+		events = append(events, event)
+		data = data[i+1:]
+	}
+	t.partial = append([]byte(nil), data...)
+	return events
+}
+
+// Run polls until ctx is done, sending events on the channel
+func (t *Tailer) Run(ctx context.Context, events chan<- TailEvent) {
+	defer t.Close()
+	ticker := time.NewTicker(t.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			polled, err := t.Poll()
+			if err != nil {
+				slog.Warn("tailing file", "filePath", t.filePath, "error", err)
+				continue
+			}
+			for _, event := range polled {
+				select {
+				case events <- event:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}
+}
+
+func isEmptyFileErr(err error) bool {
+	return errors.Is(err, io.EOF)
+}
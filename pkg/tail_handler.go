@@ -0,0 +1,198 @@
+package pkg
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+
+	"github.com/labstack/echo/v4"
+)
+
+type TailRequest struct {
+	ID        string `json:"id" query:"id"`
+	FilePath  string `json:"file_path" query:"file_path" validate:"required" message:"file_path is required"`
+	Host      string `json:"host" query:"host"`
+	Type      string `json:"type" query:"type" validate:"required" message:"type is required"`
+	FromStart bool   `json:"from_start" query:"from_start"`
+	// Levels (comma separated, like error,warn) keep the lines of these levels
+	Levels string `json:"levels" query:"levels"`
+}
+
+// GetTail streams lines appended to a local file as server sent events.
+// A "sources" event is sent first, lines refer to its entries by index.
+// Truncations and replacements of the file are sent as "truncated" and "reopened" events,
+// after which lines are numbered from 1 again under the next generation. The seq of the lines
+// keeps increasing by one across them, lines are sent in the order of the file. A reload no longer
+// watching the file ends the tail with a "source_removed" event.
+func (h *APIHandler) GetTail(c echo.Context) error {
+	req := new(TailRequest)
+	if err := BindRequest(c, req); err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err)
+	}
+	if err := resolveFileID(req.ID, &req.FilePath, &req.Host, &req.Type); err != nil {
+		return err
+	}
+	msgs, err := ValidateRequest(req)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, msgs)
+	}
+	levels, err := ParseLevels(req.Levels)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, err.Error())
+	}
+	if err := AuthorizeFilePath(req.FilePath, req.Type, req.Host); err != nil {
+		return echo.NewHTTPError(http.StatusForbidden, err)
+	}
+	if req.Type == TypeRemoteGol {
+		release, err := acquireTail(c)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return h.tailRemote(c, req.Host, req.FilePath)
+	}
+	if req.Type == TypeInternal && GlobalLogBuffer != nil {
+		release, err := acquireTail(c)
+		if err != nil {
+			return err
+		}
+		defer release()
+		return h.tailInternal(c, req, levels)
+	}
+	if req.Type == TypeSSH || req.Type == TypeHTTP || req.Type == TypeS3 || (req.Type == TypeDocker && !strings.HasPrefix(req.FilePath, TmpContainerPath)) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "tailing is only supported for local files")
+	}
+
+	release, err := acquireTail(c)
+	if err != nil {
+		return err
+	}
+	defer release()
+
+	tailer, err := NewTailer(req.FilePath, req.FromStart)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusInternalServerError, err)
+	}
+
+	SetHeadersResponseSSE(c.Response().Header())
+	c.Response().WriteHeader(http.StatusOK)
+	sources := []LineSource{{
+		FilePath: req.FilePath,
+		Host:     req.Host,
+		Type:     req.Type,
+		Label:    fileLabel(req.FilePath, req.Type, req.Host),
+	}}
+	if err := WriteSSE(c.Response(), TailEventSources, sources); err != nil {
+		return nil
+	}
+
+	ctx := c.Request().Context()
+	classifier := ClassifierFor(req.FilePath)
+	stream := NewTailStream(c.Response())
+	events := make(chan TailEvent)
+	go tailer.Run(ctx, events)
+	reloaded, unsubscribeReloads := GlobalSourceReloads.Subscribe()
+	defer unsubscribeReloads()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-reloaded:
+			if AuthorizeFilePath(req.FilePath, req.Type, req.Host) != nil {
+				stream.Send(TailEvent{Type: TailEventSourceRemoved}) //nolint: errcheck
+				return nil
+			}
+		case event := <-events:
+			if event.Type == TailEventLine && !levelEvent(&event, classifier, levels) {
+				continue
+			}
+			if err := stream.Send(event); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// tailInternal streams the lines of gol's own log as they are logged, numbered from the first buffered line
+func (h *APIHandler) tailInternal(c echo.Context, req *TailRequest, levels map[string]bool) error {
+	buffered, lines, unsubscribe := GlobalLogBuffer.Subscribe()
+	defer unsubscribe()
+
+	SetHeadersResponseSSE(c.Response().Header())
+	c.Response().WriteHeader(http.StatusOK)
+	sources := []LineSource{{FilePath: req.FilePath, Type: TypeInternal, Label: InternalLogName}}
+	if err := WriteSSE(c.Response(), TailEventSources, sources); err != nil {
+		return nil
+	}
+
+	classifier := ClassifierFor(req.FilePath)
+	stream := NewTailStream(c.Response())
+	lineNumber := 0
+	send := func(content string) error {
+		lineNumber++
+		event := TailEvent{Type: TailEventLine, LineNumber: lineNumber, Content: content}
+		if !levelEvent(&event, classifier, levels) {
+			return nil
+		}
+		return stream.Send(event)
+	}
+	if !req.FromStart {
+		lineNumber = len(buffered)
+		buffered = nil
+	}
+	for _, content := range buffered {
+		if err := send(content); err != nil {
+			return nil
+		}
+	}
+	ctx := c.Request().Context()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case content := <-lines:
+			if err := send(content); err != nil {
+				return nil
+			}
+		}
+	}
+}
+
+// TailStream sends the events of one tail connection, one at a time. Every line is given the next
+// seq of the stream, so that a client can tell a lost or reordered line from a rotation, which
+// changes the generation of the lines instead.
+type TailStream struct {
+	mutex sync.Mutex
+	res   *echo.Response
+	seq   int64
+}
+
+func NewTailStream(res *echo.Response) *TailStream {
+	return &TailStream{res: res}
+}
+
+// Send writes the event, numbering it when it is a line
+func (s *TailStream) Send(event TailEvent) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if event.Type == TailEventLine {
+		s.seq++
+		event.Seq = s.seq
+	}
+	return WriteSSE(s.res, event.Type, event)
+}
+
+// WriteSSE writes one server sent event and flushes it to the client
+func WriteSSE(res *echo.Response, event string, data interface{}) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", event, b); err != nil {
+		return err
+	}
+	res.Flush()
+	return nil
+}
@@ -0,0 +1,136 @@
+package pkg
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// tailHubSubscriberBuffer is the number of events a slow subscriber may lag behind before it is dropped
+const tailHubSubscriberBuffer = 4096
+
+// TailHub shares one Tailer per file among the streams following it. The tailer starts with the first
+// subscriber and stops with the last. A subscriber lagging too far behind is dropped and its channel
+// closed, rather than slowing down the others or losing lines unnoticed.
+type TailHub struct {
+	mutex    sync.Mutex
+	tails    map[string]*hubTail
+	interval time.Duration
+	running  atomic.Int64
+}
+
+type hubTail struct {
+	subscribers map[chan TailEvent]struct{}
+	cancel      context.CancelFunc
+}
+
+// NewTailHub returns a hub whose tailers poll their file every interval
+func NewTailHub(interval time.Duration) *TailHub {
+	return &TailHub{
+		tails:    map[string]*hubTail{},
+		interval: interval,
+	}
+}
+
+// Subscribe returns a channel receiving the events of filePath from its current end, until the returned
+// func is called
+func (h *TailHub) Subscribe(filePath string) (<-chan TailEvent, func(), error) {
+	for {
+		h.mutex.Lock()
+		if tail, ok := h.tails[filePath]; ok {
+			defer h.mutex.Unlock()
+			return h.subscribe(filePath, tail)
+		}
+		h.mutex.Unlock()
+
+		// the tailer counts the lines of the file, which is not done under the lock
+		tailer, err := NewTailer(filePath, false)
+		if err != nil {
+			return nil, nil, err
+		}
+		tailer.interval = h.interval
+		h.mutex.Lock()
+		if _, ok := h.tails[filePath]; ok {
+			// another stream started following the file meanwhile
+			h.mutex.Unlock()
+			tailer.Close()
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		tail := &hubTail{subscribers: map[chan TailEvent]struct{}{}, cancel: cancel}
+		h.tails[filePath] = tail
+		h.running.Add(1)
+		go h.run(ctx, tail, tailer)
+		defer h.mutex.Unlock()
+		return h.subscribe(filePath, tail)
+	}
+}
+
+// subscribe adds a subscriber to the tail, under the lock
+func (h *TailHub) subscribe(filePath string, tail *hubTail) (<-chan TailEvent, func(), error) {
+	subscriber := make(chan TailEvent, tailHubSubscriberBuffer)
+	tail.subscribers[subscriber] = struct{}{}
+	unsubscribe := sync.OnceFunc(func() {
+		h.mutex.Lock()
+		defer h.mutex.Unlock()
+		delete(tail.subscribers, subscriber)
+		h.stopUnused(filePath, tail)
+	})
+	return subscriber, unsubscribe, nil
+}
+
+// stopUnused stops the tailer of a tail without subscribers, under the lock
+func (h *TailHub) stopUnused(filePath string, tail *hubTail) {
+	if len(tail.subscribers) > 0 || h.tails[filePath] != tail {
+		return
+	}
+	delete(h.tails, filePath)
+	tail.cancel()
+}
+
+// run fans the events of the tailer out to the subscribers until the tail is stopped
+func (h *TailHub) run(ctx context.Context, tail *hubTail, tailer *Tailer) {
+	defer h.running.Add(-1)
+	events := make(chan TailEvent)
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		tailer.Run(ctx, events)
+	}()
+	for {
+		select {
+		case <-ctx.Done():
+			<-stopped
+			return
+		case event := <-events:
+			h.publish(tailer.filePath, tail, event)
+		}
+	}
+}
+
+func (h *TailHub) publish(filePath string, tail *hubTail, event TailEvent) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	for subscriber := range tail.subscribers {
+		select {
+		case subscriber <- event:
+		default:
+			delete(tail.subscribers, subscriber)
+			close(subscriber)
+		}
+	}
+	h.stopUnused(filePath, tail)
+}
+
+// Len is the number of files followed
+func (h *TailHub) Len() int {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+	return len(h.tails)
+}
+
+// Running is the number of tailers still polling, a stopped tail is no longer running once its last poll is done
+func (h *TailHub) Running() int {
+	return int(h.running.Load())
+}
@@ -0,0 +1,250 @@
+package pkg
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTailer_Poll(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte("line 1\nline 2\n"), 0600))
+
+	tailer, err := NewTailer(logFile, false)
+	assert.NoError(t, err)
+	defer tailer.Close()
+
+	appendTo := func(content string) {
+		f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0600)
+		assert.NoError(t, err)
+		_, err = f.WriteString(content)
+		assert.NoError(t, err)
+		f.Close()
+	}
+
+	// a partial line is held back until it is complete
+	appendTo("line 3\nline ")
+	events, err := tailer.Poll()
+	assert.NoError(t, err)
+	assert.Equal(t, []TailEvent{{Type: TailEventLine, LineNumber: 3, Content: "line 3"}}, events)
+	appendTo("4\n")
+	events, err = tailer.Poll()
+	assert.NoError(t, err)
+	assert.Equal(t, []TailEvent{{Type: TailEventLine, LineNumber: 4, Content: "line 4"}}, events)
+}
+
+func TestTailer_Truncate(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte{}, 0600))
+
+	tailer, err := NewTailer(logFile, true)
+	assert.NoError(t, err)
+	defer tailer.Close()
+
+	received := []string{}
+	poll := func() []TailEvent {
+		events, err := tailer.Poll()
+		assert.NoError(t, err)
+		for _, event := range events {
+			if event.Type == TailEventLine {
+				received = append(received, event.Content)
+			}
+		}
+		return events
+	}
+
+	written := []string{}
+	write := func(flag int, from, to int) {
+		f, err := os.OpenFile(logFile, flag|os.O_WRONLY, 0600)
+		assert.NoError(t, err)
+		for i := from; i <= to; i++ {
+			line := fmt.Sprintf("line %d", i)
+			written = append(written, line)
+			_, err = f.WriteString(line + "\n")
+			assert.NoError(t, err)
+		}
+		f.Close()
+	}
+
+	write(os.O_APPEND, 1, 5)
+	poll()
+
+	// copytruncate: the file shrinks to zero and fresh content follows
+	write(os.O_TRUNC, 6, 7)
+	events := poll()
+	assert.Equal(t, TailEventTruncated, events[0].Type)
+	assert.Equal(t, 1, events[1].LineNumber)
+	assert.Greater(t, events[1].Generation, 0)
+	assert.Equal(t, events[0].Generation, events[1].Generation)
+
+	write(os.O_APPEND, 8, 9)
+	poll()
+
+	// no duplicated or lost lines around the truncation
+	assert.Equal(t, written, received)
+}
+
+func TestTailer_Reopen(t *testing.T) {
+	type TestCase struct {
+		Name    string
+		Replace func(t *testing.T, dir string, logFile string, content string)
+	}
+
+	testCases := []TestCase{
+		{
+			Name: "rename over",
+			Replace: func(t *testing.T, _ string, logFile string, content string) {
+				assert.NoError(t, os.WriteFile(logFile+".tmp", []byte(content), 0600))
+				assert.NoError(t, os.Rename(logFile+".tmp", logFile))
+			},
+		},
+		{
+			Name: "delete then recreate",
+			Replace: func(t *testing.T, _ string, logFile string, content string) {
+				assert.NoError(t, os.Remove(logFile))
+				assert.NoError(t, os.WriteFile(logFile, []byte(content), 0600))
+			},
+		},
+		{
+			Name: "symlink retarget",
+			Replace: func(t *testing.T, dir string, logFile string, content string) {
+				target := filepath.Join(dir, "app-2.log")
+				assert.NoError(t, os.WriteFile(target, []byte(content), 0600))
+				assert.NoError(t, os.Symlink(target, logFile+".tmp"))
+				assert.NoError(t, os.Rename(logFile+".tmp", logFile))
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			dir := t.TempDir()
+			logFile := filepath.Join(dir, "app.log")
+			target := filepath.Join(dir, "app-1.log")
+			assert.NoError(t, os.WriteFile(target, []byte("old 1\n"), 0600))
+			assert.NoError(t, os.Symlink(target, logFile))
+
+			tailer, err := NewTailer(logFile, true)
+			assert.NoError(t, err)
+			defer tailer.Close()
+
+			events, err := tailer.Poll()
+			assert.NoError(t, err)
+			assert.Equal(t, []TailEvent{{Type: TailEventLine, LineNumber: 1, Content: "old 1"}}, events)
+
+			// written to the old file just before it is replaced, it must not be lost
+			f, err := os.OpenFile(target, os.O_APPEND|os.O_WRONLY, 0600)
+			assert.NoError(t, err)
+			_, err = f.WriteString("old 2\nold 3")
+			assert.NoError(t, err)
+			f.Close()
+
+			tc.Replace(t, dir, logFile, "new 1\nnew 2\n")
+
+			events, err = tailer.Poll()
+			assert.NoError(t, err)
+			assert.Len(t, events, 5)
+			assert.Equal(t, TailEvent{Type: TailEventLine, LineNumber: 2, Content: "old 2"}, events[0])
+			assert.Equal(t, TailEvent{Type: TailEventLine, LineNumber: 3, Content: "old 3"}, events[1])
+			assert.Equal(t, TailEventReopened, events[2].Type)
+			if tc.Name == "symlink retarget" {
+				want, err := filepath.EvalSymlinks(filepath.Join(dir, "app-2.log"))
+				assert.NoError(t, err)
+				assert.Equal(t, want, events[2].Target)
+			}
+			generation := events[2].Generation
+			assert.Equal(t, TailEvent{Type: TailEventLine, LineNumber: 1, Content: "new 1", Generation: generation}, events[3])
+			assert.Equal(t, TailEvent{Type: TailEventLine, LineNumber: 2, Content: "new 2", Generation: generation}, events[4])
+			assert.Equal(t, generation, FileGeneration(logFile))
+
+			events, err = tailer.Poll()
+			assert.NoError(t, err)
+			assert.Empty(t, events)
+		})
+	}
+}
+
+func TestAPIHandler_GetTail_Sequence(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte("before\n"), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: logFile, Type: TypeFile}})
+	server := httptest.NewServer(newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff}))
+	defer server.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, server.URL+"/api/tail?type=file&file_path="+logFile, nil)
+	assert.NoError(t, err)
+	res, err := http.DefaultClient.Do(req)
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer res.Body.Close()
+	scanner := bufio.NewScanner(res.Body)
+	next := func() (string, TailEvent) {
+		name, event := "", TailEvent{}
+		for scanner.Scan() {
+			line := scanner.Text()
+			switch {
+			case strings.HasPrefix(line, "event: "):
+				name = strings.TrimPrefix(line, "event: ")
+			case strings.HasPrefix(line, "data: ") && name != TailEventSources:
+				assert.NoError(t, json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &event))
+			case line == "":
+				return name, event
+			}
+		}
+		return "", event
+	}
+	name, _ := next()
+	assert.Equal(t, TailEventSources, name)
+
+	// a burst of writes, lines split across writes and polls
+	const lines = 5000
+	go func() {
+		f, err := os.OpenFile(logFile, os.O_APPEND|os.O_WRONLY, 0600)
+		if err != nil {
+			return
+		}
+		defer f.Close()
+		content := ""
+		for i := 1; i <= lines; i++ {
+			content += fmt.Sprintf("line %d\n", i)
+		}
+		for len(content) > 0 {
+			n := min(len(content), 777)
+			f.WriteString(content[:n]) //nolint: errcheck
+			content = content[n:]
+		}
+	}()
+
+	received := []TailEvent{}
+	for len(received) < lines {
+		name, event := next()
+		if name == "" {
+			break
+		}
+		if name != TailEventLine {
+			continue
+		}
+		received = append(received, event)
+		// in order, without gaps, in the generation the stream started with
+		i := len(received)
+		if event.Seq != int64(i) || event.LineNumber != i+1 || event.Content != fmt.Sprintf("line %d", i) || event.Generation != received[0].Generation {
+			t.Fatalf("line %d received as %+v", i, event)
+		}
+	}
+	assert.Len(t, received, lines)
+}
@@ -0,0 +1,353 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	// lines read from the start of a segment looking for its first timestamp
+	timeRangeProbeLines = 100
+	// bytes read from the end of a plain segment looking for its last timestamp
+	timeRangeProbeTail = 64 * 1024
+)
+
+// SegmentTimeRange is the span of time a physical file covers, from its first to its last timestamp.
+// Undated is set when no timestamp could be parsed, such segments are never skipped.
+type SegmentTimeRange struct {
+	FilePath string    `json:"file_path"`
+	First    time.Time `json:"first"`
+	Last     time.Time `json:"last"`
+	Undated  bool      `json:"undated"`
+}
+
+// Overlaps tells whether the segment may hold lines between from and to, a zero bound is open
+func (r SegmentTimeRange) Overlaps(from, to time.Time) bool {
+	if r.Undated {
+		return true
+	}
+	if !from.IsZero() && r.Last.Before(from) {
+		return false
+	}
+	if !to.IsZero() && r.First.After(to) {
+		return false
+	}
+	return true
+}
+
+// TimeRangeResolution is the result of routing a time range to the segments of a logical log
+type TimeRangeResolution struct {
+	From time.Time `json:"from"`
+	To   time.Time `json:"to"`
+	// Consulted are the segments that were scanned, oldest first
+	Consulted []SegmentTimeRange `json:"consulted"`
+	// Undated is set when a consulted segment was included only because its range is unknown
+	Undated bool `json:"undated"`
+	// Warnings are the segments skipped because their range could not be read, reported by the reads
+	Warnings []SegmentWarning `json:"-"`
+}
+
+// FilePaths are the physical files to scan
+func (r *TimeRangeResolution) FilePaths() []string {
+	filePaths := make([]string, 0, len(r.Consulted))
+	for _, segment := range r.Consulted {
+		filePaths = append(filePaths, segment.FilePath)
+	}
+	return filePaths
+}
+
+type segmentTimeRangeEntry struct {
+	size    int64
+	modTime int64
+	span    SegmentTimeRange
+}
+
+// SegmentTimeRanges caches the probed time ranges of files, until their size or mtime changes
+type SegmentTimeRanges struct {
+	mutex   sync.Mutex
+	entries map[string]segmentTimeRangeEntry
+}
+
+func NewSegmentTimeRanges() *SegmentTimeRanges {
+	return &SegmentTimeRanges{
+		entries: make(map[string]segmentTimeRangeEntry),
+	}
+}
+
+// Get returns the time range of filePath, probing the file when it is not cached or changed
+func (s *SegmentTimeRanges) Get(filePath string) (SegmentTimeRange, error) {
+	fileInfo, err := os.Stat(filePath)
+	if err != nil {
+		return SegmentTimeRange{}, err
+	}
+	s.mutex.Lock()
+	entry, ok := s.entries[filePath]
+	s.mutex.Unlock()
+	if ok && entry.size == fileInfo.Size() && entry.modTime == fileInfo.ModTime().UnixNano() {
+		return entry.span, nil
+	}
+
+	span, err := ProbeTimeRange(filePath)
+	if err != nil {
+		return SegmentTimeRange{}, err
+	}
+	s.mutex.Lock()
+	s.entries[filePath] = segmentTimeRangeEntry{size: fileInfo.Size(), modTime: fileInfo.ModTime().UnixNano(), span: span}
+	s.mutex.Unlock()
+	return span, nil
+}
+
+// Resolve picks the segments, given oldest first, that may hold lines between from and to.
+// Segments that cannot be read are skipped with a warning, unless there is only one.
+func (s *SegmentTimeRanges) Resolve(filePaths []string, from, to time.Time) (*TimeRangeResolution, error) {
+	resolution := &TimeRangeResolution{From: from, To: to, Consulted: []SegmentTimeRange{}}
+	for _, filePath := range filePaths {
+		span, err := s.Get(filePath)
+		if err != nil && len(filePaths) == 1 {
+			return nil, err
+		}
+		if err != nil {
+			resolution.Warnings = append(resolution.Warnings, SegmentWarning{FilePath: filePath, Error: err.Error()})
+			continue
+		}
+		if !span.Overlaps(from, to) {
+			continue
+		}
+		resolution.Consulted = append(resolution.Consulted, span)
+		if span.Undated {
+			resolution.Undated = true
+		}
+	}
+	return resolution, nil
+}
+
+// ProbeTimeRange reads the first timestamp near the start of a file and the last one near its end.
+// Only the tail of plain files is read, compressed files have to be read through.
+func ProbeTimeRange(filePath string) (SegmentTimeRange, error) {
+	span := SegmentTimeRange{FilePath: filePath}
+	file, err := os.Open(filePath)
+	if err != nil {
+		return span, err
+	}
+	defer file.Close()
+
+	buffer := make([]byte, 512)
+	n, err := file.ReadAt(buffer, 0)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return span, err
+	}
+	compressed := IsCompressed(buffer[:n])
+
+	reader, err := timeRangeReader(file, compressed)
+	if err != nil {
+		return span, err
+	}
+	scanner := newLineScanner(reader)
+	read := 0
+	for scanner.Scan() && read < timeRangeProbeLines {
+		read++
+		if ts, ok := extractTime(scanner.Text()); ok {
+			span.First = ts
+			break
+		}
+	}
+	if span.First.IsZero() {
+		span.Undated = true
+		return span, scanner.Err()
+	}
+
+	if !compressed {
+		fileInfo, err := file.Stat()
+		if err != nil {
+			return span, err
+		}
+		offset := fileInfo.Size() - timeRangeProbeTail
+		if offset < 0 {
+			offset = 0
+		}
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return span, err
+		}
+		reader = utf8BufferedReader(file)
+		scanner = newLineScanner(reader)
+	}
+	span.Last = span.First
+	for scanner.Scan() {
+		if ts, ok := extractTime(scanner.Text()); ok {
+			span.Last = ts
+		}
+	}
+	return span, scanner.Err()
+}
+
+func timeRangeReader(file File, compressed bool) (io.Reader, error) {
+	if !compressed {
+		return utf8BufferedReader(file), nil
+	}
+	reader, _, err := NewDecompressingReader(file)
+	if err != nil {
+		return nil, err
+	}
+	return utf8BufferedReader(reader), nil
+}
+
+// timeSeekSpan is the span of a file below which the search for the start of a time range reads on
+const timeSeekSpan = 64 * 1024
+
+// TimeRange keeps the lines of a scan from From to To, a zero bound is open. Times without an offset are
+// in Location. A line without a timestamp is in the range when the dated line before it is, as it likely
+// continues its entry.
+type TimeRange struct {
+	From     time.Time
+	To       time.Time
+	Location *time.Location
+}
+
+// SetTimeRange makes the following scans keep only the lines of timeRange
+func (w *Watcher) SetTimeRange(timeRange TimeRange) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.timeRange = &timeRange
+}
+
+// scanStart is where a scan starts in its file, after skipped lines. timeRange is nil without a time range.
+type scanStart struct {
+	skipped   int
+	timeRange *timeRangeScan
+}
+
+// timeRangeScan is a scan through a time range, in a sorted file the first line after it ends the scan
+type timeRangeScan struct {
+	TimeRange
+	sorted bool
+	in     bool
+}
+
+// scan starts a scan through the range, nil without one
+func (r *TimeRange) scan(sorted bool) *timeRangeScan {
+	if r == nil {
+		return nil
+	}
+	return &timeRangeScan{TimeRange: *r, sorted: sorted, in: r.From.IsZero()}
+}
+
+// line tells whether line is in the time range and whether the scan is done
+func (s *timeRangeScan) line(line []byte) (bool, bool) {
+	ts, ok := LineTime(line, s.Location)
+	if !ok {
+		return s.in, false
+	}
+	if !s.To.IsZero() && ts.After(s.To) {
+		s.in = false
+		return false, s.sorted
+	}
+	s.in = s.From.IsZero() || !ts.Before(s.From)
+	return s.in, false
+}
+
+// openScannerAt opens a scanner over filePath at the start of the time range of the watcher. Local plain
+// files are taken as sorted and binary searched for it, others are read through from their start.
+func (w *Watcher) openScannerAt(filePath string) (io.ReadCloser, *bufio.Scanner, scanStart, error) {
+	if w.timeRange == nil || w.isRemote || filePath == InternalLogPath {
+		file, scanner, err := w.openScanner(filePath)
+		return file, scanner, scanStart{timeRange: w.timeRange.scan(false)}, err
+	}
+
+	file, err := openFile(OSFileOpener{}, filePath)
+	if err != nil {
+		return nil, nil, scanStart{}, err
+	}
+	head := make([]byte, 512)
+	n, _ := file.ReadAt(head, 0)
+	if IsCompressed(head[:n]) || DetectEncoding(head[:n]) != EncodingUTF8 {
+		file.Close()
+		file, scanner, err := w.openScanner(filePath)
+		return file, scanner, scanStart{timeRange: w.timeRange.scan(false)}, err
+	}
+	start := scanStart{timeRange: w.timeRange.scan(true)}
+	if !w.timeRange.From.IsZero() {
+		info, err := file.Stat()
+		if err != nil {
+			file.Close()
+			return nil, nil, scanStart{}, err
+		}
+		offset, err := seekTime(file, info.Size(), w.timeRange.From, w.timeRange.Location)
+		if err == nil {
+			start.skipped, err = countLines(file, offset)
+		}
+		if err == nil {
+			_, err = file.Seek(offset, io.SeekStart)
+		}
+		if err != nil {
+			file.Close()
+			return nil, nil, scanStart{}, err
+		}
+	}
+	return file, newLineScanner(file), start, nil
+}
+
+// seekTime binary searches a sorted file for the offset of a line dated before t, after which its lines
+// dated t or later are, 0 when there is none. Spans without a dated line are searched in their first half.
+func seekTime(file io.ReaderAt, size int64, t time.Time, loc *time.Location) (int64, error) {
+	low, high := int64(0), size
+	for high-low > timeSeekSpan {
+		mid := low + (high-low)/2
+		offset, ts, ok, err := nextDatedLine(file, mid, high, loc)
+		if err != nil {
+			return 0, err
+		}
+		if ok && ts.Before(t) {
+			low = offset
+		} else {
+			high = mid
+		}
+	}
+	return low, nil
+}
+
+// nextDatedLine finds the first line starting from offset on, before limit, with a timestamp. Only
+// timeSeekSpan bytes are read.
+func nextDatedLine(file io.ReaderAt, offset, limit int64, loc *time.Location) (int64, time.Time, bool, error) {
+	// the byte before offset tells whether a line starts at it
+	buffer := make([]byte, timeSeekSpan+1)
+	n, err := file.ReadAt(buffer, offset-1)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return 0, time.Time{}, false, err
+	}
+	// chunk starts at the byte at position
+	chunk, position := buffer[:n], offset-1
+	for newline := bytes.IndexByte(chunk, '\n'); newline >= 0; newline = bytes.IndexByte(chunk, '\n') {
+		chunk, position = chunk[newline+1:], position+int64(newline)+1
+		end := bytes.IndexByte(chunk, '\n')
+		if position >= limit || end < 0 {
+			break
+		}
+		if ts, ok := LineTime(chunk[:end], loc); ok {
+			return position, ts, true, nil
+		}
+	}
+	return 0, time.Time{}, false, nil
+}
+
+// countLines counts the lines before offset
+func countLines(file io.ReaderAt, offset int64) (int, error) {
+	buffer := make([]byte, 64*1024)
+	lines := 0
+	for read := int64(0); read < offset; {
+		n, err := file.ReadAt(buffer[:min(int64(len(buffer)), offset-read)], read)
+		lines += bytes.Count(buffer[:n], []byte{'\n'})
+		read += int64(n)
+		if err != nil && !errors.Is(err, io.EOF) {
+			return 0, err
+		}
+		if n == 0 {
+			break
+		}
+	}
+	return lines, nil
+}
@@ -0,0 +1,294 @@
+package pkg
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSegmentTimeRanges_Resolve(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+
+	gzFile, err := os.Create(logFile + ".2.gz")
+	assert.NoError(t, err)
+	gz := gzip.NewWriter(gzFile)
+	_, err = gz.Write([]byte("2024-01-01T10:00:00Z INFO oldest\n2024-01-01T11:00:00Z INFO oldest end\n"))
+	assert.NoError(t, err)
+	assert.NoError(t, gz.Close())
+	assert.NoError(t, gzFile.Close())
+
+	assert.NoError(t, os.WriteFile(logFile+".1", []byte("2024-01-02T10:00:00Z INFO older\n2024-01-02T11:00:00Z INFO older end\n"), 0600))
+	assert.NoError(t, os.WriteFile(logFile, []byte("no timestamps here\nnor here\n"), 0600))
+	segments := []string{logFile + ".2.gz", logFile + ".1", logFile}
+
+	type TestCase struct {
+		Name        string
+		From        time.Time
+		To          time.Time
+		WantFiles   []string
+		WantUndated bool
+	}
+
+	testCases := []TestCase{
+		{
+			Name:        "in the gzipped segment",
+			From:        time.Date(2024, 1, 1, 10, 15, 0, 0, time.UTC),
+			To:          time.Date(2024, 1, 1, 10, 45, 0, 0, time.UTC),
+			WantFiles:   []string{logFile + ".2.gz", logFile},
+			WantUndated: true,
+		},
+		{
+			Name:        "from only",
+			From:        time.Date(2024, 1, 2, 10, 30, 0, 0, time.UTC),
+			WantFiles:   []string{logFile + ".1", logFile},
+			WantUndated: true,
+		},
+	}
+
+	ranges := NewSegmentTimeRanges()
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			resolution, err := ranges.Resolve(segments, tc.From, tc.To)
+			assert.NoError(t, err)
+			assert.Equal(t, tc.WantFiles, resolution.FilePaths())
+			assert.Equal(t, tc.WantUndated, resolution.Undated)
+		})
+	}
+}
+
+func TestProbeTimeRange(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	content := "2024-01-02T10:00:00Z INFO first\ncontinued without a timestamp\n2024-01-02T12:30:00Z INFO last\n"
+	assert.NoError(t, os.WriteFile(logFile, []byte(content), 0600))
+
+	span, err := ProbeTimeRange(logFile)
+	assert.NoError(t, err)
+	assert.False(t, span.Undated)
+	assert.True(t, span.First.Equal(time.Date(2024, 1, 2, 10, 0, 0, 0, time.UTC)))
+	assert.True(t, span.Last.Equal(time.Date(2024, 1, 2, 12, 30, 0, 0, time.UTC)))
+}
+
+// writeSortedLog writes a log of a line a second from 2024-01-02T00:00:00Z, with an undated
+// continuation after every tenth line, and returns the time of its lines by line number
+func writeSortedLog(t *testing.T, filePath string, lines int) map[int]time.Time {
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	times := map[int]time.Time{}
+	var b strings.Builder
+	lineNumber := 0
+	for i := 0; i < lines; i++ {
+		ts := start.Add(time.Duration(i) * time.Second)
+		lineNumber++
+		times[lineNumber] = ts
+		fmt.Fprintf(&b, "%s INFO request %d handled\n", ts.Format(time.RFC3339), i)
+		if i%10 == 0 {
+			lineNumber++
+			b.WriteString("\tcontinued without a timestamp\n")
+		}
+	}
+	assert.NoError(t, os.WriteFile(filePath, []byte(b.String()), 0600))
+	return times
+}
+
+func TestSeekTime(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	writeSortedLog(t, logFile, 50000)
+	content, err := os.ReadFile(logFile)
+	assert.NoError(t, err)
+	file, err := os.Open(logFile)
+	assert.NoError(t, err)
+	defer file.Close()
+
+	start := time.Date(2024, 1, 2, 0, 0, 0, 0, time.UTC)
+	for _, at := range []time.Duration{0, time.Second, time.Hour, 7 * time.Hour, 13*time.Hour + 53*time.Minute, 24 * time.Hour} {
+		target := start.Add(at)
+		reader := &countingReaderAt{r: bytes.NewReader(content)}
+		offset, err := seekTime(reader, int64(len(content)), target, time.UTC)
+		assert.NoError(t, err)
+		// only a few spans are read on the way
+		assert.Less(t, reader.read, 20*int64(timeSeekSpan), at)
+
+		// the lines before the offset are all before the target, the search stops within a span of it
+		_, err = file.Seek(offset, io.SeekStart)
+		assert.NoError(t, err)
+		scanner := newLineScanner(file)
+		read := int64(0)
+		for scanner.Scan() {
+			if ts, ok := LineTime(scanner.Bytes(), time.UTC); ok && !ts.Before(target) {
+				break
+			}
+			read += int64(len(scanner.Bytes())) + 1
+		}
+		assert.LessOrEqual(t, read, int64(2*timeSeekSpan), at)
+		if offset > 0 {
+			ts, ok, err := lineTimeAt(file, offset)
+			assert.NoError(t, err)
+			assert.True(t, ok)
+			assert.True(t, ts.Before(target), at)
+		}
+	}
+}
+
+// lineTimeAt is the time of the line at offset
+func lineTimeAt(file *os.File, offset int64) (time.Time, bool, error) {
+	buffer := make([]byte, 256)
+	n, err := file.ReadAt(buffer, offset)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return time.Time{}, false, err
+	}
+	ts, ok := LineTime(buffer[:n], time.UTC)
+	return ts, ok, nil
+}
+
+func TestWatcher_ScanTimeRange(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "app.log")
+	times := writeSortedLog(t, logFile, 30000)
+	// the same log gzipped, which is read through instead of searched
+	content, err := os.ReadFile(logFile)
+	assert.NoError(t, err)
+	gzFile, err := os.Create(filepath.Join(dir, "app.log.1.gz"))
+	assert.NoError(t, err)
+	gz := gzip.NewWriter(gzFile)
+	_, err = gz.Write(content)
+	assert.NoError(t, err)
+	assert.NoError(t, gz.Close())
+	assert.NoError(t, gzFile.Close())
+
+	from := time.Date(2024, 1, 2, 4, 0, 0, 0, time.UTC)
+	to := from.Add(30 * time.Second)
+	// the dated lines of the range and the continuations after them
+	want := []int{}
+	in := false
+	for lineNumber := 1; lineNumber <= bytes.Count(content, []byte{'\n'}); lineNumber++ {
+		if ts, dated := times[lineNumber]; dated {
+			in = !ts.Before(from) && !ts.After(to)
+		}
+		if in {
+			want = append(want, lineNumber)
+		}
+	}
+	assert.Len(t, want, 31+4)
+
+	for _, filePath := range []string{logFile, gzFile.Name()} {
+		watcher, err := NewWatcher(filePath, "", "", false, "", "", "", "", "")
+		assert.NoError(t, err)
+		watcher.SetTimeRange(TimeRange{From: from, To: to, Location: time.UTC})
+		result, err := watcher.ScanContext(context.Background(), 1, 100, false)
+		assert.NoError(t, err)
+		assert.Equal(t, len(want), result.Total, filePath)
+		assert.Equal(t, want, lineNumbersOf(*result), filePath)
+		assert.Equal(t, "\tcontinued without a timestamp", result.Lines[1].Content)
+	}
+
+	// the anchors of a searched file are those of a full scan
+	watcher, err := NewWatcher(logFile, "", "", false, "", "", "", "", "")
+	assert.NoError(t, err)
+	all, err := watcher.ScanContext(context.Background(), want[0], 1, false)
+	assert.NoError(t, err)
+	watcher.SetTimeRange(TimeRange{From: from, To: to, Location: time.UTC})
+	ranged, err := watcher.ScanContext(context.Background(), 1, 1, false)
+	assert.NoError(t, err)
+	assert.Equal(t, all.Lines[0].Anchor, ranged.Lines[0].Anchor)
+}
+
+func TestAPIHandler_GetTimeRange(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	content := strings.Join([]string{
+		"2024-01-02T05:01:00Z INFO before",
+		"2024-01-02T05:02:00Z ERROR failed",
+		"\tat com.example.Client.send(Client.java:42)",
+		"2024-01-02T05:05:00Z INFO retried",
+		"2024-01-02T05:08:00Z INFO after",
+	}, "\n") + "\n"
+	assert.NoError(t, os.WriteFile(logFile, []byte(content), 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: logFile, Type: TypeFile}})
+	e := newTestEcho(&EchoOptions{BaseURL: "/", Compression: CompressionOff})
+	get := func(query string) (*httptest.ResponseRecorder, ScanResult) {
+		rec := httptest.NewRecorder()
+		e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api?type=file&file_path="+logFile+query, nil))
+		res := APIResponse{}
+		if rec.Code == http.StatusOK {
+			assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &res))
+		}
+		return rec, res.Result
+	}
+
+	rec, result := get("&from=2024-01-02T05:02:00Z&to=2024-01-02T05:07:00Z")
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, []int{2, 3, 4}, lineNumbersOf(result))
+	assert.Empty(t, result.NextCursor)
+	// times without an offset are in tz, 14:02 in Tokyo being 05:02 UTC
+	_, result = get("&from=2024-01-02T14:02:00&to=2024-01-02T14:07:00&tz=Asia/Tokyo")
+	assert.Equal(t, []int{2, 3, 4}, lineNumbersOf(result))
+	_, result = get("&from=" + url.QueryEscape("2024-01-02 14:05") + "&tz=Asia/Tokyo&query=INFO")
+	assert.Equal(t, []int{4, 5}, lineNumbersOf(result))
+
+	// a path default timezone applies unless tz is passed
+	defer GlobalPathDefaults.Set(nil)
+	GlobalPathDefaults.Set([]PathConfig{{Pattern: logFile, Defaults: &ViewDefaults{Timezone: "Asia/Tokyo"}}})
+	_, result = get("&to=2024-01-02T14:01:30")
+	assert.Equal(t, []int{1}, lineNumbersOf(result))
+	_, result = get("&to=2024-01-02T14:01:30&tz=UTC")
+	assert.Equal(t, []int{1, 2, 3, 4, 5}, lineNumbersOf(result))
+
+	for _, query := range []string{"&tz=Mars/Olympus&from=2024-01-02T14:02:00", "&from=yesterday", "&from=2024-01-02T14:02:00&tail=2"} {
+		rec, _ = get(query)
+		assert.Equal(t, http.StatusUnprocessableEntity, rec.Code, query)
+	}
+}
+
+func TestAPIHandler_GetTimeRange_UnreadableSegment(t *testing.T) {
+	defer func(ranges *SegmentTimeRanges) { GlobalSegmentTimeRanges = ranges }(GlobalSegmentTimeRanges)
+	GlobalSegmentTimeRanges = NewSegmentTimeRanges()
+	defer GlobalFileRegistry.Replace(GlobalFileRegistry.Snapshot())
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	content := []byte("2024-01-02T05:01:00Z INFO before\n2024-01-02T05:02:00Z ERROR failed\n")
+	assert.NoError(t, os.WriteFile(logFile, content, 0600))
+	GlobalFileRegistry.Replace([]FileInfo{{FilePath: logFile, Type: TypeFile}})
+	stat, err := os.Stat(logFile)
+	assert.NoError(t, err)
+
+	get := func() (*httptest.ResponseRecorder, error) {
+		rec := httptest.NewRecorder()
+		req := httptest.NewRequest(http.MethodGet, "/api?type=file&file_path="+url.QueryEscape(logFile)+"&from=2024-01-02T05:00:00Z", nil)
+		return rec, NewAPIHandler().Get(echo.New().NewContext(req, rec))
+	}
+	rec, err := get()
+	assert.NoError(t, err)
+	assert.Equal(t, http.StatusOK, rec.Code)
+
+	// replaced by a truncated gzip of the same size and mtime, the cached probe is stale
+	noise := make([]byte, 4096)
+	for i := range noise {
+		noise[i] = byte(i * 7919 >> 3)
+	}
+	var buf bytes.Buffer
+	gz := gzip.NewWriter(&buf)
+	_, err = gz.Write(noise)
+	assert.NoError(t, err)
+	assert.NoError(t, gz.Close())
+	assert.NoError(t, os.WriteFile(logFile, buf.Bytes()[:len(content)], 0600))
+	assert.NoError(t, os.Chtimes(logFile, stat.ModTime(), stat.ModTime()))
+
+	_, err = get()
+	httpErr := &echo.HTTPError{}
+	if assert.ErrorAs(t, err, &httpErr) {
+		assert.Equal(t, http.StatusInternalServerError, httpErr.Code)
+	}
+}
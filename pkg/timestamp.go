@@ -0,0 +1,77 @@
+package pkg
+
+import (
+	"regexp"
+	"strings"
+	"time"
+)
+
+// timestampScanBytes is how much of the start of a line is looked at for its timestamp
+const timestampScanBytes = 128
+
+// lineTimestamp matches the timestamps LineTime parses, each format with groups of its own
+var lineTimestamp = regexp.MustCompile(
+	// RFC 3339 and the like: 2024-01-02T15:04:05.000Z, 2024-01-02 15:04:05,000 +0100
+	`(\d{4}-\d{2}-\d{2})[T ](\d{2}:\d{2}:\d{2})(?:[.,](\d{1,9}))? ?(Z|[+-]\d{2}:?\d{2})?` +
+		// the prefix of Go's log package: 2024/01/02 15:04:05.000000
+		`|(\d{4}/\d{2}/\d{2} \d{2}:\d{2}:\d{2})(?:\.(\d{1,9}))?` +
+		// Apache common log: [02/Jan/2024:15:04:05 +0000]
+		`|(\d{2}/[A-Z][a-z]{2}/\d{4}:\d{2}:\d{2}:\d{2} [+-]\d{4})` +
+		// syslog: Jan  2 15:04:05
+		`|([A-Z][a-z]{2} [ \d]\d \d{2}:\d{2}:\d{2})`,
+)
+
+// LineTime parses the first timestamp in the start of a line, in RFC 3339, syslog, Apache common log or
+// Go's log format. Times without an offset are in loc, syslog times without a year in the last year that
+// does not make them more than a day ahead.
+func LineTime(line []byte, loc *time.Location) (time.Time, bool) {
+	if len(line) > timestampScanBytes {
+		line = line[:timestampScanBytes]
+	}
+	m := lineTimestamp.FindSubmatchIndex(line)
+	if m == nil {
+		return time.Time{}, false
+	}
+	group := func(i int) string {
+		if m[2*i] < 0 {
+			return ""
+		}
+		return string(line[m[2*i]:m[2*i+1]])
+	}
+	var ts time.Time
+	var err error
+	switch {
+	case m[2] >= 0:
+		layout, value := "2006-01-02T15:04:05", group(1)+"T"+group(2)
+		if fraction := group(3); fraction != "" {
+			layout, value = layout+".999999999", value+"."+fraction
+		}
+		switch zone := group(4); {
+		case zone == "":
+		case zone == "Z" || strings.Contains(zone, ":"):
+			layout, value = layout+"Z07:00", value+zone
+		default:
+			layout, value = layout+"-0700", value+zone
+		}
+		ts, err = time.ParseInLocation(layout, value, loc)
+	case m[10] >= 0:
+		layout, value := "2006/01/02 15:04:05", group(5)
+		if fraction := group(6); fraction != "" {
+			layout, value = layout+".999999999", value+"."+fraction
+		}
+		ts, err = time.ParseInLocation(layout, value, loc)
+	case m[14] >= 0:
+		ts, err = time.ParseInLocation("02/Jan/2006:15:04:05 -0700", group(7), loc)
+	default:
+		if ts, err = time.ParseInLocation(time.Stamp, group(8), loc); err != nil {
+			break
+		}
+		now := GlobalClock.Now().In(loc)
+		year := now.Year()
+		if time.Date(year, ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), 0, loc).After(now.Add(24 * time.Hour)) {
+			year--
+		}
+		ts = time.Date(year, ts.Month(), ts.Day(), ts.Hour(), ts.Minute(), ts.Second(), 0, loc)
+	}
+	return ts, err == nil
+}
@@ -0,0 +1,46 @@
+package pkg
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLineTime(t *testing.T) {
+	clock := GlobalClock
+	t.Cleanup(func() { GlobalClock = clock })
+	GlobalClock = NewManualClock(time.Date(2024, 1, 3, 12, 0, 0, 0, time.UTC))
+	tokyo, err := time.LoadLocation("Asia/Tokyo")
+	assert.NoError(t, err)
+
+	tests := map[string]time.Time{
+		"2024-01-02T15:04:05Z INFO started":                                     time.Date(2024, 1, 2, 15, 4, 5, 0, time.UTC),
+		"2024-01-02T15:04:05.123+01:00 INFO started":                            time.Date(2024, 1, 2, 14, 4, 5, 123000000, time.UTC),
+		"2024-01-02 15:04:05,250 ERROR failed":                                  time.Date(2024, 1, 2, 15, 4, 5, 250000000, tokyo),
+		"[2024-01-02 15:04:05 +0900] WARN slow":                                 time.Date(2024, 1, 2, 6, 4, 5, 0, time.UTC),
+		"2024/01/02 15:04:05 listening on :8080":                                time.Date(2024, 1, 2, 15, 4, 5, 0, tokyo),
+		"2024/01/02 15:04:05.000123 main.go:12: ready":                          time.Date(2024, 1, 2, 15, 4, 5, 123000, tokyo),
+		`127.0.0.1 - frank [02/Jan/2024:15:04:05 -0700] "GET / HTTP/1.1" 200 2`: time.Date(2024, 1, 2, 22, 4, 5, 0, time.UTC),
+		"Jan  2 15:04:05 host sshd[42]: accepted":                               time.Date(2024, 1, 2, 15, 4, 5, 0, tokyo),
+		// a syslog time more than a day ahead is of the year before
+		"Dec 31 23:59:59 host cron: ran": time.Date(2023, 12, 31, 23, 59, 59, 0, tokyo),
+	}
+	for line, want := range tests {
+		ts, ok := LineTime([]byte(line), tokyo)
+		if assert.True(t, ok, line) {
+			assert.True(t, want.Equal(ts), "%s: %s != %s", line, ts, want)
+		}
+	}
+
+	for _, line := range []string{
+		"",
+		"plain text",
+		"\tat com.example.Client.send(Client.java:42)",
+		"2024-13-02T15:04:05Z month out of range",
+		"x" + string(make([]byte, timestampScanBytes)) + " 2024-01-02T15:04:05Z",
+	} {
+		_, ok := LineTime([]byte(line), time.UTC)
+		assert.False(t, ok, line)
+	}
+}
@@ -0,0 +1,159 @@
+package pkg
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// selfSignedValidity is how long the certificate of -tls-auto is valid
+const selfSignedValidity = 365 * 24 * time.Hour
+
+// Certificate is the TLS certificate served, loaded from a cert and key file, which Reload reads again,
+// or generated self-signed in memory
+type Certificate struct {
+	certFile string
+	keyFile  string
+
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+}
+
+// LoadCertificate reads the PEM certificate and key files, the error names the file that failed
+func LoadCertificate(certFile string, keyFile string) (*Certificate, error) {
+	if certFile == "" || keyFile == "" {
+		return nil, errors.New("TLS needs both a certificate and a key file")
+	}
+	c := &Certificate{certFile: certFile, keyFile: keyFile}
+	if err := c.Reload(); err != nil {
+		return nil, err
+	}
+	return c, nil
+}
+
+// Reload reads the files again, keeping the previous certificate when they are not valid. A generated
+// certificate is kept as is.
+func (c *Certificate) Reload() error {
+	if c.certFile == "" {
+		return nil
+	}
+	certPEM, err := os.ReadFile(c.certFile)
+	if err != nil {
+		return fmt.Errorf("reading certificate %s: %w", c.certFile, err)
+	}
+	keyPEM, err := os.ReadFile(c.keyFile)
+	if err != nil {
+		return fmt.Errorf("reading key %s: %w", c.keyFile, err)
+	}
+	certificate, err := tls.X509KeyPair(certPEM, keyPEM)
+	if err != nil {
+		return fmt.Errorf("certificate %s with key %s: %w", c.certFile, c.keyFile, err)
+	}
+	leaf, err := x509.ParseCertificate(certificate.Certificate[0])
+	if err != nil {
+		return fmt.Errorf("parsing certificate %s: %w", c.certFile, err)
+	}
+	if GlobalClock.Now().After(leaf.NotAfter) {
+		slog.Warn("certificate expired", "cert", c.certFile, "not_after", leaf.NotAfter)
+	}
+	certificate.Leaf = leaf
+	c.set(&certificate)
+	slog.Info("loaded certificate", "cert", c.certFile, "fingerprint", CertificateFingerprint(leaf.Raw), "not_after", leaf.NotAfter)
+	return nil
+}
+
+// SelfSignedCertificate generates a certificate for host, localhost and the loopback addresses, valid
+// for a year. Its fingerprint is logged for clients to check it against.
+func SelfSignedCertificate(host string) (*Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	now := GlobalClock.Now()
+	template := &x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               pkix.Name{Organization: []string{"gol"}, CommonName: "gol self-signed"},
+		NotBefore:             now.Add(-time.Hour),
+		NotAfter:              now.Add(selfSignedValidity),
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+	}
+	if ip := net.ParseIP(host); ip != nil {
+		template.IPAddresses = append(template.IPAddresses, ip)
+	} else if host != "" && host != "localhost" {
+		template.DNSNames = append(template.DNSNames, host)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	slog.Info("generated a self-signed certificate", "fingerprint", CertificateFingerprint(der), "not_after", leaf.NotAfter)
+	return &Certificate{certificate: &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key, Leaf: leaf}}, nil
+}
+
+// CertificateFingerprint is the SHA-256 of a DER certificate, as colon separated hex
+func CertificateFingerprint(der []byte) string {
+	sum := sha256.Sum256(der)
+	parts := make([]string, len(sum))
+	for i, b := range sum {
+		parts[i] = fmt.Sprintf("%02X", b)
+	}
+	return strings.Join(parts, ":")
+}
+
+func (c *Certificate) set(certificate *tls.Certificate) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	c.certificate = certificate
+}
+
+// TLSConfig serves the current certificate, a reloaded one from the next handshake on
+func (c *Certificate) TLSConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2", "http/1.1"},
+		GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+			c.mutex.RLock()
+			defer c.mutex.RUnlock()
+			return c.certificate, nil
+		},
+	}
+}
+
+// RedirectToTLS answers every request with a 301 to the same URL over HTTPS on tlsPort
+func RedirectToTLS(tlsPort int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, _, err := net.SplitHostPort(r.Host)
+		if err != nil {
+			host = r.Host
+		}
+		target := "https://" + net.JoinHostPort(host, strconv.Itoa(tlsPort)) + r.URL.RequestURI()
+		http.Redirect(w, r, target, http.StatusMovedPermanently)
+	})
+}
@@ -0,0 +1,130 @@
+package pkg
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// writeCertificate writes a self-signed certificate and its key as PEM files, it returns the DER certificate
+func writeCertificate(t *testing.T, certFile string, keyFile string) []byte {
+	t.Helper()
+	generated, err := SelfSignedCertificate("gol.test")
+	assert.NoError(t, err)
+	der := generated.certificate.Certificate[0]
+	key, err := x509.MarshalECPrivateKey(generated.certificate.PrivateKey.(*ecdsa.PrivateKey))
+	assert.NoError(t, err)
+	assert.NoError(t, os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0600))
+	assert.NoError(t, os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: key}), 0600))
+	return der
+}
+
+func TestLoadCertificate(t *testing.T) {
+	dir := t.TempDir()
+	certFile, keyFile := filepath.Join(dir, "cert.pem"), filepath.Join(dir, "key.pem")
+	served := func(c *Certificate) []byte {
+		certificate, err := c.TLSConfig().GetCertificate(nil)
+		assert.NoError(t, err)
+		return certificate.Certificate[0]
+	}
+
+	// the error names the file that failed
+	_, err := LoadCertificate(certFile, keyFile)
+	assert.ErrorContains(t, err, certFile)
+	writeCertificate(t, certFile, filepath.Join(dir, "other.pem"))
+	_, err = LoadCertificate(certFile, keyFile)
+	assert.ErrorContains(t, err, keyFile)
+	assert.NoError(t, os.WriteFile(keyFile, []byte("not a key"), 0600))
+	_, err = LoadCertificate(certFile, keyFile)
+	assert.ErrorContains(t, err, keyFile)
+	_, err = LoadCertificate(certFile, "")
+	assert.Error(t, err)
+
+	first := writeCertificate(t, certFile, keyFile)
+	certificate, err := LoadCertificate(certFile, keyFile)
+	assert.NoError(t, err)
+	assert.Equal(t, first, served(certificate))
+
+	// a reload serves the new files, or keeps the previous certificate when they are broken
+	second := writeCertificate(t, certFile, keyFile)
+	assert.NoError(t, certificate.Reload())
+	assert.Equal(t, second, served(certificate))
+	assert.NoError(t, os.WriteFile(certFile, []byte("broken"), 0600))
+	assert.ErrorContains(t, certificate.Reload(), certFile)
+	assert.Equal(t, second, served(certificate))
+}
+
+func TestServer_TLS(t *testing.T) {
+	// a free port for the redirect
+	free, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.NoError(t, err)
+	redirectPort := free.Addr().(*net.TCPAddr).Port
+	free.Close()
+
+	addrs := make(chan net.Addr, 1)
+	server, err := NewServer(func(o *EchoOptions) error {
+		o.Host = "127.0.0.1"
+		o.Port = 0
+		o.Compression = CompressionOff
+		o.TLSAuto = true
+		o.RedirectHTTP = int64(redirectPort)
+		return nil
+	}, WithListenCallback(func(addr net.Addr) { addrs <- addr }))
+	assert.NoError(t, err)
+	assert.True(t, server.TLS())
+	server.Echo.HideBanner = true
+	server.Echo.HidePort = true
+	ctx, cancel := context.WithCancel(context.Background())
+	ran := make(chan error, 1)
+	go func() {
+		ran <- server.Run(ctx)
+	}()
+	port := (<-addrs).(*net.TCPAddr).Port
+
+	client := &http.Client{
+		Transport: &http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}}, //nolint: gosec
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+		Timeout: 5 * time.Second,
+	}
+	res, err := client.Get("https://127.0.0.1:" + strconv.Itoa(port) + "/healthz")
+	if assert.NoError(t, err) {
+		res.Body.Close()
+		assert.Equal(t, http.StatusOK, res.StatusCode)
+		assert.Equal(t, server.certificate.certificate.Certificate[0], res.TLS.PeerCertificates[0].Raw)
+	}
+
+	res, err = client.Get("http://127.0.0.1:" + strconv.Itoa(redirectPort) + "/api/files?limit=2")
+	if assert.NoError(t, err) {
+		res.Body.Close()
+		assert.Equal(t, http.StatusMovedPermanently, res.StatusCode)
+		assert.Equal(t, "https://127.0.0.1:"+strconv.Itoa(port)+"/api/files?limit=2", res.Header.Get("Location"))
+	}
+
+	cancel()
+	assert.NoError(t, <-ran)
+
+	_, err = NewServer(func(o *EchoOptions) error {
+		o.TLSAuto = true
+		o.CertFile = "cert.pem"
+		return nil
+	})
+	assert.Error(t, err)
+	_, err = NewServer(func(o *EchoOptions) error {
+		o.RedirectHTTP = 8080
+		return nil
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,70 @@
+package pkg
+
+import (
+	"bufio"
+	"io"
+	"regexp"
+	"unicode/utf8"
+)
+
+const (
+	// DefaultMaxLineLength is the number of bytes of a line returned for display
+	DefaultMaxLineLength = 16 * 1024
+	// maxScanLineSize is the longest line the scanners can read
+	maxScanLineSize = 1024 * 1024
+)
+
+// Highlight is a match of the query in a line, as byte offsets into the full line.
+// OutOfWindow is set when the match lies (partly) beyond the truncated content.
+type Highlight struct {
+	Start       int  `json:"start"`
+	End         int  `json:"end"`
+	OutOfWindow bool `json:"out_of_window,omitempty"`
+}
+
+// newLineScanner is a line scanner with a buffer large enough for very long lines
+func newLineScanner(r io.Reader) *bufio.Scanner {
+	scanner := bufio.NewScanner(r)
+	buf := make([]byte, 64*1024)
+	scanner.Buffer(buf, maxScanLineSize)
+	return scanner
+}
+
+// TruncateLines applies TruncateLine to every line
+func TruncateLines(lines []LineResult, maxLength int, re *regexp.Regexp) {
+	for i := range lines {
+		TruncateLine(&lines[i], maxLength, re)
+	}
+}
+
+// TruncateLine sanitizes the content with SanitizeLine, computes the highlights of re on the full content,
+// then cuts the content to maxLength bytes on a rune boundary. A maxLength <= 0 disables truncation.
+func TruncateLine(line *LineResult, maxLength int, re *regexp.Regexp) {
+	SanitizeLine(line)
+	cut := len(line.Content)
+	if maxLength > 0 && len(line.Content) > maxLength {
+		cut = maxLength
+		for cut > 0 && !utf8.RuneStart(line.Content[cut]) {
+			cut--
+		}
+	}
+
+	if re != nil && re.String() != "" {
+		for _, loc := range re.FindAllStringIndex(line.Content, -1) {
+			if loc[0] == loc[1] {
+				continue
+			}
+			line.Highlights = append(line.Highlights, Highlight{
+				Start:       loc[0],
+				End:         loc[1],
+				OutOfWindow: loc[1] > cut,
+			})
+		}
+	}
+
+	if cut < len(line.Content) {
+		line.FullLength = len(line.Content)
+		line.Truncated = true
+		line.Content = line.Content[:cut]
+	}
+}
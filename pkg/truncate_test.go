@@ -0,0 +1,90 @@
+package pkg
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTruncateLine(t *testing.T) {
+	type TestCase struct {
+		Name           string
+		Content        string
+		MaxLength      int
+		Query          string
+		WantContent    string
+		WantTruncated  bool
+		WantFullLength int
+		WantHighlights []Highlight
+	}
+
+	long := strings.Repeat("a", 20) + "ERROR" + strings.Repeat("b", 20)
+
+	testCases := []TestCase{
+		{
+			Name:        "short line is untouched",
+			Content:     "INFO ok",
+			MaxLength:   16,
+			WantContent: "INFO ok",
+		},
+		{
+			Name:           "long line is cut",
+			Content:        long,
+			MaxLength:      10,
+			WantContent:    strings.Repeat("a", 10),
+			WantTruncated:  true,
+			WantFullLength: 45,
+		},
+		{
+			Name:           "match beyond the cut is out of window",
+			Content:        long,
+			MaxLength:      22,
+			Query:          "ERROR",
+			WantContent:    strings.Repeat("a", 20) + "ER",
+			WantTruncated:  true,
+			WantFullLength: 45,
+			WantHighlights: []Highlight{{Start: 20, End: 25, OutOfWindow: true}},
+		},
+		{
+			Name:           "match within the cut",
+			Content:        long,
+			MaxLength:      30,
+			Query:          "ERROR",
+			WantContent:    long[:30],
+			WantTruncated:  true,
+			WantFullLength: 45,
+			WantHighlights: []Highlight{{Start: 20, End: 25}},
+		},
+		{
+			Name:           "never splits a rune",
+			Content:        "ab€cd",
+			MaxLength:      3,
+			WantContent:    "ab",
+			WantTruncated:  true,
+			WantFullLength: 7,
+		},
+		{
+			Name:        "zero disables truncation",
+			Content:     long,
+			MaxLength:   0,
+			WantContent: long,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			line := LineResult{Content: tc.Content}
+			var re *regexp.Regexp
+			if tc.Query != "" {
+				re = regexp.MustCompile(tc.Query)
+			}
+			TruncateLine(&line, tc.MaxLength, re)
+			assert.Equal(t, tc.WantContent, line.Content)
+			assert.Equal(t, tc.WantTruncated, line.Truncated)
+			assert.Equal(t, tc.WantFullLength, line.FullLength)
+			assert.Equal(t, tc.WantHighlights, line.Highlights)
+		})
+	}
+}
@@ -5,8 +5,39 @@ const (
 	TypeStdin        = "stdin"
 	TypeSSH          = "ssh"
 	TypeDocker       = "docker"
+	TypeRemoteGol    = "remote"
+	TypeInternal     = "internal"
+	TypeK8s          = "k8s"
+	TypeJournal      = "journal"
+	TypeHTTP         = "http"
+	TypeS3           = "s3"
 	TmpStdinPath     = "/tmp/GOL-STDIN-"
 	TmpContainerPath = "/tmp/GOL-CONTAINER-"
+	TmpK8sPath       = "/tmp/GOL-K8S-"
+	TmpJournalPath   = "/tmp/GOL-JOURNAL-"
 
 	ErrorMsgSessionAlreadyStarted = "ssh: session already started"
+
+	ErrorCodeAnchorNotFound = "anchor_not_found"
+	ErrorCodeTooBusy        = "too_busy"
+	ErrorCodeReadOnly       = "server_is_read_only"
+	ErrorCodeAdminDisabled  = "admin_disabled"
+	ErrorCodeUnauthorized   = "unauthorized"
+	ErrorCodeRemoteDown     = "remote_unavailable"
+	ErrorCodeCursorExpired  = "cursor_expired"
+	ErrorCodeShuttingDown   = "shutting_down"
+	// ErrorCodeTooManyAttempts answers an address that failed to authenticate too often, until its Retry-After
+	ErrorCodeTooManyAttempts = "too_many_attempts"
+	// ErrorCodeTooManyScans answers a search, export or count that waited too long for a scan slot,
+	// ErrorCodeRateLimited an address sending more API requests than its rate, both with a Retry-After
+	ErrorCodeTooManyScans = "too_many_scans"
+	ErrorCodeRateLimited  = "rate_limited"
+	// ErrorCodeFileNotAllowed answers a request for a file that is not watched, with a FileAccessError
+	ErrorCodeFileNotAllowed = "file_not_allowed"
+	// ErrorCodeShareNotFound, ErrorCodeShareExpired, ErrorCodeShareFileGone and ErrorCodeShareFileChanged
+	// answer a share link that no longer opens, with a ShareError
+	ErrorCodeShareNotFound    = "share_not_found"
+	ErrorCodeShareExpired     = "share_expired"
+	ErrorCodeShareFileGone    = "share_file_gone"
+	ErrorCodeShareFileChanged = "share_file_changed"
 )
@@ -0,0 +1,41 @@
+package pkg
+
+import (
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+type VersionHandler struct {
+	options *EchoOptions
+}
+
+func NewVersionHandler(options *EchoOptions) *VersionHandler {
+	return &VersionHandler{options: options}
+}
+
+// VersionCapabilities tells the frontend which affordances to offer
+type VersionCapabilities struct {
+	ReadOnly  bool `json:"read_only"`
+	Mutations bool `json:"mutations"`
+}
+
+type VersionResponse struct {
+	Version string `json:"version"`
+	// APIVersion is the version of the contract described by /api/openapi.json
+	APIVersion   string              `json:"api_version"`
+	Capabilities VersionCapabilities `json:"capabilities"`
+	Memory       MemoryUsage         `json:"memory"`
+}
+
+func (h *VersionHandler) Get(c echo.Context) error {
+	return c.JSON(http.StatusOK, VersionResponse{
+		Version:    h.options.Version,
+		APIVersion: APIVersion,
+		Capabilities: VersionCapabilities{
+			ReadOnly:  h.options.ReadOnly,
+			Mutations: !h.options.ReadOnly,
+		},
+		Memory: GlobalMemory.Usage(),
+	})
+}
@@ -3,17 +3,20 @@ package pkg
 import (
 	"bufio"
 	"bytes"
-	"compress/gzip"
-	"fmt"
+	"context"
+	"io"
 	"os"
 	"regexp"
-	"strings"
 	"sync"
+	"time"
 
 	"github.com/acarl005/stripansi"
 	"golang.org/x/crypto/ssh"
 )
 
+// collectCheckLines is the number of lines scanned between checks of the context of a scan
+const collectCheckLines = 4096
+
 type Watcher struct {
 	filePath      string
 	matchPattern  string
@@ -22,7 +25,17 @@ type Watcher struct {
 	sshConfig     *ssh.ClientConfig
 	sshHost       string
 	sshPort       string
+	remoteConfig  *SSHConfig
 	isRemote      bool
+	sampler       *Sampler
+	processor     LineProcessor
+	fieldFilters  []FieldFilter
+	jsonFilters   []FieldFilter
+	levels        map[string]bool
+	multiline     *regexp.Regexp
+	timeRange     *TimeRange
+	// followRotation continues tails into the file the watched one was last rotated to
+	followRotation bool
 }
 
 func NewWatcher(
@@ -58,6 +71,7 @@ func NewWatcher(
 		isRemote:      isRemote,
 		sshHost:       sshHost,
 		sshPort:       sshPort,
+		remoteConfig:  remoteSSHConfig(sshHost, sshPort, sshUser, sshPassword, sshPrivateKeyPath),
 		sshConfig: &ssh.ClientConfig{
 			User: sshUser,
 			Auth: []ssh.AuthMethod{
@@ -70,14 +84,83 @@ func NewWatcher(
 	return watcher, nil
 }
 
+// remoteSSHConfig is the config of the SSH path listing the watched file, so that the watcher gets the pooled
+// client of the listing. Without one the host key is verified against the default known_hosts.
+func remoteSSHConfig(host string, port string, user string, password string, privateKeyPath string) *SSHConfig {
+	for _, pathConfig := range SSHPathConfigs() {
+		if pathConfig.Host == host && pathConfig.Port == port && pathConfig.User == user {
+			return pathConfig.ToSSHConfig()
+		}
+	}
+	return &SSHConfig{
+		Host:           host,
+		Port:           port,
+		User:           user,
+		Password:       password,
+		PrivateKeyPath: privateKeyPath,
+		StrictHostKey:  true,
+	}
+}
+
+// SetProcessor makes the following scans match and return the lines as processed by processor,
+// keeping those with fields matching every filter. Lines it does not understand stay raw.
+func (w *Watcher) SetProcessor(processor LineProcessor, fieldFilters []FieldFilter) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.processor = processor
+	w.fieldFilters = fieldFilters
+}
+
+// SetJSONFilters makes the following scans keep only the lines that are JSON objects with fields
+// matching every filter, keys address nested fields with dots
+func (w *Watcher) SetJSONFilters(filters []FieldFilter) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.jsonFilters = filters
+}
+
+// SetLevels makes the following scans keep only the lines of one of levels, as DetectLevel finds them
+func (w *Watcher) SetLevels(levels map[string]bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.levels = levels
+}
+
+// SetSampler makes the following scans return a deterministic sample of the matching lines
+func (w *Watcher) SetSampler(sampler *Sampler) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.sampler = sampler
+}
+
 type LineResult struct {
 	LineNumber int    `json:"line_number"`
 	Content    string `json:"content"`
 	Level      string `json:"level"`
+	Class      string `json:"class"`
 	Date       string `json:"date"`
+	Anchor     string `json:"anchor"`
 	Agent      struct {
 		Device string `json:"device"`
 	} `json:"agent"`
+	Truncated  bool        `json:"truncated,omitempty"`
+	FullLength int         `json:"full_length,omitempty"`
+	Highlights []Highlight `json:"highlights,omitempty"`
+	// Source is the index of the line's file in the sources table of the response
+	Source int `json:"source"`
+	// Fields are extracted by the processor of the request
+	Fields map[string]string `json:"fields,omitempty"`
+	// JSON is the line parsed, for lines that are a JSON object
+	JSON map[string]interface{} `json:"json,omitempty"`
+	// Lines is the number of physical lines of a multiline entry, from LineNumber on
+	Lines int `json:"lines,omitempty"`
+	// Binary is set when much of the line was not text, its invalid and control bytes replaced
+	Binary bool `json:"binary,omitempty"`
+
+	// hashes of the line and its neighbors, the anchor is derived from them
+	prevHash uint32
+	hash     uint32
+	nextHash uint32
 }
 
 type ScanResult struct {
@@ -87,43 +170,321 @@ type ScanResult struct {
 	MatchPattern string       `json:"match_pattern"`
 	Total        int          `json:"total"`
 	Lines        []LineResult `json:"lines"`
+	Sources      []LineSource `json:"sources"`
+	// Sample is set when only a sample of the lines was scanned
+	Sample *SampleInfo `json:"sample,omitempty"`
+	// TimeRange tells which segments a time range query was routed to
+	TimeRange *TimeRangeResolution `json:"time_range,omitempty"`
+	// Warnings name the segments of a logical log skipped because they could not be read
+	Warnings []SegmentWarning `json:"warnings,omitempty"`
+	// PatternLimited is set when the pattern was over the max cost and only a sample of the lines was scanned
+	PatternLimited bool         `json:"pattern_limited,omitempty"`
+	PatternCost    *PatternCost `json:"pattern_cost,omitempty"`
+	// NextCursor resumes reading after the last line of a forward page of a local file
+	NextCursor string `json:"next_cursor,omitempty"`
+	// Truncated is set when the lines stopped at -max-lines-per-request or -max-response-bytes, those
+	// after them are read from NextCursor
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+// LineSource is an entry of the sources table, sent once per response or stream
+// so that every line can refer to its file by index
+type LineSource struct {
+	FilePath string `json:"file_path"`
+	Host     string `json:"host"`
+	Type     string `json:"type"`
+	Label    string `json:"label"`
 }
 
+// SetSourceType sets the type and host of every source and labels them from the watched files
+func (r *ScanResult) SetSourceType(sourceType string, host string) {
+	for i := range r.Sources {
+		r.Sources[i].Type = sourceType
+		r.Sources[i].Host = host
+		r.Sources[i].Label = fileLabel(r.Sources[i].FilePath, sourceType, host)
+	}
+}
+
+// fileLabel is the name of the watched file, empty when it has none
+func fileLabel(filePath string, sourceType string, host string) string {
+	for _, fileInfo := range GlobalFileRegistry.Snapshot() {
+		if fileInfo.FilePath == filePath && fileInfo.Type == sourceType && fileInfo.Host == host {
+			return fileInfo.Name
+		}
+	}
+	return ""
+}
+
+// Deprecated: use ScanContext.
 func (w *Watcher) Scan(page, pageSize int, reverse bool) (*ScanResult, error) {
+	return w.ScanContext(context.Background(), page, pageSize, reverse)
+}
+
+// ScanContext returns a page of the lines matching the patterns of the watcher, ctx being done stops the scan
+func (w *Watcher) ScanContext(ctx context.Context, page, pageSize int, reverse bool) (result *ScanResult, err error) {
+	defer func(start time.Time) { GlobalMetrics.ObserveRead(ReadOpSearch, start, err) }(time.Now())
 	w.mutex.Lock()
 	defer w.mutex.Unlock()
+	if w.sampler != nil {
+		w.sampler.reset()
+	}
 
-	file, scanner, err := w.initializeScanner()
+	file, scanner, start, err := w.openScannerAt(w.filePath)
 	if err != nil {
 		return nil, err
 	}
 	if file != nil {
 		defer file.Close()
 	}
+	if w.sampler != nil {
+		w.sampler.reseed(file, w.filePath)
+	}
 
-	allLines, counts, err := w.collectMatchingLines(scanner)
+	window := w.pageWindow(page, pageSize, reverse)
+	counts, err := w.collect(ctx, scanner, start, window)
 	if err != nil {
 		return nil, err
 	}
 
-	lines := w.paginateLines(allLines, page, pageSize, reverse)
+	lines := window.page()
+	sources := []LineSource{{FilePath: w.filePath, Host: w.sshHost}}
+	w.finalizeLines(lines, sources)
+	result = w.scanResult(lines, window.matches, counts, sources)
+	result.Truncated = window.truncated
+	return result, nil
+}
+
+// pageWindow keeps the lines of a page within GlobalMaxPerPage lines and GlobalMaxResponseBytes.
+// Search matched the full lines, only what is returned for display is truncated.
+func (w *Watcher) pageWindow(page, pageSize int, reverse bool) *lineWindow {
+	return newPageWindow(page, pageSize, reverse, GlobalMaxPerPage, GlobalMaxResponseBytes, GlobalMaxLineLength, regexp.MustCompile(w.matchPattern))
+}
 
-	AppendGeneralInfo(&lines)
-	return &ScanResult{
+// ScanSegments scans the physical files of a logical log as one, in the given (chronological) order.
+// Line numbers are those within each physical file, which every line refers to as its source.
+// Segments that cannot be read are skipped with a warning, unless there is only one.
+func (w *Watcher) ScanSegments(filePaths []string, page, pageSize int, reverse bool) (result *ScanResult, err error) {
+	defer func(start time.Time) { GlobalMetrics.ObserveRead(ReadOpSearch, start, err) }(time.Now())
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	if w.sampler != nil {
+		w.sampler.reset()
+	}
+
+	window := w.pageWindow(page, pageSize, reverse)
+	sources := make([]LineSource, 0, len(filePaths))
+	total := 0
+	var warnings []SegmentWarning
+	for source, filePath := range filePaths {
+		window.source = source
+		mark := window.mark()
+		counts, err := w.collectSegment(filePath, window)
+		if err != nil && len(filePaths) == 1 {
+			return nil, err
+		}
+		sources = append(sources, LineSource{FilePath: filePath, Host: w.sshHost})
+		if err != nil {
+			window.rollback(mark)
+			warnings = append(warnings, SegmentWarning{FilePath: filePath, Error: err.Error()})
+			continue
+		}
+		total += counts
+	}
+
+	lines := window.page()
+	w.finalizeLines(lines, sources)
+	result = w.scanResult(lines, window.matches, total, sources)
+	result.Truncated = window.truncated
+	result.Warnings = warnings
+	return result, nil
+}
+
+// Tail returns the last n lines of the watched file in file order, reading only its end. The lines are numbered
+// back from the line count of the file, which is served from the stats cache when it is up to date.
+// Grouped lines are the last n whole entries. The tail is truncated to its last GlobalMaxPerPage lines and
+// GlobalMaxResponseBytes.
+func (w *Watcher) Tail(ctx context.Context, n int) (result *ScanResult, err error) {
+	defer func(start time.Time) { GlobalMetrics.ObserveRead(ReadOpTail, start, err) }(time.Now())
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	var sshConfig *SSHConfig
+	if w.isRemote {
+		sshConfig = w.remoteConfig
+	}
+	linesCount, _, err := FileStatsContext(ctx, w.filePath, w.isRemote, sshConfig)
+	if err != nil && !isEmptyFileErr(err) {
+		return nil, err
+	}
+	truncated := false
+	if n > GlobalMaxPerPage {
+		n, truncated = GlobalMaxPerPage, true
+	}
+	var lines []LineResult
+	if w.multiline != nil {
+		lines, err = w.tailEntries(ctx, n, linesCount, sshConfig)
+	} else {
+		lines, err = tailFileLines(ctx, w.filePath, n, linesCount, w.isRemote, sshConfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sources := []LineSource{{FilePath: w.filePath, Host: w.sshHost}}
+	if w.followRotation && !w.isRemote && w.multiline == nil && len(lines) < n {
+		rotated, rotatedLines, err := tailRotated(ctx, w.filePath, n-len(lines))
+		if err != nil {
+			return nil, err
+		}
+		if rotated != "" {
+			for i := range rotatedLines {
+				rotatedLines[i].Source = len(sources)
+			}
+			lines = append(rotatedLines, lines...)
+			sources = append(sources, LineSource{FilePath: rotated})
+		}
+	}
+	TruncateLines(lines, GlobalMaxLineLength, regexp.MustCompile(w.matchPattern))
+	lines, cut := cutToBudget(lines, n, GlobalMaxResponseBytes)
+
+	w.finalizeLines(lines, sources)
+	result = w.scanResult(lines, len(lines), linesCount, sources)
+	result.Truncated = truncated || cut
+	return result, nil
+}
+
+// SetFollowRotation makes the following tails longer than the file continue with the last lines of the
+// file it was last rotated to
+func (w *Watcher) SetFollowRotation(follow bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	w.followRotation = follow
+}
+
+// tailRotated returns the last n lines of the file filePath was last rotated to, and its path, empty when
+// there is none
+func tailRotated(ctx context.Context, filePath string, n int) (string, []LineResult, error) {
+	rotated := RotatedSibling(filePath)
+	if rotated == "" {
+		return "", nil, nil
+	}
+	linesCount, _, err := FileStatsContext(ctx, rotated, false, nil)
+	if err != nil && !isEmptyFileErr(err) {
+		return "", nil, err
+	}
+	lines, err := tailFileLines(ctx, rotated, n, linesCount, false, nil)
+	return rotated, lines, err
+}
+
+// tailFileLines returns the last n lines of a file of linesCount lines
+func tailFileLines(ctx context.Context, filePath string, n int, linesCount int, isRemote bool, sshConfig *SSHConfig) ([]LineResult, error) {
+	// one more line is read for the hash before the first line, which its anchor is made of
+	contents, err := ReadTailLinesContext(ctx, filePath, n+1, isRemote, sshConfig)
+	if err != nil {
+		return nil, err
+	}
+	var prevHash uint32
+	if len(contents) > n {
+		prevHash = lineHash(stripansi.Strip(contents[0]))
+		contents = contents[1:]
+	}
+
+	lines := make([]LineResult, 0, len(contents))
+	first := max(linesCount-len(contents), 0) + 1
+	for i, content := range contents {
+		content = stripansi.Strip(content)
+		hash := lineHash(content)
+		if i > 0 {
+			lines[i-1].nextHash = hash
+		}
+		lines = append(lines, LineResult{LineNumber: first + i, Content: content, prevHash: prevHash, hash: hash})
+		prevHash = hash
+	}
+	return lines, nil
+}
+
+// scanResult assembles the result of a scan. A sampled result is paginated over the sampled lines,
+// the sample info tells the total they extrapolate to.
+func (w *Watcher) scanResult(lines []LineResult, sampled int, total int, sources []LineSource) *ScanResult {
+	result := &ScanResult{
 		FilePath:     w.filePath,
 		Host:         w.sshHost,
 		MatchPattern: w.matchPattern,
-		Total:        counts,
+		Total:        total,
 		Lines:        lines,
-	}, nil
+		Sources:      sources,
+	}
+	if w.sampler != nil {
+		result.Total = sampled
+		result.Sample = w.sampler.Info()
+	}
+	return result
 }
 
-func (w *Watcher) initializeScanner() (*os.File, *bufio.Scanner, error) {
+func (w *Watcher) collectSegment(filePath string, window *lineWindow) (int, error) {
+	file, scanner, start, err := w.openScannerAt(filePath)
+	if err != nil {
+		return 0, err
+	}
+	if file != nil {
+		defer file.Close()
+	}
+	if w.sampler != nil {
+		w.sampler.reseed(file, filePath)
+	}
+	return w.collect(context.Background(), scanner, start, window)
+}
+
+// collect collects the matching lines of a scanner into window, or entries when lines are grouped,
+// and returns the number of matches
+func (w *Watcher) collect(ctx context.Context, scanner *bufio.Scanner, start scanStart, window *lineWindow) (int, error) {
+	if w.multiline != nil {
+		return w.collectEntriesInto(ctx, scanner, start, window)
+	}
+	return w.collectLinesInto(ctx, scanner, start, window)
+}
+
+// finalizeLines sets the anchors and general info of the lines about to be returned
+func (w *Watcher) finalizeLines(lines []LineResult, sources []LineSource) {
+	generations := map[int]int{}
+	for i, line := range lines {
+		generation, ok := generations[line.Source]
+		if !ok {
+			generation = FileGeneration(sources[line.Source].FilePath)
+			generations[line.Source] = generation
+		}
+		lines[i].Anchor = Anchor{
+			Generation: generation,
+			Hash:       anchorHash(line.prevHash, line.hash, line.nextHash),
+			LineNumber: line.LineNumber,
+		}.String()
+	}
+	AppendGeneralInfo(&lines)
+	appendJSON(lines)
+	classifiers := map[int]*Classifier{}
+	for i, line := range lines {
+		classifier, ok := classifiers[line.Source]
+		if !ok {
+			classifier = ClassifierFor(sources[line.Source].FilePath)
+			classifiers[line.Source] = classifier
+		}
+		lines[i].Class = classifier.Classify(line.Content, line.Level)
+	}
+}
+
+func (w *Watcher) initializeScanner() (io.ReadCloser, *bufio.Scanner, error) {
+	return w.openScanner(w.filePath)
+}
+
+// openScanner opens a scanner over filePath using the watcher's source (local or remote)
+func (w *Watcher) openScanner(filePath string) (io.ReadCloser, *bufio.Scanner, error) {
 	if w.isRemote {
-		return w.initializeRemoteScanner()
+		return w.initializeRemoteScanner(filePath)
+	}
+	if filePath == InternalLogPath && GlobalLogBuffer != nil {
+		return nil, newLineScanner(bytes.NewReader(GlobalLogBuffer.Bytes())), nil
 	}
 
-	file, err := os.Open(w.filePath)
+	file, err := openFile(OSFileOpener{}, filePath)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -133,116 +494,262 @@ func (w *Watcher) initializeScanner() (*os.File, *bufio.Scanner, error) {
 		return nil, nil, err
 	}
 	if fileInfo.Size() == 0 {
-		return file, bufio.NewScanner(file), nil
+		return file, newLineScanner(file), nil
 	}
 
-	buffer := make([]byte, 2)
-	if _, err := file.Read(buffer); err != nil {
+	reader, _, err := NewDecompressingReader(file)
+	if err != nil {
+		file.Close()
 		return nil, nil, err
 	}
-	_, err = file.Seek(0, 0)
+	return file, newLineScanner(utf8BufferedReader(reader)), nil
+}
+
+// initializeRemoteScanner scans filePath as it streams in from the host, closing the stream ends the transfer
+func (w *Watcher) initializeRemoteScanner(filePath string) (io.ReadCloser, *bufio.Scanner, error) {
+	stream, err := sshStreamFile(context.Background(), filePath, w.remoteConfig)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	if IsGzip(buffer) {
-		gzipReader, err := gzip.NewReader(file)
-		if err != nil {
-			return nil, nil, err
-		}
-		return file, bufio.NewScanner(gzipReader), nil
+	reader, _, err := NewDecompressingReader(stream)
+	if err != nil {
+		stream.Close()
+		return nil, nil, err
 	}
-
-	return file, bufio.NewScanner(file), nil
+	return stream, newLineScanner(utf8BufferedReader(reader)), nil
 }
 
-func (w *Watcher) initializeRemoteScanner() (*os.File, *bufio.Scanner, error) {
-	sshConfig := SSHConfig{
-		Host: w.sshHost,
-		Port: w.sshPort,
+// ReadLine returns the complete, untruncated line at lineNumber of filePath (the watched file or its
+// rotated sibling) with its anchor. It returns nil when the file has fewer lines.
+func (w *Watcher) ReadLine(filePath string, lineNumber int) (*LineResult, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+
+	re, err := regexp.Compile(w.matchPattern)
+	if err != nil {
+		return nil, err
 	}
-	session, err := NewSession(&sshConfig)
+
+	file, scanner, err := w.openScanner(filePath)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
+	}
+	if file != nil {
+		defer file.Close()
 	}
-	defer session.Close()
 
-	var b bytes.Buffer
-	session.Stdout = &b
-	if err := session.Run(fmt.Sprintf("cat %s", w.filePath)); err != nil {
-		if err.Error() != ErrorMsgSessionAlreadyStarted {
-			return nil, nil, err
+	var line *LineResult
+	var prevHash uint32
+	current := 0
+	for scanner.Scan() {
+		current++
+		content := stripansi.Strip(scanner.Text())
+		if line != nil {
+			line.nextHash = lineHash(content)
+			break
+		}
+		if current == lineNumber {
+			line = &LineResult{LineNumber: lineNumber, Content: content, prevHash: prevHash, hash: lineHash(content)}
+			continue
 		}
+		prevHash = lineHash(content)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if line == nil {
+		return nil, nil
 	}
+	line.Anchor = Anchor{
+		Generation: FileGeneration(filePath),
+		Hash:       anchorHash(line.prevHash, line.hash, line.nextHash),
+		LineNumber: lineNumber,
+	}.String()
+	TruncateLine(line, 0, re)
+	return line, nil
+}
 
-	scanner := bufio.NewScanner(strings.NewReader(b.String()))
+// ReadContext returns the lines from before lines above to after lines below each of lineNumbers
+// of filePath, truncated to GlobalMaxLineLength, in one pass that stops after the last needed line.
+// Line numbers past the end of the file have no entry.
+func (w *Watcher) ReadContext(filePath string, lineNumbers []int, before int, after int) (map[int][]LineResult, error) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
 
-	return nil, scanner, nil
+	contexts := make(map[int][]LineResult, len(lineNumbers))
+	if len(lineNumbers) == 0 {
+		return contexts, nil
+	}
+	re, err := regexp.Compile(w.matchPattern)
+	if err != nil {
+		return nil, err
+	}
+	last := 0
+	for _, lineNumber := range lineNumbers {
+		last = max(last, lineNumber+after)
+	}
+
+	file, scanner, err := w.openScanner(filePath)
+	if err != nil {
+		return nil, err
+	}
+	if file != nil {
+		defer file.Close()
+	}
+
+	current := 0
+	for current < last && scanner.Scan() {
+		current++
+		var line *LineResult
+		for _, lineNumber := range lineNumbers {
+			if current < lineNumber-before || current > lineNumber+after {
+				continue
+			}
+			if line == nil {
+				line = &LineResult{LineNumber: current, Content: stripansi.Strip(scanner.Text())}
+				TruncateLine(line, GlobalMaxLineLength, re)
+			}
+			contexts[lineNumber] = append(contexts[lineNumber], *line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	for lineNumber := range contexts {
+		if lineNumber > current {
+			delete(contexts, lineNumber)
+		}
+	}
+	return contexts, nil
 }
 
-func (w *Watcher) collectMatchingLines(scanner *bufio.Scanner) ([]LineResult, int, error) {
-	re, err := regexp.Compile(w.matchPattern)
+// collectMatchingLines collects every matching line of a scanner
+func (w *Watcher) collectMatchingLines(ctx context.Context, scanner *bufio.Scanner, start scanStart) ([]LineResult, int, error) {
+	window := newLineWindow()
+	counts, err := w.collectLinesInto(ctx, scanner, start, window)
 	if err != nil {
 		return nil, 0, err
 	}
+	return window.lines, counts, nil
+}
 
-	var reIgnore *regexp.Regexp
-	if w.ignorePattern != "" {
-		reIgnore, err = regexp.Compile(w.ignorePattern)
-		if err != nil {
-			return nil, 0, err
-		}
+// collectLinesInto is the hot path of searches: lines are matched as the scanner's bytes and only the
+// lines the window keeps are converted to strings and hashed. ctx is checked every collectCheckLines lines.
+func (w *Watcher) collectLinesInto(ctx context.Context, scanner *bufio.Scanner, start scanStart, window *lineWindow) (int, error) {
+	match, ignore, err := w.lineMatchers()
+	if err != nil {
+		return 0, err
 	}
 
-	var allLines []LineResult
-	lineNumber := 0
+	lineNumber := start.skipped
 	counts := 0
+	// stripped is reused by the lines with escape sequences. Anchors hash the lines kept and the lines
+	// around them only, prev holds the line before for its hash.
+	var stripped, prev []byte
+	hasPrev := false
 
 	for scanner.Scan() {
-		line := scanner.Text()
-		line = stripansi.Strip(line)
+		if lineNumber%collectCheckLines == 0 {
+			if err := ctx.Err(); err != nil {
+				return 0, err
+			}
+		}
+		line := scanner.Bytes()
+		content := ""
+		if hasANSI(line) {
+			stripped = stripANSI(stripped[:0], line)
+			line = stripped
+		}
 		lineNumber++
-		if reIgnore != nil && reIgnore.MatchString(line) {
-			continue
+		// anchors stay on the hash of the raw line
+		raw := line
+		hash, hashed := uint32(0), false
+		if last := window.last(); last != nil && last.LineNumber == lineNumber-1 {
+			hash, hashed = lineHash(raw), true
+			last.nextHash = hash
+		}
+		keep := true
+		if start.timeRange != nil {
+			in, done := start.timeRange.line(line)
+			if done {
+				break
+			}
+			keep = in
+		}
+		if keep && w.sampler != nil && !w.sampler.sampleLine(lineNumber) {
+			keep = false
 		}
-		if re.MatchString(line) {
-			allLines = append(allLines, LineResult{
-				LineNumber: lineNumber,
-				Content:    line,
-			})
+		var fields map[string]string
+		if keep {
+			line, content, fields, keep = w.keepLine(match, ignore, line, content)
+		}
+		if keep {
 			counts++
+			if w.sampler == nil || w.sampler.keepMatch() {
+				result := LineResult{LineNumber: lineNumber, Fields: fields}
+				if window.wants() {
+					if content == "" {
+						content = string(line)
+					}
+					if !hashed {
+						hash = lineHash(raw)
+					}
+					if hasPrev {
+						result.prevHash = lineHash(prev)
+					}
+					result.Content, result.hash = content, hash
+				}
+				window.add(result)
+			}
 		}
+		prev, hasPrev = append(prev[:0], raw...), true
 	}
 
 	if err := scanner.Err(); err != nil {
-		return nil, 0, err
+		return 0, err
 	}
 
-	return allLines, counts, nil
+	return counts, nil
 }
 
-func (w *Watcher) paginateLines(allLines []LineResult, page, pageSize int, reverse bool) []LineResult {
-	var start, end int
-	if reverse {
-		start = len(allLines) - (page * pageSize)
-		if start < 0 {
-			start = 0
-		}
-		end = start + pageSize
-		if end > len(allLines) {
-			end = len(allLines)
+// lineMatchers are the matchers of the match and ignore patterns, ignore is nil without one
+func (w *Watcher) lineMatchers() (lineMatcher, lineMatcher, error) {
+	match, err := newLineMatcher(w.matchPattern)
+	if err != nil {
+		return nil, nil, err
+	}
+	if w.ignorePattern == "" {
+		return match, nil, nil
+	}
+	ignore, err := newLineMatcher(w.ignorePattern)
+	if err != nil {
+		return nil, nil, err
+	}
+	return match, ignore, nil
+}
+
+// keepLine runs the processor, filters and patterns of the watcher over a line, content being the line
+// as a string when it was converted already. It returns the line as processed and whether it is kept.
+func (w *Watcher) keepLine(match, ignore lineMatcher, line []byte, content string) ([]byte, string, map[string]string, bool) {
+	var fields map[string]string
+	if w.processor != nil {
+		if processed, ok := processLine(w.processor, line); ok {
+			content, fields = processed.Text, processed.Fields
+			line = []byte(content)
 		}
-	} else {
-		start = (page - 1) * pageSize
-		end = start + pageSize
-		if end > len(allLines) {
-			end = len(allLines)
+		if len(w.fieldFilters) > 0 && !matchFields(w.fieldFilters, fields) {
+			return nil, "", nil, false
 		}
 	}
-
-	if start < len(allLines) {
-		return allLines[start:end]
+	if len(w.jsonFilters) > 0 && !matchJSONFilters(w.jsonFilters, line) {
+		return nil, "", nil, false
 	}
-
-	return []LineResult{}
+	if w.levels != nil && !w.levels[DetectLevel(line)] {
+		return nil, "", nil, false
+	}
+	if ignore != nil && ignore.Match(line) {
+		return nil, "", nil, false
+	}
+	return line, content, fields, match.Match(line)
 }
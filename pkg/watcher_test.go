@@ -1,12 +1,20 @@
 package pkg
 
 import (
+	"bufio"
+	"bytes"
 	"compress/gzip"
+	"context"
+	"fmt"
+	"hash/fnv"
 	"os"
 	"path/filepath"
+	"regexp"
 	"strings"
 	"testing"
+	"time"
 
+	"github.com/acarl005/stripansi"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -105,7 +113,7 @@ ERROR Another error occurred`
 	defer file.Close()
 
 	// Collect matching lines
-	lines, counts, err := watcher.collectMatchingLines(scanner)
+	lines, counts, err := watcher.collectMatchingLines(context.Background(), scanner, scanStart{})
 	assert.NoError(t, err)
 	assert.Equal(t, 2, counts)
 	assert.Len(t, lines, 2)
@@ -114,3 +122,194 @@ ERROR Another error occurred`
 	assert.Equal(t, 4, lines[1].LineNumber)
 	assert.Equal(t, "ERROR Another error occurred", lines[1].Content)
 }
+
+// TestWatcher_LongLines tests that long lines are truncated for display but matched and fetched in full
+func TestWatcher_LongLines(t *testing.T) {
+	dir := t.TempDir()
+
+	logFile := filepath.Join(dir, "test.log")
+	long := "INFO " + strings.Repeat("x", 200*1024) + " ERROR at the end"
+	content := "INFO short\n" + long + "\nINFO after\n"
+	err := os.WriteFile(logFile, []byte(content), 0600)
+	assert.NoError(t, err)
+
+	defer func(maxLineLength int) { GlobalMaxLineLength = maxLineLength }(GlobalMaxLineLength)
+	GlobalMaxLineLength = 1024
+
+	watcher, err := NewWatcher(logFile, "ERROR", "", false, "", "", "", "", "")
+	assert.NoError(t, err)
+
+	result, err := watcher.Scan(1, 10, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, result.Total)
+	line := result.Lines[0]
+	assert.Equal(t, 2, line.LineNumber)
+	assert.True(t, line.Truncated)
+	assert.Equal(t, len(long), line.FullLength)
+	assert.Len(t, line.Content, 1024)
+	assert.Len(t, line.Highlights, 1)
+	assert.True(t, line.Highlights[0].OutOfWindow)
+
+	full, err := watcher.ReadLine(logFile, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, long, full.Content)
+	assert.False(t, full.Truncated)
+	assert.Equal(t, line.Anchor, full.Anchor)
+
+	missing, err := watcher.ReadLine(logFile, 10)
+	assert.NoError(t, err)
+	assert.Nil(t, missing)
+}
+
+// collectMatchingLinesReference is the scan loop before matching moved to bytes,
+// the rewritten loop must return exactly what it did
+func collectMatchingLinesReference(scanner *bufio.Scanner, matchPattern string, ignorePattern string) ([]LineResult, int, error) {
+	re, err := regexp.Compile(matchPattern)
+	if err != nil {
+		return nil, 0, err
+	}
+	var reIgnore *regexp.Regexp
+	if ignorePattern != "" {
+		if reIgnore, err = regexp.Compile(ignorePattern); err != nil {
+			return nil, 0, err
+		}
+	}
+	var allLines []LineResult
+	lineNumber := 0
+	counts := 0
+	var prevHash uint32
+	for scanner.Scan() {
+		line := stripansi.Strip(scanner.Text())
+		lineNumber++
+		h := fnv.New32a()
+		h.Write([]byte(line)) //nolint: errcheck
+		hash := h.Sum32()
+		if n := len(allLines); n > 0 && allLines[n-1].LineNumber == lineNumber-1 {
+			allLines[n-1].nextHash = hash
+		}
+		if reIgnore != nil && reIgnore.MatchString(line) {
+			prevHash = hash
+			continue
+		}
+		if re.MatchString(line) {
+			counts++
+			allLines = append(allLines, LineResult{LineNumber: lineNumber, Content: line, prevHash: prevHash, hash: hash})
+		}
+		prevHash = hash
+	}
+	return allLines, counts, scanner.Err()
+}
+
+// writeScanFixture writes n lines of mixed levels, cases, ANSI colors and non ASCII text
+func writeScanFixture(t testing.TB, dir string, n int, gzipped bool) string {
+	var content bytes.Buffer
+	levels := []string{"INFO", "ERROR", "warn", "Debug", "\x1b[31mERROR\x1b[0m", "error"}
+	for i := 1; i <= n; i++ {
+		fmt.Fprintf(&content, "2024-06-01T12:00:%02d %s request_id=%d user=ünïcode path=/api/v1/items?id=%d TASK done\n", i%60, levels[i%len(levels)], i, i*7)
+	}
+	name := filepath.Join(dir, "scan.log")
+	b := content.Bytes()
+	if gzipped {
+		name += ".gz"
+		var buf bytes.Buffer
+		gz := gzip.NewWriter(&buf)
+		_, err := gz.Write(b)
+		assert.NoError(t, err)
+		assert.NoError(t, gz.Close())
+		b = buf.Bytes()
+	}
+	assert.NoError(t, os.WriteFile(name, b, 0600))
+	return name
+}
+
+func TestWatcher_CollectMatchingLinesEquivalence(t *testing.T) {
+	logFile := writeScanFixture(t, t.TempDir(), 5000, false)
+	queries := []struct{ match, ignore string }{
+		{"", ""},
+		{"ERROR", ""},
+		{"(?i)error", ""},
+		{"(?i)task", ""},
+		{"ERROR", "request_id=1"},
+		{"id=[0-9]+7 ", ""},
+		{"ünïcode", "(?i)debug"},
+		{"\x1b", ""},
+	}
+	for _, q := range queries {
+		watcher, err := NewWatcher(logFile, q.match, q.ignore, false, "", "", "", "", "")
+		assert.NoError(t, err)
+
+		file, scanner, err := watcher.initializeScanner()
+		assert.NoError(t, err)
+		lines, counts, err := watcher.collectMatchingLines(context.Background(), scanner, scanStart{})
+		assert.NoError(t, err)
+		file.Close()
+
+		file, scanner, err = watcher.initializeScanner()
+		assert.NoError(t, err)
+		wantLines, wantCounts, err := collectMatchingLinesReference(scanner, q.match, q.ignore)
+		assert.NoError(t, err)
+		file.Close()
+
+		assert.Equal(t, wantCounts, counts, q.match)
+		assert.Equal(t, wantLines, lines, q.match)
+	}
+}
+
+func benchmarkScan(b *testing.B, matchPattern string, gzipped bool) {
+	logFile := writeScanFixture(b, b.TempDir(), 200000, gzipped)
+	stat, err := os.Stat(logFile)
+	assert.NoError(b, err)
+	watcher, err := NewWatcher(logFile, matchPattern, "", false, "", "", "", "", "")
+	assert.NoError(b, err)
+
+	b.SetBytes(stat.Size())
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		file, scanner, err := watcher.initializeScanner()
+		if err != nil {
+			b.Fatal(err)
+		}
+		// a search keeps its first page and counts the other matches
+		window := newPageWindow(1, 100, false, 0, 0, 0, nil)
+		if _, err := watcher.collectLinesInto(context.Background(), scanner, scanStart{}, window); err != nil {
+			b.Fatal(err)
+		}
+		file.Close()
+	}
+}
+
+func BenchmarkScanPlain(b *testing.B) {
+	benchmarkScan(b, "", false)
+}
+
+func BenchmarkScanGzip(b *testing.B) {
+	benchmarkScan(b, "", true)
+}
+
+func BenchmarkSearchLiteral(b *testing.B) {
+	b.Run("case-sensitive", func(b *testing.B) { benchmarkScan(b, "ERROR", false) })
+	b.Run("case-insensitive", func(b *testing.B) { benchmarkScan(b, "(?i)error", false) })
+}
+
+func BenchmarkSearchRegex(b *testing.B) {
+	benchmarkScan(b, `request_id=\d+7 `, false)
+}
+
+func TestWatcher_ScanContext(t *testing.T) {
+	logFile := filepath.Join(t.TempDir(), "app.log")
+	assert.NoError(t, os.WriteFile(logFile, []byte(strings.Repeat("INFO line\n", 3*collectCheckLines)), 0600))
+	watcher, err := NewWatcher(logFile, "INFO", "", false, "", "", "", "", "")
+	assert.NoError(t, err)
+
+	result, err := watcher.ScanContext(context.Background(), 1, 10, false)
+	assert.NoError(t, err)
+	assert.Equal(t, 3*collectCheckLines, result.Total)
+
+	// a search past its deadline stops
+	ctx, cancel := context.WithTimeout(context.Background(), time.Nanosecond)
+	defer cancel()
+	<-ctx.Done()
+	_, err = watcher.ScanContext(ctx, 1, 10, false)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+}
@@ -0,0 +1,201 @@
+package pkg
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"unicode/utf8"
+)
+
+// ByteWindowResult is a decoded chunk of a file served by byte offsets rather than lines
+type ByteWindowResult struct {
+	FilePath   string  `json:"file_path"`
+	Host       string  `json:"host"`
+	Type       string  `json:"type"`
+	Offset     int64   `json:"offset"`
+	End        int64   `json:"end"`
+	FileSize   int64   `json:"file_size"`
+	Content    string  `json:"content"`
+	NextOffset int64   `json:"next_offset"`
+	PrevOffset int64   `json:"prev_offset"`
+	EOF        bool    `json:"eof"`
+	Matches    []int64 `json:"matches"`
+}
+
+// ReadByteWindow reads length bytes starting at offset and returns a chunk that never splits a rune.
+// Matches of query (if any) are reported as absolute byte offsets in the file.
+func ReadByteWindow(filePath string, offset int64, length int64, query string, isRemote bool, sshConfig *SSHConfig) (*ByteWindowResult, error) {
+	if offset < 0 || length <= 0 {
+		return nil, fmt.Errorf("offset must be >= 0 and length > 0")
+	}
+
+	var re *regexp.Regexp
+	if query != "" {
+		var err error
+		re, err = regexp.Compile(query)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// read a few extra bytes on both ends so rune boundaries can be fixed up
+	readFrom := offset - utf8.UTFMax + 1
+	if readFrom < 0 {
+		readFrom = 0
+	}
+	readLen := length + (offset - readFrom) + utf8.UTFMax - 1
+
+	var chunk []byte
+	var fileSize int64
+	var err error
+	if isRemote {
+		chunk, fileSize, err = sshReadRange(filePath, readFrom, readLen, sshConfig)
+	} else {
+		chunk, fileSize, err = localReadRange(filePath, readFrom, readLen)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	start, end := runeSafeBounds(chunk, offset-readFrom, length)
+	content := chunk[start:end]
+
+	result := &ByteWindowResult{
+		FilePath: filePath,
+		Offset:   readFrom + int64(start),
+		End:      readFrom + int64(end),
+		FileSize: fileSize,
+		Content:  string(content),
+		Matches:  []int64{},
+	}
+	result.EOF = int64(len(chunk)) < readLen && end == len(chunk)
+	result.NextOffset = result.End
+	if result.EOF {
+		result.NextOffset = -1
+	}
+	result.PrevOffset = result.Offset - length
+	if result.PrevOffset < 0 {
+		result.PrevOffset = 0
+	}
+	if result.Offset == 0 {
+		result.PrevOffset = -1
+	}
+
+	if re != nil {
+		for _, loc := range re.FindAllIndex(content, -1) {
+			result.Matches = append(result.Matches, result.Offset+int64(loc[0]))
+		}
+	}
+	return result, nil
+}
+
+// runeSafeBounds returns [start, end) within chunk for the requested window so that
+// the window begins and ends on rune boundaries
+func runeSafeBounds(chunk []byte, want int64, length int64) (int, int) {
+	start := int(want)
+	if start > len(chunk) {
+		start = len(chunk)
+	}
+	// skip continuation bytes at the beginning, they belong to the previous rune
+	for i := 0; i < utf8.UTFMax-1 && start < len(chunk) && !utf8.RuneStart(chunk[start]); i++ {
+		start++
+	}
+
+	end := int(want + length)
+	if end > len(chunk) {
+		end = len(chunk)
+	}
+	if end < start {
+		end = start
+	}
+	// extend to complete a rune cut in half, or pull back before it when it is incomplete
+	if end < len(chunk) && !utf8.RuneStart(chunk[end]) {
+		runeStart := end
+		for runeStart > start && !utf8.RuneStart(chunk[runeStart]) {
+			runeStart--
+		}
+		if utf8.FullRune(chunk[runeStart:]) {
+			_, size := utf8.DecodeRune(chunk[runeStart:])
+			end = runeStart + size
+		} else {
+			end = runeStart
+		}
+	}
+	return start, end
+}
+
+func localReadRange(filePath string, offset int64, length int64) ([]byte, int64, error) {
+	file, err := openFile(OSFileOpener{}, filePath)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer file.Close()
+
+	fileInfo, err := file.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+
+	buffer := make([]byte, compressionSniffBytes)
+	n, err := file.Read(buffer)
+	if err != nil && !errors.Is(err, io.EOF) {
+		return nil, 0, err
+	}
+
+	var reader io.Reader
+	if IsCompressed(buffer[:n]) {
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, 0, err
+		}
+		decompressed, _, err := NewDecompressingReader(file)
+		if err != nil {
+			return nil, 0, err
+		}
+		// compressed files can not seek, discard up to the offset
+		if _, err := io.CopyN(io.Discard, decompressed, offset); err != nil && !errors.Is(err, io.EOF) {
+			return nil, 0, err
+		}
+		reader = decompressed
+	} else {
+		if _, err := file.Seek(offset, io.SeekStart); err != nil {
+			return nil, 0, err
+		}
+		reader = file
+	}
+
+	chunk, err := io.ReadAll(io.LimitReader(bufio.NewReader(reader), length))
+	if err != nil {
+		return nil, 0, err
+	}
+	return chunk, fileInfo.Size(), nil
+}
+
+func sshReadRange(filePath string, offset int64, length int64, config *SSHConfig) ([]byte, int64, error) {
+	session, err := GlobalSSHPool.Session(context.Background(), config)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer session.Close()
+
+	var stdout bytes.Buffer
+	session.Stdout = &stdout
+	cmd := fmt.Sprintf("stat -c %%s %s && tail -c +%d %s | head -c %d", filePath, offset+1, filePath, length)
+	if err := session.Run(cmd); err != nil {
+		if err.Error() != ErrorMsgSessionAlreadyStarted {
+			return nil, 0, err
+		}
+	}
+
+	out := stdout.Bytes()
+	idx := bytes.IndexByte(out, '\n')
+	if idx < 0 {
+		return nil, 0, fmt.Errorf("unexpected output reading %s", filePath)
+	}
+	var fileSize int64
+	fmt.Sscanf(string(out[:idx]), "%d", &fileSize) //nolint: errcheck
+	return out[idx+1:], fileSize, nil
+}
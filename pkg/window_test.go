@@ -0,0 +1,64 @@
+package pkg
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReadByteWindow(t *testing.T) {
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "minified.json")
+	// "é" and "日" are multi-byte, so most windows below start or end inside a rune
+	content := `{"a":"é","b":"日本","c":"ERROR"}`
+	err := os.WriteFile(logFile, []byte(content), 0600)
+	assert.NoError(t, err)
+
+	tests := []struct {
+		name   string
+		offset int64
+		length int64
+	}{
+		{"from start", 0, 8},
+		{"ends inside rune", 0, 7},
+		{"starts inside rune", 7, 10},
+		{"starts inside three byte rune", 16, 4},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result, err := ReadByteWindow(logFile, tt.offset, tt.length, "", false, nil)
+			assert.NoError(t, err)
+			assert.True(t, utf8.ValidString(result.Content))
+			assert.Equal(t, content[result.Offset:result.End], result.Content)
+			assert.Equal(t, int64(len(content)), result.FileSize)
+		})
+	}
+
+	result, err := ReadByteWindow(logFile, 100, 10, "", false, nil)
+	assert.NoError(t, err)
+	assert.Empty(t, result.Content)
+	assert.True(t, result.EOF)
+	assert.Equal(t, int64(-1), result.NextOffset)
+
+	// paging with next offsets reassembles the whole file
+	var assembled string
+	offset := int64(0)
+	for offset >= 0 {
+		result, err := ReadByteWindow(logFile, offset, 5, "", false, nil)
+		assert.NoError(t, err)
+		assembled += result.Content
+		offset = result.NextOffset
+	}
+	assert.Equal(t, content, assembled)
+
+	result, err = ReadByteWindow(logFile, 0, 100, "ERROR", false, nil)
+	assert.NoError(t, err)
+	assert.True(t, result.EOF)
+	assert.Equal(t, []int64{int64(len(`{"a":"é","b":"日本","c":"`))}, result.Matches)
+
+	_, err = ReadByteWindow(logFile, -1, 10, "", false, nil)
+	assert.Error(t, err)
+}